@@ -51,10 +51,11 @@ type Agent struct {
 
 // Config is a structure that is used to pass in all necessary information to instantiate a new Agent
 type Config struct {
-	Sleep    string // Sleep is the amount of time the Agent will wait between sending messages to the server
-	Skew     string // Skew is the variance or jitter, used to vary the sleep time so that it isn't constant
-	KillDate string // KillDate is the date as a Unix timestamp, that agent will quit running
-	MaxRetry string // MaxRetry is the maximum amount of time an agent will fail to check in before it quits running
+	ID       uuid.UUID // ID is the Agent's identifier; a new one is generated when it is nil
+	Sleep    string    // Sleep is the amount of time the Agent will wait between sending messages to the server
+	Skew     string    // Skew is the variance or jitter, used to vary the sleep time so that it isn't constant
+	KillDate string    // KillDate is the date as a Unix timestamp, that agent will quit running
+	MaxRetry string    // MaxRetry is the maximum amount of time an agent will fail to check in before it quits running
 }
 
 // New creates a new Agent struct from the provided Config structure and returns the Agent object
@@ -62,7 +63,10 @@ func New(config Config) (agent Agent, err error) {
 	cli.Message(cli.DEBUG, "Entering agent.New() function")
 
 	agent = Agent{
-		id: uuid.New(),
+		id: config.ID,
+	}
+	if agent.id == uuid.Nil {
+		agent.id = uuid.New()
 	}
 
 	agent.host = Host{
@@ -201,6 +205,11 @@ func (a *Agent) Failed() int {
 	return a.comms.Failed
 }
 
+// Hibernate returns the time the Agent resumes checking in after hibernating; zero when the Agent isn't hibernating
+func (a *Agent) Hibernate() time.Time {
+	return a.comms.Hibernate
+}
+
 // Host returns the embedded Host structure that contains information about the Host where the Agent is running such as
 // the hostname and operating system
 func (a *Agent) Host() Host {
@@ -246,6 +255,12 @@ func (a *Agent) SetFailedCheckIn(failed int) {
 	a.comms.Failed = failed
 }
 
+// SetHibernate updates the time the Agent resumes checking in after hibernating; a zero time ends hibernation
+// The updated Agent object must be stored or updated in the repository separately for the change to be permanent
+func (a *Agent) SetHibernate(until time.Time) {
+	a.comms.Hibernate = until
+}
+
 // SetInitialCheckIn updates the time stamp that the Agent first successfully connected to the Merlin server
 // The updated Agent object must be stored or updated in the repository separately for the change to be permanent
 func (a *Agent) SetInitialCheckIn(checkin time.Time) {
@@ -287,6 +302,11 @@ func (a *Agent) Skew() int64 {
 	return a.comms.Skew
 }
 
+// StatusCheckIn returns the last time the Agent successfully communicated with the Merlin server
+func (a *Agent) StatusCheckIn() time.Time {
+	return a.checkin
+}
+
 // Wait returns the amount of time the Agent will wait or sleep between check ins
 func (a *Agent) Wait() time.Duration {
 	return a.comms.Wait
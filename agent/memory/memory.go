@@ -85,6 +85,14 @@ func (r *Repository) SetInitialCheckIn(checkin time.Time) {
 	r.agent.SetInitialCheckIn(checkin)
 }
 
+// SetHibernate updates the time the Agent resumes checking in after hibernating and stores the updated Agent in the
+// repository
+func (r *Repository) SetHibernate(until time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	r.agent.SetHibernate(until)
+}
+
 // SetKillDate sets the date, as an epoch timestamp, of when the Agent will quit running and stores the updated Agent
 // in the repository
 func (r *Repository) SetKillDate(epochDate int64) {
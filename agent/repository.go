@@ -34,6 +34,9 @@ type Repository interface {
 	// SetFailedCheckIn updates the number of times the Agent has actually failed to check in and stores the updated Agent
 	// in the repository
 	SetFailedCheckIn(failed int)
+	// SetHibernate updates the time the Agent resumes checking in after hibernating and stores the updated Agent in the
+	// repository
+	SetHibernate(until time.Time)
 	// SetInitialCheckIn updates the time stamp that the Agent first successfully connected to the Merlin server and stores
 	// the updated Agent in the repository
 	SetInitialCheckIn(checkin time.Time)
@@ -30,14 +30,15 @@ type Build struct {
 
 // Comms is a structure that holds information about an Agent's communication profile
 type Comms struct {
-	Failed  int           // The number of times the agent has failed to check in
-	JA3     string        // The ja3 signature applied to the agent's TLS client
-	Kill    int64         // The epoch date and time that the agent will kill itself and quit running
-	Padding int           // The maximum amount of padding that will be appended to the Base message
-	Proto   string        // The protocol the agent is using to communicate with the server
-	Retry   int           // The maximum amount of times an agent will retry to check in before exiting
-	Skew    int64         // The amount of skew, or jitter, used to calculate the check in time
-	Wait    time.Duration // The amount of time the agent waits before trying to check in
+	Failed    int           // The number of times the agent has failed to check in
+	Hibernate time.Time     // The time the agent resumes checking in after hibernating; zero when the agent isn't hibernating
+	JA3       string        // The ja3 signature applied to the agent's TLS client
+	Kill      int64         // The epoch date and time that the agent will kill itself and quit running
+	Padding   int           // The maximum amount of padding that will be appended to the Base message
+	Proto     string        // The protocol the agent is using to communicate with the server
+	Retry     int           // The maximum amount of times an agent will retry to check in before exiting
+	Skew      int64         // The amount of skew, or jitter, used to calculate the check in time
+	Wait      time.Duration // The amount of time the agent waits before trying to check in
 }
 
 // Host is a structure that holds information about the Host operating system an Agent is running on
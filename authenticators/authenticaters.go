@@ -23,6 +23,15 @@ along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
 package authenticators
 
 import (
+	// Standard
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
 	// Merlin
 	"github.com/Ne0nd0g/merlin-message"
 )
@@ -38,3 +47,46 @@ type Authenticator interface {
 	// String returns a string representation of the Authenticator's type
 	String() string
 }
+
+// Factory returns a new Authenticator for the Agent's ID
+type Factory func(id uuid.UUID) Authenticator
+
+// factories is a map of authenticator names to the factories that create them
+var factories = make(map[string]Factory)
+
+// factoriesLock protects the factories map from concurrent access
+var factoriesLock sync.RWMutex
+
+// Register makes an authenticator available to every client by name, case-insensitive. Authenticator packages register
+// themselves when they are imported; registering a name again replaces its factory
+func Register(name string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	factories[strings.ToLower(strings.TrimSpace(name))] = factory
+}
+
+// New returns a new Authenticator for the Agent from the factory registered with the name
+func New(id uuid.UUID, name string) (Authenticator, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return nil, fmt.Errorf("authenticators.New(): an authenticator must be provided (e.g., 'opaque')")
+	}
+	factoriesLock.RLock()
+	factory, ok := factories[name]
+	factoriesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("authenticators.New(): unhandled authenticator: %s", name)
+	}
+	return factory(id), nil
+}
+
+// Registered returns the sorted names of all the registered authenticators
+func Registered() (names []string) {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
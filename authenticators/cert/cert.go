@@ -0,0 +1,335 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package cert is an authenticator where the Agent proves its identity by signing a server challenge with an embedded
+// private key, and the server validates the Agent's certificate against the certificate authority that issued it, so
+// that recovering the PSK from one Agent binary isn't enough to impersonate an Agent. The session secret is derived
+// from an ephemeral X25519 key exchange bound to the signed transcript.
+//
+// The messages are carried as OPAQUE messages with Types outside the range defined by the merlin-message library:
+//
+//	Agent  -> Server: HELLO with the Agent's certificate, an ephemeral X25519 public key, and a nonce
+//	Server -> Agent:  CHALLENGE with a random challenge and the server's ephemeral X25519 public key
+//	Agent  -> Server: PROOF with the signature of the transcript
+//	Server -> Agent:  COMPLETE, with an empty payload, when the certificate and signature are valid
+package cert
+
+import (
+	// Standard
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// X Packages
+	"golang.org/x/crypto/hkdf"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+	"github.com/Ne0nd0g/merlin-message/opaque"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
+)
+
+// Certificate authentication message Types, carried in OPAQUE messages
+const (
+	// HELLO starts certificate authentication with a Hello payload
+	HELLO opaque.Type = 110
+	// CHALLENGE is the server's Challenge payload
+	CHALLENGE opaque.Type = 111
+	// PROOF is the Agent's Proof payload
+	PROOF opaque.Type = 112
+	// COMPLETE is returned by the server, with an empty payload, when the Agent is authenticated
+	COMPLETE opaque.Type = 113
+)
+
+// Hello is the payload of a HELLO message
+type Hello struct {
+	Certificate string `json:"certificate"` // Certificate is the base64 encoded DER certificate issued to the Agent
+	Key         string `json:"key"`         // Key is the Agent's base64 encoded ephemeral X25519 public key
+	Nonce       string `json:"nonce"`       // Nonce is 32 random base64 encoded bytes
+}
+
+// Challenge is the payload of a CHALLENGE message
+type Challenge struct {
+	Challenge string `json:"challenge"` // Challenge is 32 random base64 encoded bytes the Agent signs
+	Key       string `json:"key"`       // Key is the server's base64 encoded ephemeral X25519 public key
+}
+
+// Proof is the payload of a PROOF message
+type Proof struct {
+	Signature string `json:"signature"` // Signature is the base64 encoded signature of the transcript
+}
+
+// credentials are the embedded certificate and the private key it was issued for
+var credentials = struct {
+	certificate *x509.Certificate
+	key         crypto.Signer
+	sync.RWMutex
+}{}
+
+// SetCredentials parses and sets the Agent's certificate and private key. Each is PEM or base64 encoded DER; the key is
+// PKCS #8, PKCS #1, or SEC 1 and must be ECDSA, Ed25519, or RSA. Empty strings remove the credentials
+func SetCredentials(certificate, key string) error {
+	if strings.TrimSpace(certificate) == "" && strings.TrimSpace(key) == "" {
+		credentials.Lock()
+		credentials.certificate, credentials.key = nil, nil
+		credentials.Unlock()
+		return nil
+	}
+	der, err := decode(certificate)
+	if err != nil {
+		return fmt.Errorf("authenticators/cert.SetCredentials(): there was an error decoding the certificate: %s", err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("authenticators/cert.SetCredentials(): there was an error parsing the certificate: %s", err)
+	}
+	der, err = decode(key)
+	if err != nil {
+		return fmt.Errorf("authenticators/cert.SetCredentials(): there was an error decoding the private key: %s", err)
+	}
+	var private any
+	private, err = x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		if private, err = x509.ParseECPrivateKey(der); err != nil {
+			private, err = x509.ParsePKCS1PrivateKey(der)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("authenticators/cert.SetCredentials(): there was an error parsing the private key: %s", err)
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("authenticators/cert.SetCredentials(): unsupported private key type %T", private)
+	}
+	switch signer.(type) {
+	case *ecdsa.PrivateKey, ed25519.PrivateKey, *rsa.PrivateKey:
+	default:
+		return fmt.Errorf("authenticators/cert.SetCredentials(): unsupported private key type %T", private)
+	}
+	public, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return fmt.Errorf("authenticators/cert.SetCredentials(): %s", err)
+	}
+	if string(public) != string(c.RawSubjectPublicKeyInfo) {
+		return fmt.Errorf("authenticators/cert.SetCredentials(): the private key does not match the certificate's public key")
+	}
+	credentials.Lock()
+	credentials.certificate, credentials.key = c, signer
+	credentials.Unlock()
+	return nil
+}
+
+// decode returns the DER bytes of a PEM or base64 encoded value
+func decode(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(value))
+		if block == nil {
+			return nil, fmt.Errorf("invalid PEM data")
+		}
+		return block.Bytes, nil
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
+
+// Authenticator is a structure used for certificate authentication
+type Authenticator struct {
+	agent         uuid.UUID         // agent is the Agent's ID
+	ephemeral     *ecdh.PrivateKey  // ephemeral is the Agent's X25519 key for the current authentication
+	nonce         []byte            // nonce is the Agent's random nonce for the current authentication
+	certificate   *x509.Certificate // certificate is the certificate sent in the HELLO message
+	secret        []byte            // secret is the session key derived after the PROOF was sent
+	authenticated bool              // authenticated is true after the server returned COMPLETE
+}
+
+func init() {
+	authenticators.Register("cert", func(id uuid.UUID) authenticators.Authenticator { return New(id) })
+}
+
+// New returns a certificate Authenticator structure used for Agent authentication
+func New(id uuid.UUID) *Authenticator {
+	return &Authenticator{agent: id}
+}
+
+// Authenticate sends the HELLO, answers the server's CHALLENGE with a PROOF, and completes authentication when the
+// server returns COMPLETE. Any other message starts authentication over
+func (a *Authenticator) Authenticate(in messages.Base) (messages.Base, bool, error) {
+	var t opaque.Type = -1
+	var payload []byte
+	if o, ok := in.Payload.(opaque.Opaque); ok && in.Type == messages.OPAQUE {
+		t, payload = o.Type, o.Payload
+	}
+
+	switch {
+	case t == CHALLENGE && a.ephemeral != nil && !a.authenticated:
+		proof, err := a.prove(payload)
+		if err != nil {
+			return messages.Base{}, false, err
+		}
+		return messages.Base{ID: a.agent, Type: messages.OPAQUE, Payload: opaque.Opaque{Type: PROOF, Payload: proof}}, false, nil
+	case t == COMPLETE && a.secret != nil && !a.authenticated:
+		a.authenticated = true
+		a.ephemeral = nil
+		return messages.Base{ID: a.agent, Type: messages.CHECKIN}, true, nil
+	}
+
+	hello, err := a.hello()
+	if err != nil {
+		return messages.Base{}, false, err
+	}
+	return messages.Base{ID: a.agent, Type: messages.OPAQUE, Payload: opaque.Opaque{Type: HELLO, Payload: hello}}, false, nil
+}
+
+// hello starts a new authentication with a new ephemeral key and nonce and returns the HELLO payload
+func (a *Authenticator) hello() ([]byte, error) {
+	credentials.RLock()
+	c := credentials.certificate
+	credentials.RUnlock()
+	if c == nil {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): the Agent's certificate and private key are not configured")
+	}
+	var err error
+	a.authenticated = false
+	a.secret = nil
+	a.certificate = c
+	a.ephemeral, err = ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): there was an error generating an ephemeral key: %s", err)
+	}
+	a.nonce = make([]byte, 32)
+	if _, err = rand.Read(a.nonce); err != nil {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): there was an error generating a nonce: %s", err)
+	}
+	hello, err := json.Marshal(Hello{
+		Certificate: base64.StdEncoding.EncodeToString(c.Raw),
+		Key:         base64.StdEncoding.EncodeToString(a.ephemeral.PublicKey().Bytes()),
+		Nonce:       base64.StdEncoding.EncodeToString(a.nonce),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): there was an error JSON encoding the hello: %s", err)
+	}
+	return hello, nil
+}
+
+// prove signs the transcript for the server's challenge, derives the session secret, and returns the PROOF payload
+func (a *Authenticator) prove(payload []byte) ([]byte, error) {
+	var challenge Challenge
+	if err := json.Unmarshal(payload, &challenge); err != nil {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): there was an error JSON decoding the challenge: %s", err)
+	}
+	random, err := base64.StdEncoding.DecodeString(challenge.Challenge)
+	if err != nil || len(random) < 16 {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): the server's challenge is invalid")
+	}
+	key, err := base64.StdEncoding.DecodeString(challenge.Key)
+	if err != nil {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): there was an error decoding the server's key: %s", err)
+	}
+	server, err := ecdh.X25519().NewPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): the server's key is invalid: %s", err)
+	}
+	shared, err := a.ephemeral.ECDH(server)
+	if err != nil {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): %s", err)
+	}
+
+	transcript := Transcript(a.agent, a.certificate.Raw, a.ephemeral.PublicKey().Bytes(), a.nonce, key, random)
+	signature, err := sign(transcript)
+	if err != nil {
+		return nil, err
+	}
+	a.secret = make([]byte, 32)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared, transcript[:], []byte("merlin cert session")), a.secret); err != nil {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): there was an error deriving the session key: %s", err)
+	}
+	proof, err := json.Marshal(Proof{Signature: base64.StdEncoding.EncodeToString(signature)})
+	if err != nil {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): there was an error JSON encoding the proof: %s", err)
+	}
+	return proof, nil
+}
+
+// Transcript returns the SHA256 hash the Agent signs and the session key is salted with: the hash of
+// merlin-cert:<agent id> followed by the certificate, the Agent's key, the nonce, the server's key, and the challenge,
+// each preceded by its 4-byte big endian length
+func Transcript(agent uuid.UUID, parts ...[]byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte("merlin-cert:" + agent.String()))
+	for _, part := range parts {
+		h.Write([]byte{byte(len(part) >> 24), byte(len(part) >> 16), byte(len(part) >> 8), byte(len(part))})
+		h.Write(part)
+	}
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// sign signs the transcript hash with the Agent's private key: ECDSA ASN.1, Ed25519 over the hash, or RSA-PSS SHA256
+func sign(transcript [32]byte) ([]byte, error) {
+	credentials.RLock()
+	key := credentials.key
+	credentials.RUnlock()
+	if key == nil {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): the Agent's private key is not configured")
+	}
+	var opts crypto.SignerOpts = crypto.SHA256
+	switch key.(type) {
+	case ed25519.PrivateKey:
+		opts = crypto.Hash(0)
+	case *rsa.PrivateKey:
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	}
+	signature, err := key.Sign(rand.Reader, transcript[:], opts)
+	if err != nil {
+		return nil, fmt.Errorf("authenticators/cert.Authenticate(): there was an error signing the transcript: %s", err)
+	}
+	return signature, nil
+}
+
+// Secret returns the session key derived from the ephemeral key exchange
+func (a *Authenticator) Secret() ([]byte, error) {
+	if !a.authenticated {
+		return nil, fmt.Errorf("authenticators/cert.Secret(): the Agent is not authenticated")
+	}
+	return a.secret, nil
+}
+
+// String returns the name of the Authenticator type
+func (a *Authenticator) String() string {
+	return "cert"
+}
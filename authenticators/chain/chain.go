@@ -0,0 +1,167 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package chain is an authenticator that runs an ordered list of authenticators where each one must succeed before
+// the Agent is authenticated, so an Agent forged from a leaked PSK alone can't authenticate
+package chain
+
+import (
+	// Standard
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/authenticators/cert"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/authenticators/kerberos"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/authenticators/noise"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/authenticators/none"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/authenticators/opaque"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/authenticators/totp"
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// Authenticator is a structure used to chain multiple authenticators together
+type Authenticator struct {
+	agent         uuid.UUID                      // agent is the Agent's ID
+	links         []authenticators.Authenticator // links are the authenticators in the order they must succeed
+	current       int                            // current is the index of the link that is authenticating
+	advance       bool                           // advance is true when the current link finished and the next link starts on the next message
+	authenticated bool                           // authenticated is true when every link in the chain succeeded
+	start         messages.Base                  // start is the message that started the chain and is given to every link to start it
+}
+
+// New returns a chain Authenticator from a comma separated, ordered list of authenticator names (e.g., none,opaque)
+func New(id uuid.UUID, packages string) (*Authenticator, error) {
+	a := &Authenticator{agent: id}
+	for _, name := range strings.Split(packages, ",") {
+		link, err := authenticators.New(id, name)
+		if err != nil {
+			return nil, fmt.Errorf("authenticators/chain.New(): %s", err)
+		}
+		a.links = append(a.links, link)
+	}
+	if len(a.links) < 2 {
+		return nil, fmt.Errorf("authenticators/chain.New(): an authenticator chain requires at least 2 authenticators but received %d", len(a.links))
+	}
+	return a, nil
+}
+
+// Select returns the registered authenticator for a single name (e.g., opaque) or, for a comma separated list of names,
+// a chain Authenticator that requires every one of them to succeed (e.g., none,opaque). Importing this package
+// registers every built-in authenticator
+func Select(id uuid.UUID, packages string) (authenticators.Authenticator, error) {
+	if !strings.Contains(packages, ",") {
+		return authenticators.New(id, packages)
+	}
+	return New(id, packages)
+}
+
+// Authenticate gives the message to the current link in the chain. When a link, other than the last, succeeds its final
+// message is returned to be sent to the server and the next link is started when the server's response comes back.
+// The Agent is only authenticated after the last link succeeds.
+// Calling Authenticate after the chain completed, or with an empty message, starts the whole chain over
+func (a *Authenticator) Authenticate(in messages.Base) (out messages.Base, authenticated bool, err error) {
+	if a.authenticated || in.Type == 0 {
+		a.current = 0
+		a.advance = false
+		a.authenticated = false
+		a.start = in
+	}
+
+	for {
+		if a.advance {
+			// The server's response to the previous link's final message is not needed by the next link
+			cli.Message(cli.DEBUG, fmt.Sprintf("authenticators/chain.Authenticate(): discarding %s message after the %s authenticator completed", in.Type, a.links[a.current]))
+			a.advance = false
+			a.current++
+			in = a.start
+			cli.Message(cli.NOTE, fmt.Sprintf("Starting %s authentication, %d of %d in the authenticator chain", a.links[a.current], a.current+1, len(a.links)))
+		}
+
+		out, authenticated, err = a.links[a.current].Authenticate(in)
+		if err != nil {
+			err = fmt.Errorf("authenticators/chain.Authenticate(): the %s authenticator failed: %s", a.links[a.current], err)
+			return
+		}
+		if !authenticated {
+			return
+		}
+
+		// The last link succeeded, so the whole chain did
+		if a.current == len(a.links)-1 {
+			a.authenticated = true
+			return
+		}
+
+		a.advance = true
+		// A CHECKIN message means the link has nothing to send to the server, start the next link now
+		if out.Type == messages.CHECKIN && out.Payload == nil {
+			continue
+		}
+		return out, false, nil
+	}
+}
+
+// Secret returns the secret established by the chain. When only one link established a secret, it is returned as is.
+// When more than one link established a secret, the SHA256 hash of each secret, in chain order, is returned so
+// that every link contributes to the key used to encrypt messages
+func (a *Authenticator) Secret() ([]byte, error) {
+	if !a.authenticated {
+		return nil, fmt.Errorf("authenticators/chain.Secret(): the Agent has not completed every authenticator in the chain")
+	}
+	var secrets [][]byte
+	for _, link := range a.links {
+		key, err := link.Secret()
+		if err != nil {
+			return nil, fmt.Errorf("authenticators/chain.Secret(): there was an error getting the %s authenticator's secret: %s", link, err)
+		}
+		if len(key) > 0 {
+			secrets = append(secrets, key)
+		}
+	}
+	switch len(secrets) {
+	case 0:
+		return []byte{}, nil
+	case 1:
+		return secrets[0], nil
+	}
+	h := sha256.New()
+	for _, key := range secrets {
+		h.Write(key)
+	}
+	return h.Sum(nil), nil
+}
+
+// String returns the name of the Authenticator type with each link in the chain
+func (a *Authenticator) String() string {
+	var names []string
+	for _, link := range a.links {
+		names = append(names, link.String())
+	}
+	return fmt.Sprintf("chain(%s)", strings.Join(names, " -> "))
+}
@@ -0,0 +1,190 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package kerberos is an authenticator that uses the domain Kerberos ticket of the user the Agent is running as, through
+// the Windows Security Support Provider Interface (SSPI), to authenticate to an internal listener. On-premises peer-to-peer
+// links authenticate with the environment's own trust fabric instead of key material embedded in the Agent, and the
+// session secret is derived from the Kerberos session key.
+//
+// The listener's Service Principal Name (SPN) is set at build time (e.g., HTTP/listener.corp.local). Mutual
+// authentication is required so the Agent also verifies the listener holds the SPN's key. The messages are carried as
+// OPAQUE messages with Types outside the range defined by the merlin-message library:
+//
+//	Agent  -> Server: TOKEN with the Kerberos AP-REQ
+//	Server -> Agent:  CONTINUE with the AP-REP
+//	Agent  -> Server: TOKEN, only when the security package produced another token
+package kerberos
+
+import (
+	// Standard
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// X Packages
+	"golang.org/x/crypto/hkdf"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+	"github.com/Ne0nd0g/merlin-message/opaque"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
+)
+
+// Kerberos authentication message Types, carried in OPAQUE messages
+const (
+	// TOKEN is a security token the Agent's security package produced
+	TOKEN opaque.Type = 140
+	// CONTINUE is a security token the server's security package produced
+	CONTINUE opaque.Type = 141
+)
+
+// settings holds the Service Principal Name of the listener the Agent requests a service ticket for
+var settings = struct {
+	spn string
+	sync.RWMutex
+}{}
+
+// SetSPN sets the Service Principal Name of the listener (e.g., HTTP/listener.corp.local); an empty string removes it
+func SetSPN(spn string) error {
+	spn = strings.TrimSpace(spn)
+	if spn != "" && !strings.Contains(spn, "/") {
+		return fmt.Errorf("authenticators/kerberos.SetSPN(): the SPN %s is not in the service/host format", spn)
+	}
+	settings.Lock()
+	settings.spn = spn
+	settings.Unlock()
+	return nil
+}
+
+// SPN returns the Service Principal Name of the listener
+func SPN() string {
+	settings.RLock()
+	defer settings.RUnlock()
+	return settings.spn
+}
+
+// Authenticator is a structure used for Kerberos authentication
+type Authenticator struct {
+	agent         uuid.UUID    // agent is the Agent's ID
+	context       *sspiContext // context is the client security context being established
+	secret        []byte       // secret is the session key derived from the Kerberos session key
+	authenticated bool         // authenticated is true after the security context was established
+	final         bool         // final is true when the security package's last token was sent to the server
+}
+
+func init() {
+	authenticators.Register("kerberos", func(id uuid.UUID) authenticators.Authenticator { return New(id) })
+}
+
+// New returns a Kerberos Authenticator structure used for Agent authentication
+func New(id uuid.UUID) *Authenticator {
+	return &Authenticator{agent: id}
+}
+
+// Authenticate sends the Agent's security tokens and processes the server's until the security context is established.
+// Any message other than CONTINUE starts authentication over with a new context
+func (a *Authenticator) Authenticate(in messages.Base) (messages.Base, bool, error) {
+	// The security package's final token was sent and the server's reply completes authentication
+	if a.final {
+		a.final = false
+		return messages.Base{ID: a.agent, Type: messages.CHECKIN}, true, nil
+	}
+
+	var input []byte
+	if o, ok := in.Payload.(opaque.Opaque); ok && in.Type == messages.OPAQUE && o.Type == CONTINUE && a.context != nil {
+		input = o.Payload
+	} else {
+		a.release()
+		a.authenticated = false
+		a.secret = nil
+		spn := SPN()
+		if spn == "" {
+			return messages.Base{}, false, fmt.Errorf("authenticators/kerberos.Authenticate(): the listener's SPN is not configured")
+		}
+		var err error
+		a.context, err = newContext(spn)
+		if err != nil {
+			return messages.Base{}, false, fmt.Errorf("authenticators/kerberos.Authenticate(): %s", err)
+		}
+	}
+
+	output, done, err := a.context.step(input)
+	if err != nil {
+		a.release()
+		return messages.Base{}, false, fmt.Errorf("authenticators/kerberos.Authenticate(): %s", err)
+	}
+	if done {
+		err = a.derive()
+		if err != nil {
+			return messages.Base{}, false, err
+		}
+		if len(output) == 0 {
+			return messages.Base{ID: a.agent, Type: messages.CHECKIN}, true, nil
+		}
+		a.final = true
+	}
+	return messages.Base{ID: a.agent, Type: messages.OPAQUE, Payload: opaque.Opaque{Type: TOKEN, Payload: output}}, false, nil
+}
+
+// derive returns the session key: HKDF-SHA256 of the Kerberos session key, salted with the Agent's ID, and releases the
+// security context
+func (a *Authenticator) derive() error {
+	defer a.release()
+	key, err := a.context.sessionKey()
+	if err != nil {
+		return fmt.Errorf("authenticators/kerberos.Authenticate(): %s", err)
+	}
+	a.secret = make([]byte, 32)
+	agent := a.agent
+	_, err = io.ReadFull(hkdf.New(sha256.New, key, agent[:], []byte("merlin kerberos session")), a.secret)
+	if err != nil {
+		return fmt.Errorf("authenticators/kerberos.Authenticate(): there was an error deriving the session key: %s", err)
+	}
+	a.authenticated = true
+	return nil
+}
+
+// release releases the security context, if any
+func (a *Authenticator) release() {
+	if a.context != nil {
+		a.context.release()
+		a.context = nil
+	}
+}
+
+// Secret returns the session key derived from the Kerberos session key
+func (a *Authenticator) Secret() ([]byte, error) {
+	if !a.authenticated {
+		return nil, fmt.Errorf("authenticators/kerberos.Secret(): the Agent is not authenticated")
+	}
+	return a.secret, nil
+}
+
+// String returns the name of the Authenticator type
+func (a *Authenticator) String() string {
+	return "kerberos"
+}
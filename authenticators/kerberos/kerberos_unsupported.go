@@ -0,0 +1,50 @@
+//go:build !windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package kerberos
+
+import (
+	// Standard
+	"fmt"
+	"runtime"
+)
+
+// sspiContext is a client security context, which requires the Windows SSPI
+type sspiContext struct{}
+
+// newContext returns an error because SSPI Kerberos authentication is only supported on Windows
+func newContext(spn string) (*sspiContext, error) {
+	return nil, fmt.Errorf("kerberos authentication is not supported on %s", runtime.GOOS)
+}
+
+// step is not supported on this platform
+func (c *sspiContext) step(input []byte) ([]byte, bool, error) {
+	return nil, false, fmt.Errorf("kerberos authentication is not supported on %s", runtime.GOOS)
+}
+
+// sessionKey is not supported on this platform
+func (c *sspiContext) sessionKey() ([]byte, error) {
+	return nil, fmt.Errorf("kerberos authentication is not supported on %s", runtime.GOOS)
+}
+
+// release does nothing on this platform
+func (c *sspiContext) release() {}
@@ -0,0 +1,85 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package kerberos
+
+import (
+	// Standard
+	"fmt"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/secur32"
+)
+
+// pkg is the SSPI security package used; Kerberos is used instead of Negotiate so authentication never falls back to NTLM
+const pkg = "Kerberos"
+
+// sspiContext is a client security context established with the current user's Kerberos credentials
+type sspiContext struct {
+	spn        string            // spn is the Service Principal Name of the listener
+	credential secur32.SecHandle // credential is the handle to the current user's Kerberos credentials
+	context    secur32.SecHandle // context is the security context handle, valid after the first step
+	started    bool              // started is true after the first call to InitializeSecurityContext
+}
+
+// newContext acquires a handle to the current user's Kerberos credentials for a security context with the SPN
+func newContext(spn string) (*sspiContext, error) {
+	credential, err := secur32.AcquireCredentialsHandle(pkg, secur32.SECPKG_CRED_OUTBOUND)
+	if err != nil {
+		return nil, err
+	}
+	return &sspiContext{spn: spn, credential: credential}, nil
+}
+
+// step provides the server's token, nil on the first step, to the security package and returns the token to send to
+// the server and whether the security context is established
+func (c *sspiContext) step(input []byte) (output []byte, done bool, err error) {
+	flags := secur32.ISC_REQ_MUTUAL_AUTH | secur32.ISC_REQ_CONFIDENTIALITY | secur32.ISC_REQ_INTEGRITY
+	var current *secur32.SecHandle
+	if c.started {
+		current = &c.context
+	}
+	context, output, status, err := secur32.InitializeSecurityContext(&c.credential, current, c.spn, flags, input)
+	if err != nil {
+		return nil, false, err
+	}
+	c.context = context
+	c.started = true
+	return output, status == secur32.SEC_E_OK, nil
+}
+
+// sessionKey returns the session key of the established security context
+func (c *sspiContext) sessionKey() ([]byte, error) {
+	key, err := secur32.QuerySessionKey(&c.context)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error getting the Kerberos session key: %s", err)
+	}
+	return key, nil
+}
+
+// release deletes the security context and frees the credentials handle
+func (c *sspiContext) release() {
+	if c.started {
+		secur32.DeleteSecurityContext(&c.context)
+	}
+	secur32.FreeCredentialsHandle(&c.credential)
+}
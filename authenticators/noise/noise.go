@@ -0,0 +1,285 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package noise is an authenticator that establishes the session secret with the Noise_IK_25519_ChaChaPoly_SHA256
+// handshake from the Noise Protocol Framework. The Agent is configured with the server's static X25519 public key and
+// its own static X25519 private key, so the single round trip handshake authenticates both sides and, because each side
+// contributes an ephemeral key, the session secret has forward secrecy. The session secret is derived from the
+// handshake's transport keys and is used by the transformer chain like any other authenticator's secret.
+//
+// The handshake messages are carried as OPAQUE messages with Types outside the range defined by the merlin-message
+// library, and the prologue is merlin-noise:<agent id>, so a handshake can't be replayed for another Agent:
+//
+//	Agent  -> Server: INITIATE with the handshake's first message:  e, es, s, ss
+//	Server -> Agent:  RESPOND with the handshake's second message: e, ee, se
+package noise
+
+import (
+	// Standard
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// X Packages
+	"golang.org/x/crypto/hkdf"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+	"github.com/Ne0nd0g/merlin-message/opaque"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
+)
+
+// PROTOCOL is the Noise protocol name, which is also the initial handshake hash
+const PROTOCOL = "Noise_IK_25519_ChaChaPoly_SHA256"
+
+// Noise handshake message Types, carried in OPAQUE messages
+const (
+	// INITIATE is the Agent's first handshake message
+	INITIATE opaque.Type = 120
+	// RESPOND is the server's second handshake message, after which both sides have the transport keys
+	RESPOND opaque.Type = 121
+)
+
+// keys are the Agent's static key pair and the server's static public key
+var keys = struct {
+	static *ecdh.PrivateKey
+	server *ecdh.PublicKey
+	sync.RWMutex
+}{}
+
+// SetKeys parses and sets the Agent's static X25519 private key and the server's static X25519 public key, each 32
+// base64 or hex encoded bytes. Without a private key, one is generated when the Agent starts and the server can only
+// trust it on first use. An empty server key disables the authenticator
+func SetKeys(private, server string) (err error) {
+	var static *ecdh.PrivateKey
+	if strings.TrimSpace(private) == "" {
+		static, err = ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("authenticators/noise.SetKeys(): there was an error generating a static key: %s", err)
+		}
+	} else {
+		var raw []byte
+		raw, err = decode(private)
+		if err == nil {
+			static, err = ecdh.X25519().NewPrivateKey(raw)
+		}
+		if err != nil {
+			return fmt.Errorf("authenticators/noise.SetKeys(): there was an error parsing the Agent's static key: %s", err)
+		}
+	}
+	var public *ecdh.PublicKey
+	if strings.TrimSpace(server) != "" {
+		var raw []byte
+		raw, err = decode(server)
+		if err == nil {
+			public, err = ecdh.X25519().NewPublicKey(raw)
+		}
+		if err != nil {
+			return fmt.Errorf("authenticators/noise.SetKeys(): there was an error parsing the server's static key: %s", err)
+		}
+	}
+	keys.Lock()
+	keys.static, keys.server = static, public
+	keys.Unlock()
+	return nil
+}
+
+// PublicKey returns the Agent's base64 encoded static X25519 public key for the server to trust
+func PublicKey() string {
+	keys.RLock()
+	defer keys.RUnlock()
+	if keys.static == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(keys.static.PublicKey().Bytes())
+}
+
+// decode returns the 32 bytes of a base64 or hex encoded X25519 key
+func decode(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 64 {
+		if raw, err := hex.DecodeString(value); err == nil {
+			return raw, nil
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("the key is %d bytes but must be 32", len(raw))
+	}
+	return raw, nil
+}
+
+// Authenticator is a structure used for Noise authentication
+type Authenticator struct {
+	agent         uuid.UUID        // agent is the Agent's ID
+	state         *symmetricState  // state is the handshake's symmetric state for the current authentication
+	ephemeral     *ecdh.PrivateKey // ephemeral is the Agent's ephemeral key for the current authentication
+	secret        []byte           // secret is the session key derived from the transport keys
+	authenticated bool             // authenticated is true after the server's handshake message was read
+}
+
+func init() {
+	authenticators.Register("noise", func(id uuid.UUID) authenticators.Authenticator { return New(id) })
+}
+
+// New returns a Noise Authenticator structure used for Agent authentication
+func New(id uuid.UUID) *Authenticator {
+	return &Authenticator{agent: id}
+}
+
+// Authenticate sends the first handshake message and completes authentication when it reads the server's handshake
+// message. Any other message starts the handshake over
+func (a *Authenticator) Authenticate(in messages.Base) (messages.Base, bool, error) {
+	if o, ok := in.Payload.(opaque.Opaque); ok && in.Type == messages.OPAQUE && o.Type == RESPOND && a.state != nil && !a.authenticated {
+		err := a.respond(o.Payload)
+		if err != nil {
+			return messages.Base{}, false, err
+		}
+		return messages.Base{ID: a.agent, Type: messages.CHECKIN}, true, nil
+	}
+
+	initiate, err := a.initiate()
+	if err != nil {
+		return messages.Base{}, false, err
+	}
+	return messages.Base{ID: a.agent, Type: messages.OPAQUE, Payload: opaque.Opaque{Type: INITIATE, Payload: initiate}}, false, nil
+}
+
+// initiate starts a new handshake and returns its first message: e, es, s, ss
+func (a *Authenticator) initiate() ([]byte, error) {
+	keys.RLock()
+	static, server := keys.static, keys.server
+	keys.RUnlock()
+	if static == nil || server == nil {
+		return nil, fmt.Errorf("authenticators/noise.Authenticate(): the server's static public key is not configured")
+	}
+	var err error
+	a.authenticated = false
+	a.secret = nil
+	a.ephemeral, err = ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("authenticators/noise.Authenticate(): there was an error generating an ephemeral key: %s", err)
+	}
+
+	a.state = newSymmetricState(PROTOCOL)
+	a.state.mixHash([]byte("merlin-noise:" + a.agent.String()))
+	// The responder's static key is a pre-message
+	a.state.mixHash(server.Bytes())
+
+	// e
+	message := a.ephemeral.PublicKey().Bytes()
+	a.state.mixHash(message)
+	// es
+	if err = a.dh(a.ephemeral, server); err != nil {
+		return nil, err
+	}
+	// s
+	s, err := a.state.encryptAndHash(static.PublicKey().Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("authenticators/noise.Authenticate(): %s", err)
+	}
+	message = append(message, s...)
+	// ss
+	if err = a.dh(static, server); err != nil {
+		return nil, err
+	}
+	// An empty payload
+	payload, err := a.state.encryptAndHash(nil)
+	if err != nil {
+		return nil, fmt.Errorf("authenticators/noise.Authenticate(): %s", err)
+	}
+	return append(message, payload...), nil
+}
+
+// respond reads the server's handshake message, e, ee, se, and derives the session secret from the transport keys
+func (a *Authenticator) respond(message []byte) error {
+	keys.RLock()
+	static := keys.static
+	keys.RUnlock()
+	if len(message) < 32 {
+		return fmt.Errorf("authenticators/noise.Authenticate(): the server's handshake message is %d bytes but must be at least 32", len(message))
+	}
+	// e
+	re, err := ecdh.X25519().NewPublicKey(message[:32])
+	if err != nil {
+		return fmt.Errorf("authenticators/noise.Authenticate(): the server's ephemeral key is invalid: %s", err)
+	}
+	a.state.mixHash(message[:32])
+	// ee
+	if err = a.dh(a.ephemeral, re); err != nil {
+		return err
+	}
+	// se
+	if err = a.dh(static, re); err != nil {
+		return err
+	}
+	// The payload authenticates the server because it can only be decrypted with a key mixed from the server's static key
+	if _, err = a.state.decryptAndHash(message[32:]); err != nil {
+		a.state = nil
+		return fmt.Errorf("authenticators/noise.Authenticate(): %s", err)
+	}
+
+	k1, k2 := a.state.split()
+	a.secret = make([]byte, 32)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, append(k1[:], k2[:]...), a.state.h[:], []byte("merlin noise session")), a.secret); err != nil {
+		return fmt.Errorf("authenticators/noise.Authenticate(): there was an error deriving the session key: %s", err)
+	}
+	a.state = nil
+	a.ephemeral = nil
+	a.authenticated = true
+	return nil
+}
+
+// dh mixes the X25519 Diffie-Hellman output of the private and public keys into the handshake
+func (a *Authenticator) dh(private *ecdh.PrivateKey, public *ecdh.PublicKey) error {
+	shared, err := private.ECDH(public)
+	if err != nil {
+		return fmt.Errorf("authenticators/noise.Authenticate(): %s", err)
+	}
+	a.state.mixKey(shared)
+	return nil
+}
+
+// Secret returns the session key derived from the handshake's transport keys
+func (a *Authenticator) Secret() ([]byte, error) {
+	if !a.authenticated {
+		return nil, fmt.Errorf("authenticators/noise.Secret(): the Agent is not authenticated")
+	}
+	return a.secret, nil
+}
+
+// String returns the name of the Authenticator type
+func (a *Authenticator) String() string {
+	return "noise"
+}
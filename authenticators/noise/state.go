@@ -0,0 +1,132 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package noise
+
+import (
+	// Standard
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	// X Packages
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// symmetricState is the Noise protocol's SymmetricState, with its CipherState, for SHA256 and ChaChaPoly
+type symmetricState struct {
+	ck [32]byte // ck is the chaining key
+	h  [32]byte // h is the handshake hash
+	k  []byte   // k is the CipherState's key; nil until the first MixKey
+	n  uint64   // n is the CipherState's nonce
+}
+
+// newSymmetricState initializes the state from the protocol name
+func newSymmetricState(protocol string) *symmetricState {
+	s := &symmetricState{}
+	if len(protocol) <= len(s.h) {
+		copy(s.h[:], protocol)
+	} else {
+		s.h = sha256.Sum256([]byte(protocol))
+	}
+	s.ck = s.h
+	return s
+}
+
+// mixHash hashes the data into the handshake hash
+func (s *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h[:])
+	h.Write(data)
+	copy(s.h[:], h.Sum(nil))
+}
+
+// mixKey mixes the Diffie-Hellman output into the chaining key and sets a new cipher key
+func (s *symmetricState) mixKey(ikm []byte) {
+	ck, k := hkdf2(s.ck[:], ikm)
+	s.ck = ck
+	s.k = k[:]
+	s.n = 0
+}
+
+// encryptAndHash encrypts the plaintext, when there is a cipher key, and hashes the result into the handshake hash
+func (s *symmetricState) encryptAndHash(plaintext []byte) ([]byte, error) {
+	if s.k == nil {
+		s.mixHash(plaintext)
+		return plaintext, nil
+	}
+	aead, err := chacha20poly1305.New(s.k)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := aead.Seal(nil, s.nonce(), plaintext, s.h[:])
+	s.n++
+	s.mixHash(ciphertext)
+	return ciphertext, nil
+}
+
+// decryptAndHash decrypts the ciphertext, when there is a cipher key, and hashes it into the handshake hash
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	if s.k == nil {
+		s.mixHash(ciphertext)
+		return ciphertext, nil
+	}
+	aead, err := chacha20poly1305.New(s.k)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, s.nonce(), ciphertext, s.h[:])
+	if err != nil {
+		return nil, fmt.Errorf("there was an error decrypting the handshake message: %s", err)
+	}
+	s.n++
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// nonce returns the ChaChaPoly nonce: 4 zero bytes followed by the little endian counter
+func (s *symmetricState) nonce() []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], s.n)
+	return nonce
+}
+
+// split returns the initiator to responder and responder to initiator transport keys
+func (s *symmetricState) split() (k1, k2 [32]byte) {
+	return hkdf2(s.ck[:], nil)
+}
+
+// hkdf2 is the Noise protocol's HKDF function with two outputs
+func hkdf2(ck, ikm []byte) (out1, out2 [32]byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write([]byte{0x01})
+	copy(out1[:], mac.Sum(nil))
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write(out1[:])
+	mac.Write([]byte{0x02})
+	copy(out2[:], mac.Sum(nil))
+	return
+}
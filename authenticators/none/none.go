@@ -27,6 +27,9 @@ import (
 
 	// Merlin
 	"github.com/Ne0nd0g/merlin-message"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
 )
 
 // Authenticator is a structure used for "none" authentication
@@ -34,6 +37,10 @@ type Authenticator struct {
 	agent uuid.UUID
 }
 
+func init() {
+	authenticators.Register("none", func(id uuid.UUID) authenticators.Authenticator { return New(id) })
+}
+
 // New returns a "none" Authenticator structure
 func New(id uuid.UUID) *Authenticator {
 	return &Authenticator{agent: id}
@@ -37,6 +37,7 @@ import (
 	"github.com/Ne0nd0g/merlin-message/opaque"
 
 	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
 	"github.com/Ne0nd0g/merlin-agent/v2/core"
 )
@@ -46,9 +47,14 @@ type Authenticator struct {
 	agent         uuid.UUID // The Agent's ID
 	registered    bool      // If OPAQUE registration has been completed
 	authenticated bool      // If OPAQUE authentication has been completed
+	resuming      bool      // If a RESUME message was sent and the server's response is pending
 	opaque        *User     // The OPAQUE user data structure
 }
 
+func init() {
+	authenticators.Register("opaque", func(id uuid.UUID) authenticators.Authenticator { return New(id) })
+}
+
 // New returns an OPAQUE Authenticator structure used for Agent authentication
 func New(id uuid.UUID) *Authenticator {
 	return &Authenticator{agent: id}
@@ -59,6 +65,37 @@ func (a *Authenticator) Authenticate(in messages.Base) (out messages.Base, authe
 	out.ID = a.agent
 	out.Type = messages.OPAQUE
 
+	// Resume the authenticated session with a server-issued resumption token, in one round trip, instead of re-authenticating
+	if a.resuming {
+		a.resuming = false
+		// The server accepts the token by returning a RESUME message with an empty payload
+		if opaqueType(in) == RESUME && len(in.Payload.(opaque.Opaque).Payload) == 0 {
+			cli.Message(cli.NOTE, "Resumed the OPAQUE session with the resumption token")
+			return messages.Base{ID: a.agent, Type: messages.CHECKIN}, true, nil
+		}
+		cli.Message(cli.NOTE, "The server did not accept the OPAQUE resumption token")
+		a.authenticated = false
+		// Fall back to OPAQUE authentication unless the server asked the Agent to register again
+		if opaqueType(in) != opaque.ReRegister {
+			in = messages.Base{ID: a.agent, Type: messages.OPAQUE, Payload: opaque.Opaque{Type: opaque.RegComplete}}
+		}
+	} else if a.registered && a.authenticated && (in.Type == 0 || opaqueType(in) == opaque.ReAuthenticate) {
+		key, _ := a.Secret()
+		payload, ok, errResume := resumption(a.agent, key)
+		if errResume != nil {
+			cli.Message(cli.WARN, fmt.Sprintf("authenticators/opaque.Authenticate(): %s", errResume))
+		}
+		if ok {
+			cli.Message(cli.NOTE, "Resuming the OPAQUE session with the resumption token")
+			a.resuming = true
+			out.Payload = opaque.Opaque{
+				Type:    RESUME,
+				Payload: payload,
+			}
+			return
+		}
+	}
+
 	// Check for ReRegister and ReAuthenticate messages
 	if in.Type == messages.OPAQUE {
 		if in.Payload != nil {
@@ -69,7 +106,20 @@ func (a *Authenticator) Authenticate(in messages.Base) (out messages.Base, authe
 					cli.Message(cli.INFO, "authenticators/opaque.Authenticate(): OPAQUE registration already in progress, doing nothing")
 					return messages.Base{}, false, nil
 				}
+				// Only drop the existing registration for a request signed by the recovery key, otherwise keep the
+				// registration and re-authenticate so the Agent isn't left without a session key
+				errRecovery := verifyRecovery(a.agent, in.Payload.(opaque.Opaque).Payload)
+				if errRecovery != nil {
+					cli.Message(cli.WARN, fmt.Sprintf("authenticators/opaque.Authenticate(): refusing OPAQUE re-register request and re-authenticating instead: %s", errRecovery))
+					a.authenticated = false
+					in.Payload = opaque.Opaque{
+						Type:    opaque.RegComplete,
+						Payload: nil,
+					}
+					break
+				}
 				a.registered = false
+				a.authenticated = false
 				a.opaque = nil
 			case opaque.ReAuthenticate:
 				cli.Message(cli.NOTE, "Received OPAQUE re-authenticate request")
@@ -141,6 +191,7 @@ func (a *Authenticator) Authenticate(in messages.Base) (out messages.Base, authe
 	case opaque.ReRegister:
 		cli.Message(cli.NOTE, "Received OPAQUE server re-registration message")
 		a.registered = false
+		a.authenticated = false
 		a.opaque = nil
 		out.Payload, a.opaque, err = UserRegisterInit(a.agent, a.opaque)
 	case opaque.ReAuthenticate:
@@ -152,6 +203,17 @@ func (a *Authenticator) Authenticate(in messages.Base) (out messages.Base, authe
 	return
 }
 
+// opaqueType returns the OPAQUE message Type of the Base message's payload, or -1 if it is not an OPAQUE message
+func opaqueType(in messages.Base) opaque.Type {
+	if in.Type != messages.OPAQUE {
+		return -1
+	}
+	if o, ok := in.Payload.(opaque.Opaque); ok {
+		return o.Type
+	}
+	return -1
+}
+
 // Secret returns the established shared secret as bytes
 func (a *Authenticator) Secret() (key []byte, err error) {
 	if !a.authenticated {
@@ -0,0 +1,119 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package opaque
+
+import (
+	// Standard
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/google/uuid"
+)
+
+// RecoveryWindow is how far a signed re-registration request's timestamp can be from the Agent's clock before it is refused
+const RecoveryWindow = 10 * time.Minute
+
+// Recovery is the signed payload of an OPAQUE re-registration request the server sends after it lost the Agent's
+// registration state. The Agent redoes the OPAQUE registration phase with its existing ID and PSK
+type Recovery struct {
+	Agent     uuid.UUID `json:"agent"`     // Agent is the ID of the Agent that must re-register
+	Timestamp int64     `json:"timestamp"` // Timestamp is the Unix epoch time the request was signed
+	Signature string    `json:"signature"` // Signature is the base64 encoded Ed25519 signature of the Recovery message
+}
+
+// recoveryKey is the Ed25519 public key used to verify re-registration requests
+var recoveryKey ed25519.PublicKey
+
+// recoveryLast is the timestamp of the last accepted re-registration request, used to refuse replays
+var recoveryLast int64
+
+// recoveryMutex protects the recovery key and last timestamp from concurrent access
+var recoveryMutex sync.Mutex
+
+// SetRecoveryKey sets the base64 encoded Ed25519 public key used to verify OPAQUE re-registration requests.
+// When a key is set, the Agent only drops its registration for a request signed by the key's private key.
+// An empty string removes the key and the Agent honors unsigned re-registration requests
+func SetRecoveryKey(publicKey string) error {
+	var k ed25519.PublicKey
+	if publicKey != "" {
+		data, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil {
+			return fmt.Errorf("authenticators/opaque.SetRecoveryKey(): there was an error base64 decoding the public key: %s", err)
+		}
+		if len(data) != ed25519.PublicKeySize {
+			return fmt.Errorf("authenticators/opaque.SetRecoveryKey(): the Ed25519 public key must be %d bytes but was %d", ed25519.PublicKeySize, len(data))
+		}
+		k = data
+	}
+	recoveryMutex.Lock()
+	recoveryKey = k
+	recoveryMutex.Unlock()
+	return nil
+}
+
+// Message returns the bytes that are signed for the Recovery request: merlin-reregister:<agent id>:<unix timestamp>
+func (r Recovery) Message() []byte {
+	return []byte("merlin-reregister:" + r.Agent.String() + ":" + strconv.FormatInt(r.Timestamp, 10))
+}
+
+// verifyRecovery validates the JSON encoded Recovery payload of a re-registration request for the agent.
+// Without a recovery key, every request is accepted so servers that don't sign requests keep working
+func verifyRecovery(agent uuid.UUID, payload []byte) error {
+	recoveryMutex.Lock()
+	defer recoveryMutex.Unlock()
+	if recoveryKey == nil {
+		return nil
+	}
+	if len(payload) == 0 {
+		return fmt.Errorf("the re-registration request is not signed")
+	}
+
+	var r Recovery
+	err := json.Unmarshal(payload, &r)
+	if err != nil {
+		return fmt.Errorf("there was an error JSON decoding the re-registration request: %s", err)
+	}
+	if r.Agent != agent {
+		return fmt.Errorf("the re-registration request is for Agent %s, not %s", r.Agent, agent)
+	}
+	signature, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil {
+		return fmt.Errorf("there was an error base64 decoding the re-registration request signature: %s", err)
+	}
+	if !ed25519.Verify(recoveryKey, r.Message(), signature) {
+		return fmt.Errorf("the re-registration request signature is invalid")
+	}
+	signed := time.Unix(r.Timestamp, 0)
+	if d := time.Since(signed); d > RecoveryWindow || d < -RecoveryWindow {
+		return fmt.Errorf("the re-registration request was signed at %s, outside the %s window", signed.UTC().Format(time.RFC3339), RecoveryWindow)
+	}
+	if r.Timestamp <= recoveryLast {
+		return fmt.Errorf("the re-registration request was already used")
+	}
+	recoveryLast = r.Timestamp
+	return nil
+}
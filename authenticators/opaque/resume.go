@@ -0,0 +1,123 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package opaque
+
+import (
+	// Standard
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/opaque"
+)
+
+// RESUME is the OPAQUE message Type used to resume an authenticated session with a server-issued resumption token.
+// The Agent sends it with a Resumption payload and the server returns it, with an empty payload, to accept the token.
+// The value is outside the range of the Types defined by the merlin-message library
+const RESUME opaque.Type = 100
+
+// Resumption is the payload of a RESUME message that proves the Agent still holds the session key the token was issued for
+type Resumption struct {
+	Token     string `json:"token"`     // Token is the server-issued, server-encrypted session state exactly as it was received
+	Nonce     string `json:"nonce"`     // Nonce is 16 random base64 encoded bytes so no two requests are the same
+	Timestamp int64  `json:"timestamp"` // Timestamp is the Unix epoch time the request was created
+	MAC       string `json:"mac"`       // MAC is the base64 encoded HMAC-SHA256, keyed with the session key, of the Resumption message
+}
+
+// token is the server-issued resumption token
+var token string
+
+// tokenExpires is when the resumption token can no longer be used
+var tokenExpires time.Time
+
+// tokenMutex protects the resumption token from concurrent access
+var tokenMutex sync.Mutex
+
+// SetResumption stores the server-issued resumption token used to resume the session, instead of re-authenticating,
+// until the ttl expires. An empty token removes the stored token
+func SetResumption(t string, ttl time.Duration) error {
+	if t != "" && ttl <= 0 {
+		return fmt.Errorf("authenticators/opaque.SetResumption(): the resumption token's time to live must be greater than 0 but was %s", ttl)
+	}
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+	token = t
+	tokenExpires = time.Now().Add(ttl)
+	if t == "" {
+		tokenExpires = time.Time{}
+	}
+	return nil
+}
+
+// Resumable returns the resumption token's expiration time and true if there is a token that has not expired
+func Resumable() (time.Time, bool) {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+	return tokenExpires, token != "" && time.Now().Before(tokenExpires)
+}
+
+// Message returns the bytes that are signed for the Resumption request: merlin-resume:<agent id>:<token>:<nonce>:<unix timestamp>
+func (r Resumption) Message(agent uuid.UUID) []byte {
+	return []byte("merlin-resume:" + agent.String() + ":" + r.Token + ":" + r.Nonce + ":" + strconv.FormatInt(r.Timestamp, 10))
+}
+
+// resumption takes the stored resumption token, so it is only ever used once, and builds the RESUME message payload
+// that proves the Agent holds the session key. False is returned when there is no usable token
+func resumption(agent uuid.UUID, key []byte) (payload []byte, ok bool, err error) {
+	tokenMutex.Lock()
+	t, expires := token, tokenExpires
+	token = ""
+	tokenExpires = time.Time{}
+	tokenMutex.Unlock()
+	if t == "" || !time.Now().Before(expires) || len(key) == 0 {
+		return nil, false, nil
+	}
+
+	nonce := make([]byte, 16)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, false, fmt.Errorf("there was an error generating the resumption nonce: %s", err)
+	}
+	r := Resumption{
+		Token:     t,
+		Nonce:     base64.StdEncoding.EncodeToString(nonce),
+		Timestamp: time.Now().Unix(),
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(r.Message(agent))
+	r.MAC = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	payload, err = json.Marshal(r)
+	if err != nil {
+		return nil, false, fmt.Errorf("there was an error JSON encoding the resumption request: %s", err)
+	}
+	return payload, true, nil
+}
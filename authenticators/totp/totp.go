@@ -0,0 +1,201 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package totp is an authenticator that proves the Agent holds a seed embedded at build time by sending a time-based
+// one-time value, and mixes that value into the session secret with the PSK, so the key used to encrypt messages changes
+// with every authentication and a captured authentication can't be replayed outside its short validity window. Used in
+// an authenticator chain (e.g., totp,opaque), the value is mixed into the OPAQUE session secret as well.
+//
+// The value is the HMAC-SHA256 of the Agent's ID and the time step, the number of 30 second periods since the Unix epoch,
+// keyed with the seed. The messages are carried as OPAQUE messages with Types outside the range defined by the
+// merlin-message library:
+//
+//	Agent  -> Server: PROOF with the time step and the one-time value
+//	Server -> Agent:  ACCEPT, with an empty payload, when the value is valid for the current time step or the one before
+package totp
+
+import (
+	// Standard
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// X Packages
+	"golang.org/x/crypto/hkdf"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+	"github.com/Ne0nd0g/merlin-message/opaque"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
+	"github.com/Ne0nd0g/merlin-agent/v2/heap"
+)
+
+// STEP is how long each one-time value is valid for
+const STEP = 30 * time.Second
+
+// TOTP authentication message Types, carried in OPAQUE messages
+const (
+	// PROOF is the Agent's Proof payload
+	PROOF opaque.Type = 130
+	// ACCEPT is returned by the server, with an empty payload, when the Agent's one-time value is valid
+	ACCEPT opaque.Type = 131
+)
+
+// Proof is the payload of a PROOF message
+type Proof struct {
+	Step  int64  `json:"step"`  // Step is the number of STEP periods since the Unix epoch the value was computed for
+	Value string `json:"value"` // Value is the base64 encoded one-time value
+}
+
+// settings are the seed embedded at build time and the SHA256 hash of the PSK the one-time value is mixed with, both
+// sealed while they aren't used
+var settings = struct {
+	seed heap.Secret
+	psk  heap.Secret
+	sync.RWMutex
+}{}
+
+// SetSeed parses and sets the seed, base32 as used by authenticator apps or base64 encoded, and the PSK the one-time
+// value is mixed with. The seed must be at least 16 bytes; an empty string removes it
+func SetSeed(seed, psk string) error {
+	var raw []byte
+	if seed = strings.TrimSpace(seed); seed != "" {
+		var err error
+		raw, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(strings.TrimRight(seed, "=")))
+		if err != nil {
+			raw, err = base64.StdEncoding.DecodeString(seed)
+		}
+		if err != nil {
+			return fmt.Errorf("authenticators/totp.SetSeed(): the seed is neither base32 nor base64 encoded")
+		}
+		if len(raw) < 16 {
+			return fmt.Errorf("authenticators/totp.SetSeed(): the seed is %d bytes but must be at least 16", len(raw))
+		}
+	}
+	settings.Lock()
+	settings.seed = heap.NewSecret(raw)
+	hash := sha256.Sum256([]byte(psk))
+	settings.psk = heap.NewSecret(hash[:])
+	settings.Unlock()
+	return nil
+}
+
+// Value returns the one-time value for the Agent at the time step, or nil when there is no seed
+func Value(agent uuid.UUID, step int64) []byte {
+	settings.RLock()
+	seed := settings.seed.Open()
+	settings.RUnlock()
+	if seed == nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte("merlin-totp:" + agent.String()))
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(step)))
+	return mac.Sum(nil)
+}
+
+// Step returns the time step for the time
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(STEP/time.Second)
+}
+
+// Authenticator is a structure used for TOTP authentication
+type Authenticator struct {
+	agent         uuid.UUID // agent is the Agent's ID
+	value         []byte    // value is the one-time value sent in the current authentication's PROOF
+	secret        []byte    // secret is the session key derived after the server accepted the value
+	authenticated bool      // authenticated is true after the server returned ACCEPT
+}
+
+func init() {
+	authenticators.Register("totp", func(id uuid.UUID) authenticators.Authenticator { return New(id) })
+}
+
+// New returns a TOTP Authenticator structure used for Agent authentication
+func New(id uuid.UUID) *Authenticator {
+	return &Authenticator{agent: id}
+}
+
+// Authenticate sends the PROOF for the current time step and completes authentication when the server returns ACCEPT.
+// Any other message starts authentication over with a new PROOF
+func (a *Authenticator) Authenticate(in messages.Base) (messages.Base, bool, error) {
+	if o, ok := in.Payload.(opaque.Opaque); ok && in.Type == messages.OPAQUE && o.Type == ACCEPT && a.value != nil && !a.authenticated {
+		err := a.derive()
+		if err != nil {
+			return messages.Base{}, false, err
+		}
+		return messages.Base{ID: a.agent, Type: messages.CHECKIN}, true, nil
+	}
+
+	a.authenticated = false
+	a.secret = nil
+	step := Step(time.Now())
+	a.value = Value(a.agent, step)
+	if a.value == nil {
+		return messages.Base{}, false, fmt.Errorf("authenticators/totp.Authenticate(): the TOTP seed is not configured")
+	}
+	proof, err := json.Marshal(Proof{Step: step, Value: base64.StdEncoding.EncodeToString(a.value)})
+	if err != nil {
+		return messages.Base{}, false, fmt.Errorf("authenticators/totp.Authenticate(): there was an error JSON encoding the proof: %s", err)
+	}
+	return messages.Base{ID: a.agent, Type: messages.OPAQUE, Payload: opaque.Opaque{Type: PROOF, Payload: proof}}, false, nil
+}
+
+// derive returns the session key: HKDF-SHA256 of the PSK hash and the one-time value, salted with the Agent's ID
+func (a *Authenticator) derive() error {
+	settings.RLock()
+	psk := settings.psk.Open()
+	settings.RUnlock()
+	a.secret = make([]byte, 32)
+	agent := a.agent
+	_, err := io.ReadFull(hkdf.New(sha256.New, append(psk, a.value...), agent[:], []byte("merlin totp session")), a.secret)
+	if err != nil {
+		return fmt.Errorf("authenticators/totp.Authenticate(): there was an error deriving the session key: %s", err)
+	}
+	a.value = nil
+	a.authenticated = true
+	return nil
+}
+
+// Secret returns the session key derived from the PSK and the one-time value
+func (a *Authenticator) Secret() ([]byte, error) {
+	if !a.authenticated {
+		return nil, fmt.Errorf("authenticators/totp.Secret(): the Agent is not authenticated")
+	}
+	return a.secret, nil
+}
+
+// String returns the name of the Authenticator type
+func (a *Authenticator) String() string {
+	return "totp"
+}
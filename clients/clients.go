@@ -22,8 +22,15 @@ along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
 package clients
 
 import (
+	// Standard
+	"io"
+	"strings"
+
 	// Merlin
 	"github.com/Ne0nd0g/merlin-message"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
 )
 
 // Client is an interface definition a client must implement to interact with a remote server
@@ -48,3 +55,40 @@ type Client interface {
 	// can be sent/received.
 	Synchronous() bool
 }
+
+// Streamer is an optional interface for clients that can send large payloads, such as file transfers, as a stream that
+// is transformed while it is sent instead of as a message held in memory all at once. Only the http client implements
+// it; peer-to-peer clients can't because their parent Agent relays their traffic as delegates inside its own messages
+type Streamer interface {
+	// Stream runs the data read from the reader through the client's stream transforms and sends it to the server
+	Stream(reader io.Reader) error
+}
+
+// Padder is an optional interface for clients that add random padding to each Base message they send
+type Padder interface {
+	// Padding returns the profile that selects the amount of random padding added to each Base message
+	Padding() *padding.Profile
+	// SetPadding replaces the profile that selects the amount of random padding added to each Base message
+	SetPadding(profile *padding.Profile)
+}
+
+// Session is an authenticated client's state that is handed to a new Agent process so that it continues the session
+// without authenticating again
+type Session struct {
+	Secret []byte `json:"secret"`          // Secret is the key currently used to encrypt messages
+	Token  string `json:"token,omitempty"` // Token is the JSON Web Token the server last issued, if the client uses one
+}
+
+// Resumer is an optional interface for clients whose authenticated session can be handed to a new Agent process
+type Resumer interface {
+	// Session returns the client's authenticated session
+	Session() (Session, error)
+	// Resume continues the provided session as if the client had authenticated
+	Resume(session Session) error
+}
+
+// PSKs splits a comma separated list of Pre-Shared Keys into the order they are tried when a message from the server
+// can't be decrypted with the Agent's secret. There is always at least one PSK
+func PSKs(value string) []string {
+	return strings.Split(value, ",")
+}
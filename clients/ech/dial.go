@@ -0,0 +1,76 @@
+//go:build go1.23
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package ech
+
+import (
+	// Standard
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// supported is true because the Go standard library implements ECH starting with Go 1.23
+const supported = true
+
+// Dial makes a TLS connection to the address using ECH. When the server rejects the ECH configuration and sends new
+// ones, they replace the Config and the handshake is tried again. Only when the Config allows it, and the server
+// rejected ECH without sending new configurations, does the connection fall back to a TLS handshake without ECH; every
+// other error, such as a failed certificate pin, a timeout, or a reset connection, is returned so that a middlebox
+// can't force the server's hostname into the clear.
+// A nil Config makes a TLS connection without ECH. The network connection is made with the dialer
+func (c *Config) Dial(ctx context.Context, network, addr string, cfg *tls.Config, dialer Dialer) (*tls.Conn, error) {
+	if c == nil {
+		return handshake(ctx, network, addr, cfg, dialer)
+	}
+
+	// ECH requires TLS 1.3
+	echConfig := cfg.Clone()
+	echConfig.MinVersion = tls.VersionTLS13
+	echConfig.EncryptedClientHelloConfigList = c.List()
+	conn, err := handshake(ctx, network, addr, echConfig, dialer)
+	if err == nil {
+		return conn, nil
+	}
+
+	var rejection *tls.ECHRejectionError
+	if errors.As(err, &rejection) && len(rejection.RetryConfigList) > 0 {
+		cli.Message(cli.NOTE, fmt.Sprintf("clients/ech.Dial(): %s rejected the ECH configuration, retrying with the %d byte configuration it sent", addr, len(rejection.RetryConfigList)))
+		c.set(rejection.RetryConfigList)
+		echConfig.EncryptedClientHelloConfigList = rejection.RetryConfigList
+		conn, err = handshake(ctx, network, addr, echConfig, dialer)
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	// A rejection without new configurations means the server doesn't support ECH; anything else is returned
+	if !c.Fallback || !errors.As(err, &rejection) || len(rejection.RetryConfigList) > 0 {
+		return nil, err
+	}
+	cli.Message(cli.WARN, fmt.Sprintf("clients/ech.Dial(): %s rejected ECH without sending new configurations, falling back to a TLS handshake without ECH: %s", addr, err))
+	return handshake(ctx, network, addr, cfg, dialer)
+}
@@ -0,0 +1,37 @@
+//go:build !go1.23
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package ech
+
+import (
+	// Standard
+	"context"
+	"crypto/tls"
+)
+
+// supported is false because the Go standard library does not implement ECH before Go 1.23
+const supported = false
+
+// Dial makes a TLS connection to the address without ECH. The network connection is made with the dialer
+func (c *Config) Dial(ctx context.Context, network, addr string, cfg *tls.Config, dialer Dialer) (*tls.Conn, error) {
+	return handshake(ctx, network, addr, cfg, dialer)
+}
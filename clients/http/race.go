@@ -0,0 +1,110 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package http
+
+import (
+	// Standard
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	// raceDelay is how long a connection attempt has before the next one starts alongside it, as in Happy Eyeballs
+	raceDelay = 250 * time.Millisecond
+	// raceTimeout is how long the race has for any URL to connect
+	raceTimeout = 30 * time.Second
+)
+
+// race opens a TCP connection to the host of every URL, in order, and returns the index of the first to connect.
+// The next attempt starts when one fails or after the race delay, with at most the strategy's limit running at once.
+// Each host's IPv6 and IPv4 addresses are raced by the dialer
+func (r rotation) race(urls []string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), raceTimeout)
+	defer cancel()
+
+	type attempt struct {
+		index int
+		err   error
+	}
+	// Buffered so the attempts that lose the race don't block after it is over
+	results := make(chan attempt, len(urls))
+	var dialer net.Dialer
+	var next, running int
+	start := func() {
+		i := next
+		next++
+		running++
+		go func() {
+			addr, err := hostPort(urls[i])
+			if err == nil {
+				var conn net.Conn
+				conn, err = dialer.DialContext(ctx, "tcp", addr)
+				if err == nil {
+					_ = conn.Close()
+				}
+			}
+			results <- attempt{index: i, err: err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(raceDelay)
+	defer timer.Stop()
+	var err error
+	for running > 0 {
+		select {
+		case a := <-results:
+			running--
+			if a.err == nil {
+				return a.index, nil
+			}
+			err = fmt.Errorf("%s: %s", urls[a.index], a.err)
+			if next < len(urls) && running < r.limit {
+				start()
+			}
+		case <-timer.C:
+			if next < len(urls) && running < r.limit {
+				start()
+			}
+			timer.Reset(raceDelay)
+		}
+	}
+	return 0, fmt.Errorf("clients/http.race(): none of the URLs could be connected to, the last error was %s", err)
+}
+
+// hostPort returns the host and port, from the scheme if there isn't one, of the URL
+func hostPort(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
@@ -0,0 +1,140 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package http
+
+import (
+	// Standard
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// URL rotation strategies
+const (
+	// RANDOM selects a random URL for every request
+	RANDOM = "random"
+	// ROUNDROBIN selects the next URL, in order, for every request
+	ROUNDROBIN = "round-robin"
+	// TIMESLICED uses each URL, in order, for a fixed amount of time
+	TIMESLICED = "time-sliced"
+	// FAILOVER uses the current URL until a request fails and then moves to the next URL
+	FAILOVER = "failover"
+	// RACE connects to every URL, a limited number at a time, and uses the first to connect until a request fails
+	RACE = "race"
+	// BACKUP uses the first URL as the primary and the rest as backups, promoting the next after repeated failures and
+	// periodically retrying the primary
+	BACKUP = "backup"
+)
+
+// rotation holds the strategy and state used to select which URL the HTTP client sends the next request to
+type rotation struct {
+	strategy string        // strategy is the URL rotation strategy (e.g., round-robin)
+	slice    time.Duration // slice is the amount of time each URL is used with the time-sliced strategy
+	start    time.Time     // start is when the time-sliced strategy began
+	limit    int           // limit is the most connection attempts the race strategy makes at once
+}
+
+// parseRotation parses a rotation strategy string. The time-sliced strategy takes an optional duration after a colon
+// (e.g., time-sliced:1h) that defaults to one hour. The race strategy takes an optional limit of concurrent connection
+// attempts after a colon (e.g., race:2) that defaults to three. An empty string returns the random strategy
+func parseRotation(value string) (r rotation, err error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	strategy, duration, _ := strings.Cut(value, ":")
+	switch strategy {
+	case "", RANDOM:
+		r.strategy = RANDOM
+	case ROUNDROBIN, "roundrobin", "sequential":
+		r.strategy = ROUNDROBIN
+	case TIMESLICED, "timesliced", "time":
+		r.strategy = TIMESLICED
+		r.slice = time.Hour
+		if duration != "" {
+			r.slice, err = time.ParseDuration(duration)
+			if err != nil {
+				err = fmt.Errorf("clients/http.parseRotation(): there was an error parsing the time slice duration %s: %s", duration, err)
+				return
+			}
+			if r.slice <= 0 {
+				err = fmt.Errorf("clients/http.parseRotation(): the time slice duration must be greater than 0")
+				return
+			}
+		}
+		r.start = time.Now()
+	case FAILOVER, "failover-only":
+		r.strategy = FAILOVER
+	case BACKUP, "primary", "priority":
+		r.strategy = BACKUP
+	case RACE, "happy-eyeballs":
+		r.strategy = RACE
+		r.limit = 3
+		if duration != "" {
+			r.limit, err = strconv.Atoi(duration)
+			if err != nil {
+				err = fmt.Errorf("clients/http.parseRotation(): there was an error parsing the race limit %s: %s", duration, err)
+				return
+			}
+			if r.limit <= 0 {
+				err = fmt.Errorf("clients/http.parseRotation(): the race limit must be greater than 0")
+				return
+			}
+		}
+	default:
+		err = fmt.Errorf("clients/http.parseRotation(): unhandled URL rotation strategy: %s", value)
+	}
+	return
+}
+
+// next returns the index of the URL to use for the NEXT request based on the rotation strategy, the current URL index,
+// the total number of URLs, and whether the last request failed
+func (r rotation) next(current, total int, failed bool) int {
+	if total <= 1 {
+		return 0
+	}
+	switch r.strategy {
+	case ROUNDROBIN:
+		return (current + 1) % total
+	case TIMESLICED:
+		return int(time.Since(r.start)/r.slice) % total
+	case FAILOVER, RACE:
+		if failed {
+			return (current + 1) % total
+		}
+		return current
+	case BACKUP:
+		// The endpoint health decides when to promote a backup or retry the primary
+		return current
+	default:
+		return rand.Intn(total) // #nosec G404 random number is not used for secrets
+	}
+}
+
+// String returns the rotation strategy as a string
+func (r rotation) String() string {
+	switch r.strategy {
+	case TIMESLICED:
+		return fmt.Sprintf("%s:%s", r.strategy, r.slice)
+	case RACE:
+		return fmt.Sprintf("%s:%d", r.strategy, r.limit)
+	}
+	return r.strategy
+}
@@ -24,10 +24,12 @@ package memory
 
 import (
 	// Standard
+	"fmt"
 	"sync"
 
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/clients"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
 )
 
 // Repository is the structure that implements the in-memory repository for interacting with the Agent's C2 client
@@ -75,11 +77,28 @@ func (r *Repository) SetListener(listener string) error {
 	return r.client.Set("listener", listener)
 }
 
-// SetPadding changes the maximum amount of random padding added to each outgoing message
-func (r *Repository) SetPadding(padding string) error {
+// SetPace changes the peer-to-peer client's minimum time between upstream messages and, if provided, its jitter
+// percentage
+func (r *Repository) SetPace(sleep, jitter string) error {
 	r.Lock()
 	defer r.Unlock()
-	return r.client.Set("paddingmax", padding)
+	err := r.client.Set("sleep", sleep)
+	if err != nil || jitter == "" {
+		return err
+	}
+	return r.client.Set("jitter", jitter)
+}
+
+// SetPadding changes the padding profile that selects the amount of random padding added to each outgoing message
+func (r *Repository) SetPadding(profile *padding.Profile) error {
+	r.Lock()
+	defer r.Unlock()
+	p, ok := r.client.(clients.Padder)
+	if !ok {
+		return fmt.Errorf("clients/memory.SetPadding(): the %s client does not pad its messages", r.client.Get("protocol"))
+	}
+	p.SetPadding(profile)
+	return nil
 }
 
 // SetParrot reconfigures the client's HTTP configuration to match the provided browser
@@ -88,3 +107,25 @@ func (r *Repository) SetParrot(parrot string) error {
 	defer r.Unlock()
 	return r.client.Set("parrot", parrot)
 }
+
+// SetRotation changes the client's URL rotation strategy
+func (r *Repository) SetRotation(strategy string) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.client.Set("rotation", strategy)
+}
+
+// SetBackup changes the number of consecutive failures before the client promotes a backup endpoint and how often it
+// retries the primary
+func (r *Repository) SetBackup(spec string) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.client.Set("backup", spec)
+}
+
+// SetThrottle changes the maximum rate, in bytes per second, that the client sends data
+func (r *Repository) SetThrottle(rate string) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.client.Set("throttle", rate)
+}
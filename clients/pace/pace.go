@@ -0,0 +1,132 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package pace spaces out the messages a client sends upstream by a sleep time with random jitter so that peer-to-peer
+// Agents poll at their own cadence instead of relying on their parent's
+package pace
+
+import (
+	// Standard
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pacer holds the minimum time between sends. A sleep of 0 doesn't wait
+type Pacer struct {
+	sleep  time.Duration // sleep is the time to wait between sends
+	jitter int           // jitter is the percentage, 0 to 100, of the sleep that is randomly added or subtracted
+	last   time.Time     // last is when the previous send was allowed
+	sync.Mutex
+}
+
+// New returns a Pacer for the provided sleep duration (e.g., 30s) and jitter percentage (e.g., 20). Empty strings or 0
+// don't wait or jitter
+func New(sleep, jitter string) (*Pacer, error) {
+	p := &Pacer{}
+	err := p.SetSleep(sleep)
+	if err != nil {
+		return nil, err
+	}
+	err = p.SetJitter(jitter)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetSleep updates the time to wait between sends from the provided duration string
+func (p *Pacer) SetSleep(sleep string) error {
+	sleep = strings.TrimSpace(sleep)
+	var d time.Duration
+	if sleep != "" && sleep != "0" {
+		var err error
+		d, err = time.ParseDuration(sleep)
+		if err != nil {
+			return fmt.Errorf("clients/pace.SetSleep(): there was an error parsing the sleep %s: %s", sleep, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("clients/pace.SetSleep(): the sleep must be 0 or greater but received %s", d)
+		}
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.sleep = d
+	return nil
+}
+
+// SetJitter updates the percentage of the sleep that is randomly added or subtracted from the provided integer string
+func (p *Pacer) SetJitter(jitter string) error {
+	jitter = strings.TrimSuffix(strings.TrimSpace(jitter), "%")
+	var i int
+	if jitter != "" {
+		var err error
+		i, err = strconv.Atoi(jitter)
+		if err != nil {
+			return fmt.Errorf("clients/pace.SetJitter(): there was an error converting the jitter %s to an integer: %s", jitter, err)
+		}
+		if i < 0 || i > 100 {
+			return fmt.Errorf("clients/pace.SetJitter(): the jitter must be between 0 and 100 but received %d", i)
+		}
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.jitter = i
+	return nil
+}
+
+// Sleep returns the time to wait between sends
+func (p *Pacer) Sleep() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return p.sleep
+}
+
+// Jitter returns the percentage of the sleep that is randomly added or subtracted
+func (p *Pacer) Jitter() int {
+	p.Lock()
+	defer p.Unlock()
+	return p.jitter
+}
+
+// Wait blocks until the sleep, with jitter, has passed since the previous send
+func (p *Pacer) Wait() {
+	p.Lock()
+	if p.sleep <= 0 {
+		p.last = time.Now()
+		p.Unlock()
+		return
+	}
+	interval := p.sleep
+	if p.jitter > 0 {
+		spread := int64(p.sleep) * int64(p.jitter) / 100
+		interval += time.Duration(rand.Int63n(2*spread+1) - spread) // #nosec G404 - Does not need to be cryptographically secure
+	}
+	next := p.last.Add(interval)
+	if now := time.Now(); next.Before(now) {
+		next = now
+	}
+	p.last = next
+	p.Unlock()
+	time.Sleep(time.Until(next))
+}
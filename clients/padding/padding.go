@@ -0,0 +1,240 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package padding selects how much random padding is added to each message so that the size of an Agent's messages
+// follows a realistic distribution instead of the flat one produced by a uniform random amount.
+// A profile is one or more distributions separated by a semicolon; each can be limited to a message type:
+//
+//	4096                       - Uniform from 0 to 4095 bytes, the original behavior
+//	uniform:[min:]max          - Uniform from min, or 0, up to but not including max bytes
+//	normal:mean:stddev         - Gaussian around the mean
+//	lognormal:median:sigma     - Log-normal with the median and the shape sigma, a long tail of larger messages
+//	checkin=lognormal:600:0.5  - Only applies to check-in messages; the types are checkin, jobs, opaque, keyexchange, and idle
+//
+// A distribution without a message type is used for every type that doesn't have its own
+package padding
+
+import (
+	// Standard
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+)
+
+// Limit is the most padding, in bytes, added to a message regardless of the distribution
+const Limit = 1 << 20
+
+// Distribution types
+const (
+	UNIFORM = iota
+	NORMAL
+	LOGNORMAL
+)
+
+// distribution is a random distribution of padding sizes
+type distribution struct {
+	kind int
+	a    float64 // a is the minimum for uniform, the mean for normal, and the median for log-normal distributions
+	b    float64 // b is the maximum for uniform, the standard deviation for normal, and sigma for log-normal distributions
+}
+
+// Profile holds the padding distributions for each message type. A nil or empty Profile adds no padding
+type Profile struct {
+	spec          string
+	defaults      *distribution
+	distributions map[messages.Type]*distribution
+}
+
+// types are the message type names used in a profile
+var types = map[string]messages.Type{
+	"checkin":     messages.CHECKIN,
+	"jobs":        messages.JOBS,
+	"opaque":      messages.OPAQUE,
+	"keyexchange": messages.KEYEXCHANGE,
+	"idle":        messages.IDLE,
+}
+
+// New parses a padding profile. An empty string or 0 adds no padding
+func New(spec string) (*Profile, error) {
+	p := &Profile{spec: strings.TrimSpace(spec), distributions: make(map[messages.Type]*distribution)}
+	for _, entry := range strings.Split(p.spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, typed := strings.Cut(entry, "=")
+		if !typed {
+			value = name
+		}
+		d, err := parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("clients/padding.New(): invalid padding %s: %s", entry, err)
+		}
+		if !typed {
+			p.defaults = d
+			continue
+		}
+		t, ok := types[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("clients/padding.New(): unknown message type %s in padding %s", name, entry)
+		}
+		p.distributions[t] = d
+	}
+	return p, nil
+}
+
+// parse converts a distribution string into a distribution; a distribution that never pads returns nil
+func parse(value string) (*distribution, error) {
+	kind, args, _ := strings.Cut(strings.ToLower(strings.TrimSpace(value)), ":")
+	var params []float64
+	if args != "" {
+		for _, arg := range strings.Split(args, ":") {
+			f, err := strconv.ParseFloat(arg, 64)
+			if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+				return nil, fmt.Errorf("%s is not a positive number", arg)
+			}
+			params = append(params, f)
+		}
+	}
+	d := &distribution{}
+	switch kind {
+	case "uniform":
+		d.kind = UNIFORM
+		switch len(params) {
+		case 1:
+			d.b = params[0]
+		case 2:
+			d.a, d.b = params[0], params[1]
+		default:
+			return nil, fmt.Errorf("the uniform distribution requires an optional minimum and a maximum")
+		}
+		if d.a >= d.b {
+			return nil, fmt.Errorf("the minimum %g is not less than the maximum %g", d.a, d.b)
+		}
+	case "normal":
+		d.kind = NORMAL
+		if len(params) != 2 {
+			return nil, fmt.Errorf("the normal distribution requires a mean and a standard deviation")
+		}
+		d.a, d.b = params[0], params[1]
+	case "lognormal":
+		d.kind = LOGNORMAL
+		if len(params) != 2 || params[0] == 0 {
+			return nil, fmt.Errorf("the log-normal distribution requires a median greater than 0 and a sigma")
+		}
+		d.a, d.b = params[0], params[1]
+	default:
+		// A number on its own is the original maximum for a uniform amount of padding
+		max, err := strconv.Atoi(kind)
+		if err != nil || max < 0 || args != "" {
+			return nil, fmt.Errorf("unknown distribution %s", value)
+		}
+		if max == 0 {
+			return nil, nil
+		}
+		d.kind = UNIFORM
+		d.b = float64(max)
+	}
+	return d, nil
+}
+
+// Size returns a random amount of padding, in bytes, for a message of the type
+func (p *Profile) Size(t messages.Type) int {
+	if p == nil {
+		return 0
+	}
+	d, ok := p.distributions[t]
+	if !ok {
+		d = p.defaults
+	}
+	if d == nil {
+		return 0
+	}
+	var size float64
+	// #nosec G404 -- Random number does not impact security
+	switch d.kind {
+	case UNIFORM:
+		size = d.a + rand.Float64()*(d.b-d.a)
+	case NORMAL:
+		size = d.a + rand.NormFloat64()*d.b
+	case LOGNORMAL:
+		size = d.a * math.Exp(rand.NormFloat64()*d.b)
+	}
+	return clamp(size)
+}
+
+// Max returns the most padding the profile is expected to add to a message, using three standard deviations above the
+// mean for normal and log-normal distributions, for reporting the padding to the server
+func (p *Profile) Max() int {
+	if p == nil {
+		return 0
+	}
+	var max int
+	for _, d := range append([]*distribution{p.defaults}, p.distributionList()...) {
+		if d == nil {
+			continue
+		}
+		var size float64
+		switch d.kind {
+		case UNIFORM:
+			size = d.b
+		case NORMAL:
+			size = d.a + 3*d.b
+		case LOGNORMAL:
+			size = d.a * math.Exp(3*d.b)
+		}
+		if s := clamp(size); s > max {
+			max = s
+		}
+	}
+	return max
+}
+
+// distributionList returns the message type specific distributions
+func (p *Profile) distributionList() (list []*distribution) {
+	for _, d := range p.distributions {
+		list = append(list, d)
+	}
+	return
+}
+
+// clamp converts the size to a number of bytes from 0 to the Limit
+func clamp(size float64) int {
+	if size < 0 || math.IsNaN(size) {
+		return 0
+	}
+	if size > Limit {
+		return Limit
+	}
+	return int(size)
+}
+
+// String returns the profile as it was configured
+func (p *Profile) String() string {
+	if p == nil || p.spec == "" {
+		return "0"
+	}
+	return p.spec
+}
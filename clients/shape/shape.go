@@ -0,0 +1,193 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package shape splits the data written to a TLS connection into records whose sizes, and the time between them, match
+// an application's traffic, such as a video call or a software updater, and pads requests toward whole records
+package shape
+
+import (
+	// Standard
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// presets are the built-in application traffic profiles
+var presets = map[string]Profile{
+	// browsing is a web browser loading pages: a mix of record sizes with short pauses
+	"browsing": {Records: []int{1400, 2800, 4096, 8192, 16384}, Delay: "0-50ms"},
+	// updater is a software updater downloading a package: full-size records back to back
+	"updater": {Records: []int{16384}, Delay: "0-2ms"},
+	// video is a real-time video call: small, steady records at a regular interval
+	"video": {Records: []int{1100, 1150, 1200, 1250, 1300}, Delay: "10ms-30ms"},
+}
+
+// Profile is the traffic shape the TLS records are written in
+//
+// Example:
+//
+//	{"preset": "video", "padHeader": "X-Client-Trace"}
+//	{"records": [1200, 1350], "delay": "10ms-30ms"}
+type Profile struct {
+	Preset    string `json:"preset"`    // Preset is a built-in profile that fills in the records and delay not provided: browsing, updater, or video
+	Records   []int  `json:"records"`   // Records are the TLS record plaintext sizes in bytes, one is picked at random for each record
+	Delay     string `json:"delay"`     // Delay is the time, or min-max range of times, waited between records (e.g., 10ms-30ms)
+	PadHeader string `json:"padHeader"` // PadHeader is the HTTP header filled with random data to pad requests toward whole records; empty doesn't pad
+	min, max  time.Duration
+}
+
+// Init applies the preset and validates the profile
+func (p *Profile) Init() error {
+	if p.Preset != "" {
+		preset, ok := presets[strings.ToLower(p.Preset)]
+		if !ok {
+			return fmt.Errorf("clients/shape.Init(): unknown traffic shaping preset: %s", p.Preset)
+		}
+		if len(p.Records) == 0 {
+			p.Records = preset.Records
+		}
+		if p.Delay == "" {
+			p.Delay = preset.Delay
+		}
+	}
+	if len(p.Records) == 0 {
+		return fmt.Errorf("clients/shape.Init(): a traffic shaping profile requires a preset or record sizes")
+	}
+	for _, size := range p.Records {
+		if size < 1 || size > 16384 {
+			return fmt.Errorf("clients/shape.Init(): record sizes must be between 1 and 16384 bytes but received %d", size)
+		}
+	}
+	if p.Delay != "" {
+		low, high, found := strings.Cut(p.Delay, "-")
+		var err error
+		p.min, err = duration(low)
+		if err != nil {
+			return fmt.Errorf("clients/shape.Init(): %s", err)
+		}
+		p.max = p.min
+		if found {
+			p.max, err = duration(high)
+			if err != nil {
+				return fmt.Errorf("clients/shape.Init(): %s", err)
+			}
+		}
+		if p.max < p.min {
+			return fmt.Errorf("clients/shape.Init(): the delay's maximum %s is less than its minimum %s", p.max, p.min)
+		}
+	}
+	return nil
+}
+
+// duration parses a duration where a bare 0 doesn't need a unit
+func duration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "0" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error parsing the delay %s: %s", value, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("the delay must be 0 or greater but received %s", d)
+	}
+	return d, nil
+}
+
+// String returns a description of the profile
+func (p *Profile) String() string {
+	return fmt.Sprintf("records: %v, delay: %s-%s", p.Records, p.min, p.max)
+}
+
+// record returns the size of the next record
+func (p *Profile) record() int {
+	return p.Records[rand.Intn(len(p.Records))] // #nosec G404 - Does not need to be cryptographically secure
+}
+
+// delay returns the time to wait before the next record
+func (p *Profile) delay() time.Duration {
+	if p.max <= p.min {
+		return p.min
+	}
+	return p.min + time.Duration(rand.Int63n(int64(p.max-p.min))) // #nosec G404 - Does not need to be cryptographically secure
+}
+
+// Pad returns the number of bytes to add to a request of n bytes so that it fills whole records of the largest size.
+// It returns 0 when the profile doesn't have a pad header
+func (p *Profile) Pad(n int) int {
+	if p.PadHeader == "" {
+		return 0
+	}
+	var largest int
+	for _, size := range p.Records {
+		if size > largest {
+			largest = size
+		}
+	}
+	if n%largest == 0 {
+		return 0
+	}
+	return largest - n%largest
+}
+
+// Wrap returns a connection that writes the data in the profile's record sizes with the profile's delay between them.
+// The connection must be the TLS connection, so that every write is its own TLS record
+func (p *Profile) Wrap(conn net.Conn) net.Conn {
+	return &Conn{Conn: conn, profile: p}
+}
+
+// Conn is a TLS connection whose writes are shaped by a Profile
+type Conn struct {
+	net.Conn
+	profile *Profile
+}
+
+// Write splits the data into records and writes them one at a time, waiting between each
+func (c *Conn) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		size := c.profile.record()
+		if size > len(b) {
+			size = len(b)
+		}
+		var written int
+		written, err = c.Conn.Write(b[:size])
+		n += written
+		if err != nil {
+			return
+		}
+		b = b[size:]
+		if len(b) > 0 {
+			time.Sleep(c.profile.delay())
+		}
+	}
+	return
+}
+
+// ConnectionState returns the underlying TLS connection's state, used by HTTP/2 to verify the negotiated protocol
+func (c *Conn) ConnectionState() tls.ConnectionState {
+	if tc, ok := c.Conn.(*tls.Conn); ok {
+		return tc.ConnectionState()
+	}
+	return tls.ConnectionState{}
+}
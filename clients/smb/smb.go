@@ -65,9 +65,12 @@ type Config struct {
 	AuthPackage  string    // AuthPackage the type of authentication the agent should use when communicating with the server
 	ListenerID   uuid.UUID // ListenerID the UUID of the listener that this Agent is configured to communicate with
 	Padding      string    // Padding the max amount of data that will be randomly selected and appended to every message
+	Throttle     string    // Throttle the maximum rate, in bytes per second, that data is sent (e.g., 512K); empty is unlimited
+	Rekey        string    // Rekey is the message count and/or interval (e.g., 100,30m) after which a new secret is derived; empty never rekeys
 	PSK          string    // PSK the Pre-Shared Key secret the agent will use to start authentication
 	Transformers string    // Transformers is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message
 	Mode         string    // Mode the type of client or communication mode (e.g., BIND or REVERSE)
+	Obfuscation  string    // Obfuscation the wrapper used to disguise the named pipe traffic (e.g., dcerpc or dcerpc:spoolss)
 }
 
 // New instantiates and returns a Client that is constructed from the passed in Config
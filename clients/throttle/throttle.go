@@ -0,0 +1,188 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package throttle limits the rate, in bytes per second, that a client sends data to reduce network volume spikes
+package throttle
+
+import (
+	// Standard
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket rate limiter measured in bytes per second. A rate of 0 is unlimited
+type Limiter struct {
+	rate   int       // rate is the maximum number of bytes per second
+	tokens float64   // tokens is the number of bytes that can currently be sent without waiting
+	last   time.Time // last is the last time tokens were added to the bucket
+	sync.Mutex
+}
+
+// New returns a Limiter for the provided rate string in bytes per second. The rate can use a K, M, or G suffix
+// (e.g., 512K). An empty string or 0 is unlimited
+func New(rate string) (*Limiter, error) {
+	l := &Limiter{}
+	err := l.Set(rate)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Parse converts a rate string, with an optional K, M, or G suffix, into bytes per second
+func Parse(rate string) (int, error) {
+	rate = strings.ToUpper(strings.TrimSpace(rate))
+	rate = strings.TrimSuffix(strings.TrimSuffix(rate, "/S"), "B")
+	if rate == "" {
+		return 0, nil
+	}
+	multiplier := 1
+	switch rate[len(rate)-1] {
+	case 'K':
+		multiplier = 1024
+	case 'M':
+		multiplier = 1024 * 1024
+	case 'G':
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		rate = rate[:len(rate)-1]
+	}
+	i, err := strconv.Atoi(rate)
+	if err != nil {
+		return 0, fmt.Errorf("clients/throttle.Parse(): there was an error converting the rate %s to an integer: %s", rate, err)
+	}
+	if i < 0 {
+		return 0, fmt.Errorf("clients/throttle.Parse(): the rate must be 0 or greater but received %d", i)
+	}
+	return i * multiplier, nil
+}
+
+// Set updates the Limiter's rate from the provided rate string
+func (l *Limiter) Set(rate string) error {
+	i, err := Parse(rate)
+	if err != nil {
+		return err
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.rate = i
+	l.tokens = float64(i)
+	l.last = time.Now()
+	return nil
+}
+
+// Rate returns the Limiter's rate in bytes per second
+func (l *Limiter) Rate() int {
+	l.Lock()
+	defer l.Unlock()
+	return l.rate
+}
+
+// Wait blocks until n bytes can be sent without exceeding the Limiter's rate
+func (l *Limiter) Wait(n int) {
+	l.Lock()
+	if l.rate <= 0 {
+		l.Unlock()
+		return
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.Unlock()
+	time.Sleep(wait)
+}
+
+// chunk returns the number of bytes to send at once so that large writes are spread out instead of sent in a burst
+func (l *Limiter) chunk() int {
+	rate := l.Rate()
+	if rate <= 0 {
+		return 0
+	}
+	// Send about 10 chunks a second
+	if rate/10 < 1 {
+		return 1
+	}
+	return rate / 10
+}
+
+// Write writes data to the provided io.Writer in chunks, waiting between each one, so the Limiter's rate is not exceeded
+func (l *Limiter) Write(w io.Writer, data []byte) (n int, err error) {
+	size := l.chunk()
+	if size == 0 {
+		return w.Write(data)
+	}
+	for n < len(data) {
+		stop := n + size
+		if stop > len(data) {
+			stop = len(data)
+		}
+		l.Wait(stop - n)
+		var i int
+		i, err = w.Write(data[n:stop])
+		n += i
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Reader wraps the provided io.Reader so that reads from it, such as an HTTP request body, do not exceed the Limiter's rate
+func (l *Limiter) Reader(r io.Reader) io.Reader {
+	return &reader{reader: r, limiter: l}
+}
+
+// reader is an io.Reader that waits on a Limiter before returning data
+type reader struct {
+	reader  io.Reader
+	limiter *Limiter
+}
+
+// Read reads up to one chunk of data and waits for the Limiter before returning it
+func (r *reader) Read(p []byte) (int, error) {
+	if size := r.limiter.chunk(); size > 0 && len(p) > size {
+		p = p[:size]
+	}
+	n, err := r.reader.Read(p)
+	r.limiter.Wait(n)
+	return n, err
+}
+
+// String returns the Limiter's rate as a string
+func (l *Limiter) String() string {
+	rate := l.Rate()
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d bytes/second", rate)
+}
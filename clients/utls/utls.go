@@ -52,6 +52,7 @@ import (
 	"context"
 	"crypto/sha256"
 	t "crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"math/rand"
 	"net"
@@ -125,14 +126,16 @@ var tlsExtensions = map[string]tls.TLSExtension{
 }
 
 // NewTransportFromJA3 creates a new http.Transport object given an utls.Config
-func NewTransportFromJA3(ja3 string, InsecureSkipVerify bool, proxy func(*http.Request) (*url.URL, error)) (*Transport, error) {
+// If verify is not nil, it is used to verify the server's certificate (e.g., certificate pinning)
+func NewTransportFromJA3(ja3 string, InsecureSkipVerify bool, verify func([][]byte, [][]*x509.Certificate) error, proxy func(*http.Request) (*url.URL, error)) (*Transport, error) {
 	spec, err := JA3toClientHello(ja3)
 	if err != nil {
 		return nil, err
 	}
 
 	tlsConfig := &t.Config{
-		InsecureSkipVerify: InsecureSkipVerify, // #nosec G402 - intentionally configurable to allow self-signed certificates
+		InsecureSkipVerify:    InsecureSkipVerify, // #nosec G402 - intentionally configurable to allow self-signed certificates
+		VerifyPeerCertificate: verify,
 	}
 
 	transport := Transport{
@@ -147,14 +150,16 @@ func NewTransportFromJA3(ja3 string, InsecureSkipVerify bool, proxy func(*http.R
 
 // NewTransportFromParrot takes in a string that represents a ClientHelloID to parrot a TLS connection that
 // looks like an associated browser and returns a http transport structure
-func NewTransportFromParrot(parrot string, InsecureSkipVerify bool, proxy func(*http.Request) (*url.URL, error)) (*Transport, error) {
+// If verify is not nil, it is used to verify the server's certificate (e.g., certificate pinning)
+func NewTransportFromParrot(parrot string, InsecureSkipVerify bool, verify func([][]byte, [][]*x509.Certificate) error, proxy func(*http.Request) (*url.URL, error)) (*Transport, error) {
 	clientHello, err := ParrotStringToClientHelloID(parrot)
 	if err != nil {
 		return nil, err
 	}
 
 	tlsConfig := &t.Config{
-		InsecureSkipVerify: InsecureSkipVerify, // #nosec G402 - intentionally configurable to allow self-signed certificates
+		InsecureSkipVerify:    InsecureSkipVerify, // #nosec G402 - intentionally configurable to allow self-signed certificates
+		VerifyPeerCertificate: verify,
 	}
 
 	transport := Transport{
@@ -487,8 +492,9 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 func (t *Transport) tlsConnect(conn net.Conn, req *http.Request) (*tls.UConn, error) {
 	t.mu.RLock()
 	config := &tls.Config{
-		ServerName:         req.URL.Host,
-		InsecureSkipVerify: t.tr1.TLSClientConfig.InsecureSkipVerify,
+		ServerName:            req.URL.Host,
+		InsecureSkipVerify:    t.tr1.TLSClientConfig.InsecureSkipVerify,
+		VerifyPeerCertificate: t.tr1.TLSClientConfig.VerifyPeerCertificate,
 	}
 
 	tlsConn := tls.UClient(conn, config, t.clientHello)
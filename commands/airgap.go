@@ -0,0 +1,61 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/airgap"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/frame"
+)
+
+// This airgap.go file is part of the "link" command and is not a standalone command
+
+// ConnectAirGap watches the directory an operator carries messages into from an air-gapped peer-to-peer Agent and
+// writes the messages for it to the same directory. The Link is added when the first message from the Agent is ingested
+func ConnectAirGap(addr string) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("commands/airgap.ConnectAirGap(): entering into function with address: %s", addr))
+
+	dir, _ := airgap.Parse(addr)
+	if link, ok := peerToPeerService.Connected(p2p.AIRGAP, dir); ok {
+		results.Stderr = fmt.Sprintf("already relaying messages through %s for %s", link.Remote(), link.ID())
+		return
+	}
+
+	conn, err := airgap.Parent(addr)
+	if err != nil {
+		results.Stderr = fmt.Sprintf("commands/airgap.ConnectAirGap(): %s", err)
+		return
+	}
+
+	// The listen function is in commands/listener.go
+	go listen(conn, frame.NewReader(conn), p2p.AIRGAP)
+
+	results.Stdout = fmt.Sprintf("Watching %s for messages from an air-gapped Agent every %s", conn.RemoteAddr(), airgap.Poll)
+	return
+}
@@ -0,0 +1,104 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"time"
+
+	// Merlin Main
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/bridge"
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// Bridge runs operator-supplied external helper binaries and Go plugins and manages the artifacts they leave behind
+// bridge helper <path> [--timeout=<duration>] [args...]
+// bridge plugin <path> [--timeout=<duration>] [args...]
+// bridge artifacts
+// bridge cleanup
+func Bridge(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Bridge() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the bridge module"
+		return
+	}
+
+	switch strings.ToLower(cmd.Args[0]) {
+	case "helper", "plugin":
+		if len(cmd.Args) < 2 {
+			results.Stderr = fmt.Sprintf("the bridge %s command requires a file path", cmd.Args[0])
+			return
+		}
+		path := cmd.Args[1]
+		args := cmd.Args[2:]
+		var timeout time.Duration
+		if len(args) > 0 {
+			if value, ok := strings.CutPrefix(args[0], "--timeout="); ok {
+				var err error
+				timeout, err = time.ParseDuration(value)
+				if err != nil {
+					results.Stderr = fmt.Sprintf("there was an error parsing the timeout %s: %s", value, err)
+					return
+				}
+				args = args[1:]
+			}
+		}
+
+		var response bridge.Response
+		var err error
+		if strings.ToLower(cmd.Args[0]) == "helper" {
+			response, err = bridge.Helper(path, args, timeout)
+		} else {
+			response, err = bridge.Plugin(path, args, timeout)
+		}
+		if err != nil {
+			results.Stderr = err.Error()
+			return
+		}
+		results.Stdout = response.Stdout
+		results.Stderr = response.Stderr
+	case "artifacts":
+		list := bridge.Artifacts()
+		if len(list) == 0 {
+			results.Stdout = "No artifacts are being tracked"
+			return
+		}
+		results.Stdout = strings.Join(list, "\n")
+	case "cleanup":
+		removed, err := bridge.Cleanup()
+		results.Stdout = fmt.Sprintf("Removed %d artifacts", len(removed))
+		for _, path := range removed {
+			results.Stdout += "\n\t" + path
+		}
+		if err != nil {
+			results.Stderr = err.Error()
+		}
+	default:
+		results.Stderr = fmt.Sprintf("unrecognized bridge command: %s", cmd.Args[0])
+	}
+	return
+}
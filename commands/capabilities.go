@@ -0,0 +1,56 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+
+	// Merlin Main
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	merlinOS "github.com/Ne0nd0g/merlin-agent/v2/os"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/capability"
+)
+
+// Capabilities returns the detected operating system version and locale along with the technique variant that will be
+// selected on this host for every technique that adapts to the operating system version
+func Capabilities() (results jobs.Results) {
+	cli.Message(cli.DEBUG, "entering Capabilities()")
+
+	version, err := merlinOS.GetVersion()
+	if err != nil {
+		results.Stderr = fmt.Sprintf("there was an error detecting the operating system version: %s", err)
+		return
+	}
+	results.Stdout = fmt.Sprintf("Platform: %s\nVersion: %s\nLocale: %s\n", version.Platform, version, version.Locale)
+
+	for _, technique := range capability.Techniques() {
+		variant, _, err := capability.Select(technique)
+		if err != nil {
+			variant = fmt.Sprintf("unsupported (%s)", err)
+		}
+		results.Stdout += fmt.Sprintf("Technique %s: %s\n", technique, variant)
+	}
+	return
+}
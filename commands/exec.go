@@ -26,12 +26,14 @@ import (
 	// Standard
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 )
 
 // ExecuteCommand is a function used to instruct an agent to execute a command on the host operating system
-func executeCommand(name string, args []string) (stdout string, stderr string) {
+func executeCommand(name string, args []string, opts execOptions) (stdout string, stderr string) {
 	cmd := exec.Command(name, args...) // #nosec G204
+	cmd.Env = opts.environ(os.Environ())
 
 	out, err := cmd.CombinedOutput()
 	if cmd.Process != nil {
@@ -49,35 +51,42 @@ func executeCommand(name string, args []string) (stdout string, stderr string) {
 // ExecuteShellcodeSelf executes provided shellcode in the current process
 //
 //lint:ignore SA4009 Function needs to mirror exec_windows.go and inputs must be used
-func ExecuteShellcodeSelf(shellcode []byte) error {
+func ExecuteShellcodeSelf(shellcode []byte, allocator string) error {
 	return errors.New("shellcode execution is not implemented for this operating system")
 }
 
 // ExecuteShellcodeRemote executes provided shellcode in the provided target process
 //
 //lint:ignore SA4009 Function needs to mirror exec_windows.go and inputs must be used
-func ExecuteShellcodeRemote(shellcode []byte, pid uint32) error {
+func ExecuteShellcodeRemote(shellcode []byte, pid uint32, allocator string) error {
 	return errors.New("shellcode execution is not implemented for this operating system")
 }
 
 // ExecuteShellcodeRtlCreateUserThread executes provided shellcode in the provided target process using the Windows RtlCreateUserThread call
 //
 //lint:ignore SA4009 Function needs to mirror exec_windows.go and inputs must be used
-func ExecuteShellcodeRtlCreateUserThread(shellcode []byte, pid uint32) error {
+func ExecuteShellcodeRtlCreateUserThread(shellcode []byte, pid uint32, allocator string) error {
 	return errors.New("shellcode execution is not implemented for this operating system")
 }
 
 // ExecuteShellcodeQueueUserAPC executes provided shellcode in the provided target process using the Windows QueueUserAPC API call
 //
 //lint:ignore SA4009 Function needs to mirror exec_windows.go and inputs must be used
-func ExecuteShellcodeQueueUserAPC([]byte, uint32) error {
+func ExecuteShellcodeQueueUserAPC([]byte, uint32, string) error {
 	return errors.New("shellcode execution is not implemented for this operating system")
 }
 
+// ExecuteShellcodeEarlyBird creates a suspended process and executes shellcode in it with an APC queued to its first thread
+//
+//lint:ignore SA4009 Function needs to mirror exec_windows.go and inputs must be used
+func ExecuteShellcodeEarlyBird([]byte, uint32) (uint32, error) {
+	return 0, errors.New("shellcode execution is not implemented for this operating system")
+}
+
 // ExecuteShellcodeCreateProcessWithPipe creates a child process, redirects STDOUT/STDERR to an anonymous pipe, injects/executes shellcode, and retrieves output
 //
 //lint:ignore SA4009 Function needs to mirror exec_windows.go and inputs must be used
-func ExecuteShellcodeCreateProcessWithPipe(string, string, string) (stdout string, stderr string, err error) {
+func ExecuteShellcodeCreateProcessWithPipe(string, string, string, string) (stdout string, stderr string, err error) {
 	return stdout, stderr, fmt.Errorf("CreateProcess modules in not implemented for this operating  system")
 }
 
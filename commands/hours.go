@@ -0,0 +1,84 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/schedule"
+)
+
+// Hours manages the working hours, the days and times of day the Agent checks in. Outside the working hours, the Agent
+// sleeps until the next working window starts
+// hours status         - Returns the working hours and the locale and timezone they were derived from
+// hours auto           - Derives the working hours from the host's locale and timezone and returns them to confirm
+// hours confirm        - Confirms the derived working hours
+// hours set <hours>    - Sets the working hours (e.g., 08:00-18:00,mon-fri or 22:00-06:00,sun-thu)
+// hours off            - Checks in at all times
+func Hours(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Hours() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the hours module"
+		return
+	}
+
+	var err error
+	switch strings.ToLower(cmd.Args[0]) {
+	case "status":
+		results.Stdout = schedule.String()
+	case "auto":
+		results.Stdout = schedule.Detect()
+	case "confirm":
+		err = schedule.Confirm()
+		if err == nil {
+			results.Stdout = schedule.String()
+		}
+	case "set":
+		if len(cmd.Args) < 2 {
+			results.Stderr = "the hours set command requires the working hours (e.g., 08:00-18:00,mon-fri)"
+			return
+		}
+		err = schedule.Set(strings.Join(cmd.Args[1:], ","))
+		if err == nil {
+			results.Stdout = schedule.String()
+		}
+	case "off":
+		err = schedule.Set("off")
+		if err == nil {
+			results.Stdout = schedule.String()
+		}
+	default:
+		results.Stderr = fmt.Sprintf("unrecognized hours command: %s", cmd.Args[0])
+		return
+	}
+	if err != nil {
+		results.Stderr = fmt.Sprintf("there was an error executing the hours %s command: %s", strings.ToLower(cmd.Args[0]), err)
+	}
+	return
+}
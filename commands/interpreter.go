@@ -0,0 +1,137 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// interpreter is the Agent's default command interpreter for the shell command. An empty string uses the operating
+// system's default shell
+var interpreter string
+
+// interpreterLock protects the interpreter from concurrent jobs
+var interpreterLock sync.RWMutex
+
+// safeArgument matches arguments that do not need to be quoted for any interpreter
+var safeArgument = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./\\-]+$`)
+
+// Interpreter returns the program and leading arguments used to run a command string with the named interpreter
+// Valid interpreters are cmd, powershell, pwsh, bash, zsh, and sh
+func Interpreter(name string) (program string, args []string, err error) {
+	switch strings.ToLower(name) {
+	case "cmd", "cmd.exe":
+		return "cmd.exe", []string{"/c"}, nil
+	case "powershell", "powershell.exe":
+		return "powershell.exe", []string{"-NoProfile", "-NonInteractive", "-Command"}, nil
+	case "pwsh", "pwsh.exe":
+		if runtime.GOOS == "windows" {
+			return "pwsh.exe", []string{"-NoProfile", "-NonInteractive", "-Command"}, nil
+		}
+		return "pwsh", []string{"-NoProfile", "-NonInteractive", "-Command"}, nil
+	case "bash", "zsh", "sh":
+		return strings.ToLower(name), []string{"-c"}, nil
+	default:
+		return "", nil, fmt.Errorf("unhandled interpreter: %s", name)
+	}
+}
+
+// GetInterpreter returns the Agent's default command interpreter for the shell command
+func GetInterpreter() string {
+	interpreterLock.RLock()
+	defer interpreterLock.RUnlock()
+	return interpreter
+}
+
+// SetInterpreter sets the Agent's default command interpreter for the shell command.
+// An empty string or "default" resets it to the operating system's default shell
+func SetInterpreter(name string) error {
+	if name == "" || strings.ToLower(name) == "default" {
+		name = ""
+	} else if _, _, err := Interpreter(name); err != nil {
+		return fmt.Errorf("commands/interpreter.SetInterpreter(): %s", err)
+	}
+	interpreterLock.Lock()
+	interpreter = strings.ToLower(name)
+	interpreterLock.Unlock()
+	return nil
+}
+
+// Quote returns the provided argument quoted so the named interpreter treats it as a single literal argument
+func Quote(name string, arg string) string {
+	if arg != "" && safeArgument.MatchString(arg) {
+		return arg
+	}
+	switch strings.ToLower(name) {
+	case "cmd", "cmd.exe":
+		return `"` + strings.ReplaceAll(arg, `"`, `""`) + `"`
+	case "powershell", "powershell.exe", "pwsh", "pwsh.exe":
+		return "'" + strings.ReplaceAll(arg, "'", "''") + "'"
+	default:
+		return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+}
+
+// shellWithOptions parses the options for the shell command and runs it with the selected interpreter
+// shell [--shell=<interpreter>] [--quote] <command> [arguments...]
+// The --shell option selects the interpreter for this job only and overrides the Agent's default interpreter.
+// The --quote option quotes every argument for the interpreter instead of joining them as is
+// The process context options, if any, were already removed by parseExecOptions and are provided in opts
+func shellWithOptions(args []string, opts execOptions) (stdout string, stderr string) {
+	name := GetInterpreter()
+	var quote bool
+	for len(args) > 0 {
+		if strings.HasPrefix(args[0], "--shell=") {
+			name = strings.TrimPrefix(args[0], "--shell=")
+		} else if args[0] == "--quote" {
+			quote = true
+		} else {
+			break
+		}
+		args = args[1:]
+	}
+
+	// Use the operating system's default shell
+	if name == "" {
+		if quote {
+			return "", "the --quote option requires an interpreter, use --shell=<interpreter>"
+		}
+		return shell(args, opts)
+	}
+
+	program, arguments, err := Interpreter(name)
+	if err != nil {
+		return "", fmt.Sprintf("commands/interpreter.shellWithOptions(): %s", err)
+	}
+	if quote {
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = Quote(name, arg)
+		}
+		args = quoted
+	}
+	return executeCommand(program, append(arguments, strings.Join(args, " ")), opts)
+}
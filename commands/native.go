@@ -179,7 +179,7 @@ func list(path string) (details string, err error) {
 		}
 		perms := f.Mode().String()
 		size := strconv.FormatInt(f.Size(), 10)
-		modTime := f.ModTime().String()[0:19]
+		modTime := normalizeTime(f.ModTime())
 		name := f.Name()
 		details = details + perms + "\t" + modTime + "\t" + size + "\t" + name + "\n"
 	}
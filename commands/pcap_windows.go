@@ -0,0 +1,114 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unsafe"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/wpcap"
+	"github.com/Ne0nd0g/merlin-agent/v2/pcap"
+)
+
+// npcap captures packets with the Npcap driver, which must be installed
+type npcap struct {
+	handle uintptr
+}
+
+// openCapture opens the Npcap device, listed with pcap interfaces, and sets the filter. Filter expressions are compiled
+// by Npcap; programs compiled with tcpdump -dd are also accepted
+func openCapture(device, filter string, snaplen int) (capture, error) {
+	if strings.ToLower(device) == "any" {
+		return nil, fmt.Errorf("Npcap can't capture on every interface at once, use an interface from the pcap interfaces command")
+	}
+	handle, err := wpcap.OpenLive(device, snaplen, false, int(pcapTimeout.Milliseconds()))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case filter == "":
+	case pcap.IsProgram(filter):
+		var program []pcap.Instruction
+		program, err = pcap.ParseProgram(filter)
+		if err == nil {
+			err = wpcap.SetFilter(handle, program)
+		}
+	default:
+		err = wpcap.Compile(handle, filter)
+	}
+	if err != nil {
+		wpcap.Close(handle)
+		return nil, err
+	}
+	return &npcap{handle: handle}, nil
+}
+
+// Read returns the next packet or nil data when the read timed out
+func (n *npcap) Read() ([]byte, int, time.Time, error) {
+	ret, header, data, err := wpcap.NextEx(n.handle)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	switch ret {
+	case 1:
+	case 0:
+		return nil, 0, time.Time{}, nil
+	default:
+		return nil, 0, time.Time{}, fmt.Errorf("there was an error calling wpcap!pcap_next_ex: %s", wpcap.GetErr(n.handle))
+	}
+	packet := make([]byte, header.Caplen)
+	copy(packet, unsafe.Slice(data, header.Caplen))
+	return packet, int(header.Len), time.Unix(int64(header.Sec), int64(header.Usec)*1000), nil
+}
+
+// LinkType returns the link-layer header type of the Npcap device
+func (n *npcap) LinkType() uint32 {
+	return wpcap.DataLink(n.handle)
+}
+
+// Close closes the Npcap handle
+func (n *npcap) Close() error {
+	wpcap.Close(n.handle)
+	return nil
+}
+
+// captureInterfaces lists the Npcap devices and their descriptions
+func captureInterfaces() (string, error) {
+	devices, err := wpcap.FindAllDevs()
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var list string
+	for _, name := range names {
+		list += fmt.Sprintf("%s %s\n", name, devices[name])
+	}
+	return list, nil
+}
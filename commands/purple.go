@@ -0,0 +1,80 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/purple"
+)
+
+// Purple manages the purple-team detonation mode where selected commands also generate harmless, labeled artifacts
+// purple commands <list>  - Sets the comma separated list of commands that generate artifacts, * for all or none to stop
+// purple list             - Returns the artifacts that were generated and not yet cleaned up
+// purple cleanup          - Removes the marker files and registry keys that were generated
+func Purple(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Purple() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the purple module"
+		return
+	}
+
+	var err error
+	switch strings.ToLower(cmd.Args[0]) {
+	case "commands":
+		if len(cmd.Args) < 2 {
+			results.Stderr = "the purple commands command requires the comma separated list of commands"
+			return
+		}
+		value := cmd.Args[1]
+		if strings.ToLower(value) == "none" {
+			value = ""
+		}
+		purple.SetCommands(value)
+		results.Stdout = fmt.Sprintf("Purple-team artifacts are generated for: %s", value)
+	case "list":
+		for _, artifact := range purple.Artifacts() {
+			results.Stdout += artifact.String() + "\n"
+		}
+		if results.Stdout == "" {
+			results.Stdout = "There are no purple-team artifacts"
+		}
+	case "cleanup":
+		var n int
+		n, err = purple.Cleanup()
+		results.Stdout = fmt.Sprintf("Removed %d purple-team artifacts", n)
+	default:
+		results.Stderr = fmt.Sprintf("unrecognized purple command: %s", cmd.Args[0])
+		return
+	}
+	if err != nil {
+		results.Stderr = fmt.Sprintf("there was an error executing the purple %s command: %s", strings.ToLower(cmd.Args[0]), err)
+	}
+	return
+}
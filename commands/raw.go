@@ -0,0 +1,244 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/core"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p"
+)
+
+// rawProtocol parses the optional IP protocol number argument used with raw IP links and listeners
+func rawProtocol(args []string) (int, error) {
+	if len(args) < 1 || args[0] == "" {
+		return p2p.RawProtocol, nil
+	}
+	proto, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("there was an error converting the IP protocol number %s to an integer: %s", args[0], err)
+	}
+	if proto < 0 || proto > 255 {
+		return 0, fmt.Errorf("the IP protocol number must be between 0 and 255 but received %d", proto)
+	}
+	return proto, nil
+}
+
+// ConnectRaw establishes a raw IP connection, using the provided IP protocol number, to a raw-bind peer-to-peer Agent
+// args[0] = target IP address (e.g., 192.168.1.10), args[1] = optional IP protocol number (e.g., 253)
+func ConnectRaw(args []string) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("commands/raw.ConnectRaw(): entering into function with args: %+v", args))
+
+	if len(args) <= 0 {
+		results.Stderr = fmt.Sprintf("Expected 1 argument, received %d", len(args))
+		return
+	}
+
+	proto, err := rawProtocol(args[1:])
+	if err != nil {
+		results.Stderr = fmt.Sprintf("commands/raw.ConnectRaw(): %s", err)
+		return
+	}
+
+	// See if there is already a link or connection to the target IP
+	link, ok := peerToPeerService.Connected(p2p.RAWBIND, args[0])
+	if ok {
+		results.Stderr = fmt.Sprintf("already connected to %s: %s:%s\n", link.Remote(), link.String(), link.ID())
+		return
+	}
+
+	// Establish connection to downstream agent
+	conn, err := net.Dial(fmt.Sprintf("ip4:%d", proto), args[0])
+	if err != nil {
+		results.Stderr = fmt.Sprintf("commands/raw.ConnectRaw(): there was an error attempting to link the agent: %s", err)
+		return
+	}
+
+	// We must first write data to the raw IP connection to let the raw-bind Agent know we're listening and ready
+	junk := core.RandStringBytesMaskImprSrc(rand.Intn(100)) // #nosec G404 random number is not used for secrets
+	b64 := make([]byte, base64.StdEncoding.EncodedLen(len(junk)))
+	base64.StdEncoding.Encode(b64, []byte(junk))
+	cli.Message(cli.NOTE, fmt.Sprintf("Initiating raw IP connection to %s with IP protocol %d sending junk data: %s", conn.RemoteAddr(), proto, junk))
+	n, err := conn.Write(p2p.WrapRaw(b64, p2p.RawDownstream, 0, 1))
+	if err != nil {
+		results.Stderr = fmt.Sprintf("commands/raw.ConnectRaw(): there was an error writing data to the raw IP connection: %s", err)
+		return
+	}
+	cli.Message(cli.DEBUG, fmt.Sprintf("commands/raw.ConnectRaw(): Wrote %d bytes to connection %s at %s", n, conn.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
+
+	// Wait for linked agent first checkin message
+	cli.Message(cli.NOTE, fmt.Sprintf("Waiting to recieve raw IP connection from %s at %s...", conn.RemoteAddr(), time.Now().UTC().Format(time.RFC3339)))
+	msg, _, err := readRaw(conn.(net.PacketConn), make(map[string]*bytes.Buffer))
+	if err != nil {
+		results.Stderr = fmt.Sprintf("commands/raw.ConnectRaw(): %s", err)
+		cli.Message(cli.WARN, results.Stderr)
+		return
+	}
+
+	// Store LinkedAgent
+	linkedAgent := p2p.NewLink(msg.Agent, msg.Listener, conn, p2p.RAWBIND, conn.RemoteAddr())
+	peerToPeerService.AddLink(linkedAgent)
+
+	peerToPeerService.AddDelegate(msg)
+
+	results.Stdout = fmt.Sprintf("Successfully connected to %s Agent %s at %s with IP protocol %d", linkedAgent.String(), msg.Agent, args[0], proto)
+
+	go listenRaw(conn.(net.PacketConn), p2p.RAWBIND)
+	return
+}
+
+// ListenRaw opens a raw IP socket for the provided IP protocol number on the provided interface and listens for incoming
+// connections from raw-reverse peer-to-peer Agents
+func ListenRaw(addr string, args []string) error {
+	proto, err := rawProtocol(args)
+	if err != nil {
+		return fmt.Errorf("commands/raw.ListenRaw(): %s", err)
+	}
+	listener, err := net.ListenPacket(fmt.Sprintf("ip4:%d", proto), addr)
+	if err != nil {
+		return fmt.Errorf("commands/raw.ListenRaw(): there was an error listening on %s for IP protocol %d: %s", addr, proto, err)
+	}
+	cli.Message(cli.NOTE, fmt.Sprintf("Started raw IP listener on %s for IP protocol %d and waiting for a connection...", addr, proto))
+
+	p2pListeners = append(p2pListeners, p2pListener{
+		Addr:     listener.LocalAddr().String(),
+		Type:     RAW,
+		Listener: listener,
+	})
+
+	go listenRaw(listener, p2p.RAWREVERSE)
+	return nil
+}
+
+// readRaw reads raw IP fragments from the connection until a complete TLV message is received from a single sender and
+// returns it as a Delegate message. Fragments without a valid upstream raw IP header are discarded.
+// The buffers map tracks partial messages for each sender address
+func readRaw(conn net.PacketConn, buffers map[string]*bytes.Buffer) (msg messages.Delegate, addr net.Addr, err error) {
+	var buff *bytes.Buffer
+	for {
+		var n int
+		data := make([]byte, p2p.MaxSizeRaw+p2p.RawHeaderSize)
+		n, addr, err = conn.ReadFrom(data)
+		if err != nil {
+			err = fmt.Errorf("there was an error reading from the raw IP connection %s: %s", conn.LocalAddr(), err)
+			return
+		}
+
+		payload, fragment, total, errUnwrap := p2p.UnwrapRaw(data[:n], p2p.RawUpstream)
+		if errUnwrap != nil {
+			cli.Message(cli.DEBUG, fmt.Sprintf("commands/raw.readRaw(): discarding %d bytes from %s: %s", n, addr, errUnwrap))
+			continue
+		}
+		cli.Message(cli.DEBUG, fmt.Sprintf("commands/raw.readRaw(): Read fragment %d of %d, %d bytes, from %s at %s", fragment+1, total, n, addr, time.Now().UTC().Format(time.RFC3339)))
+
+		// The first fragment starts a new message for the sender
+		if fragment == 0 {
+			buffers[addr.String()] = new(bytes.Buffer)
+		}
+		var ok bool
+		buff, ok = buffers[addr.String()]
+		if !ok {
+			cli.Message(cli.DEBUG, fmt.Sprintf("commands/raw.readRaw(): discarding out of order fragment %d of %d from %s", fragment+1, total, addr))
+			continue
+		}
+		buff.Write(payload)
+		if fragment+1 >= total {
+			delete(buffers, addr.String())
+			break
+		}
+	}
+	cli.Message(cli.NOTE, fmt.Sprintf("Raw IP connection on %s read %d bytes from %s at %s", conn.LocalAddr(), buff.Len(), addr, time.Now().UTC().Format(time.RFC3339)))
+
+	// Type/Tag size is 4-bytes, Length size is 8-bytes for TLV
+	if buff.Len() < 12 {
+		err = fmt.Errorf("need at least 12 bytes in the buffer to read the TLV but only have %d", buff.Len())
+		return
+	}
+	tag := binary.BigEndian.Uint32(buff.Bytes()[:4])
+	if tag != 1 {
+		err = fmt.Errorf("expected a type/tag value of 1 for TLV but got %d", tag)
+		return
+	}
+	length := binary.BigEndian.Uint64(buff.Bytes()[4:12])
+	if uint64(buff.Len()) != length+4+8 {
+		err = fmt.Errorf("expected %d bytes of data but received %d", length, buff.Len()-12)
+		return
+	}
+
+	// Gob decode the message
+	err = gob.NewDecoder(bytes.NewReader(buff.Bytes()[12:])).Decode(&msg)
+	if err != nil {
+		err = fmt.Errorf("there was an error gob decoding a delegate message: %s", err)
+	}
+	return
+}
+
+// listenRaw is an infinite loop, used as a go routine, to receive data from raw IP connections and subsequently add
+// Delegate messages to the outgoing queue
+func listenRaw(conn net.PacketConn, linkType int) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("command/raw.listenRaw(): entering into function with connection: %+v, type: %s", conn.LocalAddr(), p2p.String(linkType)))
+	defer cli.Message(cli.DEBUG, "command/raw.listenRaw(): exiting function")
+
+	buffers := make(map[string]*bytes.Buffer)
+	for {
+		msg, addr, err := readRaw(conn, buffers)
+		if err != nil {
+			cli.Message(cli.WARN, fmt.Sprintf("commands/raw.listenRaw(): %s", err))
+			// A closed socket will never return data
+			if addr == nil {
+				return
+			}
+			continue
+		}
+
+		// Store LinkedAgent
+		_, err = peerToPeerService.GetLink(msg.Agent)
+		if err != nil {
+			// Reverse raw IP agents need to be added after initial checkin
+			linkedAgent := p2p.NewLink(msg.Agent, msg.Listener, conn, linkType, addr)
+			peerToPeerService.AddLink(linkedAgent)
+		} else if linkType == p2p.RAWREVERSE {
+			// Update the Link's connection to the current one
+			err = peerToPeerService.UpdateConnection(msg.Agent, conn, addr)
+			if err != nil {
+				cli.Message(cli.WARN, fmt.Sprintf("commands/raw.listenRaw(): %s", err))
+			}
+		}
+
+		// Add the message to the queue
+		peerToPeerService.AddDelegate(msg)
+	}
+}
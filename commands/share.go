@@ -0,0 +1,334 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"crypto/md5"  // #nosec G501 -- MD5 is what many Blue Team tools use
+	"crypto/sha1" // #nosec G505 -- SHA1 is what many Blue Team tools use
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// shareMaxFound is the most files a share find command returns
+const shareMaxFound = 1000
+
+// mapping is a network share the Agent mapped
+type mapping struct {
+	Remote string    // Remote is the UNC path of the share
+	Local  string    // Local is the drive letter redirected to the share, if any
+	User   string    // User is the user the share was mapped with; empty is the current context
+	Mapped time.Time // Mapped is when the share was mapped
+}
+
+// mappings are the shares the Agent mapped and hasn't unmapped, keyed by the name used to unmap them
+var mappings = make(map[string]mapping)
+var mappingsMu sync.Mutex
+
+// Share maps and unmaps network shares, with supplied or current credentials, and traverses them. Mapped shares are
+// tracked so that they can be cleaned up
+// share map <\\server\share> [drive:] [user password] - Maps the share, optionally to a drive letter
+// share unmap <\\server\share|drive:>                 - Unmaps a share
+// share list                                          - Lists the shares the Agent mapped
+// share cleanup                                       - Unmaps every share the Agent mapped
+// share ls <path>                                     - Lists a directory
+// share find <path> <pattern> [depth]                 - Finds the files below the path whose names match the glob
+// share hash <file...>                                - Returns the MD5, SHA1, and SHA256 hashes of the files
+func Share(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering Share() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the share module"
+		return
+	}
+
+	var err error
+	switch strings.ToLower(cmd.Args[0]) {
+	case "map":
+		var local, user, password string
+		args := cmd.Args[1:]
+		if len(args) > 1 && strings.HasSuffix(args[1], ":") {
+			local = args[1]
+			args = append(args[:1:1], args[2:]...)
+		}
+		switch len(args) {
+		case 1:
+		case 3:
+			user, password = args[1], args[2]
+		default:
+			results.Stderr = "the share map command requires a UNC path, an optional drive, and an optional user and password"
+			return
+		}
+		results.Stdout, err = shareMap(args[0], local, user, password)
+	case "unmap":
+		if len(cmd.Args) != 2 {
+			results.Stderr = fmt.Sprintf("the share unmap command requires 1 argument but received %d", len(cmd.Args)-1)
+			return
+		}
+		err = shareUnmap(cmd.Args[1])
+		if err == nil {
+			results.Stdout = fmt.Sprintf("Unmapped %s", cmd.Args[1])
+		}
+	case "list":
+		results.Stdout = shareList()
+	case "cleanup":
+		results.Stdout, err = ShareCleanup()
+	case "ls":
+		if len(cmd.Args) != 2 {
+			results.Stderr = fmt.Sprintf("the share ls command requires 1 argument but received %d", len(cmd.Args)-1)
+			return
+		}
+		results.Stdout, err = list(cmd.Args[1])
+	case "find":
+		if len(cmd.Args) < 3 {
+			results.Stderr = "the share find command requires a path and a file name pattern"
+			return
+		}
+		depth := -1
+		if len(cmd.Args) > 3 {
+			depth, err = strconv.Atoi(cmd.Args[3])
+			if err != nil || depth < 0 {
+				results.Stderr = fmt.Sprintf("the share find depth must be 0 or greater but received %s", cmd.Args[3])
+				return
+			}
+		}
+		results.Stdout, err = shareFind(cmd.Args[1], cmd.Args[2], depth)
+	case "hash":
+		if len(cmd.Args) < 2 {
+			results.Stderr = "the share hash command requires at least 1 file"
+			return
+		}
+		results.Stdout, err = shareHash(cmd.Args[1:])
+	default:
+		results.Stderr = fmt.Sprintf("unrecognized share command: %s", cmd.Args[0])
+		return
+	}
+	if err != nil {
+		results.Stderr = fmt.Sprintf("there was an error executing the share %s command: %s", strings.ToLower(cmd.Args[0]), err)
+	}
+	return
+}
+
+// shareMap maps the share and tracks it for cleanup
+func shareMap(remote, local, user, password string) (string, error) {
+	if !strings.HasPrefix(remote, `\\`) {
+		return "", fmt.Errorf("%s is not a UNC path (e.g., \\\\server\\share)", remote)
+	}
+	local = strings.ToUpper(local)
+	err := mapShare(remote, local, user, password)
+	if err != nil {
+		return "", err
+	}
+	name := remote
+	if local != "" {
+		name = local
+	}
+	mappingsMu.Lock()
+	mappings[strings.ToLower(name)] = mapping{Remote: remote, Local: local, User: user, Mapped: time.Now().UTC()}
+	mappingsMu.Unlock()
+
+	if local != "" {
+		return fmt.Sprintf("Mapped %s to %s", remote, local), nil
+	}
+	return fmt.Sprintf("Mapped %s", remote), nil
+}
+
+// shareUnmap unmaps the share or drive and stops tracking it
+func shareUnmap(name string) error {
+	err := unmapShare(name)
+	if err != nil {
+		return err
+	}
+	mappingsMu.Lock()
+	delete(mappings, strings.ToLower(name))
+	mappingsMu.Unlock()
+	return nil
+}
+
+// shareList returns the shares the Agent mapped
+func shareList() string {
+	mappingsMu.Lock()
+	defer mappingsMu.Unlock()
+	if len(mappings) == 0 {
+		return "The Agent has not mapped any shares"
+	}
+	var names []string
+	for name := range mappings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var list strings.Builder
+	for _, name := range names {
+		m := mappings[name]
+		user := m.User
+		if user == "" {
+			user = "(current)"
+		}
+		local := m.Local
+		if local == "" {
+			local = "-"
+		}
+		list.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\n", m.Remote, local, user, normalizeTime(m.Mapped)))
+	}
+	return list.String()
+}
+
+// ShareCleanup unmaps every share the Agent mapped, such as before it exits, and returns what was unmapped
+func ShareCleanup() (string, error) {
+	mappingsMu.Lock()
+	var names []string
+	for name := range mappings {
+		names = append(names, name)
+	}
+	mappingsMu.Unlock()
+	sort.Strings(names)
+
+	var unmapped, errs []string
+	for _, name := range names {
+		if err := shareUnmap(name); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+		unmapped = append(unmapped, name)
+	}
+	var err error
+	if len(errs) > 0 {
+		err = fmt.Errorf("there were errors unmapping shares:\n%s", strings.Join(errs, "\n"))
+	}
+	if len(unmapped) == 0 {
+		return "No shares were unmapped", err
+	}
+	return fmt.Sprintf("Unmapped %s", strings.Join(unmapped, ", ")), err
+}
+
+// shareFind walks the path, up to the depth when it isn't negative, and returns the files whose names match the
+// case-insensitive glob pattern
+func shareFind(root, pattern string, depth int) (found string, err error) {
+	pattern = strings.ToLower(pattern)
+	if _, err = filepath.Match(pattern, ""); err != nil {
+		return "", fmt.Errorf("invalid pattern %s: %s", pattern, err)
+	}
+
+	err = Setup()
+	if err != nil {
+		return
+	}
+	defer func() {
+		err2 := TearDown()
+		if err2 != nil {
+			if err != nil {
+				err = fmt.Errorf("there were multiple errors. 1. %s 2. %s", err, err2)
+			} else {
+				err = err2
+			}
+		}
+	}()
+
+	root = filepath.Clean(root)
+	rootDepth := strings.Count(root, string(filepath.Separator))
+	var results strings.Builder
+	var count int
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip directories that can't be read, such as those the user doesn't have access to
+			return nil
+		}
+		if d.IsDir() {
+			if depth >= 0 && path != root && strings.Count(path, string(filepath.Separator))-rootDepth > depth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if match, _ := filepath.Match(pattern, strings.ToLower(d.Name())); !match {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		results.WriteString(fmt.Sprintf("%s\t%d\t%s\n", normalizeTime(info.ModTime()), info.Size(), path))
+		count++
+		if count >= shareMaxFound {
+			results.WriteString(fmt.Sprintf("stopped after %d files\n", shareMaxFound))
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if count == 0 {
+		return fmt.Sprintf("No files below %s matched %s", root, pattern), err
+	}
+	return results.String(), err
+}
+
+// shareHash returns the MD5, SHA1, and SHA256 hashes of the files
+func shareHash(files []string) (hashes string, err error) {
+	err = Setup()
+	if err != nil {
+		return
+	}
+	defer func() {
+		err2 := TearDown()
+		if err2 != nil {
+			if err != nil {
+				err = fmt.Errorf("there were multiple errors. 1. %s 2. %s", err, err2)
+			} else {
+				err = err2
+			}
+		}
+	}()
+
+	var results strings.Builder
+	var errs []string
+	for _, file := range files {
+		md5Hash, sha1Hash, sha256Hash := md5.New(), sha1.New(), sha256.New() // #nosec G401 -- MD5 and SHA1 are what many Blue Team tools use
+		f, errOpen := os.Open(file)                                          // #nosec G304 -- The operator provides the file to hash
+		if errOpen != nil {
+			errs = append(errs, errOpen.Error())
+			continue
+		}
+		_, errCopy := io.Copy(io.MultiWriter(md5Hash, sha1Hash, sha256Hash), f)
+		_ = f.Close()
+		if errCopy != nil {
+			errs = append(errs, fmt.Sprintf("there was an error reading %s: %s", file, errCopy))
+			continue
+		}
+		results.WriteString(fmt.Sprintf("%s\nMD5: %x\nSHA1: %x\nSHA256: %x\n\n", file, md5Hash.Sum(nil), sha1Hash.Sum(nil), sha256Hash.Sum(nil)))
+	}
+	if len(errs) > 0 {
+		err = fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return results.String(), err
+}
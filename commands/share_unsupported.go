@@ -0,0 +1,39 @@
+//go:build !windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+	"runtime"
+)
+
+// mapShare is only supported on Windows; shares already mounted on other platforms can be traversed by their path
+func mapShare(remote, local, user, password string) error {
+	return fmt.Errorf("mapping shares is not supported on %s", runtime.GOOS)
+}
+
+// unmapShare is only supported on Windows
+func unmapShare(name string) error {
+	return fmt.Errorf("unmapping shares is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,89 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"fmt"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/mpr"
+)
+
+// mapShare connects to the share with WNetAddConnection2, redirecting the local drive to it when one is provided. An
+// empty user and password use the credentials of the current context, including an impersonated token. The connection
+// isn't remembered across logons
+func mapShare(remote, local, user, password string) (err error) {
+	resource := mpr.NETRESOURCE{Type: mpr.RESOURCETYPE_DISK}
+	resource.RemoteName, err = windows.UTF16PtrFromString(remote)
+	if err != nil {
+		return fmt.Errorf("there was an error converting %s to UTF16: %s", remote, err)
+	}
+	if local != "" {
+		resource.LocalName, err = windows.UTF16PtrFromString(local)
+		if err != nil {
+			return fmt.Errorf("there was an error converting %s to UTF16: %s", local, err)
+		}
+	}
+	var username, pass *uint16
+	if user != "" {
+		username, err = windows.UTF16PtrFromString(user)
+		if err != nil {
+			return fmt.Errorf("there was an error converting the user to UTF16: %s", err)
+		}
+		pass, err = windows.UTF16PtrFromString(password)
+		if err != nil {
+			return fmt.Errorf("there was an error converting the password to UTF16: %s", err)
+		}
+	}
+
+	err = Setup()
+	if err != nil {
+		return
+	}
+	err = mpr.WNetAddConnection2(&resource, pass, username, mpr.CONNECT_TEMPORARY)
+	if err2 := TearDown(); err2 != nil && err == nil {
+		err = err2
+	}
+	return
+}
+
+// unmapShare cancels the connection to the share or drive with WNetCancelConnection2, even if files are open on it
+func unmapShare(name string) (err error) {
+	n, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return fmt.Errorf("there was an error converting %s to UTF16: %s", name, err)
+	}
+	err = Setup()
+	if err != nil {
+		return
+	}
+	err = mpr.WNetCancelConnection2(n, 0, true)
+	if err2 := TearDown(); err2 != nil && err == nil {
+		err = err2
+	}
+	return
+}
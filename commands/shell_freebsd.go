@@ -23,13 +23,15 @@ along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
 package commands
 
 import (
+	"os"
 	"os/exec"
 	"strings"
 )
 
 // shell is used to execute a command on a host using the operating system's default shell
-func shell(args []string) (stdout string, stderr string) {
+func shell(args []string, opts execOptions) (stdout string, stderr string) {
 	cmd := exec.Command("/bin/sh", append([]string{"-c"}, strings.Join(args, " "))...) // #nosec G204
+	cmd.Env = opts.environ(os.Environ())
 
 	out, err := cmd.CombinedOutput()
 	if cmd.Process != nil {
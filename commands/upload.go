@@ -22,10 +22,14 @@ package commands
 
 import (
 	// Standard
+	"bytes"
 	// #nosec G505 -- Random number does not impact security
 	"crypto/sha1"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 
@@ -83,3 +87,79 @@ func Upload(transfer jobs.FileTransfer) (ft jobs.FileTransfer, err error) {
 	}
 	return ft, nil
 }
+
+// StreamHeader precedes the file's data in a streamed upload to identify the transfer to the server
+type StreamHeader struct {
+	ID   string `json:"id"`   // ID is the job ID the transfer is the result of
+	File string `json:"file"` // File is the path of the file on the host
+	Size int64  `json:"size"` // Size is the number of bytes of file data that follow the header
+}
+
+// Stream is a file being uploaded to the Merlin server as a stream. Reading it returns a 4-byte big-endian header length,
+// the JSON StreamHeader, and then the file's data
+type Stream struct {
+	io.Reader
+	Header StreamHeader
+	file   *os.File
+	hash   hash.Hash
+}
+
+// Close closes the file
+func (s *Stream) Close() error {
+	return s.file.Close()
+}
+
+// SHA1 returns the SHA1 hash of the file data read so far
+func (s *Stream) SHA1() []byte {
+	return s.hash.Sum(nil)
+}
+
+// UploadStream opens the file for a streamed upload to the Merlin server; the caller must close the returned Stream
+func UploadStream(id string, transfer jobs.FileTransfer) (stream *Stream, err error) {
+	cli.Message(cli.DEBUG, "Entering into commands.UploadStream() function")
+
+	// Setup OS environment, if any, only while the file is opened
+	err = Setup()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(transfer.FileLocation)
+	if err2 := TearDown(); err2 != nil {
+		if err != nil {
+			err = fmt.Errorf("there were multiple errors. 1. %s 2. %s", err, err2)
+		} else {
+			err = err2
+		}
+	}
+	if err != nil {
+		if file != nil {
+			_ = file.Close()
+		}
+		return nil, fmt.Errorf("there was an error opening %s: %s", transfer.FileLocation, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("there was an error getting information about %s: %s", transfer.FileLocation, err)
+	}
+	stream = &Stream{
+		Header: StreamHeader{ID: id, File: transfer.FileLocation, Size: info.Size()},
+		file:   file,
+		hash:   sha1.New(), // #nosec G401 // Use SHA1 because it is what many Blue Team tools use
+	}
+	header, err := json.Marshal(stream.Header)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("there was an error encoding the stream header: %s", err)
+	}
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(header)))
+
+	// Exactly the stated size is sent even if the file grows while it is read
+	data := io.TeeReader(io.LimitReader(file, info.Size()), stream.hash)
+	stream.Reader = io.MultiReader(bytes.NewReader(length), bytes.NewReader(header), data)
+
+	cli.Message(cli.NOTE, fmt.Sprintf("Streaming file %s of size %d bytes to the server", transfer.FileLocation, info.Size()))
+	return stream, nil
+}
@@ -0,0 +1,178 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package commands
+
+import (
+	// Standard
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+)
+
+// Architectures of the Agent, target processes, and shellcode
+const (
+	archX86   = "x86"
+	archX64   = "x64"
+	archARM64 = "arm64"
+)
+
+// agentArch returns the architecture the Agent was compiled for
+func agentArch() string {
+	switch runtime.GOARCH {
+	case "386":
+		return archX86
+	case "amd64":
+		return archX64
+	case "arm64":
+		return archARM64
+	}
+	return runtime.GOARCH
+}
+
+// processArch returns the architecture of the process and whether it is a 32-bit process running under WoW64
+func processArch(handle windows.Handle) (arch string, wow64 bool, err error) {
+	var processMachine, nativeMachine uint16
+	err = windows.IsWow64Process2(handle, &processMachine, &nativeMachine)
+	if err != nil {
+		// IsWow64Process2 was added in Windows 10 1511, older versions can only run x86 and x64 processes
+		var isWow64 bool
+		if err = windows.IsWow64Process(handle, &isWow64); err != nil {
+			return "", false, fmt.Errorf("there was an error calling Windows API IsWow64Process: %s", err)
+		}
+		if isWow64 {
+			return archX86, true, nil
+		}
+		// Processes that aren't under WoW64 run the native architecture, which is x86 only when the Agent is x86 and
+		// isn't under WoW64 either
+		if agentArch() == archX86 && !selfWow64() {
+			return archX86, false, nil
+		}
+		return archX64, false, nil
+	}
+	// The process machine is unknown when the process isn't under WoW64 and runs the native architecture
+	machine := processMachine
+	if machine == pe.IMAGE_FILE_MACHINE_UNKNOWN {
+		machine = nativeMachine
+	}
+	switch machine {
+	case pe.IMAGE_FILE_MACHINE_I386:
+		arch = archX86
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		arch = archX64
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		arch = archARM64
+	default:
+		return "", false, fmt.Errorf("unsupported process machine type 0x%x", machine)
+	}
+	return arch, processMachine != pe.IMAGE_FILE_MACHINE_UNKNOWN, nil
+}
+
+// selfWow64 returns true if the Agent is a 32-bit process running under WoW64
+func selfWow64() bool {
+	var isWow64 bool
+	if err := windows.IsWow64Process(windows.CurrentProcess(), &isWow64); err != nil {
+		return false
+	}
+	return isWow64
+}
+
+// injectable checks that the shellcode can be injected into the process, returning an error that names the
+// architectures for combinations that can't work. It returns true when the target is a WoW64 process injected from a
+// 64-bit Agent, where the shellcode runs as 32-bit code
+func injectable(handle windows.Handle, shellcode []byte) (wow64 bool, err error) {
+	agent := agentArch()
+	target, wow64, err := processArch(handle)
+	if err != nil {
+		return false, err
+	}
+	// The Agent's own threads can't start in another architecture's code; a 32-bit Agent would need to switch
+	// the processor to 64-bit mode to create a thread in a 64-bit process
+	if agent == archX86 && target != archX86 {
+		return false, fmt.Errorf("a %s Agent can't inject into the %s target process; use a %s Agent", agent, target, target)
+	}
+	if agent == archARM64 && target != archARM64 || agent == archX64 && target == archARM64 {
+		return false, fmt.Errorf("a %s Agent can't inject into the %s target process; use a %s Agent", agent, target, target)
+	}
+	if code := shellcodeArch(shellcode); code != "" && code != target {
+		return false, fmt.Errorf("the shellcode appears to be %s but the target process is %s", code, target)
+	}
+	return wow64 && agent == archX64, nil
+}
+
+// wow64APC encodes a 32-bit routine's address so that an APC queued from a 64-bit process to a WoW64 thread runs it as
+// 32-bit code, which is how ntdll!RtlQueueApcWow64Thread encodes it
+func wow64APC(addr uintptr) uintptr {
+	return uintptr(-(int64(addr) << 2))
+}
+
+// shellcodeArch returns the architecture of shellcode that starts with a well known x86 or x64 prologue, or an empty
+// string when it can't be told apart, in which case the shellcode is trusted to match the target process
+func shellcodeArch(shellcode []byte) string {
+	// Skip leading NOP and CLD instructions
+	i := 0
+	for i < len(shellcode) && (shellcode[i] == 0x90 || shellcode[i] == 0xfc) {
+		i++
+	}
+	code := shellcode[i:]
+	if len(code) < 2 {
+		return ""
+	}
+	switch {
+	// pushad is invalid in 64-bit mode
+	case code[0] == 0x60:
+		return archX86
+	// push ebp; mov ebp, esp is encoded with a REX.W prefix in 64-bit mode
+	case len(code) >= 3 && code[0] == 0x55 && code[1] == 0x89 && code[2] == 0xe5:
+		return archX86
+	// A REX.W prefix followed by a common opcode such as and, sub, mov, xor, or lea on a 64-bit register
+	case (code[0] == 0x48 || code[0] == 0x49 || code[0] == 0x4c || code[0] == 0x4d) && rexOpcode(code[1]):
+		return archX64
+	// A call over a block, such as Metasploit's API resolver, identified by the instructions it calls
+	case code[0] == 0xe8 && len(code) >= 5:
+		target := 5 + int(int32(binary.LittleEndian.Uint32(code[1:5])))
+		if target <= 5 || target >= len(code)-1 {
+			return ""
+		}
+		if code[target] == 0x60 {
+			return archX86
+		}
+		// push r9 and push r8
+		if code[target] == 0x41 && (code[target+1] == 0x51 || code[target+1] == 0x50) {
+			return archX64
+		}
+	}
+	return ""
+}
+
+// rexOpcode returns true for opcodes commonly found after a REX.W prefix at the start of 64-bit shellcode
+func rexOpcode(op byte) bool {
+	switch op {
+	case 0x01, 0x29, 0x31, 0x33, 0x81, 0x83, 0x85, 0x89, 0x8b, 0x8d, 0xc7:
+		return true
+	}
+	return false
+}
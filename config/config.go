@@ -0,0 +1,120 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package config holds the Agent's configuration as it was built and started so that the effective configuration can
+// be exported and re-used to build new Agents, and so that one Agent can load its configuration from an encrypted file
+package config
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/heap"
+)
+
+// Sensitive are the settings holding secrets that are never exported; the new Agent must be given its own
+var Sensitive = []string{"authkey", "configkey", "noisekey", "psk", "totpseed"}
+
+// Config is the Agent's effective configuration in a format that can be re-used to build a new Agent
+type Config struct {
+	Settings map[string]string `json:"settings"`           // Settings are the values of the Agent's command line flags, which are also the Makefile's ldflags -X variables
+	Redacted []string          `json:"redacted,omitempty"` // Redacted are the sensitive settings that were removed
+}
+
+// settings are the Agent's configuration when it was started, keyed by command line flag name. The Sensitive settings
+// are held sealed with the heap package's ephemeral key and only opened when they are read
+var settings = make(map[string]string)
+
+// mu protects the settings from concurrent access
+var mu sync.Mutex
+
+// Set stores a setting, by its command line flag name, as it was when the Agent was started or last switched transports
+func Set(name, value string) {
+	if sensitive(name) {
+		value = string(heap.NewSecret([]byte(value)))
+	}
+	mu.Lock()
+	settings[name] = value
+	mu.Unlock()
+}
+
+// Get returns a setting, by its command line flag name, as it was when the Agent was started or last switched transports
+func Get(name string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return reveal(name, settings[name])
+}
+
+// Effective returns every setting, none redacted. The current values of settings that were changed after the Agent
+// started are passed in, keyed by command line flag name, and replace the values it was started with
+func Effective(current map[string]string) map[string]string {
+	effective := make(map[string]string)
+	mu.Lock()
+	for name, value := range settings {
+		effective[name] = reveal(name, value)
+	}
+	mu.Unlock()
+	for name, value := range current {
+		if _, ok := effective[name]; ok {
+			effective[name] = value
+		}
+	}
+	return effective
+}
+
+// Export returns the Agent's effective configuration as indented JSON. The current values of settings that were changed
+// after the Agent started are passed in, keyed by command line flag name, and replace the values it was started with
+func Export(current map[string]string) ([]byte, error) {
+	config := Config{Settings: Effective(current)}
+	for _, name := range Sensitive {
+		if config.Settings[name] != "" {
+			config.Settings[name] = ""
+			config.Redacted = append(config.Redacted, name)
+		}
+	}
+	sort.Strings(config.Redacted)
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("config.Export(): there was an error marshalling the configuration to JSON: %s", err)
+	}
+	return data, nil
+}
+
+// sensitive determines if the setting, by its command line flag name, is one of the Sensitive settings
+func sensitive(name string) bool {
+	for _, s := range Sensitive {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// reveal returns the clear text value of a setting, opening it when it is one of the Sensitive settings
+func reveal(name, value string) string {
+	if sensitive(name) {
+		return string(heap.Secret(value).Open())
+	}
+	return value
+}
@@ -0,0 +1,47 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	// Standard
+	"os"
+	"strings"
+)
+
+// Environment returns the settings from the environment variables named with the prefix followed by one of the command
+// line flag names in upper case (e.g., MERLIN_SLEEP), keyed by flag name. The variables are removed from the Agent's
+// environment so that the processes it starts don't inherit them
+func Environment(prefix string, names []string) map[string]string {
+	env := make(map[string]string)
+	if prefix == "" {
+		return env
+	}
+	for _, name := range names {
+		variable := prefix + strings.ToUpper(name)
+		value, ok := os.LookupEnv(variable)
+		if !ok {
+			continue
+		}
+		env[name] = value
+		_ = os.Unsetenv(variable)
+	}
+	return env
+}
@@ -0,0 +1,197 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	// Standard
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// X Packages
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Keys derived from the host the Agent is running on
+const (
+	HOSTNAME  = "hostname"  // HOSTNAME derives the key from the lower case hostname
+	MACHINEID = "machineid" // MACHINEID derives the key from the Windows MachineGuid or the Unix machine-id
+)
+
+// Sizes and iterations used to encrypt a configuration file
+const (
+	saltSize   = 16
+	iterations = 100000
+)
+
+// Passphrase returns the passphrase for the key, which is either HOSTNAME, MACHINEID, or the passphrase itself
+func Passphrase(key string) (string, error) {
+	switch strings.ToLower(key) {
+	case HOSTNAME:
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("config.Passphrase(): there was an error getting the hostname: %s", err)
+		}
+		return strings.ToLower(hostname), nil
+	case MACHINEID:
+		id, err := machineID()
+		if err != nil {
+			return "", fmt.Errorf("config.Passphrase(): %s", err)
+		}
+		return strings.ToLower(id), nil
+	default:
+		return key, nil
+	}
+}
+
+// Encrypt encrypts the JSON encoded Config with a key derived from the passphrase.
+// The file is the random salt, the AES-256-GCM nonce, and then the sealed Config
+func Encrypt(data []byte, passphrase string) ([]byte, error) {
+	var config Config
+	err := json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("config.Encrypt(): there was an error unmarshalling the configuration JSON: %s", err)
+	}
+	if len(config.Settings) == 0 {
+		return nil, fmt.Errorf("config.Encrypt(): the configuration does not have any settings")
+	}
+
+	salt := make([]byte, saltSize)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, fmt.Errorf("config.Encrypt(): there was an error generating the salt: %s", err)
+	}
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, fmt.Errorf("config.Encrypt(): %s", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("config.Encrypt(): there was an error generating the nonce: %s", err)
+	}
+	out := append(salt, nonce...)
+	return aead.Seal(out, nonce, data, salt), nil
+}
+
+// Decrypt decrypts and verifies the data with a key derived from the passphrase and returns the Config
+func Decrypt(data []byte, passphrase string) (config Config, err error) {
+	if len(data) < saltSize {
+		err = fmt.Errorf("config.Decrypt(): the encrypted configuration is too short")
+		return
+	}
+	salt := data[:saltSize]
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		err = fmt.Errorf("config.Decrypt(): %s", err)
+		return
+	}
+	data = data[saltSize:]
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		err = fmt.Errorf("config.Decrypt(): the encrypted configuration is too short")
+		return
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], salt)
+	if err != nil {
+		err = fmt.Errorf("config.Decrypt(): the configuration could not be decrypted with the key: %s", err)
+		return
+	}
+	err = json.Unmarshal(plaintext, &config)
+	if err != nil {
+		err = fmt.Errorf("config.Decrypt(): there was an error unmarshalling the configuration JSON: %s", err)
+	}
+	return
+}
+
+// Embed returns the settings the Agent was started with encrypted with the passphrase and base64 encoded so the build
+// can replace the plaintext ldflags variables with the one encrypted blob. The Sensitive settings are left out because
+// the passphrase is stored in the binary next to the blob; the build keeps them in their own ldflags variables
+func Embed(passphrase string) (string, error) {
+	config := Config{Settings: make(map[string]string)}
+	mu.Lock()
+	for name, value := range settings {
+		config.Settings[name] = value
+	}
+	mu.Unlock()
+	for _, name := range Sensitive {
+		delete(config.Settings, name)
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("config.Embed(): there was an error marshalling the configuration to JSON: %s", err)
+	}
+	data, err = Encrypt(data, passphrase)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Embedded decodes and decrypts the embedded configuration created by Embed
+func Embedded(blob, passphrase string) (Config, error) {
+	data, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return Config{}, fmt.Errorf("config.Embedded(): there was an error base64 decoding the embedded configuration: %s", err)
+	}
+	return Decrypt(data, passphrase)
+}
+
+// Load reads and decrypts the configuration file. A relative path is next to the Agent's executable
+func Load(path, passphrase string) (Config, error) {
+	path = Path(path)
+	data, err := os.ReadFile(path) // #nosec G304 -- The configuration file path is provided by the operator
+	if err != nil {
+		return Config{}, fmt.Errorf("config.Load(): there was an error reading the configuration file: %s", err)
+	}
+	return Decrypt(data, passphrase)
+}
+
+// Path returns the path to the configuration file; a relative path is next to the Agent's executable
+func Path(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(filepath.Dir(exe), path)
+}
+
+// newAEAD returns AES-256-GCM keyed with the PBKDF2-SHA256 derivation of the passphrase and salt
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("the configuration key is empty")
+	}
+	key := pbkdf2.Key([]byte(passphrase), salt, iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error creating the AES cipher: %s", err)
+	}
+	return cipher.NewGCM(block)
+}
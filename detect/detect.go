@@ -0,0 +1,251 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package detect watches for signs that the Agent was detected, such as its executable being quarantined, a security
+// product opening a handle to its process, or an inspection device resetting its connections, so the events can be
+// reported to the operator immediately
+package detect
+
+import (
+	// Standard
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+const (
+	// EXECUTABLE events are the Agent's executable being removed, modified, or locked, such as by a quarantine
+	EXECUTABLE = "executable"
+	// PROCESS events are a security product opening a handle to, or tracing, the Agent's process
+	PROCESS = "process"
+	// RESET events are the Agent's connections being reset, such as by an inspection device
+	RESET = "reset"
+)
+
+// Event is a sign that the Agent was detected
+type Event struct {
+	Time   time.Time // Time is when the event was observed, in UTC
+	Type   string    // Type is EXECUTABLE, PROCESS, or RESET
+	Detail string    // Detail describes what was observed
+}
+
+// String returns the event as a single line for the operator
+func (e Event) String() string {
+	return fmt.Sprintf("Detection event at %s [%s]: %s", e.Time.UTC().Format(time.RFC3339), e.Type, e.Detail)
+}
+
+// events holds the events until they are reported; events are dropped when it is full
+var events = make(chan Event, 32)
+
+// enabled determines if the Agent watches for detection events
+var enabled bool
+
+// executable is the path to the Agent's executable and its state when the watch started
+var executable struct {
+	path  string
+	info  os.FileInfo
+	state string
+}
+
+// seen are the processes that were already reported, keyed by process ID
+var seen = make(map[uint32]bool)
+
+// mu protects the settings and state from concurrent access
+var mu sync.Mutex
+
+// once ensures only one watch runs
+var once sync.Once
+
+// Events returns a channel that receives the detection events to report
+func Events() <-chan Event {
+	return events
+}
+
+// Enabled returns true if the Agent watches for detection events
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// Parse converts a duration string (e.g., 30s) into the polling interval; an empty string or 0 disables detection
+// telemetry
+func Parse(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("detect.Parse(): there was an error parsing the interval %s: %s", value, err)
+	}
+	if interval < 0 {
+		return 0, fmt.Errorf("detect.Parse(): the interval must be 0 or greater but received %s", interval)
+	}
+	return interval, nil
+}
+
+// Start polls the Agent's executable and the handles to its process at the interval. An interval of 0 or less disables
+// detection telemetry
+func Start(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	once.Do(func() {
+		path, err := os.Executable()
+		if err != nil {
+			cli.Message(cli.WARN, fmt.Sprintf("detect.Start(): there was an error getting the agent's executable: %s", err))
+		}
+		var info os.FileInfo
+		if path != "" {
+			info, err = os.Stat(path)
+			if err != nil {
+				cli.Message(cli.WARN, fmt.Sprintf("detect.Start(): there was an error getting the agent's executable information: %s", err))
+			}
+		}
+		mu.Lock()
+		enabled = true
+		executable.path = path
+		executable.info = info
+		mu.Unlock()
+		go watch(interval)
+	})
+}
+
+// Reset reports the error as a RESET event if the connection was reset by the remote host or a device in between
+func Reset(err error) {
+	if err == nil || !Enabled() {
+		return
+	}
+	msg := strings.ToLower(err.Error())
+	if errors.Is(err, syscall.ECONNRESET) || strings.Contains(msg, "connection reset") || strings.Contains(msg, "forcibly closed") {
+		report(RESET, err.Error())
+	}
+}
+
+// watch polls for detection events until the program exits
+func watch(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		checkExecutable()
+		for _, p := range watchers() {
+			mu.Lock()
+			reported := seen[p.pid]
+			seen[p.pid] = true
+			mu.Unlock()
+			if !reported {
+				report(PROCESS, p.String())
+			}
+		}
+	}
+}
+
+// checkExecutable reports an EXECUTABLE event when the Agent's executable is removed, modified, or can't be read
+// compared to when the watch started. Each state is only reported once
+func checkExecutable() {
+	mu.Lock()
+	path, original, previous := executable.path, executable.info, executable.state
+	mu.Unlock()
+	if path == "" || original == nil {
+		return
+	}
+	var state string
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		state = fmt.Sprintf("the agent's executable %s was removed or quarantined", path)
+	case err != nil:
+		state = fmt.Sprintf("there was an error getting the agent's executable %s information: %s", path, err)
+	case info.Size() != original.Size() || !info.ModTime().Equal(original.ModTime()):
+		state = fmt.Sprintf("the agent's executable %s was modified, it was %d bytes and is now %d bytes", path, original.Size(), info.Size())
+	default:
+		f, err := os.Open(path) // #nosec G304 the path is the Agent's own executable
+		if err != nil {
+			state = fmt.Sprintf("the agent's executable %s can't be read: %s", path, err)
+		} else {
+			_ = f.Close()
+		}
+	}
+	if state == previous {
+		return
+	}
+	mu.Lock()
+	executable.state = state
+	mu.Unlock()
+	if state != "" {
+		report(EXECUTABLE, state)
+	}
+}
+
+// watcher is a security product process watching the Agent's process
+type watcher struct {
+	pid    uint32 // pid is the watching process' ID
+	name   string // name is the watching process' executable name
+	access string // access is how the process is watching, such as the access rights of its handle
+}
+
+// String returns a description of the watcher
+func (w watcher) String() string {
+	return fmt.Sprintf("%s (PID %d) %s the agent's process", w.name, w.pid, w.access)
+}
+
+// products are the executable names, in lower case, of common Windows endpoint security products
+var products = map[string]bool{
+	"bdservicehost.exe":       true,
+	"ccsvchst.exe":            true,
+	"csfalconcontainer.exe":   true,
+	"csfalconservice.exe":     true,
+	"cylancesvc.exe":          true,
+	"cyserver.exe":            true,
+	"ekrn.exe":                true,
+	"elastic-endpoint.exe":    true,
+	"mcshield.exe":            true,
+	"msmpeng.exe":             true,
+	"mssense.exe":             true,
+	"repmgr.exe":              true,
+	"savservice.exe":          true,
+	"sedservice.exe":          true,
+	"senseir.exe":             true,
+	"sentinelagent.exe":       true,
+	"sentinelservicehost.exe": true,
+	"sophosfilescanner.exe":   true,
+	"sysmon.exe":              true,
+	"sysmon64.exe":            true,
+	"taniumclient.exe":        true,
+	"xagt.exe":                true,
+}
+
+// report adds the event to be reported without blocking; it is dropped if too many events are waiting
+func report(t, detail string) {
+	e := Event{Time: time.Now().UTC(), Type: t, Detail: detail}
+	cli.Message(cli.WARN, e.String())
+	select {
+	case events <- e:
+	default:
+		cli.Message(cli.WARN, "detect.report(): too many detection events are waiting to be reported, dropping the event")
+	}
+}
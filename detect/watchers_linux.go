@@ -0,0 +1,57 @@
+//go:build linux
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package detect
+
+import (
+	// Standard
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// watchers returns the process tracing the Agent's process, such as a debugger or security product, from the TracerPid
+// field in /proc/self/status. Every tracer is reported because tracing the Agent is itself a sign of analysis
+func watchers() (found []watcher) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return
+	}
+	defer f.Close() // #nosec G307
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "TracerPid:")
+		if !ok {
+			continue
+		}
+		pid, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+		if err != nil || pid == 0 {
+			return
+		}
+		name, _ := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+		found = append(found, watcher{pid: uint32(pid), name: strings.TrimSpace(string(name)), access: "is tracing"})
+		return
+	}
+	return
+}
@@ -0,0 +1,140 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package detect
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// handleEntry is the SYSTEM_HANDLE_TABLE_ENTRY_INFO_EX structure
+type handleEntry struct {
+	Object                uintptr
+	UniqueProcessId       uintptr
+	HandleValue           uintptr
+	GrantedAccess         uint32
+	CreatorBackTraceIndex uint16
+	ObjectTypeIndex       uint16
+	HandleAttributes      uint32
+	Reserved              uint32
+}
+
+// handleInformation is the header of the SYSTEM_HANDLE_INFORMATION_EX structure, the entries follow it
+type handleInformation struct {
+	NumberOfHandles uintptr
+	Reserved        uintptr
+}
+
+// watchers returns the security product processes that have a handle open to the Agent's process. The Agent opens a
+// handle to itself to learn its process object's address and then looks for other processes' handles to that object
+func watchers() (found []watcher) {
+	self := windows.GetCurrentProcessId()
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, self)
+	if err != nil {
+		cli.Message(cli.DEBUG, fmt.Sprintf("detect.watchers(): there was an error opening a handle to the agent's process: %s", err))
+		return
+	}
+	defer windows.CloseHandle(handle) // #nosec G307
+
+	entries, err := handles()
+	if err != nil {
+		cli.Message(cli.DEBUG, fmt.Sprintf("detect.watchers(): %s", err))
+		return
+	}
+	var object uintptr
+	for _, e := range entries {
+		if uint32(e.UniqueProcessId) == self && windows.Handle(e.HandleValue) == handle {
+			object = e.Object
+			break
+		}
+	}
+	if object == 0 {
+		return
+	}
+
+	names := processNames()
+	for _, e := range entries {
+		pid := uint32(e.UniqueProcessId)
+		if e.Object != object || pid == self {
+			continue
+		}
+		name := names[pid]
+		if products[strings.ToLower(name)] {
+			found = append(found, watcher{pid: pid, name: name, access: fmt.Sprintf("opened a handle with access 0x%x to", e.GrantedAccess)})
+		}
+	}
+	return
+}
+
+// handles returns every handle on the system, growing the buffer until it is large enough
+func handles() ([]handleEntry, error) {
+	size := uint32(1 << 20)
+	for i := 0; i < 8; i++ {
+		buf := make([]byte, size)
+		var needed uint32
+		err := windows.NtQuerySystemInformation(windows.SystemExtendedHandleInformation, unsafe.Pointer(&buf[0]), size, &needed)
+		if err == windows.STATUS_INFO_LENGTH_MISMATCH {
+			size *= 2
+			if needed > size {
+				size = needed + 1<<16
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("there was an error calling NtQuerySystemInformation: %s", err)
+		}
+		info := (*handleInformation)(unsafe.Pointer(&buf[0]))
+		offset := unsafe.Sizeof(handleInformation{})
+		limit := (uintptr(len(buf)) - offset) / unsafe.Sizeof(handleEntry{})
+		n := info.NumberOfHandles
+		if n > limit {
+			n = limit
+		}
+		return unsafe.Slice((*handleEntry)(unsafe.Pointer(&buf[offset])), n), nil
+	}
+	return nil, fmt.Errorf("the system handle table was too large to query")
+}
+
+// processNames returns the executable name of every running process keyed by process ID
+func processNames() map[uint32]string {
+	names := make(map[uint32]string)
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return names
+	}
+	defer windows.CloseHandle(snapshot) // #nosec G307
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		names[entry.ProcessID] = windows.UTF16ToString(entry.ExeFile[:])
+	}
+	return names
+}
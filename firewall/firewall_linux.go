@@ -0,0 +1,54 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package firewall
+
+import (
+	// Standard
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// add inserts an iptables INPUT chain rule, ahead of any rule that would drop the traffic, commented with the rule's
+// name. This requires root or the CAP_NET_ADMIN capability
+func add(rule Rule) error {
+	return iptables(append([]string{"-I", "INPUT"}, spec(rule)...)...)
+}
+
+// remove deletes the iptables rule with the same specification
+func remove(rule Rule) error {
+	return iptables(append([]string{"-D", "INPUT"}, spec(rule)...)...)
+}
+
+// spec returns the iptables rule specification
+func spec(rule Rule) []string {
+	return []string{"-p", rule.Protocol, "--dport", strconv.Itoa(rule.Port), "-m", "comment", "--comment", rule.Name, "-j", "ACCEPT"}
+}
+
+// iptables runs the iptables command
+func iptables(args ...string) error {
+	out, err := exec.Command("iptables", append([]string{"-w"}, args...)...).CombinedOutput() // #nosec G204 -- arguments are built from validated rules
+	if err != nil && len(strings.TrimSpace(string(out))) > 0 {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return err
+}
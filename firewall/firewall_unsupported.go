@@ -0,0 +1,39 @@
+//go:build !linux && !windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package firewall
+
+import (
+	// Standard
+	"fmt"
+	"runtime"
+)
+
+// add is only supported on Linux and Windows
+func add(rule Rule) error {
+	return fmt.Errorf("firewall rules are not supported on %s", runtime.GOOS)
+}
+
+// remove is only supported on Linux and Windows
+func remove(rule Rule) error {
+	return fmt.Errorf("firewall rules are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,51 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package firewall
+
+import (
+	// Standard
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// add adds an inbound allow rule with the Windows Defender Firewall's netsh context
+func add(rule Rule) error {
+	return netsh("add", "rule", "name="+rule.Name, "dir=in", "action=allow", "protocol="+strings.ToUpper(rule.Protocol), "localport="+strconv.Itoa(rule.Port))
+}
+
+// remove deletes the rule by its name, protocol, and port so that rules with the same name aren't removed
+func remove(rule Rule) error {
+	return netsh("delete", "rule", "name="+rule.Name, "dir=in", "protocol="+strings.ToUpper(rule.Protocol), "localport="+strconv.Itoa(rule.Port))
+}
+
+// netsh runs the netsh advfirewall firewall command without displaying a console window
+func netsh(args ...string) error {
+	cmd := exec.Command("netsh", append([]string{"advfirewall", "firewall"}, args...)...) // #nosec G204 -- arguments are built from validated rules
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(strings.TrimSpace(string(out))) > 0 {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+//go:build !windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package guardrail
+
+import (
+	// Standard
+	"bufio"
+	"os"
+	"strings"
+)
+
+// domain returns the host's DNS domain from its fully qualified hostname or, failing that, the domain or first search
+// entry in /etc/resolv.conf. An empty string is returned if the host doesn't have a domain
+func domain() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	if _, d, found := strings.Cut(hostname, "."); found {
+		return d, nil
+	}
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close() // #nosec G307
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && (fields[0] == "domain" || fields[0] == "search") {
+			return fields[1], nil
+		}
+	}
+	return "", scanner.Err()
+}
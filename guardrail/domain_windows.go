@@ -0,0 +1,43 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package guardrail
+
+import (
+	// X Packages
+	"golang.org/x/sys/windows"
+)
+
+// domain returns the DNS name of the Active Directory domain the host is joined to, or an empty string if it isn't
+func domain() (string, error) {
+	n := uint32(256)
+	buf := make([]uint16, n)
+	err := windows.GetComputerNameEx(windows.ComputerNameDnsDomain, &buf[0], &n)
+	if err == windows.ERROR_MORE_DATA {
+		buf = make([]uint16, n)
+		err = windows.GetComputerNameEx(windows.ComputerNameDnsDomain, &buf[0], &n)
+	}
+	if err != nil {
+		return "", err
+	}
+	return windows.UTF16ToString(buf[:n]), nil
+}
@@ -0,0 +1,194 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package guardrail checks the host the Agent is executing on against the environment it was built for so that the
+// Agent quits, without ever touching the network, when it is run in a sandbox or on the wrong target
+package guardrail
+
+import (
+	// Standard
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DOMAIN requires the host to be joined to the Active Directory, or DNS, domain (e.g., domain=corp.local)
+	DOMAIN = "domain"
+	// FILE requires a marker file to exist on the host (e.g., file=C:\ProgramData\marker.txt)
+	FILE = "file"
+	// HOSTNAME requires the host's name to match the regular expression (e.g., hostname=^WS-\d+$)
+	HOSTNAME = "hostname"
+	// SUBNET requires one of the host's network interfaces to have an address in the CIDR (e.g., subnet=10.1.0.0/16)
+	SUBNET = "subnet"
+	// USERNAME requires the Agent to be running as the user, with or without the domain (e.g., username=CORP\alice)
+	USERNAME = "username"
+)
+
+// Guardrail is a single condition the host must meet for the Agent to run
+type Guardrail struct {
+	Type  string // Type is the kind of condition, such as DOMAIN or SUBNET
+	Value string // Value is what the host must match
+}
+
+// String returns the guardrail in the same type=value format it is configured with
+func (g Guardrail) String() string {
+	return fmt.Sprintf("%s=%s", g.Type, g.Value)
+}
+
+// guardrails are the conditions that must all be met for the Agent to run
+var guardrails []Guardrail
+
+// wipe determines if the Agent removes its executable from disk when a guardrail isn't met
+var wipe bool
+
+// mu protects the guardrails and settings from concurrent access
+var mu sync.Mutex
+
+// Set parses and sets the guardrails from a semicolon separated list of type=value pairs
+// (e.g., domain=corp.local;hostname=^WS-\d+$;subnet=10.1.0.0/16). An empty string removes all guardrails
+func Set(value string) error {
+	var parsed []Guardrail
+	for _, pair := range strings.Split(value, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		t, v, ok := strings.Cut(pair, "=")
+		g := Guardrail{Type: strings.ToLower(strings.TrimSpace(t)), Value: strings.TrimSpace(v)}
+		if !ok || g.Value == "" {
+			return fmt.Errorf("guardrail.Set(): the guardrail %s is not in the type=value format", pair)
+		}
+		switch g.Type {
+		case DOMAIN, FILE, USERNAME:
+		case HOSTNAME:
+			_, err := regexp.Compile(g.Value)
+			if err != nil {
+				return fmt.Errorf("guardrail.Set(): there was an error compiling the hostname regular expression %s: %s", g.Value, err)
+			}
+		case SUBNET:
+			_, _, err := net.ParseCIDR(g.Value)
+			if err != nil {
+				return fmt.Errorf("guardrail.Set(): there was an error parsing the subnet %s: %s", g.Value, err)
+			}
+		default:
+			return fmt.Errorf("guardrail.Set(): unhandled guardrail type: %s", g.Type)
+		}
+		parsed = append(parsed, g)
+	}
+	mu.Lock()
+	guardrails = parsed
+	mu.Unlock()
+	return nil
+}
+
+// SetWipe parses and sets whether the Agent removes its executable from disk when a guardrail isn't met (e.g., true)
+func SetWipe(value string) error {
+	if value == "" {
+		value = "false"
+	}
+	w, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("guardrail.SetWipe(): there was an error parsing %s as a boolean: %s", value, err)
+	}
+	mu.Lock()
+	wipe = w
+	mu.Unlock()
+	return nil
+}
+
+// Wipe returns true if the Agent removes its executable from disk when a guardrail isn't met
+func Wipe() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return wipe
+}
+
+// Check verifies the host meets every guardrail and returns an error for the first one it doesn't. Only local
+// information is used; nothing is sent over the network
+func Check() error {
+	mu.Lock()
+	checks := guardrails
+	mu.Unlock()
+	for _, g := range checks {
+		ok, err := check(g)
+		if err != nil {
+			return fmt.Errorf("guardrail.Check(): there was an error checking the %s guardrail: %s", g, err)
+		}
+		if !ok {
+			return fmt.Errorf("guardrail.Check(): the host does not meet the %s guardrail", g)
+		}
+	}
+	return nil
+}
+
+// check returns true if the host meets the guardrail
+func check(g Guardrail) (bool, error) {
+	switch g.Type {
+	case DOMAIN:
+		d, err := domain()
+		if err != nil {
+			return false, err
+		}
+		return strings.EqualFold(strings.TrimSuffix(d, "."), strings.TrimSuffix(g.Value, ".")), nil
+	case FILE:
+		_, err := os.Stat(g.Value)
+		return err == nil, nil
+	case HOSTNAME:
+		hostname, err := os.Hostname()
+		if err != nil {
+			return false, err
+		}
+		return regexp.MustCompile(g.Value).MatchString(hostname), nil
+	case SUBNET:
+		_, subnet, err := net.ParseCIDR(g.Value)
+		if err != nil {
+			return false, err
+		}
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return false, err
+		}
+		for _, addr := range addrs {
+			if ip, ok := addr.(*net.IPNet); ok && subnet.Contains(ip.IP) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case USERNAME:
+		u, err := user.Current()
+		if err != nil {
+			return false, err
+		}
+		// Windows usernames include the domain, which is optional in the guardrail
+		if strings.EqualFold(u.Username, g.Value) {
+			return true, nil
+		}
+		_, name, found := strings.Cut(u.Username, "\\")
+		return found && strings.EqualFold(name, g.Value), nil
+	default:
+		return false, fmt.Errorf("unhandled guardrail type: %s", g.Type)
+	}
+}
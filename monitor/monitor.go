@@ -0,0 +1,146 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package monitor is a read-only Agent mode that only permits passive collection and reconnaissance jobs, used as a
+// blue-team sensor or to generate purple-team telemetry without executing programs or modifying the host
+package monitor
+
+import (
+	// Standard
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+)
+
+// ALL permits every subcommand of a command
+const ALL = "*"
+
+// natives are the native commands permitted in monitor mode and their permitted subcommands, the first argument
+var natives = map[string][]string{
+	"cd":       {ALL},
+	"env":      {"get", "showall"},
+	"ifconfig": {ALL},
+	"ls":       {ALL},
+	"nslookup": {ALL},
+	"pwd":      {ALL},
+}
+
+// modules are the module commands permitted in monitor mode and their permitted subcommands, the first argument
+var modules = map[string][]string{
+	"capabilities": {ALL},
+	"config":       {ALL},
+	"git":          {ALL},
+	"guest":        {"list"},
+	"hours":        {ALL},
+	"netstat":      {ALL},
+	"page":         {ALL},
+	"pipes":        {ALL},
+	"ps":           {ALL},
+	"queue":        {ALL},
+	"record":       {ALL},
+	"session":      {"list"},
+	"uptime":       {ALL},
+}
+
+// controls are the control commands denied in monitor mode because they write, execute, or delete files on the host
+var controls = []string{"selfdelete", "update"}
+
+// enabled determines if the Agent is in read-only monitor mode
+var enabled bool
+
+// mu protects the setting from concurrent access
+var mu sync.Mutex
+
+// SetEnabled parses and sets whether the Agent is in read-only monitor mode (e.g., true). Monitor mode can only be
+// enabled when the Agent is built or started, it is not changed by the server
+func SetEnabled(value string) error {
+	if value == "" {
+		value = "false"
+	}
+	e, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("monitor.SetEnabled(): there was an error parsing %s as a boolean: %s", value, err)
+	}
+	mu.Lock()
+	enabled = e
+	mu.Unlock()
+	return nil
+}
+
+// Enabled returns true if the Agent is in read-only monitor mode
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// Allowed returns an error if the Agent is in monitor mode and the job executes a program or code, modifies the host,
+// or uses the host to reach other hosts. Control jobs that only configure the Agent are allowed
+func Allowed(job jobs.Job) error {
+	if !Enabled() {
+		return nil
+	}
+	switch job.Type {
+	case jobs.CONTROL:
+		name := strings.ToLower(job.Payload.(jobs.Command).Command)
+		for _, control := range controls {
+			if name == control {
+				return fmt.Errorf("the %s command is disabled because the agent is in read-only monitor mode", name)
+			}
+		}
+		return nil
+	case jobs.AGENTINFO, jobs.RESULT:
+		return nil
+	case jobs.FILETRANSFER:
+		// Files can be collected from the host but not written to it
+		if !job.Payload.(jobs.FileTransfer).IsDownload {
+			return nil
+		}
+		return fmt.Errorf("the upload command is disabled because the agent is in read-only monitor mode")
+	case jobs.NATIVE:
+		return permitted(natives, job.Payload.(jobs.Command))
+	case jobs.MODULE:
+		return permitted(modules, job.Payload.(jobs.Command))
+	default:
+		return fmt.Errorf("%s jobs are disabled because the agent is in read-only monitor mode", job.Type)
+	}
+}
+
+// permitted returns an error if the command, or its subcommand, isn't in the list of permitted commands
+func permitted(commands map[string][]string, cmd jobs.Command) error {
+	name := strings.ToLower(cmd.Command)
+	var sub string
+	if len(cmd.Args) > 0 {
+		sub = strings.ToLower(cmd.Args[0])
+	}
+	for _, s := range commands[name] {
+		if s == ALL || s == sub {
+			return nil
+		}
+	}
+	if sub != "" && len(commands[name]) > 0 {
+		name = fmt.Sprintf("%s %s", name, sub)
+	}
+	return fmt.Errorf("the %s command is disabled because the agent is in read-only monitor mode", name)
+}
@@ -0,0 +1,132 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package netwatch detects when the host's network interfaces, addresses, or default route change (e.g., the host moved
+// to a different network or a VPN connected) so the Agent can re-evaluate its communication settings right away
+package netwatch
+
+import (
+	// Standard
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+)
+
+// routes are documentation addresses (RFC 5737 and RFC 3849) used to ask the operating system which local address
+// the default route uses. Dialing UDP doesn't send any packets
+var routes = []string{"203.0.113.1:53", "[2001:db8::1]:53"}
+
+// changed signals that a network change was detected
+var changed = make(chan struct{}, 1)
+
+// once ensures only one watcher is started
+var once sync.Once
+
+// Changed returns a channel that receives a value when a network change is detected
+func Changed() <-chan struct{} {
+	return changed
+}
+
+// Start polls the host's network configuration at the interval and signals the Changed channel when it differs from the
+// previous poll. An interval of 0 or less disables network change detection
+func Start(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	once.Do(func() {
+		go watch(interval)
+	})
+}
+
+// Parse converts a duration string (e.g., 10s) into the polling interval; an empty string or 0 disables detection
+func Parse(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("netwatch.Parse(): there was an error parsing the interval %s: %s", value, err)
+	}
+	return interval, nil
+}
+
+// Fingerprint returns a string describing the up, non-loopback interfaces, their addresses, and the local addresses
+// used by the default IPv4 and IPv6 routes. Any change to the network changes the fingerprint
+func Fingerprint() string {
+	var parts []string
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		cli.Message(cli.DEBUG, fmt.Sprintf("netwatch.Fingerprint(): there was an error getting the network interfaces: %s", err))
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			cli.Message(cli.DEBUG, fmt.Sprintf("netwatch.Fingerprint(): there was an error getting the %s interface addresses: %s", iface.Name, err))
+			continue
+		}
+		for _, addr := range addrs {
+			parts = append(parts, fmt.Sprintf("%s=%s", iface.Name, addr))
+		}
+	}
+	sort.Strings(parts)
+
+	for _, route := range routes {
+		local := "none"
+		conn, err := net.Dial("udp", route)
+		if err == nil {
+			local = conn.LocalAddr().(*net.UDPAddr).IP.String()
+			_ = conn.Close()
+		}
+		parts = append(parts, fmt.Sprintf("route=%s", local))
+	}
+	return strings.Join(parts, ",")
+}
+
+// watch polls the network fingerprint forever and signals the changed channel when it is different
+func watch(interval time.Duration) {
+	last := Fingerprint()
+	cli.Message(cli.DEBUG, fmt.Sprintf("netwatch.watch(): watching for network changes every %s starting with: %s", interval, last))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		current := Fingerprint()
+		if current == last {
+			continue
+		}
+		cli.Message(cli.NOTE, fmt.Sprintf("Network change detected at %s", time.Now().UTC().Format(time.RFC3339)))
+		cli.Message(cli.DEBUG, fmt.Sprintf("netwatch.watch(): network changed from %s to %s", last, current))
+		last = current
+		// Don't block if a previous change hasn't been handled yet
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package capability selects the variant of a technique that works on the host operating system version and reports
+// which variant was used
+package capability
+
+import (
+	// Standard
+	"fmt"
+	"sort"
+	"sync"
+
+	// Internal
+	merlinOS "github.com/Ne0nd0g/merlin-agent/v2/os"
+)
+
+// Variant is one implementation of a technique
+type Variant struct {
+	Name      string                      // Name is the variant's name (e.g., userapc)
+	Supported func(merlinOS.Version) bool // Supported returns true if the variant works on the operating system version; nil is always supported
+}
+
+// techniques is a map of technique names to their variants in order of preference
+var techniques = make(map[string][]Variant)
+
+// techniquesLock protects the techniques map from concurrent access
+var techniquesLock sync.RWMutex
+
+// Register adds the technique's variants in order of preference, the first supported variant is selected
+func Register(technique string, variants ...Variant) {
+	techniquesLock.Lock()
+	defer techniquesLock.Unlock()
+	techniques[technique] = append(techniques[technique], variants...)
+}
+
+// Select returns the most preferred variant of the technique that is supported by the host operating system version
+func Select(technique string) (variant string, version merlinOS.Version, err error) {
+	version, err = merlinOS.GetVersion()
+	if err != nil {
+		err = fmt.Errorf("os/capability.Select(): there was an error detecting the operating system version: %s", err)
+		return
+	}
+
+	techniquesLock.RLock()
+	variants, ok := techniques[technique]
+	techniquesLock.RUnlock()
+	if !ok {
+		err = fmt.Errorf("os/capability.Select(): unknown technique: %s", technique)
+		return
+	}
+
+	for _, v := range variants {
+		if v.Supported == nil || v.Supported(version) {
+			return v.Name, version, nil
+		}
+	}
+	err = fmt.Errorf("os/capability.Select(): no %s technique variant supports %s", technique, version)
+	return
+}
+
+// Report returns a line for job results that identifies the technique variant used on the operating system version
+func Report(technique, variant string, version merlinOS.Version) string {
+	return fmt.Sprintf("Selected %s variant \"%s\" for %s\n", technique, variant, version)
+}
+
+// Techniques returns the sorted names of all the registered techniques
+func Techniques() (names []string) {
+	techniquesLock.RLock()
+	defer techniquesLock.RUnlock()
+	for name := range techniques {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
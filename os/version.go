@@ -0,0 +1,109 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package os
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Version describes the host operating system so that techniques can select the variant that works on it
+type Version struct {
+	Platform string // Platform is the runtime.GOOS value (e.g., windows, darwin, or linux)
+	Name     string // Name is the operating system product name (e.g., Windows 11 or macOS Ventura)
+	Major    int    // Major is the major version number (kernel version on Linux and FreeBSD)
+	Minor    int    // Minor is the minor version number
+	Build    int    // Build is the build or patch number
+	Release  string // Release is the feature release name or full release string (e.g., 24H2 or 6.8.0-31-generic)
+	Locale   string // Locale is the user's locale name (e.g., en-US)
+}
+
+// version is the cached Version of the host operating system because it does not change while the Agent runs
+var version Version
+
+// versionErr is the error, if any, from detecting the host operating system version
+var versionErr error
+
+// versionOnce ensures the host operating system version is only detected once
+var versionOnce sync.Once
+
+// GetVersion returns the detected version and locale of the host operating system
+func GetVersion() (Version, error) {
+	versionOnce.Do(func() {
+		version, versionErr = getVersion()
+		version.Platform = runtime.GOOS
+		if version.Locale == "" {
+			version.Locale = envLocale()
+		}
+	})
+	return version, versionErr
+}
+
+// AtLeast returns true if the operating system version is greater than or equal to the provided version
+func (v Version) AtLeast(major, minor, build int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Build >= build
+}
+
+// String returns the operating system name, version number, and release
+func (v Version) String() string {
+	s := fmt.Sprintf("%s %d.%d.%d", v.Name, v.Major, v.Minor, v.Build)
+	if v.Release != "" {
+		s += fmt.Sprintf(" (%s)", v.Release)
+	}
+	return s
+}
+
+// envLocale returns the locale from the POSIX locale environment variables (e.g., en_US.UTF-8 becomes en-US)
+func envLocale() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" && value != "C" && value != "POSIX" {
+			value, _, _ = strings.Cut(value, ".")
+			value, _, _ = strings.Cut(value, "@")
+			return strings.ReplaceAll(value, "_", "-")
+		}
+	}
+	return ""
+}
+
+// parseVersion parses up to three dot separated version numbers, ignoring any non-numeric suffix
+func parseVersion(s string) (major, minor, patch int) {
+	parts := strings.SplitN(s, ".", 3)
+	numbers := make([]int, 3)
+	for i, part := range parts {
+		end := strings.IndexFunc(part, func(r rune) bool { return r < '0' || r > '9' })
+		if end >= 0 {
+			part = part[:end]
+		}
+		numbers[i], _ = strconv.Atoi(part)
+	}
+	return numbers[0], numbers[1], numbers[2]
+}
@@ -0,0 +1,57 @@
+//go:build darwin
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package os
+
+import (
+	// Standard
+	"fmt"
+
+	// X Packages
+	"golang.org/x/sys/unix"
+)
+
+// macOSReleases maps macOS major version numbers to their release names
+var macOSReleases = map[int]string{
+	11: "Big Sur",
+	12: "Monterey",
+	13: "Ventura",
+	14: "Sonoma",
+	15: "Sequoia",
+	26: "Tahoe",
+}
+
+// getVersion reads the macOS product version (e.g., 13.4.1) from the kern.osproductversion sysctl
+func getVersion() (v Version, err error) {
+	v.Name = "macOS"
+	product, err := unix.Sysctl("kern.osproductversion")
+	if err != nil {
+		err = fmt.Errorf("os.getVersion(): there was an error reading the kern.osproductversion sysctl: %s", err)
+		return
+	}
+	v.Major, v.Minor, v.Build = parseVersion(product)
+	if name, ok := macOSReleases[v.Major]; ok {
+		v.Release = name
+		v.Name = "macOS " + name
+	}
+	return
+}
@@ -0,0 +1,45 @@
+//go:build linux || freebsd
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package os
+
+import (
+	// Standard
+	"fmt"
+
+	// X Packages
+	"golang.org/x/sys/unix"
+)
+
+// getVersion uses uname to detect the operating system name and kernel version
+func getVersion() (v Version, err error) {
+	var uname unix.Utsname
+	err = unix.Uname(&uname)
+	if err != nil {
+		err = fmt.Errorf("os.getVersion(): there was an error calling uname: %s", err)
+		return
+	}
+	v.Name = unix.ByteSliceToString(uname.Sysname[:])
+	v.Release = unix.ByteSliceToString(uname.Release[:])
+	v.Major, v.Minor, v.Build = parseVersion(v.Release)
+	return
+}
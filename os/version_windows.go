@@ -0,0 +1,94 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package os
+
+import (
+	// X Packages
+	"golang.org/x/sys/windows"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/kernel32"
+)
+
+// windowsReleases maps Windows 10 and 11 build numbers to their feature release names, newest first
+var windowsReleases = []struct {
+	build   int
+	release string
+}{
+	{26100, "24H2"},
+	{22631, "23H2"},
+	{22621, "22H2"},
+	{22000, "21H2"},
+	{19045, "22H2"},
+	{19044, "21H2"},
+	{19043, "21H1"},
+	{19042, "20H2"},
+	{19041, "2004"},
+	{18363, "1909"},
+	{18362, "1903"},
+	{17763, "1809"},
+	{17134, "1803"},
+	{16299, "1709"},
+	{15063, "1703"},
+	{14393, "1607"},
+	{10586, "1511"},
+	{10240, "1507"},
+}
+
+// getVersion uses RtlGetVersion, which is not affected by application compatibility shims, to detect the Windows version
+func getVersion() (v Version, err error) {
+	info := windows.RtlGetVersion()
+	v.Major = int(info.MajorVersion)
+	v.Minor = int(info.MinorVersion)
+	v.Build = int(info.BuildNumber)
+
+	server := info.ProductType != 1 // VER_NT_WORKSTATION
+	switch {
+	case v.Major == 10 && server:
+		v.Name = "Windows Server"
+	case v.Major == 10 && v.Build >= 22000:
+		v.Name = "Windows 11"
+	case v.Major == 10:
+		v.Name = "Windows 10"
+	case v.Major == 6 && v.Minor == 3:
+		v.Name = "Windows 8.1"
+	case v.Major == 6 && v.Minor == 2:
+		v.Name = "Windows 8"
+	case v.Major == 6 && v.Minor == 1:
+		v.Name = "Windows 7"
+	default:
+		v.Name = "Windows"
+	}
+
+	if v.Major == 10 && !server {
+		for _, r := range windowsReleases {
+			if v.Build >= r.build {
+				v.Release = r.release
+				break
+			}
+		}
+	}
+
+	v.Locale, err = kernel32.GetUserDefaultLocaleName()
+	return
+}
@@ -30,6 +30,9 @@ import (
 
 	// X Packages
 	"golang.org/x/sys/windows"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/resolve"
 )
 
 var Advapi32 = windows.NewLazySystemDLL("Advapi32.dll")
@@ -39,7 +42,7 @@ var Advapi32 = windows.NewLazySystemDLL("Advapi32.dll")
 // (user, domain, and password). It can optionally load the user profile for a specified user.
 // https://docs.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-createprocesswithlogonw
 func CreateProcessWithLogon(lpUsername *uint16, lpDomain *uint16, lpPassword *uint16, dwLogonFlags uint32, lpApplicationName *uint16, lpCommandLine *uint16, dwCreationFlags uint32, lpEnvironment uintptr, lpCurrentDirectory *uint16, lpStartupInfo *windows.StartupInfo, lpProcessInformation *windows.ProcessInformation) error {
-	CreateProcessWithLogonW := Advapi32.NewProc("CreateProcessWithLogonW")
+	CreateProcessWithLogonW := resolve.NewProc(Advapi32, 0xefed75c5) // CreateProcessWithLogonW
 
 	// Parse optional arguments
 	var domain uintptr
@@ -118,7 +121,8 @@ func CreateProcessWithTokenW(hToken, dwLogonFlags, lpApplicationName, lpCommandL
 	//  [in]                LPSTARTUPINFOW        lpStartupInfo,
 	//  [out]               LPPROCESS_INFORMATION lpProcessInformation
 	//);
-	ret, _, err := Advapi32.NewProc("CreateProcessWithTokenW").Call(
+	// CreateProcessWithTokenW
+	ret, _, err := resolve.NewProc(Advapi32, 0x9b0bbabb).Call(
 		hToken,
 		dwLogonFlags,
 		lpApplicationName,
@@ -136,11 +140,85 @@ func CreateProcessWithTokenW(hToken, dwLogonFlags, lpApplicationName, lpCommandL
 	return nil
 }
 
+// Credential types and persistence values used with CredEnumerate
+// https://learn.microsoft.com/en-us/windows/win32/api/wincred/ns-wincred-credentialw
+const (
+	CRED_TYPE_GENERIC                 uint32 = 1
+	CRED_TYPE_DOMAIN_PASSWORD         uint32 = 2
+	CRED_TYPE_DOMAIN_CERTIFICATE      uint32 = 3
+	CRED_TYPE_DOMAIN_VISIBLE_PASSWORD uint32 = 4
+	CRED_TYPE_GENERIC_CERTIFICATE     uint32 = 5
+	CRED_TYPE_DOMAIN_EXTENDED         uint32 = 6
+	CRED_PERSIST_SESSION              uint32 = 1
+	CRED_PERSIST_LOCAL_MACHINE        uint32 = 2
+	CRED_PERSIST_ENTERPRISE           uint32 = 3
+	// CRED_ENUMERATE_ALL_CREDENTIALS enumerates every credential in the user's credential set; the filter must be nil
+	CRED_ENUMERATE_ALL_CREDENTIALS uint32 = 0x1
+)
+
+// CREDENTIAL contains an individual credential from the user's Credential Manager
+// https://learn.microsoft.com/en-us/windows/win32/api/wincred/ns-wincred-credentialw
+type CREDENTIAL struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// CredEnumerate enumerates the credentials from the user's credential set whose target name matches the filter.
+// The returned buffer must be freed with CredFree
+// https://learn.microsoft.com/en-us/windows/win32/api/wincred/nf-wincred-credenumeratew
+func CredEnumerate(filter *uint16, flags uint32) (credentials []*CREDENTIAL, buffer unsafe.Pointer, err error) {
+	CredEnumerateW := Advapi32.NewProc("CredEnumerateW")
+
+	// BOOL CredEnumerateW(
+	//  [in]  LPCWSTR      Filter,
+	//  [in]  DWORD        Flags,
+	//  [out] DWORD        *Count,
+	//  [out] PCREDENTIALW **Credential
+	//);
+
+	var count uint32
+	ret, _, err := CredEnumerateW.Call(
+		uintptr(unsafe.Pointer(filter)),
+		uintptr(flags),
+		uintptr(unsafe.Pointer(&count)),
+		uintptr(unsafe.Pointer(&buffer)),
+	)
+	if ret == 0 {
+		// ERROR_NOT_FOUND means there are no credentials
+		if err == windows.ERROR_NOT_FOUND {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("there was an error calling advapi32!CredEnumerateW: %s", err)
+	}
+	return unsafe.Slice((**CREDENTIAL)(buffer), count), buffer, nil
+}
+
+// CredFree frees a buffer returned by any of the credentials management functions
+// https://learn.microsoft.com/en-us/windows/win32/api/wincred/nf-wincred-credfree
+func CredFree(buffer unsafe.Pointer) {
+	credFree := Advapi32.NewProc("CredFree")
+
+	// void CredFree(
+	//  [in] PVOID Buffer
+	//);
+	_, _, _ = credFree.Call(uintptr(buffer))
+}
+
 // ImpersonateLoggedOnUser lets the calling thread impersonate the security context of a logged-on user.
 // The user is represented by a token handle.
 // https://docs.microsoft.com/en-us/windows/win32/api/securitybaseapi/nf-securitybaseapi-impersonateloggedonuser
 func ImpersonateLoggedOnUser(hToken windows.Token) (err error) {
-	impersonateLoggedOnUser := Advapi32.NewProc("ImpersonateLoggedOnUser")
+	impersonateLoggedOnUser := resolve.NewProc(Advapi32, 0xd10a2e5d) // ImpersonateLoggedOnUser
 
 	// BOOL ImpersonateLoggedOnUser(
 	//  [in] HANDLE hToken
@@ -162,7 +240,7 @@ func ImpersonateLoggedOnUser(hToken windows.Token) (err error) {
 // https://docs.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-logonuserw
 func LogonUser(lpszUsername *uint16, lpszDomain *uint16, lpszPassword *uint16, dwLogonType uint32, dwLogonProvider uint32) (token *unsafe.Pointer, err error) {
 	// The LogonUser function was not available in the golang.org/x/sys/windows package at the time of writing
-	LogonUserW := Advapi32.NewProc("LogonUserW")
+	LogonUserW := resolve.NewProc(Advapi32, 0xb9089ed9) // LogonUserW
 
 	// BOOL LogonUserW(
 	//  [in]           LPCWSTR lpszUsername,
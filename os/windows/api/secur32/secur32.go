@@ -0,0 +1,247 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package secur32 wraps the Security Support Provider Interface (SSPI) functions used to establish a client security
+// context, such as a Kerberos context with the current user's domain credentials
+package secur32
+
+import (
+	// Standard
+	"fmt"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+)
+
+var Secur32 = windows.NewLazySystemDLL("Secur32.dll")
+
+// Constants used with the SSPI functions
+// https://learn.microsoft.com/en-us/windows/win32/api/sspi/nf-sspi-initializesecuritycontextw
+const (
+	// SECPKG_CRED_OUTBOUND acquires credentials used to initialize a client security context
+	SECPKG_CRED_OUTBOUND uint32 = 0x2
+	// SECURITY_NATIVE_DREP is the native data representation of the target
+	SECURITY_NATIVE_DREP uint32 = 0x10
+	// SECBUFFER_VERSION is the version of the SecBufferDesc structure
+	SECBUFFER_VERSION uint32 = 0
+	// SECBUFFER_TOKEN is a buffer holding a security token
+	SECBUFFER_TOKEN uint32 = 2
+	// SECPKG_ATTR_SESSION_KEY queries the session key of an established context
+	SECPKG_ATTR_SESSION_KEY uint32 = 9
+
+	// ISC_REQ_MUTUAL_AUTH requires the server to authenticate to the client
+	ISC_REQ_MUTUAL_AUTH uint32 = 0x2
+	// ISC_REQ_CONFIDENTIALITY requests that messages can be encrypted
+	ISC_REQ_CONFIDENTIALITY uint32 = 0x10
+	// ISC_REQ_ALLOCATE_MEMORY has the security package allocate output buffers, which are freed with FreeContextBuffer
+	ISC_REQ_ALLOCATE_MEMORY uint32 = 0x100
+	// ISC_REQ_INTEGRITY requests that messages can be signed
+	ISC_REQ_INTEGRITY uint32 = 0x10000
+
+	// SEC_E_OK is returned when the security context was established
+	SEC_E_OK uint32 = 0
+	// SEC_I_CONTINUE_NEEDED is returned when the output token must be sent to the server and its response provided
+	SEC_I_CONTINUE_NEEDED uint32 = 0x00090312
+)
+
+// SecHandle is a credential or security context handle
+// https://learn.microsoft.com/en-us/windows/win32/secauthn/sechandle
+type SecHandle struct {
+	Lower uintptr
+	Upper uintptr
+}
+
+// TimeStamp is the local time a credential or context expires
+type TimeStamp struct {
+	LowPart  uint32
+	HighPart int32
+}
+
+// SecBuffer describes a buffer passed to or returned by a security package
+// https://learn.microsoft.com/en-us/windows/win32/api/sspi/ns-sspi-secbuffer
+type SecBuffer struct {
+	Size   uint32
+	Type   uint32
+	Buffer *byte
+}
+
+// SecBufferDesc describes an array of SecBuffer structures
+// https://learn.microsoft.com/en-us/windows/win32/api/sspi/ns-sspi-secbufferdesc
+type SecBufferDesc struct {
+	Version uint32
+	Count   uint32
+	Buffers *SecBuffer
+}
+
+// SecPkgContext_SessionKey holds the session key of an established security context
+// https://learn.microsoft.com/en-us/windows/win32/api/sspi/ns-sspi-secpkgcontext_sessionkey
+type SecPkgContext_SessionKey struct {
+	SessionKeyLength uint32
+	SessionKey       *byte
+}
+
+// AcquireCredentialsHandle acquires a handle to the current user's credentials for the security package (e.g., Kerberos)
+// https://learn.microsoft.com/en-us/windows/win32/api/sspi/nf-sspi-acquirecredentialshandlew
+func AcquireCredentialsHandle(pkg string, use uint32) (credential SecHandle, err error) {
+	AcquireCredentialsHandleW := Secur32.NewProc("AcquireCredentialsHandleW")
+
+	// SECURITY_STATUS SEC_ENTRY AcquireCredentialsHandleW(
+	//  [in, optional] LPWSTR         pszPrincipal,
+	//  [in]           LPWSTR         pszPackage,
+	//  [in]           unsigned long  fCredentialUse,
+	//  [in, optional] void           *pvLogonId,
+	//  [in, optional] void           *pAuthData,
+	//  [in, optional] SEC_GET_KEY_FN pGetKeyFn,
+	//  [in, optional] void           *pvGetKeyArgument,
+	//  [out]          PCredHandle    phCredential,
+	//  [out, optional] PTimeStamp    ptsExpiry
+	//);
+
+	pszPackage, err := windows.UTF16PtrFromString(pkg)
+	if err != nil {
+		return
+	}
+	var expiry TimeStamp
+	ret, _, _ := AcquireCredentialsHandleW.Call(
+		0,
+		uintptr(unsafe.Pointer(pszPackage)),
+		uintptr(use),
+		0,
+		0,
+		0,
+		0,
+		uintptr(unsafe.Pointer(&credential)),
+		uintptr(unsafe.Pointer(&expiry)),
+	)
+	if uint32(ret) != SEC_E_OK {
+		err = fmt.Errorf("there was an error calling AcquireCredentialsHandleW: %s", windows.Errno(ret))
+	}
+	return
+}
+
+// InitializeSecurityContext builds the next client token for the target from the server's input token, which is nil
+// on the first call, and returns the output token and the SECURITY_STATUS. The context is nil on the first call and
+// the returned context is used for the following calls
+// https://learn.microsoft.com/en-us/windows/win32/api/sspi/nf-sspi-initializesecuritycontextw
+func InitializeSecurityContext(credential *SecHandle, context *SecHandle, target string, flags uint32, input []byte) (newContext SecHandle, output []byte, status uint32, err error) {
+	InitializeSecurityContextW := Secur32.NewProc("InitializeSecurityContextW")
+
+	// SECURITY_STATUS SEC_ENTRY InitializeSecurityContextW(
+	//  [in, optional]      PCredHandle      phCredential,
+	//  [in, optional]      PCtxtHandle      phContext,
+	//  [in, optional]      SEC_WCHAR        *pszTargetName,
+	//  [in]                unsigned long    fContextReq,
+	//  [in]                unsigned long    Reserved1,
+	//  [in]                unsigned long    TargetDataRep,
+	//  [in, optional]      PSecBufferDesc   pInput,
+	//  [in]                unsigned long    Reserved2,
+	//  [in, out, optional] PCtxtHandle      phNewContext,
+	//  [in, out, optional] PSecBufferDesc   pOutput,
+	//  [out]               unsigned long    *pfContextAttr,
+	//  [out, optional]     PTimeStamp       ptsExpiry
+	//);
+
+	pszTargetName, err := windows.UTF16PtrFromString(target)
+	if err != nil {
+		return
+	}
+
+	var pInput uintptr
+	if len(input) > 0 {
+		inBuffer := SecBuffer{Size: uint32(len(input)), Type: SECBUFFER_TOKEN, Buffer: &input[0]}
+		inDesc := SecBufferDesc{Version: SECBUFFER_VERSION, Count: 1, Buffers: &inBuffer}
+		pInput = uintptr(unsafe.Pointer(&inDesc))
+	}
+	outBuffer := SecBuffer{Type: SECBUFFER_TOKEN}
+	outDesc := SecBufferDesc{Version: SECBUFFER_VERSION, Count: 1, Buffers: &outBuffer}
+
+	var attributes uint32
+	var expiry TimeStamp
+	ret, _, _ := InitializeSecurityContextW.Call(
+		uintptr(unsafe.Pointer(credential)),
+		uintptr(unsafe.Pointer(context)),
+		uintptr(unsafe.Pointer(pszTargetName)),
+		uintptr(flags|ISC_REQ_ALLOCATE_MEMORY),
+		0,
+		uintptr(SECURITY_NATIVE_DREP),
+		pInput,
+		0,
+		uintptr(unsafe.Pointer(&newContext)),
+		uintptr(unsafe.Pointer(&outDesc)),
+		uintptr(unsafe.Pointer(&attributes)),
+		uintptr(unsafe.Pointer(&expiry)),
+	)
+	status = uint32(ret)
+	if outBuffer.Buffer != nil {
+		output = append([]byte{}, unsafe.Slice(outBuffer.Buffer, outBuffer.Size)...)
+		FreeContextBuffer(unsafe.Pointer(outBuffer.Buffer))
+	}
+	if status != SEC_E_OK && status != SEC_I_CONTINUE_NEEDED {
+		err = fmt.Errorf("there was an error calling InitializeSecurityContextW: %s", windows.Errno(ret))
+	}
+	return
+}
+
+// QuerySessionKey returns the session key of an established security context
+// https://learn.microsoft.com/en-us/windows/win32/api/sspi/nf-sspi-querycontextattributesw
+func QuerySessionKey(context *SecHandle) ([]byte, error) {
+	QueryContextAttributesW := Secur32.NewProc("QueryContextAttributesW")
+
+	// SECURITY_STATUS SEC_ENTRY QueryContextAttributesW(
+	//  [in]  PCtxtHandle   phContext,
+	//  [in]  unsigned long ulAttribute,
+	//  [out] void          *pBuffer
+	//);
+
+	var key SecPkgContext_SessionKey
+	ret, _, _ := QueryContextAttributesW.Call(uintptr(unsafe.Pointer(context)), uintptr(SECPKG_ATTR_SESSION_KEY), uintptr(unsafe.Pointer(&key)))
+	if uint32(ret) != SEC_E_OK {
+		return nil, fmt.Errorf("there was an error calling QueryContextAttributesW: %s", windows.Errno(ret))
+	}
+	if key.SessionKey == nil {
+		return nil, fmt.Errorf("the security context does not have a session key")
+	}
+	defer FreeContextBuffer(unsafe.Pointer(key.SessionKey))
+	return append([]byte{}, unsafe.Slice(key.SessionKey, key.SessionKeyLength)...), nil
+}
+
+// DeleteSecurityContext deletes the security context
+// https://learn.microsoft.com/en-us/windows/win32/api/sspi/nf-sspi-deletesecuritycontext
+func DeleteSecurityContext(context *SecHandle) {
+	DeleteSecurityContext := Secur32.NewProc("DeleteSecurityContext")
+	_, _, _ = DeleteSecurityContext.Call(uintptr(unsafe.Pointer(context)))
+}
+
+// FreeCredentialsHandle releases the credentials handle
+// https://learn.microsoft.com/en-us/windows/win32/api/sspi/nf-sspi-freecredentialshandle
+func FreeCredentialsHandle(credential *SecHandle) {
+	FreeCredentialsHandle := Secur32.NewProc("FreeCredentialsHandle")
+	_, _, _ = FreeCredentialsHandle.Call(uintptr(unsafe.Pointer(credential)))
+}
+
+// FreeContextBuffer frees a buffer the security package allocated
+// https://learn.microsoft.com/en-us/windows/win32/api/sspi/nf-sspi-freecontextbuffer
+func FreeContextBuffer(buffer unsafe.Pointer) {
+	FreeContextBuffer := Secur32.NewProc("FreeContextBuffer")
+	_, _, _ = FreeContextBuffer.Call(uintptr(buffer))
+}
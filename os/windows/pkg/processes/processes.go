@@ -28,6 +28,8 @@ import (
 	"os/exec"
 	"strings"
 	"syscall"
+	"unicode/utf16"
+	"unsafe"
 
 	// X Packages
 	"golang.org/x/sys/windows"
@@ -35,6 +37,7 @@ import (
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/advapi32"
 	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/pipes"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/text"
 )
 
 // LOGON_ The logon option
@@ -189,3 +192,145 @@ func CreateProcessWithLogon(username string, domain string, password string, app
 	}
 	return
 }
+
+// CreateProcess creates a new process on the provided window station and desktop (e.g., winsta0\default) and returns
+// its output. If a token is provided, the process is created with CreateProcessAsUser in the security context and
+// session of the token. If env is not nil, it is used as the process's environment block instead of inheriting it
+func CreateProcess(token windows.Token, application string, args []string, desktop string, env []string, hide bool) (stdout string, stderr string) {
+	if application == "" {
+		stderr = "an application must be provided for the CreateProcess call"
+		return
+	}
+
+	// Search PATH environment variable to retrieve the application's absolute path
+	application, err := exec.LookPath(application)
+	if err != nil {
+		stderr = fmt.Sprintf("there was an error resolving the absolute path for %s: %s", application, err)
+		return
+	}
+
+	// Convert the application to a LPCWSTR
+	lpApplicationName, err := syscall.UTF16PtrFromString(application)
+	if err != nil {
+		stderr = fmt.Sprintf("there was an error converting the application name \"%s\" to LPCWSTR: %s", application, err)
+		return
+	}
+
+	// Convert the command line to a LPWSTR; the first token must be the application
+	commandLine := windows.ComposeCommandLine(append([]string{application}, args...))
+	lpCommandLine, err := syscall.UTF16PtrFromString(commandLine)
+	if err != nil {
+		stderr = fmt.Sprintf("there was an error converting the command line \"%s\" to LPWSTR: %s", commandLine, err)
+		return
+	}
+
+	dwCreationFlags := uint32(windows.CREATE_UNICODE_ENVIRONMENT)
+	var lpEnvironment *uint16
+	if env != nil {
+		lpEnvironment, err = environmentBlock(env)
+		if err != nil {
+			stderr = err.Error()
+			return
+		}
+	}
+
+	// Setup pipes to retrieve output
+	stdInRead, _, stdOutRead, stdOutWrite, stdErrRead, stdErrWrite, err := pipes.CreateAnonymousPipes()
+	if err != nil {
+		stderr = fmt.Sprintf("there was an error creating anonymous pipes to collect output: %s", err)
+		return
+	}
+
+	lpStartupInfo := windows.StartupInfo{
+		StdInput:  stdInRead,
+		StdOutput: stdOutWrite,
+		StdErr:    stdErrWrite,
+		Flags:     windows.STARTF_USESTDHANDLES,
+	}
+	lpStartupInfo.Cb = uint32(unsafe.Sizeof(lpStartupInfo))
+	if hide {
+		lpStartupInfo.Flags = windows.STARTF_USESTDHANDLES | windows.STARTF_USESHOWWINDOW
+		lpStartupInfo.ShowWindow = windows.SW_HIDE
+	}
+	if desktop != "" {
+		lpStartupInfo.Desktop, err = syscall.UTF16PtrFromString(desktop)
+		if err != nil {
+			stderr = fmt.Sprintf("there was an error converting the desktop \"%s\" to LPWSTR: %s", desktop, err)
+			return
+		}
+	}
+	lpProcessInformation := windows.ProcessInformation{}
+
+	if token != 0 {
+		err = windows.CreateProcessAsUser(token, lpApplicationName, lpCommandLine, nil, nil, true, dwCreationFlags, lpEnvironment, nil, &lpStartupInfo, &lpProcessInformation)
+	} else {
+		err = windows.CreateProcess(lpApplicationName, lpCommandLine, nil, nil, true, dwCreationFlags, lpEnvironment, nil, &lpStartupInfo, &lpProcessInformation)
+	}
+	if err != nil {
+		stderr = fmt.Sprintf("there was an error creating the %s process: %s", application, err)
+		_ = pipes.ClosePipes(stdInRead, 0, stdOutRead, stdOutWrite, stdErrRead, stdErrWrite)
+		return
+	}
+	defer func() {
+		_ = windows.CloseHandle(lpProcessInformation.Process)
+		_ = windows.CloseHandle(lpProcessInformation.Thread)
+	}()
+
+	stdout += fmt.Sprintf("Created %s process with an ID of %d\n", application, lpProcessInformation.ProcessId)
+
+	// Close the "write" pipe handles
+	err = pipes.ClosePipes(0, 0, 0, stdOutWrite, 0, stdErrWrite)
+	if err != nil {
+		stderr = err.Error()
+		return
+	}
+
+	// Read from the pipes
+	var out, outErr string
+	_, out, outErr, err = pipes.ReadPipes(0, stdOutRead, stdErrRead)
+	if err != nil {
+		stderr += err.Error()
+		return
+	}
+
+	// Convert the output to a UTF-8 string
+	decoded, err := text.DecodeString([]byte(out))
+	if err != nil {
+		stderr += fmt.Sprintf("%s\n", err)
+	} else {
+		stdout += decoded
+	}
+	if outErr != "" {
+		decoded, err = text.DecodeString([]byte(outErr))
+		if err != nil {
+			decoded = outErr
+		}
+		stderr += decoded
+	}
+
+	// Close the "read" pipe handles
+	err = pipes.ClosePipes(stdInRead, 0, stdOutRead, 0, stdErrRead, 0)
+	if err != nil {
+		stderr += err.Error()
+	}
+	return
+}
+
+// environmentBlock converts a list of KEY=VALUE strings into a Unicode environment block used with CreateProcess
+// https://learn.microsoft.com/en-us/windows/win32/procthread/changing-environment-variables
+func environmentBlock(env []string) (*uint16, error) {
+	var block []uint16
+	for _, variable := range env {
+		if strings.Contains(variable, "\x00") {
+			return nil, fmt.Errorf("the environment variable \"%s\" contains a NULL byte", variable)
+		}
+		block = append(block, utf16.Encode([]rune(variable))...)
+		block = append(block, 0)
+	}
+	// An empty block must still be terminated by two NULL characters
+	if len(block) == 0 {
+		block = append(block, 0)
+	}
+	block = append(block, 0)
+	return &block[0], nil
+}
@@ -0,0 +1,173 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package resolve finds Windows API procedures at runtime by walking the export table of their loaded DLL and comparing
+// a hash of each exported name, so the procedures resolved this way don't have import table entries and aren't looked
+// up by name with GetProcAddress. It does not remove the names from the binary; other packages, such as the
+// golang.org/x/sys/windows lazy procedures and error messages, still contain many of them as strings
+package resolve
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+)
+
+// seed is the starting value of the hash so that the hashes don't match published tables for the FNV-1a hash
+const seed uint32 = 0x6d65726c
+
+// Proc is a procedure, identified by the hash of its name, that is resolved the first time it is used. It can be used
+// in place of a windows.LazyProc
+type Proc struct {
+	dll  *windows.LazyDLL
+	hash uint32
+	once sync.Once
+	addr uintptr
+	err  error
+}
+
+// Hash returns the hash of the procedure name; it is used to generate the hashes passed to NewProc
+func Hash(name string) uint32 {
+	h := seed
+	for i := 0; i < len(name); i++ {
+		h = (h ^ uint32(name[i])) * 0x01000193
+	}
+	return h
+}
+
+// NewProc returns a Proc for the procedure whose name has the hash in the DLL
+func NewProc(dll *windows.LazyDLL, hash uint32) *Proc {
+	return &Proc{dll: dll, hash: hash}
+}
+
+// Find loads the DLL and resolves the procedure's address if it hasn't been already
+func (p *Proc) Find() error {
+	p.once.Do(func() {
+		p.err = p.dll.Load()
+		if p.err != nil {
+			return
+		}
+		p.addr, p.err = address(p.dll.Handle(), p.hash, 0)
+		if p.err != nil {
+			p.err = fmt.Errorf("there was an error resolving procedure 0x%08x in %s: %s", p.hash, p.dll.Name, p.err)
+		}
+	})
+	return p.err
+}
+
+// Addr returns the address of the procedure and panics if it can't be resolved, like windows.LazyProc
+func (p *Proc) Addr() uintptr {
+	err := p.Find()
+	if err != nil {
+		panic(err)
+	}
+	return p.addr
+}
+
+// Call calls the procedure with the arguments and panics if it can't be resolved. The return values match
+// windows.LazyProc's Call
+func (p *Proc) Call(args ...uintptr) (r1, r2 uintptr, lastErr error) {
+	return syscall.SyscallN(p.Addr(), args...)
+}
+
+// forwarders is the number of forwarded exports that are followed before giving up
+const forwarders = 4
+
+// address walks the export table of the module loaded at the base address and returns the address of the export whose
+// name has the hash. Forwarded exports are followed by loading the DLL they are forwarded to
+func address(base uintptr, hash uint32, depth int) (uintptr, error) {
+	nt := base + uintptr(read32(base+0x3C))
+	if read32(nt) != 0x4550 {
+		return 0, fmt.Errorf("the module does not have a PE signature")
+	}
+	// The data directories follow the 96 byte PE32 or 112 byte PE32+ optional header fields
+	optional := nt + 24
+	directories := optional + 96
+	if read16(optional) == 0x20b {
+		directories = optional + 112
+	}
+	exports := base + uintptr(read32(directories))
+	exportsSize := uintptr(read32(directories + 4))
+	if exports == base {
+		return 0, fmt.Errorf("the module does not have an export table")
+	}
+
+	names := base + uintptr(read32(exports+32))
+	ordinals := base + uintptr(read32(exports+36))
+	functions := base + uintptr(read32(exports+28))
+	for i := uintptr(0); i < uintptr(read32(exports+24)); i++ {
+		name := cString(base + uintptr(read32(names+i*4)))
+		if Hash(name) != hash {
+			continue
+		}
+		addr := base + uintptr(read32(functions+uintptr(read16(ordinals+i*2))*4))
+		// An address inside the export table is a forwarder string such as "NTDLL.RtlAllocateHeap"
+		if addr < exports || addr >= exports+exportsSize {
+			return addr, nil
+		}
+		if depth >= forwarders {
+			return 0, fmt.Errorf("too many forwarded exports")
+		}
+		forward := cString(addr)
+		dll, proc, found := strings.Cut(forward, ".")
+		if !found || strings.HasPrefix(proc, "#") {
+			return 0, fmt.Errorf("unsupported forwarded export %s", forward)
+		}
+		module, err := windows.LoadLibrary(dll + ".dll")
+		if err != nil {
+			return 0, fmt.Errorf("there was an error loading %s for forwarded export %s: %s", dll, forward, err)
+		}
+		return address(uintptr(module), Hash(proc), depth+1)
+	}
+	return 0, fmt.Errorf("the procedure was not found")
+}
+
+// cString returns the NUL-terminated string at the address in the Agent's process
+func cString(addr uintptr) string {
+	var s strings.Builder
+	for b := *(*byte)(pointer(addr)); b != 0; b = *(*byte)(pointer(addr)) {
+		s.WriteByte(b)
+		addr++
+	}
+	return s.String()
+}
+
+// read16 returns the 16-bit value at the address in the Agent's process
+func read16(addr uintptr) uint16 {
+	return *(*uint16)(pointer(addr))
+}
+
+// read32 returns the 32-bit value at the address in the Agent's process
+func read32(addr uintptr) uint32 {
+	return *(*uint32)(pointer(addr))
+}
+
+// pointer converts an address in the Agent's process, such as a loaded module's image, to a pointer
+func pointer(addr uintptr) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&addr))
+}
@@ -26,61 +26,166 @@ import (
 	// Standard
 	"bytes"
 	"fmt"
-	"io"
+	"unicode/utf8"
 
 	// X Packages
 	"golang.org/x/sys/windows"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
 	"golang.org/x/text/encoding/japanese"
 	"golang.org/x/text/encoding/korean"
 	"golang.org/x/text/encoding/simplifiedchinese"
 	"golang.org/x/text/encoding/traditionalchinese"
-	"golang.org/x/text/transform"
+	"golang.org/x/text/encoding/unicode"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/kernel32"
 )
 
-// DecodeString decodes a byte slice to a string using the current code page
+// DecodeString decodes a byte slice to a UTF-8 string.
+// UTF-16LE output, as written by some Windows programs (e.g., PowerShell or cmd.exe /U), is detected and converted first.
+// Valid UTF-8 is returned as is. Everything else is decoded with the OEM code page, used by console programs, and then
+// the ANSI code page if there isn't a decoder for the OEM code page
 func DecodeString(encoded []byte) (decoded string, err error) {
-	codePage := windows.GetACP()
+	if IsUTF16LE(encoded) {
+		return Decode(encoded, 1200)
+	}
 
-	switch codePage {
-	// 437 is the default code page for US English
-	case 437:
+	if utf8.Valid(encoded) {
 		decoded = string(encoded)
 		return
+	}
+
+	codePage := kernel32.GetOEMCP()
+	if Decoder(codePage) == nil {
+		codePage = windows.GetACP()
+	}
+	return Decode(encoded, codePage)
+}
+
+// Decode decodes a byte slice to a UTF-8 string using the provided code page identifier
+// https://learn.microsoft.com/en-us/windows/win32/intl/code-page-identifiers
+func Decode(encoded []byte, codePage uint32) (decoded string, err error) {
+	decoder := Decoder(codePage)
+	if decoder == nil {
+		decoded = fmt.Sprintf("\n***The output was not valid UTF-8 and there isn't a configured decoder for code page %d***\n\n", codePage)
+		decoded += string(bytes.ToValidUTF8(encoded, []byte("�")))
+		return
+	}
+	t, e := decoder.NewDecoder().Bytes(encoded)
+	if e != nil {
+		err = fmt.Errorf("os/windows/pkg/text.Decode(): there was an error decoding the string from code page %d: %s", codePage, e)
+		return
+	}
+	decoded = string(t)
+	return
+}
+
+// Decoder returns the text encoding for the provided code page identifier or nil if the code page is not handled
+func Decoder(codePage uint32) encoding.Encoding {
+	switch codePage {
+	// 437 is the OEM code page for US English
+	case 437:
+		return charmap.CodePage437
+	// 850 is the OEM code page for Western European languages
+	case 850:
+		return charmap.CodePage850
+	// 852 is the OEM code page for Central European languages
+	case 852:
+		return charmap.CodePage852
+	// 855 is the OEM code page for Cyrillic
+	case 855:
+		return charmap.CodePage855
+	// 858 is the OEM code page for Western European languages with the Euro symbol
+	case 858:
+		return charmap.CodePage858
+	// 860 is the OEM code page for Portuguese
+	case 860:
+		return charmap.CodePage860
+	// 862 is the OEM code page for Hebrew
+	case 862:
+		return charmap.CodePage862
+	// 863 is the OEM code page for French Canadian
+	case 863:
+		return charmap.CodePage863
+	// 865 is the OEM code page for Nordic languages
+	case 865:
+		return charmap.CodePage865
+	// 866 is the OEM code page for Russian
+	case 866:
+		return charmap.CodePage866
+	// 874 is the default code page for Thai
+	case 874:
+		return charmap.Windows874
 	// 932 is the default code page for Japanese
 	case 932:
-		t, e := io.ReadAll(transform.NewReader(bytes.NewReader(encoded), japanese.ShiftJIS.NewDecoder()))
-		if e != nil {
-			err = fmt.Errorf("os/windows/pkg/text.DecodeString(): there was an error decoding the string to ShiftJIS: %s", e)
-			return
-		}
-		decoded = string(t)
+		return japanese.ShiftJIS
 	// 936 is the default code page for Simplified Chinese
 	case 936:
-		t, e := io.ReadAll(transform.NewReader(bytes.NewReader(encoded), simplifiedchinese.GBK.NewDecoder()))
-		if e != nil {
-			err = fmt.Errorf("os/windows/pkg/text.DecodeString(): there was an error decoding the string to Simplified Chinese GBK: %s", e)
-			return
-		}
-		decoded = string(t)
+		return simplifiedchinese.GBK
 	// 949 is the default code page for Korean
 	case 949:
-		t, e := io.ReadAll(transform.NewReader(bytes.NewReader(encoded), korean.EUCKR.NewDecoder()))
-		if e != nil {
-			err = fmt.Errorf("os/windows/pkg/text.DecodeString(): there was an error decoding the string to Korean EUCKR: %s", e)
-			return
-		}
-		decoded = string(t)
+		return korean.EUCKR
 	// 950 is the default code page for Traditional Chinese
 	case 950:
-		t, e := io.ReadAll(transform.NewReader(bytes.NewReader(encoded), traditionalchinese.Big5.NewDecoder()))
-		if e != nil {
-			err = fmt.Errorf("os/windows/pkg/text.DecodeString(): there was an error decoding the string to Traditional Chinese Big5: %s", e)
-			return
-		}
-		decoded = string(t)
+		return traditionalchinese.Big5
+	// 1200 is UTF-16LE
+	case 1200:
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+	// 1201 is UTF-16BE
+	case 1201:
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM)
+	// 1250 through 1258 are the ANSI code pages for European, Cyrillic, Greek, Turkish, Hebrew, Arabic, Baltic, and Vietnamese
+	case 1250:
+		return charmap.Windows1250
+	case 1251:
+		return charmap.Windows1251
+	case 1252:
+		return charmap.Windows1252
+	case 1253:
+		return charmap.Windows1253
+	case 1254:
+		return charmap.Windows1254
+	case 1255:
+		return charmap.Windows1255
+	case 1256:
+		return charmap.Windows1256
+	case 1257:
+		return charmap.Windows1257
+	case 1258:
+		return charmap.Windows1258
+	// 20866 is KOI8-R Russian Cyrillic
+	case 20866:
+		return charmap.KOI8R
+	// 21866 is KOI8-U Ukrainian Cyrillic
+	case 21866:
+		return charmap.KOI8U
+	// 28591 is ISO 8859-1 Latin 1
+	case 28591:
+		return charmap.ISO8859_1
+	// 65001 is UTF-8
+	case 65001:
+		return unicode.UTF8
 	default:
-		decoded = fmt.Sprintf("\n***The output was not valid UTF-8 and there isn't a configured decoder for code page %d***\n\n", codePage)
-		decoded += string(bytes.ToValidUTF8(encoded, []byte("�")))
+		return nil
 	}
-	return
+}
+
+// IsUTF16LE determines if the provided byte slice is UTF-16LE encoded text.
+// The data is UTF-16LE if it starts with the 0xFF 0xFE byte order mark or, for mostly ASCII text, if at least
+// half of the odd bytes are zero
+func IsUTF16LE(data []byte) bool {
+	if len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE {
+		return true
+	}
+	if len(data) < 2 || len(data)%2 != 0 {
+		return false
+	}
+	var zeros int
+	for i := 1; i < len(data); i += 2 {
+		if data[i] == 0 {
+			zeros++
+		}
+	}
+	return zeros >= len(data)/4
 }
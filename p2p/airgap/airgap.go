@@ -0,0 +1,283 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package airgap relays peer-to-peer Agent messages across an air gap. Every write to a Conn becomes a file in an outbox
+// directory, and reads consume the files an operator carried over from the other side of the gap into an inbox directory.
+// Both sides use the same directory layout so the removable media can be moved between them as it is: the air-gapped
+// Agent writes to the "up" directory and reads from "down" while the connected Agent does the opposite.
+// With the "qr:" address prefix, messages are written as base64 text files small enough to be rendered as QR codes, or
+// copied through a clipboard, and ingested again from text files on the other side
+package airgap
+
+import (
+	// Standard
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// UP is the directory holding messages from the air-gapped Agent to the connected Agent
+	UP = "up"
+	// DOWN is the directory holding messages from the connected Agent to the air-gapped Agent
+	DOWN = "down"
+	// QR is the address prefix that selects text files sized for QR codes instead of binary files
+	QR = "qr:"
+	// TextSize is the largest chunk of a message, in bytes, written to one text file. Base64 encoded it is 2,048
+	// characters and fits in a version 40 QR code with low error correction
+	TextSize = 1536
+	// Poll is how often the inbox directory is checked for new files
+	Poll = time.Second * 5
+)
+
+// file extensions for binary and text messages; partial files are ignored until they are renamed
+const (
+	extBinary  = ".bin"
+	extText    = ".txt"
+	extPartial = ".part"
+)
+
+// Addr is the directory an air gap Conn relays messages through
+type Addr string
+
+// Network returns the name of the network
+func (a Addr) Network() string {
+	return "airgap"
+}
+
+// String returns the directory
+func (a Addr) String() string {
+	return string(a)
+}
+
+// Conn is a net.Conn that writes every message to a file in its outbox directory and reads the files, oldest first, that
+// appear in its inbox directory, deleting each one after it was read
+type Conn struct {
+	dir    string        // dir is the root directory holding the up and down directories
+	in     string        // in is the directory files are read from
+	out    string        // out is the directory files are written to
+	text   bool          // text writes base64 text files sized for QR codes instead of binary files
+	buf    []byte        // buf holds data read from a file but not yet returned to the caller
+	last   int64         // last is the name of the most recently written file, used to keep names unique and ordered
+	closed chan struct{} // closed is closed when the Conn is closed to stop a blocked read
+	once   sync.Once     // once closes the closed channel exactly once
+	rLock  sync.Mutex    // rLock serializes reads
+	wLock  sync.Mutex    // wLock serializes writes so file names stay in order
+}
+
+// Child returns a Conn for the air-gapped Agent that writes to the up directory and reads from the down directory
+func Child(addr string) (*Conn, error) {
+	return newConn(addr, UP, DOWN)
+}
+
+// Parent returns a Conn for the connected Agent that writes to the down directory and reads from the up directory
+func Parent(addr string) (*Conn, error) {
+	return newConn(addr, DOWN, UP)
+}
+
+// Parse returns the directory from the address and true if the address has the QR prefix
+func Parse(addr string) (dir string, text bool) {
+	if strings.HasPrefix(strings.ToLower(addr), QR) {
+		return addr[len(QR):], true
+	}
+	return addr, false
+}
+
+// newConn creates the directories and returns a Conn that writes to the out directory and reads from the in directory
+func newConn(addr, out, in string) (*Conn, error) {
+	dir, text := Parse(addr)
+	if dir == "" {
+		return nil, fmt.Errorf("p2p/airgap: a directory was not provided")
+	}
+	c := &Conn{
+		dir:    dir,
+		in:     filepath.Join(dir, in),
+		out:    filepath.Join(dir, out),
+		text:   text,
+		closed: make(chan struct{}),
+	}
+	for _, d := range []string{c.in, c.out} {
+		err := os.MkdirAll(d, 0700)
+		if err != nil {
+			return nil, fmt.Errorf("p2p/airgap: there was an error creating the %s directory: %s", d, err)
+		}
+	}
+	return c, nil
+}
+
+// Read blocks until data is available in the inbox directory, or the Conn is closed, and copies it into p
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rLock.Lock()
+	defer c.rLock.Unlock()
+	for len(c.buf) == 0 {
+		data, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+		if data != nil {
+			c.buf = data
+			break
+		}
+		select {
+		case <-c.closed:
+			return 0, io.EOF
+		case <-time.After(Poll):
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// next reads, removes, and decodes the oldest file in the inbox directory and returns nil if there aren't any
+func (c *Conn) next() ([]byte, error) {
+	select {
+	case <-c.closed:
+		return nil, io.EOF
+	default:
+	}
+	entries, err := os.ReadDir(c.in)
+	if err != nil {
+		return nil, fmt.Errorf("p2p/airgap: there was an error reading the %s directory: %s", c.in, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.Type().IsRegular() && (ext == extBinary || ext == extText) {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	sort.Strings(names)
+	path := filepath.Join(c.in, names[0])
+	data, err := os.ReadFile(path) // #nosec G304 -- The file was carried into the inbox directory by the operator
+	if err != nil {
+		return nil, fmt.Errorf("p2p/airgap: there was an error reading %s: %s", path, err)
+	}
+	err = os.Remove(path)
+	if err != nil {
+		return nil, fmt.Errorf("p2p/airgap: there was an error removing %s: %s", path, err)
+	}
+	if filepath.Ext(path) == extText {
+		// Text may have been wrapped or padded with whitespace by a QR code reader or a clipboard
+		data, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+		if err != nil {
+			return nil, fmt.Errorf("p2p/airgap: there was an error decoding %s: %s", path, err)
+		}
+	}
+	// An empty file is skipped
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// Write writes p to one binary file, or to as many text files as needed, in the outbox directory
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if !c.text {
+		err := c.write(p, extBinary)
+		if err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	var n int
+	for n < len(p) {
+		end := n + TextSize
+		if end > len(p) {
+			end = len(p)
+		}
+		err := c.write([]byte(base64.StdEncoding.EncodeToString(p[n:end])), extText)
+		if err != nil {
+			return n, err
+		}
+		n = end
+	}
+	return n, nil
+}
+
+// write writes the data to a partial file and renames it so the other side never reads a file that is still being written.
+// File names are increasing timestamps so that the files are read in the order they were written
+func (c *Conn) write(data []byte, ext string) error {
+	name := time.Now().UnixNano()
+	if name <= c.last {
+		name = c.last + 1
+	}
+	c.last = name
+	path := filepath.Join(c.out, fmt.Sprintf("%020d%s", name, ext))
+	err := os.WriteFile(path+extPartial, data, 0600)
+	if err != nil {
+		return fmt.Errorf("p2p/airgap: there was an error writing %s: %s", path, err)
+	}
+	err = os.Rename(path+extPartial, path)
+	if err != nil {
+		return fmt.Errorf("p2p/airgap: there was an error renaming %s: %s", path, err)
+	}
+	return nil
+}
+
+// Close stops any blocked read; files that were not yet read are left in place
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// LocalAddr returns the outbox directory
+func (c *Conn) LocalAddr() net.Addr {
+	return Addr(c.out)
+}
+
+// RemoteAddr returns the root directory
+func (c *Conn) RemoteAddr() net.Addr {
+	return Addr(c.dir)
+}
+
+// SetDeadline is not supported because messages may take days to cross the gap
+func (c *Conn) SetDeadline(time.Time) error {
+	return nil
+}
+
+// SetReadDeadline is not supported because messages may take days to cross the gap
+func (c *Conn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline is not supported because writes don't wait on the other side
+func (c *Conn) SetWriteDeadline(time.Time) error {
+	return nil
+}
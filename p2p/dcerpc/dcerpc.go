@@ -0,0 +1,422 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package dcerpc wraps a peer-to-peer named pipe connection so that its traffic is framed as connection-oriented
+// MS-RPC over SMB (ncacn_np). The dialing Agent binds to a well-known interface, and all following data is sent as
+// DCE/RPC request PDUs from the client and response PDUs from the server. No RPC authentication or cryptography is
+// performed; message confidentiality is still provided by the Agent's transforms
+package dcerpc
+
+import (
+	// Standard
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/google/uuid"
+)
+
+// DCE/RPC connection-oriented PDU types
+const (
+	pduRequest  = 0x00
+	pduResponse = 0x02
+	pduBind     = 0x0B
+	pduBindAck  = 0x0C
+)
+
+// DCE/RPC PDU flags
+const (
+	flagFirst = 0x01
+	flagLast  = 0x02
+)
+
+const (
+	// MaxFragSize is the maximum DCE/RPC fragment size negotiated for ncacn_np
+	MaxFragSize = 4280
+	// headerSize is the size of the common PDU header:
+	// | Version (1) | Minor (1) | Type (1) | Flags (1) | Data Representation (4) | Frag Length (2) | Auth Length (2) | Call ID (4) |
+	headerSize = 16
+	// bodySize is the size of the request and response PDU header that precedes the stub data:
+	// | Alloc Hint (4) | Context ID (2) | Opnum or Cancel Count and Reserved (2) |
+	bodySize = 8
+	// handshakeTimeout is the amount of time a peer has to complete the bind handshake
+	handshakeTimeout = time.Second * 30
+)
+
+// ndr is the NDR transfer syntax 8a885d04-1ceb-11c9-9fe8-08002b104860 version 2
+var ndr = syntax{uuid.MustParse("8a885d04-1ceb-11c9-9fe8-08002b104860"), 2}
+
+// syntax is a DCE/RPC presentation syntax identifier made of an interface UUID and its version
+type syntax struct {
+	id      uuid.UUID
+	version uint32
+}
+
+// Interface is a well-known RPC interface whose named pipe endpoint and operation the wrapped traffic imitates
+type Interface struct {
+	Name     string // Name is the interface's short name (e.g., srvsvc)
+	Pipe     string // Pipe is the secondary address the server returns in the bind_ack (e.g., \PIPE\srvsvc)
+	Opnum    uint16 // Opnum is the operation number used in request PDUs
+	abstract syntax
+}
+
+// interfaces is the list of RPC interfaces that can be imitated, keyed by their name
+var interfaces = map[string]Interface{
+	"srvsvc":   {Name: "srvsvc", Pipe: "\\PIPE\\srvsvc", Opnum: 15, abstract: syntax{uuid.MustParse("4b324fc8-1670-01d3-1278-5a47bf6ee188"), 3}},
+	"wkssvc":   {Name: "wkssvc", Pipe: "\\PIPE\\wkssvc", Opnum: 0, abstract: syntax{uuid.MustParse("6bffd098-a112-3610-9833-46c3f87e345a"), 1}},
+	"samr":     {Name: "samr", Pipe: "\\PIPE\\samr", Opnum: 64, abstract: syntax{uuid.MustParse("12345778-1234-abcd-ef00-0123456789ac"), 1}},
+	"lsarpc":   {Name: "lsarpc", Pipe: "\\PIPE\\lsass", Opnum: 76, abstract: syntax{uuid.MustParse("12345778-1234-abcd-ef00-0123456789ab"), 0}},
+	"spoolss":  {Name: "spoolss", Pipe: "\\PIPE\\spoolss", Opnum: 69, abstract: syntax{uuid.MustParse("12345678-1234-abcd-ef00-0123456789ab"), 1}},
+	"netlogon": {Name: "netlogon", Pipe: "\\PIPE\\netlogon", Opnum: 21, abstract: syntax{uuid.MustParse("12345678-1234-abcd-ef00-01234567cffb"), 1}},
+}
+
+// Conn is a net.Conn that frames all data written to it as DCE/RPC request or response PDUs and removes the PDU
+// framing from all data read from it
+type Conn struct {
+	net.Conn              // Conn is the underlying named pipe connection
+	client   bool         // client is true for the binding side that sends requests and false for the side that sends responses
+	opnum    uint16       // opnum is the operation number used in request PDUs
+	callID   uint32       // callID is the call identifier of the last request sent or received
+	in       bytes.Buffer // in holds stub data that has been read from a PDU but not yet returned to the caller
+	rLock    sync.Mutex   // rLock serializes reads from the connection
+	wLock    sync.Mutex   // wLock serializes writes to the connection so that fragments are not interleaved
+}
+
+// Client performs the client side of the bind handshake for the provided interface name on the connection and returns
+// the wrapped connection. If name is empty, the srvsvc interface is used
+func Client(conn net.Conn, name string) (*Conn, error) {
+	iface, err := Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("p2p/dcerpc.Client(): %s", err)
+	}
+
+	err = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("p2p/dcerpc.Client(): there was an error setting the handshake deadline: %s", err)
+	}
+
+	// Bind
+	_, err = conn.Write(pdu(pduBind, flagFirst|flagLast, 1, bind(iface)))
+	if err != nil {
+		return nil, fmt.Errorf("p2p/dcerpc.Client(): there was an error writing the bind PDU: %s", err)
+	}
+
+	// Bind acknowledgement
+	header, data, err := readPDU(conn)
+	if err != nil {
+		return nil, fmt.Errorf("p2p/dcerpc.Client(): %s", err)
+	}
+	if header[2] != pduBindAck {
+		return nil, fmt.Errorf("p2p/dcerpc.Client(): expected a bind_ack PDU but received type 0x%X", header[2])
+	}
+	// | Max Xmit (2) | Max Recv (2) | Assoc Group (4) | Secondary Address Length (2) | Secondary Address | Padding | Results |
+	if len(data) < 10 {
+		return nil, fmt.Errorf("p2p/dcerpc.Client(): the bind_ack PDU is too short")
+	}
+	offset := align(10 + int(binary.LittleEndian.Uint16(data[8:10])))
+	// | Number of Results (1) | Reserved (3) | Result (2) | Reason (2) | Transfer Syntax (20) |
+	if len(data) < offset+8 || data[offset] < 1 {
+		return nil, fmt.Errorf("p2p/dcerpc.Client(): the bind_ack PDU does not contain a result")
+	}
+	if result := binary.LittleEndian.Uint16(data[offset+4 : offset+6]); result != 0 {
+		return nil, fmt.Errorf("p2p/dcerpc.Client(): the bind to the %s interface was rejected with result %d", iface.Name, result)
+	}
+
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("p2p/dcerpc.Client(): there was an error clearing the handshake deadline: %s", err)
+	}
+	return &Conn{Conn: conn, client: true, opnum: iface.Opnum, callID: 1}, nil
+}
+
+// Server performs the server side of the bind handshake on the provided connection and returns the wrapped connection.
+// The bind_ack accepts whichever abstract syntax the client requested and names that interface's pipe, if known
+func Server(conn net.Conn) (*Conn, error) {
+	err := conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("p2p/dcerpc.Server(): there was an error setting the handshake deadline: %s", err)
+	}
+
+	// Bind
+	header, data, err := readPDU(conn)
+	if err != nil {
+		return nil, fmt.Errorf("p2p/dcerpc.Server(): %s", err)
+	}
+	if header[2] != pduBind {
+		return nil, fmt.Errorf("p2p/dcerpc.Server(): expected a bind PDU but received type 0x%X", header[2])
+	}
+	// | Max Xmit (2) | Max Recv (2) | Assoc Group (4) | Number of Contexts (1) | Reserved (3) | Context ID (2) | Number of Transfer Syntaxes (1) | Reserved (1) | Abstract Syntax (20) |
+	if len(data) < 36 || data[8] < 1 {
+		return nil, fmt.Errorf("p2p/dcerpc.Server(): the bind PDU does not contain a presentation context")
+	}
+	pipe := "\\PIPE\\srvsvc"
+	requested := syntax{version: binary.LittleEndian.Uint32(data[32:36])}
+	copy(requested.id[:], wire(data[16:32]))
+	for _, iface := range interfaces {
+		if iface.abstract == requested {
+			pipe = iface.Pipe
+			break
+		}
+	}
+
+	// Bind acknowledgement
+	callID := binary.LittleEndian.Uint32(header[12:16])
+	_, err = conn.Write(pdu(pduBindAck, flagFirst|flagLast, callID, bindAck(pipe)))
+	if err != nil {
+		return nil, fmt.Errorf("p2p/dcerpc.Server(): there was an error writing the bind_ack PDU: %s", err)
+	}
+
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("p2p/dcerpc.Server(): there was an error clearing the handshake deadline: %s", err)
+	}
+	return &Conn{Conn: conn, callID: callID}, nil
+}
+
+// Read reads DCE/RPC request or response PDUs from the underlying connection and returns their stub data
+func (c *Conn) Read(b []byte) (int, error) {
+	c.rLock.Lock()
+	defer c.rLock.Unlock()
+	for c.in.Len() == 0 {
+		header, data, err := readPDU(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		// Ignore anything that isn't a request or response (e.g., an alter_context)
+		if (header[2] != pduRequest && header[2] != pduResponse) || len(data) < bodySize {
+			continue
+		}
+		if !c.client {
+			c.wLock.Lock()
+			c.callID = binary.LittleEndian.Uint32(header[12:16])
+			c.wLock.Unlock()
+		}
+		c.in.Write(data[bodySize:])
+	}
+	return c.in.Read(b)
+}
+
+// Write fragments the provided data into one or more DCE/RPC PDUs and writes them to the underlying connection.
+// The client sends request PDUs with a new call ID for every write and the server sends response PDUs that use
+// the call ID of the last request it received
+func (c *Conn) Write(b []byte) (int, error) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	ptype := byte(pduResponse)
+	if c.client {
+		ptype = pduRequest
+		c.callID++
+	}
+	stub := MaxFragSize - headerSize - bodySize
+	var out []byte
+	for start := 0; ; start += stub {
+		stop := start + stub
+		if stop > len(b) {
+			stop = len(b)
+		}
+		var flags byte
+		if start == 0 {
+			flags |= flagFirst
+		}
+		if stop == len(b) {
+			flags |= flagLast
+		}
+		// | Alloc Hint (4) | Context ID (2) | Opnum or Cancel Count and Reserved (2) |
+		body := make([]byte, bodySize, bodySize+stop-start)
+		binary.LittleEndian.PutUint32(body[0:4], uint32(len(b)-start))
+		if c.client {
+			binary.LittleEndian.PutUint16(body[6:8], c.opnum)
+		}
+		out = append(out, pdu(ptype, flags, c.callID, append(body, b[start:stop]...))...)
+		if stop == len(b) {
+			break
+		}
+	}
+	_, err := c.Conn.Write(out)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Listener is a net.Listener that performs the server side of the bind handshake on every accepted connection
+type Listener struct {
+	net.Listener // Listener is the underlying named pipe listener
+}
+
+// NewListener wraps the provided net.Listener so that every accepted connection is a DCE/RPC connection
+func NewListener(listener net.Listener) *Listener {
+	return &Listener{Listener: listener}
+}
+
+// Accept waits for the next connection that successfully completes the bind handshake and returns it.
+// Connections that fail the handshake, such as enumeration tools or other probes, are closed and dropped
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		rpcConn, err := Server(conn)
+		if err != nil {
+			_ = conn.Close()
+			continue
+		}
+		return rpcConn, nil
+	}
+}
+
+// Enabled returns true if the provided obfuscation string selects the DCE/RPC wrapper. The string is either "dcerpc"
+// or "dcerpc:" followed by the name of the interface to imitate (e.g., dcerpc:spoolss)
+func Enabled(obfuscation string) bool {
+	name := strings.ToLower(obfuscation)
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	switch name {
+	case "dcerpc", "rpc", "ncacn_np":
+		return true
+	default:
+		return false
+	}
+}
+
+// Name returns the interface name from an obfuscation string that selects the DCE/RPC wrapper (e.g., dcerpc:spoolss)
+func Name(obfuscation string) string {
+	if i := strings.Index(obfuscation, ":"); i >= 0 {
+		return obfuscation[i+1:]
+	}
+	return ""
+}
+
+// Lookup returns the RPC interface for the provided name. An empty name returns the srvsvc interface
+func Lookup(name string) (Interface, error) {
+	if name == "" {
+		name = "srvsvc"
+	}
+	iface, ok := interfaces[strings.ToLower(name)]
+	if !ok {
+		return Interface{}, fmt.Errorf("unknown RPC interface: %s", name)
+	}
+	return iface, nil
+}
+
+// pdu builds a DCE/RPC PDU with the common header for the provided type, flags, call ID, and body
+func pdu(ptype, flags byte, callID uint32, body []byte) []byte {
+	out := make([]byte, headerSize, headerSize+len(body))
+	out[0] = 5 // Version
+	out[1] = 0 // Minor version
+	out[2] = ptype
+	out[3] = flags
+	out[4] = 0x10 // Data representation: little-endian, ASCII, IEEE floating point
+	binary.LittleEndian.PutUint16(out[8:10], uint16(headerSize+len(body)))
+	binary.LittleEndian.PutUint32(out[12:16], callID)
+	return append(out, body...)
+}
+
+// readPDU reads a single DCE/RPC PDU from the connection and returns its header and body
+func readPDU(conn io.Reader) ([]byte, []byte, error) {
+	header := make([]byte, headerSize)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("there was an error reading the DCE/RPC PDU header: %s", err)
+	}
+	if header[0] != 5 || header[1] != 0 {
+		return nil, nil, fmt.Errorf("unexpected DCE/RPC version %d.%d", header[0], header[1])
+	}
+	length := int(binary.LittleEndian.Uint16(header[8:10]))
+	if length < headerSize {
+		return nil, nil, fmt.Errorf("the DCE/RPC fragment length %d is invalid", length)
+	}
+	data := make([]byte, length-headerSize)
+	_, err = io.ReadFull(conn, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("there was an error reading the DCE/RPC PDU body: %s", err)
+	}
+	// Strip any authentication verifier
+	if auth := int(binary.LittleEndian.Uint16(header[10:12])); auth > 0 && auth+8 <= len(data) {
+		data = data[:len(data)-auth-8]
+	}
+	return header, data, nil
+}
+
+// bind builds the body of a bind PDU with a single presentation context for the provided interface and NDR
+func bind(iface Interface) []byte {
+	// | Max Xmit (2) | Max Recv (2) | Assoc Group (4) |
+	out := make([]byte, 8)
+	binary.LittleEndian.PutUint16(out[0:2], MaxFragSize)
+	binary.LittleEndian.PutUint16(out[2:4], MaxFragSize)
+	// | Number of Contexts (1) | Reserved (3) | Context ID (2) | Number of Transfer Syntaxes (1) | Reserved (1) |
+	out = append(out, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00)
+	out = append(out, iface.abstract.bytes()...)
+	return append(out, ndr.bytes()...)
+}
+
+// bindAck builds the body of a bind_ack PDU that names the provided pipe and accepts the NDR transfer syntax
+func bindAck(pipe string) []byte {
+	// | Max Xmit (2) | Max Recv (2) | Assoc Group (4) |
+	out := make([]byte, 8)
+	binary.LittleEndian.PutUint16(out[0:2], MaxFragSize)
+	binary.LittleEndian.PutUint16(out[2:4], MaxFragSize)
+	group := make([]byte, 4)
+	_, _ = rand.Read(group)
+	copy(out[4:8], group)
+	// | Secondary Address Length (2) | Secondary Address | Padding |
+	out = append(out, byte(len(pipe)+1), byte((len(pipe)+1)>>8))
+	out = append(out, []byte(pipe)...)
+	out = append(out, 0x00)
+	for len(out)%4 != 0 {
+		out = append(out, 0x00)
+	}
+	// | Number of Results (1) | Reserved (3) | Result (2) | Reason (2) | Transfer Syntax (20) |
+	out = append(out, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	return append(out, ndr.bytes()...)
+}
+
+// bytes returns the syntax identifier in its NDR wire format
+func (s syntax) bytes() []byte {
+	out := wire(s.id[:])
+	version := make([]byte, 4)
+	binary.LittleEndian.PutUint32(version, s.version)
+	return append(out, version...)
+}
+
+// wire converts a UUID between its big-endian string order and the NDR wire order where the first three fields are
+// little-endian. The conversion is its own inverse
+func wire(id []byte) []byte {
+	out := make([]byte, 16)
+	copy(out, id)
+	out[0], out[1], out[2], out[3] = id[3], id[2], id[1], id[0]
+	out[4], out[5] = id[5], id[4]
+	out[6], out[7] = id[7], id[6]
+	return out
+}
+
+// align rounds the provided offset up to the next multiple of four
+func align(offset int) int {
+	return (offset + 3) &^ 3
+}
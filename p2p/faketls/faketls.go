@@ -0,0 +1,383 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package faketls wraps a peer-to-peer network connection so that its traffic is framed to look like a TLS 1.3 session.
+// A plausible ClientHello, ServerHello, and ChangeCipherSpec handshake is exchanged and all following data is sent as
+// TLS application data records. No cryptography is performed; message confidentiality is still provided by the Agent's
+// transforms. The wrapper only exists to defeat simple protocol detection on east-west links
+package faketls
+
+import (
+	// Standard
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS record content types
+const (
+	recordChangeCipherSpec = 0x14
+	recordHandshake        = 0x16
+	recordApplicationData  = 0x17
+)
+
+// TLS handshake message types
+const (
+	handshakeClientHello = 0x01
+	handshakeServerHello = 0x02
+)
+
+const (
+	// MaxRecordSize is the maximum TLS plaintext record payload size
+	MaxRecordSize = 16384
+	// recordHeaderSize is the size of the TLS record header: | Type (1) | Version (2) | Length (2) |
+	recordHeaderSize = 5
+	// handshakeTimeout is the amount of time a peer has to complete the fake handshake
+	handshakeTimeout = time.Second * 30
+)
+
+// serverNames is a list of plausible Server Name Indication values used when one is not provided
+var serverNames = []string{
+	"www.microsoft.com",
+	"login.microsoftonline.com",
+	"www.google.com",
+	"update.googleapis.com",
+	"www.apple.com",
+	"cdn.cloudflare.com",
+}
+
+// Conn is a net.Conn that frames all data written to it as TLS application data records and removes the TLS record
+// framing from all data read from it
+type Conn struct {
+	net.Conn              // Conn is the underlying network connection
+	in       bytes.Buffer // in holds application data that has been read from a record but not yet returned to the caller
+	rLock    sync.Mutex   // rLock serializes reads from the connection
+	wLock    sync.Mutex   // wLock serializes writes to the connection so that records are not interleaved
+}
+
+// Client performs the client side of the fake TLS handshake on the provided connection and returns the wrapped connection.
+// If serverName is empty, a random plausible value is used for the Server Name Indication extension
+func Client(conn net.Conn, serverName string) (*Conn, error) {
+	if serverName == "" || net.ParseIP(serverName) != nil {
+		serverName = serverNames[randInt(len(serverNames))]
+	}
+
+	err := conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("p2p/faketls.Client(): there was an error setting the handshake deadline: %s", err)
+	}
+
+	// ClientHello
+	_, err = conn.Write(record(recordHandshake, []byte{0x03, 0x01}, clientHello(serverName)))
+	if err != nil {
+		return nil, fmt.Errorf("p2p/faketls.Client(): there was an error writing the ClientHello: %s", err)
+	}
+
+	// ServerHello, ChangeCipherSpec, and the "encrypted" server handshake messages
+	for _, expected := range []byte{recordHandshake, recordChangeCipherSpec, recordApplicationData} {
+		var data []byte
+		data, err = readRecord(conn, expected)
+		if err != nil {
+			return nil, fmt.Errorf("p2p/faketls.Client(): %s", err)
+		}
+		if expected == recordHandshake && (len(data) < 1 || data[0] != handshakeServerHello) {
+			return nil, fmt.Errorf("p2p/faketls.Client(): expected a ServerHello handshake message")
+		}
+	}
+
+	// ChangeCipherSpec and the "encrypted" client Finished message
+	out := record(recordChangeCipherSpec, []byte{0x03, 0x03}, []byte{0x01})
+	out = append(out, record(recordApplicationData, []byte{0x03, 0x03}, random(53))...)
+	_, err = conn.Write(out)
+	if err != nil {
+		return nil, fmt.Errorf("p2p/faketls.Client(): there was an error writing the client Finished message: %s", err)
+	}
+
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("p2p/faketls.Client(): there was an error clearing the handshake deadline: %s", err)
+	}
+	return &Conn{Conn: conn}, nil
+}
+
+// Server performs the server side of the fake TLS handshake on the provided connection and returns the wrapped connection
+func Server(conn net.Conn) (*Conn, error) {
+	err := conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("p2p/faketls.Server(): there was an error setting the handshake deadline: %s", err)
+	}
+
+	// ClientHello
+	hello, err := readRecord(conn, recordHandshake)
+	if err != nil {
+		return nil, fmt.Errorf("p2p/faketls.Server(): %s", err)
+	}
+	// | Type (1) | Length (3) | Version (2) | Random (32) | Session ID Length (1) | Session ID |
+	if len(hello) < 39 || hello[0] != handshakeClientHello {
+		return nil, fmt.Errorf("p2p/faketls.Server(): expected a ClientHello handshake message")
+	}
+	sessionLength := int(hello[38])
+	if len(hello) < 39+sessionLength {
+		return nil, fmt.Errorf("p2p/faketls.Server(): the ClientHello session ID length %d is invalid", sessionLength)
+	}
+	sessionID := hello[39 : 39+sessionLength]
+
+	// ServerHello, ChangeCipherSpec, and the "encrypted" EncryptedExtensions, Certificate, CertificateVerify, and Finished messages
+	out := record(recordHandshake, []byte{0x03, 0x03}, serverHello(sessionID))
+	out = append(out, record(recordChangeCipherSpec, []byte{0x03, 0x03}, []byte{0x01})...)
+	out = append(out, record(recordApplicationData, []byte{0x03, 0x03}, random(1024+randInt(2048)))...)
+	_, err = conn.Write(out)
+	if err != nil {
+		return nil, fmt.Errorf("p2p/faketls.Server(): there was an error writing the ServerHello: %s", err)
+	}
+
+	// ChangeCipherSpec and the "encrypted" client Finished message
+	for _, expected := range []byte{recordChangeCipherSpec, recordApplicationData} {
+		_, err = readRecord(conn, expected)
+		if err != nil {
+			return nil, fmt.Errorf("p2p/faketls.Server(): %s", err)
+		}
+	}
+
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("p2p/faketls.Server(): there was an error clearing the handshake deadline: %s", err)
+	}
+	return &Conn{Conn: conn}, nil
+}
+
+// Read reads TLS application data records from the underlying connection and returns their payload
+func (c *Conn) Read(b []byte) (int, error) {
+	c.rLock.Lock()
+	defer c.rLock.Unlock()
+	for c.in.Len() == 0 {
+		header := make([]byte, recordHeaderSize)
+		_, err := io.ReadFull(c.Conn, header)
+		if err != nil {
+			return 0, err
+		}
+		data := make([]byte, binary.BigEndian.Uint16(header[3:5]))
+		_, err = io.ReadFull(c.Conn, data)
+		if err != nil {
+			return 0, err
+		}
+		// Ignore anything that isn't application data (e.g., a late ChangeCipherSpec)
+		if header[0] != recordApplicationData {
+			continue
+		}
+		c.in.Write(data)
+	}
+	return c.in.Read(b)
+}
+
+// Write frames the provided data into one or more TLS application data records and writes them to the underlying connection
+func (c *Conn) Write(b []byte) (int, error) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	var out []byte
+	for start := 0; start < len(b); start += MaxRecordSize {
+		stop := start + MaxRecordSize
+		if stop > len(b) {
+			stop = len(b)
+		}
+		out = append(out, record(recordApplicationData, []byte{0x03, 0x03}, b[start:stop])...)
+	}
+	_, err := c.Conn.Write(out)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Listener is a net.Listener that performs the server side of the fake TLS handshake on every accepted connection
+type Listener struct {
+	net.Listener // Listener is the underlying network listener
+}
+
+// NewListener wraps the provided net.Listener so that every accepted connection is a fake TLS connection
+func NewListener(listener net.Listener) *Listener {
+	return &Listener{Listener: listener}
+}
+
+// Accept waits for the next connection that successfully completes the fake TLS handshake and returns it.
+// Connections that fail the handshake, such as port scanners or other probes, are closed and dropped
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		tlsConn, err := Server(conn)
+		if err != nil {
+			_ = conn.Close()
+			continue
+		}
+		return tlsConn, nil
+	}
+}
+
+// Enabled returns true if the provided obfuscation string selects the fake TLS wrapper
+func Enabled(obfuscation string) bool {
+	switch strings.ToLower(obfuscation) {
+	case "faketls", "fake-tls", "tls":
+		return true
+	default:
+		return false
+	}
+}
+
+// record builds a TLS record for the provided content type, version, and payload
+func record(contentType byte, version []byte, payload []byte) []byte {
+	out := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	out[0] = contentType
+	copy(out[1:3], version)
+	binary.BigEndian.PutUint16(out[3:5], uint16(len(payload)))
+	return append(out, payload...)
+}
+
+// readRecord reads a single TLS record from the connection and returns an error if it is not the expected content type
+func readRecord(conn net.Conn, expected byte) ([]byte, error) {
+	header := make([]byte, recordHeaderSize)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading the TLS record header: %s", err)
+	}
+	if header[0] != expected {
+		return nil, fmt.Errorf("expected TLS record type 0x%X but received 0x%X", expected, header[0])
+	}
+	if header[1] != 0x03 {
+		return nil, fmt.Errorf("unexpected TLS record version 0x%X%X", header[1], header[2])
+	}
+	data := make([]byte, binary.BigEndian.Uint16(header[3:5]))
+	_, err = io.ReadFull(conn, data)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading the TLS record payload: %s", err)
+	}
+	return data, nil
+}
+
+// clientHello builds a TLS 1.3 ClientHello handshake message for the provided server name
+func clientHello(serverName string) []byte {
+	var body []byte
+	// Legacy version TLS 1.2
+	body = append(body, 0x03, 0x03)
+	body = append(body, random(32)...)
+	// Legacy session ID
+	body = append(body, 32)
+	body = append(body, random(32)...)
+	// Cipher suites: TLS_AES_128_GCM_SHA256, TLS_AES_256_GCM_SHA384, TLS_CHACHA20_POLY1305_SHA256,
+	// TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	suites := []byte{0x13, 0x01, 0x13, 0x02, 0x13, 0x03, 0xc0, 0x2b, 0xc0, 0x2f}
+	body = append(body, uint16Bytes(len(suites))...)
+	body = append(body, suites...)
+	// Compression methods: null
+	body = append(body, 0x01, 0x00)
+
+	var extensions []byte
+	// server_name
+	name := append([]byte{0x00}, uint16Bytes(len(serverName))...)
+	name = append(name, []byte(serverName)...)
+	extensions = append(extensions, extension(0x0000, append(uint16Bytes(len(name)), name...))...)
+	// supported_groups: x25519, secp256r1, secp384r1
+	groups := []byte{0x00, 0x1d, 0x00, 0x17, 0x00, 0x18}
+	extensions = append(extensions, extension(0x000a, append(uint16Bytes(len(groups)), groups...))...)
+	// signature_algorithms: ecdsa_secp256r1_sha256, rsa_pss_rsae_sha256, rsa_pkcs1_sha256
+	algorithms := []byte{0x04, 0x03, 0x08, 0x04, 0x04, 0x01}
+	extensions = append(extensions, extension(0x000d, append(uint16Bytes(len(algorithms)), algorithms...))...)
+	// application_layer_protocol_negotiation: h2, http/1.1
+	alpn := []byte{0x02, 'h', '2', 0x08, 'h', 't', 't', 'p', '/', '1', '.', '1'}
+	extensions = append(extensions, extension(0x0010, append(uint16Bytes(len(alpn)), alpn...))...)
+	// supported_versions: TLS 1.3, TLS 1.2
+	extensions = append(extensions, extension(0x002b, []byte{0x04, 0x03, 0x04, 0x03, 0x03})...)
+	// key_share: x25519
+	share := append([]byte{0x00, 0x1d, 0x00, 0x20}, random(32)...)
+	extensions = append(extensions, extension(0x0033, append(uint16Bytes(len(share)), share...))...)
+
+	body = append(body, uint16Bytes(len(extensions))...)
+	body = append(body, extensions...)
+	return handshake(handshakeClientHello, body)
+}
+
+// serverHello builds a TLS 1.3 ServerHello handshake message that echoes the client's legacy session ID
+func serverHello(sessionID []byte) []byte {
+	var body []byte
+	// Legacy version TLS 1.2
+	body = append(body, 0x03, 0x03)
+	body = append(body, random(32)...)
+	body = append(body, byte(len(sessionID)))
+	body = append(body, sessionID...)
+	// Cipher suite: TLS_AES_128_GCM_SHA256
+	body = append(body, 0x13, 0x01)
+	// Compression method: null
+	body = append(body, 0x00)
+
+	var extensions []byte
+	// supported_versions: TLS 1.3
+	extensions = append(extensions, extension(0x002b, []byte{0x03, 0x04})...)
+	// key_share: x25519
+	extensions = append(extensions, extension(0x0033, append([]byte{0x00, 0x1d, 0x00, 0x20}, random(32)...))...)
+
+	body = append(body, uint16Bytes(len(extensions))...)
+	body = append(body, extensions...)
+	return handshake(handshakeServerHello, body)
+}
+
+// handshake prepends the handshake message type and 3-byte length to the provided body
+func handshake(messageType byte, body []byte) []byte {
+	out := []byte{messageType, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	return append(out, body...)
+}
+
+// extension builds a TLS extension for the provided extension type and data
+func extension(extensionType uint16, data []byte) []byte {
+	out := uint16Bytes(int(extensionType))
+	out = append(out, uint16Bytes(len(data))...)
+	return append(out, data...)
+}
+
+// uint16Bytes returns the provided integer as a 2-byte big endian slice
+func uint16Bytes(i int) []byte {
+	out := make([]byte, 2)
+	binary.BigEndian.PutUint16(out, uint16(i))
+	return out
+}
+
+// random returns a slice of cryptographically random bytes of the provided size
+func random(size int) []byte {
+	out := make([]byte, size)
+	_, _ = rand.Read(out)
+	return out
+}
+
+// randInt returns a cryptographically random integer in the range [0, max)
+func randInt(max int) int {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0
+	}
+	return int(n.Int64())
+}
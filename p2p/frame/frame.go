@@ -0,0 +1,118 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package frame reads and writes the length-framed Tag, Length, Value (TLV) messages exchanged between peer-to-peer Agents
+package frame
+
+import (
+	// Standard
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+)
+
+const (
+	// TAG is the only Type/Tag value used for peer-to-peer messages
+	TAG = 1
+	// HeaderSize is the number of bytes for the 4-byte Type/Tag and the 8-byte Length
+	HeaderSize = 12
+	// MaxLength is the largest message, in bytes, that will be read from a peer
+	MaxLength = 1 << 30
+)
+
+// Reader reads one framed message at a time from a connection. A single Reader must be used for the life of the
+// connection because bytes of the next message, read along with the current message, are held in its buffer
+type Reader struct {
+	src io.Reader
+	r   *bufio.Reader
+}
+
+// NewReader returns a Reader for the connection
+func NewReader(src io.Reader) *Reader {
+	return &Reader{src: src, r: bufio.NewReaderSize(src, 64*1024)}
+}
+
+// Reads returns true if the Reader was created for the provided connection
+func (r *Reader) Reads(src io.Reader) bool {
+	return r != nil && r.src == src
+}
+
+// Next blocks until a complete message has been read, no matter how it was fragmented, and returns its value
+func (r *Reader) Next() ([]byte, error) {
+	header := make([]byte, HeaderSize)
+	_, err := io.ReadFull(r.r, header)
+	if err != nil {
+		return nil, err
+	}
+	tag := binary.BigEndian.Uint32(header[:4])
+	if tag != TAG {
+		return nil, fmt.Errorf("p2p/frame.Next(): expected a type/tag value of %d for TLV but got %d", TAG, tag)
+	}
+	length := binary.BigEndian.Uint64(header[4:])
+	if length > MaxLength {
+		return nil, fmt.Errorf("p2p/frame.Next(): the message length %d is larger than the %d byte maximum", length, MaxLength)
+	}
+	value := make([]byte, length)
+	_, err = io.ReadFull(r.r, value)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("p2p/frame.Next(): there was an error reading the %d byte message: %w", length, err)
+	}
+	return value, nil
+}
+
+// Delegate reads the next message and gob decodes it into a Delegate message
+func (r *Reader) Delegate() (delegate messages.Delegate, err error) {
+	value, err := r.Next()
+	if err != nil {
+		return
+	}
+	err = gob.NewDecoder(bytes.NewReader(value)).Decode(&delegate)
+	if err != nil {
+		err = fmt.Errorf("p2p/frame.Delegate(): there was an error gob decoding the delegate message: %s", err)
+	}
+	return
+}
+
+// Frame prepends the Type/Tag and Length to the value
+func Frame(value []byte) []byte {
+	data := make([]byte, HeaderSize, HeaderSize+len(value))
+	binary.BigEndian.PutUint32(data[:4], TAG)
+	binary.BigEndian.PutUint64(data[4:], uint64(len(value)))
+	return append(data, value...)
+}
+
+// EncodeDelegate gob encodes the Delegate message and frames it
+func EncodeDelegate(delegate messages.Delegate) ([]byte, error) {
+	value := new(bytes.Buffer)
+	err := gob.NewEncoder(value).Encode(delegate)
+	if err != nil {
+		return nil, fmt.Errorf("p2p/frame.EncodeDelegate(): there was an error gob encoding the delegate message: %s", err)
+	}
+	return Frame(value.Bytes()), nil
+}
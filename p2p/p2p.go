@@ -42,6 +42,9 @@ const (
 	UDPREVERSE = 3
 	SMBBIND    = 4
 	SMBREVERSE = 5
+	RAWBIND    = 6
+	RAWREVERSE = 7
+	AIRGAP     = 8
 )
 
 const (
@@ -53,6 +56,8 @@ const (
 	// "Pipe write operations across a network are limited to 65,535 bytes per write"
 	// https://learn.microsoft.com/en-us/windows/win32/api/fileapi/nf-fileapi-writefileex
 	MaxSizeSMB = 65535
+	// MaxSizeRaw is the maximum size of a raw IP fragment, leaving room for the IP and RawHeaderSize headers in a 1500 MTU
+	MaxSizeRaw = 1400
 )
 
 // Link holds information about peer-to-peer linked agents
@@ -64,6 +69,7 @@ type Link struct {
 	connType   int                // connType of the linked Agent (e.g., tcp-bind, SMB, etc.)
 	remote     net.Addr           // remote is the name or address of the remote Agent data is being sent to
 	listener   uuid.UUID          // listener is the server-side listener id for this link
+	queue      *Queue             // queue holds outgoing Delegate messages waiting to be written to the linked Agent
 	sync.Mutex                    // Mutex is used to lock the Link object for thread safety
 }
 
@@ -77,6 +83,7 @@ func NewLink(id uuid.UUID, listener uuid.UUID, conn interface{}, linkType int, r
 		connType: linkType,
 		remote:   remote,
 		listener: listener,
+		queue:    NewQueue(),
 	}
 }
 
@@ -122,6 +129,11 @@ func (l *Link) Type() int {
 	return l.connType
 }
 
+// Queue returns the peer-to-peer Link's outgoing message queue
+func (l *Link) Queue() *Queue {
+	return l.queue
+}
+
 // Remote returns the address the peer-to-peer Link is connected to
 func (l *Link) Remote() net.Addr {
 	return l.remote
@@ -154,6 +166,12 @@ func String(linkType int) string {
 		return "udp-bind"
 	case UDPREVERSE:
 		return "udp-reverse"
+	case RAWBIND:
+		return "raw-bind"
+	case RAWREVERSE:
+		return "raw-reverse"
+	case AIRGAP:
+		return "airgap"
 	default:
 		return fmt.Sprintf("unknown peer-to-peer agent link type %d", linkType)
 	}
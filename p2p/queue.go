@@ -0,0 +1,189 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package p2p
+
+import (
+	// Standard
+	"errors"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+)
+
+// Priorities for outgoing Delegate messages; lower values are sent first
+const (
+	// HIGH is for control messages, such as the final message sent before a Link is removed
+	HIGH = 0
+	// NORMAL is for all other messages
+	NORMAL = 1
+	// BULK is for messages larger than BulkSize so that large transfers don't delay interactive traffic
+	BULK = 2
+)
+
+const (
+	// BulkSize is the payload size, in bytes, at which a NORMAL message is sent with BULK priority
+	BulkSize = 512 * 1024
+	// MaxQueueMessages is the maximum number of messages held in a Link's outgoing queue
+	MaxQueueMessages = 256
+	// MaxQueueBytes is the maximum number of payload bytes held in a Link's outgoing queue; a single message larger
+	// than this is still accepted when the queue is empty
+	MaxQueueBytes = 32 * 1024 * 1024
+)
+
+// ErrQueueFull is returned when a message can't be added to a Link's outgoing queue without exceeding its limits
+var ErrQueueFull = errors.New("the peer-to-peer link's outgoing queue is full")
+
+// ErrQueueClosed is returned when a message is added to a Link's outgoing queue after it was closed
+var ErrQueueClosed = errors.New("the peer-to-peer link's outgoing queue is closed")
+
+// Queue is a bounded, prioritized queue of outgoing Delegate messages for a single peer-to-peer Link
+type Queue struct {
+	lanes   [BULK + 1][]messages.Delegate // lanes holds the queued messages for each priority
+	count   int                           // count is the number of queued messages
+	bytes   int                           // bytes is the number of queued payload bytes
+	busy    bool                          // busy is true while a message taken from the queue is being written
+	started bool                          // started is true once a routine has been started to write the queued messages
+	closed  bool                          // closed is true once the Link has been removed
+	mu      sync.Mutex                    // mu protects the Queue from concurrent access
+	ready   *sync.Cond                    // ready signals that a message was added or the Queue was closed
+}
+
+// NewQueue is a factory that returns an empty Queue
+func NewQueue() *Queue {
+	q := &Queue{}
+	q.ready = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push adds the Delegate message to the queue with the provided priority. NORMAL messages larger than BulkSize are
+// queued as BULK. HIGH priority messages are only limited by MaxQueueMessages
+func (q *Queue) Push(delegate messages.Delegate, priority int) error {
+	if priority < HIGH || priority > BULK {
+		priority = NORMAL
+	}
+	if priority == NORMAL && len(delegate.Payload) > BulkSize {
+		priority = BULK
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if q.count >= MaxQueueMessages {
+		return ErrQueueFull
+	}
+	if priority != HIGH && q.count > 0 && q.bytes+len(delegate.Payload) > MaxQueueBytes {
+		return ErrQueueFull
+	}
+	q.lanes[priority] = append(q.lanes[priority], delegate)
+	q.count++
+	q.bytes += len(delegate.Payload)
+	q.ready.Signal()
+	return nil
+}
+
+// Pop blocks until there is a message in the queue and returns the one with the highest priority. The queue is busy
+// until Done is called. False is returned when the queue has been closed
+func (q *Queue) Pop() (messages.Delegate, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.count == 0 && !q.closed {
+		q.ready.Wait()
+	}
+	if q.closed {
+		return messages.Delegate{}, false
+	}
+	for i := range q.lanes {
+		if len(q.lanes[i]) > 0 {
+			delegate := q.lanes[i][0]
+			q.lanes[i][0] = messages.Delegate{}
+			q.lanes[i] = q.lanes[i][1:]
+			q.count--
+			q.bytes -= len(delegate.Payload)
+			q.busy = true
+			return delegate, true
+		}
+	}
+	return messages.Delegate{}, false
+}
+
+// Done signals that the message returned by Pop has been written
+func (q *Queue) Done() {
+	q.mu.Lock()
+	q.busy = false
+	q.mu.Unlock()
+}
+
+// Start returns true the first time it is called so that only one routine writes the queued messages
+func (q *Queue) Start() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.started || q.closed {
+		return false
+	}
+	q.started = true
+	return true
+}
+
+// Close discards all queued messages and releases the routine waiting in Pop
+func (q *Queue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.lanes = [BULK + 1][]messages.Delegate{}
+	q.count = 0
+	q.bytes = 0
+	q.mu.Unlock()
+	q.ready.Broadcast()
+}
+
+// Congested returns true when the queue holds more than half of its maximum number of messages or bytes
+func (q *Queue) Congested() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count > MaxQueueMessages/2 || q.bytes > MaxQueueBytes/2
+}
+
+// Flush blocks until every queued message has been written, the queue is closed, or the timeout has elapsed.
+// False is returned if messages were still queued when the timeout elapsed
+func (q *Queue) Flush(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		q.mu.Lock()
+		empty := q.closed || (q.count == 0 && !q.busy)
+		q.mu.Unlock()
+		if empty {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// Len returns the number of queued messages and payload bytes
+func (q *Queue) Len() (count, bytes int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count, q.bytes
+}
@@ -0,0 +1,88 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package p2p
+
+import (
+	// Standard
+	"encoding/binary"
+	"fmt"
+)
+
+// Raw IP peer-to-peer header
+// Raw IP sockets receive every packet for their IP protocol number, so each fragment is prefixed with a small
+// handcrafted header used to identify Merlin traffic, its direction, and the fragment's order
+//
+// | Magic (2) | Version (1) | Direction (1) | Fragment (2) | Total (2) |
+
+const (
+	// RawHeaderSize is the size, in bytes, of the header prepended to every raw IP fragment
+	RawHeaderSize = 8
+	// RawMagic identifies a raw IP fragment as a Merlin peer-to-peer message
+	RawMagic = 0x4D52
+	// RawVersion is the version of the raw IP header format
+	RawVersion = 1
+	// RawProtocol is the default IP protocol number used for raw IP peer-to-peer communications
+	// 253 is reserved for experimentation and testing by RFC 3692
+	RawProtocol = 253
+)
+
+const (
+	// RawUpstream identifies a fragment sent from a child Agent to its parent
+	RawUpstream = 1
+	// RawDownstream identifies a fragment sent from a parent Agent to its child
+	RawDownstream = 2
+)
+
+// WrapRaw prepends the raw IP header to the provided fragment
+func WrapRaw(data []byte, direction uint8, fragment, total uint16) []byte {
+	header := make([]byte, RawHeaderSize)
+	binary.BigEndian.PutUint16(header[0:2], RawMagic)
+	header[2] = RawVersion
+	header[3] = direction
+	binary.BigEndian.PutUint16(header[4:6], fragment)
+	binary.BigEndian.PutUint16(header[6:8], total)
+	return append(header, data...)
+}
+
+// UnwrapRaw validates the raw IP header on the provided fragment and returns the fragment's payload.
+// An error is returned if the data is not a Merlin raw IP fragment or was not sent in the expected direction
+func UnwrapRaw(data []byte, direction uint8) (payload []byte, fragment, total uint16, err error) {
+	if len(data) < RawHeaderSize {
+		err = fmt.Errorf("p2p.UnwrapRaw(): expected at least %d bytes for the raw header but received %d", RawHeaderSize, len(data))
+		return
+	}
+	if binary.BigEndian.Uint16(data[0:2]) != RawMagic {
+		err = fmt.Errorf("p2p.UnwrapRaw(): the raw header magic value 0x%X is not valid", data[0:2])
+		return
+	}
+	if data[2] != RawVersion {
+		err = fmt.Errorf("p2p.UnwrapRaw(): unhandled raw header version %d", data[2])
+		return
+	}
+	if data[3] != direction {
+		err = fmt.Errorf("p2p.UnwrapRaw(): expected raw header direction %d but received %d", direction, data[3])
+		return
+	}
+	fragment = binary.BigEndian.Uint16(data[4:6])
+	total = binary.BigEndian.Uint16(data[6:8])
+	payload = data[RawHeaderSize:]
+	return
+}
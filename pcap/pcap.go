@@ -0,0 +1,147 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package pcap writes captured packets to files in the libpcap format and parses classic BPF filter programs compiled
+// by tcpdump so that packet capture jobs don't depend on a compiler for filter expressions
+package pcap
+
+import (
+	// Standard
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Link types identify the link-layer header at the start of each packet
+const (
+	LINKTYPE_NULL     uint32 = 0
+	LINKTYPE_ETHERNET uint32 = 1
+	LINKTYPE_RAW      uint32 = 101
+)
+
+// MaxInstructions is the most instructions a classic BPF program can have
+const MaxInstructions = 4096
+
+// Instruction is a classic BPF instruction with the same memory layout as the C struct sock_filter and bpf_insn
+type Instruction struct {
+	Op uint16
+	Jt uint8
+	Jf uint8
+	K  uint32
+}
+
+// Writer writes packets to an io.Writer in the libpcap file format
+type Writer struct {
+	w       io.Writer
+	snaplen uint32
+	written int64
+}
+
+// NewWriter writes the libpcap file header for the snapshot length and link type and returns a Writer for the packets
+func NewWriter(w io.Writer, snaplen, linkType uint32) (*Writer, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4) // Magic number for microsecond timestamps
+	binary.LittleEndian.PutUint16(header[4:6], 2)          // Major version
+	binary.LittleEndian.PutUint16(header[6:8], 4)          // Minor version
+	binary.LittleEndian.PutUint32(header[16:20], snaplen)
+	binary.LittleEndian.PutUint32(header[20:24], linkType)
+	n, err := w.Write(header)
+	if err != nil {
+		return nil, fmt.Errorf("pcap.NewWriter(): there was an error writing the file header: %s", err)
+	}
+	return &Writer{w: w, snaplen: snaplen, written: int64(n)}, nil
+}
+
+// WritePacket writes a packet captured at the time. The length is the packet's original length, which is larger than
+// the data when the packet was truncated to the snapshot length
+func (pw *Writer) WritePacket(timestamp time.Time, data []byte, length int) error {
+	if uint32(len(data)) > pw.snaplen {
+		data = data[:pw.snaplen]
+	}
+	if length < len(data) {
+		length = len(data)
+	}
+	header := make([]byte, 16)
+	binary.LittleEndian.PutUint32(header[0:4], uint32(timestamp.Unix()))
+	binary.LittleEndian.PutUint32(header[4:8], uint32(timestamp.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(header[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[12:16], uint32(length))
+	n, err := pw.w.Write(header)
+	pw.written += int64(n)
+	if err != nil {
+		return err
+	}
+	n, err = pw.w.Write(data)
+	pw.written += int64(n)
+	return err
+}
+
+// Written returns the number of bytes written, including the file header
+func (pw *Writer) Written() int64 {
+	return pw.written
+}
+
+// numbers matches the decimal and hexadecimal numbers in tcpdump's -dd and -ddd output
+var numbers = regexp.MustCompile(`0[xX][0-9a-fA-F]+|[0-9]+`)
+
+// IsProgram returns true if the filter looks like a classic BPF program from tcpdump -dd or -ddd instead of an expression
+func IsProgram(filter string) bool {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return false
+	}
+	return strings.Trim(numbers.ReplaceAllString(filter, ""), " \t\r\n{},") == ""
+}
+
+// ParseProgram parses the output of tcpdump -dd (C array) or -ddd (decimal with a leading instruction count) into a
+// classic BPF program (e.g., tcpdump -dd 'tcp port 21')
+func ParseProgram(filter string) ([]Instruction, error) {
+	var values []uint32
+	for _, number := range numbers.FindAllString(filter, -1) {
+		v, err := strconv.ParseUint(number, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("pcap.ParseProgram(): %s is not a valid BPF value: %s", number, err)
+		}
+		values = append(values, uint32(v))
+	}
+	// The -ddd output starts with the number of instructions
+	if len(values)%4 == 1 && int(values[0]) == len(values)/4 {
+		values = values[1:]
+	}
+	if len(values) == 0 || len(values)%4 != 0 {
+		return nil, fmt.Errorf("pcap.ParseProgram(): the filter is not the output of tcpdump -dd or -ddd")
+	}
+	if len(values)/4 > MaxInstructions {
+		return nil, fmt.Errorf("pcap.ParseProgram(): the program has %d instructions but the maximum is %d", len(values)/4, MaxInstructions)
+	}
+	program := make([]Instruction, len(values)/4)
+	for i := range program {
+		v := values[i*4 : i*4+4]
+		if v[0] > 0xffff || v[1] > 0xff || v[2] > 0xff {
+			return nil, fmt.Errorf("pcap.ParseProgram(): instruction %d is out of range: %v", i, v)
+		}
+		program[i] = Instruction{Op: uint16(v[0]), Jt: uint8(v[1]), Jf: uint8(v[2]), K: v[3]}
+	}
+	return program, nil
+}
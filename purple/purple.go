@@ -0,0 +1,189 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package purple is a purple-team detonation mode where selected jobs also generate harmless, well-labeled artifacts
+// and telemetry, such as marker files, registry keys, and process trees, so defenders can validate their detections
+// while the command and control workflow stays realistic
+package purple
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LABEL identifies every artifact the purple-team mode generates so defenders can tell them apart from real activity
+const LABEL = "MERLIN-PURPLE-TEAM"
+
+// Artifact is a synthetic indicator of compromise that was generated for a job
+type Artifact struct {
+	Job  string // Job is the ID of the job the artifact was generated for
+	Type string // Type is the kind of artifact: file, registry, or process
+	Path string // Path is the file path, registry key, or process command line
+}
+
+// String returns a description of the artifact
+func (a Artifact) String() string {
+	return fmt.Sprintf("%s\t%s\t%s", a.Job, a.Type, a.Path)
+}
+
+// commands are the names of the commands that generate artifacts, "*" selects every command
+var commands = make(map[string]bool)
+
+// artifacts are the artifacts that were generated and not yet cleaned up
+var artifacts []Artifact
+
+// mu protects the commands and artifacts from concurrent access
+var mu sync.Mutex
+
+// SetCommands sets the comma separated list of commands that generate artifacts (e.g., ps,netstat); * selects every
+// command and an empty string disables the purple-team mode
+func SetCommands(value string) {
+	c := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			c[name] = true
+		}
+	}
+	mu.Lock()
+	commands = c
+	mu.Unlock()
+}
+
+// Selected determines if the command generates artifacts
+func Selected(command string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return commands["*"] || commands[strings.ToLower(command)]
+}
+
+// Detonate generates a marker file, a registry key on Windows, and a process tree labeled with the job ID and command.
+// It returns a description of the artifacts that were generated and any that failed
+func Detonate(job, command string) string {
+	if job == "" {
+		job = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	tag := fmt.Sprintf("%s job=%s command=%s time=%s", LABEL, job, command, time.Now().UTC().Format(time.RFC3339))
+	var generated []Artifact
+	var failed []string
+
+	// Marker file
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("%s-%s.txt", strings.ToLower(LABEL), job))
+	err := os.WriteFile(path, []byte(tag+"\n"), 0600)
+	if err != nil {
+		failed = append(failed, fmt.Sprintf("there was an error writing the marker file %s: %s", path, err))
+	} else {
+		generated = append(generated, Artifact{Job: job, Type: "file", Path: path})
+	}
+
+	// Registry key
+	key, err := registryKey(job, command, tag)
+	if err != nil {
+		failed = append(failed, err.Error())
+	} else if key != "" {
+		generated = append(generated, Artifact{Job: job, Type: "registry", Path: key})
+	}
+
+	// Process tree of the Agent, a shell, and a child shell that echos the label
+	line, err := processTree(tag)
+	if err != nil {
+		failed = append(failed, fmt.Sprintf("there was an error starting the process tree %s: %s", line, err))
+	} else {
+		generated = append(generated, Artifact{Job: job, Type: "process", Path: line})
+	}
+
+	mu.Lock()
+	artifacts = append(artifacts, generated...)
+	mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("\n%s artifacts:\n", LABEL))
+	for _, a := range generated {
+		b.WriteString(a.String() + "\n")
+	}
+	for _, f := range failed {
+		b.WriteString(f + "\n")
+	}
+	return b.String()
+}
+
+// processTree starts a shell that starts a child shell, both with the tag on their command line and in the
+// MERLIN_PURPLE_TEAM environment variable, and waits for them to finish
+func processTree(tag string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd.exe", "/c", "cmd.exe", "/c", "echo", tag) // #nosec G204 the tag is generated by the Agent
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", fmt.Sprintf("/bin/sh -c 'echo %s'; true", tag)) // #nosec G204 the tag is generated by the Agent
+	}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("MERLIN_PURPLE_TEAM=%s", tag))
+	line := strings.Join(cmd.Args, " ")
+	return line, cmd.Run()
+}
+
+// Artifacts returns the artifacts that were generated and not yet cleaned up
+func Artifacts() []Artifact {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Artifact(nil), artifacts...)
+}
+
+// Cleanup removes the marker files and registry keys that were generated and returns the number removed. Artifacts
+// that could not be removed are kept so that cleaning up can be tried again
+func Cleanup() (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	var kept []Artifact
+	var errs []string
+	var removed int
+	for _, a := range artifacts {
+		var err error
+		switch a.Type {
+		case "file":
+			err = os.Remove(a.Path)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		case "registry":
+			err = deleteRegistryKey(a.Path)
+		default:
+			// Processes already exited, there is nothing to remove
+			continue
+		}
+		if err != nil {
+			kept = append(kept, a)
+			errs = append(errs, err.Error())
+			continue
+		}
+		removed++
+	}
+	artifacts = kept
+	if len(errs) > 0 {
+		return removed, fmt.Errorf("purple.Cleanup(): there was an error removing %d artifacts: %s", len(errs), strings.Join(errs, "; "))
+	}
+	return removed, nil
+}
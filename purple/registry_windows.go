@@ -0,0 +1,72 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package purple
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+
+	// X Packages
+	"golang.org/x/sys/windows/registry"
+)
+
+// root is the registry key, under HKEY_CURRENT_USER, the purple-team mode's keys are created in
+const root = `Software\MerlinPurpleTeam`
+
+// registryKey creates a benign key under HKEY_CURRENT_USER named after the job with values for the command and tag
+func registryKey(job, command, tag string) (string, error) {
+	path := fmt.Sprintf(`%s\%s`, root, job)
+	key, _, err := registry.CreateKey(registry.CURRENT_USER, path, registry.SET_VALUE)
+	if err != nil {
+		return "", fmt.Errorf("there was an error creating the registry key HKCU\\%s: %s", path, err)
+	}
+	defer key.Close() // #nosec G307
+	err = key.SetStringValue("Command", command)
+	if err == nil {
+		err = key.SetStringValue("Tag", tag)
+	}
+	if err != nil {
+		return "", fmt.Errorf("there was an error setting the registry key HKCU\\%s values: %s", path, err)
+	}
+	return `HKCU\` + path, nil
+}
+
+// deleteRegistryKey deletes the key created by registryKey, and the root key once it has no more subkeys
+func deleteRegistryKey(path string) error {
+	path = strings.TrimPrefix(path, `HKCU\`)
+	err := registry.DeleteKey(registry.CURRENT_USER, path)
+	if err != nil && err != registry.ErrNotExist {
+		return fmt.Errorf("there was an error deleting the registry key HKCU\\%s: %s", path, err)
+	}
+	key, err := registry.OpenKey(registry.CURRENT_USER, root, registry.QUERY_VALUE|registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return nil
+	}
+	info, err := key.Stat()
+	_ = key.Close()
+	if err == nil && info.SubKeyCount == 0 {
+		_ = registry.DeleteKey(registry.CURRENT_USER, root)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package reauth holds the Agent's forced re-authentication policy: after a number of messages and/or an amount of time,
+// the Agent proactively authenticates again to establish a new session secret with a fresh key exchange instead of
+// waiting for the server to ask for it. Unlike rekeying, which derives the next secret from the current one, the new
+// secret shares nothing with the old one, so a compromised session key stops being useful at the next re-authentication
+package reauth
+
+import (
+	// Standard
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+	"github.com/Ne0nd0g/merlin-message/opaque"
+)
+
+// policy is the message count and interval after which the Agent re-authenticates; both 0 never re-authenticates
+var policy = struct {
+	messages int           // messages is the number of messages to send before re-authenticating; 0 disables
+	interval time.Duration // interval is how long to wait after authenticating before re-authenticating; 0 disables
+	count    int           // count is the number of messages sent since the Agent last authenticated
+	last     time.Time     // last is when the Agent last authenticated
+	sync.Mutex
+}{last: time.Now()}
+
+// Set parses and sets the policy from a comma separated message count and/or duration (e.g., 500, 12h, or 500,12h).
+// An empty string or 0 never re-authenticates
+func Set(value string) error {
+	var messages int
+	var interval time.Duration
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(v); err == nil {
+			if n < 0 {
+				return fmt.Errorf("reauth.Set(): the message count must be 0 or greater but received %d", n)
+			}
+			messages = n
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("reauth.Set(): %s is not a message count or duration: %s", v, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("reauth.Set(): the interval must be 0 or greater but received %s", d)
+		}
+		interval = d
+	}
+	policy.Lock()
+	defer policy.Unlock()
+	policy.messages = messages
+	policy.interval = interval
+	return nil
+}
+
+// Count records that a message was sent to the server with the current session secret
+func Count() {
+	policy.Lock()
+	defer policy.Unlock()
+	policy.count++
+}
+
+// Due returns true when the message count or interval since the Agent last authenticated was reached
+func Due() bool {
+	policy.Lock()
+	defer policy.Unlock()
+	if policy.messages > 0 && policy.count >= policy.messages {
+		return true
+	}
+	return policy.interval > 0 && time.Since(policy.last) >= policy.interval
+}
+
+// Reset starts the policy over because the Agent authenticated and has a new session secret
+func Reset() {
+	policy.Lock()
+	defer policy.Unlock()
+	policy.count = 0
+	policy.last = time.Now()
+}
+
+// Message returns the message the client's authenticator is given to re-authenticate. For OPAQUE, it starts
+// authentication with the existing registration, skipping the resumption token that would keep the current secret.
+// Authenticators that don't recognize it start authentication over
+func Message(agent uuid.UUID) messages.Base {
+	return messages.Base{ID: agent, Type: messages.OPAQUE, Payload: opaque.Opaque{Type: opaque.RegComplete}}
+}
+
+// String returns the message count and interval the Agent re-authenticates at
+func String() string {
+	policy.Lock()
+	defer policy.Unlock()
+	var p []string
+	if policy.messages > 0 {
+		p = append(p, fmt.Sprintf("%d messages", policy.messages))
+	}
+	if policy.interval > 0 {
+		p = append(p, policy.interval.String())
+	}
+	if len(p) == 0 {
+		return "never"
+	}
+	return fmt.Sprintf("every %s", strings.Join(p, " or "))
+}
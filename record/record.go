@@ -0,0 +1,303 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package record keeps an engagement record of the commands the Agent executed, the targets they named, and the
+// artifacts it wrote or collected, each timestamped, hashed, and chained to the previous entry, so that the operator can
+// export the record as JSON Lines at the end of an engagement for report writing and customer deconfliction
+package record
+
+import (
+	// Standard
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// COMMAND entries record a job the Agent started executing
+	COMMAND = "command"
+	// RESULT entries record the hash of a job's output
+	RESULT = "result"
+	// ARTIFACT entries record a file the Agent wrote to, or read from, the host
+	ARTIFACT = "artifact"
+)
+
+// Entry is a single line of the engagement record
+type Entry struct {
+	Sequence int       `json:"sequence"`          // Sequence is the entry's position in the record, starting at 1
+	Time     time.Time `json:"time"`              // Time is when the entry was recorded, in UTC
+	Type     string    `json:"type"`              // Type is COMMAND, RESULT, or ARTIFACT
+	Job      string    `json:"job,omitempty"`     // Job is the ID of the job the entry belongs to
+	Host     string    `json:"host"`              // Host is the hostname the Agent is running on
+	User     string    `json:"user"`              // User is the user the Agent is running as
+	Command  string    `json:"command,omitempty"` // Command is the name of the command the job runs
+	Args     []string  `json:"args,omitempty"`    // Args are the command's arguments
+	Targets  []string  `json:"targets,omitempty"` // Targets are the remote hosts, addresses, and shares named in the arguments
+	Path     string    `json:"path,omitempty"`    // Path is the artifact's location on the host
+	Action   string    `json:"action,omitempty"`  // Action is what happened to the artifact, such as written or collected
+	Size     int64     `json:"size"`              // Size is the length, in bytes, of the output or artifact that was hashed
+	SHA256   string    `json:"sha256,omitempty"`  // SHA256 is the hash of the command's arguments, output, or artifact
+	Error    string    `json:"error,omitempty"`   // Error is the error the job returned, if any
+	Previous string    `json:"previous"`          // Previous is the hash of the previous entry, or empty for the first
+	Hash     string    `json:"hash"`              // Hash is the SHA256 hash of this entry with an empty Hash field
+}
+
+// enabled determines if entries are recorded
+var enabled bool
+
+// entries are the entries recorded since the record was started or last cleared
+var entries []Entry
+
+// host and username identify where entries were recorded; they are looked up once
+var host, username string
+
+// mu protects the record and settings from concurrent access
+var mu sync.Mutex
+
+// SetEnabled parses and sets whether the Agent records an engagement record (e.g., true)
+func SetEnabled(value string) error {
+	if value == "" {
+		value = "false"
+	}
+	e, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("record.SetEnabled(): there was an error parsing %s as a boolean: %s", value, err)
+	}
+	mu.Lock()
+	enabled = e
+	mu.Unlock()
+	return nil
+}
+
+// Enabled returns true if the Agent is recording an engagement record
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// Command records a job the Agent started executing along with the targets named in its arguments
+func Command(job, command string, args []string) {
+	sum := sha256.Sum256([]byte(strings.Join(args, "\x00")))
+	add(Entry{
+		Type:    COMMAND,
+		Job:     job,
+		Command: command,
+		Args:    args,
+		Targets: Targets(args),
+		SHA256:  hex.EncodeToString(sum[:]),
+	})
+}
+
+// Result records the hash of a job's output, without the output itself, and any error it returned
+func Result(job, command, stdout, stderr string) {
+	sum := sha256.Sum256([]byte(stdout))
+	add(Entry{
+		Type:    RESULT,
+		Job:     job,
+		Command: command,
+		Size:    int64(len(stdout)),
+		SHA256:  hex.EncodeToString(sum[:]),
+		Error:   stderr,
+	})
+}
+
+// Artifact records data the Agent wrote to, or collected from, the path on the host
+func Artifact(job, action, path string, data []byte) {
+	sum := sha256.Sum256(data)
+	add(Entry{
+		Type:   ARTIFACT,
+		Job:    job,
+		Path:   path,
+		Action: action,
+		Size:   int64(len(data)),
+		SHA256: hex.EncodeToString(sum[:]),
+	})
+}
+
+// File records the file at the path the Agent wrote to, or collected from, the host by hashing it on disk, which avoids
+// holding large files in memory
+func File(job, action, path string) {
+	if !Enabled() {
+		return
+	}
+	entry := Entry{Type: ARTIFACT, Job: job, Path: path, Action: action}
+	f, err := os.Open(path) // #nosec G304 the path is a file the Agent just transferred
+	if err != nil {
+		entry.Error = err.Error()
+		add(entry)
+		return
+	}
+	defer f.Close() // #nosec G307
+	hash := sha256.New()
+	entry.Size, err = io.Copy(hash, f)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	add(entry)
+}
+
+// add timestamps the entry, chains it to the previous entry, and appends it to the record when recording is enabled
+func add(entry Entry) {
+	mu.Lock()
+	defer mu.Unlock()
+	if !enabled {
+		return
+	}
+	if host == "" {
+		host, _ = os.Hostname()
+		if u, err := user.Current(); err == nil {
+			username = u.Username
+		}
+	}
+	entry.Sequence = len(entries) + 1
+	entry.Time = time.Now().UTC()
+	entry.Host = host
+	entry.User = username
+	if len(entries) > 0 {
+		entry.Previous = entries[len(entries)-1].Hash
+	}
+	entry.Hash = hash(entry)
+	entries = append(entries, entry)
+}
+
+// hash returns the hex encoded SHA256 hash of the entry's JSON encoding with an empty Hash field
+func hash(entry Entry) string {
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Export returns the record as JSON Lines, one entry per line in the order they were recorded
+func Export() ([]byte, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return nil, fmt.Errorf("record.Export(): there was an error encoding entry %d: %s", entry.Sequence, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Verify checks every entry's hash and its link to the previous entry, returning an error for the first that fails
+func Verify() error {
+	mu.Lock()
+	defer mu.Unlock()
+	var previous string
+	for _, entry := range entries {
+		if entry.Previous != previous {
+			return fmt.Errorf("entry %d is not chained to the previous entry", entry.Sequence)
+		}
+		if hash(entry) != entry.Hash {
+			return fmt.Errorf("entry %d does not match its hash", entry.Sequence)
+		}
+		previous = entry.Hash
+	}
+	return nil
+}
+
+// Status returns whether recording is enabled, the number of entries, and the hash of the last entry
+func Status() (bool, int, string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(entries) == 0 {
+		return enabled, 0, ""
+	}
+	return enabled, len(entries), entries[len(entries)-1].Hash
+}
+
+// Clear removes every entry from the record and returns the number removed
+func Clear() int {
+	mu.Lock()
+	defer mu.Unlock()
+	n := len(entries)
+	entries = nil
+	return n
+}
+
+// Targets returns the remote hosts named in the arguments as IP addresses, CIDR ranges, host:port pairs, URLs, or UNC
+// paths, in the order they first appear
+func Targets(args []string) (targets []string) {
+	found := make(map[string]bool)
+	for _, arg := range args {
+		for _, field := range strings.FieldsFunc(arg, func(r rune) bool { return r == ' ' || r == ',' || r == '"' || r == '\'' }) {
+			target := target(field)
+			if target != "" && !found[target] {
+				found[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	return
+}
+
+// target returns the remote host the field names, or an empty string if it doesn't name one
+func target(field string) string {
+	// UNC paths such as \\host\share
+	if strings.HasPrefix(field, `\\`) {
+		host, _, _ := strings.Cut(field[2:], `\`)
+		return host
+	}
+	// URLs such as https://host:port/path or user@host
+	if strings.Contains(field, "://") {
+		if u, err := url.Parse(field); err == nil && u.Host != "" {
+			return u.Host
+		}
+		return ""
+	}
+	if _, after, ok := strings.Cut(field, "@"); ok && !strings.Contains(after, "@") {
+		field = after
+	}
+	if ip := net.ParseIP(field); ip != nil {
+		return ip.String()
+	}
+	if _, network, err := net.ParseCIDR(field); err == nil {
+		return network.String()
+	}
+	// host:port pairs where the host is a name or IP address, but not a number, so that times such as 12:30 are skipped
+	if h, port, err := net.SplitHostPort(field); err == nil && h != "" {
+		if _, err = strconv.Atoi(h); err == nil {
+			return ""
+		}
+		if _, err = strconv.ParseUint(port, 10, 16); err == nil {
+			return net.JoinHostPort(h, port)
+		}
+	}
+	return ""
+}
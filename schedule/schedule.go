@@ -0,0 +1,299 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package schedule holds the Agent's working hours, the days and times of day it checks in, so that its traffic blends
+// in with the target's business hours. Working hours can be derived from the host's detected locale and timezone at the
+// first check in and are reported to the operator for confirmation
+package schedule
+
+import (
+	// Standard
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AUTO derives the working hours from the host's locale and timezone at the first check in
+const AUTO = "auto"
+
+// offHoursJitter is the most time randomly added to the start of the next working window so that an Agent doesn't
+// check in at exactly the same time every morning
+const offHoursJitter = 20 * time.Minute
+
+// Hours are the days and times of day the Agent checks in
+type Hours struct {
+	Start    time.Duration  // Start is the time of day, since midnight, the working window starts
+	End      time.Duration  // End is the time of day the working window ends; before Start for overnight windows
+	Days     [7]bool        // Days are the days, indexed by time.Weekday, a working window starts on
+	Location *time.Location // Location is the timezone the times of day are in
+}
+
+// days are the abbreviations days are parsed from and printed as, indexed by time.Weekday
+var days = [7]string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// String returns the working hours in the same format they are parsed from (e.g., 08:00-18:00,mon-fri)
+func (h Hours) String() string {
+	// Start after a day off so that runs of days that wrap around the week, such as sat-wed, are a single range
+	first := 0
+	for day, ok := range h.Days {
+		if !ok {
+			first = (day + 1) % 7
+			break
+		}
+	}
+	var ranges []string
+	for i := 0; i < 7; i++ {
+		day := (first + i) % 7
+		if !h.Days[day] {
+			continue
+		}
+		last := i
+		for last+1 < 7 && h.Days[(first+last+1)%7] {
+			last++
+		}
+		if last == i {
+			ranges = append(ranges, days[day])
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%s-%s", days[day], days[(first+last)%7]))
+		}
+		i = last
+	}
+	return fmt.Sprintf("%s-%s,%s", clock(h.Start), clock(h.End), strings.Join(ranges, ","))
+}
+
+// Active returns true if the time is within a working window
+func (h Hours) Active(t time.Time) bool {
+	t = t.In(h.Location)
+	since := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if h.Start <= h.End {
+		return h.Days[t.Weekday()] && since >= h.Start && since < h.End
+	}
+	// Overnight windows belong to the day they started on
+	if since >= h.Start {
+		return h.Days[t.Weekday()]
+	}
+	return since < h.End && h.Days[(t.Weekday()+6)%7]
+}
+
+// Next returns the time the next working window starts after the time, or the time itself if it is within one
+func (h Hours) Next(t time.Time) time.Time {
+	if h.Active(t) {
+		return t
+	}
+	local := t.In(h.Location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, h.Location)
+	for i := 0; i <= 7; i++ {
+		day := midnight.AddDate(0, 0, i)
+		start := day.Add(h.Start)
+		if h.Days[day.Weekday()] && start.After(t) {
+			return start
+		}
+	}
+	return t
+}
+
+// Parse parses working hours as a start and end time of day followed by the comma separated days or ranges of days
+// they apply to (e.g., 08:00-18:00,mon-fri or 22:00-06:00,sun-thu). Without days, every day is a working day
+func Parse(value string, location *time.Location) (hours Hours, err error) {
+	fields := strings.Split(strings.ToLower(strings.TrimSpace(value)), ",")
+	start, end, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return hours, fmt.Errorf("the working hours %s must start with a time range such as 08:00-18:00", value)
+	}
+	hours.Start, err = parseClock(start)
+	if err != nil {
+		return
+	}
+	hours.End, err = parseClock(end)
+	if err != nil {
+		return
+	}
+	if hours.Start == hours.End {
+		return hours, fmt.Errorf("the working hours %s start and end at the same time", value)
+	}
+	if len(fields) == 1 {
+		fields = append(fields, "sun-sat")
+	}
+	for _, field := range fields[1:] {
+		first, last, isRange := strings.Cut(strings.TrimSpace(field), "-")
+		if !isRange {
+			last = first
+		}
+		var from, to int
+		from, err = parseDay(first)
+		if err != nil {
+			return
+		}
+		to, err = parseDay(last)
+		if err != nil {
+			return
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			hours.Days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	if location == nil {
+		location = time.Local
+	}
+	hours.Location = location
+	return
+}
+
+// parseClock parses a 24-hour time of day (e.g., 08:00 or 8) as the duration since midnight
+func parseClock(value string) (time.Duration, error) {
+	hour, minute, _ := strings.Cut(strings.TrimSpace(value), ":")
+	if minute == "" {
+		minute = "0"
+	}
+	h, err := strconv.Atoi(hour)
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("%s is not a valid 24-hour time of day", value)
+	}
+	m, err := strconv.Atoi(minute)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("%s is not a valid 24-hour time of day", value)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// parseDay returns the time.Weekday index of a day's name or abbreviation
+func parseDay(value string) (int, error) {
+	for day, name := range days {
+		if len(value) >= 3 && strings.HasPrefix(value, name) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("%s is not a valid day of the week", value)
+}
+
+// clock returns the duration since midnight as a 24-hour time of day
+func clock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// hours are the working hours the Agent checks in during; nil checks in at all times
+var hours *Hours
+
+// auto determines if the working hours are derived from the host's locale and timezone at the first check in
+var auto bool
+
+// detected is the locale and timezone the working hours were derived from, if they were
+var detected *Defaults
+
+// confirmed is true once the operator confirmed or set the working hours
+var confirmed bool
+
+// mu protects the working hours and settings from concurrent access
+var mu sync.Mutex
+
+// Set parses and sets the working hours. An empty string or "off" checks in at all times and "auto" derives the
+// working hours from the host's locale and timezone at the first check in
+func Set(value string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "off":
+		hours, auto, detected, confirmed = nil, false, nil, false
+	case AUTO:
+		hours, auto, detected, confirmed = nil, true, nil, false
+	default:
+		h, err := Parse(value, time.Local)
+		if err != nil {
+			return fmt.Errorf("schedule.Set(): %s", err)
+		}
+		hours, auto, detected, confirmed = &h, false, nil, true
+	}
+	return nil
+}
+
+// Pending returns true if the working hours are derived from the host's locale and timezone but haven't been yet
+func Pending() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return auto && detected == nil
+}
+
+// Detect derives the working hours from the host's locale and timezone, applies them, and returns a report of the
+// chosen defaults for the operator to confirm
+func Detect() string {
+	defaults := Derive()
+	mu.Lock()
+	h := defaults.Hours
+	hours, auto, detected, confirmed = &h, true, &defaults, false
+	mu.Unlock()
+	return fmt.Sprintf("%s\nConfirm the working hours with \"hours confirm\", change them with \"hours set <hours>\", or disable them with \"hours off\"", defaults)
+}
+
+// Confirm records that the operator confirmed the working hours, returning an error if there are none
+func Confirm() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if hours == nil {
+		return fmt.Errorf("there are no working hours to confirm")
+	}
+	confirmed = true
+	return nil
+}
+
+// Guardrails returns the locale and timezone the working hours were derived from, which the host is expected to keep
+// for the life of the Agent. Both are empty if the working hours weren't derived
+func Guardrails() (locale, timezone string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if detected == nil {
+		return "", ""
+	}
+	return detected.Locale, detected.Timezone
+}
+
+// Sleep returns how long the Agent sleeps for, extending the sleep to the start of the next working window, plus a
+// random amount of time, when the sleep would otherwise end outside the working hours
+func Sleep(now time.Time, sleep time.Duration) time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+	if hours == nil || hours.Active(now.Add(sleep)) {
+		return sleep
+	}
+	next := hours.Next(now.Add(sleep))
+	return next.Sub(now) + time.Duration(rand.Int63n(int64(offHoursJitter))) // #nosec G404 - Does not need to be cryptographically secure
+}
+
+// String returns the working hours and whether they were derived from the host and confirmed by the operator
+func String() string {
+	mu.Lock()
+	defer mu.Unlock()
+	switch {
+	case hours == nil && auto:
+		return "Working hours: pending detection at the first check in"
+	case hours == nil:
+		return "Working hours: off, checking in at all times"
+	}
+	s := fmt.Sprintf("Working hours: %s (%s), Confirmed: %t", hours, time.Now().In(hours.Location).Format("MST -07:00"), confirmed)
+	if detected != nil {
+		s += fmt.Sprintf("\n%s", detected)
+	}
+	return s
+}
@@ -0,0 +1,159 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package seal encrypts sensitive job results (e.g., credential dumps) to an operator-held offline public key before they
+// are sent, so that not even the Merlin server can read them. Results are NaCl anonymous sealed boxes (X25519,
+// XSalsa20-Poly1305) that only the holder of the matching private key can open
+package seal
+
+import (
+	// Standard
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	// 3rd Party
+	"golang.org/x/crypto/nacl/box"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+)
+
+// PREFIX identifies sealed job result output
+const PREFIX = "sealed:"
+
+// key is the operator's X25519 public key; nil disables sealing
+var key *[32]byte
+
+// commands are the lower case names of the commands whose results are sealed; "*" seals every result
+var commands = map[string]bool{"credman": true, "credprompt": true, "git": true, "minidump": true, "secrets": true, "sshagent": true}
+
+// mu protects the key and commands from concurrent access
+var mu sync.RWMutex
+
+// SetKey parses and sets the operator's base64 or hex encoded X25519 public key. An empty string disables sealing
+func SetKey(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		mu.Lock()
+		key = nil
+		mu.Unlock()
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		data, err = hex.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("seal.SetKey(): the public key must be base64 or hex encoded")
+		}
+	}
+	if len(data) != 32 {
+		return fmt.Errorf("seal.SetKey(): the X25519 public key must be 32 bytes but received %d", len(data))
+	}
+	k := new([32]byte)
+	copy(k[:], data)
+	mu.Lock()
+	key = k
+	mu.Unlock()
+	return nil
+}
+
+// SetCommands sets the comma separated list of commands whose results are sealed (e.g., minidump,run); * seals every result
+func SetCommands(value string) {
+	c := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			c[name] = true
+		}
+	}
+	mu.Lock()
+	commands = c
+	mu.Unlock()
+}
+
+// Sensitive determines if the command's results must be sealed
+func Sensitive(command string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return key != nil && (commands["*"] || commands[strings.ToLower(command)])
+}
+
+// String returns a description of the sealing configuration
+func String() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if key == nil {
+		return "disabled"
+	}
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	return fmt.Sprintf("sealing results of %s to public key %s", strings.Join(names, ","), base64.StdEncoding.EncodeToString(key[:]))
+}
+
+// Bytes seals the data to the operator's public key
+func Bytes(data []byte) ([]byte, error) {
+	mu.RLock()
+	k := key
+	mu.RUnlock()
+	if k == nil {
+		return nil, fmt.Errorf("seal.Bytes(): an operator public key is not configured")
+	}
+	sealed, err := box.SealAnonymous(nil, data, k, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("seal.Bytes(): %s", err)
+	}
+	return sealed, nil
+}
+
+// Results seals the JSON encoded job results to the operator's public key and returns them as the base64 encoded
+// Stdout with the sealed: prefix
+func Results(results jobs.Results) (jobs.Results, error) {
+	data, err := json.Marshal(results)
+	if err != nil {
+		return results, fmt.Errorf("seal.Results(): there was an error encoding the results: %s", err)
+	}
+	sealed, err := Bytes(data)
+	if err != nil {
+		return results, err
+	}
+	return jobs.Results{Stdout: PREFIX + base64.StdEncoding.EncodeToString(sealed)}, nil
+}
+
+// FileTransfer seals the file's data to the operator's public key and adds the .sealed extension to the file name
+func FileTransfer(ft jobs.FileTransfer) (jobs.FileTransfer, error) {
+	data, err := base64.StdEncoding.DecodeString(ft.FileBlob)
+	if err != nil {
+		return ft, fmt.Errorf("seal.FileTransfer(): there was an error decoding the file data: %s", err)
+	}
+	sealed, err := Bytes(data)
+	if err != nil {
+		return ft, err
+	}
+	ft.FileBlob = base64.StdEncoding.EncodeToString(sealed)
+	ft.FileLocation += ".sealed"
+	return ft, nil
+}
@@ -0,0 +1,154 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package secrets finds credentials, tokens, keys, and connection strings embedded in text with pattern rules, and an
+// entropy threshold for generic rules, so that modules that collect files, configuration, or memory return the secrets
+// in them as structured findings
+package secrets
+
+import (
+	// Standard
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"regexp"
+)
+
+// maxLine is the longest line, in bytes, that is scanned; longer lines are truncated
+const maxLine = 64 << 10
+
+// Finding is a secret that matched a rule
+type Finding struct {
+	Source  string  `json:"source"`            // Source is where the secret was found, such as a file path
+	Line    int     `json:"line,omitempty"`    // Line is the line number in the source the secret is on
+	Rule    string  `json:"rule"`              // Rule is the name of the rule that matched
+	Secret  string  `json:"secret"`            // Secret is the matched secret
+	Entropy float64 `json:"entropy,omitempty"` // Entropy is the Shannon entropy of the secret, in bits per character
+}
+
+// Rule is a pattern that identifies a secret
+type Rule struct {
+	Name       string         // Name identifies the type of secret the rule finds
+	Pattern    *regexp.Regexp // Pattern matches the secret
+	Group      int            // Group is the Pattern's capture group holding the secret; 0 is the whole match
+	MinEntropy float64        // MinEntropy is the entropy the secret must have to filter out placeholders; 0 disables
+}
+
+// Rules are the rules every scan uses
+var Rules = []Rule{
+	{Name: "aws-access-key-id", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "aws-secret-access-key", Pattern: regexp.MustCompile(`(?i)aws.{0,20}?(?:secret|private).{0,20}?["'\s:=]+([A-Za-z0-9/+=]{40})\b`), Group: 1, MinEntropy: 4},
+	{Name: "azure-storage-key", Pattern: regexp.MustCompile(`(?i)AccountKey=([A-Za-z0-9+/]{86}==)`), Group: 1},
+	{Name: "google-api-key", Pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{Name: "stripe-key", Pattern: regexp.MustCompile(`\b(?:sk|rk)_live_[0-9a-zA-Z]{24,}\b`)},
+	{Name: "github-token", Pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{82})\b`)},
+	{Name: "gitlab-token", Pattern: regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20}\b`)},
+	{Name: "slack-token", Pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{Name: "private-key", Pattern: regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )*PRIVATE KEY-----`)},
+	{Name: "jwt", Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{Name: "connection-string", Pattern: regexp.MustCompile(`(?i)(?:server|data source|host|address)\s*=[^;"'\n]+;[^"'\n]*?(?:password|pwd)\s*=[^;"'\n]+`)},
+	{Name: "url-credentials", Pattern: regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:([^/\s:@]+)@[^\s/]+`)},
+	{Name: "assignment", Pattern: regexp.MustCompile(`(?i)(?:password|passwd|secret|token|api[_-]?key|access[_-]?key|auth)[a-z_-]*["']?\s*[:=]\s*["']?([^\s"',;/[:cntrl:]][^\s"',;[:cntrl:]]{7,})`), Group: 1, MinEntropy: 3},
+}
+
+// Scan checks every line of the data against the Rules and returns the secrets found, identified by the source
+func Scan(source string, data []byte) (findings []Finding) {
+	for line := 1; len(data) > 0; line++ {
+		text := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			text, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		if len(text) > maxLine {
+			text = text[:maxLine]
+		}
+		for _, finding := range ScanLine(text) {
+			finding.Source = source
+			finding.Line = line
+			findings = append(findings, finding)
+		}
+	}
+	return
+}
+
+// ScanReader checks every line read from the reader against the Rules and returns the secrets found, identified by the
+// source, without holding more than one line in memory so that large files such as memory dumps can be scanned
+func ScanReader(source string, reader io.Reader) (findings []Finding, err error) {
+	buffered := bufio.NewReaderSize(reader, maxLine)
+	for line := 1; ; line++ {
+		var text []byte
+		text, err = buffered.ReadSlice('\n')
+		for _, finding := range ScanLine(text) {
+			finding.Source = source
+			finding.Line = line
+			findings = append(findings, finding)
+		}
+		// Skip the rest of a line that is longer than the buffer
+		for err == bufio.ErrBufferFull {
+			_, err = buffered.ReadSlice('\n')
+		}
+		if err == io.EOF {
+			return findings, nil
+		}
+		if err != nil {
+			return findings, err
+		}
+	}
+}
+
+// ScanLine checks a single line of text against the Rules and returns the secrets found without a source
+func ScanLine(text []byte) (findings []Finding) {
+	found := make(map[string]bool)
+	for _, rule := range Rules {
+		for _, match := range rule.Pattern.FindAllSubmatch(text, -1) {
+			if rule.Group >= len(match) || len(match[rule.Group]) == 0 {
+				continue
+			}
+			secret := string(match[rule.Group])
+			entropy := Entropy(secret)
+			if entropy < rule.MinEntropy || found[secret] {
+				continue
+			}
+			found[secret] = true
+			findings = append(findings, Finding{Rule: rule.Name, Secret: secret, Entropy: math.Round(entropy*100) / 100})
+		}
+	}
+	return
+}
+
+// Entropy returns the Shannon entropy of the string in bits per character
+func Entropy(s string) (entropy float64) {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	var total int
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return
+}
@@ -23,7 +23,6 @@ package agent
 
 import (
 	// Standard
-	"strconv"
 	"time"
 
 	// Internal
@@ -91,7 +90,10 @@ func (s *Service) AgentInfo() messages.AgentInfo {
 		Domain:       p.Domain,
 	}
 
-	padding, _ := strconv.Atoi(c.Get("paddingmax"))
+	var padding int
+	if p, ok := c.(clients.Padder); ok {
+		padding = p.Padding().Max()
+	}
 	agentInfoMessage := messages.AgentInfo{
 		Version:       core.Version,
 		Build:         core.Build,
@@ -126,6 +128,11 @@ func (s *Service) SetAuthenticated(authenticated bool) {
 	s.AgentRepo.SetAuthenticated(authenticated)
 }
 
+// SetComms replaces the Agent's communication profile, such as its sleep, skew, and kill date, all at once
+func (s *Service) SetComms(comms agent.Comms) {
+	s.AgentRepo.SetComms(comms)
+}
+
 // SetFailedCheckIn updates the number of times the Agent has already failed to check in with the provided value
 func (s *Service) SetFailedCheckIn(failed int) {
 	s.AgentRepo.SetFailedCheckIn(failed)
@@ -136,6 +143,11 @@ func (s *Service) SetInitialCheckIn(checkin time.Time) {
 	s.AgentRepo.SetInitialCheckIn(checkin)
 }
 
+// SetHibernate updates the time the Agent resumes checking in after hibernating; a zero time ends hibernation
+func (s *Service) SetHibernate(until time.Time) {
+	s.AgentRepo.SetHibernate(until)
+}
+
 // SetKillDate updates the date, as an epoch timestamp, that the Agent will quit running
 func (s *Service) SetKillDate(date int64) {
 	s.AgentRepo.SetKillDate(date)
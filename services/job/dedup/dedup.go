@@ -0,0 +1,80 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package dedup remembers the idempotency keys of the taskings the Agent recently processed so that a retransmitted or
+// replayed tasking, which is common on lossy transports, isn't executed twice
+package dedup
+
+import (
+	// Standard
+	"sync"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message/jobs"
+)
+
+// Size is the number of the most recent idempotency keys that are remembered
+const Size = 1024
+
+// keys is the set of remembered idempotency keys
+var keys = make(map[string]struct{}, Size)
+
+// order holds the remembered keys, oldest first, so the oldest is forgotten when the cache is full
+var order []string
+
+// mu protects the keys and order from concurrent access
+var mu sync.Mutex
+
+// Tasking returns true for the job types the server sends to be executed; results, Agent information, and SOCKS data
+// are not taskings
+func Tasking(job jobs.Job) bool {
+	switch job.Type {
+	case jobs.CMD, jobs.CONTROL, jobs.FILETRANSFER, jobs.MODULE, jobs.NATIVE, jobs.SHELLCODE:
+		return true
+	default:
+		return false
+	}
+}
+
+// Key returns the job's idempotency key; the server gives every tasking a unique ID and token
+func Key(job jobs.Job) string {
+	return job.ID + ":" + job.Token.String()
+}
+
+// Seen remembers the job's idempotency key and returns true if it was already remembered. Jobs without an ID are never
+// considered seen
+func Seen(job jobs.Job) bool {
+	if job.ID == "" {
+		return false
+	}
+	key := Key(job)
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := keys[key]; ok {
+		return true
+	}
+	if len(order) >= Size {
+		delete(keys, order[0])
+		order = order[1:]
+	}
+	keys[key] = struct{}{}
+	order = append(order, key)
+	return false
+}
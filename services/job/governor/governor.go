@@ -0,0 +1,193 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package governor limits how quickly the Agent starts jobs and how many network-heavy jobs run at the same time so that
+// a flood of tasks doesn't overwhelm a fragile host
+package governor
+
+import (
+	// Standard
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxHold is the longest a job is held while outgoing messages are backing up so that a stalled connection can't stop
+// every job, including the one that would remove it
+const MaxHold = time.Minute
+
+// rate is the maximum number of jobs started in any one minute; 0 is unlimited
+var rate int
+
+// concurrent is the maximum number of network-heavy jobs that can run at the same time; 0 is unlimited
+var concurrent int
+
+// started holds the time of every job started in the last minute
+var started []time.Time
+
+// running is the number of network-heavy jobs currently running
+var running int
+
+// pressure returns true when outgoing messages are backing up; new jobs are held while it does, up to MaxHold
+var pressure func() bool
+
+// mu protects the governor's settings and state from concurrent access
+var mu sync.Mutex
+
+// available is used to signal jobs waiting on a network-heavy slot
+var available = sync.NewCond(&mu)
+
+// Rate returns the maximum number of jobs started in any one minute; 0 is unlimited
+func Rate() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return rate
+}
+
+// SetRate parses and sets the maximum number of jobs started in any one minute. An empty string or 0 is unlimited
+func SetRate(value string) error {
+	i, err := parse(value)
+	if err != nil {
+		return fmt.Errorf("services/job/governor.SetRate(): %s", err)
+	}
+	mu.Lock()
+	rate = i
+	mu.Unlock()
+	return nil
+}
+
+// Concurrent returns the maximum number of network-heavy jobs that can run at the same time; 0 is unlimited
+func Concurrent() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return concurrent
+}
+
+// SetConcurrent parses and sets the maximum number of network-heavy jobs that can run at the same time.
+// An empty string or 0 is unlimited
+func SetConcurrent(value string) error {
+	i, err := parse(value)
+	if err != nil {
+		return fmt.Errorf("services/job/governor.SetConcurrent(): %s", err)
+	}
+	mu.Lock()
+	concurrent = i
+	mu.Unlock()
+	available.Broadcast()
+	return nil
+}
+
+// SetBackpressure sets the function that reports when outgoing messages are backing up
+func SetBackpressure(f func() bool) {
+	mu.Lock()
+	pressure = f
+	mu.Unlock()
+}
+
+// Wait blocks until starting another job will not exceed the maximum number of jobs per minute and outgoing messages
+// are no longer backing up, and returns how long it waited
+func Wait() time.Duration {
+	start := time.Now()
+	for {
+		mu.Lock()
+		congested := pressure
+		mu.Unlock()
+		if congested != nil && time.Since(start) < MaxHold && congested() {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		mu.Lock()
+		if rate <= 0 {
+			started = nil
+			mu.Unlock()
+			return time.Since(start)
+		}
+		now := time.Now()
+		i := 0
+		for i < len(started) && now.Sub(started[i]) >= time.Minute {
+			i++
+		}
+		started = started[i:]
+		if len(started) < rate {
+			started = append(started, now)
+			mu.Unlock()
+			return time.Since(start)
+		}
+		delay := started[len(started)-rate].Add(time.Minute).Sub(now)
+		mu.Unlock()
+		// Re-check at least every second in case the rate is changed while waiting
+		if delay > time.Second {
+			delay = time.Second
+		}
+		time.Sleep(delay)
+	}
+}
+
+// Acquire blocks until a network-heavy job is allowed to run. Every call must be followed by a call to Release
+func Acquire() {
+	mu.Lock()
+	for concurrent > 0 && running >= concurrent {
+		available.Wait()
+	}
+	running++
+	mu.Unlock()
+}
+
+// Release frees the slot held by a network-heavy job that has finished
+func Release() {
+	mu.Lock()
+	running--
+	mu.Unlock()
+	available.Broadcast()
+}
+
+// String returns a description of the governor's limits
+func String() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return fmt.Sprintf("%s jobs per minute, %s concurrent network jobs", limit(rate), limit(concurrent))
+}
+
+// limit returns the value or "unlimited" when it is 0
+func limit(i int) string {
+	if i <= 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(i)
+}
+
+// parse converts the value to a non-negative integer; an empty string is 0
+func parse(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error converting %s to an integer: %s", value, err)
+	}
+	if i < 0 {
+		return 0, fmt.Errorf("the value must be 0 or greater but received %d", i)
+	}
+	return i, nil
+}
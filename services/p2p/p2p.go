@@ -23,7 +23,6 @@ package p2p
 
 import (
 	// Standard
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -41,9 +40,13 @@ import (
 	// Internal
 	"github.com/Ne0nd0g/merlin-agent/v2/cli"
 	"github.com/Ne0nd0g/merlin-agent/v2/p2p"
+	"github.com/Ne0nd0g/merlin-agent/v2/p2p/frame"
 	"github.com/Ne0nd0g/merlin-agent/v2/p2p/memory"
 )
 
+// WriteTimeout is the longest a single message write to a linked Agent can take before the Link is removed
+const WriteTimeout = time.Second * 30
+
 // Service is the structure used to interact with Link and Delegate objects
 type Service struct {
 	repo p2p.Repository
@@ -79,6 +82,10 @@ func (s *Service) AddDelegate(delegate messages.Delegate) {
 
 // AddLink stores a Link object in the repository
 func (s *Service) AddLink(link *p2p.Link) {
+	// Stop writing to the connection of the Link being replaced
+	if old, err := s.repo.Get(link.ID()); err == nil && old != link {
+		old.Queue().Close()
+	}
 	s.repo.Store(link)
 }
 
@@ -95,6 +102,9 @@ func (s *Service) Connected(agentType int, ip string) (*p2p.Link, bool) {
 
 // Delete removes the peer-to-peer link from the repository without trying to gracefully close the connection
 func (s *Service) Delete(id uuid.UUID) {
+	if link, err := s.repo.Get(id); err == nil {
+		link.Queue().Close()
+	}
 	s.repo.Delete(id)
 }
 
@@ -122,128 +132,226 @@ func (s *Service) Check() (delegates []messages.Delegate) {
 	return
 }
 
-// Handle takes in a list of incoming Delegate messages to this parent Agent and sends it to the child or linked Agent
+// Handle takes in a list of incoming Delegate messages to this parent Agent and adds them to the outgoing queue of the
+// child or linked Agent they are for. A routine per Link writes the queued messages so that a slow or stalled child
+// Agent doesn't block this Agent. Messages for a Link whose queue is full are dropped
 func (s *Service) Handle(delegates []messages.Delegate) {
 	cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.Handle(): entering into function with %d delegate messages", len(delegates)))
 	defer cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.Handle(): exiting function"))
+	s.enqueue(delegates, p2p.NORMAL)
+}
 
+// HandleNow adds the Delegate messages to the front of the child or linked Agent's outgoing queue
+// Used for control messages, such as the final message sent to an Agent before it is unlinked
+func (s *Service) HandleNow(delegates []messages.Delegate) {
+	s.enqueue(delegates, p2p.HIGH)
+}
+
+// enqueue adds each Delegate message to its Link's outgoing queue with the provided priority and starts the routine
+// that writes the queue to the Link if it isn't already running
+func (s *Service) enqueue(delegates []messages.Delegate, priority int) {
 	for _, delegate := range delegates {
-		cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.Handle(): processing delegate message for %s, payload size: %d, delegate messages: %d", delegate.Agent, len(delegate.Payload), len(delegate.Delegates)))
+		cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.enqueue(): processing delegate message for %s, payload size: %d, delegate messages: %d", delegate.Agent, len(delegate.Payload), len(delegate.Delegates)))
 		link, err := s.repo.Get(delegate.Agent)
 		if err != nil {
 			cli.Message(cli.WARN, err.Error())
 			continue
 		}
-		// Lock the link so other go routines don't try to write to it at the same time causing the child to receive packets out of order
-		link.Lock()
+		err = link.Queue().Push(delegate, priority)
+		if err != nil {
+			count, size := link.Queue().Len()
+			cli.Message(cli.WARN, fmt.Sprintf("services/p2p.enqueue(): dropped a %d byte message for the linked agent %s with %d messages (%d bytes) already queued: %s", len(delegate.Payload), delegate.Agent, count, size, err))
+			continue
+		}
+		if link.Queue().Start() {
+			go s.drain(link)
+		}
+	}
+}
 
-		// Tag/Type, Length, Value (TLV)
-		// Determine the message type, which is static right now
-		// uint is 32-bits (4 bytes)
-		tag := make([]byte, 4)
-		binary.BigEndian.PutUint32(tag, uint32(1))
-		// Going for uint64 (8 bytes)
-		length := make([]byte, 8)
-		binary.BigEndian.PutUint64(length, uint64(len(delegate.Payload)))
-		// Prepend the data length
-		delegate.Payload = append(length, delegate.Payload...)
-		// Prepend the data type/tag
-		delegate.Payload = append(tag, delegate.Payload...)
-
-		var n int
-		sleep := time.Millisecond * 30
-
-		switch link.Type() {
-		case p2p.SMBBIND, p2p.SMBREVERSE:
-			// Split into fragments of MaxSize
-			fragments := int(math.Ceil(float64(len(delegate.Payload)) / float64(p2p.MaxSizeSMB)))
-			cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.Handle(): SMB data size is: %d, max SMB fragment size is %d, creating %d fragments", len(delegate.Payload), p2p.MaxSizeSMB, fragments))
-			var i int
-			size := len(delegate.Payload)
-			for i < fragments {
-				start := i * p2p.MaxSizeSMB
-				var stop int
-				// if bytes remaining are less than max size, read until the end
-				if size < p2p.MaxSizeSMB {
-					stop = len(delegate.Payload)
-				} else {
-					stop = (i + 1) * p2p.MaxSizeSMB
-				}
-				n, err = link.Conn().(net.Conn).Write(delegate.Payload[start:stop])
-				if err != nil {
-					cli.Message(cli.WARN, fmt.Sprintf("services/p2p.Handle(): there was an error writing a message to the linked agent %s: %s\n", link.Conn().(net.Conn).RemoteAddr(), err))
-					break
-				}
-				cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.Handle(): Wrote SMB fragment %d of %d", i+1, fragments))
-				i++
-				size = size - p2p.MaxSizeSMB
-			}
-		case p2p.TCPBIND, p2p.TCPREVERSE:
-			cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.Handle(): Writing %d bytes to the linked agent %s at %s at %s\n", len(delegate.Payload), delegate.Agent, link.Remote(), time.Now().UTC().Format(time.RFC3339)))
-			n, err = link.Conn().(net.Conn).Write(delegate.Payload)
-			cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.Handle(): Wrote %d bytes to the linked agent %s at %s at %s\n", n, delegate.Agent, link.Remote(), time.Now().UTC().Format(time.RFC3339)))
-		case p2p.UDPBIND, p2p.UDPREVERSE:
-			// Needed for space between consecutive delegate messages
-			sleep = time.Second * 1
-			// Split into fragments of MaxSize
-			fragments := int(math.Ceil(float64(len(delegate.Payload)) / float64(p2p.MaxSizeUDP)))
-			cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.Handle(): UDP data size is: %d, max UDP fragment size is %d, creating %d fragments", len(delegate.Payload), p2p.MaxSizeUDP, fragments))
-			var i int
-			size := len(delegate.Payload)
-			for i < fragments {
-				start := i * p2p.MaxSizeUDP
-				var stop int
-				// if bytes remaining are less than max size, read until the end
-				if size < p2p.MaxSizeUDP {
-					stop = len(delegate.Payload)
-				} else {
-					stop = (i + 1) * p2p.MaxSizeUDP
-				}
-				switch link.Type() {
-				case p2p.UDPBIND:
-					n, err = link.Conn().(net.Conn).Write(delegate.Payload[start:stop])
-				case p2p.UDPREVERSE:
-					n, err = link.Conn().(net.PacketConn).WriteTo(delegate.Payload[start:stop], link.Remote())
-				}
-				if err != nil {
-					cli.Message(cli.WARN, fmt.Sprintf("services/p2p.Handle(): there was an error writing a message to the linked agent %s: %s\n", link.Conn().(net.Conn).RemoteAddr(), err))
-					break
-				}
-				cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.Handle(): Wrote UDP fragment %d of %d", i+1, fragments))
-				i++
-				size = size - p2p.MaxSizeUDP
-				// UDP packets seemed to get dropped if too many are sent too fast
-				if fragments > 100 {
-					time.Sleep(time.Millisecond * 10)
-				}
-			}
-		default:
-			cli.Message(cli.WARN, fmt.Sprintf("services/p2p.Handle(): unhandled Agent type: %d", link.Type()))
-			break
+// Flush blocks until every message queued for the Link has been written or the timeout has elapsed
+// False is returned if messages were still queued when the timeout elapsed
+func (s *Service) Flush(id uuid.UUID, timeout time.Duration) bool {
+	link, err := s.repo.Get(id)
+	if err != nil {
+		return true
+	}
+	return link.Queue().Flush(timeout)
+}
+
+// Congested returns true when the outgoing queue of any peer-to-peer Link is more than half full
+// Used to signal job producers to slow down
+func (s *Service) Congested() bool {
+	for _, link := range s.repo.GetAll() {
+		if link.Queue().Congested() {
+			return true
 		}
+	}
+	return false
+}
 
+// drain writes the messages in the Link's outgoing queue to the linked Agent until the queue is closed or a write fails
+func (s *Service) drain(link *p2p.Link) {
+	cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.drain(): started writing queued messages for linked agent %s", link.ID()))
+	defer cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.drain(): stopped writing queued messages for linked agent %s", link.ID()))
+	for {
+		delegate, ok := link.Queue().Pop()
+		if !ok {
+			return
+		}
+		// Lock the link so other go routines don't try to write to it at the same time causing the child to receive packets out of order
+		link.Lock()
+		err := s.write(link, delegate)
 		if err != nil {
 			if errors.Is(err, syscall.EPIPE) {
-				cli.Message(cli.WARN, fmt.Sprintf("services/p2p.Handle(): the linked agent %s has closed the connection", link.Conn().(net.Conn).RemoteAddr()))
+				cli.Message(cli.WARN, fmt.Sprintf("services/p2p.drain(): the linked agent %s has closed the connection", link.Conn().(net.Conn).RemoteAddr()))
 			} else if errors.Is(err, syscall.ECONNRESET) {
-				cli.Message(cli.WARN, fmt.Sprintf("services/p2p.Handle(): the linked agent %s has reset the connection", link.Conn().(net.Conn).RemoteAddr()))
+				cli.Message(cli.WARN, fmt.Sprintf("services/p2p.drain(): the linked agent %s has reset the connection", link.Conn().(net.Conn).RemoteAddr()))
 			} else if errors.Is(err, io.EOF) {
-				cli.Message(cli.WARN, fmt.Sprintf("services/p2p.Handle(): the linked agent %s has closed the connection", link.Conn().(net.Conn).RemoteAddr()))
+				cli.Message(cli.WARN, fmt.Sprintf("services/p2p.drain(): the linked agent %s has closed the connection", link.Conn().(net.Conn).RemoteAddr()))
 			} else {
-				cli.Message(cli.WARN, fmt.Sprintf("services/p2p.Handle(): there was an error writing a message to the linked agent %s: %s\n", link.Conn().(net.Conn).RemoteAddr(), err))
+				cli.Message(cli.WARN, fmt.Sprintf("services/p2p.drain(): there was an error writing a message to the linked agent %s: %s\n", link.Conn().(net.Conn).RemoteAddr(), err))
 			}
-			cli.Message(cli.WARN, fmt.Sprintf("services/p2p.Handle(): removing the linked agent %s at %s from the repository", link.ID(), link.Conn().(net.Conn).RemoteAddr()))
+			cli.Message(cli.WARN, fmt.Sprintf("services/p2p.drain(): removing the linked agent %s at %s from the repository", link.ID(), link.Conn().(net.Conn).RemoteAddr()))
 			link.Unlock()
-			s.Delete(link.ID())
-			break
+			link.Queue().Done()
+			link.Queue().Close()
+			// The Link might have already been replaced by a new connection from the same Agent
+			if current, err := s.repo.Get(link.ID()); err == nil && current == link {
+				s.repo.Delete(link.ID())
+			}
+			return
 		}
-		cli.Message(cli.NOTE, fmt.Sprintf("Wrote %d bytes to the linked agent %s at %s at %s\n", len(delegate.Payload), delegate.Agent, link.Remote(), time.Now().UTC().Format(time.RFC3339)))
-		// Without a delay, synchronous connections can send multiple messages so fast that receiver thinks it is one message
-		// TODO Fix this so that way an artificial sleep is not needed
-		// Sent about 25 UDP IDLE messages in 1 second and caused the agent to receive them out of order
-		time.Sleep(sleep)
 		link.Unlock()
+		link.Queue().Done()
+	}
+}
+
+// write frames the Delegate message and writes it to the linked Agent's network connection, splitting it into fragments
+// when required by the connection type
+func (s *Service) write(link *p2p.Link, delegate messages.Delegate) error {
+	// Tag/Type, Length, Value (TLV)
+	delegate.Payload = frame.Frame(delegate.Payload)
+
+	// Don't let a stalled Agent block the routine writing to it forever
+	if conn, ok := link.Conn().(interface{ SetWriteDeadline(time.Time) error }); ok {
+		_ = conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
 	}
+
+	var n int
+	var err error
+	sleep := time.Millisecond * 30
+
+	switch link.Type() {
+	case p2p.SMBBIND, p2p.SMBREVERSE:
+		// Split into fragments of MaxSize
+		fragments := int(math.Ceil(float64(len(delegate.Payload)) / float64(p2p.MaxSizeSMB)))
+		cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.write(): SMB data size is: %d, max SMB fragment size is %d, creating %d fragments", len(delegate.Payload), p2p.MaxSizeSMB, fragments))
+		var i int
+		size := len(delegate.Payload)
+		for i < fragments {
+			start := i * p2p.MaxSizeSMB
+			var stop int
+			// if bytes remaining are less than max size, read until the end
+			if size < p2p.MaxSizeSMB {
+				stop = len(delegate.Payload)
+			} else {
+				stop = (i + 1) * p2p.MaxSizeSMB
+			}
+			n, err = link.Conn().(net.Conn).Write(delegate.Payload[start:stop])
+			if err != nil {
+				cli.Message(cli.WARN, fmt.Sprintf("services/p2p.write(): there was an error writing a message to the linked agent %s: %s\n", link.Conn().(net.Conn).RemoteAddr(), err))
+				break
+			}
+			cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.write(): Wrote SMB fragment %d of %d", i+1, fragments))
+			i++
+			size = size - p2p.MaxSizeSMB
+		}
+	case p2p.TCPBIND, p2p.TCPREVERSE, p2p.AIRGAP:
+		cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.write(): Writing %d bytes to the linked agent %s at %s at %s\n", len(delegate.Payload), delegate.Agent, link.Remote(), time.Now().UTC().Format(time.RFC3339)))
+		n, err = link.Conn().(net.Conn).Write(delegate.Payload)
+		cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.write(): Wrote %d bytes to the linked agent %s at %s at %s\n", n, delegate.Agent, link.Remote(), time.Now().UTC().Format(time.RFC3339)))
+	case p2p.UDPBIND, p2p.UDPREVERSE:
+		// Needed for space between consecutive delegate messages
+		sleep = time.Second * 1
+		// Split into fragments of MaxSize
+		fragments := int(math.Ceil(float64(len(delegate.Payload)) / float64(p2p.MaxSizeUDP)))
+		cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.write(): UDP data size is: %d, max UDP fragment size is %d, creating %d fragments", len(delegate.Payload), p2p.MaxSizeUDP, fragments))
+		var i int
+		size := len(delegate.Payload)
+		for i < fragments {
+			start := i * p2p.MaxSizeUDP
+			var stop int
+			// if bytes remaining are less than max size, read until the end
+			if size < p2p.MaxSizeUDP {
+				stop = len(delegate.Payload)
+			} else {
+				stop = (i + 1) * p2p.MaxSizeUDP
+			}
+			switch link.Type() {
+			case p2p.UDPBIND:
+				n, err = link.Conn().(net.Conn).Write(delegate.Payload[start:stop])
+			case p2p.UDPREVERSE:
+				n, err = link.Conn().(net.PacketConn).WriteTo(delegate.Payload[start:stop], link.Remote())
+			}
+			if err != nil {
+				cli.Message(cli.WARN, fmt.Sprintf("services/p2p.write(): there was an error writing a message to the linked agent %s: %s\n", link.Conn().(net.Conn).RemoteAddr(), err))
+				break
+			}
+			cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.write(): Wrote UDP fragment %d of %d", i+1, fragments))
+			i++
+			size = size - p2p.MaxSizeUDP
+			// UDP packets seemed to get dropped if too many are sent too fast
+			if fragments > 100 {
+				time.Sleep(time.Millisecond * 10)
+			}
+		}
+	case p2p.RAWBIND, p2p.RAWREVERSE:
+		// Needed for space between consecutive delegate messages
+		sleep = time.Second * 1
+		// Split into fragments of MaxSize, each one is prefixed with the raw IP header
+		fragments := int(math.Ceil(float64(len(delegate.Payload)) / float64(p2p.MaxSizeRaw)))
+		if fragments > math.MaxUint16 {
+			err = fmt.Errorf("the message size %d requires %d raw IP fragments but the maximum is %d", len(delegate.Payload), fragments, math.MaxUint16)
+			break
+		}
+		cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.write(): Raw IP data size is: %d, max raw IP fragment size is %d, creating %d fragments", len(delegate.Payload), p2p.MaxSizeRaw, fragments))
+		for i := 0; i < fragments; i++ {
+			start := i * p2p.MaxSizeRaw
+			stop := start + p2p.MaxSizeRaw
+			if stop > len(delegate.Payload) {
+				stop = len(delegate.Payload)
+			}
+			fragment := p2p.WrapRaw(delegate.Payload[start:stop], p2p.RawDownstream, uint16(i), uint16(fragments))
+			switch link.Type() {
+			case p2p.RAWBIND:
+				n, err = link.Conn().(net.Conn).Write(fragment)
+			case p2p.RAWREVERSE:
+				n, err = link.Conn().(net.PacketConn).WriteTo(fragment, link.Remote())
+			}
+			if err != nil {
+				break
+			}
+			cli.Message(cli.DEBUG, fmt.Sprintf("services/p2p.write(): Wrote raw IP fragment %d of %d", i+1, fragments))
+			// Raw IP packets, like UDP, are dropped if too many are sent too fast
+			if fragments > 100 {
+				time.Sleep(time.Millisecond * 10)
+			}
+		}
+	default:
+		cli.Message(cli.WARN, fmt.Sprintf("services/p2p.write(): unhandled Agent type: %d", link.Type()))
+		break
+	}
+	if err != nil {
+		return err
+	}
+
+	cli.Message(cli.NOTE, fmt.Sprintf("Wrote %d bytes to the linked agent %s at %s at %s\n", len(delegate.Payload), delegate.Agent, link.Remote(), time.Now().UTC().Format(time.RFC3339)))
+	// Without a delay, synchronous connections can send multiple messages so fast that receiver thinks it is one message
+	// TODO Fix this so that way an artificial sleep is not needed
+	// Sent about 25 UDP IDLE messages in 1 second and caused the agent to receive them out of order
+	time.Sleep(sleep)
+	return nil
 }
 
 // List returns a numbered list of peer-to-peer Links that exist each seperated by a new line
@@ -251,7 +359,11 @@ func (s *Service) List() (list string) {
 	agents := s.repo.GetAll()
 	list = fmt.Sprintf("Peer-to-Peer Links (%d)\n", len(agents))
 	for i, agent := range agents {
-		list += fmt.Sprintf("%d. %s:%s:%s\n", i, agent.String(), agent.ID(), agent.Remote())
+		list += fmt.Sprintf("%d. %s:%s:%s", i, agent.String(), agent.ID(), agent.Remote())
+		if count, size := agent.Queue().Len(); count > 0 {
+			list += fmt.Sprintf(" (%d queued messages, %d bytes)", count, size)
+		}
+		list += "\n"
 	}
 	return
 }
@@ -278,7 +390,7 @@ func (s *Service) Remove(id uuid.UUID) error {
 	}
 
 	switch link.Type() {
-	case p2p.TCPBIND, p2p.UDPBIND, p2p.SMBBIND:
+	case p2p.TCPBIND, p2p.UDPBIND, p2p.SMBBIND, p2p.RAWBIND, p2p.AIRGAP:
 		// Close the connection
 		err = link.Conn().(net.Conn).Close()
 		if err != nil {
@@ -287,6 +399,7 @@ func (s *Service) Remove(id uuid.UUID) error {
 	default:
 		return fmt.Errorf("services/p2p.Remove() unhandled peer-to-peer link type %d", link.Type())
 	}
+	link.Queue().Close()
 	s.repo.Delete(id)
 	return nil
 }
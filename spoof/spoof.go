@@ -0,0 +1,80 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package spoof hides the Agent's code from call stacks captured while it calls sensitive Windows APIs and while it
+// sleeps. The API is entered through a trampoline that replaces the return address with a "jmp [rbx]" gadget in a signed
+// system DLL, so a stack walk from the API shows a return into that DLL instead of into the Agent, which is unbacked
+// memory when the Agent is loaded from shellcode. The gadget jumps back to the trampoline, which restores the real
+// return address. Spoofing is configured at build time and is only supported by 64-bit Windows Agents; everywhere else
+// the calls are made directly
+package spoof
+
+import (
+	// Standard
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// enabled is true when the Agent spoofs the return address of sensitive API calls and of its sleep
+var enabled bool
+
+// mu protects enabled from concurrent access
+var mu sync.Mutex
+
+// SetEnabled parses and sets whether calls are spoofed from a boolean string; an empty string disables spoofing
+func SetEnabled(value string) error {
+	if value == "" {
+		value = "false"
+	}
+	e, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("spoof.SetEnabled(): there was an error parsing %s as a boolean: %s", value, err)
+	}
+	mu.Lock()
+	enabled = e
+	mu.Unlock()
+	return nil
+}
+
+// Enabled returns true if the Agent spoofs the return address of sensitive API calls and of its sleep
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// Sleep waits for the duration, or until the wake channel receives, and returns true if it was woken early. When
+// spoofing is enabled, the wait is a Windows API call made through the trampoline; if it can't be, the Go runtime waits
+func Sleep(d time.Duration, wake <-chan struct{}) bool {
+	if Enabled() {
+		woken, err := wait(d, wake)
+		if err == nil {
+			return woken
+		}
+	}
+	select {
+	case <-time.After(d):
+		return false
+	case <-wake:
+		return true
+	}
+}
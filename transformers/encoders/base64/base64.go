@@ -24,17 +24,32 @@ package base64
 import (
 	"encoding/base64"
 	"fmt"
+	"io"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
 )
 
 const (
-	BYTE   = 0
-	STRING = 1
+	BYTE      = 0
+	STRING    = 1
+	URLBYTE   = 2 // URLBYTE uses the unpadded URL and filename safe alphabet from RFC 4648 and returns bytes
+	URLSTRING = 3 // URLSTRING uses the unpadded URL and filename safe alphabet from RFC 4648 and returns a string
 )
 
 type Coder struct {
 	concrete int
 }
 
+func init() {
+	transformer.Register("base64-byte", func() transformer.Transformer { return NewEncoder(BYTE) })
+	transformer.Register("base64", func() transformer.Transformer { return NewEncoder(STRING) })
+	transformer.Register("base64-string", func() transformer.Transformer { return NewEncoder(STRING) })
+	transformer.Register("base64url-byte", func() transformer.Transformer { return NewEncoder(URLBYTE) })
+	transformer.Register("base64url", func() transformer.Transformer { return NewEncoder(URLSTRING) })
+	transformer.Register("base64url-string", func() transformer.Transformer { return NewEncoder(URLSTRING) })
+}
+
 // NewEncoder is a factory that returns a structure that implements the Transformer interface
 func NewEncoder(concrete int) *Coder {
 	return &Coder{concrete: concrete}
@@ -43,11 +58,14 @@ func NewEncoder(concrete int) *Coder {
 // Construct takes in data, Base64 encodes it, and returns the encoded data as bytes
 func (c *Coder) Construct(data any, key []byte) (retData []byte, err error) {
 	switch c.concrete {
-	case BYTE:
-		retData = make([]byte, base64.StdEncoding.EncodedLen(len(data.([]byte))))
-		base64.StdEncoding.Encode(retData, data.([]byte))
-	case STRING:
-		retData = []byte(base64.StdEncoding.EncodeToString(data.([]byte)))
+	case BYTE, URLBYTE:
+		enc := c.encoding()
+		retData = make([]byte, enc.EncodedLen(len(data.([]byte))))
+		enc.Encode(retData, data.([]byte))
+	case STRING, URLSTRING:
+		retData = []byte(c.encoding().EncodeToString(data.([]byte)))
+	default:
+		err = fmt.Errorf("transformer/encoders/base64.Construct(): unhandled concrete type %d", c.concrete)
 	}
 	return
 }
@@ -55,23 +73,49 @@ func (c *Coder) Construct(data any, key []byte) (retData []byte, err error) {
 // Deconstruct takes in bytes and Base64 decodes it to its original type
 func (c *Coder) Deconstruct(data, key []byte) (any, error) {
 	switch c.concrete {
-	case BYTE:
-		retData := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
-		return base64.StdEncoding.Decode(retData, data)
-	case STRING:
-		return base64.StdEncoding.DecodeString(string(data))
+	case BYTE, URLBYTE:
+		enc := c.encoding()
+		retData := make([]byte, enc.DecodedLen(len(data)))
+		n, err := enc.Decode(retData, data)
+		return retData[:n], err
+	case STRING, URLSTRING:
+		return c.encoding().DecodeString(string(data))
 	default:
 		return nil, fmt.Errorf("transformer/encoders/base64.Deconstruct(): unhandled concrete type %d", c.concrete)
 	}
 }
 
-// String converts the Gob encode/decode constant to a string
+// ConstructStream returns a writer that Base64 encodes the data written to it and writes it to w
+func (c *Coder) ConstructStream(w io.Writer, key []byte) (io.WriteCloser, error) {
+	return base64.NewEncoder(c.encoding(), w), nil
+}
+
+// DeconstructStream returns a reader that Base64 decodes the data read from r
+func (c *Coder) DeconstructStream(r io.Reader, key []byte) (io.Reader, error) {
+	return base64.NewDecoder(c.encoding(), r), nil
+}
+
+// encoding returns the standard or URL safe Base64 encoding for the concrete type
+func (c *Coder) encoding() *base64.Encoding {
+	switch c.concrete {
+	case URLBYTE, URLSTRING:
+		return base64.RawURLEncoding
+	default:
+		return base64.StdEncoding
+	}
+}
+
+// String converts the Base64 encode/decode constant to a string
 func (c *Coder) String() string {
 	switch c.concrete {
 	case BYTE:
 		return "base64-byte"
 	case STRING:
 		return "base64-string"
+	case URLBYTE:
+		return "base64url-byte"
+	case URLSTRING:
+		return "base64url-string"
 	default:
 		return fmt.Sprintf("unknown base64 transform %d", c.concrete)
 	}
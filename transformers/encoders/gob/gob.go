@@ -29,6 +29,9 @@ import (
 
 	// Merlin
 	"github.com/Ne0nd0g/merlin-message"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
 )
 
 const (
@@ -41,6 +44,11 @@ type Coder struct {
 	concrete int
 }
 
+func init() {
+	transformer.Register("gob-base", func() transformer.Transformer { return NewEncoder(BASE) })
+	transformer.Register("gob-string", func() transformer.Transformer { return NewEncoder(STRING) })
+}
+
 // NewEncoder is a factory that returns a structure that implements the Transformer interface
 func NewEncoder(concrete int) *Coder {
 	return &Coder{concrete: concrete}
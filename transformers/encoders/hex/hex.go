@@ -24,6 +24,10 @@ package hex
 import (
 	"encoding/hex"
 	"fmt"
+	"io"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
 )
 
 const (
@@ -35,6 +39,12 @@ type Coder struct {
 	concrete int
 }
 
+func init() {
+	transformer.Register("hex-byte", func() transformer.Transformer { return NewEncoder(BYTE) })
+	transformer.Register("hex", func() transformer.Transformer { return NewEncoder(STRING) })
+	transformer.Register("hex-string", func() transformer.Transformer { return NewEncoder(STRING) })
+}
+
 // NewEncoder is a factory that returns a structure that implements the Transformer interface
 func NewEncoder(concrete int) *Coder {
 	return &Coder{concrete: concrete}
@@ -60,7 +70,7 @@ func (c *Coder) Deconstruct(data, key []byte) (any, error) {
 	retData := make([]byte, hex.DecodedLen(len(data)))
 	_, err := hex.Decode(retData, data)
 	if err != nil {
-		return nil, fmt.Errorf("transformer/encoders/hex.Deconstruct(): there was an error Base64 decoding the incoming data: %s", err)
+		return nil, fmt.Errorf("transformer/encoders/hex.Deconstruct(): there was an error hex decoding the incoming data: %s", err)
 	}
 	switch c.concrete {
 	case BYTE:
@@ -72,7 +82,17 @@ func (c *Coder) Deconstruct(data, key []byte) (any, error) {
 	}
 }
 
-// String converts the Gob encode/decode constant to a string
+// ConstructStream returns a writer that hex encodes the data written to it and writes it to w
+func (c *Coder) ConstructStream(w io.Writer, key []byte) (io.WriteCloser, error) {
+	return transformer.NopCloser(hex.NewEncoder(w)), nil
+}
+
+// DeconstructStream returns a reader that hex decodes the data read from r
+func (c *Coder) DeconstructStream(r io.Reader, key []byte) (io.Reader, error) {
+	return hex.NewDecoder(r), nil
+}
+
+// String converts the hex encode/decode constant to a string
 func (c *Coder) String() string {
 	switch c.concrete {
 	case BYTE:
@@ -80,6 +100,6 @@ func (c *Coder) String() string {
 	case STRING:
 		return "hex-string"
 	default:
-		return fmt.Sprintf("hex base64 transform %d", c.concrete)
+		return fmt.Sprintf("unknown hex transform %d", c.concrete)
 	}
 }
@@ -0,0 +1,259 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package json encodes/decodes Agent messages as JSON so they can be read by listeners that aren't written in Go
+package json
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+	"github.com/Ne0nd0g/merlin-message/jobs"
+	"github.com/Ne0nd0g/merlin-message/opaque"
+	"github.com/Ne0nd0g/merlin-message/rsa"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/prekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/chunk"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/psk"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/reload"
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+)
+
+const (
+	BASE     = 0
+	DELEGATE = 1
+)
+
+// Coder is the structure that implements the Transformer interface for JSON encoding/decoding
+type Coder struct {
+	concrete int
+}
+
+// base mirrors messages.Base but holds the Payload as raw JSON until the message Type is known
+type base struct {
+	ID        uuid.UUID           `json:"id"`
+	Type      messages.Type       `json:"type"`
+	Payload   json.RawMessage     `json:"payload,omitempty"`
+	Padding   string              `json:"padding"`
+	Token     string              `json:"token,omitempty"`
+	Delegates []messages.Delegate `json:"delegate,omitempty"`
+}
+
+// job mirrors jobs.Job but holds the Payload as raw JSON until the job Type is known
+type job struct {
+	AgentID uuid.UUID
+	ID      string
+	Token   uuid.UUID
+	Type    jobs.Type
+	Payload json.RawMessage
+}
+
+func init() {
+	transformer.Register("json", func() transformer.Transformer { return NewEncoder(BASE) })
+	transformer.Register("json-base", func() transformer.Transformer { return NewEncoder(BASE) })
+}
+
+// NewEncoder is a factory that returns a structure that implements the Transformer interface
+func NewEncoder(concrete int) *Coder {
+	return &Coder{concrete: concrete}
+}
+
+// Construct takes in data, JSON encodes it, and returns the encoded data as bytes
+func (c *Coder) Construct(data any, key []byte) ([]byte, error) {
+	return c.Encode(data)
+}
+
+// Deconstruct takes in bytes and JSON decodes it to its original type
+func (c *Coder) Deconstruct(data, key []byte) (any, error) {
+	return c.Decode(data)
+}
+
+// Encode takes in data, JSON encodes it, and returns the encoded data as bytes
+// This function is exported so that it can be called directly outside the Transformer interface
+func (c *Coder) Encode(e any) ([]byte, error) {
+	switch c.concrete {
+	case BASE:
+		data, ok := e.(messages.Base)
+		if !ok {
+			return nil, fmt.Errorf("transformers/encoders/json.Encode(): expected messages.Base but received %T", e)
+		}
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/json.Encode(): error JSON encoding messages.Base: %s", err)
+		}
+		return encoded, nil
+	case DELEGATE:
+		data, ok := e.(messages.Delegate)
+		if !ok {
+			return nil, fmt.Errorf("transformers/encoders/json.Encode(): expected messages.Delegate but received %T", e)
+		}
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/json.Encode(): error JSON encoding messages.Delegate: %s", err)
+		}
+		return encoded, nil
+	default:
+		return nil, fmt.Errorf("transformers/encoders/json.Encode(): unhandled concrete type %d", c.concrete)
+	}
+}
+
+// Decode takes in bytes and JSON decodes it to its original type.
+// The Base message's Payload, and each job's Payload, are decoded into the concrete type their Type field identifies
+// This function is exported so that it can be called directly outside the Transformer interface
+func (c *Coder) Decode(data []byte) (any, error) {
+	switch c.concrete {
+	case BASE:
+		var b base
+		err := json.Unmarshal(data, &b)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/json.Decode(): error JSON decoding messages.Base: %s", err)
+		}
+		msg := messages.Base{
+			ID:        b.ID,
+			Type:      b.Type,
+			Padding:   b.Padding,
+			Token:     b.Token,
+			Delegates: b.Delegates,
+		}
+		msg.Payload, err = Payload(b.Type, b.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/json.Decode(): error JSON decoding the %s message payload: %s", b.Type, err)
+		}
+		return msg, nil
+	case DELEGATE:
+		var d messages.Delegate
+		err := json.Unmarshal(data, &d)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/json.Decode(): error JSON decoding messages.Delegate: %s", err)
+		}
+		return d, nil
+	default:
+		return nil, fmt.Errorf("transformers/encoders/json.Decode(): unhandled concrete type %d", c.concrete)
+	}
+}
+
+// Payload decodes a Base message's raw JSON payload into the concrete type for the message Type
+// This function is exported so that other encoders that carry the payload as JSON can use it
+func Payload(t messages.Type, data json.RawMessage) (p any, err error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	switch t {
+	case messages.OPAQUE:
+		var o opaque.Opaque
+		err = json.Unmarshal(data, &o)
+		p = o
+	case messages.JOBS:
+		var list []job
+		err = json.Unmarshal(data, &list)
+		if err != nil {
+			return
+		}
+		var js []jobs.Job
+		for _, j := range list {
+			var jp any
+			jp, err = jobPayload(j.Type, j.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("job %s: %s", j.ID, err)
+			}
+			js = append(js, jobs.Job{AgentID: j.AgentID, ID: j.ID, Token: j.Token, Type: j.Type, Payload: jp})
+		}
+		p = js
+	case messages.KEYEXCHANGE:
+		// The Agent only receives KEYEXCHANGE responses
+		var r rsa.Response
+		err = json.Unmarshal(data, &r)
+		p = r
+	case chunk.CHUNK:
+		var ch chunk.Chunk
+		err = json.Unmarshal(data, &ch)
+		p = ch
+	case psk.PSK:
+		var r psk.Rotation
+		err = json.Unmarshal(data, &r)
+		p = r
+	case prekey.PREKEY:
+		var k prekey.Key
+		err = json.Unmarshal(data, &k)
+		p = k
+	case reload.CONFIG:
+		var c reload.Config
+		err = json.Unmarshal(data, &c)
+		p = c
+	default:
+		err = json.Unmarshal(data, &p)
+	}
+	return
+}
+
+// jobPayload decodes a job's raw JSON payload into the concrete type for the job Type
+func jobPayload(t jobs.Type, data json.RawMessage) (p any, err error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	switch t {
+	case jobs.CMD, jobs.CONTROL, jobs.NATIVE, jobs.MODULE:
+		var cmd jobs.Command
+		err = json.Unmarshal(data, &cmd)
+		p = cmd
+	case jobs.SHELLCODE:
+		var sc jobs.Shellcode
+		err = json.Unmarshal(data, &sc)
+		p = sc
+	case jobs.FILETRANSFER:
+		var ft jobs.FileTransfer
+		err = json.Unmarshal(data, &ft)
+		p = ft
+	case jobs.SOCKS:
+		var s jobs.Socks
+		err = json.Unmarshal(data, &s)
+		p = s
+	case jobs.RESULT:
+		var r jobs.Results
+		err = json.Unmarshal(data, &r)
+		p = r
+	case jobs.AGENTINFO:
+		var info messages.AgentInfo
+		err = json.Unmarshal(data, &info)
+		p = info
+	default:
+		err = json.Unmarshal(data, &p)
+	}
+	return
+}
+
+// String converts the JSON encode/decode constant to a string
+func (c *Coder) String() string {
+	switch c.concrete {
+	case BASE:
+		return "json-base"
+	case DELEGATE:
+		return "json-delegate"
+	default:
+		return fmt.Sprintf("unknown json transform %d", c.concrete)
+	}
+}
@@ -0,0 +1,285 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package msgpack encodes/decodes Agent messages as MessagePack, a compact, schema-less alternative to gob for
+// constrained transports where every byte of overhead matters
+package msgpack
+
+import (
+	// Standard
+	"bytes"
+	"fmt"
+
+	// 3rd Party
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+	"github.com/Ne0nd0g/merlin-message/jobs"
+	"github.com/Ne0nd0g/merlin-message/opaque"
+	"github.com/Ne0nd0g/merlin-message/rsa"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/prekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/chunk"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/psk"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/reload"
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+)
+
+const (
+	BASE     = 0
+	DELEGATE = 1
+)
+
+// tag is the struct tag used for MessagePack map keys so that they match the JSON encoding of the same messages
+const tag = "json"
+
+// Coder is the structure that implements the Transformer interface for MessagePack encoding/decoding
+type Coder struct {
+	concrete int
+}
+
+// base mirrors messages.Base but holds the Payload as raw MessagePack until the message Type is known
+type base struct {
+	ID        uuid.UUID           `json:"id"`
+	Type      messages.Type       `json:"type"`
+	Payload   msgpack.RawMessage  `json:"payload,omitempty"`
+	Padding   string              `json:"padding"`
+	Token     string              `json:"token,omitempty"`
+	Delegates []messages.Delegate `json:"delegate,omitempty"`
+}
+
+// job mirrors jobs.Job but holds the Payload as raw MessagePack until the job Type is known
+type job struct {
+	AgentID uuid.UUID
+	ID      string
+	Token   uuid.UUID
+	Type    jobs.Type
+	Payload msgpack.RawMessage
+}
+
+func init() {
+	transformer.Register("msgpack", func() transformer.Transformer { return NewEncoder(BASE) })
+	transformer.Register("msgpack-base", func() transformer.Transformer { return NewEncoder(BASE) })
+}
+
+// NewEncoder is a factory that returns a structure that implements the Transformer interface
+func NewEncoder(concrete int) *Coder {
+	return &Coder{concrete: concrete}
+}
+
+// Construct takes in data, MessagePack encodes it, and returns the encoded data as bytes
+func (c *Coder) Construct(data any, key []byte) ([]byte, error) {
+	return c.Encode(data)
+}
+
+// Deconstruct takes in bytes and MessagePack decodes it to its original type
+func (c *Coder) Deconstruct(data, key []byte) (any, error) {
+	return c.Decode(data)
+}
+
+// Encode takes in data, MessagePack encodes it, and returns the encoded data as bytes
+// This function is exported so that it can be called directly outside the Transformer interface
+func (c *Coder) Encode(e any) ([]byte, error) {
+	switch c.concrete {
+	case BASE:
+		data, ok := e.(messages.Base)
+		if !ok {
+			return nil, fmt.Errorf("transformers/encoders/msgpack.Encode(): expected messages.Base but received %T", e)
+		}
+		encoded, err := marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/msgpack.Encode(): error MessagePack encoding messages.Base: %s", err)
+		}
+		return encoded, nil
+	case DELEGATE:
+		data, ok := e.(messages.Delegate)
+		if !ok {
+			return nil, fmt.Errorf("transformers/encoders/msgpack.Encode(): expected messages.Delegate but received %T", e)
+		}
+		encoded, err := marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/msgpack.Encode(): error MessagePack encoding messages.Delegate: %s", err)
+		}
+		return encoded, nil
+	default:
+		return nil, fmt.Errorf("transformers/encoders/msgpack.Encode(): unhandled concrete type %d", c.concrete)
+	}
+}
+
+// Decode takes in bytes and MessagePack decodes it to its original type.
+// The Base message's Payload, and each job's Payload, are decoded into the concrete type their Type field identifies
+// This function is exported so that it can be called directly outside the Transformer interface
+func (c *Coder) Decode(data []byte) (any, error) {
+	switch c.concrete {
+	case BASE:
+		var b base
+		err := unmarshal(data, &b)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/msgpack.Decode(): error MessagePack decoding messages.Base: %s", err)
+		}
+		msg := messages.Base{
+			ID:        b.ID,
+			Type:      b.Type,
+			Padding:   b.Padding,
+			Token:     b.Token,
+			Delegates: b.Delegates,
+		}
+		msg.Payload, err = payload(b.Type, b.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/msgpack.Decode(): error MessagePack decoding the %s message payload: %s", b.Type, err)
+		}
+		return msg, nil
+	case DELEGATE:
+		var d messages.Delegate
+		err := unmarshal(data, &d)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/msgpack.Decode(): error MessagePack decoding messages.Delegate: %s", err)
+		}
+		return d, nil
+	default:
+		return nil, fmt.Errorf("transformers/encoders/msgpack.Decode(): unhandled concrete type %d", c.concrete)
+	}
+}
+
+// marshal MessagePack encodes the value using the smallest integer encoding and JSON field names as map keys
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag(tag)
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshal MessagePack decodes the data into v using JSON field names as map keys
+func unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag(tag)
+	return dec.Decode(v)
+}
+
+// payload decodes a Base message's raw MessagePack payload into the concrete type for the message Type
+func payload(t messages.Type, data msgpack.RawMessage) (p any, err error) {
+	if len(data) == 0 || (len(data) == 1 && data[0] == msgpcode.Nil) {
+		return nil, nil
+	}
+	switch t {
+	case messages.OPAQUE:
+		var o opaque.Opaque
+		err = unmarshal(data, &o)
+		p = o
+	case messages.JOBS:
+		var list []job
+		err = unmarshal(data, &list)
+		if err != nil {
+			return
+		}
+		var js []jobs.Job
+		for _, j := range list {
+			var jp any
+			jp, err = jobPayload(j.Type, j.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("job %s: %s", j.ID, err)
+			}
+			js = append(js, jobs.Job{AgentID: j.AgentID, ID: j.ID, Token: j.Token, Type: j.Type, Payload: jp})
+		}
+		p = js
+	case messages.KEYEXCHANGE:
+		// The Agent only receives KEYEXCHANGE responses
+		var r rsa.Response
+		err = unmarshal(data, &r)
+		p = r
+	case chunk.CHUNK:
+		var ch chunk.Chunk
+		err = unmarshal(data, &ch)
+		p = ch
+	case psk.PSK:
+		var r psk.Rotation
+		err = unmarshal(data, &r)
+		p = r
+	case prekey.PREKEY:
+		var k prekey.Key
+		err = unmarshal(data, &k)
+		p = k
+	case reload.CONFIG:
+		var c reload.Config
+		err = unmarshal(data, &c)
+		p = c
+	default:
+		err = unmarshal(data, &p)
+	}
+	return
+}
+
+// jobPayload decodes a job's raw MessagePack payload into the concrete type for the job Type
+func jobPayload(t jobs.Type, data msgpack.RawMessage) (p any, err error) {
+	if len(data) == 0 || (len(data) == 1 && data[0] == msgpcode.Nil) {
+		return nil, nil
+	}
+	switch t {
+	case jobs.CMD, jobs.CONTROL, jobs.NATIVE, jobs.MODULE:
+		var cmd jobs.Command
+		err = unmarshal(data, &cmd)
+		p = cmd
+	case jobs.SHELLCODE:
+		var sc jobs.Shellcode
+		err = unmarshal(data, &sc)
+		p = sc
+	case jobs.FILETRANSFER:
+		var ft jobs.FileTransfer
+		err = unmarshal(data, &ft)
+		p = ft
+	case jobs.SOCKS:
+		var s jobs.Socks
+		err = unmarshal(data, &s)
+		p = s
+	case jobs.RESULT:
+		var r jobs.Results
+		err = unmarshal(data, &r)
+		p = r
+	case jobs.AGENTINFO:
+		var info messages.AgentInfo
+		err = unmarshal(data, &info)
+		p = info
+	default:
+		err = unmarshal(data, &p)
+	}
+	return
+}
+
+// String converts the MessagePack encode/decode constant to a string
+func (c *Coder) String() string {
+	switch c.concrete {
+	case BASE:
+		return "msgpack-base"
+	case DELEGATE:
+		return "msgpack-delegate"
+	default:
+		return fmt.Sprintf("unknown msgpack transform %d", c.concrete)
+	}
+}
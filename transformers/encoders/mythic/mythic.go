@@ -27,11 +27,18 @@ package mythic
 import (
 	"encoding/base64"
 	"fmt"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
 )
 
 type Coder struct {
 }
 
+func init() {
+	transformer.Register("mythic", func() transformer.Transformer { return NewEncoder() })
+}
+
 // NewEncoder is a factory that returns a structure that implements the Transformer interface
 func NewEncoder() *Coder {
 	return &Coder{}
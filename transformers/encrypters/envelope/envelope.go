@@ -0,0 +1,334 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package envelope encrypts Agent messages with a random per-message key that is encrypted to the server's embedded
+// X25519 or RSA public key, so that recovering the PSK from an Agent binary doesn't decrypt recorded pre-authentication
+// traffic; only the server's private key does. The transform key, the PSK or the session secret, is authenticated as
+// additional data.
+//
+// A message to the server is:
+//
+//	X25519: [0x01][32-byte ephemeral public key][12-byte nonce][wrapped key][12-byte nonce][AES-256-GCM ciphertext]
+//	RSA:    [0x02][2-byte wrapped key length][RSA-OAEP SHA256 wrapped key][12-byte nonce][AES-256-GCM ciphertext]
+//
+// The X25519 wrapped key is the per-message key encrypted with AES-256-GCM under an HKDF-SHA256 key derived from the
+// shared secret, the ephemeral public key, and the server's public key. The server replies with:
+//
+//	[16-byte key ID][12-byte nonce][AES-256-GCM ciphertext]
+//
+// where the key ID is the first 16 bytes of the SHA256 hash of the request's header, everything before the nonce of its
+// ciphertext, and the reply is encrypted with an HKDF-SHA256 key derived from that request's per-message key. The Agent remembers the keys
+// of its most recent messages so the server can reply to any of them
+package envelope
+
+import (
+	// Standard
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	// X Packages
+	"golang.org/x/crypto/hkdf"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+)
+
+const (
+	// X25519 identifies a message whose key is wrapped to an X25519 public key
+	X25519 byte = 1
+	// RSA identifies a message whose key is wrapped to an RSA public key with OAEP
+	RSA byte = 2
+	// keySize is the size of the per-message AES-256 key
+	keySize = 32
+	// idSize is the size of the key ID a reply starts with
+	idSize = 16
+	// maxKeys is the number of per-message keys remembered for replies
+	maxKeys = 256
+)
+
+// info labels the HKDF derived keys
+var (
+	wrapInfo  = []byte("merlin envelope wrap")
+	replyInfo = []byte("merlin envelope reply")
+)
+
+// server is the configured public key; both are nil when the envelope is disabled
+var server = struct {
+	x25519 *ecdh.PublicKey
+	rsa    *rsa.PublicKey
+	sync.RWMutex
+}{}
+
+// keys are the per-message keys of the most recent messages, by key ID, that replies are decrypted with
+var keys = struct {
+	byID  map[[idSize]byte][]byte
+	order [][idSize]byte
+	sync.Mutex
+}{byID: make(map[[idSize]byte][]byte)}
+
+// SetKey parses and sets the server's public key: a base64 or hex encoded 32-byte X25519 key, or a PEM, base64, or hex
+// encoded PKIX or PKCS #1 RSA key of at least 2048 bits. An empty string removes the key
+func SetKey(value string) error {
+	value = strings.TrimSpace(value)
+	var der []byte
+	switch {
+	case value == "":
+	case strings.HasPrefix(value, "-----BEGIN"):
+		block, _ := pem.Decode([]byte(value))
+		if block == nil {
+			return fmt.Errorf("transformers/encrypters/envelope.SetKey(): there was an error decoding the PEM public key")
+		}
+		der = block.Bytes
+	default:
+		var err error
+		der, err = base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			der, err = hex.DecodeString(value)
+			if err != nil {
+				return fmt.Errorf("transformers/encrypters/envelope.SetKey(): the public key must be PEM, base64, or hex encoded")
+			}
+		}
+	}
+
+	var x *ecdh.PublicKey
+	var r *rsa.PublicKey
+	var err error
+	switch {
+	case value == "":
+	case len(der) == 32:
+		x, err = ecdh.X25519().NewPublicKey(der)
+	default:
+		var public any
+		public, err = x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			public, err = x509.ParsePKCS1PublicKey(der)
+		}
+		if err != nil {
+			return fmt.Errorf("transformers/encrypters/envelope.SetKey(): there was an error parsing the public key: %s", err)
+		}
+		switch k := public.(type) {
+		case *ecdh.PublicKey:
+			x = k
+			if k.Curve() != ecdh.X25519() {
+				err = fmt.Errorf("only X25519 elliptic curve keys are supported")
+			}
+		case *rsa.PublicKey:
+			r = k
+			if k.Size() < 256 {
+				err = fmt.Errorf("the RSA key must be at least 2048 bits but is %d", k.N.BitLen())
+			}
+		default:
+			err = fmt.Errorf("unsupported public key type %T", public)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("transformers/encrypters/envelope.SetKey(): %s", err)
+	}
+	server.Lock()
+	server.x25519, server.rsa = x, r
+	server.Unlock()
+	return nil
+}
+
+// String returns a description of the server's public key
+func String() string {
+	server.RLock()
+	defer server.RUnlock()
+	switch {
+	case server.x25519 != nil:
+		return fmt.Sprintf("X25519 %s", base64.StdEncoding.EncodeToString(server.x25519.Bytes()))
+	case server.rsa != nil:
+		return fmt.Sprintf("RSA %d-bit", server.rsa.N.BitLen())
+	default:
+		return "disabled"
+	}
+}
+
+// Encrypter is the structure that implements the Transformer interface for envelope encryption
+type Encrypter struct {
+}
+
+func init() {
+	transformer.Register("envelope", func() transformer.Transformer { return NewEncrypter() })
+}
+
+// NewEncrypter is a factory to return a structure that implements the Transformer interface
+func NewEncrypter() *Encrypter {
+	return &Encrypter{}
+}
+
+// Construct encrypts the data with a random per-message key and wraps that key to the server's public key
+func (e *Encrypter) Construct(data any, key []byte) ([]byte, error) {
+	plaintext, ok := data.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("transformers/encrypters/envelope unhandled data type for Construct(): %T", data)
+	}
+	server.RLock()
+	x, r := server.x25519, server.rsa
+	server.RUnlock()
+
+	messageKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, messageKey); err != nil {
+		return nil, fmt.Errorf("transformers/encrypters/envelope.Construct(): there was an error generating the message key: %s", err)
+	}
+
+	var header []byte
+	switch {
+	case x != nil:
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encrypters/envelope.Construct(): there was an error generating an ephemeral key: %s", err)
+		}
+		shared, err := ephemeral.ECDH(x)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encrypters/envelope.Construct(): %s", err)
+		}
+		header = append([]byte{X25519}, ephemeral.PublicKey().Bytes()...)
+		wrapKey, err := derive(shared, append(ephemeral.PublicKey().Bytes(), x.Bytes()...), wrapInfo)
+		if err != nil {
+			return nil, err
+		}
+		header, err = seal(header, wrapKey, messageKey, nil)
+		if err != nil {
+			return nil, err
+		}
+	case r != nil:
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, r, messageKey, nil)
+		if err != nil {
+			return nil, fmt.Errorf("transformers/encrypters/envelope.Construct(): there was an error wrapping the message key: %s", err)
+		}
+		header = []byte{RSA, 0, 0}
+		binary.BigEndian.PutUint16(header[1:], uint16(len(wrapped)))
+		header = append(header, wrapped...)
+	default:
+		return nil, fmt.Errorf("transformers/encrypters/envelope.Construct(): the server's public key is not configured")
+	}
+	remember(id(header), messageKey)
+	return seal(header, messageKey, plaintext, key)
+}
+
+// Deconstruct decrypts a reply with the per-message key of the message it identifies
+func (e *Encrypter) Deconstruct(data, key []byte) (any, error) {
+	if len(data) < idSize {
+		return nil, fmt.Errorf("transformers/encrypters/envelope.Deconstruct(): the %d byte message is too short", len(data))
+	}
+	var keyID [idSize]byte
+	copy(keyID[:], data)
+	keys.Lock()
+	messageKey, ok := keys.byID[keyID]
+	keys.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("transformers/encrypters/envelope.Deconstruct(): the reply's message key %x is unknown", keyID)
+	}
+	replyKey, err := derive(messageKey, keyID[:], replyInfo)
+	if err != nil {
+		return nil, err
+	}
+	return open(replyKey, data[idSize:], key)
+}
+
+// String returns the name of the encrypter
+func (e *Encrypter) String() string {
+	return "envelope"
+}
+
+// id returns the key ID of a message's header
+func id(header []byte) (keyID [idSize]byte) {
+	hash := sha256.Sum256(header)
+	copy(keyID[:], hash[:])
+	return
+}
+
+// remember stores the per-message key, forgetting the oldest key when there are too many
+func remember(keyID [idSize]byte, messageKey []byte) {
+	keys.Lock()
+	defer keys.Unlock()
+	keys.byID[keyID] = messageKey
+	keys.order = append(keys.order, keyID)
+	if len(keys.order) > maxKeys {
+		delete(keys.byID, keys.order[0])
+		keys.order = keys.order[1:]
+	}
+}
+
+// derive returns a 32-byte HKDF-SHA256 key
+func derive(secret, salt, info []byte) ([]byte, error) {
+	derived := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), derived); err != nil {
+		return nil, fmt.Errorf("transformers/encrypters/envelope.derive(): %s", err)
+	}
+	return derived, nil
+}
+
+// seal appends a random nonce and the AES-256-GCM encrypted plaintext to dst
+func seal(dst, key, plaintext, additional []byte) ([]byte, error) {
+	aead, err := gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("transformers/encrypters/envelope.seal(): there was an error generating a nonce: %s", err)
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, additional), nil
+}
+
+// open decrypts the nonce prefixed AES-256-GCM ciphertext
+func open(key, data, additional []byte) ([]byte, error) {
+	aead, err := gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("transformers/encrypters/envelope.open(): the %d byte ciphertext is too short", len(data))
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, fmt.Errorf("transformers/encrypters/envelope.open(): %s", err)
+	}
+	return plaintext, nil
+}
+
+// gcm returns an AES-256-GCM AEAD for the key
+func gcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("transformers/encrypters/envelope.gcm(): %s", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("transformers/encrypters/envelope.gcm(): %s", err)
+	}
+	return aead, nil
+}
@@ -0,0 +1,172 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package hmac appends/validates an HMAC-SHA256 over Agent messages so that tampered or corrupted messages are rejected
+// before they reach a decoder that could panic or return garbage. Place it first in the list of transforms so that
+// it covers the fully transformed message (e.g., hmac,aes,gob-base)
+package hmac
+
+import (
+	// Standard
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
+)
+
+// info separates the HMAC key from the secret it is derived from so the same key is never used for encryption
+const info = "merlin message integrity"
+
+// Encrypter is the structure that implements the Transformer interface for HMAC-SHA256 message integrity
+type Encrypter struct {
+}
+
+func init() {
+	transformer.Register("hmac", func() transformer.Transformer { return NewEncrypter() })
+}
+
+// NewEncrypter is a factory to return a structure that implements the Transformer interface
+func NewEncrypter() *Encrypter {
+	return &Encrypter{}
+}
+
+// Construct takes in data, appends an HMAC-SHA256 over it with a key derived from the provided key, and returns the
+// data and HMAC as bytes
+func (e *Encrypter) Construct(data any, key []byte) (retData []byte, err error) {
+	var in []byte
+	switch data.(type) {
+	case []uint8:
+		in = data.([]byte)
+	case string:
+		in = []byte(data.(string))
+	default:
+		return nil, fmt.Errorf("transformers/encrypters/hmac.Construct(): unhandled data type for Construct(): %T", data)
+	}
+	retData = make([]byte, len(in), len(in)+sha256.Size)
+	copy(retData, in)
+	return append(retData, sum(in, key)...), nil
+}
+
+// Deconstruct takes in data with an HMAC-SHA256 appended to it, validates the HMAC with a key derived from the provided
+// key, and returns the data without the HMAC as bytes. An error is returned if the HMAC does not match
+func (e *Encrypter) Deconstruct(data, key []byte) (any, error) {
+	if len(data) < sha256.Size {
+		return nil, fmt.Errorf("transformers/encrypters/hmac.Deconstruct(): the %d byte message is too short to hold an HMAC", len(data))
+	}
+	message, mac := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !hmac.Equal(mac, sum(message, key)) {
+		return nil, fmt.Errorf("transformers/encrypters/hmac.Deconstruct(): the message HMAC is invalid")
+	}
+	return message, nil
+}
+
+// sum returns the HMAC-SHA256 of the data keyed with the HMAC key derived from the secret
+func sum(data, secret []byte) []byte {
+	mac := newMAC(secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// newMAC returns an HMAC-SHA256 keyed with the HMAC key derived from the secret
+func newMAC(secret []byte) hash.Hash {
+	derive := hmac.New(sha256.New, secret)
+	derive.Write([]byte(info))
+	return hmac.New(sha256.New, derive.Sum(nil))
+}
+
+// ConstructStream returns a writer that passes the data written to it through to w and appends the HMAC-SHA256 over
+// all of it on Close, producing the same output as Construct
+func (e *Encrypter) ConstructStream(w io.Writer, key []byte) (io.WriteCloser, error) {
+	return &streamWriter{w: w, mac: newMAC(key)}, nil
+}
+
+// DeconstructStream returns a reader that passes the data read from r through without the trailing HMAC-SHA256 and
+// validates the HMAC when r is exhausted. The reader returns an error instead of io.EOF if the HMAC does not match, so
+// the consumer must not act on the data until the stream has been read to the end
+func (e *Encrypter) DeconstructStream(r io.Reader, key []byte) (io.Reader, error) {
+	return &streamReader{r: r, mac: newMAC(key)}, nil
+}
+
+// streamWriter writes data through while computing its HMAC
+type streamWriter struct {
+	w      io.Writer
+	mac    hash.Hash
+	closed bool
+}
+
+// Write writes p to the underlying writer and adds it to the HMAC
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.mac.Write(p)
+	return s.w.Write(p)
+}
+
+// Close writes the HMAC; it doesn't close the underlying writer
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	_, err := s.w.Write(s.mac.Sum(nil))
+	return err
+}
+
+// streamReader reads data through while computing its HMAC, holding back the last bytes read because they might be the
+// HMAC itself
+type streamReader struct {
+	r    io.Reader
+	mac  hash.Hash
+	tail []byte
+	err  error
+}
+
+// Read returns the data read from the underlying reader except for the trailing HMAC, which is validated at the end
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.tail) <= sha256.Size && s.err == nil {
+		buffer := make([]byte, len(p)+sha256.Size)
+		var n int
+		n, s.err = s.r.Read(buffer)
+		s.tail = append(s.tail, buffer[:n]...)
+	}
+	if len(s.tail) > sha256.Size {
+		n := copy(p, s.tail[:len(s.tail)-sha256.Size])
+		s.mac.Write(p[:n])
+		s.tail = s.tail[n:]
+		return n, nil
+	}
+	if s.err != io.EOF {
+		return 0, s.err
+	}
+	if len(s.tail) < sha256.Size {
+		return 0, fmt.Errorf("transformers/encrypters/hmac.DeconstructStream(): the stream is too short to hold an HMAC")
+	}
+	if !hmac.Equal(s.tail, s.mac.Sum(nil)) {
+		return 0, fmt.Errorf("transformers/encrypters/hmac.DeconstructStream(): the stream HMAC is invalid")
+	}
+	return 0, io.EOF
+}
+
+// String returns the name of the encrypter
+func (e *Encrypter) String() string {
+	return "hmac"
+}
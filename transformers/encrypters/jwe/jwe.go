@@ -27,11 +27,18 @@ import (
 
 	// 3rd Party
 	"github.com/go-jose/go-jose/v3"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
 )
 
 type Encrypter struct {
 }
 
+func init() {
+	transformer.Register("jwe", func() transformer.Transformer { return NewEncrypter() })
+}
+
 // NewEncrypter is a factory to return a structure that implements the Transformer interface
 func NewEncrypter() *Encrypter {
 	return &Encrypter{}
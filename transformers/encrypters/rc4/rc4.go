@@ -22,14 +22,23 @@ along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
 package rc4
 
 import (
+	"crypto/cipher"
 	"crypto/rc4" // #nosec G503 intentionally using rc4 knowing it is insecure
 	"fmt"
+	"io"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
 )
 
 // Encrypter is the structure that implements the Transformer interface for RC4 encrypting/decryption
 type Encrypter struct {
 }
 
+func init() {
+	transformer.Register("rc4", func() transformer.Transformer { return NewEncrypter() })
+}
+
 // NewEncrypter is a factory to return a structure that implements the Transformer interface
 func NewEncrypter() *Encrypter {
 	return &Encrypter{}
@@ -60,6 +69,35 @@ func xor(data, key []byte) (retData []byte, err error) {
 	return
 }
 
+// ConstructStream returns a writer that RC4 encrypts the data written to it and writes it to w
+func (e *Encrypter) ConstructStream(w io.Writer, key []byte) (io.WriteCloser, error) {
+	c, err := rc4.NewCipher(key) // #nosec G401 intentionally using rc4 knowing it is insecure
+	if err != nil {
+		return nil, fmt.Errorf("pkg/transformer/encrypters/rc4.ConstructStream(): there was an error getting an RC4 cipher: %s", err)
+	}
+	// cipher.StreamWriter closes the underlying writer if it is a Closer, which is left to the caller
+	return &streamWriter{StreamWriter: cipher.StreamWriter{S: c, W: w}}, nil
+}
+
+// DeconstructStream returns a reader that RC4 decrypts the data read from r
+func (e *Encrypter) DeconstructStream(r io.Reader, key []byte) (io.Reader, error) {
+	c, err := rc4.NewCipher(key) // #nosec G401 intentionally using rc4 knowing it is insecure
+	if err != nil {
+		return nil, fmt.Errorf("pkg/transformer/encrypters/rc4.DeconstructStream(): there was an error getting an RC4 cipher: %s", err)
+	}
+	return cipher.StreamReader{S: c, R: r}, nil
+}
+
+// streamWriter is a cipher.StreamWriter that doesn't close the underlying writer
+type streamWriter struct {
+	cipher.StreamWriter
+}
+
+// Close doesn't close the underlying writer because RC4 doesn't buffer data
+func (s *streamWriter) Close() error {
+	return nil
+}
+
 // String returns the name of the encrypter
 func (e *Encrypter) String() string {
 	return "rc4"
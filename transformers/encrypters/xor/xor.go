@@ -22,41 +22,139 @@ along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
 package xor
 
 import (
+	// Standard
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"io"
+
+	// Internal
+	transformer "github.com/Ne0nd0g/merlin-agent/v2/transformers"
 )
 
+// label separates the XOR keystream from other keys derived from the same session secret
+const label = "merlin-xor-keystream"
+
 // Encrypter is the structure that implements the Transformer interface for XOR encrypting/decryption
 type Encrypter struct {
 }
 
+func init() {
+	transformer.Register("xor", func() transformer.Transformer { return NewEncrypter() })
+}
+
 // NewEncrypter is a factory to return a structure that implements the Transformer interface
 func NewEncrypter() *Encrypter {
 	return &Encrypter{}
 }
 
-// Construct takes data in data, AES encrypts it with the provided key, and returns that data as bytes
+// Construct takes data in data, XOR encrypts it with a keystream derived from the provided key, and returns that data as bytes
 func (e *Encrypter) Construct(data any, key []byte) ([]byte, error) {
 	switch data.(type) {
 	case []uint8:
 		return xor(data.([]byte), key)
 	default:
-		return nil, fmt.Errorf("pkg/encrypters/aes unhandled data type for Construct(): %T", data)
+		return nil, fmt.Errorf("transformers/encrypters/xor unhandled data type for Construct(): %T", data)
 	}
 }
 
-// Deconstruct takes in AES encrypted data, decrypts it with the provided key, and returns the data as bytes
+// Deconstruct takes in XOR encrypted data, decrypts it with a keystream derived from the provided key, and returns the data as bytes
 func (e *Encrypter) Deconstruct(data, key []byte) (any, error) {
 	return xor(data, key)
 }
 
+// xor combines the data with a keystream derived from the session secret so the XOR key is never the secret itself,
+// which is also used by other transforms like AES, and doesn't repeat every len(key) bytes
 func xor(data, key []byte) (retData []byte, err error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("transformers/encrypters/xor: an empty key was provided")
+	}
+	stream := keystream(key, len(data))
 	retData = make([]byte, len(data))
 	for k, v := range data {
-		retData[k] = v ^ key[k%len(key)]
+		retData[k] = v ^ stream[k]
 	}
 	return
 }
 
+// keystream expands the key into length bytes by hashing the key, a label, and a block counter with SHA256
+func keystream(key []byte, length int) []byte {
+	stream := make([]byte, 0, length+sha256.Size)
+	for i := uint64(0); len(stream) < length; i++ {
+		stream = block(stream, key, i)
+	}
+	return stream[:length]
+}
+
+// block appends the keystream block for the counter to stream
+func block(stream, key []byte, counter uint64) []byte {
+	c := make([]byte, 8)
+	binary.BigEndian.PutUint64(c, counter)
+	h := sha256.New()
+	h.Write(key)
+	h.Write([]byte(label))
+	h.Write(c)
+	return h.Sum(stream)
+}
+
+// ConstructStream returns a writer that XOR encrypts the data written to it and writes it to w. The output is the same
+// as Construct's for the same data
+func (e *Encrypter) ConstructStream(w io.Writer, key []byte) (io.WriteCloser, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("transformers/encrypters/xor: an empty key was provided")
+	}
+	return &stream{key: key, w: w}, nil
+}
+
+// DeconstructStream returns a reader that XOR decrypts the data read from r
+func (e *Encrypter) DeconstructStream(r io.Reader, key []byte) (io.Reader, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("transformers/encrypters/xor: an empty key was provided")
+	}
+	return &stream{key: key, r: r}, nil
+}
+
+// stream applies the keystream to data as it is written or read, tracking its position in the keystream
+type stream struct {
+	key     []byte
+	offset  uint64
+	current []byte
+	w       io.Writer
+	r       io.Reader
+}
+
+// apply XORs data in place with the keystream at the stream's offset
+func (s *stream) apply(data []byte) {
+	for k := range data {
+		i := s.offset % sha256.Size
+		if i == 0 {
+			s.current = block(s.current[:0], s.key, s.offset/sha256.Size)
+		}
+		data[k] ^= s.current[i]
+		s.offset++
+	}
+}
+
+// Write encrypts p and writes it to the underlying writer
+func (s *stream) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	s.apply(data)
+	return s.w.Write(data)
+}
+
+// Read reads from the underlying reader and decrypts the data into p
+func (s *stream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.apply(p[:n])
+	return n, err
+}
+
+// Close doesn't close the underlying writer because XOR doesn't buffer data
+func (s *stream) Close() error {
+	return nil
+}
+
 // String returns the name of the encrypter
 func (e *Encrypter) String() string {
 	return "xor"
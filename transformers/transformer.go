@@ -21,9 +21,177 @@ along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
 // Package transformer provides encoding and encryption methods to transform Agent messages
 package transformer
 
+import (
+	// Standard
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MaxChains is the largest number of transformer chains an Agent can pick from because a chain is identified by one byte
+const MaxChains = 256
+
 // Transformer is an interface used to transform Agent message data from one format to the next through encoding or encryption
 type Transformer interface {
 	Construct(data any, key []byte) ([]byte, error)
 	Deconstruct(data, key []byte) (any, error)
 	String() string
 }
+
+// Streamer is implemented by transforms that can also transform data as a stream so that large payloads, such as file
+// transfers, are never held in memory all at once
+type Streamer interface {
+	// ConstructStream returns a writer that transforms the data written to it and writes the result to w.
+	// Closing the writer flushes any buffered data but does not close w
+	ConstructStream(w io.Writer, key []byte) (io.WriteCloser, error)
+	// DeconstructStream returns a reader that reverses the transform on the data read from r
+	DeconstructStream(r io.Reader, key []byte) (io.Reader, error)
+}
+
+// Factory returns a new Transformer
+type Factory func() Transformer
+
+// factories is a map of transform names to the factories that create them
+var factories = make(map[string]Factory)
+
+// factoriesLock protects the factories map from concurrent access
+var factoriesLock sync.RWMutex
+
+// Register makes a transform available to every client by name, case-insensitive. Transform packages register
+// themselves when they are imported; registering a name again replaces its factory
+func Register(name string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	factories[strings.ToLower(strings.TrimSpace(name))] = factory
+}
+
+// New returns a new Transformer from the factory registered with the name
+func New(name string) (Transformer, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	factoriesLock.RLock()
+	factory, ok := factories[name]
+	factoriesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("transformers.New(): unhandled transform: %s", name)
+	}
+	return factory(), nil
+}
+
+// Registered returns the sorted names of all the registered transforms
+func Registered() (names []string) {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Pick randomly selects one of the Agent's transformer chains for the next message and returns its index along with the
+// prefix that must be placed in front of the constructed message to identify the chain to the server.
+// The prefix is a random byte whose value modulo the number of chains is the index, so it changes with every message.
+// No prefix is returned when there is only one chain so the message is unchanged.
+func Pick(chains int) (index int, prefix []byte) {
+	if chains < 2 {
+		return 0, nil
+	}
+	// #nosec G404 -- Random number does not impact security
+	index = rand.Intn(chains)
+	// #nosec G404 -- Random number does not impact security
+	prefix = []byte{byte(rand.Intn(MaxChains/chains)*chains + index)}
+	return
+}
+
+// Identify returns the index of the transformer chain identified by the prefix byte at the start of the data along with
+// the data that follows it. When there is only one chain, there is no prefix and the data is returned unchanged
+func Identify(data []byte, chains int) (index int, payload []byte, err error) {
+	if chains < 2 {
+		return 0, data, nil
+	}
+	if len(data) < 1 {
+		return 0, nil, fmt.Errorf("transformers.Identify(): the data is missing the byte that identifies its transformer chain")
+	}
+	return int(data[0]) % chains, data[1:], nil
+}
+
+// StreamChain returns the transforms in a chain that are applied to a stream. The last transform in a chain, which
+// encodes the Base message, is skipped if it can't stream, but every other transform must be able to
+func StreamChain(chain []Transformer) ([]Streamer, error) {
+	var streamers []Streamer
+	for i, t := range chain {
+		streamer, ok := t.(Streamer)
+		if !ok {
+			if i == len(chain)-1 {
+				break
+			}
+			return nil, fmt.Errorf("transformers.StreamChain(): the %s transform does not support streaming", t)
+		}
+		streamers = append(streamers, streamer)
+	}
+	return streamers, nil
+}
+
+// ConstructStream returns a writer that runs the data written to it through the chain of stream transforms, from last
+// to first like a message, and writes the result to w. Closing the writer flushes every transform but does not close w
+func ConstructStream(w io.Writer, chain []Streamer, key []byte) (io.WriteCloser, error) {
+	stream := &streamWriter{Writer: w}
+	// The first transform in the chain is the last one to handle the data, so it is the one that writes to w
+	for _, streamer := range chain {
+		writer, err := streamer.ConstructStream(stream.Writer, key)
+		if err != nil {
+			return nil, fmt.Errorf("transformers.ConstructStream(): %s", err)
+		}
+		stream.Writer = writer
+		stream.closers = append(stream.closers, writer)
+	}
+	return stream, nil
+}
+
+// DeconstructStream returns a reader that runs the data read from r through the chain of stream transforms, from first
+// to last like a message, to reverse them
+func DeconstructStream(r io.Reader, chain []Streamer, key []byte) (io.Reader, error) {
+	for _, streamer := range chain {
+		var err error
+		r, err = streamer.DeconstructStream(r, key)
+		if err != nil {
+			return nil, fmt.Errorf("transformers.DeconstructStream(): %s", err)
+		}
+	}
+	return r, nil
+}
+
+// streamWriter writes to the last transform in a chain and closes every transform, innermost first, when it is closed
+type streamWriter struct {
+	io.Writer
+	closers []io.Closer
+}
+
+// Close flushes and closes every transform in the chain, starting with the one that receives the data first
+func (s *streamWriter) Close() error {
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		if err := s.closers[i].Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NopCloser returns a WriteCloser with a no-op Close method wrapping the provided Writer, for transforms that don't
+// buffer any data
+func NopCloser(w io.Writer) io.WriteCloser {
+	return nopCloser{Writer: w}
+}
+
+// nopCloser is an io.Writer with a Close method that does nothing
+type nopCloser struct {
+	io.Writer
+}
+
+// Close does nothing
+func (nopCloser) Close() error {
+	return nil
+}
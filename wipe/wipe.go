@@ -0,0 +1,171 @@
+/*
+Merlin is a post-exploitation command and control framework.
+
+This file is part of Merlin.
+Copyright (C) 2023 Russel Van Tuyl
+
+Merlin is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+any later version.
+
+Merlin is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package wipe removes the artifacts the Agent left on the host's disk when the Agent's kill date, or its maximum
+// number of failed check ins, is reached, or when the Agent is instructed to remove itself
+package wipe
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// enabled determines if the artifacts are removed when the kill date is reached
+var enabled bool
+
+// retry determines if the artifacts are removed when the maximum number of failed check ins is reached
+var retry bool
+
+// files are the paths of the files the Agent wrote to the host
+var files []string
+
+// exit is true when the Agent removed itself and exits after its next successful check in
+var exit bool
+
+// exits is signaled when the Agent removed itself so that it checks in right away instead of sleeping
+var exits = make(chan struct{}, 1)
+
+// mu protects the settings and files from concurrent access
+var mu sync.Mutex
+
+// SetEnabled parses and sets whether the Agent removes its artifacts when the kill date is reached (e.g., true)
+func SetEnabled(value string) error {
+	if value == "" {
+		value = "false"
+	}
+	e, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("wipe.SetEnabled(): there was an error parsing %s as a boolean: %s", value, err)
+	}
+	mu.Lock()
+	enabled = e
+	mu.Unlock()
+	return nil
+}
+
+// Enabled returns true if the Agent removes its artifacts when the kill date is reached
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// SetRetry parses and sets whether the Agent removes its artifacts when the maximum number of failed check ins is
+// reached (e.g., true)
+func SetRetry(value string) error {
+	if value == "" {
+		value = "false"
+	}
+	r, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("wipe.SetRetry(): there was an error parsing %s as a boolean: %s", value, err)
+	}
+	mu.Lock()
+	retry = r
+	mu.Unlock()
+	return nil
+}
+
+// Retry returns true if the Agent removes its artifacts when the maximum number of failed check ins is reached
+func Retry() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return retry
+}
+
+// Track adds the path of a file the Agent wrote to the host to the artifacts that are removed
+func Track(path string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, file := range files {
+		if file == path {
+			return
+		}
+	}
+	files = append(files, path)
+}
+
+// Wipe removes the files the Agent wrote to the host and then the Agent's own executable. Files that were already
+// removed are skipped; an error is returned for each artifact that could not be removed
+func Wipe() (errs []error) {
+	mu.Lock()
+	paths := files
+	files = nil
+	mu.Unlock()
+
+	for _, path := range paths {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("wipe.Wipe(): there was an error removing %s: %s", path, err))
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("wipe.Wipe(): there was an error getting the agent's executable: %s", err))
+		return
+	}
+	err = removeExecutable(executable)
+	if err != nil && !os.IsNotExist(err) {
+		errs = append(errs, fmt.Errorf("wipe.Wipe(): there was an error removing %s: %s", executable, err))
+	}
+	return
+}
+
+// Persistence removes the persistence artifacts the Agent was installed with: files and, on Windows, registry values
+// (e.g., HKCU\Software\Microsoft\Windows\CurrentVersion\Run\Updater). Artifacts that were already removed are
+// skipped; the removed artifacts are returned along with an error for each one that could not be removed
+func Persistence(artifacts []string) (removed []string, errs []error) {
+	for _, artifact := range artifacts {
+		err := removePersistence(artifact)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("wipe.Persistence(): there was an error removing %s: %s", artifact, err))
+			continue
+		}
+		removed = append(removed, artifact)
+	}
+	return
+}
+
+// Exit schedules the Agent to exit after its next successful check in so that it can report that it removed itself
+func Exit() {
+	mu.Lock()
+	exit = true
+	mu.Unlock()
+	select {
+	case exits <- struct{}{}:
+	default:
+	}
+}
+
+// Exiting returns true if the Agent removed itself and exits after its next successful check in
+func Exiting() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return exit
+}
+
+// Exits returns a channel that is signaled when the Agent removed itself
+func Exits() <-chan struct{} {
+	return exits
+}